@@ -0,0 +1,371 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+type aclCmd struct {
+	clientConfig
+
+	action  string
+	filter  sarama.AclFilter
+	yes     bool
+	verbose bool
+	pretty  bool
+
+	admin sarama.ClusterAdmin
+}
+
+type aclArgs struct {
+	clientArgs
+
+	resourceType string
+	resourceName string
+	pattern      string
+	principal    string
+	host         string
+	operation    string
+	permission   string
+	yes          bool
+	verbose      bool
+	pretty       bool
+}
+
+// aclBinding is the JSON form of an ACL binding: who may or may not do
+// what on which resources.
+type aclBinding struct {
+	ResourceType string `json:"resourceType"`
+	ResourceName string `json:"resourceName"`
+	PatternType  string `json:"patternType"`
+	Principal    string `json:"principal"`
+	Host         string `json:"host"`
+	Operation    string `json:"operation"`
+	Permission   string `json:"permission"`
+}
+
+func newACLBinding(r sarama.Resource, a sarama.Acl) aclBinding {
+	return aclBinding{
+		ResourceType: r.ResourceType.String(),
+		ResourceName: r.ResourceName,
+		PatternType:  r.ResourcePatternType.String(),
+		Principal:    a.Principal,
+		Host:         a.Host,
+		Operation:    a.Operation.String(),
+		Permission:   a.PermissionType.String(),
+	}
+}
+
+func sortACLBindings(bs []aclBinding) {
+	sort.Slice(bs, func(i, j int) bool {
+		a, b := bs[i], bs[j]
+		switch {
+		case a.ResourceType != b.ResourceType:
+			return a.ResourceType < b.ResourceType
+		case a.ResourceName != b.ResourceName:
+			return a.ResourceName < b.ResourceName
+		case a.Principal != b.Principal:
+			return a.Principal < b.Principal
+		case a.Operation != b.Operation:
+			return a.Operation < b.Operation
+		}
+		return a.Permission < b.Permission
+	})
+}
+
+// normalizeACLName lets names be given like Kafka's tooling does, e.g.
+// transactional-id or TRANSACTIONAL_ID for sarama's transactionalid.
+func normalizeACLName(s string) []byte {
+	return []byte(strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(s)))
+}
+
+// parseACLFilter parses the flags that select ACL bindings. Empty flags
+// match any value.
+func parseACLFilter(args aclArgs) (sarama.AclFilter, error) {
+	f := sarama.AclFilter{
+		ResourceType:              sarama.AclResourceAny,
+		ResourcePatternTypeFilter: sarama.AclPatternAny,
+		Operation:                 sarama.AclOperationAny,
+		PermissionType:            sarama.AclPermissionAny,
+	}
+
+	if args.resourceType != "" {
+		if err := f.ResourceType.UnmarshalText(normalizeACLName(args.resourceType)); err != nil {
+			return f, fmt.Errorf("invalid resource-type %#v, expected topic, group, cluster, transactional-id or delegation-token", args.resourceType)
+		}
+	}
+	if args.pattern != "" {
+		if err := f.ResourcePatternTypeFilter.UnmarshalText(normalizeACLName(args.pattern)); err != nil {
+			return f, fmt.Errorf("invalid pattern %#v, expected literal, prefixed, match or any", args.pattern)
+		}
+	}
+	if args.operation != "" {
+		if err := f.Operation.UnmarshalText(normalizeACLName(args.operation)); err != nil {
+			return f, fmt.Errorf("invalid operation %#v, expected e.g. read, write, describe or all", args.operation)
+		}
+	}
+	if args.permission != "" {
+		if err := f.PermissionType.UnmarshalText(normalizeACLName(args.permission)); err != nil {
+			return f, fmt.Errorf("invalid permission %#v, expected allow or deny", args.permission)
+		}
+	}
+
+	if args.resourceName != "" {
+		f.ResourceName = &args.resourceName
+	}
+	if args.principal != "" {
+		f.Principal = &args.principal
+	}
+	if args.host != "" {
+		f.Host = &args.host
+	}
+	return f, nil
+}
+
+// aclToCreate turns the filter into the single binding to create. Unlike
+// filters, bindings need concrete values, the pattern defaults to literal,
+// the host to * and the permission to allow.
+func aclToCreate(f sarama.AclFilter) (sarama.Resource, sarama.Acl, error) {
+	r := sarama.Resource{ResourceType: f.ResourceType, ResourcePatternType: f.ResourcePatternTypeFilter}
+	a := sarama.Acl{Host: "*", Operation: f.Operation, PermissionType: f.PermissionType}
+
+	switch {
+	case r.ResourceType == sarama.AclResourceAny:
+		return r, a, fmt.Errorf("-resource-type is required")
+	case f.Principal == nil:
+		return r, a, fmt.Errorf("-acl-principal is required, e.g. User:alice")
+	case a.Operation == sarama.AclOperationAny:
+		return r, a, fmt.Errorf("-operation is required")
+	case r.ResourcePatternType == sarama.AclPatternMatch:
+		return r, a, fmt.Errorf("-pattern match only selects bindings, create literal or prefixed ones")
+	}
+
+	if f.ResourceName != nil {
+		r.ResourceName = *f.ResourceName
+	} else if r.ResourceType == sarama.AclResourceCluster {
+		r.ResourceName = "kafka-cluster"
+	} else {
+		return r, a, fmt.Errorf("-resource-name is required")
+	}
+	if r.ResourcePatternType == sarama.AclPatternAny {
+		r.ResourcePatternType = sarama.AclPatternLiteral
+	}
+	a.Principal = *f.Principal
+	if f.Host != nil {
+		a.Host = *f.Host
+	}
+	if a.PermissionType == sarama.AclPermissionAny {
+		a.PermissionType = sarama.AclPermissionAllow
+	}
+	return r, a, nil
+}
+
+func (cmd *aclCmd) run(as []string) {
+	var err error
+
+	if len(as) == 0 || strings.HasPrefix(as[0], "-") {
+		if len(as) > 0 && (as[0] == "-h" || as[0] == "-help" || as[0] == "--help") {
+			cmd.parseFlags(as)
+		}
+		cmd.failStartup("need to supply one of the actions list, create or delete.")
+	}
+	cmd.action = as[0]
+	cmd.parseArgs(as[1:])
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.admin, err = sarama.NewClusterAdmin(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create cluster admin err=%v", err)
+	}
+	defer logClose("cluster admin", cmd.admin)
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	switch cmd.action {
+	case "list":
+		cmd.runList(out)
+	case "create":
+		cmd.runCreate(out)
+	case "delete":
+		cmd.runDelete(out)
+	}
+}
+
+func (cmd *aclCmd) list() []aclBinding {
+	resources, err := cmd.admin.ListAcls(cmd.filter)
+	if err != nil {
+		failf("failed to list ACLs err=%v", err)
+	}
+
+	bindings := []aclBinding{}
+	for _, r := range resources {
+		for _, a := range r.Acls {
+			bindings = append(bindings, newACLBinding(r.Resource, *a))
+		}
+	}
+	sortACLBindings(bindings)
+	return bindings
+}
+
+func (cmd *aclCmd) runList(out chan printContext) {
+	for _, b := range cmd.list() {
+		ctx := printContext{output: b, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+func (cmd *aclCmd) runCreate(out chan printContext) {
+	r, a, _ := aclToCreate(cmd.filter)
+	if err := cmd.admin.CreateACL(r, a); err != nil {
+		failf("failed to create ACL err=%v", err)
+	}
+
+	ctx := printContext{output: newACLBinding(r, a), done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+// runDelete lists the bindings that the filter matches and deletes them
+// after confirmation, as a filter without names and principal matches all
+// bindings of the cluster.
+func (cmd *aclCmd) runDelete(out chan printContext) {
+	matches := cmd.list()
+	if len(matches) == 0 {
+		fmt.Fprintf(os.Stderr, "found no ACLs matching the filter.\n")
+		return
+	}
+	if !cmd.yes {
+		for _, b := range matches {
+			fmt.Fprintf(os.Stderr, "%v %v on %v %v (%v) for %v from %v\n", b.Permission, b.Operation, b.ResourceType, b.ResourceName, b.PatternType, b.Principal, b.Host)
+		}
+		if !confirmf("delete these %v ACLs?", len(matches)) {
+			fmt.Fprintf(os.Stderr, "leaving ACLs unchanged.\n")
+			return
+		}
+	}
+
+	deleted, err := cmd.admin.DeleteACL(cmd.filter, false)
+	if err != nil {
+		failf("failed to delete ACLs err=%v", err)
+	}
+
+	bindings := []aclBinding{}
+	for _, m := range deleted {
+		if m.Err != sarama.ErrNoError {
+			fmt.Fprintf(os.Stderr, "failed to delete ACL for %v on %v err=%v\n", m.Principal, m.ResourceName, m.Err)
+			continue
+		}
+		bindings = append(bindings, newACLBinding(m.Resource, m.Acl))
+	}
+	sortACLBindings(bindings)
+	for _, b := range bindings {
+		ctx := printContext{output: b, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+func (cmd *aclCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("acl")
+}
+
+func (cmd *aclCmd) failStartup(msg string) {
+	failUsage(msg, "kt acl")
+}
+
+func (cmd *aclCmd) parseArgs(as []string) {
+	var err error
+	args := cmd.parseFlags(as)
+
+	switch cmd.action {
+	case "list", "create", "delete":
+	default:
+		cmd.failStartup(fmt.Sprintf("unsupported action %#v, only list, create and delete are supported.", cmd.action))
+	}
+
+	if cmd.filter, err = parseACLFilter(args); err != nil {
+		cmd.failStartup(err.Error())
+	}
+	if cmd.action == "create" {
+		if _, _, err = aclToCreate(cmd.filter); err != nil {
+			cmd.failStartup(err.Error())
+		}
+	}
+
+	cmd.yes = args.yes
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	if !cmd.version.IsAtLeast(sarama.V2_0_0_0) {
+		// prefixed patterns need version 1 of the ACL requests.
+		cmd.version = sarama.V2_0_0_0
+	}
+}
+
+func (cmd *aclCmd) parseFlags(as []string) aclArgs {
+	var args aclArgs
+	flags := flag.NewFlagSet("acl", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.resourceType, "resource-type", "", "Type of the resource (topic|group|cluster|transactional-id|delegation-token), defaults to any for list and delete.")
+	flags.StringVar(&args.resourceName, "resource-name", "", "Name of the resource, e.g. a topic name, defaults to any for list and delete and to kafka-cluster for cluster resources.")
+	flags.StringVar(&args.pattern, "pattern", "", "How -resource-name matches resources (literal|prefixed), list and delete also accept match for all bindings that apply to the name and any; defaults to literal for create and any otherwise.")
+	flags.StringVar(&args.principal, "acl-principal", "", "Principal of the binding, e.g. User:alice, defaults to any for list and delete.")
+	flags.StringVar(&args.host, "acl-host", "", "Host the principal connects from, defaults to * for create and any otherwise.")
+	flags.StringVar(&args.operation, "operation", "", "Operation (all|read|write|create|delete|alter|describe|clusteraction|describeconfigs|alterconfigs|idempotentwrite), defaults to any for list and delete.")
+	flags.StringVar(&args.permission, "permission", "", "Permission (allow|deny), defaults to allow for create and any otherwise.")
+	flags.BoolVar(&args.yes, "yes", false, "Skip the confirmation prompt of delete.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of acl: kt acl list|create|delete [flags]")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, aclDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var aclDocString = `
+The value for -brokers can also be set via environment variables KT_BROKERS.
+The value supplied on the command line wins over the environment variable value.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+kt acl manages the ACL bindings of the cluster. list prints the bindings that
+match the flags as JSON objects, flags that aren't given match anything:
+
+kt acl list -resource-type topic -resource-name orders
+
+create adds the binding described by the flags and prints it. It requires
+-resource-type, -resource-name (except for the cluster), -acl-principal and
+-operation:
+
+kt acl create -resource-type topic -resource-name orders- -pattern prefixed -acl-principal User:alice -operation read
+
+delete removes all bindings that match the flags, like list would print them.
+It asks for confirmation first unless -yes is given, and prints the deleted
+bindings:
+
+kt acl delete -acl-principal User:alice -resource-name orders
+
+ACLs require Kafka 2.0 or later for prefixed patterns, kt uses at least
+version 2.0.0 of the protocol.`
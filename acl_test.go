@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseACLFilter(t *testing.T) {
+	f, err := parseACLFilter(aclArgs{})
+	require.NoError(t, err)
+	require.Equal(t, sarama.AclFilter{
+		ResourceType:              sarama.AclResourceAny,
+		ResourcePatternTypeFilter: sarama.AclPatternAny,
+		Operation:                 sarama.AclOperationAny,
+		PermissionType:            sarama.AclPermissionAny,
+	}, f)
+
+	f, err = parseACLFilter(aclArgs{resourceType: "transactional-id", resourceName: "tx-", pattern: "PREFIXED", principal: "User:alice", operation: "describe_configs", permission: "deny"})
+	require.NoError(t, err)
+	require.Equal(t, sarama.AclResourceTransactionalID, f.ResourceType)
+	require.Equal(t, sarama.AclPatternPrefixed, f.ResourcePatternTypeFilter)
+	require.Equal(t, sarama.AclOperationDescribeConfigs, f.Operation)
+	require.Equal(t, sarama.AclPermissionDeny, f.PermissionType)
+	require.Equal(t, "tx-", *f.ResourceName)
+	require.Equal(t, "User:alice", *f.Principal)
+	require.Nil(t, f.Host)
+
+	_, err = parseACLFilter(aclArgs{operation: "publish"})
+	require.Error(t, err)
+}
+
+func TestACLToCreate(t *testing.T) {
+	f, err := parseACLFilter(aclArgs{resourceType: "topic", resourceName: "orders", principal: "User:alice", operation: "read"})
+	require.NoError(t, err)
+	r, a, err := aclToCreate(f)
+	require.NoError(t, err)
+	require.Equal(t, sarama.Resource{ResourceType: sarama.AclResourceTopic, ResourceName: "orders", ResourcePatternType: sarama.AclPatternLiteral}, r)
+	require.Equal(t, sarama.Acl{Principal: "User:alice", Host: "*", Operation: sarama.AclOperationRead, PermissionType: sarama.AclPermissionAllow}, a)
+
+	f, err = parseACLFilter(aclArgs{resourceType: "cluster", principal: "User:alice", operation: "alter"})
+	require.NoError(t, err)
+	r, _, err = aclToCreate(f)
+	require.NoError(t, err)
+	require.Equal(t, "kafka-cluster", r.ResourceName)
+
+	for _, args := range []aclArgs{
+		{resourceName: "orders", principal: "User:alice", operation: "read"},
+		{resourceType: "topic", principal: "User:alice", operation: "read"},
+		{resourceType: "topic", resourceName: "orders", operation: "read"},
+		{resourceType: "topic", resourceName: "orders", principal: "User:alice"},
+		{resourceType: "topic", resourceName: "orders", principal: "User:alice", operation: "read", pattern: "match"},
+	} {
+		f, err = parseACLFilter(args)
+		require.NoError(t, err)
+		_, _, err = aclToCreate(f)
+		require.Error(t, err, "%#v", args)
+	}
+}
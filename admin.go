@@ -22,11 +22,25 @@ type adminCmd struct {
 	tlsCA      string
 	tlsCert    string
 	tlsCertKey string
+	sasl       saslArgs
+	pretty     bool
 
 	createTopic  string
 	topicDetail  *sarama.TopicDetail
 	validateOnly bool
 	deleteTopic  string
+	versions     bool
+	quorum       bool
+	cluster      bool
+
+	describeConfig   string
+	alterConfig      string
+	setConfig        map[string]string
+	resetConfig      []string
+	createPartitions string
+	partitions       int32
+	deleteRecords    string
+	deleteOffsets    map[int32]int64
 
 	admin sarama.ClusterAdmin
 }
@@ -39,19 +53,48 @@ type adminArgs struct {
 	tlsCA      string
 	tlsCert    string
 	tlsCertKey string
+	sasl       saslArgs
+	pretty     bool
 
 	createTopic     string
 	topicDetailPath string
 	validateOnly    bool
 	deleteTopic     string
+	cluster         bool
+
+	describeConfig   string
+	alterConfig      string
+	setConfig        string
+	resetConfig      string
+	createPartitions string
+	partitions       int
+	deleteRecords    string
+	offsets          string
+}
+
+func (cmd *adminCmd) failStartup(msg string) {
+	failUsage(msg, "kt admin")
 }
 
 func (cmd *adminCmd) parseArgs(as []string) {
+	if len(as) > 0 && !strings.HasPrefix(as[0], "-") {
+		switch as[0] {
+		case "versions":
+			cmd.versions = true
+		case "quorum":
+			cmd.quorum = true
+		default:
+			cmd.failStartup(fmt.Sprintf("unsupported sub-command %#v, only versions and quorum are supported.", as[0]))
+		}
+		as = as[1:]
+	}
+
 	var (
 		args = cmd.parseFlags(as)
 	)
 
 	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
 	cmd.version = kafkaVersion(args.version)
 
 	cmd.timeout = parseTimeout(os.Getenv("KT_ADMIN_TIMEOUT"))
@@ -62,6 +105,7 @@ func (cmd *adminCmd) parseArgs(as []string) {
 	cmd.tlsCA = args.tlsCA
 	cmd.tlsCert = args.tlsCert
 	cmd.tlsCertKey = args.tlsCertKey
+	cmd.sasl = readSASLEnv(args.sasl)
 
 	envBrokers := os.Getenv("KT_BROKERS")
 	if args.brokers == "" {
@@ -81,6 +125,38 @@ func (cmd *adminCmd) parseArgs(as []string) {
 	cmd.validateOnly = args.validateOnly
 	cmd.createTopic = args.createTopic
 	cmd.deleteTopic = args.deleteTopic
+	cmd.cluster = args.cluster
+
+	cmd.describeConfig = args.describeConfig
+	cmd.alterConfig = args.alterConfig
+	cmd.createPartitions = args.createPartitions
+	cmd.deleteRecords = args.deleteRecords
+
+	var err error
+	if cmd.alterConfig != "" {
+		if args.setConfig == "" && args.resetConfig == "" {
+			failf("-alterconfig requires -config or -resetconfig")
+		}
+		if cmd.setConfig, err = parseConfigEntries(args.setConfig); err != nil {
+			failf("failed to parse -config err=%v", err)
+		}
+		if cmd.resetConfig, err = splitConfigList(args.resetConfig); err != nil {
+			failf("failed to parse -resetconfig err=%v", err)
+		}
+	}
+
+	if cmd.createPartitions != "" {
+		if args.partitions <= 0 {
+			failf("-createpartitions requires -partitions with the new total number of partitions")
+		}
+		cmd.partitions = int32(args.partitions)
+	}
+
+	if cmd.deleteRecords != "" {
+		if cmd.deleteOffsets, err = parsePartitionOffsets(args.offsets); err != nil {
+			failf("failed to parse -offsets err=%v", err)
+		}
+	}
 
 	if cmd.createTopic != "" {
 		buf, err := ioutil.ReadFile(args.topicDetailPath)
@@ -109,13 +185,26 @@ func (cmd *adminCmd) run(args []string) {
 		failf("failed to create cluster admin err=%v", err)
 	}
 
-	if cmd.createTopic != "" {
+	if cmd.versions {
+		cmd.runVersions()
+	} else if cmd.quorum {
+		cmd.runQuorum()
+	} else if cmd.createTopic != "" {
 		cmd.runCreateTopic()
-
 	} else if cmd.deleteTopic != "" {
 		cmd.runDeleteTopic()
+	} else if cmd.describeConfig != "" {
+		cmd.runDescribeConfig()
+	} else if cmd.alterConfig != "" {
+		cmd.runAlterConfig()
+	} else if cmd.createPartitions != "" {
+		cmd.runCreatePartitions()
+	} else if cmd.deleteRecords != "" {
+		cmd.runDeleteRecords()
+	} else if cmd.cluster {
+		cmd.runCluster()
 	} else {
-		failf("need to supply at least one sub-command of: createtopic, deletetopic")
+		failf("need to supply the sub-command versions or quorum or at least one of: createtopic, deletetopic, describeconfig, alterconfig, createpartitions, deleterecords, cluster")
 	}
 }
 
@@ -150,7 +239,7 @@ func (cmd *adminCmd) saramaConfig() *sarama.Config {
 		cfg.Admin.Timeout = *cmd.timeout
 	}
 
-	tlsConfig, err := setupCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
+	tlsConfig, err := setupBrokerCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
 	if err != nil {
 		failf("failed to setup certificates err=%v", err)
 	}
@@ -158,6 +247,9 @@ func (cmd *adminCmd) saramaConfig() *sarama.Config {
 		cfg.Net.TLS.Enable = true
 		cfg.Net.TLS.Config = tlsConfig
 	}
+	if err = setupSASL(cfg, cmd.sasl); err != nil {
+		failf("failed to setup SASL err=%v", err)
+	}
 
 	return cfg
 }
@@ -172,38 +264,96 @@ func (cmd *adminCmd) parseFlags(as []string) adminArgs {
 	flags.StringVar(&args.tlsCA, "tlsca", "", "Path to the TLS certificate authority file")
 	flags.StringVar(&args.tlsCert, "tlscert", "", "Path to the TLS client certificate file")
 	flags.StringVar(&args.tlsCertKey, "tlscertkey", "", "Path to the TLS client certificate key file")
+	flags.StringVar(&args.sasl.mechanism, "sasl-mechanism", "", "SASL mechanism to authenticate with (PLAIN|SCRAM-SHA-256|SCRAM-SHA-512|GSSAPI), defaults to none.")
+	flags.StringVar(&args.sasl.user, "sasl-user", "", "SASL user name")
+	flags.StringVar(&args.sasl.password, "sasl-password", "", "SASL password")
+	flags.StringVar(&args.sasl.krb5Config, "krb5-config", "", "Path to the Kerberos config file for GSSAPI (defaults to $KRB5_CONFIG or /etc/krb5.conf).")
+	flags.StringVar(&args.sasl.keytab, "keytab", "", "Path to the Kerberos keytab file for GSSAPI, otherwise -sasl-password is used.")
+	flags.StringVar(&args.sasl.principal, "principal", "", "Kerberos principal to authenticate as for GSSAPI, e.g. kt@EXAMPLE.COM (defaults to -sasl-user).")
+	flags.StringVar(&args.sasl.realm, "realm", "", "Kerberos realm for GSSAPI (defaults to the realm of -principal).")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
 
 	flags.StringVar(&args.createTopic, "createtopic", "", "Name of the topic that should be created.")
 	flags.StringVar(&args.topicDetailPath, "topicdetail", "", "Path to JSON encoded topic detail. cf sarama.TopicDetail")
-	flags.BoolVar(&args.validateOnly, "validateonly", false, "Flag to indicate whether operation should only validate input (supported for createtopic).")
+	flags.BoolVar(&args.validateOnly, "validateonly", false, "Flag to indicate whether operation should only validate input (supported for createtopic, alterconfig and createpartitions).")
 
 	flags.StringVar(&args.deleteTopic, "deletetopic", "", "Name of the topic that should be deleted.")
 
+	flags.StringVar(&args.describeConfig, "describeconfig", "", "Name of the topic whose config should be printed.")
+	flags.StringVar(&args.alterConfig, "alterconfig", "", "Name of the topic whose config should be altered.")
+	flags.StringVar(&args.setConfig, "config", "", "Comma separated config entries to set for alterconfig, e.g. retention.ms=1000,cleanup.policy=[compact,delete].")
+	flags.StringVar(&args.resetConfig, "resetconfig", "", "Comma separated config names to reset to their defaults for alterconfig.")
+	flags.StringVar(&args.createPartitions, "createpartitions", "", "Name of the topic that partitions should be added to.")
+	flags.IntVar(&args.partitions, "partitions", 0, "New total number of partitions for createpartitions.")
+	flags.StringVar(&args.deleteRecords, "deleterecords", "", "Name of the topic that records should be deleted from.")
+	flags.StringVar(&args.offsets, "offsets", "", "Comma separated partition=offset pairs for deleterecords, records before each offset are deleted.")
+
+	flags.BoolVar(&args.cluster, "cluster", false, "Print the cluster ID, controller and per broker its rack, release and log dir sizes.")
+
 	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage of admin:")
+		fmt.Fprintln(os.Stderr, "Usage of admin: kt admin [versions|quorum] [flags]")
 		flags.PrintDefaults()
 		fmt.Fprintln(os.Stderr, adminDocString)
 	}
 
 	err := flags.Parse(as)
 	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
-		os.Exit(0)
+		exit(0)
 	} else if err != nil {
-		os.Exit(2)
+		exit(exitUsage)
 	}
 
+	jsonErrors = !args.pretty
 	return args
 }
 
 var adminDocString = `
 The value for -brokers can also be set via environment variables KT_BROKERS.
 The value supplied on the command line wins over the environment variable value.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
 
-If both -createtopic and deletetopic are supplied, -createtopic wins.
+Only one operation runs per invocation. The sub-commands versions and quorum
+run on their own, otherwise if several flags are supplied they win in the
+order -createtopic, -deletetopic, -describeconfig, -alterconfig,
+-createpartitions, -deleterecords and -cluster.
 
 The topic details should be passed via a JSON file that represents a sarama.TopicDetail struct.
 cf https://godoc.org/github.com/Shopify/sarama#TopicDetail
 
 A simple way to pass a JSON file is to use a tool like https://github.com/fgeller/jsonify and shell's process substition:
 
-kt admin -createtopic morenews -topicdetail <(jsonify =NumPartitions 1 =ReplicationFactor 1)`
+kt admin -createtopic morenews -topicdetail <(jsonify =NumPartitions 1 =ReplicationFactor 1)
+
+-describeconfig prints a topic's config entries as JSON. -alterconfig sets the
+entries given via -config and resets those named in -resetconfig, other
+entries are left as they are. It requires Kafka 2.3 or later:
+
+kt admin -alterconfig news -config retention.ms=86400000,cleanup.policy=[compact,delete]
+
+-createpartitions increases the number of partitions of a topic to -partitions.
+-deleterecords deletes the records before the given offset per partition,
+an offset of -1 deletes all records up to the high water mark:
+
+kt admin -deleterecords news -offsets 0=1000,1=-1
+
+versions prints a JSON object per broker with the API version ranges it
+supports and the Kafka release inferred from them. The inferred release is
+a lower bound, e.g. 2.8+ for a broker running 2.8 or 2.9. If the brokers disagree, e.g.
+during a rolling upgrade, a warning on stderr lists the release per broker:
+
+kt admin versions
+
+quorum prints the status of the KRaft controller quorum like
+kafka-metadata-quorum.sh: the active controller as leaderId, and per voter
+and observer how far its log lags behind the high water mark. It requires a
+KRaft cluster running Kafka 3.3 or later and doesn't support SASL yet:
+
+kt admin quorum
+
+-cluster prints the shape of the cluster: its ID, the controller and per
+broker the address, rack, the Kafka release inferred from its API versions
+and the size of its log dirs in bytes. Describing log dirs requires Kafka 1.0
+or later, errors of a broker are reported in its "error" field:
+
+kt admin -cluster`
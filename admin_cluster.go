@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+type clusterInfo struct {
+	ClusterID    string       `json:"clusterId,omitempty"`
+	ControllerID int32        `json:"controllerId"`
+	Brokers      []brokerInfo `json:"brokers"`
+}
+
+// brokerInfo describes a broker of -cluster. Release is inferred from the
+// broker's API versions like for kt admin versions and LogDirBytes is the
+// size of all partitions in its log dirs.
+type brokerInfo struct {
+	ID          int32        `json:"id"`
+	Addr        string       `json:"addr"`
+	Rack        string       `json:"rack,omitempty"`
+	Controller  bool         `json:"controller"`
+	Release     string       `json:"release,omitempty"`
+	LogDirBytes *int64       `json:"logDirBytes,omitempty"`
+	LogDirs     []logDirInfo `json:"logDirs,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+type logDirInfo struct {
+	Path       string `json:"path"`
+	Bytes      int64  `json:"bytes"`
+	Partitions int    `json:"partitions"`
+	Error      string `json:"error,omitempty"`
+}
+
+// summarizeLogDirs totals the partition sizes per log dir, sorted by path.
+func summarizeLogDirs(dirs []sarama.DescribeLogDirsResponseDirMetadata) ([]logDirInfo, int64) {
+	var (
+		infos = []logDirInfo{}
+		total int64
+	)
+	for _, d := range dirs {
+		info := logDirInfo{Path: d.Path}
+		if d.ErrorCode != sarama.ErrNoError {
+			info.Error = d.ErrorCode.Error()
+		}
+		for _, t := range d.Topics {
+			for _, p := range t.Partitions {
+				info.Bytes += p.Size
+				info.Partitions++
+			}
+		}
+		total += info.Bytes
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+	return infos, total
+}
+
+func (cmd *adminCmd) readBrokerInfo(b *sarama.Broker, controllerID int32) brokerInfo {
+	info := brokerInfo{ID: b.ID(), Addr: b.Addr(), Rack: b.Rack(), Controller: b.ID() == controllerID}
+
+	bv := cmd.readBrokerVersions(b)
+	if bv.Error != "" {
+		info.Error = bv.Error
+		return info
+	}
+	info.Release = bv.Release
+
+	dirs, err := cmd.admin.DescribeLogDirs([]int32{b.ID()})
+	if err != nil {
+		info.Error = fmt.Sprintf("failed to describe log dirs, brokers before 1.0 don't support this, err=%v", err)
+		return info
+	}
+	var total int64
+	info.LogDirs, total = summarizeLogDirs(dirs[b.ID()])
+	info.LogDirBytes = &total
+	return info
+}
+
+func (cmd *adminCmd) runCluster() {
+	controller, err := cmd.admin.Controller()
+	if err != nil {
+		failf("failed to find controller err=%v", err)
+	}
+	md, err := controller.GetMetadata(sarama.NewMetadataRequest(cmd.version, nil))
+	if err != nil {
+		failf("failed to read cluster metadata err=%v", err)
+	}
+	sort.Slice(md.Brokers, func(i, j int) bool { return md.Brokers[i].ID() < md.Brokers[j].ID() })
+
+	info := clusterInfo{ControllerID: md.ControllerID, Brokers: []brokerInfo{}}
+	if md.ClusterID != nil {
+		info.ClusterID = *md.ClusterID
+	}
+	for _, b := range md.Brokers {
+		info.Brokers = append(info.Brokers, cmd.readBrokerInfo(b, md.ControllerID))
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: info, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
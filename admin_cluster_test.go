@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeLogDirs(t *testing.T) {
+	dirs := []sarama.DescribeLogDirsResponseDirMetadata{
+		{
+			Path: "/var/lib/kafka/b",
+			Topics: []sarama.DescribeLogDirsResponseTopic{
+				{Topic: "news", Partitions: []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 100}, {PartitionID: 1, Size: 20}}},
+				{Topic: "orders", Partitions: []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 3}}},
+			},
+		},
+		{Path: "/var/lib/kafka/a", ErrorCode: sarama.ErrKafkaStorageError},
+	}
+
+	infos, total := summarizeLogDirs(dirs)
+	require.Equal(t, int64(123), total)
+	require.Equal(t, []logDirInfo{
+		{Path: "/var/lib/kafka/a", Error: sarama.ErrKafkaStorageError.Error()},
+		{Path: "/var/lib/kafka/b", Bytes: 123, Partitions: 3},
+	}, infos)
+
+	infos, total = summarizeLogDirs(nil)
+	require.Equal(t, int64(0), total)
+	require.Equal(t, []logDirInfo{}, infos)
+}
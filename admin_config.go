@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+type topicConfigEntry struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Default   bool   `json:"default"`
+	ReadOnly  bool   `json:"readOnly"`
+	Sensitive bool   `json:"sensitive"`
+}
+
+type topicConfig struct {
+	Topic   string             `json:"topic"`
+	Configs []topicConfigEntry `json:"configs"`
+}
+
+// splitConfigList splits a comma separated list like the ones passed to
+// kafka-configs.sh, where values that contain commas are wrapped in square
+// brackets, e.g. cleanup.policy=[compact,delete],retention.ms=1000.
+func splitConfigList(str string) ([]string, error) {
+	var (
+		parts []string
+		start int
+		depth int
+	)
+
+	for i, c := range str {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("unbalanced ] at position %v", i)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, str[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("missing closing ]")
+	}
+	parts = append(parts, str[start:])
+
+	var trimmed []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			trimmed = append(trimmed, p)
+		}
+	}
+	return trimmed, nil
+}
+
+// parseConfigEntries parses config entries to set like
+// retention.ms=1000,cleanup.policy=[compact,delete].
+func parseConfigEntries(str string) (map[string]string, error) {
+	parts, err := splitConfigList(str)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]string{}
+	for _, p := range parts {
+		i := strings.Index(p, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid config entry %#v, expected name=value", p)
+		}
+		v := p[i+1:]
+		if strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]") {
+			v = v[1 : len(v)-1]
+		}
+		entries[strings.TrimSpace(p[:i])] = v
+	}
+	return entries, nil
+}
+
+// parsePartitionOffsets parses offsets per partition like 0=100,1=200.
+func parsePartitionOffsets(str string) (map[int32]int64, error) {
+	offsets := map[int32]int64{}
+	for _, p := range strings.Split(str, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid partition offset %#v, expected partition=offset", p)
+		}
+		partition, err := strconv.ParseInt(kv[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition %#v err=%v", kv[0], err)
+		}
+		offset, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset %#v err=%v", kv[1], err)
+		}
+		offsets[int32(partition)] = offset
+	}
+
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("no partition offsets given")
+	}
+	return offsets, nil
+}
+
+func (cmd *adminCmd) runDescribeConfig() {
+	entries, err := cmd.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: cmd.describeConfig})
+	if err != nil {
+		failf("failed to describe config err=%v", err)
+	}
+
+	tc := topicConfig{Topic: cmd.describeConfig, Configs: []topicConfigEntry{}}
+	for _, e := range entries {
+		tc.Configs = append(tc.Configs, topicConfigEntry{
+			Name:      e.Name,
+			Value:     e.Value,
+			Source:    e.Source.String(),
+			Default:   e.Default,
+			ReadOnly:  e.ReadOnly,
+			Sensitive: e.Sensitive,
+		})
+	}
+	sort.Slice(tc.Configs, func(i, j int) bool { return tc.Configs[i].Name < tc.Configs[j].Name })
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: tc, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+func (cmd *adminCmd) runAlterConfig() {
+	entries := map[string]sarama.IncrementalAlterConfigsEntry{}
+	for k, v := range cmd.setConfig {
+		v := v
+		entries[k] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &v}
+	}
+	for _, k := range cmd.resetConfig {
+		entries[k] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+	}
+
+	err := cmd.admin.IncrementalAlterConfig(sarama.TopicResource, cmd.alterConfig, entries, cmd.validateOnly)
+	if err != nil {
+		failf("failed to alter config err=%v", err)
+	}
+}
+
+func (cmd *adminCmd) runCreatePartitions() {
+	err := cmd.admin.CreatePartitions(cmd.createPartitions, cmd.partitions, nil, cmd.validateOnly)
+	if err != nil {
+		failf("failed to create partitions err=%v", err)
+	}
+}
+
+func (cmd *adminCmd) runDeleteRecords() {
+	err := cmd.admin.DeleteRecords(cmd.deleteRecords, cmd.deleteOffsets)
+	if err != nil {
+		failf("failed to delete records err=%v", err)
+	}
+}
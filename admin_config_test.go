@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigEntries(t *testing.T) {
+	actual, err := parseConfigEntries("retention.ms=1000, cleanup.policy=[compact,delete],segment.jitter.ms=")
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{
+		"retention.ms":      "1000",
+		"cleanup.policy":    "compact,delete",
+		"segment.jitter.ms": "",
+	}, actual)
+
+	actual, err = parseConfigEntries("")
+	require.Nil(t, err)
+	require.Empty(t, actual)
+
+	for _, invalid := range []string{"retention.ms", "=1000", "cleanup.policy=[compact,delete", "a=b]"} {
+		_, err := parseConfigEntries(invalid)
+		require.NotNil(t, err, invalid)
+	}
+}
+
+func TestParsePartitionOffsets(t *testing.T) {
+	actual, err := parsePartitionOffsets("0=100, 1=-1")
+	require.Nil(t, err)
+	require.Equal(t, map[int32]int64{0: 100, 1: -1}, actual)
+
+	for _, invalid := range []string{"", "0", "a=1", "0=b"} {
+		_, err := parsePartitionOffsets(invalid)
+		require.NotNil(t, err, invalid)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// sarama doesn't implement DescribeQuorum, so the request is sent over a
+// connection of its own. The request and response are encoded by hand
+// following the Kafka protocol for flexible versions, with compact arrays
+// and strings and tagged fields.
+const (
+	describeQuorumKey        int16 = 55
+	describeQuorumMaxVersion int16 = 1
+	clusterMetadataTopic           = "__cluster_metadata"
+)
+
+type quorumReplica struct {
+	ID                    int32  `json:"id"`
+	LogEndOffset          int64  `json:"logEndOffset"`
+	Lag                   int64  `json:"lag"`
+	LastFetchTimestamp    *int64 `json:"lastFetchTimestamp,omitempty"`
+	LastCaughtUpTimestamp *int64 `json:"lastCaughtUpTimestamp,omitempty"`
+}
+
+type quorumStatus struct {
+	LeaderID      int32           `json:"leaderId"`
+	LeaderEpoch   int32           `json:"leaderEpoch"`
+	HighWatermark int64           `json:"highWatermark"`
+	Voters        []quorumReplica `json:"voters"`
+	Observers     []quorumReplica `json:"observers"`
+}
+
+type kafkaWriter struct{ bytes.Buffer }
+
+func (w *kafkaWriter) int16(v int16) { binary.Write(w, binary.BigEndian, v) }
+func (w *kafkaWriter) int32(v int32) { binary.Write(w, binary.BigEndian, v) }
+func (w *kafkaWriter) int64(v int64) { binary.Write(w, binary.BigEndian, v) }
+
+func (w *kafkaWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.WriteString(s)
+}
+
+func (w *kafkaWriter) compactString(s string) {
+	w.uvarint(uint64(len(s) + 1))
+	w.WriteString(s)
+}
+
+func (w *kafkaWriter) emptyTaggedFields() { w.uvarint(0) }
+
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int16() int16 { return int16(binary.BigEndian.Uint16(r.next(2))) }
+func (r *kafkaReader) int32() int32 { return int32(binary.BigEndian.Uint32(r.next(4))) }
+func (r *kafkaReader) int64() int64 { return int64(binary.BigEndian.Uint64(r.next(8))) }
+
+func (r *kafkaReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// compactLength reads the length of a compact array or string, which is
+// encoded as length+1 so that 0 can represent null.
+func (r *kafkaReader) compactLength() int {
+	n := int(r.uvarint()) - 1
+	if n > len(r.buf) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return n
+}
+
+func (r *kafkaReader) compactString() string {
+	n := r.compactLength()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(n))
+}
+
+func (r *kafkaReader) skipTaggedFields() {
+	for i := r.uvarint(); i > 0 && r.err == nil; i-- {
+		r.uvarint() // tag
+		r.next(int(r.uvarint()))
+	}
+}
+
+func encodeDescribeQuorumRequest(version int16, correlationID int32, clientID string) []byte {
+	var w kafkaWriter
+
+	// request header v2
+	w.int16(describeQuorumKey)
+	w.int16(version)
+	w.int32(correlationID)
+	w.string(clientID)
+	w.emptyTaggedFields()
+
+	// topics
+	w.uvarint(2)
+	w.compactString(clusterMetadataTopic)
+	w.uvarint(2)
+	w.int32(0) // partition
+	w.emptyTaggedFields()
+	w.emptyTaggedFields()
+	w.emptyTaggedFields()
+
+	frame := make([]byte, 4, 4+w.Len())
+	binary.BigEndian.PutUint32(frame, uint32(w.Len()))
+	return append(frame, w.Bytes()...)
+}
+
+func decodeQuorumReplicas(r *kafkaReader, version int16, highWatermark int64) []quorumReplica {
+	replicas := []quorumReplica{}
+	for i := r.compactLength(); i > 0 && r.err == nil; i-- {
+		rep := quorumReplica{ID: r.int32(), LogEndOffset: r.int64()}
+		rep.Lag = highWatermark - rep.LogEndOffset
+		if rep.Lag < 0 {
+			rep.Lag = 0
+		}
+		if version >= 1 {
+			if ts := r.int64(); ts >= 0 {
+				rep.LastFetchTimestamp = &ts
+			}
+			if ts := r.int64(); ts >= 0 {
+				rep.LastCaughtUpTimestamp = &ts
+			}
+		}
+		r.skipTaggedFields()
+		replicas = append(replicas, rep)
+	}
+	return replicas
+}
+
+// decodeDescribeQuorumResponse decodes the response without its size prefix
+// and returns the status of the metadata topic's partition.
+func decodeDescribeQuorumResponse(buf []byte, version int16, correlationID int32) (*quorumStatus, error) {
+	r := &kafkaReader{buf: buf}
+
+	// response header v1
+	if id := r.int32(); r.err == nil && id != correlationID {
+		return nil, fmt.Errorf("unexpected correlation id %v, expected %v", id, correlationID)
+	}
+	r.skipTaggedFields()
+
+	if code := sarama.KError(r.int16()); r.err == nil && code != sarama.ErrNoError {
+		return nil, code
+	}
+
+	var status *quorumStatus
+	for i := r.compactLength(); i > 0 && r.err == nil; i-- {
+		topic := r.compactString()
+		for j := r.compactLength(); j > 0 && r.err == nil; j-- {
+			partition := r.int32()
+			if code := sarama.KError(r.int16()); r.err == nil && code != sarama.ErrNoError {
+				return nil, code
+			}
+			s := &quorumStatus{LeaderID: r.int32(), LeaderEpoch: r.int32(), HighWatermark: r.int64()}
+			s.Voters = decodeQuorumReplicas(r, version, s.HighWatermark)
+			s.Observers = decodeQuorumReplicas(r, version, s.HighWatermark)
+			r.skipTaggedFields()
+			if topic == clusterMetadataTopic && partition == 0 {
+				status = s
+			}
+		}
+		r.skipTaggedFields()
+	}
+	r.skipTaggedFields()
+
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to decode DescribeQuorum response err=%v", r.err)
+	}
+	if status == nil {
+		return nil, fmt.Errorf("DescribeQuorum response is missing partition 0 of %v", clusterMetadataTopic)
+	}
+	return status, nil
+}
+
+// describeQuorumVersion returns the DescribeQuorum version to use with the
+// broker, or an error if the broker doesn't support DescribeQuorum.
+func (cmd *adminCmd) describeQuorumVersion(addr string) (int16, error) {
+	b := sarama.NewBroker(addr)
+	if err := b.Open(cmd.saramaConfig()); err != nil {
+		return 0, err
+	}
+	defer logClose(fmt.Sprintf("broker %v", addr), b)
+
+	resp, err := b.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, k := range resp.ApiKeys {
+		if k.ApiKey == describeQuorumKey {
+			if k.MaxVersion < describeQuorumMaxVersion {
+				return k.MaxVersion, nil
+			}
+			return describeQuorumMaxVersion, nil
+		}
+	}
+	return 0, fmt.Errorf("broker %v does not support DescribeQuorum, it requires a KRaft cluster running Kafka 3.3 or later", addr)
+}
+
+func (cmd *adminCmd) describeQuorum(addr string, version int16) (*quorumStatus, error) {
+	tlsConfig, err := setupBrokerCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := 3 * time.Second
+	if cmd.timeout != nil {
+		timeout = *cmd.timeout
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer logClose(fmt.Sprintf("connection to %v", addr), conn)
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	const correlationID = 1
+	if _, err = conn.Write(encodeDescribeQuorumRequest(version, correlationID, cmd.saramaConfig().ClientID)); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err = binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	return decodeDescribeQuorumResponse(buf, version, correlationID)
+}
+
+func (cmd *adminCmd) runQuorum() {
+	if cmd.sasl.mechanism != "" {
+		failf("kt admin quorum does not support SASL authentication yet")
+	}
+
+	var (
+		status *quorumStatus
+		err    error
+	)
+
+	for _, addr := range cmd.brokers {
+		var version int16
+		if version, err = cmd.describeQuorumVersion(addr); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to check API versions of %v. err=%v\n", addr, err)
+			continue
+		}
+		if status, err = cmd.describeQuorum(addr, version); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to describe quorum via %v. err=%v\n", addr, err)
+			continue
+		}
+		break
+	}
+	if status == nil {
+		failf("failed to describe quorum err=%v", err)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: status, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDescribeQuorumRequest(t *testing.T) {
+	expected := []byte{
+		0, 0, 0, 41, // size
+		0, 55, 0, 1, 0, 0, 0, 7, 0, 2, 'k', 't', 0, // header
+		2, 19, '_', '_', 'c', 'l', 'u', 's', 't', 'e', 'r', '_', 'm', 'e', 't', 'a', 'd', 'a', 't', 'a',
+		2, 0, 0, 0, 0, 0, 0, 0,
+	}
+	require.Equal(t, expected, encodeDescribeQuorumRequest(1, 7, "kt"))
+}
+
+func describeQuorumResponse(version int16, code int16, topic string) []byte {
+	var w kafkaWriter
+	w.int32(7)
+	w.emptyTaggedFields()
+	w.int16(0)
+
+	voter := func(id int32, leo int64, lastFetch int64) {
+		w.int32(id)
+		w.int64(leo)
+		if version >= 1 {
+			w.int64(lastFetch)
+			w.int64(-1)
+		}
+		w.emptyTaggedFields()
+	}
+
+	w.uvarint(2)
+	w.compactString(topic)
+	w.uvarint(2)
+	w.int32(0)
+	w.int16(code)
+	w.int32(1)   // leader
+	w.int32(4)   // epoch
+	w.int64(100) // high water mark
+	w.uvarint(3)
+	voter(1, 100, 1685622600000)
+	voter(2, 90, -1)
+	w.uvarint(2)
+	voter(4, 110, 1685622600000)
+	w.emptyTaggedFields()
+	w.emptyTaggedFields()
+	w.uvarint(1) // one unknown tagged field
+	w.uvarint(0)
+	w.uvarint(2)
+	w.Write([]byte{1, 2})
+	return w.Bytes()
+}
+
+func TestDecodeDescribeQuorumResponse(t *testing.T) {
+	ts := int64(1685622600000)
+
+	actual, err := decodeDescribeQuorumResponse(describeQuorumResponse(1, 0, clusterMetadataTopic), 1, 7)
+	require.Nil(t, err)
+	require.Equal(t, &quorumStatus{
+		LeaderID:      1,
+		LeaderEpoch:   4,
+		HighWatermark: 100,
+		Voters: []quorumReplica{
+			{ID: 1, LogEndOffset: 100, Lag: 0, LastFetchTimestamp: &ts},
+			{ID: 2, LogEndOffset: 90, Lag: 10},
+		},
+		Observers: []quorumReplica{
+			{ID: 4, LogEndOffset: 110, Lag: 0, LastFetchTimestamp: &ts},
+		},
+	}, actual)
+
+	actual, err = decodeDescribeQuorumResponse(describeQuorumResponse(0, 0, clusterMetadataTopic), 0, 7)
+	require.Nil(t, err)
+	require.Equal(t, []quorumReplica{{ID: 1, LogEndOffset: 100}, {ID: 2, LogEndOffset: 90, Lag: 10}}, actual.Voters)
+
+	_, err = decodeDescribeQuorumResponse(describeQuorumResponse(1, int16(sarama.ErrNotLeaderForPartition), clusterMetadataTopic), 1, 7)
+	require.Equal(t, sarama.ErrNotLeaderForPartition, err)
+
+	_, err = decodeDescribeQuorumResponse(describeQuorumResponse(1, 0, "other"), 1, 7)
+	require.NotNil(t, err)
+
+	_, err = decodeDescribeQuorumResponse(describeQuorumResponse(1, 0, clusterMetadataTopic), 1, 8)
+	require.NotNil(t, err)
+
+	buf := describeQuorumResponse(1, 0, clusterMetadataTopic)
+	_, err = decodeDescribeQuorumResponse(buf[:len(buf)-10], 1, 7)
+	require.NotNil(t, err)
+}
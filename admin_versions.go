@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+type apiVersionRange struct {
+	Key  int16  `json:"key"`
+	Name string `json:"name"`
+	Min  int16  `json:"min"`
+	Max  int16  `json:"max"`
+}
+
+type brokerVersions struct {
+	ID      int32             `json:"id"`
+	Addr    string            `json:"addr"`
+	Release string            `json:"release,omitempty"`
+	APIs    []apiVersionRange `json:"apis,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+var apiKeyNames = []string{
+	"Produce", "Fetch", "ListOffsets", "Metadata", "LeaderAndIsr", "StopReplica",
+	"UpdateMetadata", "ControlledShutdown", "OffsetCommit", "OffsetFetch",
+	"FindCoordinator", "JoinGroup", "Heartbeat", "LeaveGroup", "SyncGroup",
+	"DescribeGroups", "ListGroups", "SaslHandshake", "ApiVersions",
+	"CreateTopics", "DeleteTopics", "DeleteRecords", "InitProducerId",
+	"OffsetForLeaderEpoch", "AddPartitionsToTxn", "AddOffsetsToTxn", "EndTxn",
+	"WriteTxnMarkers", "TxnOffsetCommit", "DescribeAcls", "CreateAcls",
+	"DeleteAcls", "DescribeConfigs", "AlterConfigs", "AlterReplicaLogDirs",
+	"DescribeLogDirs", "SaslAuthenticate", "CreatePartitions",
+	"CreateDelegationToken", "RenewDelegationToken", "ExpireDelegationToken",
+	"DescribeDelegationToken", "DeleteGroups", "ElectLeaders",
+	"IncrementalAlterConfigs", "AlterPartitionReassignments",
+	"ListPartitionReassignments", "OffsetDelete", "DescribeClientQuotas",
+	"AlterClientQuotas", "DescribeUserScramCredentials",
+	"AlterUserScramCredentials", "Vote", "BeginQuorumEpoch", "EndQuorumEpoch",
+	"DescribeQuorum", "AlterPartition", "UpdateFeatures", "Envelope",
+	"FetchSnapshot", "DescribeCluster", "DescribeProducers",
+	"BrokerRegistration", "BrokerHeartbeat", "UnregisterBroker",
+	"DescribeTransactions", "ListTransactions", "AllocateProducerIds",
+	"ConsumerGroupHeartbeat",
+}
+
+func apiKeyName(key int16) string {
+	if key >= 0 && int(key) < len(apiKeyNames) {
+		return apiKeyNames[key]
+	}
+	return fmt.Sprintf("Unknown%d", key)
+}
+
+// kafkaReleaseMarkers are API versions that first appeared in a Kafka
+// release, oldest release first. Most releases introduced a new API key,
+// the others are recognized by the max version of Fetch.
+var kafkaReleaseMarkers = []struct {
+	release string
+	key     int16
+	max     int16
+}{
+	{"0.10.0", 18, 0}, // ApiVersions
+	{"0.10.1", 19, 0}, // CreateTopics
+	{"0.11.0", 22, 0}, // InitProducerId
+	{"1.0", 37, 0},    // CreatePartitions
+	{"1.1", 42, 0},    // DeleteGroups
+	{"2.0", 1, 8},     // Fetch v8
+	{"2.1", 1, 10},    // Fetch v10
+	{"2.2", 43, 0},    // ElectLeaders
+	{"2.3", 44, 0},    // IncrementalAlterConfigs
+	{"2.4", 45, 0},    // AlterPartitionReassignments
+	{"2.6", 48, 0},    // DescribeClientQuotas
+	{"2.7", 50, 0},    // DescribeUserScramCredentials
+	{"2.8", 60, 0},    // DescribeCluster
+	{"3.0", 65, 0},    // DescribeTransactions
+}
+
+// inferKafkaRelease returns the newest Kafka release whose APIs are all
+// supported given the API version ranges, which is a lower bound of the
+// broker's actual release.
+func inferKafkaRelease(apis []apiVersionRange) string {
+	max := map[int16]int16{}
+	for _, a := range apis {
+		max[a.Key] = a.Max
+	}
+
+	release := "0.10.0"
+	for _, m := range kafkaReleaseMarkers {
+		v, ok := max[m.key]
+		if !ok || v < m.max {
+			break
+		}
+		release = m.release
+	}
+	return release + "+"
+}
+
+func (cmd *adminCmd) readBrokerVersions(b *sarama.Broker) brokerVersions {
+	bv := brokerVersions{ID: b.ID(), Addr: b.Addr()}
+
+	if err := b.Open(cmd.saramaConfig()); err != nil && err != sarama.ErrAlreadyConnected {
+		bv.Error = err.Error()
+		return bv
+	}
+	defer logClose(fmt.Sprintf("broker %v", b.Addr()), b)
+
+	resp, err := b.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err == nil && resp.ErrorCode != int16(sarama.ErrNoError) {
+		err = sarama.KError(resp.ErrorCode)
+	}
+	if err != nil {
+		bv.Error = fmt.Sprintf("failed to request API versions, brokers before 0.10.0 don't support this, err=%v", err)
+		return bv
+	}
+
+	for _, k := range resp.ApiKeys {
+		bv.APIs = append(bv.APIs, apiVersionRange{Key: k.ApiKey, Name: apiKeyName(k.ApiKey), Min: k.MinVersion, Max: k.MaxVersion})
+	}
+	sort.Slice(bv.APIs, func(i, j int) bool { return bv.APIs[i].Key < bv.APIs[j].Key })
+	bv.Release = inferKafkaRelease(bv.APIs)
+	return bv
+}
+
+// mixedVersions describes how the releases or API version ranges of the
+// brokers differ, it's empty if they all support the same versions.
+func mixedVersions(all []brokerVersions) string {
+	var (
+		releases = map[string][]string{}
+		ranges   = map[string]bool{}
+	)
+
+	for _, bv := range all {
+		if bv.Error != "" {
+			continue
+		}
+		releases[bv.Release] = append(releases[bv.Release], fmt.Sprint(bv.ID))
+		ranges[fmt.Sprint(bv.APIs)] = true
+	}
+
+	if len(ranges) < 2 {
+		return ""
+	}
+
+	if len(releases) < 2 {
+		return "brokers support different API versions"
+	}
+
+	var parts []string
+	for r, ids := range releases {
+		parts = append(parts, fmt.Sprintf("%v on %v", r, strings.Join(ids, ",")))
+	}
+	sort.Strings(parts)
+	return "brokers run different releases: " + strings.Join(parts, "; ")
+}
+
+func (cmd *adminCmd) runVersions() {
+	brokers, _, err := cmd.admin.DescribeCluster()
+	if err != nil {
+		failf("failed to describe cluster err=%v", err)
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID() < brokers[j].ID() })
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	all := []brokerVersions{}
+	for _, b := range brokers {
+		bv := cmd.readBrokerVersions(b)
+		all = append(all, bv)
+
+		ctx := printContext{output: bv, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+
+	if mixed := mixedVersions(all); mixed != "" {
+		fmt.Fprintf(os.Stderr, "mixed-version cluster, %v\n", mixed)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminParseArgsSubCommands(t *testing.T) {
+	os.Setenv("KT_BROKERS", "")
+
+	target := &adminCmd{}
+	target.parseArgs([]string{"versions", "-brokers", "a"})
+	require.True(t, target.versions)
+	require.Equal(t, []string{"a:9092"}, target.brokers)
+
+	target = &adminCmd{}
+	target.parseArgs([]string{"quorum"})
+	require.True(t, target.quorum)
+	require.False(t, target.versions)
+}
+
+func TestInferKafkaRelease(t *testing.T) {
+	data := []struct {
+		apis     []apiVersionRange
+		expected string
+	}{
+		{
+			apis:     []apiVersionRange{{Key: 1, Max: 2}, {Key: 18, Max: 0}},
+			expected: "0.10.0+",
+		},
+		{
+			apis:     []apiVersionRange{{Key: 1, Max: 7}, {Key: 18, Max: 1}, {Key: 19, Max: 2}, {Key: 22, Max: 0}, {Key: 37, Max: 0}, {Key: 42, Max: 0}},
+			expected: "1.1+",
+		},
+		{
+			apis:     []apiVersionRange{{Key: 1, Max: 11}, {Key: 18, Max: 2}, {Key: 19, Max: 3}, {Key: 22, Max: 1}, {Key: 37, Max: 1}, {Key: 42, Max: 1}, {Key: 43, Max: 1}},
+			expected: "2.2+",
+		},
+		{
+			// a gap stops the inference even if newer APIs are present.
+			apis:     []apiVersionRange{{Key: 1, Max: 11}, {Key: 18, Max: 2}, {Key: 19, Max: 3}, {Key: 22, Max: 1}, {Key: 37, Max: 1}, {Key: 42, Max: 1}, {Key: 60, Max: 0}},
+			expected: "2.1+",
+		},
+	}
+
+	for _, d := range data {
+		require.Equal(t, d.expected, inferKafkaRelease(d.apis))
+	}
+}
+
+func TestApiKeyName(t *testing.T) {
+	require.Equal(t, "Produce", apiKeyName(0))
+	require.Equal(t, "ApiVersions", apiKeyName(18))
+	require.Equal(t, "DescribeCluster", apiKeyName(60))
+	require.Equal(t, "Unknown999", apiKeyName(999))
+}
+
+func TestMixedVersions(t *testing.T) {
+	old := []apiVersionRange{{Key: 1, Max: 11}}
+	upgraded := []apiVersionRange{{Key: 1, Max: 12}}
+
+	require.Equal(t, "", mixedVersions([]brokerVersions{
+		{ID: 1, Release: "2.7+", APIs: old},
+		{ID: 2, Release: "2.7+", APIs: old},
+		{ID: 3, Error: "connection refused"},
+	}))
+
+	require.Equal(t, "brokers support different API versions", mixedVersions([]brokerVersions{
+		{ID: 1, Release: "2.7+", APIs: old},
+		{ID: 2, Release: "2.7+", APIs: upgraded},
+	}))
+
+	require.Equal(t, "brokers run different releases: 2.7+ on 1,3; 2.8+ on 2", mixedVersions([]brokerVersions{
+		{ID: 1, Release: "2.7+", APIs: old},
+		{ID: 2, Release: "2.8+", APIs: upgraded},
+		{ID: 3, Release: "2.7+", APIs: old},
+	}))
+}
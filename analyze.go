@@ -0,0 +1,347 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type analyzeCmd struct {
+	clientConfig
+
+	idle     time.Duration
+	timeout  time.Duration
+	internal bool
+	verbose  bool
+	pretty   bool
+
+	client   sarama.Client
+	admin    sarama.ClusterAdmin
+	consumer sarama.Consumer
+}
+
+type analyzeArgs struct {
+	clientArgs
+
+	idle     string
+	timeout  time.Duration
+	internal bool
+	verbose  bool
+	pretty   bool
+}
+
+// unusedResource is a topic or group that is a candidate for cleanup.
+// LastActivity is the timestamp of the newest message of a topic or of the
+// last message a group consumed, it's nil if there's no such message.
+type unusedResource struct {
+	Type         string     `json:"type"`
+	Name         string     `json:"name"`
+	LastActivity *time.Time `json:"lastActivity"`
+	Reason       string     `json:"reason"`
+}
+
+// parseIdleDuration parses durations like time.ParseDuration, additionally
+// accepting days as d, e.g. 30d or 1d12h.
+func parseIdleDuration(str string) (time.Duration, error) {
+	var days int64
+	if i := strings.Index(str, "d"); i >= 0 {
+		n, err := strconv.ParseInt(str[:i], 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %#v", str)
+		}
+		days, str = n, str[i+1:]
+	}
+
+	var d time.Duration
+	if str != "" {
+		var err error
+		if d, err = time.ParseDuration(str); err != nil {
+			return 0, fmt.Errorf("invalid duration %#v", str)
+		}
+	}
+	return time.Duration(days)*24*time.Hour + d, nil
+}
+
+func (cmd *analyzeCmd) run(args []string) {
+	var err error
+
+	if len(args) > 0 && args[0] == "partitioning" {
+		(&analyzePartitioningCmd{}).run(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "compression" {
+		(&analyzeCompressionCmd{}).run(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "duplicates" {
+		(&analyzeDuplicatesCmd{}).run(args[1:])
+		return
+	}
+	if len(args) == 0 || args[0] != "unused" {
+		exitf(exitUsage, "unknown analysis, use \"kt analyze unused -help\", \"kt analyze partitioning -help\", \"kt analyze compression -help\" or \"kt analyze duplicates -help\" for more information")
+	}
+	cmd.parseArgs(args[1:])
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.admin, err = sarama.NewClusterAdminFromClient(cmd.client); err != nil {
+		failf("failed to create cluster admin err=%v", err)
+	}
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	since := time.Now().Add(-cmd.idle)
+	for _, r := range append(cmd.unusedTopics(since), cmd.unusedGroups(since)...) {
+		ctx := printContext{output: r, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+// recordTime returns the timestamp of the record at offset, or nil if it
+// can't be read within -timeout, e.g. as it's a transaction marker.
+func (cmd *analyzeCmd) recordTime(topic string, partition int32, offset int64) *time.Time {
+	pc, err := cmd.consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		if cmd.verbose {
+			fmt.Fprintf(os.Stderr, "failed to read offset %v of topic %v partition %v err=%v\n", offset, topic, partition, err)
+		}
+		return nil
+	}
+	defer logClose("partition consumer", pc)
+
+	select {
+	case msg := <-pc.Messages():
+		if msg.Offset != offset || msg.Timestamp.IsZero() {
+			return nil
+		}
+		return &msg.Timestamp
+	case <-pc.Errors():
+	case <-time.After(cmd.timeout):
+	}
+	return nil
+}
+
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+// topicIdleSince reports whether no messages were produced to the topic
+// since the given time. Brokers return -1 when asked for the offset of a
+// time after the newest message.
+func (cmd *analyzeCmd) topicIdleSince(topic string, partitions []int32, since time.Time) (bool, error) {
+	ms := since.UnixNano() / int64(time.Millisecond)
+	for _, p := range partitions {
+		off, err := cmd.client.GetOffset(topic, p, ms)
+		if err != nil {
+			return false, err
+		}
+		if off >= 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (cmd *analyzeCmd) unusedTopics(since time.Time) []unusedResource {
+	topics, err := cmd.client.Topics()
+	if err != nil {
+		failf("failed to read topics err=%v", err)
+	}
+	sort.Strings(topics)
+
+	result := []unusedResource{}
+	for _, t := range topics {
+		if strings.HasPrefix(t, "__") && !cmd.internal {
+			continue
+		}
+
+		partitions, err := cmd.client.Partitions(t)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read partitions of topic %v err=%v\n", t, err)
+			continue
+		}
+
+		idle, err := cmd.topicIdleSince(t, partitions, since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read offsets of topic %v err=%v\n", t, err)
+			continue
+		}
+		if !idle {
+			continue
+		}
+
+		var last *time.Time
+		for _, p := range partitions {
+			oldest, err := cmd.client.GetOffset(t, p, sarama.OffsetOldest)
+			if err != nil {
+				continue
+			}
+			newest, err := cmd.client.GetOffset(t, p, sarama.OffsetNewest)
+			if err != nil || newest <= oldest {
+				continue
+			}
+			last = latest(last, cmd.recordTime(t, p, newest-1))
+		}
+
+		r := unusedResource{Type: "topic", Name: t, LastActivity: last, Reason: "no messages"}
+		if last != nil {
+			r.Reason = fmt.Sprintf("no messages produced in %v", cmd.idle)
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+func (cmd *analyzeCmd) unusedGroups(since time.Time) []unusedResource {
+	all, err := cmd.admin.ListConsumerGroups()
+	if err != nil {
+		failf("failed to list groups err=%v", err)
+	}
+	groups := []string{}
+	for g := range all {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	if len(groups) == 0 {
+		return nil
+	}
+
+	descriptions, err := cmd.admin.DescribeConsumerGroups(groups)
+	if err != nil {
+		failf("failed to describe groups err=%v", err)
+	}
+	sort.Slice(descriptions, func(i, j int) bool { return descriptions[i].GroupId < descriptions[j].GroupId })
+
+	result := []unusedResource{}
+	for _, desc := range descriptions {
+		if desc.Err != sarama.ErrNoError || len(desc.Members) > 0 {
+			continue
+		}
+
+		committed, err := fetchGroupOffsets(cmd.client, desc.GroupId, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read committed offsets of group %v err=%v\n", desc.GroupId, err)
+			continue
+		}
+
+		if len(committed) == 0 {
+			result = append(result, unusedResource{Type: "group", Name: desc.GroupId, Reason: "no members and no committed offsets"})
+			continue
+		}
+
+		// Kafka doesn't expose when offsets were committed, the timestamp
+		// of the last consumed message is an upper bound of the group's
+		// idle time.
+		var last *time.Time
+		for _, e := range committed {
+			if e.Offset > 0 {
+				last = latest(last, cmd.recordTime(e.Topic, e.Partition, e.Offset-1))
+			}
+		}
+		if last != nil && last.After(since) {
+			continue
+		}
+
+		r := unusedResource{Type: "group", Name: desc.GroupId, LastActivity: last, Reason: "no members and the consumed messages are no longer available"}
+		if last != nil {
+			r.Reason = fmt.Sprintf("no members and no messages consumed in %v", cmd.idle)
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+func (cmd *analyzeCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("analyze")
+}
+
+func (cmd *analyzeCmd) failStartup(msg string) {
+	failUsage(msg, "kt analyze unused")
+}
+
+func (cmd *analyzeCmd) parseArgs(as []string) {
+	var (
+		err  error
+		args = cmd.parseFlags(as)
+	)
+
+	if cmd.idle, err = parseIdleDuration(args.idle); err != nil || cmd.idle <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid idle argument %#v, expected a positive duration like 30d or 12h.", args.idle))
+	}
+
+	cmd.timeout = args.timeout
+	cmd.internal = args.internal
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *analyzeCmd) parseFlags(as []string) analyzeArgs {
+	var args analyzeArgs
+	flags := flag.NewFlagSet("analyze unused", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.idle, "idle", "30d", "Report topics and groups without activity for the given duration, e.g. 30d or 12h.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Timeout for reading the record that shows the last activity.")
+	flags.BoolVar(&args.internal, "internal", false, "Include internal topics like __consumer_offsets.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of analyze unused:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, analyzeDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var analyzeDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt analyze unused" lists cleanup candidates: topics without messages
+produced within -idle and consumer groups without members that didn't
+consume messages produced within -idle. Each candidate includes the
+timestamp of the newest message of the topic or the last message the group
+consumed as evidence.
+
+Kafka doesn't record when a group committed its offsets, so a group that
+committed recently but only consumes old messages is reported too. Messages
+with timestamps set by producers may also be misleading, so review the
+candidates before deleting anything.
+
+kt analyze unused -idle 30d`
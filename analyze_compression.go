@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+type analyzeCompressionCmd struct {
+	clientConfig
+
+	topic        string
+	offsets      map[int32]interval
+	maxFetchSize int32
+	verbose      bool
+	pretty       bool
+
+	client   sarama.Client
+	consumer sarama.Consumer
+	src      *consumeCmd // resolves -offsets
+}
+
+type analyzeCompressionArgs struct {
+	clientArgs
+
+	topic        string
+	offsets      string
+	maxFetchSize int
+	verbose      bool
+	pretty       bool
+}
+
+// recordBatchOverhead is the size of a record batch without its records in
+// the message format of Kafka 0.11.0.0 and later.
+const recordBatchOverhead = 61
+
+// batchCompressors compress the records of a batch like the producers of
+// the codec, to estimate the batch's size on the wire. sarama decompresses
+// batches when fetching them and doesn't keep their compressed size.
+var batchCompressors = map[sarama.CompressionCodec]func(data []byte) ([]byte, error){
+	sarama.CompressionGZIP: func(data []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		err := w.Close()
+		return buf.Bytes(), err
+	},
+	sarama.CompressionSnappy: func(data []byte) ([]byte, error) {
+		return snappy.Encode(nil, data), nil
+	},
+	sarama.CompressionLZ4: func(data []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		w := lz4.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		err := w.Close()
+		return buf.Bytes(), err
+	},
+	sarama.CompressionZSTD: func(data []byte) ([]byte, error) {
+		return zstdBatchEncoder.EncodeAll(data, nil), nil
+	},
+}
+
+var zstdBatchEncoder, _ = zstd.NewWriter(nil)
+
+// payloadCompression recognizes keys or values that producers compressed
+// themselves by the magic bytes the format starts with.
+type payloadCompression struct {
+	name  string
+	magic []byte
+}
+
+// payloadCompressions are checked in order, add formats here to detect them.
+var payloadCompressions = []payloadCompression{
+	{name: "gzip", magic: []byte{0x1f, 0x8b}},
+	{name: "zstd", magic: zstdMagic},
+	{name: "lz4", magic: []byte{0x04, 0x22, 0x4d, 0x18}},
+	{name: "snappy-framed", magic: []byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}},
+}
+
+// detectPayloadCompression returns the name of the format data is
+// compressed with, or "" if none is recognized.
+func detectPayloadCompression(data []byte) string {
+	for _, c := range payloadCompressions {
+		if bytes.HasPrefix(data, c.magic) {
+			return c.name
+		}
+	}
+	return ""
+}
+
+// appendRecord appends r encoded like in a record batch.
+func appendRecord(buf []byte, r *sarama.Record) []byte {
+	var body []byte
+	body = append(body, byte(r.Attributes))
+	body = appendVarint(body, r.TimestampDelta.Milliseconds())
+	body = appendVarint(body, r.OffsetDelta)
+	body = appendVarBytes(body, r.Key)
+	body = appendVarBytes(body, r.Value)
+	body = appendVarint(body, int64(len(r.Headers)))
+	for _, h := range r.Headers {
+		body = appendVarBytes(body, h.Key)
+		body = appendVarBytes(body, h.Value)
+	}
+	buf = appendVarint(buf, int64(len(body)))
+	return append(buf, body...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendVarBytes(buf []byte, data []byte) []byte {
+	if data == nil {
+		return appendVarint(buf, -1)
+	}
+	return append(appendVarint(buf, int64(len(data))), data...)
+}
+
+// compressionStats compares the sizes of record batches as they are with
+// their uncompressed size and with zstd. Compressed sizes are estimates,
+// see batchCompressors. Ratios are compressed by uncompressed bytes.
+type compressionStats struct {
+	Batches           int            `json:"batches"`
+	Records           int            `json:"records"`
+	Codecs            map[string]int `json:"codecs"` // batches by codec
+	UncompressedBytes int64          `json:"uncompressedBytes"`
+	CompressedBytes   int64          `json:"compressedBytes"`
+	Ratio             float64        `json:"ratio"`
+	ZstdBytes         int64          `json:"zstdBytes"`
+	ZstdRatio         float64        `json:"zstdRatio"`
+	KeyCompression    map[string]int `json:"keyCompression"` // records by detected format
+	ValueCompression  map[string]int `json:"valueCompression"`
+}
+
+func newCompressionStats() compressionStats {
+	return compressionStats{Codecs: map[string]int{}, KeyCompression: map[string]int{}, ValueCompression: map[string]int{}}
+}
+
+// addBatch adds the records of b between start and end.
+func (s *compressionStats) addBatch(b *sarama.RecordBatch, start, end int64) error {
+	var buf []byte
+	records := 0
+	for _, r := range b.Records {
+		if o := b.FirstOffset + r.OffsetDelta; o < start || o > end {
+			continue
+		}
+		buf = appendRecord(buf, r)
+		records++
+		if c := detectPayloadCompression(r.Key); c != "" {
+			s.KeyCompression[c]++
+		}
+		if c := detectPayloadCompression(r.Value); c != "" {
+			s.ValueCompression[c]++
+		}
+	}
+	if records == 0 {
+		return nil
+	}
+
+	uncompressed := int64(recordBatchOverhead + len(buf))
+	compressed := uncompressed
+	if compress, ok := batchCompressors[b.Codec]; ok {
+		data, err := compress(buf)
+		if err != nil {
+			return fmt.Errorf("failed to compress batch at offset %v with %v err=%v", b.FirstOffset, b.Codec, err)
+		}
+		compressed = int64(recordBatchOverhead + len(data))
+	}
+	zstdData, _ := batchCompressors[sarama.CompressionZSTD](buf)
+
+	s.Batches++
+	s.Records += records
+	s.Codecs[b.Codec.String()]++
+	s.UncompressedBytes += uncompressed
+	s.CompressedBytes += compressed
+	s.ZstdBytes += int64(recordBatchOverhead + len(zstdData))
+	return nil
+}
+
+func (s *compressionStats) add(o compressionStats) {
+	s.Batches += o.Batches
+	s.Records += o.Records
+	s.UncompressedBytes += o.UncompressedBytes
+	s.CompressedBytes += o.CompressedBytes
+	s.ZstdBytes += o.ZstdBytes
+	for _, m := range []struct{ dst, src map[string]int }{{s.Codecs, o.Codecs}, {s.KeyCompression, o.KeyCompression}, {s.ValueCompression, o.ValueCompression}} {
+		for k, v := range m.src {
+			m.dst[k] += v
+		}
+	}
+}
+
+func (s *compressionStats) computeRatios() {
+	if s.UncompressedBytes == 0 {
+		return
+	}
+	s.Ratio = float64(s.CompressedBytes) / float64(s.UncompressedBytes)
+	s.ZstdRatio = float64(s.ZstdBytes) / float64(s.UncompressedBytes)
+}
+
+// compressionReport is printed by kt analyze compression, the embedded
+// stats cover all partitions.
+type compressionReport struct {
+	Topic string `json:"topic"`
+	compressionStats
+	Partitions []compressionPartition `json:"partitions"`
+}
+
+type compressionPartition struct {
+	Partition   int32 `json:"partition"`
+	StartOffset int64 `json:"startOffset"`
+	EndOffset   int64 `json:"endOffset"`
+	compressionStats
+}
+
+func (cmd *analyzeCompressionCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	cmd.src = &consumeCmd{
+		topic:    cmd.topic,
+		offsets:  cmd.offsets,
+		untilEnd: true,
+		verbose:  cmd.verbose,
+		client:   cmd.client,
+		consumer: cmd.consumer,
+	}
+
+	partitions := cmd.src.findPartitions(cmd.topic)
+	if len(partitions) == 0 {
+		failf("found no partitions to analyze")
+	}
+	tps := []topicPartition{}
+	for _, p := range partitions {
+		tps = append(tps, topicPartition{cmd.topic, p})
+	}
+	if err = cmd.src.captureCurrentOffsets(tps); err != nil {
+		failf("failed to read high water marks err=%v", err)
+	}
+
+	report := compressionReport{Topic: cmd.topic, compressionStats: newCompressionStats(), Partitions: []compressionPartition{}}
+	for _, p := range partitions {
+		start, end, ok := cmd.src.partitionRange(cmd.topic, p)
+		if !ok {
+			continue
+		}
+		r, err := cmd.analyzePartition(p, start, end)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to analyze partition %v of topic %v err=%v\n", p, cmd.topic, err)
+			cmd.src.partitionFailed(cmd.topic, p)
+		}
+		r.computeRatios()
+		report.add(r.compressionStats)
+		report.Partitions = append(report.Partitions, r)
+	}
+	sort.Slice(report.Partitions, func(i, j int) bool { return report.Partitions[i].Partition < report.Partitions[j].Partition })
+	report.computeRatios()
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: report, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	cmd.src.exitOnFailures()
+}
+
+// analyzePartition fetches the record batches of partition from start to
+// end. It returns the stats gathered so far with an error.
+func (cmd *analyzeCompressionCmd) analyzePartition(partition int32, start, end int64) (compressionPartition, error) {
+	var (
+		r         = compressionPartition{Partition: partition, StartOffset: start, EndOffset: end, compressionStats: newCompressionStats()}
+		offset    = start
+		fetchSize = int32(1 << 20)
+	)
+
+	for offset <= end {
+		broker, err := cmd.client.Leader(cmd.topic, partition)
+		if err != nil {
+			return r, err
+		}
+		req := &sarama.FetchRequest{Version: 4, MaxWaitTime: 500, MinBytes: 1, MaxBytes: sarama.MaxResponseSize}
+		req.AddBlock(cmd.topic, partition, offset, fetchSize, -1)
+		resp, err := broker.Fetch(req)
+		if err != nil {
+			return r, err
+		}
+		block := resp.GetBlock(cmd.topic, partition)
+		if block == nil {
+			return r, fmt.Errorf("missing partition %v of topic %v in fetch response", partition, cmd.topic)
+		}
+		if block.Err != sarama.ErrNoError {
+			return r, block.Err
+		}
+
+		fetched := false
+		for _, records := range block.RecordsSet {
+			if records.MsgSet != nil {
+				return r, fmt.Errorf("kt analyze compression requires the message format of Kafka 0.11.0.0 or later")
+			}
+			b := records.RecordBatch
+			if b == nil {
+				continue
+			}
+			next := b.FirstOffset + int64(b.LastOffsetDelta) + 1
+			if next <= offset {
+				continue
+			}
+			fetched, offset = true, next
+			if b.Control {
+				continue
+			}
+			if err = r.addBatch(b, start, end); err != nil {
+				return r, err
+			}
+		}
+
+		switch {
+		case fetched:
+		case block.Partial || len(block.RecordsSet) > 0:
+			// the next batch is larger than the fetch size.
+			if fetchSize >= cmd.maxFetchSize {
+				return r, sarama.ErrMessageTooLarge
+			}
+			if fetchSize *= 2; fetchSize > cmd.maxFetchSize {
+				fetchSize = cmd.maxFetchSize
+			}
+		case block.LastRecordsBatchOffset != nil && *block.LastRecordsBatchOffset >= offset:
+			offset = *block.LastRecordsBatchOffset + 1
+		default:
+			if cmd.verbose {
+				fmt.Fprintf(os.Stderr, "found no records in partition %v of topic %v from offset %v to %v\n", partition, cmd.topic, offset, end)
+			}
+			return r, nil
+		}
+	}
+	return r, nil
+}
+
+func (cmd *analyzeCompressionCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("analyze")
+}
+
+func (cmd *analyzeCompressionCmd) failStartup(msg string) {
+	failUsage(msg, "kt analyze compression")
+}
+
+func (cmd *analyzeCompressionCmd) parseArgs(as []string) {
+	var err error
+
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.maxFetchSize <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid -max-fetch-size %v, expected a positive number of bytes.", args.maxFetchSize))
+	}
+
+	if cmd.offsets, err = parseOffsets(args.offsets); err != nil {
+		cmd.failStartup(fmt.Sprintf("%s", err))
+	}
+	for _, i := range cmd.offsets {
+		if i.start.start == offsetResume || i.end.start == offsetResume {
+			cmd.failStartup("resume offsets require a group, which kt analyze compression doesn't use.")
+		}
+	}
+
+	cmd.topic = args.topic
+	cmd.maxFetchSize = int32(args.maxFetchSize)
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *analyzeCompressionCmd) parseFlags(as []string) analyzeCompressionArgs {
+	var args analyzeCompressionArgs
+	flags := flag.NewFlagSet("analyze compression", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.topic, "topic", "", "Topic to analyze (required).")
+	flags.StringVar(&args.offsets, "offsets", "", "Specifies what records to analyze by partition and offset range like for kt consume (defaults to all).")
+	flags.IntVar(&args.maxFetchSize, "max-fetch-size", int(sarama.MaxResponseSize), "Maximum size in bytes of a fetch, must fit the largest record batch.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of analyze compression:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, analyzeCompressionDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var analyzeCompressionDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt analyze compression" reads the record batches of each partition within
+-offsets, which uses the syntax of kt consume up to the current end of the
+partitions, and prints per partition and for the whole topic:
+
+  codecs             the number of batches per compression codec
+  uncompressedBytes  the size of the batches without compression
+  compressedBytes    the size of the batches with their codec
+  zstdBytes          the size of the batches if they were compressed with zstd
+  ratio, zstdRatio   compressedBytes and zstdBytes by uncompressedBytes
+
+Brokers return batches compressed, but the client decompresses them without
+keeping their size, so compressedBytes and zstdBytes are estimates from
+compressing the batches' records again with the default level of the codec.
+Producers may use other levels and batches at the edges of -offsets only
+count their records within. A zstdBytes well below compressedBytes suggests
+switching the producers to zstd is worth it.
+
+keyCompression and valueCompression count the records whose keys or values
+producers compressed themselves, recognized as gzip, zstd, lz4 or framed
+snappy by their magic bytes. Compressing batches again gains little for them.
+
+kt analyze compression -topic orders -offsets all=-24h:`
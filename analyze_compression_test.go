@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendRecord(t *testing.T) {
+	data := []struct {
+		testName string
+		record   *sarama.Record
+		expected []byte
+	}{
+		{
+			testName: "key-value",
+			record:   &sarama.Record{OffsetDelta: 1, Key: []byte("k"), Value: []byte("v")},
+			expected: []byte{16, 0, 0, 2, 2, 'k', 2, 'v', 0},
+		},
+		{
+			testName: "null-key-header",
+			record:   &sarama.Record{Value: []byte("v"), Headers: []*sarama.RecordHeader{{Key: []byte("h"), Value: nil}}},
+			expected: []byte{20, 0, 0, 0, 1, 2, 'v', 2, 2, 'h', 1},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.testName, func(t *testing.T) {
+			require.Equal(t, d.expected, appendRecord(nil, d.record))
+		})
+	}
+}
+
+func TestDetectPayloadCompression(t *testing.T) {
+	compressed, err := batchCompressors[sarama.CompressionGZIP]([]byte("hello"))
+	require.Nil(t, err)
+	require.Equal(t, "gzip", detectPayloadCompression(compressed))
+
+	compressed, err = batchCompressors[sarama.CompressionZSTD]([]byte("hello"))
+	require.Nil(t, err)
+	require.Equal(t, "zstd", detectPayloadCompression(compressed))
+
+	compressed, err = batchCompressors[sarama.CompressionLZ4]([]byte("hello"))
+	require.Nil(t, err)
+	require.Equal(t, "lz4", detectPayloadCompression(compressed))
+
+	require.Equal(t, "", detectPayloadCompression([]byte(`{"hello":1}`)))
+	require.Equal(t, "", detectPayloadCompression(nil))
+}
+
+func TestCompressionStats(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"hans","city":"berlin"}`), 10)
+	batch := func(codec sarama.CompressionCodec, first int64) *sarama.RecordBatch {
+		b := &sarama.RecordBatch{FirstOffset: first, Codec: codec}
+		for i := int64(0); i < 10; i++ {
+			b.Records = append(b.Records, &sarama.Record{OffsetDelta: i, Value: value})
+		}
+		return b
+	}
+
+	s := newCompressionStats()
+	require.Nil(t, s.addBatch(batch(sarama.CompressionNone, 0), 0, 100))
+	require.Nil(t, s.addBatch(batch(sarama.CompressionGZIP, 10), 0, 14))
+	require.Nil(t, s.addBatch(batch(sarama.CompressionGZIP, 20), 0, 14))
+	s.computeRatios()
+
+	require.Equal(t, 2, s.Batches)
+	require.Equal(t, 15, s.Records)
+	require.Equal(t, map[string]int{"none": 1, "gzip": 1}, s.Codecs)
+	require.Greater(t, s.UncompressedBytes, int64(15*len(value)))
+	require.Less(t, s.CompressedBytes, s.UncompressedBytes)
+	require.Less(t, s.ZstdBytes, s.CompressedBytes)
+	require.Less(t, s.ZstdRatio, s.Ratio)
+
+	total := newCompressionStats()
+	total.add(s)
+	total.add(s)
+	require.Equal(t, 4, total.Batches)
+	require.Equal(t, map[string]int{"none": 2, "gzip": 2}, total.Codecs)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type analyzeDuplicatesCmd struct {
+	clientConfig
+
+	topic    string
+	by       string
+	stateDir string
+	timeout  time.Duration
+	encoding string
+	verbose  bool
+	pretty   bool
+
+	client   sarama.Client
+	consumer sarama.Consumer
+	state    *passState
+}
+
+type analyzeDuplicatesArgs struct {
+	clientArgs
+
+	topic    string
+	by       string
+	stateDir string
+	timeout  time.Duration
+	encoding string
+	verbose  bool
+	pretty   bool
+}
+
+// duplicateRecord is printed for every record that repeats an earlier
+// record of the topic, First is where that earlier record is.
+type duplicateRecord struct {
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Key       *string   `json:"key"`
+	Count     int       `json:"count"`
+	First     string    `json:"first"`
+}
+
+// duplicateHash hashes what -by compares of msg. Keys and values are
+// length prefixed for record, so that moving bytes between them changes
+// the hash.
+func duplicateHash(by string, msg *sarama.ConsumerMessage) [sha256.Size]byte {
+	h := sha256.New()
+	write := func(data []byte) {
+		var n [8]byte
+		size := int64(-1)
+		if data != nil {
+			size = int64(len(data))
+		}
+		binary.BigEndian.PutUint64(n[:], uint64(size))
+		h.Write(n[:])
+		h.Write(data)
+	}
+	switch by {
+	case "key":
+		write(msg.Key)
+	case "value":
+		write(msg.Value)
+	default:
+		write(msg.Key)
+		write(msg.Value)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func (cmd *analyzeDuplicatesCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	dir := cmd.stateDir
+	if dir == "" {
+		if dir, err = ioutil.TempDir("", "kt-analyze-"); err != nil {
+			failf("failed to create state dir err=%v", err)
+		}
+		defer os.RemoveAll(dir)
+	}
+	if cmd.state, err = openPassState(dir, cmd.topic); err != nil {
+		failf("%v", err)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	if err = cmd.storeRecords(); err != nil {
+		if cmd.stateDir != "" {
+			failf("%v, run again with the same -state-dir to continue", err)
+		}
+		failf("%v", err)
+	}
+
+	counts := map[[sha256.Size]byte]int{}
+	err = cmd.state.each(func(msg *sarama.ConsumerMessage) error {
+		counts[duplicateHash(cmd.by, msg)]++
+		return nil
+	})
+	if err != nil {
+		failf("%v", err)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	first := map[[sha256.Size]byte]string{}
+	err = cmd.state.each(func(msg *sarama.ConsumerMessage) error {
+		sum := duplicateHash(cmd.by, msg)
+		if counts[sum] < 2 {
+			return nil
+		}
+		if _, ok := first[sum]; !ok {
+			first[sum] = fmt.Sprintf("%v/%v", msg.Partition, msg.Offset)
+			return nil
+		}
+		ctx := printContext{
+			output: duplicateRecord{
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Timestamp: msg.Timestamp,
+				Key:       encodeBytes(msg.Key, cmd.encoding),
+				Count:     counts[sum],
+				First:     first[sum],
+			},
+			done: make(chan struct{}),
+		}
+		out <- ctx
+		<-ctx.done
+		return nil
+	})
+	if err != nil {
+		failf("%v", err)
+	}
+}
+
+// storeRecords consumes the partitions that the state doesn't hold yet.
+func (cmd *analyzeDuplicatesCmd) storeRecords() error {
+	resolved, err := resolvePassRanges(cmd.client, cmd.topic)
+	if err != nil {
+		return fmt.Errorf("failed to read offsets err=%v", err)
+	}
+	ranges, err := cmd.state.plan(resolved)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range ranges {
+		if r.Complete {
+			if cmd.verbose {
+				fmt.Fprintf(os.Stderr, "reusing %v stored records of partition %v\n", r.Records, r.Partition)
+			}
+			continue
+		}
+		err = cmd.state.store(r.Partition, func(add func(*sarama.ConsumerMessage) error) error {
+			return consumePassRange(cmd.consumer, cmd.topic, r, cmd.timeout, add)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %v err=%v", r.Partition, err)
+		}
+	}
+	return nil
+}
+
+func (cmd *analyzeDuplicatesCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("analyze")
+}
+
+func (cmd *analyzeDuplicatesCmd) failStartup(msg string) {
+	failUsage(msg, "kt analyze duplicates")
+}
+
+func (cmd *analyzeDuplicatesCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	switch args.by {
+	case "key", "value", "record":
+	default:
+		cmd.failStartup(fmt.Sprintf(`unsupported -by argument %#v, only "key", "value" and "record" are supported.`, args.by))
+	}
+	switch args.encoding {
+	case "string", "hex", "base64":
+	default:
+		cmd.failStartup(fmt.Sprintf(`unsupported -encoding argument %#v, only "string", "hex" and "base64" are supported.`, args.encoding))
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid -timeout %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.by = args.by
+	cmd.stateDir = args.stateDir
+	cmd.timeout = args.timeout
+	cmd.encoding = args.encoding
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *analyzeDuplicatesCmd) parseFlags(as []string) analyzeDuplicatesArgs {
+	var args analyzeDuplicatesArgs
+	flags := flag.NewFlagSet("analyze duplicates", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.topic, "topic", "", "Topic to analyze (required).")
+	flags.StringVar(&args.by, "by", "record", "What makes records duplicates: key, value or record for both.")
+	flags.StringVar(&args.stateDir, "state-dir", "", "Directory to keep the consumed records in, to continue an interrupted analysis or run it again without consuming the topic (defaults to a temporary directory that's removed).")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Stop consuming a partition after this long without records.")
+	flags.StringVar(&args.encoding, "encoding", "string", "Encoding of the printed keys (string|hex|base64).")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of analyze duplicates:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, analyzeDuplicatesDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var analyzeDuplicatesDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt analyze duplicates" finds records with the same key, value or both
+across all partitions of a topic, e.g. to check that a deduplicating
+producer or stream processor works. It prints every record that repeats an
+earlier one, with the partition and offset of the first occurrence as first
+and the number of occurrences as count:
+
+kt analyze duplicates -topic payments -by value
+
+The analysis reads the records twice, once to count them and once to print
+the duplicates. It consumes the records from the oldest to the newest offsets
+of each partition once and stores them in -state-dir, the second pass reads
+them from there. -state-dir has a manifest.json with the topic and the
+offset ranges and tracks which partitions are stored completely, so running
+the analysis again with the same -state-dir after an interruption only
+consumes the missing partitions, and with all partitions stored it doesn't
+consume at all. Partitions whose offsets changed since, e.g. as new records
+arrived, are consumed again. Without -state-dir, a temporary directory is
+used and removed afterwards.
+
+The stored records take about as much space as the partitions' keys and
+values, base64 encoded.`
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicateHash(t *testing.T) {
+	a := &sarama.ConsumerMessage{Key: []byte("ab"), Value: []byte("c")}
+	b := &sarama.ConsumerMessage{Key: []byte("a"), Value: []byte("bc")}
+	c := &sarama.ConsumerMessage{Key: []byte("ab"), Value: []byte("d")}
+	null := &sarama.ConsumerMessage{Value: []byte("c")}
+	empty := &sarama.ConsumerMessage{Key: []byte{}, Value: []byte("c")}
+
+	require.NotEqual(t, duplicateHash("record", a), duplicateHash("record", b))
+	require.NotEqual(t, duplicateHash("record", a), duplicateHash("record", c))
+	require.Equal(t, duplicateHash("key", a), duplicateHash("key", c))
+	require.Equal(t, duplicateHash("value", a), duplicateHash("value", null))
+	require.NotEqual(t, duplicateHash("key", null), duplicateHash("key", empty))
+}
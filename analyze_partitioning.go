@@ -0,0 +1,360 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type analyzePartitioningCmd struct {
+	clientConfig
+
+	topic        string
+	partitioners []string
+	sample       int
+	timeout      time.Duration
+	verbose      bool
+	pretty       bool
+
+	client   sarama.Client
+	consumer sarama.Consumer
+}
+
+type analyzePartitioningArgs struct {
+	clientArgs
+
+	topic        string
+	partitioners string
+	sample       int
+	timeout      time.Duration
+	verbose      bool
+	pretty       bool
+}
+
+// keyPartitioners compute the partition of a key the way common producers do
+// by default.
+var keyPartitioners = map[string]func(key []byte, partitions int32) int32{
+	// Java client and Kafka Streams
+	"murmur2": func(key []byte, partitions int32) int32 {
+		return (murmur2(key) & 0x7fffffff) % partitions
+	},
+	// librdkafka's consistent and consistent_random
+	"crc32": func(key []byte, partitions int32) int32 {
+		return int32(crc32.ChecksumIEEE(key) % uint32(partitions))
+	},
+	// sarama's NewHashPartitioner
+	"fnv1a": func(key []byte, partitions int32) int32 {
+		h := fnv.New32a()
+		h.Write(key)
+		p := int32(h.Sum32()) % partitions
+		if p < 0 {
+			p = -p
+		}
+		return p
+	},
+	// kt produce -partitioner hashCode
+	"hashCode": func(key []byte, partitions int32) int32 {
+		return hashCodePartition(string(key), partitions)
+	},
+}
+
+var keyPartitionerNames = []string{"murmur2", "crc32", "fnv1a", "hashCode"}
+
+// murmur2 is the hash of the Java client's default partitioner, see
+// org.apache.kafka.common.utils.Utils#murmur2.
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// keySample is the key of a sampled record and the partition it was
+// produced to.
+type keySample struct {
+	partition int32
+	key       []byte
+}
+
+// partitioningReport compares the sampled records' partitions with the ones
+// a partitioner expects for their keys.
+type partitioningReport struct {
+	Topic       string               `json:"topic"`
+	Partitioner string               `json:"partitioner"`
+	Sampled     int                  `json:"sampled"`
+	Mismatched  int                  `json:"mismatched"`
+	Partitions  []partitionHistogram `json:"partitions"`
+}
+
+// partitionHistogram counts the sampled records of a partition. Expected
+// counts the partitions the partitioner expects for the mismatched ones.
+type partitionHistogram struct {
+	Partition  int32         `json:"partition"`
+	Records    int           `json:"records"`
+	Mismatched int           `json:"mismatched"`
+	Expected   map[int32]int `json:"expected,omitempty"`
+}
+
+func analyzePartitioning(topic string, partitions int32, samples []keySample, partitioners []string) []partitioningReport {
+	reports := []partitioningReport{}
+	for _, name := range partitioners {
+		partition := keyPartitioners[name]
+		histograms := map[int32]*partitionHistogram{}
+		report := partitioningReport{Topic: topic, Partitioner: name, Partitions: []partitionHistogram{}}
+
+		for _, s := range samples {
+			h, ok := histograms[s.partition]
+			if !ok {
+				h = &partitionHistogram{Partition: s.partition}
+				histograms[s.partition] = h
+			}
+			h.Records++
+			report.Sampled++
+
+			if expected := partition(s.key, partitions); expected != s.partition {
+				if h.Expected == nil {
+					h.Expected = map[int32]int{}
+				}
+				h.Expected[expected]++
+				h.Mismatched++
+				report.Mismatched++
+			}
+		}
+
+		for _, h := range histograms {
+			report.Partitions = append(report.Partitions, *h)
+		}
+		sort.Slice(report.Partitions, func(i, j int) bool { return report.Partitions[i].Partition < report.Partitions[j].Partition })
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func (cmd *analyzePartitioningCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.topic, err)
+	}
+
+	samples := []keySample{}
+	for _, p := range partitions {
+		ss, err := cmd.samplePartition(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to sample partition %v of topic %v err=%v\n", p, cmd.topic, err)
+			continue
+		}
+		samples = append(samples, ss...)
+	}
+	if len(samples) == 0 {
+		failf("found no records with keys in topic %v", cmd.topic)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	for _, r := range analyzePartitioning(cmd.topic, int32(len(partitions)), samples, cmd.partitioners) {
+		if r.Mismatched == 0 {
+			fmt.Fprintf(os.Stderr, "all %v sampled records match the %v partitioner\n", r.Sampled, r.Partitioner)
+		}
+		ctx := printContext{output: r, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+// samplePartition reads the keys of up to -sample of the partition's newest
+// records. Records without key are skipped as partitioners spread them
+// without regard to their contents.
+func (cmd *analyzePartitioningCmd) samplePartition(partition int32) ([]keySample, error) {
+	oldest, err := cmd.client.GetOffset(cmd.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, err
+	}
+	newest, err := cmd.client.GetOffset(cmd.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	if newest <= oldest {
+		return nil, nil
+	}
+
+	start := newest - int64(cmd.sample)
+	if start < oldest {
+		start = oldest
+	}
+
+	pc, err := cmd.consumer.ConsumePartition(cmd.topic, partition, start)
+	if err != nil {
+		return nil, err
+	}
+	defer logClose("partition consumer", pc)
+
+	samples := []keySample{}
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Key != nil {
+				samples = append(samples, keySample{partition: partition, key: msg.Key})
+			}
+			if msg.Offset >= newest-1 {
+				return samples, nil
+			}
+		case err := <-pc.Errors():
+			return samples, err
+		case <-time.After(cmd.timeout):
+			// the newest offsets may be transaction markers.
+			if cmd.verbose {
+				fmt.Fprintf(os.Stderr, "timed out sampling partition %v of topic %v after %v records\n", partition, cmd.topic, len(samples))
+			}
+			return samples, nil
+		}
+	}
+}
+
+func (cmd *analyzePartitioningCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("analyze")
+}
+
+func (cmd *analyzePartitioningCmd) failStartup(msg string) {
+	failUsage(msg, "kt analyze partitioning")
+}
+
+func (cmd *analyzePartitioningCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.sample <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid sample size %v, expected a positive number of records.", args.sample))
+	}
+
+	cmd.partitioners = keyPartitionerNames
+	if args.partitioners != "" {
+		cmd.partitioners = strings.Split(args.partitioners, ",")
+	}
+	for _, name := range cmd.partitioners {
+		if keyPartitioners[name] == nil {
+			cmd.failStartup(fmt.Sprintf("unknown partitioner %#v, available: %v.", name, strings.Join(keyPartitionerNames, ", ")))
+		}
+	}
+
+	cmd.topic = args.topic
+	cmd.sample = args.sample
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *analyzePartitioningCmd) parseFlags(as []string) analyzePartitioningArgs {
+	var args analyzePartitioningArgs
+	flags := flag.NewFlagSet("analyze partitioning", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.topic, "topic", "", "Topic to analyze (required).")
+	flags.StringVar(&args.partitioners, "partitioners", "", "Comma separated list of partitioners to compare with, defaults to all of: "+strings.Join(keyPartitionerNames, ", "))
+	flags.IntVar(&args.sample, "sample", 100, "Number of newest records to sample per partition.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Timeout for reading the sampled records of a partition.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of analyze partitioning:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, analyzePartitioningDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var analyzePartitioningDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt analyze partitioning" samples the newest records with keys of each
+partition and recomputes the partition of their keys with the default
+partitioners of common clients:
+
+  murmur2   the Java client and Kafka Streams
+  crc32     librdkafka's consistent partitioners
+  fnv1a     sarama's hash partitioner
+  hashCode  kt produce -partitioner hashCode
+
+For each partitioner, kt prints a histogram of the sampled records per
+partition with the number of records in a different partition than expected
+and the partitions expected for them. If no partitioner matches all records,
+the partitions with mismatches under the closest one point to producers that
+partition differently, e.g. with a custom partitioner or a client with
+another default. Records produced before partitions were added are reported
+as mismatches, too.
+
+kt analyze partitioning -topic orders -sample 1000`
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMurmur2(t *testing.T) {
+	// from org.apache.kafka.common.utils.UtilsTest#testMurmur2
+	data := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+
+	for given, expected := range data {
+		require.Equal(t, expected, murmur2([]byte(given)), given)
+	}
+}
+
+func TestAnalyzePartitioning(t *testing.T) {
+	key := []byte("a")
+	expected := keyPartitioners["murmur2"](key, 3)
+	other := (expected + 1) % 3
+
+	samples := []keySample{
+		{partition: expected, key: key},
+		{partition: expected, key: key},
+		{partition: other, key: key},
+	}
+	reports := analyzePartitioning("t", 3, samples, []string{"murmur2"})
+
+	require.Equal(t, 1, len(reports))
+	r := reports[0]
+	require.Equal(t, "murmur2", r.Partitioner)
+	require.Equal(t, 3, r.Sampled)
+	require.Equal(t, 1, r.Mismatched)
+
+	var mismatched partitionHistogram
+	for _, h := range r.Partitions {
+		if h.Partition == other {
+			mismatched = h
+		}
+	}
+	require.Equal(t, partitionHistogram{Partition: other, Records: 1, Mismatched: 1, Expected: map[int32]int{expected: 1}}, mismatched)
+}
+
+func TestKeyPartitionersInRange(t *testing.T) {
+	for _, name := range keyPartitionerNames {
+		for _, k := range []string{"", "a", "order-42", "日本"} {
+			p := keyPartitioners[name]([]byte(k), 7)
+			require.True(t, p >= 0 && p < 7, "%v of %#v is %v", name, k, p)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// passManifestVersion is the version of the manifest and record files of
+// -state-dir, a directory with another version is rejected rather than
+// misread.
+const passManifestVersion = 1
+
+// passRange is the offsets from Start to before End of a partition that an
+// analysis reads. Complete is set once its records are stored.
+type passRange struct {
+	Partition int32 `json:"partition"`
+	Start     int64 `json:"start"`
+	End       int64 `json:"end"`
+	Complete  bool  `json:"complete"`
+	Records   int64 `json:"records"`
+}
+
+type passManifest struct {
+	Version int         `json:"version"`
+	Topic   string      `json:"topic"`
+	Created time.Time   `json:"created"`
+	Ranges  []passRange `json:"ranges"`
+}
+
+// passRecord is a record as stored in a partition's record file.
+type passRecord struct {
+	Offset    int64        `json:"offset"`
+	Timestamp time.Time    `json:"timestamp"`
+	Key       []byte       `json:"key"`
+	Value     []byte       `json:"value"`
+	Headers   []passHeader `json:"headers,omitempty"`
+}
+
+type passHeader struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// passState stores the records of a topic's range on disk for analyses
+// that read them more than once, e.g. to count keys before reporting
+// duplicates. Records are consumed from Kafka once per partition, later
+// passes read them from disk. The manifest keys the state by topic and
+// ranges and tracks which partitions are stored completely, so that an
+// interrupted analysis that's started again with the same directory only
+// consumes the partitions it's missing, and analyses of the same range can
+// reuse the state.
+type passState struct {
+	sync.Mutex
+	dir      string
+	manifest passManifest
+}
+
+// openPassState opens the state of topic in dir, creating dir if it
+// doesn't exist. It fails if dir holds the state of another topic.
+func openPassState(dir, topic string) (*passState, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir err=%v", err)
+	}
+	s := &passState{dir: dir, manifest: passManifest{Version: passManifestVersion, Topic: topic}}
+
+	buf, err := ioutil.ReadFile(s.manifestPath())
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state manifest err=%v", err)
+	}
+	if err = json.Unmarshal(buf, &s.manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse state manifest %v err=%v", s.manifestPath(), err)
+	}
+	if s.manifest.Version != passManifestVersion {
+		return nil, fmt.Errorf("state dir %v has version %v, expected %v", dir, s.manifest.Version, passManifestVersion)
+	}
+	if s.manifest.Topic != topic {
+		return nil, fmt.Errorf("state dir %v holds topic %v rather than %v", dir, s.manifest.Topic, topic)
+	}
+	return s, nil
+}
+
+func (s *passState) manifestPath() string { return filepath.Join(s.dir, "manifest.json") }
+
+func (s *passState) recordsPath(partition int32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("partition-%v.jsonl", partition))
+}
+
+// plan sets the ranges to store. Ranges of an earlier run that match are
+// kept with their stored records, the other partitions are planned again,
+// e.g. when the topic grew or partitions were added since, so that their
+// stale records aren't reused. It returns the ranges in effect.
+func (s *passState) plan(ranges []passRange) ([]passRange, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	stored := map[int32]passRange{}
+	for _, r := range s.manifest.Ranges {
+		stored[r.Partition] = r
+	}
+	planned := []passRange{}
+	for _, r := range ranges {
+		if prev, ok := stored[r.Partition]; ok && prev.Start == r.Start && prev.End == r.End {
+			r = prev
+		}
+		planned = append(planned, r)
+	}
+	sort.Slice(planned, func(i, j int) bool { return planned[i].Partition < planned[j].Partition })
+
+	if s.manifest.Ranges == nil {
+		s.manifest.Created = time.Now().UTC()
+	}
+	s.manifest.Ranges = planned
+	return append([]passRange{}, planned...), s.save()
+}
+
+// store writes the records that fill passes to add to the partition's
+// record file and marks its range complete if fill succeeds. A failed or
+// interrupted fill leaves no records behind.
+func (s *passState) store(partition int32, fill func(add func(*sarama.ConsumerMessage) error) error) error {
+	tmp, err := ioutil.TempFile(s.dir, filepath.Base(s.recordsPath(partition))+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var (
+		w     = bufio.NewWriter(tmp)
+		enc   = json.NewEncoder(w)
+		count int64
+	)
+	err = fill(func(msg *sarama.ConsumerMessage) error {
+		r := passRecord{Offset: msg.Offset, Timestamp: msg.Timestamp, Key: msg.Key, Value: msg.Value}
+		for _, h := range msg.Headers {
+			r.Headers = append(r.Headers, passHeader{Key: h.Key, Value: h.Value})
+		}
+		count++
+		return enc.Encode(r)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.recordsPath(partition))
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for i, r := range s.manifest.Ranges {
+		if r.Partition == partition {
+			s.manifest.Ranges[i].Complete = true
+			s.manifest.Ranges[i].Records = count
+		}
+	}
+	return s.save()
+}
+
+// read passes the stored records of the partition to fn in offset order.
+func (s *passState) read(topic string, partition int32, fn func(*sarama.ConsumerMessage) error) error {
+	f, err := os.Open(s.recordsPath(partition))
+	if err != nil {
+		return err
+	}
+	defer logClose("state records", f)
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var r passRecord
+		if err = dec.Decode(&r); err != nil {
+			return fmt.Errorf("failed to read stored records of partition %v err=%v", partition, err)
+		}
+		msg := &sarama.ConsumerMessage{Topic: topic, Partition: partition, Offset: r.Offset, Timestamp: r.Timestamp, Key: r.Key, Value: r.Value}
+		for _, h := range r.Headers {
+			msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+		if err = fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// each runs a pass over the stored records of all complete partitions.
+func (s *passState) each(fn func(*sarama.ConsumerMessage) error) error {
+	s.Lock()
+	ranges := append([]passRange{}, s.manifest.Ranges...)
+	s.Unlock()
+
+	for _, r := range ranges {
+		if !r.Complete {
+			continue
+		}
+		if err := s.read(s.manifest.Topic, r.Partition, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save writes the manifest by renaming a temporary file, so an interrupted
+// save leaves the previous manifest intact. It's called with s locked.
+func (s *passState) save() error {
+	buf, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, "manifest.json.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(buf, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.manifestPath())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state manifest err=%v", err)
+	}
+	return nil
+}
+
+// resolvePassRanges reads the oldest and newest offsets of the partitions
+// of topic.
+func resolvePassRanges(client sarama.Client, topic string) ([]passRange, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	ranges := []passRange{}
+	for _, p := range partitions {
+		oldest, err := client.GetOffset(topic, p, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		newest, err := client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, passRange{Partition: p, Start: oldest, End: newest})
+	}
+	return ranges, nil
+}
+
+// consumePassRange passes the records of r to add. It stops early after
+// timeout without records, as the newest offsets may be transaction
+// markers, but fails if the range's start is no longer available, e.g. as
+// retention deleted it since the range was planned.
+func consumePassRange(consumer sarama.Consumer, topic string, r passRange, timeout time.Duration, add func(*sarama.ConsumerMessage) error) error {
+	if r.End <= r.Start {
+		return nil
+	}
+	pc, err := consumer.ConsumePartition(topic, r.Partition, r.Start)
+	if err != nil {
+		return err
+	}
+	defer logClose("partition consumer", pc)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+			if msg.Offset >= r.End {
+				return nil
+			}
+			if err := add(msg); err != nil {
+				return err
+			}
+			if msg.Offset >= r.End-1 {
+				return nil
+			}
+		case err := <-pc.Errors():
+			return err
+		case <-timer.C:
+			return nil
+		}
+	}
+}
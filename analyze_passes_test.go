@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-passes-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := openPassState(dir, "orders")
+	require.Nil(t, err)
+	ranges, err := s.plan([]passRange{{Partition: 1, Start: 5, End: 7}, {Partition: 0, Start: 0, End: 2}})
+	require.Nil(t, err)
+	require.Equal(t, []passRange{{Partition: 0, Start: 0, End: 2}, {Partition: 1, Start: 5, End: 7}}, ranges)
+
+	interrupted := errors.New("interrupted")
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = s.store(0, func(add func(*sarama.ConsumerMessage) error) error {
+		require.Nil(t, add(&sarama.ConsumerMessage{Offset: 0, Timestamp: ts, Key: []byte("k"), Value: []byte("v")}))
+		return add(&sarama.ConsumerMessage{Offset: 1, Timestamp: ts, Value: []byte("w"), Headers: []*sarama.RecordHeader{{Key: []byte("h"), Value: []byte("x")}}})
+	})
+	require.Nil(t, err)
+	err = s.store(1, func(add func(*sarama.ConsumerMessage) error) error {
+		require.Nil(t, add(&sarama.ConsumerMessage{Offset: 5}))
+		return interrupted
+	})
+	require.Equal(t, interrupted, err)
+
+	// a second run with the same ranges keeps the stored partition.
+	s, err = openPassState(dir, "orders")
+	require.Nil(t, err)
+	ranges, err = s.plan([]passRange{{Partition: 0, Start: 0, End: 2}, {Partition: 1, Start: 5, End: 7}})
+	require.Nil(t, err)
+	require.Equal(t, []passRange{{Partition: 0, Start: 0, End: 2, Complete: true, Records: 2}, {Partition: 1, Start: 5, End: 7}}, ranges)
+
+	var read []*sarama.ConsumerMessage
+	require.Nil(t, s.each(func(msg *sarama.ConsumerMessage) error {
+		read = append(read, msg)
+		return nil
+	}))
+	require.Equal(t, []*sarama.ConsumerMessage{
+		{Topic: "orders", Partition: 0, Offset: 0, Timestamp: ts, Key: []byte("k"), Value: []byte("v")},
+		{Topic: "orders", Partition: 0, Offset: 1, Timestamp: ts, Value: []byte("w"), Headers: []*sarama.RecordHeader{{Key: []byte("h"), Value: []byte("x")}}},
+	}, read)
+
+	// partitions that grew or were added since are planned again.
+	s, err = openPassState(dir, "orders")
+	require.Nil(t, err)
+	ranges, err = s.plan([]passRange{{Partition: 2, Start: 0, End: 3}, {Partition: 1, Start: 5, End: 7}, {Partition: 0, Start: 0, End: 10}})
+	require.Nil(t, err)
+	require.Equal(t, []passRange{{Partition: 0, Start: 0, End: 10}, {Partition: 1, Start: 5, End: 7}, {Partition: 2, Start: 0, End: 3}}, ranges)
+	require.Nil(t, s.each(func(msg *sarama.ConsumerMessage) error {
+		t.Fatalf("read stale record %#v", msg)
+		return nil
+	}))
+
+	// the new plan is saved rather than reverting to the stored partition.
+	s, err = openPassState(dir, "orders")
+	require.Nil(t, err)
+	ranges, err = s.plan([]passRange{{Partition: 0, Start: 0, End: 10}, {Partition: 1, Start: 5, End: 7}, {Partition: 2, Start: 0, End: 3}})
+	require.Nil(t, err)
+	require.False(t, ranges[0].Complete)
+
+	_, err = openPassState(dir, "payments")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIdleDuration(t *testing.T) {
+	data := []struct {
+		input       string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{input: "30d", expected: 30 * 24 * time.Hour},
+		{input: "1d12h", expected: 36 * time.Hour},
+		{input: "90m", expected: 90 * time.Minute},
+		{input: "d", expectedErr: true},
+		{input: "-1d", expectedErr: true},
+		{input: "1w", expectedErr: true},
+		{input: "", expected: 0},
+	}
+
+	for _, d := range data {
+		actual, err := parseIdleDuration(d.input)
+		if d.expectedErr {
+			require.NotNil(t, err, d.input)
+			continue
+		}
+		require.Nil(t, err, d.input)
+		require.Equal(t, d.expected, actual, d.input)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	a := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	b := a.Add(time.Hour)
+
+	require.Nil(t, latest(nil, nil))
+	require.Equal(t, &a, latest(&a, nil))
+	require.Equal(t, &b, latest(nil, &b))
+	require.Equal(t, &b, latest(&a, &b))
+	require.Equal(t, &b, latest(&b, &a))
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// anonymization replaces a field of input messages with a pseudonym derived
+// from its value, like mutation it names the field as key, value or
+// headers and a path within it. Arrays along the path apply to each element.
+type anonymization struct {
+	target string
+	path   []string
+	method string        // hash, mask-email, mask-phone or jitter
+	jitter time.Duration // maximum shift of jitter
+}
+
+const anonymizationMethods = "hash, mask-email, mask-phone and jitter:<duration>"
+
+// parseAnonymization parses -anonymize arguments like .value.email=mask-email,
+// .key=hash, .value.contacts[].phone=mask-phone, .value.birthDate=jitter:720h
+// or .headers.user-id=hash.
+func parseAnonymization(s string) (anonymization, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || !strings.HasPrefix(kv[0], ".") {
+		return anonymization{}, fmt.Errorf("invalid anonymization %#v, expected .path=method", s)
+	}
+
+	a := anonymization{method: kv[1]}
+	if strings.HasPrefix(a.method, "jitter:") {
+		d, err := time.ParseDuration(strings.TrimPrefix(a.method, "jitter:"))
+		if err != nil || d < time.Second {
+			return anonymization{}, fmt.Errorf("invalid anonymization %#v, expected a jitter of at least 1s like jitter:720h", s)
+		}
+		a.method, a.jitter = "jitter", d
+	}
+	switch a.method {
+	case "hash", "mask-email", "mask-phone", "jitter":
+	default:
+		return anonymization{}, fmt.Errorf("invalid anonymization %#v, supported methods are %v", s, anonymizationMethods)
+	}
+
+	p := strings.TrimPrefix(kv[0], ".")
+	if strings.HasPrefix(p, "headers.") {
+		a.target, a.path = "headers", []string{strings.TrimPrefix(p, "headers.")}
+		return a, nil
+	}
+	fields := strings.Split(p, ".")
+	a.target = fields[0]
+	for _, f := range fields[1:] {
+		if f = strings.TrimSuffix(f, "[]"); f == "" {
+			return anonymization{}, fmt.Errorf("invalid anonymization %#v, empty field name", s)
+		}
+		a.path = append(a.path, f)
+	}
+	if a.target != "key" && a.target != "value" {
+		return anonymization{}, fmt.Errorf("invalid anonymization %#v, only .key, .value and .headers can be anonymized", s)
+	}
+	return a, nil
+}
+
+// anonymizer applies the anonymizations of -anonymize with the secret
+// -anonymize-salt. The same input and salt always give the same pseudonym,
+// so references between records and topics still match after a copy, while
+// the original values can't be recovered or guessed without the salt. Like
+// consumeStats, a nil *anonymizer is valid and leaves messages as they are.
+type anonymizer struct {
+	salt  []byte
+	rules []anonymization
+}
+
+func newAnonymizer(salt string, rules []anonymization) *anonymizer {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &anonymizer{salt: []byte(salt), rules: rules}
+}
+
+// apply anonymizes the fields of msg. Fields that msg doesn't have are left
+// alone, fields within the key or value require it to be a JSON object.
+func (a *anonymizer) apply(msg *message) error {
+	if a == nil {
+		return nil
+	}
+	for _, r := range a.rules {
+		if err := a.applyRule(r, msg); err != nil {
+			return fmt.Errorf("failed to anonymize %v err=%v", r.target, err)
+		}
+	}
+	return nil
+}
+
+func (a *anonymizer) applyRule(r anonymization, msg *message) error {
+	// jitter shifts all dates of a key by the same amount, so durations
+	// between them stay the same.
+	seed := msg.Key
+
+	if r.target == "headers" {
+		v, ok := msg.Headers[r.path[0]]
+		if !ok || v == nil {
+			return nil
+		}
+		anon, err := a.anonymize(r, *v, seed)
+		if err != nil {
+			return err
+		}
+		str := fmt.Sprint(anon)
+		msg.Headers[r.path[0]] = &str
+		return nil
+	}
+
+	key := r.target == "key"
+	if len(r.path) == 0 {
+		str := msg.Value
+		if key {
+			str = msg.Key
+		}
+		if str == nil {
+			return nil
+		}
+		anon, err := a.anonymize(r, *str, seed)
+		if err != nil {
+			return err
+		}
+		result := fmt.Sprint(anon)
+		if key {
+			msg.Key = &result
+		} else {
+			msg.Value = &result
+		}
+		return nil
+	}
+
+	obj, err := transformTarget(msg, key)
+	if err != nil || obj == nil {
+		return err
+	}
+	if _, err = a.walk(obj, r, r.path, seed); err != nil {
+		return err
+	}
+	return setTransformTarget(msg, key, obj)
+}
+
+// walk anonymizes the values at path below v.
+func (a *anonymizer) walk(v interface{}, r anonymization, path []string, seed *string) (interface{}, error) {
+	if arr, ok := v.([]interface{}); ok {
+		for i, e := range arr {
+			var err error
+			if arr[i], err = a.walk(e, r, path, seed); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	if len(path) == 0 {
+		if v == nil {
+			return nil, nil
+		}
+		return a.anonymize(r, v, seed)
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v, nil
+	}
+	child, ok := obj[path[0]]
+	if !ok {
+		return v, nil
+	}
+	anon, err := a.walk(child, r, path[1:], seed)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path[0], err)
+	}
+	obj[path[0]] = anon
+	return obj, nil
+}
+
+// anonymize returns the pseudonym of v, a string or json.Number.
+func (a *anonymizer) anonymize(r anonymization, v interface{}, seed *string) (interface{}, error) {
+	switch r.method {
+	case "hash":
+		switch t := v.(type) {
+		case string:
+			return hex.EncodeToString(a.sum(t)[:16]), nil
+		case json.Number:
+			// a positive integer that's exact in JSON parsers using
+			// float64.
+			return json.Number(strconv.FormatUint(binary.BigEndian.Uint64(a.sum(t.String()))>>11, 10)), nil
+		}
+		return nil, fmt.Errorf("can only hash strings and numbers, found %T", v)
+	case "mask-email":
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("can only mask strings as emails, found %T", v)
+		}
+		return a.maskEmail(str), nil
+	case "mask-phone":
+		var str string
+		switch t := v.(type) {
+		case string:
+			str = t
+		case json.Number:
+			return json.Number(a.maskDigits(t.String())), nil
+		default:
+			return nil, fmt.Errorf("can only mask strings and numbers as phone numbers, found %T", v)
+		}
+		return a.maskDigits(str), nil
+	case "jitter":
+		s := fmt.Sprint(v)
+		if seed != nil {
+			s = *seed
+		}
+		return a.jitterDate(v, r.jitter, s)
+	}
+	return nil, fmt.Errorf("unsupported method %#v", r.method)
+}
+
+// sum is the HMAC-SHA256 of s with the salt.
+func (a *anonymizer) sum(s string) []byte {
+	h := hmac.New(sha256.New, a.salt)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// stream returns n pseudorandom bytes derived from s.
+func (a *anonymizer) stream(s string, n int) []byte {
+	result := []byte{}
+	for i := 0; len(result) < n; i++ {
+		result = append(result, a.sum(fmt.Sprintf("%v:%v", i, s))...)
+	}
+	return result[:n]
+}
+
+// maskChars replaces the letters and digits of s with others of the same
+// kind and case derived from key, other characters stay.
+func (a *anonymizer) maskChars(s, key string, letters bool) string {
+	var (
+		rs     = []rune(s)
+		random = a.stream(key, len(rs))
+	)
+	for i, c := range rs {
+		switch {
+		case c >= '0' && c <= '9':
+			rs[i] = '0' + rune(random[i]%10)
+		case letters && unicode.IsUpper(c):
+			rs[i] = 'A' + rune(random[i]%26)
+		case letters && unicode.IsLower(c):
+			rs[i] = 'a' + rune(random[i]%26)
+		}
+	}
+	return string(rs)
+}
+
+// maskEmail masks the local part and the domain of an email address except
+// its top level domain, e.g. jane.doe@example.com to xkqa.mrt@hbqwzpt.com.
+func (a *anonymizer) maskEmail(s string) string {
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return a.maskChars(s, s, true)
+	}
+	domain := s[at+1:]
+	tld := ""
+	if dot := strings.LastIndex(domain, "."); dot >= 0 {
+		domain, tld = domain[:dot], domain[dot:]
+	}
+	return a.maskChars(s[:at], s, true) + "@" + a.maskChars(domain, "@"+s, true) + tld
+}
+
+// maskDigits masks the digits of a phone number, keeping its length and
+// format, e.g. +1 (555) 123-4567 to +8 (203) 961-0475.
+func (a *anonymizer) maskDigits(s string) string {
+	masked := a.maskChars(s, s, false)
+	if strings.HasPrefix(masked, "0") && !strings.HasPrefix(s, "0") {
+		// keep numbers valid as JSON numbers.
+		masked = "1" + masked[1:]
+	}
+	return masked
+}
+
+// jitterLayouts are the date formats jitter recognizes in strings.
+var jitterLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
+// jitterDate shifts the date v by up to max in either direction, by an
+// amount derived from seed. Strings keep their format, numbers are
+// milliseconds since the epoch. Dates without time shift by whole days.
+func (a *anonymizer) jitterDate(v interface{}, max time.Duration, seed string) (interface{}, error) {
+	var (
+		n     = binary.BigEndian.Uint64(a.sum(seed))
+		secs  = int64(max / time.Second)
+		shift = time.Duration(int64(n%uint64(2*secs+1))-secs) * time.Second
+	)
+
+	switch t := v.(type) {
+	case json.Number:
+		ms, err := t.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("can only jitter integer milliseconds since the epoch, found %v", t)
+		}
+		return json.Number(strconv.FormatInt(ms+int64(shift/time.Millisecond), 10)), nil
+	case string:
+		for _, layout := range jitterLayouts {
+			d, err := time.Parse(layout, t)
+			if err != nil {
+				continue
+			}
+			if layout == "2006-01-02" {
+				shift = shift / (24 * time.Hour) * (24 * time.Hour)
+			}
+			return d.Add(shift).Format(layout), nil
+		}
+		return nil, fmt.Errorf("can only jitter dates like 2006-01-02 or RFC 3339 timestamps, found %#v", t)
+	}
+	return nil, fmt.Errorf("can only jitter strings and numbers, found %T", v)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnonymization(t *testing.T) {
+	a, err := parseAnonymization(".value.contacts[].email=mask-email")
+	require.NoError(t, err)
+	require.Equal(t, anonymization{target: "value", path: []string{"contacts", "email"}, method: "mask-email"}, a)
+
+	a, err = parseAnonymization(".key=hash")
+	require.NoError(t, err)
+	require.Equal(t, anonymization{target: "key", method: "hash"}, a)
+
+	a, err = parseAnonymization(".headers.user.id=jitter:720h")
+	require.NoError(t, err)
+	require.Equal(t, anonymization{target: "headers", path: []string{"user.id"}, method: "jitter", jitter: 720 * time.Hour}, a)
+
+	for _, s := range []string{"value.email=hash", ".value.email", ".value.email=encrypt", ".partition=hash", ".value..email=hash", ".value.born=jitter:1ms", ".value.born=jitter:x"} {
+		_, err = parseAnonymization(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestAnonymizerApply(t *testing.T) {
+	var disabled *anonymizer
+	require.NoError(t, disabled.apply(&message{}))
+	require.Nil(t, newAnonymizer("salt", nil))
+
+	rules := []anonymization{}
+	for _, s := range []string{".key=hash", ".value.customerId=hash", ".value.contacts[].email=mask-email", ".value.contacts[].phone=mask-phone", ".value.born=jitter:720h", ".value.since=jitter:1h", ".value.missing=hash", ".headers.user=hash"} {
+		r, err := parseAnonymization(s)
+		require.NoError(t, err)
+		rules = append(rules, r)
+	}
+	anonymize := func(salt, key, value string) (message, map[string]interface{}) {
+		user := "jane"
+		msg := message{Key: &key, Value: &value, Headers: map[string]*string{"user": &user}}
+		require.NoError(t, newAnonymizer(salt, rules).apply(&msg))
+		var v map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(*msg.Value))
+		dec.UseNumber()
+		require.NoError(t, dec.Decode(&v))
+		return msg, v
+	}
+
+	value := `{"customerId":"c-1","contacts":[{"email":"jane.doe@example.com","phone":"+1 (555) 123-4567"},{"email":null}],"born":"1980-05-17","since":1672531200000}`
+	msg, v := anonymize("secret", "c-1", value)
+	require.Len(t, *msg.Key, 32)
+	require.Equal(t, *msg.Key, v["customerId"])
+	require.NotEqual(t, "jane", *msg.Headers["user"])
+
+	contacts := v["contacts"].([]interface{})
+	email := contacts[0].(map[string]interface{})["email"].(string)
+	require.Regexp(t, regexp.MustCompile(`^[a-z]{4}\.[a-z]{3}@[a-z]{7}\.com$`), email)
+	require.NotEqual(t, "jane.doe@example.com", email)
+	require.Regexp(t, regexp.MustCompile(`^\+\d \(\d{3}\) \d{3}-\d{4}$`), contacts[0].(map[string]interface{})["phone"])
+	require.Nil(t, contacts[1].(map[string]interface{})["email"])
+
+	born, err := time.Parse("2006-01-02", v["born"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Date(1980, 5, 17, 0, 0, 0, 0, time.UTC), born, 720*time.Hour)
+	since, err := v["since"].(json.Number).Int64()
+	require.NoError(t, err)
+	require.InDelta(t, 1672531200000, since, float64(time.Hour/time.Millisecond))
+
+	again, _ := anonymize("secret", "c-1", value)
+	require.Equal(t, msg, again)
+	other, _ := anonymize("other", "c-1", value)
+	require.NotEqual(t, *msg.Key, *other.Key)
+
+	invalid := `{"contacts":[{"email":1}]}`
+	key := "k"
+	err = newAnonymizer("secret", rules).apply(&message{Key: &key, Value: &invalid})
+	require.Error(t, err)
+}
+
+func TestAnonymizerJitterDate(t *testing.T) {
+	a := newAnonymizer("secret", []anonymization{{target: "key", method: "hash"}})
+
+	ts, err := a.jitterDate("2023-01-02T03:04:05.123+02:00", 48*time.Hour, "c-1")
+	require.NoError(t, err)
+	parsed, err := time.Parse(time.RFC3339Nano, ts.(string))
+	require.NoError(t, err)
+	require.Equal(t, 123*time.Millisecond, time.Duration(parsed.Nanosecond()))
+	_, offset := parsed.Zone()
+	require.Equal(t, 2*60*60, offset)
+
+	// the same seed shifts by the same amount.
+	a1, err := a.jitterDate("2023-01-02", 240*time.Hour, "c-1")
+	require.NoError(t, err)
+	a2, err := a.jitterDate("2023-01-12", 240*time.Hour, "c-1")
+	require.NoError(t, err)
+	d1, _ := time.Parse("2006-01-02", a1.(string))
+	d2, _ := time.Parse("2006-01-02", a2.(string))
+	require.Equal(t, 240*time.Hour, d2.Sub(d1))
+
+	_, err = a.jitterDate("yesterday", time.Hour, "c-1")
+	require.Error(t, err)
+	_, err = a.jitterDate(json.Number("1.5"), time.Hour, "c-1")
+	require.Error(t, err)
+}
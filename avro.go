@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// avroSchema is a parsed Avro schema. Named types that are referenced more
+// than once, e.g. in recursive records, share the same *avroSchema.
+type avroSchema struct {
+	typ      string // primitive type name, record, enum, array, map, union or fixed
+	name     string // full name of records, enums and fixed
+	fields   []*avroField
+	symbols  []string
+	enumDef  string
+	items    *avroSchema
+	values   *avroSchema
+	branches []*avroSchema
+	size     int
+}
+
+type avroField struct {
+	name       string
+	aliases    []string
+	schema     *avroSchema
+	def        interface{}
+	hasDefault bool
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+func parseAvroSchema(str string) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(str), &v); err != nil {
+		// a schema can also be a bare primitive type name.
+		v = str
+	}
+	return parseAvroType(v, "", map[string]*avroSchema{})
+}
+
+func parseAvroType(v interface{}, namespace string, names map[string]*avroSchema) (*avroSchema, error) {
+	switch t := v.(type) {
+	case string:
+		if avroPrimitives[t] {
+			return &avroSchema{typ: t}, nil
+		}
+		if s, ok := names[avroFullName(t, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := names[t]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown Avro type %#v", t)
+
+	case []interface{}:
+		s := &avroSchema{typ: "union"}
+		for _, b := range t {
+			bs, err := parseAvroType(b, namespace, names)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, bs)
+		}
+		return s, nil
+
+	case map[string]interface{}:
+		return parseAvroComplex(t, namespace, names)
+	}
+
+	return nil, fmt.Errorf("invalid Avro schema %v", v)
+}
+
+func parseAvroComplex(m map[string]interface{}, namespace string, names map[string]*avroSchema) (*avroSchema, error) {
+	typ, ok := m["type"].(string)
+	if !ok {
+		// e.g. {"type": {"type": "array", "items": "int"}}
+		return parseAvroType(m["type"], namespace, names)
+	}
+
+	s := &avroSchema{typ: typ}
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, _ := m["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("missing name for Avro %v", typ)
+		}
+		if ns, ok := m["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		s.name = avroFullName(name, namespace)
+		if i := strings.LastIndex(s.name, "."); i >= 0 {
+			namespace = s.name[:i]
+		} else {
+			namespace = ""
+		}
+		names[s.name] = s
+	}
+
+	switch typ {
+	case "record", "error":
+		s.typ = "record"
+		fields, _ := m["fields"].([]interface{})
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid field in Avro record %v", s.name)
+			}
+			name, _ := fm["name"].(string)
+			fs, err := parseAvroType(fm["type"], namespace, names)
+			if err != nil {
+				return nil, fmt.Errorf("invalid type for field %v of record %v: %v", name, s.name, err)
+			}
+			field := &avroField{name: name, schema: fs}
+			field.def, field.hasDefault = fm["default"]
+			aliases, _ := fm["aliases"].([]interface{})
+			for _, a := range aliases {
+				if str, ok := a.(string); ok {
+					field.aliases = append(field.aliases, str)
+				}
+			}
+			s.fields = append(s.fields, field)
+		}
+
+	case "enum":
+		symbols, _ := m["symbols"].([]interface{})
+		for _, sym := range symbols {
+			str, _ := sym.(string)
+			s.symbols = append(s.symbols, str)
+		}
+		s.enumDef, _ = m["default"].(string)
+
+	case "fixed":
+		size, ok := m["size"].(float64)
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("invalid size for Avro fixed %v", s.name)
+		}
+		s.size = int(size)
+
+	case "array":
+		items, err := parseAvroType(m["items"], namespace, names)
+		if err != nil {
+			return nil, err
+		}
+		s.items = items
+
+	case "map":
+		values, err := parseAvroType(m["values"], namespace, names)
+		if err != nil {
+			return nil, err
+		}
+		s.values = values
+
+	default:
+		if !avroPrimitives[typ] {
+			return parseAvroType(typ, namespace, names)
+		}
+		// primitive with attributes like logicalType, decoded as the
+		// underlying type.
+	}
+
+	return s, nil
+}
+
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// unionName names a union branch the way the Avro JSON encoding does.
+func (s *avroSchema) unionName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.typ
+}
+
+// decode decodes Avro binary data into values that marshal to the Avro JSON
+// encoding, i.e. non-null union values are wrapped in an object with the
+// branch's type name as the only key.
+func (s *avroSchema) decode(data []byte) (interface{}, error) {
+	r := &avroReader{buf: data}
+	v, err := r.read(s)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.buf) {
+		return nil, fmt.Errorf("%v trailing bytes after decoding Avro data", len(r.buf)-r.pos)
+	}
+	return v, nil
+}
+
+type avroReader struct {
+	buf []byte
+	pos int
+}
+
+var errAvroShortBuffer = fmt.Errorf("unexpected end of Avro data")
+
+func (r *avroReader) long() (int64, error) {
+	v, n := binary.Varint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errAvroShortBuffer
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *avroReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.buf) {
+		return nil, errAvroShortBuffer
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *avroReader) bytes() ([]byte, error) {
+	n, err := r.long()
+	if err != nil {
+		return nil, err
+	}
+	return r.next(int(n))
+}
+
+func (r *avroReader) read(s *avroSchema) (interface{}, error) {
+	switch s.typ {
+	case "null":
+		return nil, nil
+
+	case "boolean":
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] == 1, nil
+
+	case "int":
+		v, err := r.long()
+		return int32(v), err
+
+	case "long":
+		return r.long()
+
+	case "float":
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+
+	case "double":
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+
+	case "string":
+		b, err := r.bytes()
+		return string(b), err
+
+	case "bytes":
+		b, err := r.bytes()
+		return avroBytesString(b), err
+
+	case "fixed":
+		b, err := r.next(s.size)
+		return avroBytesString(b), err
+
+	case "enum":
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(s.symbols) {
+			return nil, fmt.Errorf("invalid index %v for enum %v", i, s.name)
+		}
+		return s.symbols[i], nil
+
+	case "record":
+		rec := map[string]interface{}{}
+		for _, f := range s.fields {
+			v, err := r.read(f.schema)
+			if err != nil {
+				return nil, err
+			}
+			rec[f.name] = v
+		}
+		return rec, nil
+
+	case "array":
+		arr := []interface{}{}
+		err := r.blocks(func() error {
+			v, err := r.read(s.items)
+			arr = append(arr, v)
+			return err
+		})
+		return arr, err
+
+	case "map":
+		m := map[string]interface{}{}
+		err := r.blocks(func() error {
+			k, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			v, err := r.read(s.values)
+			m[string(k)] = v
+			return err
+		})
+		return m, err
+
+	case "union":
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(s.branches) {
+			return nil, fmt.Errorf("invalid union branch %v", i)
+		}
+		b := s.branches[i]
+		v, err := r.read(b)
+		if err != nil || b.typ == "null" {
+			return nil, err
+		}
+		return map[string]interface{}{b.unionName(): v}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported Avro type %v", s.typ)
+}
+
+// blocks reads the blocks of an array or map, calling item for every item.
+func (r *avroReader) blocks(item func() error) error {
+	for {
+		n, err := r.long()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if n < 0 {
+			// negative counts are followed by the block's size in bytes.
+			n = -n
+			if _, err := r.long(); err != nil {
+				return err
+			}
+		}
+		for i := int64(0); i < n; i++ {
+			if err := item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// avroBytesString maps every byte to the code point of the same value as the
+// Avro JSON encoding does for bytes and fixed.
+func avroBytesString(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// encodeJSON encodes the Avro JSON encoding of a value into Avro binary
+// data. Union values may also be passed without the wrapping object, the
+// first branch that accepts the value is used then.
+func (s *avroSchema) encodeJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := avroEncode(&buf, s, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func avroWriteLong(buf *bytes.Buffer, v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutVarint(b, v)])
+}
+
+func avroWriteBytes(buf *bytes.Buffer, b []byte) {
+	avroWriteLong(buf, int64(len(b)))
+	buf.Write(b)
+}
+
+func avroNumber(v interface{}) (float64, int64, bool, error) {
+	switch n := v.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return float64(i), i, true, nil
+		}
+		f, err := n.Float64()
+		return f, int64(f), false, err
+	case float64:
+		return n, int64(n), n == math.Trunc(n), nil
+	}
+	return 0, 0, false, fmt.Errorf("expected number, got %v", v)
+}
+
+func avroEncode(buf *bytes.Buffer, s *avroSchema, v interface{}) error {
+	switch s.typ {
+	case "null":
+		if v != nil {
+			return fmt.Errorf("expected null, got %v", v)
+		}
+		return nil
+
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expected boolean, got %v", v)
+		}
+		if b {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		return nil
+
+	case "int", "long":
+		_, i, integral, err := avroNumber(v)
+		if err != nil {
+			return err
+		}
+		if !integral {
+			return fmt.Errorf("expected integer for %v, got %v", s.typ, v)
+		}
+		if s.typ == "int" && (i < math.MinInt32 || i > math.MaxInt32) {
+			return fmt.Errorf("%v overflows int", i)
+		}
+		avroWriteLong(buf, i)
+		return nil
+
+	case "float":
+		f, _, _, err := avroNumber(v)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(f)))
+		buf.Write(b)
+		return nil
+
+	case "double":
+		f, _, _, err := avroNumber(v)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+		buf.Write(b)
+		return nil
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got %v", v)
+		}
+		avroWriteBytes(buf, []byte(str))
+		return nil
+
+	case "bytes", "fixed":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected string for %v, got %v", s.typ, v)
+		}
+		b := []byte(avroStringBytes(str))
+		if s.typ == "fixed" {
+			if len(b) != s.size {
+				return fmt.Errorf("expected %v bytes for fixed %v, got %v", s.size, s.name, len(b))
+			}
+			buf.Write(b)
+			return nil
+		}
+		avroWriteBytes(buf, b)
+		return nil
+
+	case "enum":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected enum symbol, got %v", v)
+		}
+		for i, sym := range s.symbols {
+			if sym == str {
+				avroWriteLong(buf, int64(i))
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown symbol %v for enum %v", str, s.name)
+
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected array, got %v", v)
+		}
+		if len(items) > 0 {
+			avroWriteLong(buf, int64(len(items)))
+			for _, item := range items {
+				if err := avroEncode(buf, s.items, item); err != nil {
+					return err
+				}
+			}
+		}
+		avroWriteLong(buf, 0)
+		return nil
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected object, got %v", v)
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			avroWriteLong(buf, int64(len(keys)))
+			for _, k := range keys {
+				avroWriteBytes(buf, []byte(k))
+				if err := avroEncode(buf, s.values, m[k]); err != nil {
+					return err
+				}
+			}
+		}
+		avroWriteLong(buf, 0)
+		return nil
+
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected object for record %v, got %v", s.name, v)
+		}
+		for _, f := range s.fields {
+			fv, ok := m[f.name]
+			if !ok {
+				if !f.hasDefault {
+					return fmt.Errorf("missing field %v of record %v", f.name, s.name)
+				}
+				fv = f.def
+			}
+			if err := avroEncode(buf, f.schema, fv); err != nil {
+				return fmt.Errorf("field %v: %v", f.name, err)
+			}
+		}
+		return nil
+
+	case "union":
+		return avroEncodeUnion(buf, s, v)
+	}
+
+	return fmt.Errorf("unsupported Avro type %v", s.typ)
+}
+
+func avroEncodeUnion(buf *bytes.Buffer, s *avroSchema, v interface{}) error {
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+		for name, bv := range m {
+			for i, b := range s.branches {
+				if b.unionName() == name || (b.name != "" && avroShortName(b.name) == name) {
+					avroWriteLong(buf, int64(i))
+					return avroEncode(buf, b, bv)
+				}
+			}
+		}
+	}
+
+	for i, b := range s.branches {
+		var bb bytes.Buffer
+		if err := avroEncode(&bb, b, v); err == nil {
+			avroWriteLong(buf, int64(i))
+			buf.Write(bb.Bytes())
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no union branch matches %v", v)
+}
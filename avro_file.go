@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var avroFileMagic = []byte("Obj\x01")
+
+// readAvroFile decodes the records of an Avro object container file, calling
+// record for each of them with values like avroSchema.decode returns. It
+// supports the null, deflate, snappy and zstandard codecs.
+func readAvroFile(data []byte, record func(interface{}) error) error {
+	if !bytes.HasPrefix(data, avroFileMagic) {
+		return fmt.Errorf("not an Avro object container file")
+	}
+	r := &avroReader{buf: data, pos: len(avroFileMagic)}
+
+	meta := map[string][]byte{}
+	err := r.blocks(func() error {
+		k, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		v, err := r.bytes()
+		meta[string(k)] = v
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("invalid header err=%v", err)
+	}
+	schema, err := parseAvroSchema(string(meta["avro.schema"]))
+	if err != nil {
+		return fmt.Errorf("invalid schema err=%v", err)
+	}
+	codec := string(meta["avro.codec"])
+	sync, err := r.next(16)
+	if err != nil {
+		return err
+	}
+
+	for r.pos < len(r.buf) {
+		count, err := r.long()
+		if err != nil {
+			return err
+		}
+		block, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		if block, err = avroFileBlock(codec, block); err != nil {
+			return err
+		}
+		br := &avroReader{buf: block}
+		for i := int64(0); i < count; i++ {
+			v, err := br.read(schema)
+			if err != nil {
+				return err
+			}
+			if err = record(v); err != nil {
+				return err
+			}
+		}
+		marker, err := r.next(16)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(marker, sync) {
+			return fmt.Errorf("invalid sync marker after block")
+		}
+	}
+	return nil
+}
+
+// avroFileBlock decompresses a block of an object container file.
+func avroFileBlock(codec string, block []byte) ([]byte, error) {
+	switch codec {
+	case "", "null":
+		return block, nil
+	case "deflate":
+		return ioutil.ReadAll(flate.NewReader(bytes.NewReader(block)))
+	case "snappy":
+		// the block is followed by the CRC32 of the uncompressed data.
+		if len(block) < 4 {
+			return nil, fmt.Errorf("invalid snappy block")
+		}
+		data, err := snappy.Decode(nil, block[:len(block)-4])
+		if err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(block[len(block)-4:]) {
+			return nil, fmt.Errorf("invalid checksum of snappy block")
+		}
+		return data, nil
+	case "zstandard":
+		d, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		return d.DecodeAll(block, nil)
+	}
+	return nil, fmt.Errorf("unsupported Avro codec %#v", codec)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+)
+
+// avroFile builds an object container file of a block with the encoded
+// records, compressed with codec.
+func avroFile(t *testing.T, schema, codec string, records ...[]byte) []byte {
+	sync := []byte("0123456789abcdef")
+	block := concat(records...)
+	switch codec {
+	case "deflate":
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		require.NoError(t, err)
+		w.Write(block)
+		require.NoError(t, w.Close())
+		block = buf.Bytes()
+	case "snappy":
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(block))
+		block = append(snappy.Encode(nil, block), crc...)
+	}
+	return concat(
+		avroFileMagic,
+		avroLong(2), avroStr("avro.schema"), avroStr(schema), avroStr("avro.codec"), avroStr(codec), avroLong(0),
+		sync,
+		avroLong(int64(len(records))), avroLong(int64(len(block))), block,
+		sync,
+	)
+}
+
+func TestReadAvroFile(t *testing.T) {
+	schema := `{"type": "record", "name": "Order", "fields": [{"name": "id", "type": "long"}, {"name": "customer", "type": ["null", "string"]}]}`
+	records := [][]byte{
+		concat(avroLong(1), avroLong(1), avroStr("hans")),
+		concat(avroLong(2), avroLong(0)),
+	}
+	expected := []interface{}{
+		map[string]interface{}{"id": int64(1), "customer": map[string]interface{}{"string": "hans"}},
+		map[string]interface{}{"id": int64(2), "customer": nil},
+	}
+
+	for _, codec := range []string{"null", "deflate", "snappy"} {
+		var actual []interface{}
+		err := readAvroFile(avroFile(t, schema, codec, records...), func(v interface{}) error {
+			actual = append(actual, v)
+			return nil
+		})
+		require.NoError(t, err, codec)
+		require.Equal(t, expected, actual, codec)
+	}
+
+	data := avroFile(t, schema, "null", records...)
+	data[len(data)-1] = 'x'
+	require.Error(t, readAvroFile(data, func(interface{}) error { return nil }), "invalid sync marker")
+	require.Error(t, readAvroFile([]byte(`{"id": 1}`), func(interface{}) error { return nil }))
+}
@@ -0,0 +1,92 @@
+package main
+
+import "fmt"
+
+// avroNaming controls the names in the JSON of decoded Avro data. By
+// default it's the Avro JSON encoding: union values are wrapped in an object
+// keyed by the branch's full name and record fields use their names. With
+// short, union branches are named without namespace, with aliases record
+// fields are named by their first alias if they have one.
+type avroNaming struct {
+	short   bool
+	aliases bool
+}
+
+func parseAvroNames(s string) (bool, error) {
+	switch s {
+	case "full":
+		return false, nil
+	case "short":
+		return true, nil
+	}
+	return false, fmt.Errorf("unsupported avro-names argument %#v, only full and short are supported", s)
+}
+
+// rename applies the naming to v, which was decoded with schema s.
+func (n avroNaming) rename(s *avroSchema, v interface{}) interface{} {
+	if n == (avroNaming{}) || v == nil {
+		return v
+	}
+
+	switch s.typ {
+	case "record":
+		rec, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		out := make(map[string]interface{}, len(rec))
+		for _, f := range s.fields {
+			fv, ok := rec[f.name]
+			if !ok {
+				continue
+			}
+			name := f.name
+			if n.aliases && len(f.aliases) > 0 {
+				name = f.aliases[0]
+			}
+			out[name] = n.rename(f.schema, fv)
+		}
+		return out
+
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		out := make([]interface{}, len(arr))
+		for i, item := range arr {
+			out[i] = n.rename(s.items, item)
+		}
+		return out
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		out := make(map[string]interface{}, len(m))
+		for k, item := range m {
+			out[k] = n.rename(s.values, item)
+		}
+		return out
+
+	case "union":
+		wrapped, ok := v.(map[string]interface{})
+		if !ok || len(wrapped) != 1 {
+			return v
+		}
+		for _, b := range s.branches {
+			bv, ok := wrapped[b.unionName()]
+			if !ok {
+				continue
+			}
+			name := b.unionName()
+			if n.short && b.name != "" {
+				name = avroShortName(b.name)
+			}
+			return map[string]interface{}{name: n.rename(b, bv)}
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvroNamingRename(t *testing.T) {
+	schema, err := parseAvroSchema(`{
+  "type": "record",
+  "name": "Order",
+  "namespace": "com.shop",
+  "fields": [
+    {"name": "id", "type": "long", "aliases": ["order_id"]},
+    {"name": "address", "type": ["null", {"type": "record", "name": "Address", "fields": [
+      {"name": "zip", "type": "string", "aliases": ["postcode"]}
+    ]}]},
+    {"name": "items", "type": {"type": "array", "items": ["null", "string"]}}
+  ]
+}`)
+	require.NoError(t, err)
+
+	data := concat(
+		avroLong(23),
+		avroLong(1), avroStr("8000"),
+		avroLong(2), avroLong(1), avroStr("a"), avroLong(0), avroLong(0),
+	)
+	decoded, err := schema.decode(data)
+	require.NoError(t, err)
+
+	require.Equal(t, decoded, avroNaming{}.rename(schema, decoded))
+
+	require.Equal(t, map[string]interface{}{
+		"id":      int64(23),
+		"address": map[string]interface{}{"Address": map[string]interface{}{"zip": "8000"}},
+		"items":   []interface{}{map[string]interface{}{"string": "a"}, nil},
+	}, avroNaming{short: true}.rename(schema, decoded))
+
+	require.Equal(t, map[string]interface{}{
+		"order_id": int64(23),
+		"address":  map[string]interface{}{"com.shop.Address": map[string]interface{}{"postcode": "8000"}},
+		"items":    []interface{}{map[string]interface{}{"string": "a"}, nil},
+	}, avroNaming{aliases: true}.rename(schema, decoded))
+}
+
+func TestParseAvroNames(t *testing.T) {
+	short, err := parseAvroNames("full")
+	require.NoError(t, err)
+	require.False(t, short)
+
+	short, err = parseAvroNames("short")
+	require.NoError(t, err)
+	require.True(t, short)
+
+	_, err = parseAvroNames("none")
+	require.Error(t, err)
+}
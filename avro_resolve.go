@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// decodeAs decodes data written with schema s as seen through the reader
+// schema, following Avro schema resolution: writer fields that the reader
+// lacks are skipped, reader fields that the writer lacks get their default,
+// and numeric types are promoted.
+func (s *avroSchema) decodeAs(reader *avroSchema, data []byte) (interface{}, error) {
+	r := &avroReader{buf: data}
+	v, err := r.readAs(s, reader)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.buf) {
+		return nil, fmt.Errorf("%v trailing bytes after decoding Avro data", len(r.buf)-r.pos)
+	}
+	return v, nil
+}
+
+func (r *avroReader) readAs(w, rd *avroSchema) (interface{}, error) {
+	if w.typ == "union" {
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(w.branches) {
+			return nil, fmt.Errorf("invalid union branch %v", i)
+		}
+		return r.readAs(w.branches[i], rd)
+	}
+
+	if rd.typ == "union" {
+		for _, b := range rd.branches {
+			if !avroMatches(w, b) {
+				continue
+			}
+			v, err := r.readAs(w, b)
+			if err != nil || b.typ == "null" {
+				return nil, err
+			}
+			return map[string]interface{}{b.unionName(): v}, nil
+		}
+		return nil, fmt.Errorf("no branch of reader union matches writer type %v", w.unionName())
+	}
+
+	if !avroMatches(w, rd) {
+		return nil, fmt.Errorf("writer type %v does not match reader type %v", w.unionName(), rd.unionName())
+	}
+
+	switch rd.typ {
+	case "long", "float", "double":
+		v, err := r.read(w)
+		if err != nil {
+			return nil, err
+		}
+		return avroPromote(v, rd.typ), nil
+
+	case "string", "bytes":
+		v, err := r.read(w)
+		if err != nil || w.typ == rd.typ {
+			return v, err
+		}
+		// promoted between string and bytes, i.e. reinterpret the raw bytes.
+		if rd.typ == "string" {
+			return avroStringBytes(v.(string)), nil
+		}
+		return avroBytesString([]byte(v.(string))), nil
+
+	case "enum":
+		v, err := r.read(w)
+		if err != nil {
+			return nil, err
+		}
+		for _, sym := range rd.symbols {
+			if sym == v {
+				return v, nil
+			}
+		}
+		if rd.enumDef != "" {
+			return rd.enumDef, nil
+		}
+		return nil, fmt.Errorf("symbol %v is unknown to reader enum %v", v, rd.name)
+
+	case "array":
+		arr := []interface{}{}
+		err := r.blocks(func() error {
+			v, err := r.readAs(w.items, rd.items)
+			arr = append(arr, v)
+			return err
+		})
+		return arr, err
+
+	case "map":
+		m := map[string]interface{}{}
+		err := r.blocks(func() error {
+			k, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			v, err := r.readAs(w.values, rd.values)
+			m[string(k)] = v
+			return err
+		})
+		return m, err
+
+	case "record":
+		rec := map[string]interface{}{}
+		for _, wf := range w.fields {
+			rf := rd.field(wf.name)
+			if rf == nil {
+				if _, err := r.read(wf.schema); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			v, err := r.readAs(wf.schema, rf.schema)
+			if err != nil {
+				return nil, fmt.Errorf("field %v: %v", rf.name, err)
+			}
+			rec[rf.name] = v
+		}
+		for _, rf := range rd.fields {
+			if _, ok := rec[rf.name]; ok {
+				continue
+			}
+			if w.field(rf.name) != nil || rf.hasAlias(w) {
+				continue
+			}
+			if !rf.hasDefault {
+				return nil, fmt.Errorf("reader field %v is missing in writer schema and has no default", rf.name)
+			}
+			v, err := avroDefault(rf.schema, rf.def)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default for field %v: %v", rf.name, err)
+			}
+			rec[rf.name] = v
+		}
+		return rec, nil
+	}
+
+	return r.read(w)
+}
+
+// field finds the field with the given name or alias.
+func (s *avroSchema) field(name string) *avroField {
+	for _, f := range s.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	for _, f := range s.fields {
+		for _, a := range f.aliases {
+			if a == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+func (f *avroField) hasAlias(w *avroSchema) bool {
+	for _, a := range f.aliases {
+		if w.field(a) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// avroMatches reports whether data of writer type w can be read as reader
+// type rd, see "Schema Resolution" in the Avro specification.
+func avroMatches(w, rd *avroSchema) bool {
+	if rd.typ == "union" {
+		for _, b := range rd.branches {
+			if avroMatches(w, b) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch rd.typ {
+	case "long":
+		return w.typ == "long" || w.typ == "int"
+	case "float":
+		return w.typ == "float" || w.typ == "long" || w.typ == "int"
+	case "double":
+		return w.typ == "double" || w.typ == "float" || w.typ == "long" || w.typ == "int"
+	case "string", "bytes":
+		return w.typ == "string" || w.typ == "bytes"
+	case "record", "enum":
+		return w.typ == rd.typ && avroShortName(w.name) == avroShortName(rd.name)
+	case "fixed":
+		return w.typ == "fixed" && w.size == rd.size && avroShortName(w.name) == avroShortName(rd.name)
+	}
+
+	return w.typ == rd.typ
+}
+
+func avroShortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func avroPromote(v interface{}, typ string) interface{} {
+	var f float64
+	switch n := v.(type) {
+	case int32:
+		if typ == "long" {
+			return int64(n)
+		}
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case float32:
+		f = float64(n)
+	default:
+		return v
+	}
+
+	if typ == "float" {
+		return float32(f)
+	}
+	return f
+}
+
+// avroStringBytes reverses avroBytesString.
+func avroStringBytes(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+// avroDefault converts a field default from the schema's JSON into the
+// decoded representation. Defaults of unions refer to the first branch.
+func avroDefault(s *avroSchema, v interface{}) (interface{}, error) {
+	switch s.typ {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected boolean, got %v", v)
+		}
+		return b, nil
+	case "int", "long", "float", "double":
+		n, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected number, got %v", v)
+		}
+		switch s.typ {
+		case "int":
+			return int32(n), nil
+		case "long":
+			return int64(n), nil
+		case "float":
+			return float32(n), nil
+		}
+		return n, nil
+	case "string", "bytes", "fixed", "enum":
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %v", v)
+		}
+		return str, nil
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected array, got %v", v)
+		}
+		arr := []interface{}{}
+		for _, item := range items {
+			d, err := avroDefault(s.items, item)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, d)
+		}
+		return arr, nil
+	case "map":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected object, got %v", v)
+		}
+		m := map[string]interface{}{}
+		for k, val := range values {
+			d, err := avroDefault(s.values, val)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = d
+		}
+		return m, nil
+	case "record":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected object, got %v", v)
+		}
+		rec := map[string]interface{}{}
+		for _, f := range s.fields {
+			val, ok := values[f.name]
+			if !ok {
+				if !f.hasDefault {
+					return nil, fmt.Errorf("missing value for field %v", f.name)
+				}
+				val = f.def
+			}
+			d, err := avroDefault(f.schema, val)
+			if err != nil {
+				return nil, err
+			}
+			rec[f.name] = d
+		}
+		return rec, nil
+	case "union":
+		if len(s.branches) == 0 {
+			return nil, fmt.Errorf("empty union")
+		}
+		first := s.branches[0]
+		d, err := avroDefault(first, v)
+		if err != nil || first.typ == "null" {
+			return nil, err
+		}
+		return map[string]interface{}{first.unionName(): d}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported Avro type %v", s.typ)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func avroLong(v int64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutVarint(b, v)]
+}
+
+func avroStr(s string) []byte {
+	return append(avroLong(int64(len(s))), s...)
+}
+
+func concat(bs ...[]byte) []byte {
+	var r []byte
+	for _, b := range bs {
+		r = append(r, b...)
+	}
+	return r
+}
+
+func TestAvroDecodeRecord(t *testing.T) {
+	schema, err := parseAvroSchema(`{
+  "type": "record",
+  "name": "Order",
+  "namespace": "com.example",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "customer", "type": ["null", "string"]},
+    {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "SHIPPED"]}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "attrs", "type": {"type": "map", "values": "int"}},
+    {"name": "price", "type": "double"},
+    {"name": "paid", "type": "boolean"},
+    {"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 2}},
+    {"name": "next", "type": ["null", "Order"]}
+  ]
+}`)
+	require.Nil(t, err)
+
+	price := make([]byte, 8)
+	binary.LittleEndian.PutUint64(price, math.Float64bits(9.5))
+
+	data := concat(
+		avroLong(23),
+		avroLong(1), avroStr("alice"),
+		avroLong(1),
+		avroLong(2), avroStr("a"), avroStr("b"), avroLong(0),
+		avroLong(-1), avroLong(2), avroStr("x"), avroLong(7), avroLong(0),
+		price,
+		[]byte{1},
+		[]byte{0x41, 0xff},
+		avroLong(1),
+		// nested order
+		avroLong(24), avroLong(0), avroLong(0), avroLong(0), avroLong(0), price, []byte{0}, []byte{0, 0}, avroLong(0),
+	)
+
+	actual, err := schema.decode(data)
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{
+		"id":       int64(23),
+		"customer": map[string]interface{}{"string": "alice"},
+		"status":   "SHIPPED",
+		"tags":     []interface{}{"a", "b"},
+		"attrs":    map[string]interface{}{"x": int32(7)},
+		"price":    9.5,
+		"paid":     true,
+		"hash":     "Aÿ",
+		"next": map[string]interface{}{"com.example.Order": map[string]interface{}{
+			"id":       int64(24),
+			"customer": nil,
+			"status":   "NEW",
+			"tags":     []interface{}{},
+			"attrs":    map[string]interface{}{},
+			"price":    9.5,
+			"paid":     false,
+			"hash":     "\u0000\u0000",
+			"next":     nil,
+		}},
+	}, actual)
+
+	_, err = schema.decode(data[:10])
+	require.NotNil(t, err)
+
+	_, err = schema.decode(append(data, 0))
+	require.NotNil(t, err)
+}
+
+func TestParseAvroSchemaErrors(t *testing.T) {
+	for _, s := range []string{
+		`"unknown"`,
+		`{"type": "record", "fields": []}`,
+		`{"type": "record", "name": "r", "fields": [{"name": "f", "type": "Missing"}]}`,
+		`{"type": "fixed", "name": "f"}`,
+	} {
+		_, err := parseAvroSchema(s)
+		require.NotNil(t, err, s)
+	}
+
+	schema, err := parseAvroSchema(`"string"`)
+	require.Nil(t, err)
+	actual, err := schema.decode(avroStr("hi"))
+	require.Nil(t, err)
+	require.Equal(t, "hi", actual)
+}
+
+func TestAvroDecodeAs(t *testing.T) {
+	writer, err := parseAvroSchema(`{"type": "record", "name": "v1.User", "fields": [
+  {"name": "id", "type": "int"},
+  {"name": "name", "type": "string"},
+  {"name": "legacy", "type": {"type": "array", "items": "long"}},
+  {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["LOW", "HIGH", "ULTRA"]}}
+]}`)
+	require.Nil(t, err)
+
+	reader, err := parseAvroSchema(`{"type": "record", "name": "v2.User", "fields": [
+  {"name": "id", "type": "double"},
+  {"name": "fullName", "aliases": ["name"], "type": ["null", "string"]},
+  {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["LOW", "HIGH"], "default": "LOW"}},
+  {"name": "email", "type": ["null", "string"], "default": null},
+  {"name": "tags", "type": {"type": "map", "values": "int"}, "default": {"a": 1}}
+]}`)
+	require.Nil(t, err)
+
+	data := concat(avroLong(7), avroStr("Arni"), avroLong(1), avroLong(5), avroLong(0), avroLong(2))
+
+	actual, err := writer.decodeAs(reader, data)
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{
+		"id":       float64(7),
+		"fullName": map[string]interface{}{"string": "Arni"},
+		"level":    "LOW",
+		"email":    nil,
+		"tags":     map[string]interface{}{"a": int32(1)},
+	}, actual)
+
+	strict, err := parseAvroSchema(`{"type": "record", "name": "User", "fields": [
+  {"name": "id", "type": "int"},
+  {"name": "required", "type": "string"}
+]}`)
+	require.Nil(t, err)
+	_, err = writer.decodeAs(strict, data)
+	require.NotNil(t, err)
+
+	mismatch, err := parseAvroSchema(`{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`)
+	require.Nil(t, err)
+	_, err = writer.decodeAs(mismatch, data)
+	require.NotNil(t, err)
+}
+
+func TestAvroEncodeJSON(t *testing.T) {
+	schema, err := parseAvroSchema(`{"type": "record", "name": "Order", "namespace": "shop", "fields": [
+  {"name": "id", "type": "long"},
+  {"name": "customer", "type": ["null", "string"]},
+  {"name": "note", "type": ["null", "string"], "default": null},
+  {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "SHIPPED"]}},
+  {"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [{"name": "price", "type": "double"}]}}},
+  {"name": "attrs", "type": {"type": "map", "values": "int"}},
+  {"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 2}},
+  {"name": "replaces", "type": ["null", "Order"]}
+]}`)
+	require.Nil(t, err)
+
+	input := `{"id": 9007199254740993, "customer": {"string": "Arni"}, "status": "SHIPPED",
+  "items": [{"price": 9.5}], "attrs": {"b": 2, "a": 1}, "hash": "Aÿ", "replaces": {"Order": {
+    "id": 1, "customer": "Bob", "status": "NEW", "items": [], "attrs": {}, "hash": "\u0000\u0000", "replaces": null}}}`
+
+	data, err := schema.encodeJSON([]byte(input))
+	require.Nil(t, err)
+
+	actual, err := schema.decode(data)
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{
+		"id":       int64(9007199254740993),
+		"customer": map[string]interface{}{"string": "Arni"},
+		"note":     nil,
+		"status":   "SHIPPED",
+		"items":    []interface{}{map[string]interface{}{"price": 9.5}},
+		"attrs":    map[string]interface{}{"a": int32(1), "b": int32(2)},
+		"hash":     "Aÿ",
+		"replaces": map[string]interface{}{"shop.Order": map[string]interface{}{
+			"id":       int64(1),
+			"customer": map[string]interface{}{"string": "Bob"},
+			"note":     nil,
+			"status":   "NEW",
+			"items":    []interface{}{},
+			"attrs":    map[string]interface{}{},
+			"hash":     "\u0000\u0000",
+			"replaces": nil,
+		}},
+	}, actual)
+
+	for _, invalid := range []string{
+		`{"id": 1.5}`,
+		`{"id": 1, "customer": 3, "status": "NEW", "items": [], "attrs": {}, "hash": "AA", "replaces": null}`,
+		`{"id": 1, "customer": null, "status": "GONE", "items": [], "attrs": {}, "hash": "AA", "replaces": null}`,
+		`{"id": 1, "customer": null, "status": "NEW", "items": [], "attrs": {}, "hash": "A", "replaces": null}`,
+		`not json`,
+	} {
+		_, err := schema.encodeJSON([]byte(invalid))
+		require.NotNil(t, err, invalid)
+	}
+}
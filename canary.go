@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type canaryCmd struct {
+	clientConfig
+
+	topic   string
+	timeout time.Duration
+	verbose bool
+	pretty  bool
+
+	runID    string
+	client   sarama.Client
+	consumer sarama.Consumer
+	producer sarama.SyncProducer
+}
+
+type canaryArgs struct {
+	clientArgs
+
+	topic   string
+	timeout time.Duration
+	verbose bool
+	pretty  bool
+}
+
+// canaryResult reports the health of a partition. ProduceMs is the time
+// until the brokers acknowledged the canary record and RoundTripMs until it
+// was consumed back, Error describes the first step that failed.
+type canaryResult struct {
+	Partition   int32  `json:"partition"`
+	Healthy     bool   `json:"healthy"`
+	Offset      *int64 `json:"offset,omitempty"`
+	ProduceMs   *int64 `json:"produceMs,omitempty"`
+	RoundTripMs *int64 `json:"roundTripMs,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (cmd *canaryCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	if cmd.producer, err = sarama.NewSyncProducerFromClient(cmd.client); err != nil {
+		failf("failed to create producer err=%v", err)
+	}
+	defer logClose("producer", cmd.producer)
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.topic, err)
+	}
+	if len(partitions) == 0 {
+		failf("found no partitions for topic %v", cmd.topic)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = []canaryResult{}
+	)
+	wg.Add(len(partitions))
+	for _, p := range partitions {
+		go func(p int32) {
+			defer wg.Done()
+			r := cmd.probe(p)
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Partition < results[j].Partition })
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	unhealthy := []string{}
+	for _, r := range results {
+		if !r.Healthy {
+			unhealthy = append(unhealthy, fmt.Sprint(r.Partition))
+		}
+		ctx := printContext{output: r, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+
+	if len(unhealthy) > 0 {
+		failf("canary failed on partitions %v of topic %v", strings.Join(unhealthy, ","), cmd.topic)
+	}
+}
+
+// canaryValue tags the record of a partition with the run's ID so that
+// concurrent runs and other records don't count as the canary.
+func (cmd *canaryCmd) canaryValue(partition int32) []byte {
+	return []byte(fmt.Sprintf("kt-canary %v %v", cmd.runID, partition))
+}
+
+// probe produces the canary record to the partition and consumes from its
+// offset until the record shows up or -timeout passes.
+func (cmd *canaryCmd) probe(partition int32) canaryResult {
+	var (
+		result  = canaryResult{Partition: partition}
+		value   = cmd.canaryValue(partition)
+		start   = time.Now()
+		timeout = time.After(cmd.timeout)
+	)
+
+	_, offset, err := cmd.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:     cmd.topic,
+		Partition: partition,
+		Key:       sarama.StringEncoder("kt-canary"),
+		Value:     sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to produce: %v", err)
+		return result
+	}
+	produced := int64(time.Since(start) / time.Millisecond)
+	result.Offset, result.ProduceMs = &offset, &produced
+
+	pc, err := cmd.consumer.ConsumePartition(cmd.topic, partition, offset)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to consume: %v", err)
+		return result
+	}
+	defer logClose(fmt.Sprintf("partition consumer %v", partition), pc)
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if !bytes.Equal(msg.Value, value) {
+				continue
+			}
+			roundTrip := int64(time.Since(start) / time.Millisecond)
+			result.RoundTripMs = &roundTrip
+			result.Healthy = true
+			return result
+		case err := <-pc.Errors():
+			result.Error = fmt.Sprintf("failed to consume: %v", err)
+			return result
+		case <-timeout:
+			result.Error = fmt.Sprintf("timed out after %v waiting to consume the canary record", cmd.timeout)
+			return result
+		}
+	}
+}
+
+func (cmd *canaryCmd) saramaConfig() *sarama.Config {
+	cfg := cmd.clientConfig.saramaConfig("canary")
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	cfg.Producer.Timeout = cmd.timeout
+
+	return cfg
+}
+
+func (cmd *canaryCmd) failStartup(msg string) {
+	failUsage(msg, "kt canary")
+}
+
+func (cmd *canaryCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	cmd.runID = randomString(16)
+}
+
+func (cmd *canaryCmd) parseFlags(as []string) canaryArgs {
+	var args canaryArgs
+	flags := flag.NewFlagSet("canary", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Topic to probe (required).")
+	args.clientArgs.addFlags(flags)
+	flags.DurationVar(&args.timeout, "timeout", 10*time.Second, "Time to wait for the canary record of a partition to be consumed.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of canary:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, canaryDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var canaryDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+canary produces a record with key "kt-canary" to every partition of -topic
+and consumes it back, to check that the topic is writable and readable end
+to end, e.g. as a readiness probe after maintenance. It prints the time
+until the record was acknowledged and until it was consumed per partition,
+and exits with status 1 if any partition failed within -timeout.
+
+Consumers of the topic see the canary records, so use a topic that tolerates
+them, e.g. a dedicated canary topic with short retention.
+
+  $ kt canary -topic canary -timeout 5s`
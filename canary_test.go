@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanaryParseArgs(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &canaryCmd{}
+	target.parseArgs([]string{"-topic", "canary", "-brokers", "a,b:9093", "-timeout", "3s"})
+	require.Equal(t, "canary", target.topic)
+	require.Equal(t, []string{"a:9092", "b:9093"}, target.brokers)
+	require.Equal(t, 3*time.Second, target.timeout)
+	require.Equal(t, 16, len(target.runID))
+}
+
+func TestCanaryValue(t *testing.T) {
+	a := &canaryCmd{runID: "a"}
+	b := &canaryCmd{runID: "b"}
+	require.NotEqual(t, a.canaryValue(0), a.canaryValue(1))
+	require.NotEqual(t, a.canaryValue(0), b.canaryValue(0))
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// checkCmd dispatches the checks of kt check.
+type checkCmd struct{}
+
+func (cmd *checkCmd) run(args []string) {
+	if len(args) > 0 && args[0] == "freshness" {
+		(&checkFreshnessCmd{}).run(args[1:])
+		return
+	}
+	exitf(exitUsage, "unknown check, use \"kt check freshness -help\" for more information")
+}
+
+type checkFreshnessCmd struct {
+	clientConfig
+
+	topic   string
+	maxAge  time.Duration
+	timeout time.Duration
+	verbose bool
+	pretty  bool
+
+	client sarama.Client
+}
+
+type checkFreshnessArgs struct {
+	clientArgs
+
+	topic   string
+	maxAge  string
+	timeout time.Duration
+	verbose bool
+	pretty  bool
+}
+
+// freshnessTail is how many of the newest offsets of a partition are read
+// to find its newest record, e.g. past transaction markers.
+const freshnessTail = 10
+
+// partitionFreshness is the newest record of a partition, Timestamp and
+// AgeMs are nil if the partition has no records or they have no
+// timestamps.
+type partitionFreshness struct {
+	Partition    int32      `json:"partition"`
+	NewestOffset int64      `json:"newestOffset"`
+	Timestamp    *time.Time `json:"timestamp"`
+	AgeMs        *int64     `json:"ageMs"`
+	Stale        bool       `json:"stale"`
+}
+
+type freshnessReport struct {
+	Topic      string               `json:"topic"`
+	MaxAge     string               `json:"maxAge"`
+	CheckedAt  time.Time            `json:"checkedAt"`
+	Partitions []partitionFreshness `json:"partitions"`
+	Stale      []int32              `json:"stale"`
+	Failed     []int32              `json:"failed,omitempty"`
+}
+
+// newPartitionFreshness finds the newest timestamp among msgs, the newest
+// records of partition p, and checks that it's at most maxAge before now.
+// Timestamps in the future count as now.
+func newPartitionFreshness(p int32, msgs []*sarama.ConsumerMessage, now time.Time, maxAge time.Duration) partitionFreshness {
+	result := partitionFreshness{Partition: p, NewestOffset: -1, Stale: true}
+	for _, m := range msgs {
+		if m.Offset > result.NewestOffset {
+			result.NewestOffset = m.Offset
+		}
+		if m.Timestamp.IsZero() || m.Timestamp.Unix() <= 0 {
+			continue
+		}
+		if result.Timestamp == nil || m.Timestamp.After(*result.Timestamp) {
+			ts := m.Timestamp
+			result.Timestamp = &ts
+		}
+	}
+	if result.Timestamp == nil {
+		return result
+	}
+
+	age := now.Sub(*result.Timestamp)
+	if age < 0 {
+		age = 0
+	}
+	ms := int64(age / time.Millisecond)
+	result.AgeMs = &ms
+	result.Stale = age > maxAge
+	return result
+}
+
+func (cmd *checkFreshnessCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.topic, err)
+	}
+	if len(partitions) == 0 {
+		failf("found no partitions for topic %v", cmd.topic)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		now    = time.Now()
+		report = freshnessReport{Topic: cmd.topic, MaxAge: cmd.maxAge.String(), CheckedAt: now, Partitions: []partitionFreshness{}, Stale: []int32{}}
+	)
+	wg.Add(len(partitions))
+	for _, p := range partitions {
+		go func(p int32) {
+			defer wg.Done()
+			msgs, err := cmd.newestRecords(p)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read partition %v of topic %v err=%v\n", p, cmd.topic, err)
+				report.Failed = append(report.Failed, p)
+				return
+			}
+			f := newPartitionFreshness(p, msgs, now, cmd.maxAge)
+			report.Partitions = append(report.Partitions, f)
+			if f.Stale {
+				report.Stale = append(report.Stale, p)
+			}
+		}(p)
+	}
+	wg.Wait()
+	sort.Slice(report.Partitions, func(i, j int) bool { return report.Partitions[i].Partition < report.Partitions[j].Partition })
+	sort.Slice(report.Stale, func(i, j int) bool { return report.Stale[i] < report.Stale[j] })
+	sort.Slice(report.Failed, func(i, j int) bool { return report.Failed[i] < report.Failed[j] })
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: report, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	if len(report.Stale) > 0 {
+		failf("partitions %v of topic %v have no records newer than %v", report.Stale, cmd.topic, cmd.maxAge)
+	}
+	if len(report.Failed) > 0 {
+		exitf(exitPartial, "failed to check partitions %v of topic %v", report.Failed, cmd.topic)
+	}
+}
+
+// newestRecords reads up to freshnessTail of the newest records of
+// partition p, none if it's empty.
+func (cmd *checkFreshnessCmd) newestRecords(p int32) ([]*sarama.ConsumerMessage, error) {
+	oldest, err := cmd.client.GetOffset(cmd.topic, p, sarama.OffsetOldest)
+	if err != nil {
+		return nil, err
+	}
+	newest, err := cmd.client.GetOffset(cmd.topic, p, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	if newest <= oldest {
+		return nil, nil
+	}
+	start := newest - freshnessTail
+	if start < oldest {
+		start = oldest
+	}
+	return readRecordsAt(cmd.client, cmd.topic, p, start, int(newest-start), cmd.timeout)
+}
+
+func (cmd *checkFreshnessCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("check")
+}
+
+func (cmd *checkFreshnessCmd) failStartup(msg string) {
+	failUsage(msg, "kt check freshness")
+}
+
+func (cmd *checkFreshnessCmd) parseArgs(as []string) {
+	var err error
+
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.maxAge == "" {
+		cmd.failStartup("-max-age is required.")
+	}
+	if cmd.maxAge, err = parseIdleDuration(args.maxAge); err != nil || cmd.maxAge <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid max-age argument %#v, expected a positive duration like 5m or 1d.", args.maxAge))
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *checkFreshnessCmd) parseFlags(as []string) checkFreshnessArgs {
+	var args checkFreshnessArgs
+	flags := flag.NewFlagSet("check freshness", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Topic to check (required).")
+	flags.StringVar(&args.maxAge, "max-age", "", "Maximum age of the newest record of each partition, e.g. 5m or 1d (required).")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Time to wait for the newest records of a partition.")
+	args.clientArgs.addFlags(flags)
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of check freshness:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, checkFreshnessDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var checkFreshnessDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt check freshness" reads the newest record of each partition of -topic and
+fails if any is older than -max-age, e.g. to check from cron that a pipeline
+still produces:
+
+  $ kt check freshness -topic orders -max-age 5m || alert "orders is stale"
+
+The age is the time since the record's timestamp, so it's the event time
+for topics with CreateTime timestamps and the append time for LogAppendTime
+ones. Empty partitions and partitions whose newest records have no
+timestamps are stale. The newest 10 offsets are read to skip transaction
+markers.
+
+kt check freshness exits with status 0 if all partitions are fresh, 1 if any
+is stale, 5 if it couldn't read some of them, and with the status of the
+failure otherwise, e.g. 3 if the brokers are unreachable.`
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPartitionFreshness(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	msgs := []*sarama.ConsumerMessage{
+		{Offset: 7, Timestamp: now.Add(-10 * time.Minute)},
+		{Offset: 8, Timestamp: now.Add(-3 * time.Minute)},
+		{Offset: 9, Timestamp: now.Add(-20 * time.Minute)},
+	}
+	actual := newPartitionFreshness(2, msgs, now, 5*time.Minute)
+	ts, age := now.Add(-3*time.Minute), int64(3*60*1000)
+	require.Equal(t, partitionFreshness{Partition: 2, NewestOffset: 9, Timestamp: &ts, AgeMs: &age, Stale: false}, actual)
+
+	actual = newPartitionFreshness(2, msgs, now, 2*time.Minute)
+	require.True(t, actual.Stale)
+
+	// timestamps in the future count as now.
+	future := now.Add(time.Minute)
+	actual = newPartitionFreshness(0, []*sarama.ConsumerMessage{{Offset: 1, Timestamp: future}}, now, time.Minute)
+	require.False(t, actual.Stale)
+	require.Equal(t, int64(0), *actual.AgeMs)
+
+	actual = newPartitionFreshness(1, nil, now, time.Hour)
+	require.Equal(t, partitionFreshness{Partition: 1, NewestOffset: -1, Stale: true}, actual)
+
+	actual = newPartitionFreshness(1, []*sarama.ConsumerMessage{{Offset: 4, Timestamp: time.Unix(-1, 0)}}, now, time.Hour)
+	require.Equal(t, partitionFreshness{Partition: 1, NewestOffset: 4, Stale: true}, actual)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkpoint tracks the next offset to consume per partition for
+// -checkpoint-file, so that consumption can resume without committing
+// offsets for a group. Like sessionStats, a nil *checkpoint is valid and
+// does nothing.
+type checkpoint struct {
+	sync.Mutex
+	path    string
+	offsets map[topicPartition]int64
+	dirty   bool
+}
+
+type checkpointFile struct {
+	Updated time.Time          `json:"updated"`
+	Offsets []checkpointOffset `json:"offsets"`
+}
+
+// checkpointOffset is the next offset to consume, i.e. one after the last
+// consumed message.
+type checkpointOffset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// loadCheckpoint reads the offsets stored in path, a missing file is an
+// empty checkpoint that's created on the first save.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	c := &checkpoint{path: path, offsets: map[topicPartition]int64{}}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint file err=%v", err)
+	}
+
+	var f checkpointFile
+	if err = json.Unmarshal(buf, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint file %v err=%v", path, err)
+	}
+	for _, o := range f.Offsets {
+		c.offsets[topicPartition{o.Topic, o.Partition}] = o.Offset
+	}
+	return c, nil
+}
+
+// next returns the offset to resume the partition at, false if the
+// checkpoint has none.
+func (c *checkpoint) next(topic string, partition int32) (int64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	o, ok := c.offsets[topicPartition{topic, partition}]
+	return o, ok
+}
+
+func (c *checkpoint) mark(topic string, partition int32, next int64) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.offsets[topicPartition{topic, partition}] = next
+	c.dirty = true
+}
+
+// save writes the offsets if they changed since the last save. The file is
+// replaced by renaming a temporary file, so an interrupted save leaves the
+// previous checkpoint intact.
+func (c *checkpoint) save() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if !c.dirty {
+		return
+	}
+
+	f := checkpointFile{Updated: time.Now().UTC(), Offsets: []checkpointOffset{}}
+	for tp, o := range c.offsets {
+		f.Offsets = append(f.Offsets, checkpointOffset{Topic: tp.topic, Partition: tp.partition, Offset: o})
+	}
+	sort.Slice(f.Offsets, func(i, j int) bool {
+		if f.Offsets[i].Topic != f.Offsets[j].Topic {
+			return f.Offsets[i].Topic < f.Offsets[j].Topic
+		}
+		return f.Offsets[i].Partition < f.Offsets[j].Partition
+	})
+
+	buf, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal checkpoint err=%v\n", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write checkpoint file err=%v\n", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(buf, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write checkpoint file err=%v\n", err)
+		return
+	}
+	c.dirty = false
+}
+
+// saveEvery saves the checkpoint every interval until done is closed.
+func (c *checkpoint) saveEvery(interval time.Duration, done <-chan struct{}) {
+	if c == nil {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.save()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "offsets.json")
+
+	c, err := loadCheckpoint(path)
+	require.NoError(t, err)
+	_, ok := c.next("a", 0)
+	require.False(t, ok)
+
+	c.mark("a", 0, 12)
+	c.mark("a", 1, 3)
+	c.mark("a", 0, 13)
+	c.save()
+
+	loaded, err := loadCheckpoint(path)
+	require.NoError(t, err)
+	next, ok := loaded.next("a", 0)
+	require.True(t, ok)
+	require.Equal(t, int64(13), next)
+	next, ok = loaded.next("a", 1)
+	require.True(t, ok)
+	require.Equal(t, int64(3), next)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+}
+
+func TestCheckpointNil(t *testing.T) {
+	c, err := loadCheckpoint("")
+	require.NoError(t, err)
+	require.Nil(t, c)
+
+	c.mark("a", 0, 1)
+	c.save()
+	_, ok := c.next("a", 0)
+	require.False(t, ok)
+}
+
+func TestParseOffsetsResumeFile(t *testing.T) {
+	actual, err := parseOffsets("all=resume-file")
+	require.NoError(t, err)
+	require.Equal(t, offset{relative: true, start: offsetFile}, actual[-1].start)
+	require.Equal(t, offset{start: 1<<63 - 1}, actual[-1].end)
+
+	cmd := &consumeCmd{}
+	_, err = cmd.resolveOffset(actual[-1].start, "a", 0)
+	require.Error(t, err)
+}
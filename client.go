@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/Shopify/sarama"
+)
+
+// clientArgs bundles the flags to connect to the brokers that are shared by
+// most commands.
+type clientArgs struct {
+	brokers    string
+	tlsCA      string
+	tlsCert    string
+	tlsCertKey string
+	sasl       saslArgs
+	version    string
+}
+
+// addFlags registers -brokers and the connection flags of addConnFlags.
+func (args *clientArgs) addFlags(flags *flag.FlagSet) {
+	flags.StringVar(&args.brokers, "brokers", "", "Comma separated list of brokers. Port defaults to 9092 when omitted (defaults to localhost:9092).")
+	args.addConnFlags(flags)
+}
+
+// addConnFlags registers the TLS, SASL and version flags, for commands that
+// name their brokers differently.
+func (args *clientArgs) addConnFlags(flags *flag.FlagSet) {
+	flags.StringVar(&args.tlsCA, "tlsca", "", "Path to the TLS certificate authority file")
+	flags.StringVar(&args.tlsCert, "tlscert", "", "Path to the TLS client certificate file")
+	flags.StringVar(&args.tlsCertKey, "tlscertkey", "", "Path to the TLS client certificate key file")
+	flags.StringVar(&args.sasl.mechanism, "sasl-mechanism", "", "SASL mechanism to authenticate with (PLAIN|SCRAM-SHA-256|SCRAM-SHA-512|GSSAPI), defaults to none.")
+	flags.StringVar(&args.sasl.user, "sasl-user", "", "SASL user name")
+	flags.StringVar(&args.sasl.password, "sasl-password", "", "SASL password")
+	flags.StringVar(&args.sasl.krb5Config, "krb5-config", "", "Path to the Kerberos config file for GSSAPI (defaults to $KRB5_CONFIG or /etc/krb5.conf).")
+	flags.StringVar(&args.sasl.keytab, "keytab", "", "Path to the Kerberos keytab file for GSSAPI, otherwise -sasl-password is used.")
+	flags.StringVar(&args.sasl.principal, "principal", "", "Kerberos principal to authenticate as for GSSAPI, e.g. kt@EXAMPLE.COM (defaults to -sasl-user).")
+	flags.StringVar(&args.sasl.realm, "realm", "", "Kerberos realm for GSSAPI (defaults to the realm of -principal).")
+	flags.StringVar(&args.version, "version", "", "Kafka protocol version")
+}
+
+// clientConfig is what clientArgs resolve to, with the brokers taken from
+// KT_BROKERS and the SASL settings from the environment if not passed.
+type clientConfig struct {
+	brokers    []string
+	tlsCA      string
+	tlsCert    string
+	tlsCertKey string
+	sasl       saslArgs
+	version    sarama.KafkaVersion
+}
+
+func (args clientArgs) read() clientConfig {
+	brokers := args.brokers
+	if brokers == "" {
+		brokers = os.Getenv("KT_BROKERS")
+	}
+	if brokers == "" {
+		brokers = "localhost:9092"
+	}
+	return clientConfig{
+		brokers:    parseBrokers(brokers),
+		tlsCA:      args.tlsCA,
+		tlsCert:    args.tlsCert,
+		tlsCertKey: args.tlsCertKey,
+		sasl:       readSASLEnv(args.sasl),
+		version:    kafkaVersion(args.version),
+	}
+}
+
+// reload resolves args again after reading the cluster profile selected via
+// -cluster anew from the config file, e.g. when a daemon gets SIGHUP.
+func (args clientArgs) reload() (clientConfig, error) {
+	if name := os.Getenv("KT_CLUSTER"); name != "" {
+		if err := useCluster(name); err != nil {
+			return clientConfig{}, err
+		}
+	}
+	return args.read(), nil
+}
+
+// saramaConfig returns the config to connect with as client kt-<name>-<user>,
+// callers add the settings specific to their command.
+func (c clientConfig) saramaConfig(name string) *sarama.Config {
+	cfg, err := c.newSaramaConfig(name)
+	if err != nil {
+		failf("%v", err)
+	}
+	return cfg
+}
+
+// newSaramaConfig is saramaConfig for callers that can't exit on errors.
+func (c clientConfig) newSaramaConfig(name string) (*sarama.Config, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = c.version
+	usr, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current user err=%v", err)
+	}
+	cfg.ClientID = "kt-" + name + "-" + sanitizeUsername(usr.Username)
+
+	tlsConfig, err := setupBrokerCerts(c.tlsCert, c.tlsCA, c.tlsCertKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup certificates err=%v", err)
+	}
+	if tlsConfig != nil {
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+	if err = setupSASL(cfg, c.sasl); err != nil {
+		return nil, fmt.Errorf("failed to setup SASL err=%v", err)
+	}
+
+	return cfg, nil
+}
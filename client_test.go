@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientArgsRead(t *testing.T) {
+	defer os.Setenv("KT_BROKERS", os.Getenv("KT_BROKERS"))
+	defer os.Setenv("KT_SASL_USER", os.Getenv("KT_SASL_USER"))
+
+	os.Setenv("KT_BROKERS", "")
+	require.Equal(t, []string{"localhost:9092"}, clientArgs{}.read().brokers)
+
+	os.Setenv("KT_BROKERS", "a,b:9093")
+	os.Setenv("KT_SASL_USER", "kt")
+	c := clientArgs{version: "2.4.0"}.read()
+	require.Equal(t, []string{"a:9092", "b:9093"}, c.brokers)
+	require.Equal(t, "kt", c.sasl.user)
+	require.Equal(t, sarama.V2_4_0_0, c.version)
+
+	var args clientArgs
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	args.addFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-brokers", "c", "-sasl-mechanism", "GSSAPI", "-keytab", "kt.keytab"}))
+	c = args.read()
+	require.Equal(t, []string{"c:9092"}, c.brokers)
+	require.Equal(t, saslArgs{mechanism: "GSSAPI", user: "kt", keytab: "kt.keytab"}, c.sasl)
+}
+
+func TestClientArgsReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte("clusters: {prod: {brokers: kafka-1.prod}}\n"), 0644))
+	os.Setenv("KT_CONFIG", path)
+	defer os.Unsetenv("KT_CONFIG")
+	for _, k := range []string{"KT_CLUSTER", "KT_BROKERS", "KT_SASL_USER"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	require.Nil(t, useCluster("prod"))
+
+	args := clientArgs{sasl: saslArgs{user: "kt"}}
+	require.Equal(t, []string{"kafka-1.prod:9092"}, args.read().brokers)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte("clusters: {prod: {brokers: kafka-2.prod, sasl: {user: other}}}\n"), 0644))
+	c, err := args.reload()
+	require.Nil(t, err)
+	require.Equal(t, []string{"kafka-2.prod:9092"}, c.brokers)
+	require.Equal(t, "kt", c.sasl.user)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte("clusters: {}\n"), 0644))
+	_, err = args.reload()
+	require.NotNil(t, err)
+}
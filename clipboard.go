@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// clipboard collects the records of -copy and places them on the system
+// clipboard once consuming ends. Like sessionStats, a nil *clipboard is
+// valid and collects nothing.
+type clipboard struct {
+	sync.Mutex
+	buf     bytes.Buffer
+	records int
+	once    sync.Once
+}
+
+func newClipboard(enabled bool) *clipboard {
+	if !enabled {
+		return nil
+	}
+	return &clipboard{}
+}
+
+// add appends the record as indented JSON, ready to paste into a ticket.
+func (c *clipboard) add(m consumedMessage) {
+	if c == nil {
+		return
+	}
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal record at offset %v of partition %v for the clipboard err=%v\n", m.Offset, m.Partition, err)
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.buf.Write(buf)
+	c.buf.WriteByte('\n')
+	c.records++
+}
+
+// clipboardCommands returns the commands that write stdin to the clipboard
+// on goos, in order of preference.
+func clipboardCommands(goos string, getenv func(string) string) [][]string {
+	switch goos {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+
+	cmds := [][]string{}
+	if getenv("WAYLAND_DISPLAY") != "" {
+		cmds = append(cmds, []string{"wl-copy"})
+	}
+	return append(cmds,
+		[]string{"xclip", "-selection", "clipboard"},
+		[]string{"xsel", "--clipboard", "--input"},
+		[]string{"clip.exe"}, // WSL
+	)
+}
+
+func writeClipboard(data []byte) error {
+	for _, args := range clipboardCommands(runtime.GOOS, os.Getenv) {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, args[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("%v failed err=%v", args[0], err)
+		}
+		return nil
+	}
+	return fmt.Errorf("found no clipboard command, install wl-copy, xclip or xsel")
+}
+
+// copy places the collected records on the clipboard, at most once so that
+// an interrupt and the end of consuming don't both copy them.
+func (c *clipboard) copy() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		c.Lock()
+		defer c.Unlock()
+		if c.records == 0 {
+			fmt.Fprintf(os.Stderr, "no records to copy to the clipboard\n")
+			return
+		}
+		if err := writeClipboard(c.buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to copy records to the clipboard err=%v\n", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "copied %v records to the clipboard\n", c.records)
+	})
+}
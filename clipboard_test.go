@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClipboardCommands(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	require.Equal(t, [][]string{{"pbcopy"}}, clipboardCommands("darwin", getenv))
+	require.Equal(t, [][]string{{"clip"}}, clipboardCommands("windows", getenv))
+	require.Equal(t, "xclip", clipboardCommands("linux", getenv)[0][0])
+
+	env["WAYLAND_DISPLAY"] = "wayland-0"
+	require.Equal(t, []string{"wl-copy"}, clipboardCommands("linux", getenv)[0])
+}
+
+func TestClipboardAdd(t *testing.T) {
+	var c *clipboard
+	c.add(consumedMessage{})
+	c.copy()
+
+	c = newClipboard(true)
+	value := "ola"
+	c.add(consumedMessage{Partition: 1, Offset: 2, Value: &value})
+	require.Equal(t, 1, c.records)
+	require.Equal(t, "{\n  \"partition\": 1,\n  \"offset\": 2,\n  \"key\": null,\n  \"value\": \"ola\"\n}\n", c.buf.String())
+}
+
+func TestConsumeBounded(t *testing.T) {
+	all, err := parseOffsets("")
+	require.Nil(t, err)
+	require.False(t, (&consumeCmd{offsets: all}).bounded())
+	require.True(t, (&consumeCmd{offsets: all, maxMessages: 1}).bounded())
+	require.True(t, (&consumeCmd{offsets: all, untilEnd: true}).bounded())
+
+	single, err := parseOffsets("0=5:5")
+	require.Nil(t, err)
+	require.True(t, (&consumeCmd{offsets: single}).bounded())
+
+	newest, err := parseOffsets("0=:newest")
+	require.Nil(t, err)
+	require.True(t, (&consumeCmd{offsets: newest}).bounded())
+}
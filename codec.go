@@ -0,0 +1,35 @@
+package main
+
+import "github.com/fgeller/kt/pkg/codec"
+
+// checkEncoding returns an error unless enc is one of others or a codec
+// chain.
+func checkEncoding(enc string, others ...string) error {
+	for _, o := range others {
+		if enc == o {
+			return nil
+		}
+	}
+	_, err := codec.ParseChain(enc)
+	return err
+}
+
+// jsonEncoding returns whether values of encoding enc are JSON values in
+// produce's input rather than strings.
+func jsonEncoding(enc string) bool {
+	c, err := codec.ParseChain(enc)
+	return enc == "avro" || (err == nil && c.JSON())
+}
+
+// chainDecoder decodes keys and values of codec encodings like gzip+json.
+type chainDecoder struct {
+	codec.Chain
+}
+
+func (d chainDecoder) decode(data []byte) (interface{}, error) { return d.Decode(data) }
+
+// encodeBytes renders data with the string, hex or base64 codec. Data of
+// other encodings is a string until decodeMessage decodes it.
+func encodeBytes(data []byte, encoding string) *string {
+	return codec.Format(data, encoding)
+}
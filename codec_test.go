@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/fgeller/kt/pkg/codec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecBytes(t *testing.T) {
+	require.Equal(t, "4142", *encodeBytes([]byte("AB"), "hex"))
+	require.Equal(t, "QUI=", *encodeBytes([]byte("AB"), "base64"))
+	require.Equal(t, `{"a":1}`, *encodeBytes([]byte(`{"a":1}`), "gzip+json"))
+	require.Nil(t, encodeBytes(nil, "string"))
+
+	data, err := decodeBytes("4142", "hex")
+	require.Nil(t, err)
+	require.Equal(t, []byte("AB"), data)
+	_, err = decodeBytes("zz", "hex")
+	require.NotNil(t, err)
+}
+
+func TestConsumeCodecs(t *testing.T) {
+	gz, err := decodeBytes(`{"status": "ERROR"}`, "gzip+json")
+	require.Nil(t, err)
+
+	cmd := &consumeCmd{encodeKey: "string", encodeValue: "gzip+json", encodeHeaders: headerEncodings{fallback: "string"}}
+	cmd.setupCodecs()
+	require.Nil(t, cmd.keyDecoder)
+
+	msg := &sarama.ConsumerMessage{Key: []byte("k"), Value: gz}
+	m := cmd.newMessage(msg)
+	require.Equal(t, map[string]interface{}{"status": "ERROR"}, m.Value)
+}
+
+func TestProduceCodecs(t *testing.T) {
+	cmd := &produceCmd{decodeKey: "string", decodeValue: "gzip+json"}
+	var msg message
+	require.Nil(t, cmd.unmarshalMessage(`{"key": "k", "value": {"event": "signup"}}`, &msg))
+	_, value, err := cmd.decodeKeyValue(msg)
+	require.Nil(t, err)
+
+	c, _ := codec.ParseChain("gzip")
+	v, err := c.Decode(value)
+	require.Nil(t, err)
+	require.Equal(t, `{"event":"signup"}`, *v.(*string))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// repeatCollapser tracks runs of identical consecutive values for
+// -collapse-repeats, either per partition or per key within a partition.
+// Like sessionStats, a nil *repeatCollapser is valid and collapses nothing.
+type repeatCollapser struct {
+	sync.Mutex
+	byKey bool
+	runs  map[repeatGroup]*repeatRun
+}
+
+type repeatGroup struct {
+	topic     string
+	partition int32
+	key       string
+}
+
+// repeatRun is a run of identical values. last is the latest message of the
+// run and count the number of messages after the first, which was printed.
+type repeatRun struct {
+	value []byte
+	last  *sarama.ConsumerMessage
+	count int
+}
+
+func newRepeatCollapser(mode string) (*repeatCollapser, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "partition", "key":
+		return &repeatCollapser{byKey: mode == "key", runs: map[repeatGroup]*repeatRun{}}, nil
+	}
+	return nil, fmt.Errorf("unsupported collapse-repeats argument %#v, only partition and key are supported", mode)
+}
+
+func sameValue(a, b []byte) bool {
+	return (a == nil) == (b == nil) && bytes.Equal(a, b)
+}
+
+// observe adds msg to its run. It returns whether msg starts a new run and
+// should be printed, and the previous run if msg ended one that collapsed
+// messages.
+func (c *repeatCollapser) observe(msg *sarama.ConsumerMessage) (bool, *repeatRun) {
+	if c == nil {
+		return true, nil
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	g := repeatGroup{topic: msg.Topic, partition: msg.Partition}
+	if c.byKey {
+		g.key = string(msg.Key)
+	}
+
+	run, ok := c.runs[g]
+	if ok && sameValue(run.value, msg.Value) {
+		run.last = msg
+		run.count++
+		return false, nil
+	}
+
+	c.runs[g] = &repeatRun{value: msg.Value}
+	if ok && run.count > 0 {
+		return true, run
+	}
+	return true, nil
+}
+
+// flush ends the runs of the partition and returns those that collapsed
+// messages, ordered by the offset of their last message.
+func (c *repeatCollapser) flush(topic string, partition int32) []*repeatRun {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	ended := []*repeatRun{}
+	for g, run := range c.runs {
+		if g.topic != topic || g.partition != partition {
+			continue
+		}
+		delete(c.runs, g)
+		if run.count > 0 {
+			ended = append(ended, run)
+		}
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].last.Offset < ended[j].last.Offset })
+	return ended
+}
+
+// printRun prints the last message of a run that collapsed messages with
+// their number as "collapsed".
+func (cmd *consumeCmd) printRun(out chan printContext, run *repeatRun) bool {
+	m := cmd.newMessage(run.last)
+	m.Collapsed = &run.count
+	return cmd.emitMessage(out, m, run.last)
+}
+
+// flushRepeats prints the runs of the partition that are still open, e.g.
+// once the partition's end or -timeout is reached.
+func (cmd *consumeCmd) flushRepeats(out chan printContext, topic string, partition int32) {
+	for _, run := range cmd.repeats.flush(topic, partition) {
+		if !cmd.printRun(out, run) {
+			return
+		}
+	}
+}
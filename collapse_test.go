@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepeatCollapser(t *testing.T) {
+	var c *repeatCollapser
+	show, ended := c.observe(&sarama.ConsumerMessage{})
+	require.True(t, show)
+	require.Nil(t, ended)
+
+	c, err := newRepeatCollapser("")
+	require.Nil(t, err)
+	require.Nil(t, c)
+	_, err = newRepeatCollapser("topic")
+	require.NotNil(t, err)
+
+	c, err = newRepeatCollapser("partition")
+	require.Nil(t, err)
+	msg := func(p int32, o int64, k, v string) *sarama.ConsumerMessage {
+		return &sarama.ConsumerMessage{Partition: p, Offset: o, Key: []byte(k), Value: []byte(v)}
+	}
+
+	show, _ = c.observe(msg(0, 1, "a", "beat"))
+	require.True(t, show)
+	show, _ = c.observe(msg(0, 2, "b", "beat"))
+	require.False(t, show)
+	show, _ = c.observe(msg(1, 3, "a", "beat"))
+	require.True(t, show, "partitions have runs of their own")
+	show, _ = c.observe(msg(0, 4, "a", "beat"))
+	require.False(t, show)
+
+	show, ended = c.observe(msg(0, 5, "a", "alert"))
+	require.True(t, show)
+	require.Equal(t, 2, ended.count)
+	require.Equal(t, int64(4), ended.last.Offset)
+
+	show, ended = c.observe(&sarama.ConsumerMessage{Partition: 0, Offset: 6})
+	require.True(t, show, "tombstones differ from values")
+	require.Nil(t, ended, "runs without repeats aren't printed twice")
+
+	c.observe(&sarama.ConsumerMessage{Partition: 0, Offset: 7})
+	require.Empty(t, c.flush("", 1))
+	flushed := c.flush("", 0)
+	require.Len(t, flushed, 1)
+	require.Equal(t, int64(7), flushed[0].last.Offset)
+	require.Empty(t, c.flush("", 0))
+
+	c, _ = newRepeatCollapser("key")
+	c.observe(msg(0, 1, "a", "beat"))
+	show, _ = c.observe(msg(0, 2, "b", "beat"))
+	require.True(t, show, "keys have runs of their own")
+	show, _ = c.observe(msg(0, 3, "a", "beat"))
+	require.False(t, show)
+}
+
+func TestConsumeCollapseRepeats(t *testing.T) {
+	out := make(chan printContext)
+	printed := make(chan consumedMessage, 10)
+	go func() {
+		for ctx := range out {
+			printed <- ctx.output.(consumedMessage)
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	target := &consumeCmd{encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json"}
+	target.repeats, _ = newRepeatCollapser("partition")
+
+	for o, v := range []string{"beat", "beat", "beat", "alert", "beat", "beat"} {
+		require.True(t, target.printMessage(out, &sarama.ConsumerMessage{Offset: int64(o), Value: []byte(v)}))
+	}
+	target.flushRepeats(out, "", 0)
+	close(printed)
+
+	actual := []consumedMessage{}
+	for m := range printed {
+		actual = append(actual, m)
+	}
+	require.Len(t, actual, 5)
+	offsets := []int64{}
+	for _, m := range actual {
+		offsets = append(offsets, m.Offset)
+	}
+	require.Equal(t, []int64{0, 2, 3, 4, 5}, offsets)
+	require.Nil(t, actual[0].Collapsed)
+	require.Equal(t, 2, *actual[1].Collapsed)
+	require.Nil(t, actual[2].Collapsed)
+	require.Equal(t, 1, *actual[4].Collapsed)
+}
@@ -5,14 +5,17 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"os"
 	"os/signal"
 	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf16"
@@ -27,7 +30,7 @@ var (
 
 func listenForInterrupt(q chan struct{}) {
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Kill, os.Interrupt)
+	signal.Notify(signals, os.Kill, os.Interrupt, syscall.SIGTERM)
 	sig := <-signals
 	fmt.Fprintf(os.Stderr, "received signal %s\n", sig)
 	close(q)
@@ -40,7 +43,7 @@ func kafkaVersion(s string) sarama.KafkaVersion {
 
 	v, err := sarama.ParseKafkaVersion(strings.TrimPrefix(s, "v"))
 	if err != nil {
-		failf(err.Error())
+		exitf(exitUsage, "%s", err)
 	}
 
 	return v
@@ -53,18 +56,49 @@ func parseTimeout(s string) *time.Duration {
 
 	v, err := time.ParseDuration(s)
 	if err != nil {
-		failf(err.Error())
+		exitf(exitUsage, "%s", err)
 	}
 
 	return &v
 }
 
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
 func logClose(name string, c io.Closer) {
 	if err := c.Close(); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to close %#v err=%v", name, err)
 	}
 }
 
+// bufferedStdout buffers the output of print, so that printing many messages
+// doesn't take a write to stdout per message. print flushes it every
+// flushInterval, exit and main before kt exits. All output to stdout goes
+// through it so that it isn't reordered, and interactive output is flushed
+// right away.
+type bufferedStdout struct {
+	sync.Mutex
+	w *bufio.Writer
+}
+
+const flushInterval = 100 * time.Millisecond
+
+var stdout = &bufferedStdout{w: bufio.NewWriterSize(os.Stdout, 64*1024)}
+
+func (b *bufferedStdout) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.w.Write(p)
+}
+
+func (b *bufferedStdout) Flush() error {
+	b.Lock()
+	defer b.Unlock()
+	return b.w.Flush()
+}
+
 type printContext struct {
 	output interface{}
 	done   chan struct{}
@@ -81,46 +115,148 @@ func print(in <-chan printContext, pretty bool) {
 		marshal = func(i interface{}) ([]byte, error) { return json.MarshalIndent(i, "", "  ") }
 	}
 
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+
 	for {
-		ctx := <-in
+		var ctx printContext
+		select {
+		case ctx = <-in:
+		case <-flush.C:
+			if err = stdout.Flush(); err != nil {
+				failf("failed to write output err=%v", err)
+			}
+			continue
+		}
+
+		if raw, ok := ctx.output.(rawOutput); ok {
+			if _, err = stdout.Write(raw); err != nil {
+				failf("failed to write output err=%v", err)
+			}
+			close(ctx.done)
+			continue
+		}
+
 		if buf, err = marshal(ctx.output); err != nil {
 			failf("failed to marshal output %#v, err=%v", ctx.output, err)
 		}
 
-		fmt.Println(string(buf))
+		if _, err = stdout.Write(append(buf, '\n')); err != nil {
+			failf("failed to write output err=%v", err)
+		}
 		close(ctx.done)
 	}
 }
 
+// Exit codes that let scripts running kt tell what went wrong.
+const (
+	exitFailure    = 1
+	exitUsage      = 2 // invalid arguments, also used by flag parsing
+	exitConnection = 3 // brokers unreachable
+	exitAuth       = 4 // TLS, SASL or ACL failures
+	exitPartial    = 5 // some partitions failed to consume
+)
+
+var exitKinds = map[int]string{
+	exitFailure:    "failure",
+	exitUsage:      "usage",
+	exitConnection: "connection",
+	exitAuth:       "auth",
+	exitPartial:    "partial",
+}
+
+// jsonErrors is set when -pretty=false to report errors as JSON objects on
+// stderr, like the rest of the output is meant for machines then.
+var jsonErrors bool
+
+type errorOutput struct {
+	Error string `json:"error"`
+	Kind  string `json:"kind"`
+	Code  int    `json:"code"`
+}
+
+var authErrors = []error{
+	sarama.ErrSASLAuthenticationFailed,
+	sarama.ErrUnsupportedSASLMechanism,
+	sarama.ErrIllegalSASLState,
+	sarama.ErrTopicAuthorizationFailed,
+	sarama.ErrGroupAuthorizationFailed,
+	sarama.ErrClusterAuthorizationFailed,
+	sarama.ErrTransactionalIDAuthorizationFailed,
+	sarama.ErrDelegationTokenAuthorizationFailed,
+}
+
+// errorExitCode classifies err as an authentication or connection failure.
+// Auth is checked first as sarama reports brokers that reject the
+// credentials as running out of brokers, too.
+func errorExitCode(err error) int {
+	for _, auth := range authErrors {
+		if errors.Is(err, auth) {
+			return exitAuth
+		}
+	}
+
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		netErr           net.Error
+	)
+	switch {
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCert), errors.As(err, &hostname):
+		return exitAuth
+	case errors.Is(err, sarama.ErrOutOfBrokers), errors.Is(err, sarama.ErrNotConnected), errors.As(err, &netErr):
+		return exitConnection
+	}
+
+	return exitFailure
+}
+
 func quitf(msg string, args ...interface{}) {
 	exitf(0, msg, args...)
 }
 
+// failf exits with the code of the first error in args that is classified by
+// errorExitCode, or exitFailure.
 func failf(msg string, args ...interface{}) {
-	exitf(1, msg, args...)
+	code := exitFailure
+	for _, a := range args {
+		if err, ok := a.(error); ok {
+			if code = errorExitCode(err); code != exitFailure {
+				break
+			}
+		}
+	}
+	exitf(code, msg, args...)
 }
 
-func exitf(code int, msg string, args ...interface{}) {
-	if code == 0 {
-		fmt.Fprintf(os.Stdout, msg+"\n", args...)
-	} else {
-		fmt.Fprintf(os.Stderr, msg+"\n", args...)
+// failUsage reports invalid arguments and points to the help of command.
+func failUsage(msg, command string) {
+	if jsonErrors {
+		exitf(exitUsage, "%s", msg)
 	}
-	os.Exit(code)
+	fmt.Fprintln(os.Stderr, msg)
+	exitf(exitUsage, "use \"%v -help\" for more information", command)
 }
 
-func readStdinLines(max int, out chan string) {
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Buffer(make([]byte, max), max)
-
-	for scanner.Scan() {
-		out <- scanner.Text()
+func exitf(code int, msg string, args ...interface{}) {
+	stdout.Flush()
+	switch {
+	case code == 0:
+		fmt.Fprintf(stdout, msg+"\n", args...)
+	case jsonErrors:
+		buf, _ := json.Marshal(errorOutput{Error: fmt.Sprintf(msg, args...), Kind: exitKinds[code], Code: code})
+		fmt.Fprintln(os.Stderr, string(buf))
+	default:
+		fmt.Fprintf(os.Stderr, msg+"\n", args...)
 	}
+	exit(code)
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "scanning input failed err=%v\n", err)
-	}
-	close(out)
+// exit flushes stdout before kt exits with code, so no output gets lost.
+func exit(code int) {
+	stdout.Flush()
+	os.Exit(code)
 }
 
 // hashCode imitates the behavior of the JDK's String#hashCode method.
@@ -176,37 +312,91 @@ func randomString(length int) string {
 }
 
 // setupCerts takes the paths to a tls certificate, CA, and certificate key in
-// a PEM format and returns a constructed tls.Config object.
+// a PEM format and returns a constructed tls.Config object. The certificate
+// and key are only required for client authentication, a CA alone suffices to
+// verify the brokers, e.g. when authenticating via SASL.
 func setupCerts(certPath, caPath, keyPath string) (*tls.Config, error) {
 	if certPath == "" && caPath == "" && keyPath == "" {
 		return nil, nil
 	}
 
-	if certPath == "" || caPath == "" || keyPath == "" {
-		err := fmt.Errorf("certificate, CA and key path are required - got cert=%#v ca=%#v key=%#v", certPath, caPath, keyPath)
+	if (certPath == "") != (keyPath == "") {
+		err := fmt.Errorf("certificate and key path are required together - got cert=%#v key=%#v", certPath, keyPath)
 		return nil, err
 	}
 
-	caString, err := ioutil.ReadFile(caPath)
-	if err != nil {
-		return nil, err
-	}
+	bundle := &tls.Config{}
 
-	caPool := x509.NewCertPool()
-	ok := caPool.AppendCertsFromPEM(caString)
-	if !ok {
-		failf("unable to add ca at %s to certificate pool", caPath)
-	}
+	if caPath != "" {
+		caString, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
 
-	clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
-	if err != nil {
-		return nil, err
+		caPool := x509.NewCertPool()
+		ok := caPool.AppendCertsFromPEM(caString)
+		if !ok {
+			failf("unable to add ca at %s to certificate pool", caPath)
+		}
+		bundle.RootCAs = caPool
 	}
 
-	bundle := &tls.Config{
-		RootCAs:      caPool,
-		Certificates: []tls.Certificate{clientCert},
+	if certPath != "" {
+		clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Certificates = []tls.Certificate{clientCert}
 	}
+
 	bundle.BuildNameToCertificate()
 	return bundle, nil
 }
+
+// setupBrokerCerts is setupCerts for the brokers, with the paths that weren't
+// supplied via flags taken from KT_TLS_CA, KT_TLS_CERT and KT_TLS_CERT_KEY.
+func setupBrokerCerts(certPath, caPath, keyPath string) (*tls.Config, error) {
+	if certPath == "" {
+		certPath = os.Getenv("KT_TLS_CERT")
+	}
+	if caPath == "" {
+		caPath = os.Getenv("KT_TLS_CA")
+	}
+	if keyPath == "" {
+		keyPath = os.Getenv("KT_TLS_CERT_KEY")
+	}
+	return setupCerts(certPath, caPath, keyPath)
+}
+
+// fetchCommittedOffset reads the offset group committed for the given topic
+// partition straight from the group's coordinator, so kt neither joins nor
+// commits on behalf of that group. It returns a negative offset if the group
+// hasn't committed an offset yet.
+func fetchCommittedOffset(client sarama.Client, group, topic string, partition int32) (int64, error) {
+	var (
+		err         error
+		coordinator *sarama.Broker
+		resp        *sarama.OffsetFetchResponse
+		req         = &sarama.OffsetFetchRequest{ConsumerGroup: group, Version: 1}
+	)
+
+	if coordinator, err = client.Coordinator(group); err != nil {
+		return 0, fmt.Errorf("failed to find coordinator for group %#v err=%v", group, err)
+	}
+
+	req.AddPartition(topic, partition)
+	if resp, err = coordinator.FetchOffset(req); err != nil {
+		return 0, fmt.Errorf("failed to fetch offset for group %#v err=%v", group, err)
+	}
+
+	block := resp.GetBlock(topic, partition)
+	if block == nil {
+		return 0, fmt.Errorf("missing offset for group %#v in coordinator response", group)
+	}
+
+	if block.Err != sarama.ErrNoError {
+		return 0, fmt.Errorf("failed to fetch offset for group %#v err=%v", group, block.Err)
+	}
+
+	return block.Offset, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorExitCode(t *testing.T) {
+	data := map[string]struct {
+		given    error
+		expected int
+	}{
+		"other": {
+			given:    fmt.Errorf("no such topic"),
+			expected: exitFailure,
+		},
+		"out of brokers": {
+			given:    sarama.Wrap(sarama.ErrOutOfBrokers, &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}),
+			expected: exitConnection,
+		},
+		"sasl rejected": {
+			given:    sarama.Wrap(sarama.ErrOutOfBrokers, sarama.ErrSASLAuthenticationFailed),
+			expected: exitAuth,
+		},
+		"acl": {
+			given:    fmt.Errorf("failed to fetch offsets: %w", sarama.ErrGroupAuthorizationFailed),
+			expected: exitAuth,
+		},
+		"unknown ca": {
+			given:    sarama.Wrap(sarama.ErrOutOfBrokers, x509.UnknownAuthorityError{}),
+			expected: exitAuth,
+		},
+	}
+
+	for name, d := range data {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, d.expected, errorExitCode(d.given))
+		})
+	}
+}
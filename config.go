@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ktConfig is the config file of cluster profiles and flag defaults, read
+// from KT_CONFIG, ~/.config/kt/config.(yaml|yml|json) or ~/.kt.json. Defaults
+// map flag names without dash to values.
+type ktConfig struct {
+	Clusters map[string]clusterConfig `json:"clusters,omitempty" yaml:"clusters,omitempty"`
+	Topics   map[string]flagDefaults  `json:"topics,omitempty" yaml:"topics,omitempty"`
+}
+
+// clusterConfig applies to commands whose brokers include any of Brokers.
+// Selected with -cluster or KT_CLUSTER, it's also a profile of the brokers,
+// TLS, SASL and schema registry settings to connect with.
+type clusterConfig struct {
+	Brokers        string                  `json:"brokers" yaml:"brokers"`
+	TLSCA          string                  `json:"tlsca,omitempty" yaml:"tlsca,omitempty"`
+	TLSCert        string                  `json:"tlscert,omitempty" yaml:"tlscert,omitempty"`
+	TLSCertKey     string                  `json:"tlscertkey,omitempty" yaml:"tlscertkey,omitempty"`
+	SASL           saslConfig              `json:"sasl" yaml:"sasl,omitempty"`
+	SchemaRegistry string                  `json:"schema-registry,omitempty" yaml:"schema-registry,omitempty"`
+	Defaults       flagDefaults            `json:"defaults,omitempty" yaml:"defaults,omitempty"`
+	Topics         map[string]flagDefaults `json:"topics,omitempty" yaml:"topics,omitempty"`
+	Metadata       *clusterMetadata        `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+type saslConfig struct {
+	Mechanism string `json:"mechanism,omitempty" yaml:"mechanism,omitempty"`
+	User      string `json:"user,omitempty" yaml:"user,omitempty"`
+	Password  string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	// Kerberos settings of GSSAPI
+	Krb5Config string `json:"krb5-config,omitempty" yaml:"krb5-config,omitempty"`
+	Keytab     string `json:"keytab,omitempty" yaml:"keytab,omitempty"`
+	Principal  string `json:"principal,omitempty" yaml:"principal,omitempty"`
+	Realm      string `json:"realm,omitempty" yaml:"realm,omitempty"`
+}
+
+func (c saslConfig) args() saslArgs {
+	return saslArgs{
+		mechanism:  c.Mechanism,
+		user:       c.User,
+		password:   c.Password,
+		krb5Config: c.Krb5Config,
+		keytab:     c.Keytab,
+		principal:  c.Principal,
+		realm:      c.Realm,
+	}
+}
+
+type flagDefaults map[string]interface{}
+
+// configPath is KT_CONFIG or the first config file that exists in the
+// default locations, ~/.kt.json if none does.
+func configPath() string {
+	if p := os.Getenv("KT_CONFIG"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(home, ".config")
+	}
+	for _, n := range []string{"config.yaml", "config.yml", "config.json"} {
+		p := filepath.Join(dir, "kt", n)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return filepath.Join(home, ".kt.json")
+}
+
+// readConfig reads the config file at path, a missing file is an empty
+// config.
+func readConfig(path string) (ktConfig, error) {
+	var cfg ktConfig
+	if path == "" {
+		return cfg, nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config file %v err=%v", path, err)
+	}
+
+	if err = unmarshalConfigFile(path, buf, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file %v err=%v", path, err)
+	}
+	return cfg, nil
+}
+
+// unmarshalConfigFile decodes buf as YAML if path has a .yaml or .yml
+// extension, and as JSON otherwise.
+func unmarshalConfigFile(path string, buf []byte, v interface{}) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(buf, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// clusterFlag removes the global -cluster flag from the arguments before the
+// command and returns its value, KT_CLUSTER if it isn't passed.
+func clusterFlag(args []string) (string, []string, error) {
+	name := os.Getenv("KT_CLUSTER")
+	for len(args) > 0 {
+		a := strings.TrimPrefix(args[0], "-")
+		switch {
+		case a == "-cluster" || a == "cluster":
+			if len(args) < 2 {
+				return "", nil, fmt.Errorf("flag needs an argument: -cluster")
+			}
+			name, args = args[1], args[2:]
+		case strings.HasPrefix(a, "-cluster=") || strings.HasPrefix(a, "cluster="):
+			name, args = a[strings.Index(a, "=")+1:], args[1:]
+		default:
+			return name, args, nil
+		}
+	}
+	return name, args, nil
+}
+
+// useCluster selects the profile name of the config file by setting the
+// environment variables that commands fall back to for flags that weren't
+// passed, so flags on the command line still win. Settings the profile
+// doesn't have are cleared rather than taken from the environment, so they
+// can't leak in from another cluster.
+func useCluster(name string) error {
+	cfg, err := readConfig(configPath())
+	if err != nil {
+		return err
+	}
+	c, ok := cfg.Clusters[name]
+	if !ok {
+		return fmt.Errorf("unknown cluster %#v, not found in config file %v", name, configPath())
+	}
+	if c.Brokers == "" {
+		return fmt.Errorf("cluster %#v in config file %v has no brokers", name, configPath())
+	}
+
+	env := []struct{ key, value string }{
+		{"KT_CLUSTER", name},
+		{"KT_BROKERS", c.Brokers},
+		{"KT_TLS_CA", c.TLSCA},
+		{"KT_TLS_CERT", c.TLSCert},
+		{"KT_TLS_CERT_KEY", c.TLSCertKey},
+		{"KT_SASL_MECHANISM", c.SASL.Mechanism},
+		{"KT_SASL_USER", c.SASL.User},
+		{"KT_SASL_PASSWORD", c.SASL.Password},
+		{"KT_KRB5_CONFIG", c.SASL.Krb5Config},
+		{"KT_KEYTAB", c.SASL.Keytab},
+		{"KT_PRINCIPAL", c.SASL.Principal},
+		{"KT_REALM", c.SASL.Realm},
+		{"KT_SCHEMA_REGISTRY", c.SchemaRegistry},
+	}
+	for _, e := range env {
+		if err := os.Setenv(e.key, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameCluster(a, b string) bool {
+	brokers := map[string]bool{}
+	for _, br := range parseBrokers(a) {
+		brokers[br] = true
+	}
+	for _, br := range parseBrokers(b) {
+		if brokers[br] {
+			return true
+		}
+	}
+	return false
+}
+
+// defaults returns the defaults for topic on the cluster of brokers, in
+// increasing precedence: the cluster's, the topic's and the topic's on the
+// cluster. The cluster selected via KT_CLUSTER is preferred when several
+// share brokers.
+func (cfg ktConfig) defaults(brokers, topic string) []flagDefaults {
+	var (
+		result  = []flagDefaults{}
+		cluster *clusterConfig
+	)
+
+	names := make([]string, 0, len(cfg.Clusters))
+	for n := range cfg.Clusters {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if selected := os.Getenv("KT_CLUSTER"); names[i] == selected || names[j] == selected {
+			return names[i] == selected
+		}
+		return names[i] < names[j]
+	})
+	for _, n := range names {
+		if c := cfg.Clusters[n]; c.Brokers != "" && sameCluster(c.Brokers, brokers) {
+			cluster = &c
+			break
+		}
+	}
+
+	if cluster != nil {
+		result = append(result, cluster.Defaults)
+	}
+	result = append(result, cfg.Topics[topic])
+	if cluster != nil {
+		result = append(result, cluster.Topics[topic])
+	}
+	return result
+}
+
+// applyConfig sets the flags that weren't passed on the command line to the
+// config file's defaults for topic on the cluster of brokers, where each is
+// taken from the flag or its environment variable. Flags the command doesn't
+// have are ignored.
+func applyConfig(flags *flag.FlagSet, brokers, topic string) error {
+	cfg, err := readConfig(configPath())
+	if err != nil {
+		return err
+	}
+
+	passed := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	if brokers == "" {
+		brokers = os.Getenv("KT_BROKERS")
+	}
+	if brokers == "" {
+		brokers = "localhost:9092"
+	}
+	if topic == "" {
+		topic = os.Getenv("KT_TOPIC")
+	}
+
+	for _, defaults := range cfg.defaults(brokers, topic) {
+		names := make([]string, 0, len(defaults))
+		for n := range defaults {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		for _, n := range names {
+			if passed[n] {
+				continue
+			}
+			if flags.Lookup(n) == nil {
+				// e.g. decodevalue for produce in consume, so defaults
+				// can cover both.
+				continue
+			}
+			if err := flags.Set(n, fmt.Sprint(defaults[n])); err != nil {
+				return fmt.Errorf("invalid value %#v for flag %#v in config file %v err=%v", defaults[n], n, configPath(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// commandConfigFlags are the commands with a -config flag of their own,
+// which stdinConfig leaves to them.
+var commandConfigFlags = map[string]bool{
+	"admin": true, // config entries for alterconfig
+}
+
+// stdinConfig replaces the first -config - in the arguments of command with
+// the flags of the JSON object read from stdin, so other programs can pass
+// a command's configuration without building and escaping a command line.
+// Keys are flag names without dash, arrays repeat a flag and null leaves it
+// unset. Flags after -config - win as they're parsed later. It returns the
+// rest of stdin after the object, e.g. messages for produce, or nil if the
+// arguments have no -config - or the command has a -config flag itself.
+func stdinConfig(command string, args []string, stdin io.Reader) ([]string, io.Reader, error) {
+	if commandConfigFlags[command] {
+		return args, nil, nil
+	}
+
+	at := -1
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if (a == "-config" || a == "--config") && i+1 < len(args) && args[i+1] == "-" {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return args, nil, nil
+	}
+
+	var doc map[string]interface{}
+	dec := json.NewDecoder(stdin)
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to read -config - as a JSON object from stdin err=%v", err)
+	}
+	flags, err := configFlags(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := append([]string{}, args[:at]...)
+	result = append(result, flags...)
+	result = append(result, args[at+2:]...)
+	return result, io.MultiReader(dec.Buffered(), stdin), nil
+}
+
+// configFlags converts the flags of a JSON object to arguments, sorted by
+// name.
+func configFlags(doc map[string]interface{}) ([]string, error) {
+	names := make([]string, 0, len(doc))
+	for n := range doc {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	result := []string{}
+	for _, n := range names {
+		values, ok := doc[n].([]interface{})
+		if !ok {
+			values = []interface{}{doc[n]}
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case nil:
+				continue
+			case string, json.Number, bool:
+				result = append(result, fmt.Sprintf("-%v=%v", strings.TrimLeft(n, "-"), v))
+			default:
+				return nil, fmt.Errorf("invalid value for flag %#v in -config -, expected a string, number, boolean or an array of them", n)
+			}
+		}
+	}
+	return result, nil
+}
+
+// replaceStdin makes r the process's stdin, for commands that read
+// os.Stdin after stdinConfig consumed the start of it.
+func replaceStdin(r io.Reader) error {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	go func() {
+		if _, err := io.Copy(pw, r); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read stdin err=%v\n", err)
+		}
+		logClose("stdin pipe", pw)
+	}()
+	os.Stdin = pr
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"gopkg.in/yaml.v3"
+)
+
+// clusterMetadata is stored with a cluster profile by "config add-cluster"
+// when it checked the connection.
+type clusterMetadata struct {
+	Checked    time.Time `json:"checked" yaml:"checked"`
+	Controller int32     `json:"controller" yaml:"controller"`
+	Brokers    []string  `json:"brokers" yaml:"brokers"` // id=host:port
+	Topics     int       `json:"topics" yaml:"topics"`
+	Subjects   *int      `json:"subjects,omitempty" yaml:"subjects,omitempty"` // of the schema registry
+}
+
+type configCmd struct {
+	name      string
+	cluster   clusterConfig
+	passed    map[string]bool
+	version   sarama.KafkaVersion
+	timeout   time.Duration
+	skipCheck bool
+	pretty    bool
+}
+
+type configArgs struct {
+	name           string
+	brokers        string
+	tlsCA          string
+	tlsCert        string
+	tlsCertKey     string
+	sasl           saslArgs
+	schemaRegistry string
+	version        string
+	timeout        time.Duration
+	skipCheck      bool
+	pretty         bool
+}
+
+func (cmd *configCmd) failStartup(msg string) {
+	failUsage(msg, "kt config add-cluster")
+}
+
+func (cmd *configCmd) parseArgs(as []string) {
+	if len(as) == 0 || as[0] != "add-cluster" {
+		cmd.failStartup("kt config requires a subcommand, only add-cluster is supported.")
+	}
+	args := cmd.parseFlags(as[1:])
+
+	if args.name == "" {
+		cmd.failStartup("-name is required.")
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+	cmd.name = args.name
+	cmd.version = kafkaVersion(args.version)
+	cmd.timeout = args.timeout
+	cmd.skipCheck = args.skipCheck
+	cmd.pretty = args.pretty
+	cmd.cluster = clusterConfig{
+		Brokers:    args.brokers,
+		TLSCA:      args.tlsCA,
+		TLSCert:    args.tlsCert,
+		TLSCertKey: args.tlsCertKey,
+		SASL: saslConfig{
+			Mechanism:  args.sasl.mechanism,
+			User:       args.sasl.user,
+			Password:   args.sasl.password,
+			Krb5Config: args.sasl.krb5Config,
+			Keytab:     args.sasl.keytab,
+			Principal:  args.sasl.principal,
+			Realm:      args.sasl.realm,
+		},
+		SchemaRegistry: args.schemaRegistry,
+	}
+}
+
+func (cmd *configCmd) parseFlags(as []string) configArgs {
+	var args configArgs
+	flags := flag.NewFlagSet("config add-cluster", flag.ContinueOnError)
+	flags.StringVar(&args.name, "name", "", "Name of the cluster profile to add or edit (required).")
+	flags.StringVar(&args.brokers, "brokers", "", "Comma separated list of brokers, required for new profiles.")
+	flags.StringVar(&args.tlsCA, "tlsca", "", "Path to the TLS certificate authority file")
+	flags.StringVar(&args.tlsCert, "tlscert", "", "Path to the TLS client certificate file")
+	flags.StringVar(&args.tlsCertKey, "tlscertkey", "", "Path to the TLS client certificate key file")
+	flags.StringVar(&args.sasl.mechanism, "sasl-mechanism", "", "SASL mechanism to authenticate with (PLAIN|SCRAM-SHA-256|SCRAM-SHA-512|GSSAPI), defaults to none.")
+	flags.StringVar(&args.sasl.user, "sasl-user", "", "SASL user name")
+	flags.StringVar(&args.sasl.password, "sasl-password", "", "SASL password")
+	flags.StringVar(&args.sasl.krb5Config, "krb5-config", "", "Path to the Kerberos config file for GSSAPI (defaults to $KRB5_CONFIG or /etc/krb5.conf).")
+	flags.StringVar(&args.sasl.keytab, "keytab", "", "Path to the Kerberos keytab file for GSSAPI, otherwise -sasl-password is used.")
+	flags.StringVar(&args.sasl.principal, "principal", "", "Kerberos principal to authenticate as for GSSAPI, e.g. kt@EXAMPLE.COM (defaults to -sasl-user).")
+	flags.StringVar(&args.sasl.realm, "realm", "", "Kerberos realm for GSSAPI (defaults to the realm of -principal).")
+	flags.StringVar(&args.schemaRegistry, "schema-registry", "", "URL of the schema registry of the cluster, defaults to none.")
+	flags.StringVar(&args.version, "version", "", "Kafka protocol version to check the connection with")
+	flags.DurationVar(&args.timeout, "timeout", 10*time.Second, "Time to wait for the brokers and the schema registry to respond.")
+	flags.BoolVar(&args.skipCheck, "skip-check", false, "Save the profile without checking the connection, e.g. for a cluster that's not reachable from here.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of config add-cluster:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, configDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	cmd.passed = map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { cmd.passed[f.Name] = true })
+	jsonErrors = !args.pretty
+	return args
+}
+
+// merge returns the profile existing with the settings that were passed as
+// flags, so editing a profile only changes what's passed. An empty value
+// clears a setting, e.g. -tlsca "".
+func (cmd *configCmd) merge(existing clusterConfig) clusterConfig {
+	c := existing
+	for _, s := range []struct {
+		flag  string
+		value string
+		field *string
+	}{
+		{"brokers", cmd.cluster.Brokers, &c.Brokers},
+		{"tlsca", cmd.cluster.TLSCA, &c.TLSCA},
+		{"tlscert", cmd.cluster.TLSCert, &c.TLSCert},
+		{"tlscertkey", cmd.cluster.TLSCertKey, &c.TLSCertKey},
+		{"sasl-mechanism", cmd.cluster.SASL.Mechanism, &c.SASL.Mechanism},
+		{"sasl-user", cmd.cluster.SASL.User, &c.SASL.User},
+		{"sasl-password", cmd.cluster.SASL.Password, &c.SASL.Password},
+		{"krb5-config", cmd.cluster.SASL.Krb5Config, &c.SASL.Krb5Config},
+		{"keytab", cmd.cluster.SASL.Keytab, &c.SASL.Keytab},
+		{"principal", cmd.cluster.SASL.Principal, &c.SASL.Principal},
+		{"realm", cmd.cluster.SASL.Realm, &c.SASL.Realm},
+		{"schema-registry", cmd.cluster.SchemaRegistry, &c.SchemaRegistry},
+	} {
+		if cmd.passed[s.flag] {
+			*s.field = s.value
+		}
+	}
+	c.Metadata = nil
+	return c
+}
+
+func (cmd *configCmd) run(as []string) {
+	cmd.parseArgs(as)
+
+	path := configPath()
+	if path == "" {
+		failf("failed to find the config file, set KT_CONFIG")
+	}
+	cfg, err := readConfig(path)
+	if err != nil {
+		failf("%v", err)
+	}
+
+	cluster := cmd.merge(cfg.Clusters[cmd.name])
+	if cluster.Brokers == "" {
+		cmd.failStartup(fmt.Sprintf("cluster %#v requires -brokers.", cmd.name))
+	}
+	if !cmd.skipCheck {
+		if cluster.Metadata, err = probeCluster(cluster, cmd.version, cmd.timeout); err != nil {
+			failf("failed to connect to cluster %#v, pass -skip-check to save it anyway err=%v", cmd.name, err)
+		}
+	}
+
+	if cfg.Clusters == nil {
+		cfg.Clusters = map[string]clusterConfig{}
+	}
+	cfg.Clusters[cmd.name] = cluster
+	if err = writeConfig(path, cfg); err != nil {
+		failf("failed to write config file %v err=%v", path, err)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: map[string]interface{}{"cluster": cmd.name, "config": path, "metadata": cluster.Metadata}, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+// probeCluster connects to the brokers of c with its TLS and SASL settings,
+// reads the cluster's metadata and lists the subjects of its schema
+// registry, if any.
+func probeCluster(c clusterConfig, version sarama.KafkaVersion, timeout time.Duration) (*clusterMetadata, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = version
+	if usr, err := user.Current(); err == nil {
+		cfg.ClientID = "kt-config-" + sanitizeUsername(usr.Username)
+	}
+	cfg.Net.DialTimeout, cfg.Net.ReadTimeout, cfg.Net.WriteTimeout = timeout, timeout, timeout
+	cfg.Metadata.Retry.Max = 1
+
+	tlsConfig, err := setupBrokerCerts(c.TLSCert, c.TLSCA, c.TLSCertKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup certificates err=%v", err)
+	}
+	if tlsConfig != nil {
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+	if err = setupSASL(cfg, c.SASL.args()); err != nil {
+		return nil, fmt.Errorf("failed to setup SASL err=%v", err)
+	}
+
+	client, err := sarama.NewClient(parseBrokers(c.Brokers), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client err=%v", err)
+	}
+	defer logClose("client", client)
+
+	m := &clusterMetadata{Checked: time.Now().UTC().Truncate(time.Second), Controller: -1, Brokers: []string{}}
+	brokers := client.Brokers()
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID() < brokers[j].ID() })
+	for _, b := range brokers {
+		m.Brokers = append(m.Brokers, fmt.Sprintf("%v=%v", b.ID(), b.Addr()))
+	}
+	if controller, err := client.Controller(); err == nil {
+		m.Controller = controller.ID()
+	}
+	topics, err := client.Topics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topics err=%v", err)
+	}
+	m.Topics = len(topics)
+
+	if c.SchemaRegistry == "" {
+		return m, nil
+	}
+	registry, err := newSchemaRegistry(registryArgs{url: c.SchemaRegistry})
+	if err != nil {
+		return nil, err
+	}
+	registry.client.Timeout = timeout
+	subjects := []string{}
+	if err = registry.get("/subjects", &subjects); err != nil {
+		return nil, fmt.Errorf("failed to list subjects of schema registry %v err=%v", c.SchemaRegistry, err)
+	}
+	n := len(subjects)
+	m.Subjects = &n
+	return m, nil
+}
+
+// writeConfig replaces the config file at path in the format of its
+// extension, via a temporary file so it's never left half written. It's
+// only readable by the user as it may contain passwords.
+func writeConfig(path string, cfg ktConfig) error {
+	var (
+		buf []byte
+		err error
+	)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		buf, err = yaml.Marshal(cfg)
+	default:
+		buf, err = json.MarshalIndent(cfg, "", "  ")
+		buf = append(buf, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+var configDocString = `
+config add-cluster adds a cluster profile to the config file (see "kt -help")
+or edits an existing one, changing only the settings that are passed, e.g.
+
+  $ kt config add-cluster -name prod -brokers kafka-1.prod:9093,kafka-2.prod:9093 \
+      -tlsca /etc/kafka/prod-ca.pem -sasl-mechanism SCRAM-SHA-512 -sasl-user kt \
+      -sasl-password secret -schema-registry https://registry.prod
+  $ kt config add-cluster -name prod -sasl-password rotated
+
+Before saving, it connects to the brokers with the profile's TLS and SASL
+settings, reads the cluster's metadata and lists the subjects of the schema
+registry, so that typos in endpoints or credentials show up now rather than
+when the profile is needed. It stores the brokers, the controller and the
+number of topics and subjects it found with the profile under "metadata".
+Pass -skip-check to save a profile without connecting.
+
+The config file is rewritten without comments and only readable by the
+current user, as profiles may contain passwords.`
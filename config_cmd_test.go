@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigMerge(t *testing.T) {
+	existing := clusterConfig{
+		Brokers:  "kafka-1.prod:9093",
+		TLSCA:    "/etc/kafka/prod-ca.pem",
+		SASL:     saslConfig{Mechanism: "SCRAM-SHA-512", User: "kt", Password: "secret"},
+		Defaults: flagDefaults{"pretty": false},
+		Metadata: &clusterMetadata{Topics: 3},
+	}
+
+	cmd := &configCmd{}
+	cmd.parseArgs([]string{"add-cluster", "-name", "prod", "-sasl-password", "rotated", "-tlsca", ""})
+	require.Equal(t, "prod", cmd.name)
+	require.Equal(t, clusterConfig{
+		Brokers:  "kafka-1.prod:9093",
+		SASL:     saslConfig{Mechanism: "SCRAM-SHA-512", User: "kt", Password: "rotated"},
+		Defaults: flagDefaults{"pretty": false},
+	}, cmd.merge(existing))
+
+	cmd = &configCmd{}
+	cmd.parseArgs([]string{"add-cluster", "-name", "dev", "-brokers", "localhost:9092"})
+	require.Equal(t, clusterConfig{Brokers: "localhost:9092"}, cmd.merge(clusterConfig{}))
+}
+
+func TestWriteConfig(t *testing.T) {
+	subjects := 2
+	cfg := ktConfig{
+		Clusters: map[string]clusterConfig{
+			"prod": {
+				Brokers:  "kafka-1.prod:9093",
+				SASL:     saslConfig{Mechanism: "PLAIN", User: "kt", Password: "secret"},
+				Defaults: flagDefaults{"schema-registry": "http://registry.prod"},
+				Metadata: &clusterMetadata{Checked: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), Controller: 1, Brokers: []string{"1=kafka-1.prod:9093"}, Topics: 3, Subjects: &subjects},
+			},
+		},
+	}
+
+	for _, name := range []string{"config.yaml", "config.json"} {
+		path := filepath.Join(t.TempDir(), "kt", name)
+		require.Nil(t, writeConfig(path, cfg))
+		info, err := os.Stat(path)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		read, err := readConfig(path)
+		require.Nil(t, err)
+		require.Equal(t, cfg.Clusters["prod"].Metadata, read.Clusters["prod"].Metadata, name)
+		require.Equal(t, cfg.Clusters["prod"].SASL, read.Clusters["prod"].SASL, name)
+		require.Equal(t, "http://registry.prod", read.Clusters["prod"].Defaults["schema-registry"], name)
+	}
+}
+
+func TestProbeCluster(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("payments", 0, broker.BrokerID()),
+	})
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/subjects", r.URL.Path)
+		w.Write([]byte(`["orders-value"]`))
+	}))
+	defer registry.Close()
+
+	m, err := probeCluster(clusterConfig{Brokers: broker.Addr(), SchemaRegistry: registry.URL}, sarama.V1_0_0_0, time.Second)
+	require.Nil(t, err)
+	require.Equal(t, []string{"1=" + broker.Addr()}, m.Brokers)
+	require.Equal(t, int32(1), m.Controller)
+	require.Equal(t, 2, m.Topics)
+	require.Equal(t, 1, *m.Subjects)
+
+	_, err = probeCluster(clusterConfig{Brokers: broker.Addr(), SchemaRegistry: "http://127.0.0.1:1"}, sarama.V1_0_0_0, time.Second)
+	require.NotNil(t, err)
+}
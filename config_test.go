@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kt.json")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{
+  "clusters": {
+    "prod": {
+      "brokers": "kafka-1.prod,kafka-2.prod:9093",
+      "defaults": {"schema-registry": "http://registry.prod", "pretty": false},
+      "topics": {"orders": {"max-messages": 10}}
+    }
+  },
+  "topics": {
+    "orders": {"encodevalue": "avro", "max-messages": 5, "decodevalue": "avro"}
+  }
+}`), 0644))
+	os.Setenv("KT_CONFIG", path)
+	defer os.Unsetenv("KT_CONFIG")
+
+	parse := func(as ...string) (string, string, bool, int, string) {
+		var (
+			flags                    = flag.NewFlagSet("test", flag.ContinueOnError)
+			brokers, topic, enc, reg string
+			pretty                   bool
+			maxMessages              int
+		)
+		flags.StringVar(&brokers, "brokers", "", "")
+		flags.StringVar(&topic, "topic", "", "")
+		flags.StringVar(&enc, "encodevalue", "string", "")
+		flags.StringVar(&reg, "schema-registry", "", "")
+		flags.BoolVar(&pretty, "pretty", true, "")
+		flags.IntVar(&maxMessages, "max-messages", 0, "")
+		require.Nil(t, flags.Parse(as))
+		require.Nil(t, applyConfig(flags, brokers, topic))
+		return enc, reg, pretty, maxMessages, topic
+	}
+
+	enc, reg, pretty, max, _ := parse("-topic", "orders", "-brokers", "kafka-2.prod:9093")
+	require.Equal(t, []interface{}{"avro", "http://registry.prod", false, 10}, []interface{}{enc, reg, pretty, max})
+
+	enc, reg, pretty, max, _ = parse("-topic", "orders", "-encodevalue", "hex")
+	require.Equal(t, []interface{}{"hex", "", true, 5}, []interface{}{enc, reg, pretty, max})
+
+	os.Setenv("KT_TOPIC", "orders")
+	defer os.Unsetenv("KT_TOPIC")
+	enc, _, _, _, topic := parse()
+	require.Equal(t, "avro", enc)
+	require.Equal(t, "", topic)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"topics": {"orders": {"max-messages": "many"}}}`), 0644))
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("max-messages", 0, "")
+	require.NotNil(t, applyConfig(flags, "", "orders"))
+}
+
+func TestReadConfigMissing(t *testing.T) {
+	cfg, err := readConfig(filepath.Join(os.TempDir(), "kt-missing-config.json"))
+	require.Nil(t, err)
+	require.Empty(t, cfg.defaults("localhost:9092", "orders")[0])
+}
+
+func TestClusterFlag(t *testing.T) {
+	os.Unsetenv("KT_CLUSTER")
+	name, args, err := clusterFlag([]string{"-cluster", "prod", "consume", "-topic", "orders"})
+	require.Nil(t, err)
+	require.Equal(t, "prod", name)
+	require.Equal(t, []string{"consume", "-topic", "orders"}, args)
+
+	name, args, err = clusterFlag([]string{"--cluster=dev", "admin", "-cluster"})
+	require.Nil(t, err)
+	require.Equal(t, "dev", name)
+	require.Equal(t, []string{"admin", "-cluster"}, args)
+
+	os.Setenv("KT_CLUSTER", "staging")
+	defer os.Unsetenv("KT_CLUSTER")
+	name, args, err = clusterFlag([]string{"topic"})
+	require.Nil(t, err)
+	require.Equal(t, "staging", name)
+	require.Equal(t, []string{"topic"}, args)
+
+	_, _, err = clusterFlag([]string{"-cluster"})
+	require.NotNil(t, err)
+}
+
+func TestUseCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`
+clusters:
+  prod:
+    brokers: kafka-1.prod:9093
+    tlsca: /etc/kafka/prod-ca.pem
+    sasl: {mechanism: SCRAM-SHA-512, user: kt, password: secret}
+    schema-registry: https://registry.prod
+    defaults: {max-messages: 10}
+  prod-admin:
+    brokers: kafka-1.prod:9093
+  dev:
+    brokers: localhost:9092
+`), 0644))
+	os.Setenv("KT_CONFIG", path)
+	defer os.Unsetenv("KT_CONFIG")
+	keys := []string{"KT_CLUSTER", "KT_BROKERS", "KT_TLS_CA", "KT_SASL_MECHANISM", "KT_SASL_USER", "KT_SASL_PASSWORD", "KT_SCHEMA_REGISTRY"}
+	for _, k := range keys {
+		defer os.Unsetenv(k)
+	}
+
+	require.Nil(t, useCluster("prod"))
+	actual := []string{}
+	for _, k := range keys {
+		actual = append(actual, os.Getenv(k))
+	}
+	require.Equal(t, []string{"prod", "kafka-1.prod:9093", "/etc/kafka/prod-ca.pem", "SCRAM-SHA-512", "kt", "secret", "https://registry.prod"}, actual)
+
+	cfg, err := readConfig(path)
+	require.Nil(t, err)
+	require.Equal(t, flagDefaults{"max-messages": 10}, cfg.defaults("kafka-1.prod:9093", "orders")[0])
+
+	require.Nil(t, useCluster("dev"))
+	require.Equal(t, "localhost:9092", os.Getenv("KT_BROKERS"))
+	require.Equal(t, "", os.Getenv("KT_SASL_PASSWORD"))
+
+	require.NotNil(t, useCluster("missing"))
+}
+
+func TestStdinConfig(t *testing.T) {
+	in := strings.NewReader(`{"topic": "orders", "max-messages": 10, "pretty": false, "header": ["a:1", "b:2"], "group": null}
+{"value": "a"}`)
+	args, rest, err := stdinConfig("consume", []string{"-config", "-", "-topic", "payments"}, in)
+	require.Nil(t, err)
+	require.Equal(t, []string{"-header=a:1", "-header=b:2", "-max-messages=10", "-pretty=false", "-topic=orders", "-topic", "payments"}, args)
+	buf, err := ioutil.ReadAll(rest)
+	require.Nil(t, err)
+	require.Equal(t, "\n{\"value\": \"a\"}", string(buf))
+
+	args, rest, err = stdinConfig("group", []string{"reset", "-config", "-"}, strings.NewReader(`{"group": "g"}`))
+	require.Nil(t, err)
+	require.NotNil(t, rest)
+	require.Equal(t, []string{"reset", "-group=g"}, args)
+
+	args, rest, err = stdinConfig("consume", []string{"-config", "retention.ms=1000"}, strings.NewReader(`{}`))
+	require.Nil(t, err)
+	require.Nil(t, rest)
+	require.Equal(t, []string{"-config", "retention.ms=1000"}, args)
+
+	// admin's own -config is left alone.
+	args, rest, err = stdinConfig("admin", []string{"-alterconfig", "orders", "-config", "-"}, strings.NewReader(`{"topic": "orders"}`))
+	require.Nil(t, err)
+	require.Nil(t, rest)
+	require.Equal(t, []string{"-alterconfig", "orders", "-config", "-"}, args)
+
+	_, _, err = stdinConfig("consume", []string{"-config", "-"}, strings.NewReader(`{"topic": {"name": "orders"}}`))
+	require.NotNil(t, err)
+	_, _, err = stdinConfig("consume", []string{"-config", "-"}, strings.NewReader(`["orders"]`))
+	require.NotNil(t, err)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// maxPendingChunks limits the incomplete chunked values kept per partition,
+// e.g. when consuming starts after the first chunks of a value. The oldest
+// one is dropped when another one starts.
+const maxPendingChunks = 1000
+
+// chunkHeaders names the headers of chunked records: the ID of the value
+// the record is a chunk of, the chunk's index and the number of chunks.
+type chunkHeaders struct {
+	id    string
+	index string
+	count string
+}
+
+func parseChunkHeaders(str string) (chunkHeaders, error) {
+	if str == "" {
+		return chunkHeaders{}, nil
+	}
+	names := strings.Split(str, ",")
+	if len(names) != 3 {
+		return chunkHeaders{}, fmt.Errorf("invalid chunk-headers %#v, expected the names of the id, index and count headers like chunk-id,chunk-index,chunk-count", str)
+	}
+	for _, n := range names {
+		if strings.TrimSpace(n) == "" {
+			return chunkHeaders{}, fmt.Errorf("invalid chunk-headers %#v, header names cannot be empty", str)
+		}
+	}
+	return chunkHeaders{strings.TrimSpace(names[0]), strings.TrimSpace(names[1]), strings.TrimSpace(names[2])}, nil
+}
+
+// parseChunkNumber reads the index or count of a chunk, which producers
+// write as a decimal string or as a big-endian integer of 4 or 8 bytes.
+func parseChunkNumber(data []byte) (int64, error) {
+	if n, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		return n, nil
+	}
+	switch len(data) {
+	case 4:
+		return int64(int32(binary.BigEndian.Uint32(data))), nil
+	case 8:
+		return int64(binary.BigEndian.Uint64(data)), nil
+	}
+	return 0, fmt.Errorf("invalid chunk number %#v", string(data))
+}
+
+// pendingChunks are the chunks of a value received so far.
+type pendingChunks struct {
+	first  *sarama.ConsumerMessage
+	count  int64
+	chunks map[int64][]byte
+}
+
+// chunkAssembler reassembles the values of chunked records for
+// -chunk-headers. A reassembled record has the partition, offset, key and
+// timestamp of its first chunk and its headers without the chunk headers.
+// Like consumeStats, a nil *chunkAssembler is valid and passes records
+// through.
+type chunkAssembler struct {
+	sync.Mutex
+	headers chunkHeaders
+	pending map[topicPartition]map[string]*pendingChunks
+	order   map[topicPartition][]string // IDs of pending, oldest first
+}
+
+func newChunkAssembler(headers chunkHeaders) *chunkAssembler {
+	if headers == (chunkHeaders{}) {
+		return nil
+	}
+	return &chunkAssembler{
+		headers: headers,
+		pending: map[topicPartition]map[string]*pendingChunks{},
+		order:   map[topicPartition][]string{},
+	}
+}
+
+// add returns msg if it isn't a chunk, the reassembled record if msg is the
+// last missing chunk of its value, and false otherwise.
+func (a *chunkAssembler) add(msg *sarama.ConsumerMessage) (*sarama.ConsumerMessage, bool) {
+	if a == nil {
+		return msg, true
+	}
+	id, index, count, ok := a.chunkOf(msg)
+	if !ok {
+		return msg, true
+	}
+	if count <= 1 {
+		return a.reassembled(msg, [][]byte{msg.Value}), true
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	tp := topicPartition{msg.Topic, msg.Partition}
+	if a.pending[tp] == nil {
+		a.pending[tp] = map[string]*pendingChunks{}
+	}
+	p, ok := a.pending[tp][id]
+	if !ok {
+		if len(a.order[tp]) >= maxPendingChunks {
+			oldest := a.order[tp][0]
+			fmt.Fprintf(os.Stderr, "dropping incomplete chunked value %#v on partition %v of topic %v, more than %v values are incomplete\n", oldest, msg.Partition, msg.Topic, maxPendingChunks)
+			a.remove(tp, oldest)
+		}
+		p = &pendingChunks{first: msg, count: count, chunks: map[int64][]byte{}}
+		a.pending[tp][id] = p
+		a.order[tp] = append(a.order[tp], id)
+	}
+	// chunks may be repeated when producers retry.
+	p.chunks[index] = msg.Value
+	if int64(len(p.chunks)) < p.count {
+		return nil, false
+	}
+
+	a.remove(tp, id)
+	indexes := make([]int64, 0, len(p.chunks))
+	for i := range p.chunks {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	values := make([][]byte, 0, len(indexes))
+	for _, i := range indexes {
+		values = append(values, p.chunks[i])
+	}
+	return a.reassembled(p.first, values), true
+}
+
+// chunkOf reads the chunk headers of msg, records without an id header
+// aren't chunked.
+func (a *chunkAssembler) chunkOf(msg *sarama.ConsumerMessage) (id string, index, count int64, ok bool) {
+	var hasIndex, hasCount bool
+	var err error
+	for _, h := range msg.Headers {
+		switch string(h.Key) {
+		case a.headers.id:
+			id, ok = string(h.Value), true
+		case a.headers.index:
+			if index, err = parseChunkNumber(h.Value); err == nil {
+				hasIndex = true
+			}
+		case a.headers.count:
+			if count, err = parseChunkNumber(h.Value); err == nil {
+				hasCount = true
+			}
+		}
+	}
+	if ok && (!hasIndex || !hasCount) {
+		fmt.Fprintf(os.Stderr, "record at offset %v on partition %v of topic %v has chunk id %#v but no valid %v and %v headers, printing it as it is\n", msg.Offset, msg.Partition, msg.Topic, id, a.headers.index, a.headers.count)
+		return "", 0, 0, false
+	}
+	return id, index, count, ok
+}
+
+func (a *chunkAssembler) remove(tp topicPartition, id string) {
+	delete(a.pending[tp], id)
+	for i, o := range a.order[tp] {
+		if o == id {
+			a.order[tp] = append(a.order[tp][:i], a.order[tp][i+1:]...)
+			break
+		}
+	}
+}
+
+func (a *chunkAssembler) reassembled(first *sarama.ConsumerMessage, values [][]byte) *sarama.ConsumerMessage {
+	msg := *first
+	msg.Value = bytes.Join(values, nil)
+	msg.Headers = nil
+	for _, h := range first.Headers {
+		switch string(h.Key) {
+		case a.headers.id, a.headers.index, a.headers.count:
+		default:
+			msg.Headers = append(msg.Headers, h)
+		}
+	}
+	return &msg
+}
+
+// incomplete returns the number of values per partition that are missing
+// chunks.
+func (a *chunkAssembler) incomplete() map[topicPartition]int {
+	if a == nil {
+		return nil
+	}
+	a.Lock()
+	defer a.Unlock()
+	result := map[topicPartition]int{}
+	for tp, p := range a.pending {
+		if len(p) > 0 {
+			result[tp] = len(p)
+		}
+	}
+	return result
+}
+
+// reportIncompleteChunks notes on stderr the chunked values that consume
+// didn't print as chunks were missing, e.g. as consuming started after their
+// first chunks.
+func (cmd *consumeCmd) reportIncompleteChunks() {
+	for tp, n := range cmd.chunks.incomplete() {
+		fmt.Fprintf(os.Stderr, "skipped %v chunked values on partition %v of topic %v that are missing chunks\n", n, tp.partition, tp.topic)
+	}
+}
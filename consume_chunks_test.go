@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChunkHeaders(t *testing.T) {
+	headers, err := parseChunkHeaders("chunk-id, chunk-index, chunk-count")
+	require.NoError(t, err)
+	require.Equal(t, chunkHeaders{"chunk-id", "chunk-index", "chunk-count"}, headers)
+
+	headers, err = parseChunkHeaders("")
+	require.NoError(t, err)
+	require.Nil(t, newChunkAssembler(headers))
+
+	_, err = parseChunkHeaders("chunk-id,chunk-index")
+	require.Error(t, err)
+	_, err = parseChunkHeaders("chunk-id,,chunk-count")
+	require.Error(t, err)
+}
+
+func TestParseChunkNumber(t *testing.T) {
+	for data, expected := range map[string]int64{
+		"3":                                3,
+		"\x00\x00\x00\x02":                 2,
+		"\x00\x00\x00\x00\x00\x00\x00\x05": 5,
+	} {
+		n, err := parseChunkNumber([]byte(data))
+		require.NoError(t, err)
+		require.Equal(t, expected, n)
+	}
+	_, err := parseChunkNumber([]byte("x"))
+	require.Error(t, err)
+}
+
+func TestChunkAssembler(t *testing.T) {
+	var disabled *chunkAssembler
+	plain := &sarama.ConsumerMessage{Value: []byte("plain")}
+	msg, ok := disabled.add(plain)
+	require.True(t, ok)
+	require.Equal(t, plain, msg)
+
+	chunk := func(offset int64, id, index string, value string) *sarama.ConsumerMessage {
+		return &sarama.ConsumerMessage{
+			Topic:     "documents",
+			Partition: 1,
+			Offset:    offset,
+			Key:       []byte("doc"),
+			Value:     []byte(value),
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte("trace"), Value: []byte("abc")},
+				{Key: []byte("chunk-id"), Value: []byte(id)},
+				{Key: []byte("chunk-index"), Value: []byte(index)},
+				{Key: []byte("chunk-count"), Value: []byte("3")},
+			},
+		}
+	}
+
+	a := newChunkAssembler(chunkHeaders{"chunk-id", "chunk-index", "chunk-count"})
+	msg, ok = a.add(plain)
+	require.True(t, ok)
+	require.Equal(t, plain, msg)
+
+	for _, m := range []*sarama.ConsumerMessage{
+		chunk(10, "a", "0", "he"),
+		chunk(11, "b", "1", "or"),
+		chunk(12, "a", "2", "o"),
+		chunk(13, "a", "2", "o"), // repeated
+	} {
+		_, ok = a.add(m)
+		require.False(t, ok)
+	}
+	msg, ok = a.add(chunk(14, "a", "1", "ll"))
+	require.True(t, ok)
+	require.Equal(t, int64(10), msg.Offset)
+	require.Equal(t, []byte("doc"), msg.Key)
+	require.Equal(t, "hello", string(msg.Value))
+	require.Equal(t, []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}}, msg.Headers)
+
+	require.Equal(t, map[topicPartition]int{{"documents", 1}: 1}, a.incomplete())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Shopify/sarama"
+)
+
+// committedOffset is printed for each partition by -commit-only. Previous
+// is missing if the group had no offset committed for the partition.
+type committedOffset struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Previous  *int64 `json:"previous,omitempty"`
+	Offset    int64  `json:"offset"`
+}
+
+// commitStartOffsets commits the start offset per -offsets of each of the
+// partitions for -group rather than consuming them. Offsets move forward or
+// back, like group -reset.
+func (cmd *consumeCmd) commitStartOffsets(partitions []topicPartition) {
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	for _, tp := range partitions {
+		_, _, start, ok := cmd.partitionStart(tp.topic, tp.partition)
+		if !ok {
+			continue
+		}
+		ctx := printContext{output: cmd.commitOffset(cmd.getPOM(tp.topic, tp.partition), tp, start), done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+
+	// rather than at the next -commit-interval.
+	cmd.offsetManager.Commit()
+}
+
+func (cmd *consumeCmd) commitOffset(pom sarama.PartitionOffsetManager, tp topicPartition, offset int64) committedOffset {
+	result := committedOffset{Topic: tp.topic, Partition: tp.partition, Offset: offset}
+	previous, _ := pom.NextOffset()
+	if previous >= 0 {
+		result.Previous = &previous
+	}
+
+	if offset > previous {
+		pom.MarkOffset(offset, "")
+	} else {
+		pom.ResetOffset(offset, "")
+	}
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "committing offset %v of partition %v of topic %v for group %v\n", offset, tp.partition, tp.topic, cmd.group)
+	}
+	return result
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePOM struct {
+	sarama.PartitionOffsetManager
+	next   int64
+	marked *int64
+	reset  *int64
+}
+
+func (p *fakePOM) NextOffset() (int64, string) { return p.next, "" }
+
+func (p *fakePOM) MarkOffset(offset int64, _ string) { p.marked = &offset }
+
+func (p *fakePOM) ResetOffset(offset int64, _ string) { p.reset = &offset }
+
+func TestConsumeCommitOffset(t *testing.T) {
+	cmd := &consumeCmd{group: "billing"}
+	tp := topicPartition{"orders", 1}
+	i64 := func(i int64) *int64 { return &i }
+
+	pom := &fakePOM{next: 10}
+	require.Equal(t, committedOffset{Topic: "orders", Partition: 1, Previous: i64(10), Offset: 20}, cmd.commitOffset(pom, tp, 20))
+	require.Equal(t, i64(20), pom.marked)
+	require.Nil(t, pom.reset)
+
+	pom = &fakePOM{next: 10}
+	require.Equal(t, committedOffset{Topic: "orders", Partition: 1, Previous: i64(10), Offset: 3}, cmd.commitOffset(pom, tp, 3))
+	require.Nil(t, pom.marked)
+	require.Equal(t, i64(3), pom.reset)
+
+	// without a committed offset, NextOffset is the initial offset.
+	pom = &fakePOM{next: sarama.OffsetNewest}
+	require.Equal(t, committedOffset{Topic: "orders", Partition: 1, Offset: 0}, cmd.commitOffset(pom, tp, 0))
+	require.Equal(t, i64(0), pom.marked)
+}
+
+func TestConsumeParseArgsCommitOnly(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-group", "billing", "-offsets", "all=newest:", "-commit-only"})
+	require.True(t, target.commitOnly)
+	require.Equal(t, "billing", target.group)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-group", "billing"})
+	require.False(t, target.commitOnly)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// controlRecord is printed for the transaction markers of -show-control-records,
+// which sarama's partition consumers skip.
+type controlRecord struct {
+	Topic     string         `json:"topic,omitempty"`
+	Partition int32          `json:"partition"`
+	Offset    int64          `json:"offset"`
+	Timestamp *time.Time     `json:"timestamp,omitempty"`
+	Control   controlDetails `json:"control"`
+}
+
+type controlDetails struct {
+	Type             string `json:"type"` // commit, abort or unknown
+	ProducerID       int64  `json:"producerId"`
+	ProducerEpoch    int16  `json:"producerEpoch"`
+	CoordinatorEpoch *int32 `json:"coordinatorEpoch,omitempty"`
+}
+
+// parseControlRecord reads the marker of a control batch, its key is a
+// version and the type (0 abort, 1 commit), its value a version and the
+// epoch of the transaction coordinator that wrote it.
+func parseControlRecord(key, value []byte) controlDetails {
+	d := controlDetails{Type: "unknown"}
+	if len(key) >= 4 {
+		switch binary.BigEndian.Uint16(key[2:4]) {
+		case 0:
+			d.Type = "abort"
+		case 1:
+			d.Type = "commit"
+		}
+	}
+	if len(value) >= 6 {
+		epoch := int32(binary.BigEndian.Uint32(value[2:6]))
+		d.CoordinatorEpoch = &epoch
+	}
+	return d
+}
+
+// printControl prints msg as a control record if pc passed it on as one and
+// returns whether it did.
+func (cmd *consumeCmd) printControl(out chan printContext, pc sarama.PartitionConsumer, msg *sarama.ConsumerMessage) bool {
+	cpc, ok := pc.(*fetchPartitionConsumer)
+	if !ok {
+		return false
+	}
+	rec, ok := cpc.control(msg)
+	if !ok {
+		return false
+	}
+	if cmd.topicRegex != nil {
+		rec.Topic = msg.Topic
+	}
+	ctx := printContext{output: rec, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+	return true
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseControlRecord(t *testing.T) {
+	epoch := int32(3)
+	require.Equal(t, controlDetails{Type: "commit", CoordinatorEpoch: &epoch}, parseControlRecord([]byte{0, 0, 0, 1}, []byte{0, 0, 0, 0, 0, 3}))
+	require.Equal(t, controlDetails{Type: "abort"}, parseControlRecord([]byte{0, 0, 0, 0}, nil))
+	require.Equal(t, controlDetails{Type: "unknown"}, parseControlRecord(nil, nil))
+}
+
+func TestConsumeParseArgsIsolation(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-isolation", "read_committed", "-show-control-records"})
+	require.Equal(t, sarama.ReadCommitted, target.isolation)
+	require.True(t, target.showControl)
+	require.True(t, target.version.IsAtLeast(sarama.V0_11_0_0))
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans"})
+	require.Equal(t, sarama.ReadUncommitted, target.isolation)
+	require.False(t, target.showControl)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// fetchReport is printed to stderr for each fetch request of -show-fetch.
+type fetchReport struct {
+	Fetch fetchDetails `json:"fetch"`
+}
+
+// fetchDetails describes a fetch of a partition: the broker that served it,
+// the offset and size requested, and the record batches, records and bytes
+// of keys and values it returned.
+type fetchDetails struct {
+	Broker        int32   `json:"broker"`
+	Addr          string  `json:"addr"`
+	Topic         string  `json:"topic"`
+	Partition     int32   `json:"partition"`
+	Offset        int64   `json:"offset"`
+	FetchSize     int32   `json:"fetchSize"`
+	Batches       int     `json:"batches"`
+	Records       int     `json:"records"`
+	Bytes         int     `json:"bytes"`
+	Partial       bool    `json:"partial,omitempty"`
+	LatencyMs     float64 `json:"latencyMs"`
+	ThrottleMs    int64   `json:"throttleMs"`
+	HighWaterMark int64   `json:"highWaterMark"`
+	Err           string  `json:"error,omitempty"`
+}
+
+func newFetchDetails(broker *sarama.Broker, topic string, partition int32, offset int64, size int32, latency, throttle time.Duration, block *sarama.FetchResponseBlock) fetchDetails {
+	d := fetchDetails{
+		Broker:        broker.ID(),
+		Addr:          broker.Addr(),
+		Topic:         topic,
+		Partition:     partition,
+		Offset:        offset,
+		FetchSize:     size,
+		Partial:       block.Partial,
+		LatencyMs:     durationMs(latency),
+		ThrottleMs:    int64(throttle / time.Millisecond),
+		HighWaterMark: block.HighWaterMarkOffset,
+	}
+	if block.Err != sarama.ErrNoError {
+		d.Err = block.Err.Error()
+	}
+	for _, records := range block.RecordsSet {
+		if records.RecordBatch == nil {
+			continue
+		}
+		d.Batches++
+		d.Records += len(records.RecordBatch.Records)
+		for _, rec := range records.RecordBatch.Records {
+			d.Bytes += len(rec.Key) + len(rec.Value)
+		}
+	}
+	return d
+}
+
+// durationMs is d in milliseconds with microsecond precision, as fetches
+// from a local broker often take less than a millisecond.
+func durationMs(d time.Duration) float64 {
+	return float64(d/time.Microsecond) / 1000
+}
+
+// fetchLog prints the reports of -show-fetch as JSON lines, one at a time as
+// partitions are fetched concurrently. Like consumeStats, a nil *fetchLog is
+// valid and doesn't print anything.
+type fetchLog struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func newFetchLog(enabled bool, w io.Writer) *fetchLog {
+	if !enabled {
+		return nil
+	}
+	return &fetchLog{w: w}
+}
+
+func (l *fetchLog) print(report interface{}) {
+	if l == nil {
+		return
+	}
+	buf, err := json.Marshal(report)
+	if err != nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.w.Write(append(buf, '\n'))
+}
+
+// watchThrottle returns whether to consume with fetchPartitionConsumer to
+// see when brokers throttle fetches, as sarama's consumer doesn't pass on
+// the throttle time. -verbose, -stats and -metricsaddr report it.
+func (cmd *consumeCmd) watchThrottle() bool {
+	return (cmd.verbose || cmd.summary != nil || cmd.metrics != nil) && cmd.version.IsAtLeast(sarama.V0_11_0_0)
+}
+
+// recordThrottle reports that broker throttled a fetch for throttle due to
+// quotas.
+func (cmd *consumeCmd) recordThrottle(broker *sarama.Broker, throttle time.Duration) {
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "broker %v throttled fetch request for %v due to quota violation\n", broker.Addr(), throttle)
+	}
+	cmd.metrics.throttled(broker.Addr(), throttle)
+	cmd.summary.throttled(throttle)
+}
+
+// fetchPartitionConsumer is a sarama.PartitionConsumer that fetches record
+// batches itself, so it can pass on control records for
+// -show-control-records, report each fetch for -show-fetch and pass the time
+// brokers throttled fetches for to throttled. Messages of
+// control records are looked up with control. With read_committed it skips
+// the records of aborted transactions like sarama does.
+type fetchPartitionConsumer struct {
+	broker      func() (*sarama.Broker, error)
+	refresh     func() error
+	topic       string
+	partition   int32
+	offset      int64
+	fetchSize   int32
+	maxSize     int32
+	maxWait     time.Duration
+	isolation   sarama.IsolationLevel
+	showControl bool
+	log         *fetchLog
+	throttled   func(broker *sarama.Broker, throttle time.Duration)
+
+	messages chan *sarama.ConsumerMessage
+	errors   chan *sarama.ConsumerError
+	controls sync.Map // *sarama.ConsumerMessage to controlRecord
+	hwm      int64
+	paused   int32
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+func newFetchPartitionConsumer(client sarama.Client, topic string, partition int32, offset int64, showControl bool, log *fetchLog, throttled func(*sarama.Broker, time.Duration)) *fetchPartitionConsumer {
+	cfg := client.Config()
+	pc := &fetchPartitionConsumer{
+		broker:      func() (*sarama.Broker, error) { return client.Leader(topic, partition) },
+		refresh:     func() error { return client.RefreshMetadata(topic) },
+		topic:       topic,
+		partition:   partition,
+		offset:      offset,
+		fetchSize:   cfg.Consumer.Fetch.Default,
+		maxSize:     cfg.Consumer.Fetch.Max,
+		maxWait:     cfg.Consumer.MaxWaitTime,
+		isolation:   cfg.Consumer.IsolationLevel,
+		showControl: showControl,
+		log:         log,
+		throttled:   throttled,
+		messages:    make(chan *sarama.ConsumerMessage, cfg.ChannelBufferSize),
+		errors:      make(chan *sarama.ConsumerError, cfg.ChannelBufferSize),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go pc.run()
+	return pc
+}
+
+func (pc *fetchPartitionConsumer) run() {
+	defer close(pc.stopped)
+	for {
+		select {
+		case <-pc.done:
+			return
+		default:
+		}
+		if atomic.LoadInt32(&pc.paused) == 1 {
+			time.Sleep(pc.maxWait)
+			continue
+		}
+		if err := pc.fetch(); err != nil {
+			select {
+			case pc.errors <- &sarama.ConsumerError{Topic: pc.topic, Partition: pc.partition, Err: err}:
+			case <-pc.done:
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// fetch requests the records after the current offset and passes them on.
+func (pc *fetchPartitionConsumer) fetch() error {
+	broker, err := pc.broker()
+	if err != nil {
+		return err
+	}
+	req := &sarama.FetchRequest{
+		Version:     4,
+		MaxWaitTime: int32(pc.maxWait / time.Millisecond),
+		MinBytes:    1,
+		MaxBytes:    sarama.MaxResponseSize,
+		Isolation:   pc.isolation,
+	}
+	req.AddBlock(pc.topic, pc.partition, pc.offset, pc.fetchSize, -1)
+	started := time.Now()
+	resp, err := broker.Fetch(req)
+	if err != nil {
+		return err
+	}
+	block := resp.GetBlock(pc.topic, pc.partition)
+	if block == nil {
+		return fmt.Errorf("missing partition %v of topic %v in fetch response", pc.partition, pc.topic)
+	}
+	pc.log.print(fetchReport{Fetch: newFetchDetails(broker, pc.topic, pc.partition, pc.offset, pc.fetchSize, time.Since(started), resp.ThrottleTime, block)})
+	if resp.ThrottleTime > 0 && pc.throttled != nil {
+		pc.throttled(broker, resp.ThrottleTime)
+	}
+	switch block.Err {
+	case sarama.ErrNoError:
+	case sarama.ErrNotLeaderForPartition, sarama.ErrLeaderNotAvailable, sarama.ErrUnknownTopicOrPartition:
+		return pc.refresh()
+	default:
+		return block.Err
+	}
+	atomic.StoreInt64(&pc.hwm, block.HighWaterMarkOffset)
+
+	batches := []*sarama.RecordBatch{}
+	for _, records := range block.RecordsSet {
+		if records.MsgSet != nil {
+			return fmt.Errorf("-show-control-records and -show-fetch require the message format of Kafka 0.11.0.0 or later")
+		}
+		if records.RecordBatch != nil && len(records.RecordBatch.Records) > 0 {
+			batches = append(batches, records.RecordBatch)
+		}
+	}
+	switch {
+	case len(batches) > 0:
+	case block.Partial || len(block.RecordsSet) > 0:
+		// the next batch is larger than the fetch size.
+		if pc.maxSize > 0 && pc.fetchSize >= pc.maxSize {
+			return sarama.ErrMessageTooLarge
+		}
+		pc.fetchSize *= 2
+		if pc.maxSize > 0 && pc.fetchSize > pc.maxSize {
+			pc.fetchSize = pc.maxSize
+		}
+		return nil
+	case block.LastRecordsBatchOffset != nil && *block.LastRecordsBatchOffset >= pc.offset && *block.LastRecordsBatchOffset < block.HighWaterMarkOffset:
+		// batches without records, e.g. after compaction, like sarama skips
+		// them.
+		pc.offset = *block.LastRecordsBatchOffset + 1
+		return nil
+	}
+	return pc.send(batches, block.AbortedTransactions)
+}
+
+// send passes on the records of batches. With read_committed, records of
+// producers are skipped from the first offset of an aborted transaction up
+// to its abort marker.
+func (pc *fetchPartitionConsumer) send(batches []*sarama.RecordBatch, aborted []*sarama.AbortedTransaction) error {
+	sort.Slice(aborted, func(i, j int) bool { return aborted[i].FirstOffset < aborted[j].FirstOffset })
+	aborting := map[int64]bool{}
+	for _, batch := range batches {
+		for pc.isolation == sarama.ReadCommitted && len(aborted) > 0 && aborted[0].FirstOffset <= batch.LastOffset() {
+			aborting[aborted[0].ProducerID] = true
+			aborted = aborted[1:]
+		}
+		skip := batch.IsTransactional && !batch.Control && aborting[batch.ProducerID]
+
+		for _, rec := range batch.Records {
+			offset := batch.FirstOffset + rec.OffsetDelta
+			if offset < pc.offset || skip {
+				continue
+			}
+			ts := batch.FirstTimestamp.Add(rec.TimestampDelta)
+			if batch.LogAppendTime {
+				ts = batch.MaxTimestamp
+			}
+			msg := &sarama.ConsumerMessage{
+				Topic:     pc.topic,
+				Partition: pc.partition,
+				Offset:    offset,
+				Key:       rec.Key,
+				Value:     rec.Value,
+				Headers:   rec.Headers,
+				Timestamp: ts,
+			}
+			if batch.Control {
+				d := parseControlRecord(rec.Key, rec.Value)
+				d.ProducerID, d.ProducerEpoch = batch.ProducerID, batch.ProducerEpoch
+				if d.Type == "abort" {
+					delete(aborting, batch.ProducerID)
+				}
+				if !pc.showControl {
+					continue
+				}
+				pc.controls.Store(msg, controlRecord{Partition: pc.partition, Offset: offset, Timestamp: &ts, Control: d})
+			}
+			select {
+			case pc.messages <- msg:
+			case <-pc.done:
+				return nil
+			}
+		}
+		if next := batch.LastOffset() + 1; next > pc.offset {
+			pc.offset = next
+		}
+	}
+	return nil
+}
+
+// control returns the control record of msg, if it is one.
+func (pc *fetchPartitionConsumer) control(msg *sarama.ConsumerMessage) (controlRecord, bool) {
+	rec, ok := pc.controls.Load(msg)
+	if !ok {
+		return controlRecord{}, false
+	}
+	pc.controls.Delete(msg)
+	return rec.(controlRecord), true
+}
+
+func (pc *fetchPartitionConsumer) AsyncClose() {
+	pc.once.Do(func() { close(pc.done) })
+}
+
+func (pc *fetchPartitionConsumer) Close() error {
+	pc.AsyncClose()
+	<-pc.stopped
+	return nil
+}
+
+func (pc *fetchPartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return pc.messages }
+
+func (pc *fetchPartitionConsumer) Errors() <-chan *sarama.ConsumerError { return pc.errors }
+
+func (pc *fetchPartitionConsumer) HighWaterMarkOffset() int64 { return atomic.LoadInt64(&pc.hwm) }
+
+func (pc *fetchPartitionConsumer) Pause() { atomic.StoreInt32(&pc.paused, 1) }
+
+func (pc *fetchPartitionConsumer) Resume() { atomic.StoreInt32(&pc.paused, 0) }
+
+func (pc *fetchPartitionConsumer) IsPaused() bool { return atomic.LoadInt32(&pc.paused) == 1 }
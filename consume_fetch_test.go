@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/fgeller/kt/pkg/consume"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchPartitionConsumerSend(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	batches := []*sarama.RecordBatch{
+		{FirstOffset: 10, LastOffsetDelta: 1, FirstTimestamp: ts, ProducerID: 7, IsTransactional: true, Records: []*sarama.Record{{Value: []byte("a")}, {OffsetDelta: 1, Value: []byte("b")}}},
+		{FirstOffset: 12, FirstTimestamp: ts, ProducerID: 7, ProducerEpoch: 1, IsTransactional: true, Control: true, Records: []*sarama.Record{{Key: []byte{0, 0, 0, 0}, Value: []byte{0, 0, 0, 0, 0, 0}}}},
+		{FirstOffset: 13, FirstTimestamp: ts, Records: []*sarama.Record{{Value: []byte("c")}}},
+	}
+	aborted := []*sarama.AbortedTransaction{{ProducerID: 7, FirstOffset: 10}}
+
+	read := func(isolation sarama.IsolationLevel, offset int64, showControl bool) (*fetchPartitionConsumer, []*sarama.ConsumerMessage) {
+		pc := &fetchPartitionConsumer{topic: "orders", offset: offset, isolation: isolation, showControl: showControl, messages: make(chan *sarama.ConsumerMessage, 10), done: make(chan struct{})}
+		require.Nil(t, pc.send(batches, aborted))
+		close(pc.messages)
+		msgs := []*sarama.ConsumerMessage{}
+		for m := range pc.messages {
+			msgs = append(msgs, m)
+		}
+		require.Equal(t, int64(14), pc.offset)
+		return pc, msgs
+	}
+
+	pc, msgs := read(sarama.ReadUncommitted, 11, true)
+	require.Len(t, msgs, 3)
+	require.Equal(t, int64(11), msgs[0].Offset)
+	_, ok := pc.control(msgs[0])
+	require.False(t, ok)
+	rec, ok := pc.control(msgs[1])
+	require.True(t, ok)
+	require.Equal(t, int64(12), rec.Offset)
+	require.Equal(t, "abort", rec.Control.Type)
+	require.Equal(t, int64(7), rec.Control.ProducerID)
+	require.Equal(t, int16(1), rec.Control.ProducerEpoch)
+	require.Equal(t, ts, *rec.Timestamp)
+
+	pc, msgs = read(sarama.ReadCommitted, 10, true)
+	require.Len(t, msgs, 2)
+	_, ok = pc.control(msgs[0])
+	require.True(t, ok)
+	require.Equal(t, []byte("c"), msgs[1].Value)
+
+	// without -show-control-records markers are skipped like sarama does.
+	pc, msgs = read(sarama.ReadUncommitted, 10, false)
+	require.Len(t, msgs, 3)
+	require.Equal(t, int64(13), msgs[2].Offset)
+	for _, m := range msgs {
+		_, ok = pc.control(m)
+		require.False(t, ok)
+	}
+}
+
+func TestNewFetchDetails(t *testing.T) {
+	broker := sarama.NewBroker("localhost:9092")
+	block := &sarama.FetchResponseBlock{
+		HighWaterMarkOffset: 20,
+		RecordsSet: []*sarama.Records{
+			{RecordBatch: &sarama.RecordBatch{Records: []*sarama.Record{{Key: []byte("k"), Value: []byte("abc")}, {Value: []byte("de")}}}},
+			{RecordBatch: &sarama.RecordBatch{Control: true, Records: []*sarama.Record{{Key: []byte{0, 0, 0, 1}}}}},
+		},
+	}
+	d := newFetchDetails(broker, "orders", 2, 10, 1024, 1500*time.Microsecond, 20*time.Millisecond, block)
+	require.Equal(t, fetchDetails{
+		Broker:        -1,
+		Addr:          "localhost:9092",
+		Topic:         "orders",
+		Partition:     2,
+		Offset:        10,
+		FetchSize:     1024,
+		Batches:       2,
+		Records:       3,
+		Bytes:         10,
+		LatencyMs:     1.5,
+		ThrottleMs:    20,
+		HighWaterMark: 20,
+	}, d)
+
+	block = &sarama.FetchResponseBlock{Err: sarama.ErrNotLeaderForPartition}
+	d = newFetchDetails(broker, "orders", 2, 10, 1024, 0, 0, block)
+	require.Equal(t, sarama.ErrNotLeaderForPartition.Error(), d.Err)
+}
+
+func TestFetchLog(t *testing.T) {
+	var nilLog *fetchLog
+	nilLog.print(fetchReport{})
+	require.Nil(t, newFetchLog(false, os.Stderr))
+
+	buf := &bytes.Buffer{}
+	l := newFetchLog(true, buf)
+	l.print(fetchReport{Fetch: fetchDetails{Broker: 1, Topic: "orders", Records: 2}})
+	l.print(map[string]int{"n": 1})
+	require.Equal(t, `{"fetch":{"broker":1,"addr":"","topic":"orders","partition":0,"offset":0,"fetchSize":0,"batches":0,"records":2,"bytes":0,"latencyMs":0,"throttleMs":0,"highWaterMark":0}}
+{"n":1}
+`, buf.String())
+}
+
+func TestConsumeParseArgsShowFetch(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-show-fetch"})
+	require.NotNil(t, target.fetchLog)
+	require.False(t, target.showControl)
+	require.True(t, target.version.IsAtLeast(sarama.V0_11_0_0))
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans"})
+	require.Nil(t, target.fetchLog)
+}
+
+func TestConsumeRecordThrottle(t *testing.T) {
+	cmd := &consumeCmd{version: sarama.V2_0_0_0}
+	require.False(t, cmd.watchThrottle())
+
+	cmd.summary = newConsumeStats(true)
+	cmd.metrics = newTrafficMetrics("127.0.0.1:0", false)
+	require.True(t, cmd.watchThrottle())
+	cmd.recordThrottle(sarama.NewBroker("localhost:9092"), 30*time.Millisecond)
+	require.Equal(t, int64(1), cmd.summary.summary().ThrottledFetches)
+
+	var buf bytes.Buffer
+	cmd.metrics.registry.write(&buf)
+	require.Contains(t, buf.String(), `kt_broker_throttled_responses_total{broker="localhost:9092"} 1`)
+
+	// fetch requests of older versions don't report throttling.
+	cmd.version = sarama.V0_10_2_0
+	require.False(t, cmd.watchThrottle())
+}
+
+func TestConsumeUntilEndSkippedLastOffset(t *testing.T) {
+	// offset 2 before the high water mark 3 is a commit marker, so the
+	// consumer never passes on the end offset.
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetch.AddRecordBatch("hans", 0, nil, sarama.StringEncoder("a"), 0, 7, true)
+	fetch.AddRecordBatch("hans", 0, nil, sarama.StringEncoder("b"), 1, 7, true)
+	fetch.AddControlRecord("hans", 0, 2, 7, sarama.ControlRecordCommit)
+	fetch.GetBlock("hans", 0).HighWaterMarkOffset = 3
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("hans", 0, broker.BrokerID()),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	require.Nil(t, err)
+	defer client.Close()
+
+	out := make(chan printContext)
+	go func() {
+		for ctx := range out {
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	messages := make(chan *sarama.ConsumerMessage, 2)
+	messages <- &sarama.ConsumerMessage{Topic: "hans", Partition: 0, Offset: 0, Value: []byte("a")}
+	messages <- &sarama.ConsumerMessage{Topic: "hans", Partition: 0, Offset: 1, Value: []byte("b")}
+	target := &consumeCmd{client: client, version: sarama.V2_0_0_0, untilEnd: true, encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json"}
+
+	done := make(chan struct{})
+	go func() {
+		pt := newPartitionTurn("hans", 0)
+		pt.End = 2
+		target.partitionLoop(out, tPartitionConsumer{messages: messages, highWaterMarkOffset: 3}, pt, 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * consume.EndCheckInterval):
+		t.Fatal("partition loop didn't stop at the skipped end offset")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// maxGapRanges limits the ranges listed per partition in the -report-gaps
+// summary, a compacted topic can have one after almost every record.
+const maxGapRanges = 100
+
+// offsetGap is a range of offsets that consume skipped because there are no
+// records at them, e.g. due to compaction, transaction markers and aborted
+// transactions, or deleted segments.
+type offsetGap struct {
+	From    int64 `json:"from"`
+	To      int64 `json:"to"`
+	Missing int64 `json:"missing"`
+}
+
+// gapRecord is printed before the record after a gap with -report-gaps
+// records.
+type gapRecord struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Gap       offsetGap `json:"gap"`
+}
+
+// partitionGaps summarizes the gaps of a partition between the start offset
+// and the last consumed offset for -report-gaps summary. Gaps lists the
+// first maxGapRanges of them.
+type partitionGaps struct {
+	Topic      string      `json:"topic"`
+	Partition  int32       `json:"partition"`
+	Start      int64       `json:"start"`
+	LastOffset *int64      `json:"lastOffset,omitempty"`
+	Consumed   int64       `json:"consumed"`
+	Missing    int64       `json:"missing"`
+	GapCount   int64       `json:"gapCount"`
+	Gaps       []offsetGap `json:"gaps"`
+}
+
+// gapReporter tracks the offsets consumed per partition for -report-gaps.
+// Like consumeStats, a nil *gapReporter is valid and doesn't track anything.
+type gapReporter struct {
+	sync.Mutex
+	records    bool // print gap records, otherwise only the summary
+	partitions map[topicPartition]*partitionGaps
+	next       map[topicPartition]int64
+	printOnce  sync.Once
+}
+
+func newGapReporter(mode string) *gapReporter {
+	if mode == "" {
+		return nil
+	}
+	return &gapReporter{
+		records:    mode == "records",
+		partitions: map[topicPartition]*partitionGaps{},
+		next:       map[topicPartition]int64{},
+	}
+}
+
+// start records the offset consuming a partition starts at, where its
+// first record is expected.
+func (r *gapReporter) start(topic string, partition int32, offset int64) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	tp := topicPartition{topic, partition}
+	r.partitions[tp] = &partitionGaps{Topic: topic, Partition: partition, Start: offset, Gaps: []offsetGap{}}
+	r.next[tp] = offset
+}
+
+// observe returns the gap before msg, if any, and whether to print it.
+func (r *gapReporter) observe(msg *sarama.ConsumerMessage) (*gapRecord, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	tp := topicPartition{msg.Topic, msg.Partition}
+	p, ok := r.partitions[tp]
+	if !ok {
+		// e.g. a partition consumed by a group, which starts wherever the
+		// group left off.
+		p = &partitionGaps{Topic: msg.Topic, Partition: msg.Partition, Start: msg.Offset, Gaps: []offsetGap{}}
+		r.partitions[tp] = p
+		r.next[tp] = msg.Offset
+	}
+	offset := msg.Offset
+	p.Consumed++
+	p.LastOffset = &offset
+
+	next := r.next[tp]
+	r.next[tp] = msg.Offset + 1
+	if msg.Offset <= next {
+		return nil, false
+	}
+
+	gap := offsetGap{From: next, To: msg.Offset - 1, Missing: msg.Offset - next}
+	p.Missing += gap.Missing
+	p.GapCount++
+	if len(p.Gaps) < maxGapRanges {
+		p.Gaps = append(p.Gaps, gap)
+	}
+	return &gapRecord{Topic: msg.Topic, Partition: msg.Partition, Gap: gap}, r.records
+}
+
+// summary returns the partitions sorted by topic and partition.
+func (r *gapReporter) summary() []partitionGaps {
+	r.Lock()
+	defer r.Unlock()
+	result := []partitionGaps{}
+	for _, p := range r.partitions {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Topic != result[j].Topic {
+			return result[i].Topic < result[j].Topic
+		}
+		return result[i].Partition < result[j].Partition
+	})
+	return result
+}
+
+// printGaps prints the gap summary of each partition once consuming ended.
+func (cmd *consumeCmd) printGaps() {
+	if cmd.gaps == nil || cmd.gaps.records {
+		return
+	}
+	cmd.gaps.printOnce.Do(func() {
+		out := make(chan printContext)
+		go print(out, cmd.pretty)
+		for _, p := range cmd.gaps.summary() {
+			ctx := printContext{output: p, done: make(chan struct{})}
+			out <- ctx
+			<-ctx.done
+		}
+	})
+}
+
+// printGap prints the gap before msg for -report-gaps records, in the output
+// like a record.
+func (cmd *consumeCmd) printGap(out chan printContext, msg *sarama.ConsumerMessage) {
+	gap, show := cmd.gaps.observe(msg)
+	if !show {
+		return
+	}
+	ctx := printContext{output: gap, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGapReporter(t *testing.T) {
+	var disabled *gapReporter
+	disabled.start("hans", 0, 0)
+	gap, show := disabled.observe(&sarama.ConsumerMessage{Topic: "hans", Offset: 5})
+	require.Nil(t, gap)
+	require.False(t, show)
+
+	r := newGapReporter("summary")
+	r.start("hans", 0, 10)
+	for _, o := range []int64{12, 13, 17} {
+		gap, show = r.observe(&sarama.ConsumerMessage{Topic: "hans", Partition: 0, Offset: o})
+		require.False(t, show)
+	}
+	require.Equal(t, &gapRecord{Topic: "hans", Partition: 0, Gap: offsetGap{From: 14, To: 16, Missing: 3}}, gap)
+
+	last := int64(17)
+	require.Equal(t, []partitionGaps{{
+		Topic:      "hans",
+		Partition:  0,
+		Start:      10,
+		LastOffset: &last,
+		Consumed:   3,
+		Missing:    5,
+		GapCount:   2,
+		Gaps:       []offsetGap{{From: 10, To: 11, Missing: 2}, {From: 14, To: 16, Missing: 3}},
+	}}, r.summary())
+}
+
+func TestConsumeReportGapRecords(t *testing.T) {
+	out := make(chan printContext)
+	outputs := make(chan interface{}, 10)
+	go func() {
+		for ctx := range out {
+			outputs <- ctx.output
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	messages := make(chan *sarama.ConsumerMessage, 2)
+	messages <- &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 3, Value: []byte("a")}
+	messages <- &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 5, Value: []byte("b")}
+	target := &consumeCmd{encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json", gaps: newGapReporter("records")}
+	target.gaps.start("hans", 1, 3)
+	pt := newPartitionTurn("hans", 1)
+	pt.End = 5
+	target.partitionLoop(out, tPartitionConsumer{messages: messages}, pt, 0)
+
+	require.Len(t, outputs, 3)
+	<-outputs
+	require.Equal(t, &gapRecord{Topic: "hans", Partition: 1, Gap: offsetGap{From: 4, To: 4, Missing: 1}}, <-outputs)
+}
+
+func TestConsumeParseArgsReportGaps(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-report-gaps", "summary", "-stats"})
+	require.NotNil(t, target.gaps)
+	require.False(t, target.gaps.records)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans"})
+	require.Nil(t, target.gaps)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Shopify/sarama"
+	"github.com/fgeller/kt/pkg/consume"
+)
+
+// consumeBalanced joins -group and consumes the partitions the group assigns
+// to this instance until interrupted. Offsets are committed by the group, so
+// other members continue where kt left off after a rebalance.
+func (cmd *consumeCmd) consumeBalanced() {
+	group, err := sarama.NewConsumerGroupFromClient(cmd.group, cmd.client)
+	if err != nil {
+		failf("failed to create consumer group err=%v", err)
+	}
+	defer logClose("consumer group", group)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := make(chan struct{})
+	go listenForInterrupt(q)
+	go func() { <-q; cancel() }()
+	if cmd.limitReached != nil {
+		go func() { <-cmd.limitReached; cancel() }()
+	}
+
+	go func() {
+		for err := range group.Errors() {
+			fmt.Fprintf(os.Stderr, "consumer group %v encountered err %v\n", cmd.group, err)
+		}
+	}()
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	handler := &groupHandler{cmd: cmd, out: out}
+	for ctx.Err() == nil {
+		// Consume returns whenever the group rebalances.
+		if err := group.Consume(ctx, cmd.topics, handler); err != nil {
+			failf("failed to consume as group %v err=%v", cmd.group, err)
+		}
+	}
+}
+
+type groupHandler struct {
+	cmd *consumeCmd
+	out chan printContext
+}
+
+func (h *groupHandler) Setup(s sarama.ConsumerGroupSession) error {
+	if h.cmd.verbose {
+		fmt.Fprintf(os.Stderr, "joined group %v as %v with partitions %v\n", h.cmd.group, s.MemberID(), s.Claims())
+	}
+	if h.cmd.fallback.start == offsetTime {
+		return h.startAtFallback(s)
+	}
+	return nil
+}
+
+// startAtFallback marks the -fallback-offset timestamp's offset for claimed
+// partitions without committed offset. sarama only knows oldest and newest
+// as initial offsets, and claims start consuming after Setup returns.
+func (h *groupHandler) startAtFallback(s sarama.ConsumerGroupSession) error {
+	for topic, partitions := range s.Claims() {
+		for _, p := range partitions {
+			committed, err := fetchCommittedOffset(h.cmd.client, h.cmd.group, topic, p)
+			if err != nil {
+				return err
+			}
+			if committed >= 0 {
+				continue
+			}
+
+			off, err := h.cmd.resolveFallbackOffset(topic, p)
+			if err != nil {
+				return fmt.Errorf("failed to resolve fallback offset for partition %v of topic %v err=%v", p, topic, err)
+			}
+			if h.cmd.verbose {
+				fmt.Fprintf(os.Stderr, "partition %v of topic %v has no committed offset, starting at %v\n", p, topic, off)
+			}
+			s.MarkOffset(topic, p, off, "")
+		}
+	}
+	return nil
+}
+
+func (h *groupHandler) Cleanup(s sarama.ConsumerGroupSession) error {
+	if h.cmd.verbose {
+		fmt.Fprintf(os.Stderr, "releasing partitions %v of group %v\n", s.Claims(), h.cmd.group)
+	}
+	return nil
+}
+
+func (h *groupHandler) ConsumeClaim(s sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	part := consume.NewPartition(claim.Topic(), claim.Partition(), claim.InitialOffset(), -1)
+	h.cmd.progress.partitionStarted(part.Topic, part.Partition, part.Next, part.End)
+	h.cmd.gaps.start(part.Topic, part.Partition, part.Next)
+	defer func() { h.cmd.progress.partitionDone(part.Topic, part.Partition, part.Last, part.Count) }()
+	defer h.cmd.flushRepeats(h.out, part.Topic, part.Partition)
+
+	// the session ends once -max-messages were printed, as consumeBalanced
+	// cancels the group's context.
+	part.Consume(s.Context(), claim, func(msg *sarama.ConsumerMessage) bool {
+		h.cmd.metrics.consumed(msg, claim.HighWaterMarkOffset())
+		h.cmd.printGap(h.out, msg)
+		h.cmd.printSchemaChanges(h.out, msg)
+		h.cmd.recording.add(msg)
+		if !h.cmd.printMessage(h.out, msg) {
+			return false
+		}
+		h.cmd.flushPipeline()
+		s.MarkMessage(msg, "")
+		h.cmd.offsetMarked(s)
+		h.cmd.progress.progress(msg.Topic, msg.Partition, msg.Offset, part.Count+1)
+		return true
+	})
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+type tGroupSession struct {
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *tGroupSession) Claims() map[string][]int32                            { return nil }
+func (s *tGroupSession) MemberID() string                                      { return "kt-1" }
+func (s *tGroupSession) GenerationID() int32                                   { return 1 }
+func (s *tGroupSession) MarkOffset(topic string, p int32, o int64, md string)  {}
+func (s *tGroupSession) Commit()                                               {}
+func (s *tGroupSession) ResetOffset(topic string, p int32, o int64, md string) {}
+func (s *tGroupSession) MarkMessage(msg *sarama.ConsumerMessage, md string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+func (s *tGroupSession) Context() context.Context { return s.ctx }
+
+type tGroupClaim struct{ messages chan *sarama.ConsumerMessage }
+
+func (c *tGroupClaim) Topic() string                            { return "hans" }
+func (c *tGroupClaim) Partition() int32                         { return 0 }
+func (c *tGroupClaim) InitialOffset() int64                     { return 0 }
+func (c *tGroupClaim) HighWaterMarkOffset() int64               { return 2 }
+func (c *tGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestGroupHandlerConsumeClaim(t *testing.T) {
+	filter, err := parseFilter(`value != "skip"`)
+	require.Nil(t, err)
+
+	out := make(chan printContext)
+	h := &groupHandler{cmd: &consumeCmd{filter: filter}, out: out}
+	session := &tGroupSession{ctx: context.Background()}
+	claim := &tGroupClaim{messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 0, Value: []byte("skip")}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 1, Value: []byte("print")}
+	close(claim.messages)
+
+	done := make(chan error)
+	go func() { done <- h.ConsumeClaim(session, claim) }()
+
+	ctx := <-out
+	require.Equal(t, "print", *ctx.output.(consumedMessage).Value.(*string))
+	close(ctx.done)
+
+	require.Nil(t, <-done)
+	require.Equal(t, []int64{0, 1}, session.marked)
+}
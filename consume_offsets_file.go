@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetsFileEntry is a partition of an -offsets-file. Start and End are
+// absolute offsets as numbers or offsets like those of -offsets as
+// strings, e.g. "newest-10" or "@2023-01-02T00:00:00Z". Offset is read as
+// the start of entries of kt group export files.
+type offsetsFileEntry struct {
+	Topic     string      `json:"topic"`
+	Partition *int32      `json:"partition"`
+	Start     interface{} `json:"start"`
+	End       interface{} `json:"end"`
+	Offset    interface{} `json:"offset"`
+}
+
+// offsetsFile is either a list of entries or an object that lists them as
+// partitions or, like kt group export, as offsets.
+type offsetsFile struct {
+	Partitions []offsetsFileEntry `json:"partitions"`
+	Offsets    []offsetsFileEntry `json:"offsets"`
+}
+
+// readOffsetsFile reads the intervals to consume per partition from the
+// -offsets-file at path, stdin for -. Entries of other topics than topic
+// are skipped, unless topic is empty.
+func readOffsetsFile(path, topic string) (map[int32]interval, error) {
+	var (
+		buf []byte
+		err error
+	)
+	if path == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []offsetsFileEntry
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = dec.Decode(&entries)
+	} else {
+		var f offsetsFile
+		err = dec.Decode(&f)
+		entries = append(f.Partitions, f.Offsets...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid offsets file %v err=%v", path, err)
+	}
+
+	result := map[int32]interval{}
+	for _, e := range entries {
+		if e.Partition == nil || *e.Partition < 0 {
+			return nil, fmt.Errorf("invalid entry in offsets file %v, every entry needs a partition", path)
+		}
+		if topic != "" && e.Topic != "" && e.Topic != topic {
+			continue
+		}
+		if _, ok := result[*e.Partition]; ok {
+			return nil, fmt.Errorf("duplicate entry for partition %v in offsets file %v", *e.Partition, path)
+		}
+
+		i := interval{
+			start: offset{relative: true, start: sarama.OffsetOldest},
+			end:   offset{start: 1<<63 - 1},
+		}
+		start := e.Start
+		if start == nil {
+			start = e.Offset
+		}
+		if start != nil {
+			if i.start, err = parseOffsetsFileOffset(start); err != nil {
+				return nil, fmt.Errorf("invalid start of partition %v in offsets file %v err=%v", *e.Partition, path, err)
+			}
+		}
+		if e.End != nil {
+			if i.end, err = parseOffsetsFileOffset(e.End); err != nil {
+				return nil, fmt.Errorf("invalid end of partition %v in offsets file %v err=%v", *e.Partition, path, err)
+			}
+			// like in -offsets, the end stops before the first message at or
+			// after a timestamp or percentage.
+			if i.end.start == offsetTime || i.end.start == offsetPercent {
+				i.end.diff = -1
+			}
+		}
+		result[*e.Partition] = i
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("found no partitions of topic %v in offsets file %v", topic, path)
+	}
+	return result, nil
+}
+
+func parseOffsetsFileOffset(v interface{}) (offset, error) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil || n < 0 {
+			return offset{}, fmt.Errorf("invalid offset %v", v)
+		}
+		return offset{start: n}, nil
+	case string:
+		return parseOffset(v)
+	}
+	return offset{}, fmt.Errorf("invalid offset %v, expected a number or a string", v)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOffsetsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "offsets.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	open := offset{start: 1<<63 - 1}
+	oldest := offset{relative: true, start: sarama.OffsetOldest}
+
+	offsets, err := readOffsetsFile(write(`{"partitions": [
+		{"partition": 0, "start": 1200, "end": 1300},
+		{"partition": 3, "start": "newest-10"},
+		{"topic": "orders", "partition": 4, "end": "50%"},
+		{"topic": "payments", "partition": 5, "start": 1}
+	]}`), "orders")
+	require.NoError(t, err)
+	require.Equal(t, map[int32]interval{
+		0: {start: offset{start: 1200}, end: offset{start: 1300}},
+		3: {start: offset{relative: true, start: sarama.OffsetNewest, diff: -10}, end: open},
+		4: {start: oldest, end: offset{relative: true, start: offsetPercent, percent: 50, diff: -1}},
+	}, offsets)
+
+	// a kt group export file.
+	offsets, err = readOffsetsFile(write(`{"group": "billing", "exported": "2021-03-04T05:06:07Z", "offsets": [
+		{"topic": "orders", "partition": 0, "offset": 42},
+		{"topic": "payments", "partition": 0, "offset": 7}
+	]}`), "orders")
+	require.NoError(t, err)
+	require.Equal(t, map[int32]interval{0: {start: offset{start: 42}, end: open}}, offsets)
+
+	offsets, err = readOffsetsFile(write(`[{"partition": 1}]`), "")
+	require.NoError(t, err)
+	require.Equal(t, map[int32]interval{1: {start: oldest, end: open}}, offsets)
+
+	for _, invalid := range []string{
+		`{"partitions": [{"start": 1}]}`,
+		`[{"partition": 1}, {"partition": 1, "start": 2}]`,
+		`[{"partition": 1, "start": -1}]`,
+		`[{"partition": 1, "end": true}]`,
+		`[{"topic": "payments", "partition": 1}]`,
+		`{"partitions": `,
+	} {
+		_, err = readOffsetsFile(write(invalid), "orders")
+		require.Error(t, err, invalid)
+	}
+	_, err = readOffsetsFile(filepath.Join(dir, "missing.json"), "orders")
+	require.Error(t, err)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// rawOutput is printed as is rather than marshalled to JSON.
+type rawOutput []byte
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// parseOutput validates -output and parses -template for the template
+// format.
+func parseOutput(format, tmpl string) (*template.Template, error) {
+	switch format {
+	case "json", "raw", "raw-length", "tsv", "tail", "rest-proxy", "hexdump":
+		if tmpl != "" {
+			return nil, fmt.Errorf("-template requires -output template")
+		}
+		return nil, nil
+	case "template":
+		if tmpl == "" {
+			return nil, fmt.Errorf("-output template requires -template")
+		}
+		t, err := template.New("output").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template err=%v", err)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("unsupported output argument %#v, only json, raw, raw-length, tsv, template, tail, rest-proxy and hexdump are supported", format)
+}
+
+// tsvField formats a key or value for tsv output, decoded values are
+// printed as JSON and tabs and line breaks are escaped so every message
+// stays on its own line.
+func tsvField(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case *string:
+		if v == nil {
+			return "", nil
+		}
+		return tsvEscaper.Replace(*v), nil
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return tsvEscaper.Replace(string(buf)), nil
+}
+
+// restProxyRecord is the body of a Confluent REST Proxy v3 produce request,
+// i.e. POST /v3/clusters/<cluster>/topics/<topic>/records.
+type restProxyRecord struct {
+	PartitionID int32             `json:"partition_id"`
+	Headers     []restProxyHeader `json:"headers,omitempty"`
+	Key         *restProxyData    `json:"key,omitempty"`
+	Value       *restProxyData    `json:"value,omitempty"`
+	Timestamp   *time.Time        `json:"timestamp,omitempty"`
+}
+
+type restProxyHeader struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value"` // base64
+}
+
+// restProxyData is either BINARY data encoded as base64, or data decoded
+// via the schema registry together with the ID of its schema.
+type restProxyData struct {
+	Type     string      `json:"type,omitempty"`
+	SchemaID int32       `json:"schema_id,omitempty"`
+	Data     interface{} `json:"data"`
+}
+
+// restProxyField passes data that was decoded with the schema of its
+// embedded ID on with that schema. Everything else, including data decoded
+// with a pinned or reader schema, is passed as the original bytes.
+func restProxyField(data []byte, decoded interface{}, d decoder) *restProxyData {
+	if rd, ok := d.(*registryDecoder); ok && rd.pinned == nil && rd.reader == nil {
+		if _, fallback := decoded.(*string); !fallback {
+			if id, _, err := splitWireFormat(data); err == nil {
+				return &restProxyData{SchemaID: id, Data: decoded}
+			}
+		}
+	}
+	return &restProxyData{Type: "BINARY", Data: base64.StdEncoding.EncodeToString(data)}
+}
+
+func (cmd *consumeCmd) restProxyRecord(m consumedMessage, msg *sarama.ConsumerMessage, value []byte) restProxyRecord {
+	r := restProxyRecord{PartitionID: m.Partition, Timestamp: m.Timestamp}
+	for _, h := range msg.Headers {
+		r.Headers = append(r.Headers, restProxyHeader{Name: string(h.Key), Value: encodeBytes(h.Value, "base64")})
+	}
+	if msg.Key != nil {
+		r.Key = restProxyField(msg.Key, m.Key, cmd.keyDecoder)
+	}
+	if msg.Value != nil && !cmd.noValue {
+		r.Value = restProxyField(value, m.Value, cmd.valueDecoder)
+	}
+	return r
+}
+
+// hexdumpRecord dumps the key and value of msg like hexdump -C, headed by
+// the record's coordinates and timestamp and followed by a blank line.
+// value is msg.Value, possibly shortened by -value-bytes.
+func hexdumpRecord(msg *sarama.ConsumerMessage, value []byte) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%v/%v@%v", msg.Topic, msg.Partition, msg.Offset)
+	if !msg.Timestamp.IsZero() {
+		fmt.Fprintf(&buf, " %v", msg.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	buf.WriteByte('\n')
+
+	dump := func(what string, data []byte, size int) {
+		switch {
+		case data == nil:
+			fmt.Fprintf(&buf, "%v null\n", what)
+			return
+		case len(data) < size:
+			fmt.Fprintf(&buf, "%v %v of %v bytes\n", what, len(data), size)
+		default:
+			fmt.Fprintf(&buf, "%v %v bytes\n", what, size)
+		}
+		buf.WriteString(hex.Dump(data))
+	}
+	dump("key", msg.Key, len(msg.Key))
+	if msg.Value == nil {
+		value = nil
+	}
+	dump("value", value, len(msg.Value))
+	buf.WriteByte('\n')
+	return buf.String()
+}
+
+// formatMessage returns what to print for m in the -output format. raw and
+// raw-length print the value's bytes regardless of -encodevalue, the
+// latter prefixed with their length as a 4 byte big endian integer, or -1
+// for tombstones.
+func (cmd *consumeCmd) formatMessage(m consumedMessage, msg *sarama.ConsumerMessage) (interface{}, error) {
+	value := msg.Value
+	if cmd.valueBytes > 0 && len(value) > cmd.valueBytes {
+		value = value[:cmd.valueBytes]
+	}
+
+	switch cmd.output {
+	case "raw":
+		return rawOutput(append(append([]byte{}, value...), '\n')), nil
+
+	case "raw-length":
+		size := int32(len(value))
+		if msg.Value == nil {
+			size = -1
+		}
+		buf := make([]byte, 4, 4+len(value))
+		binary.BigEndian.PutUint32(buf, uint32(size))
+		return rawOutput(append(buf, value...)), nil
+
+	case "tsv":
+		key, err := tsvField(m.Key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := tsvField(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		return rawOutput(fmt.Sprintf("%v\t%v\t%v\t%v\n", m.Partition, m.Offset, key, val)), nil
+
+	case "rest-proxy":
+		return cmd.restProxyRecord(m, msg, value), nil
+
+	case "hexdump":
+		return rawOutput(hexdumpRecord(msg, value)), nil
+
+	case "tail":
+		line, err := cmd.tailLine(m, msg)
+		if err != nil {
+			return nil, err
+		}
+		return rawOutput(line), nil
+
+	case "template":
+		var buf bytes.Buffer
+		if err := cmd.template.Execute(&buf, m); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		return rawOutput(buf.Bytes()), nil
+	}
+
+	return m, nil
+}
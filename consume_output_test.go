@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutput(t *testing.T) {
+	data := []struct {
+		format, tmpl string
+		expectedErr  bool
+	}{
+		{format: "json"},
+		{format: "raw"},
+		{format: "raw-length"},
+		{format: "tsv"},
+		{format: "tail"},
+		{format: "rest-proxy"},
+		{format: "hexdump"},
+		{format: "template", tmpl: "{{.Key}}"},
+		{format: "template", expectedErr: true},
+		{format: "template", tmpl: "{{.Key", expectedErr: true},
+		{format: "json", tmpl: "{{.Key}}", expectedErr: true},
+		{format: "xml", expectedErr: true},
+	}
+
+	for _, d := range data {
+		_, err := parseOutput(d.format, d.tmpl)
+		require.Equal(t, d.expectedErr, err != nil, "%v %v err=%v", d.format, d.tmpl, err)
+	}
+}
+
+func TestConsumeFormatMessage(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	msg := &sarama.ConsumerMessage{Partition: 2, Offset: 42, Key: []byte("k\t1"), Value: []byte("a\nb"), Timestamp: ts}
+
+	data := []struct {
+		output   string
+		tmpl     string
+		msg      *sarama.ConsumerMessage
+		expected string
+	}{
+		{output: "raw", msg: msg, expected: "a\nb\n"},
+		{output: "raw-length", msg: msg, expected: "\x00\x00\x00\x03a\nb"},
+		{output: "raw-length", msg: &sarama.ConsumerMessage{}, expected: "\xff\xff\xff\xff"},
+		{output: "tsv", msg: msg, expected: "2\t42\tk\\t1\ta\\nb\n"},
+		{output: "tsv", msg: &sarama.ConsumerMessage{Offset: 1}, expected: "0\t1\t\t\n"},
+		{output: "template", tmpl: "{{.Partition}}/{{.Offset}} {{.Key}} {{.Timestamp.Unix}}", msg: msg, expected: "2/42 k\t1 1614834367\n"},
+		{output: "template", tmpl: "{{.Value}}\n", msg: msg, expected: "a\nb\n"},
+		{output: "hexdump", msg: &sarama.ConsumerMessage{Topic: "orders", Partition: 3, Offset: 1042, Key: []byte("c-1"), Value: []byte{0, 0, 0, 0, 7, 2}, Timestamp: ts}, expected: `orders/3@1042 2021-03-04T05:06:07Z
+key 3 bytes
+00000000  63 2d 31                                          |c-1|
+value 6 bytes
+00000000  00 00 00 00 07 02                                 |......|
+
+`},
+		{output: "hexdump", msg: &sarama.ConsumerMessage{Topic: "orders", Offset: 1, Value: []byte{}}, expected: "orders/0@1\nkey null\nvalue 0 bytes\n\n"},
+	}
+
+	for _, d := range data {
+		cmd := &consumeCmd{output: d.output, encodeKey: "string", encodeValue: "string"}
+		var err error
+		cmd.template, err = parseOutput(d.output, d.tmpl)
+		require.Nil(t, err)
+
+		m := newConsumedMessage(d.msg, cmd.encodeKey, cmd.encodeValue, "string")
+		actual, err := cmd.formatMessage(m, d.msg)
+		require.Nil(t, err)
+		require.Equal(t, rawOutput(d.expected), actual, d.output)
+	}
+
+	cmd := &consumeCmd{output: "hexdump", valueBytes: 2}
+	m := newConsumedMessage(msg, "string", "string", "string")
+	actual, err := cmd.formatMessage(m, msg)
+	require.Nil(t, err)
+	require.Contains(t, string(actual.(rawOutput)), "value 2 of 3 bytes\n00000000  61 0a ")
+
+	cmd = &consumeCmd{output: "json"}
+	m = newConsumedMessage(msg, "string", "string", "string")
+	actual, err = cmd.formatMessage(m, msg)
+	require.Nil(t, err)
+	require.Equal(t, m, actual)
+}
+
+func TestConsumeRestProxyRecord(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	msg := &sarama.ConsumerMessage{
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("k"),
+		Value:     []byte{0, 0, 0, 0, 7, 2},
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("A")}, {Key: []byte("empty")}},
+		Timestamp: ts,
+	}
+	trace := "QQ=="
+
+	cmd := &consumeCmd{output: "rest-proxy"}
+	m := newConsumedMessage(msg, "string", "string", "string")
+	actual, err := cmd.formatMessage(m, msg)
+	require.Nil(t, err)
+	require.Equal(t, restProxyRecord{
+		PartitionID: 2,
+		Headers:     []restProxyHeader{{Name: "trace", Value: &trace}, {Name: "empty"}},
+		Key:         &restProxyData{Type: "BINARY", Data: "aw=="},
+		Value:       &restProxyData{Type: "BINARY", Data: "AAAAAAcC"},
+		Timestamp:   &ts,
+	}, actual)
+
+	// values decoded with the schema of their ID keep it.
+	cmd.valueDecoder = &registryDecoder{}
+	m.Value = map[string]interface{}{"id": 1}
+	actual, err = cmd.formatMessage(m, msg)
+	require.Nil(t, err)
+	require.Equal(t, &restProxyData{SchemaID: 7, Data: m.Value}, actual.(restProxyRecord).Value)
+
+	cmd.valueDecoder = &registryDecoder{pinned: &avroSchema{typ: "int"}}
+	actual, err = cmd.formatMessage(m, msg)
+	require.Nil(t, err)
+	require.Equal(t, &restProxyData{Type: "BINARY", Data: "AAAAAAcC"}, actual.(restProxyRecord).Value)
+
+	cmd.noValue = true
+	actual, err = cmd.formatMessage(newConsumedMessage(&sarama.ConsumerMessage{}, "string", "string", "string"), &sarama.ConsumerMessage{})
+	require.Nil(t, err)
+	require.Equal(t, restProxyRecord{}, actual)
+}
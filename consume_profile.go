@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// consumeProfiles are the flag defaults of -profile, so fetching can be
+// tuned without knowing how the fetch flags interact:
+//
+//	latency: brokers respond as soon as any data is available.
+//	balanced: brokers wait briefly to return more data per response.
+//	throughput: large fetches and buffers and compact output for exports.
+var consumeProfiles = map[string]flagDefaults{
+	"latency": {
+		"fetch-min":           1,
+		"fetch-max-wait":      "10ms",
+		"channel-buffer-size": 16,
+	},
+	"balanced": {
+		"fetch-min":           65536,
+		"fetch-default":       2097152,
+		"fetch-max-wait":      "100ms",
+		"channel-buffer-size": 1024,
+	},
+	"throughput": {
+		"fetch-min":           1048576,
+		"fetch-default":       8388608,
+		"fetch-max-wait":      "500ms",
+		"channel-buffer-size": 4096,
+		"pretty":              false,
+	},
+}
+
+// applyProfile sets the flags that weren't passed on the command line to
+// the defaults of profile, before the config file's defaults apply.
+func applyProfile(flags *flag.FlagSet, profile string) error {
+	if profile == "" {
+		return nil
+	}
+	defaults, ok := consumeProfiles[profile]
+	if !ok {
+		names := make([]string, 0, len(consumeProfiles))
+		for n := range consumeProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unsupported profile argument %#v, only %v are supported", profile, strings.Join(names, ", "))
+	}
+
+	passed := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	for n, v := range defaults {
+		if passed[n] {
+			continue
+		}
+		if err := flags.Set(n, fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("invalid value %#v for flag %#v of profile %v err=%v", v, n, profile, err)
+		}
+	}
+	return nil
+}
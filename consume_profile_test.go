@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumeParseArgsProfile(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-profile", "throughput"})
+	require.Equal(t, int32(1048576), target.fetchMin)
+	require.Equal(t, int32(8388608), target.fetchDefault)
+	require.Equal(t, 500*time.Millisecond, target.maxWait)
+	require.Equal(t, 4096, target.channelBuffer)
+	require.False(t, target.pretty)
+
+	// flags passed explicitly take precedence.
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-profile", "throughput", "-pretty", "-channel-buffer-size", "64"})
+	require.True(t, target.pretty)
+	require.Equal(t, 64, target.channelBuffer)
+	require.Equal(t, 500*time.Millisecond, target.maxWait)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-profile", "latency"})
+	require.Equal(t, 10*time.Millisecond, target.maxWait)
+	require.True(t, target.pretty)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans"})
+	require.Zero(t, target.maxWait)
+	require.Zero(t, target.fetchMin)
+}
+
+func TestApplyProfile(t *testing.T) {
+	for name := range consumeProfiles {
+		target := &consumeCmd{}
+		target.parseArgs([]string{"-topic", "hans", "-profile", name})
+		cfg := sarama.NewConfig()
+		target.tuneFetches(cfg)
+		require.Nil(t, cfg.Validate(), name)
+	}
+
+	require.NotNil(t, applyProfile(nil, "fast"))
+	require.Nil(t, applyProfile(nil, ""))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// sampler picks the messages to print for -sample: every nth message of
+// each partition for 1/n, or each message with probability p for a fraction
+// p. Like consumeStats, a nil *sampler is valid and keeps all messages.
+type sampler struct {
+	sync.Mutex
+	every       int
+	probability float64
+	random      *rand.Rand
+	seen        map[topicPartition]int
+}
+
+func parseSample(s string) (*sampler, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "1/") {
+		n, err := strconv.Atoi(s[2:])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid sample argument %#v, expected 1/n with n a positive number", s)
+		}
+		return &sampler{every: n, seen: map[topicPartition]int{}}, nil
+	}
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p <= 0 || p > 1 {
+		return nil, fmt.Errorf("invalid sample argument %#v, expected 1/n or a fraction between 0 and 1", s)
+	}
+	return &sampler{probability: p, random: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+}
+
+// keep returns whether msg is part of the sample.
+func (s *sampler) keep(msg *sarama.ConsumerMessage) bool {
+	if s == nil {
+		return true
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.every == 0 {
+		return s.random.Float64() < s.probability
+	}
+	tp := topicPartition{msg.Topic, msg.Partition}
+	s.seen[tp]++
+	return (s.seen[tp]-1)%s.every == 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSample(t *testing.T) {
+	s, err := parseSample("")
+	require.Nil(t, err)
+	require.Nil(t, s)
+	require.True(t, s.keep(&sarama.ConsumerMessage{}))
+
+	s, err = parseSample("1/10")
+	require.Nil(t, err)
+	require.Equal(t, 10, s.every)
+
+	s, err = parseSample("0.25")
+	require.Nil(t, err)
+	require.Equal(t, 0.25, s.probability)
+
+	for _, invalid := range []string{"1/0", "1/x", "2/3", "0", "1.5", "all"} {
+		_, err = parseSample(invalid)
+		require.NotNil(t, err, invalid)
+	}
+}
+
+func TestSamplerKeepEvery(t *testing.T) {
+	s, err := parseSample("1/3")
+	require.Nil(t, err)
+
+	kept := map[int32][]int64{}
+	for offset := int64(0); offset < 7; offset++ {
+		for _, p := range []int32{0, 1} {
+			msg := &sarama.ConsumerMessage{Topic: "orders", Partition: p, Offset: offset}
+			if s.keep(msg) {
+				kept[p] = append(kept[p], offset)
+			}
+		}
+	}
+	require.Equal(t, map[int32][]int64{0: {0, 3, 6}, 1: {0, 3, 6}}, kept)
+}
+
+func TestSamplerKeepProbability(t *testing.T) {
+	s, err := parseSample("1")
+	require.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		require.True(t, s.keep(&sarama.ConsumerMessage{}))
+	}
+
+	s, err = parseSample("0.5")
+	require.Nil(t, err)
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		if s.keep(&sarama.ConsumerMessage{}) {
+			kept++
+		}
+	}
+	require.InDelta(t, 500, kept, 100)
+}
+
+func TestConsumeParseArgsMaxValueBytes(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-max-value-bytes", "1000", "-oversized", "truncate", "-sample", "1/5"})
+	require.Equal(t, 1000, target.maxValueBytes)
+	require.True(t, target.truncateLarge)
+	require.Equal(t, 5, target.sample.every)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans"})
+	require.Zero(t, target.maxValueBytes)
+	require.False(t, target.truncateLarge)
+	require.Nil(t, target.sample)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// hllPrecision is the number of hash bits that select a register, the
+// estimates' standard error is about 1.04/sqrt(2^hllPrecision), i.e. 1.6%.
+const hllPrecision = 12
+
+// hyperLogLog estimates the number of distinct keys of -stats in constant
+// memory.
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+func hashKey(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	// FNV's high bits are poorly mixed for short keys, finish like murmur3.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb3f99ba34b53
+	x ^= x >> 33
+	return x
+}
+
+func (h *hyperLogLog) add(data []byte) {
+	x := hashKey(data)
+	idx := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) merge(o *hyperLogLog) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// estimate uses linear counting for small cardinalities, where the raw
+// HyperLogLog estimate is biased.
+func (h *hyperLogLog) estimate() uint64 {
+	var (
+		m     = float64(len(h.registers))
+		sum   float64
+		zeros int
+	)
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// partitionSummary aggregates the consumed messages of a partition for
+// -stats. DistinctKeys is an estimate and doesn't include messages without
+// key, which are counted as NullKeys.
+type partitionSummary struct {
+	Topic        string     `json:"topic"`
+	Partition    int32      `json:"partition"`
+	Messages     int64      `json:"messages"`
+	KeyBytes     int64      `json:"keyBytes"`
+	ValueBytes   int64      `json:"valueBytes"`
+	Tombstones   int64      `json:"tombstones"`
+	NullKeys     int64      `json:"nullKeys"`
+	DistinctKeys uint64     `json:"distinctKeys"`
+	FirstOffset  *int64     `json:"firstOffset,omitempty"`
+	LastOffset   *int64     `json:"lastOffset,omitempty"`
+	MinTimestamp *time.Time `json:"minTimestamp,omitempty"`
+	MaxTimestamp *time.Time `json:"maxTimestamp,omitempty"`
+
+	keys hyperLogLog
+}
+
+type statsSummary struct {
+	Messages     int64              `json:"messages"`
+	KeyBytes     int64              `json:"keyBytes"`
+	ValueBytes   int64              `json:"valueBytes"`
+	DistinctKeys uint64             `json:"distinctKeys"`
+	MinTimestamp *time.Time         `json:"minTimestamp,omitempty"`
+	MaxTimestamp *time.Time         `json:"maxTimestamp,omitempty"`
+	Partitions   []partitionSummary `json:"partitions"`
+
+	// fetches that brokers throttled due to quotas and for how long.
+	ThrottledFetches int64 `json:"throttledFetches,omitempty"`
+	ThrottleMs       int64 `json:"throttleMs,omitempty"`
+}
+
+// consumeStats collects the partition summaries of -stats. Like
+// sessionStats, a nil *consumeStats is valid and doesn't collect anything.
+type consumeStats struct {
+	sync.Mutex
+	partitions map[topicPartition]*partitionSummary
+	throttles  int64
+	throttle   time.Duration
+}
+
+func newConsumeStats(enabled bool) *consumeStats {
+	if !enabled {
+		return nil
+	}
+	return &consumeStats{partitions: map[topicPartition]*partitionSummary{}}
+}
+
+func (s *consumeStats) add(msg *sarama.ConsumerMessage) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	tp := topicPartition{msg.Topic, msg.Partition}
+	p, ok := s.partitions[tp]
+	if !ok {
+		p = &partitionSummary{Topic: msg.Topic, Partition: msg.Partition}
+		s.partitions[tp] = p
+	}
+
+	p.Messages++
+	p.KeyBytes += int64(len(msg.Key))
+	p.ValueBytes += int64(len(msg.Value))
+	if msg.Value == nil {
+		p.Tombstones++
+	}
+	if msg.Key == nil {
+		p.NullKeys++
+	} else {
+		p.keys.add(msg.Key)
+	}
+
+	offset := msg.Offset
+	if p.FirstOffset == nil || offset < *p.FirstOffset {
+		p.FirstOffset = &offset
+	}
+	if p.LastOffset == nil || offset > *p.LastOffset {
+		p.LastOffset = &offset
+	}
+	p.MinTimestamp, p.MaxTimestamp = widenTimes(p.MinTimestamp, p.MaxTimestamp, msg.Timestamp)
+}
+
+// throttled counts a fetch that a broker throttled for throttle.
+func (s *consumeStats) throttled(throttle time.Duration) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.throttles++
+	s.throttle += throttle
+}
+
+// widenTimes returns min and max extended by t, ignoring unset timestamps.
+func widenTimes(min, max *time.Time, t time.Time) (*time.Time, *time.Time) {
+	if t.IsZero() {
+		return min, max
+	}
+	if min == nil || t.Before(*min) {
+		min = &t
+	}
+	if max == nil || t.After(*max) {
+		max = &t
+	}
+	return min, max
+}
+
+// summary totals the partitions, sorted by topic and partition.
+func (s *consumeStats) summary() statsSummary {
+	s.Lock()
+	defer s.Unlock()
+
+	var (
+		sum  = statsSummary{Partitions: []partitionSummary{}}
+		keys hyperLogLog
+	)
+	for _, p := range s.partitions {
+		p.DistinctKeys = p.keys.estimate()
+		keys.merge(&p.keys)
+		sum.Messages += p.Messages
+		sum.KeyBytes += p.KeyBytes
+		sum.ValueBytes += p.ValueBytes
+		if p.MinTimestamp != nil {
+			sum.MinTimestamp, sum.MaxTimestamp = widenTimes(sum.MinTimestamp, sum.MaxTimestamp, *p.MinTimestamp)
+			sum.MinTimestamp, sum.MaxTimestamp = widenTimes(sum.MinTimestamp, sum.MaxTimestamp, *p.MaxTimestamp)
+		}
+		sum.Partitions = append(sum.Partitions, *p)
+	}
+	sum.DistinctKeys = keys.estimate()
+	sum.ThrottledFetches = s.throttles
+	sum.ThrottleMs = int64(s.throttle / time.Millisecond)
+
+	sort.Slice(sum.Partitions, func(i, j int) bool {
+		if sum.Partitions[i].Topic != sum.Partitions[j].Topic {
+			return sum.Partitions[i].Topic < sum.Partitions[j].Topic
+		}
+		return sum.Partitions[i].Partition < sum.Partitions[j].Partition
+	})
+	return sum
+}
+
+// printStats prints the -stats summary, at most once so that an interrupt
+// and the end of consuming don't both print it.
+func (cmd *consumeCmd) printStats() {
+	if cmd.summary == nil {
+		return
+	}
+	cmd.printStatsOnce.Do(func() {
+		out := make(chan printContext)
+		go print(out, cmd.pretty)
+		ctx := printContext{output: cmd.summary.summary(), done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	var h hyperLogLog
+	require.Equal(t, uint64(0), h.estimate())
+
+	for _, n := range []int{10, 1000, 100000} {
+		var h hyperLogLog
+		for i := 0; i < n; i++ {
+			h.add([]byte(fmt.Sprintf("key-%d", i)))
+			h.add([]byte(fmt.Sprintf("key-%d", i)))
+		}
+		require.InEpsilon(t, n, h.estimate(), 0.05, "n=%v", n)
+	}
+}
+
+func TestConsumeStatsSummary(t *testing.T) {
+	var s *consumeStats
+	s.add(&sarama.ConsumerMessage{})
+	require.Nil(t, newConsumeStats(false))
+
+	t1 := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	s = newConsumeStats(true)
+	s.add(&sarama.ConsumerMessage{Topic: "news", Partition: 1, Offset: 5, Key: []byte("a"), Value: []byte("xyz"), Timestamp: t2})
+	s.add(&sarama.ConsumerMessage{Topic: "news", Partition: 1, Offset: 4, Key: []byte("a"), Timestamp: t1})
+	s.add(&sarama.ConsumerMessage{Topic: "news", Partition: 0, Offset: 9, Value: []byte("v")})
+
+	actual := s.summary()
+	require.Equal(t, int64(3), actual.Messages)
+	require.Equal(t, int64(2), actual.KeyBytes)
+	require.Equal(t, int64(4), actual.ValueBytes)
+	require.Equal(t, uint64(1), actual.DistinctKeys)
+	require.Equal(t, t1, *actual.MinTimestamp)
+	require.Equal(t, t2, *actual.MaxTimestamp)
+
+	require.Len(t, actual.Partitions, 2)
+	p0, p1 := actual.Partitions[0], actual.Partitions[1]
+	require.Equal(t, int32(0), p0.Partition)
+	require.Equal(t, int64(1), p0.NullKeys)
+	require.Equal(t, uint64(0), p0.DistinctKeys)
+	require.Nil(t, p0.MinTimestamp)
+
+	require.Equal(t, int32(1), p1.Partition)
+	require.Equal(t, int64(2), p1.Messages)
+	require.Equal(t, int64(1), p1.Tombstones)
+	require.Equal(t, uint64(1), p1.DistinctKeys)
+	require.Equal(t, int64(4), *p1.FirstOffset)
+	require.Equal(t, int64(5), *p1.LastOffset)
+	require.Equal(t, t1, *p1.MinTimestamp)
+	require.Equal(t, t2, *p1.MaxTimestamp)
+	require.Zero(t, actual.ThrottledFetches)
+
+	s.throttled(150 * time.Millisecond)
+	s.throttled(50 * time.Millisecond)
+	actual = s.summary()
+	require.Equal(t, int64(2), actual.ThrottledFetches)
+	require.Equal(t, int64(200), actual.ThrottleMs)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// streamsKeyLayouts are the binary layouts of keys that Kafka Streams
+// appends window timestamps to, and the number of bytes it appends:
+// window is the key of window store changelogs with the window start and a
+// sequence number, session the key of session store changelogs and of
+// SessionWindowedSerde with the window end and start, and windowed the key
+// of TimeWindowedSerde in repartition and output topics with the window
+// start.
+var streamsKeyLayouts = map[string]int{
+	"window":   12,
+	"session":  16,
+	"windowed": 8,
+}
+
+// streamsKey is a key of -streams-key, Key is decoded with -encodekey.
+type streamsKey struct {
+	Key         interface{} `json:"key"`
+	WindowStart time.Time   `json:"windowStart"`
+	WindowEnd   *time.Time  `json:"windowEnd,omitempty"`
+	Seq         *int32      `json:"seq,omitempty"`
+}
+
+// streamsValue is a value of -streams-value timestamped, the value of a
+// timestamped key value or window store changelog with the timestamp of
+// the record it was last updated by. Value is decoded with -encodevalue.
+type streamsValue struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Value     interface{} `json:"value"`
+}
+
+// streamsDecoder strips the bytes Kafka Streams adds to keys and values of
+// its internal topics and decodes the rest with inner, or as encoding if
+// inner is nil as it's one of string, hex and base64.
+type streamsDecoder struct {
+	layout   string // a streamsKeyLayouts key or timestamped for values
+	inner    decoder
+	encoding string
+}
+
+func (d streamsDecoder) decodeInner(data []byte) (interface{}, error) {
+	if d.inner == nil {
+		return encodeBytes(data, d.encoding), nil
+	}
+	return d.inner.decode(data)
+}
+
+func (d streamsDecoder) decode(data []byte) (interface{}, error) {
+	if d.layout == "timestamped" {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("%v bytes are too short for a timestamped value", len(data))
+		}
+		v, err := d.decodeInner(data[8:])
+		if err != nil {
+			return nil, err
+		}
+		return streamsValue{Timestamp: streamsTime(data[:8]), Value: v}, nil
+	}
+
+	n := streamsKeyLayouts[d.layout]
+	if len(data) < n {
+		return nil, fmt.Errorf("%v bytes are too short for a %v key", len(data), d.layout)
+	}
+	raw, suffix := data[:len(data)-n], data[len(data)-n:]
+	k, err := d.decodeInner(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	result := streamsKey{Key: k}
+	switch d.layout {
+	case "window":
+		seq := int32(binary.BigEndian.Uint32(suffix[8:]))
+		result.WindowStart, result.Seq = streamsTime(suffix[:8]), &seq
+	case "session":
+		end := streamsTime(suffix[:8])
+		result.WindowStart, result.WindowEnd = streamsTime(suffix[8:]), &end
+	case "windowed":
+		result.WindowStart = streamsTime(suffix)
+	}
+	return result, nil
+}
+
+// streamsTime decodes milliseconds since the epoch as Streams serializes
+// them, in UTC.
+func streamsTime(b []byte) time.Time {
+	ms := int64(binary.BigEndian.Uint64(b))
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// setupStreams wraps the key and value decoders for -streams-key and
+// -streams-value.
+func (cmd *consumeCmd) setupStreams() {
+	if cmd.streamsKey != "" {
+		cmd.keyDecoder = streamsDecoder{layout: cmd.streamsKey, inner: cmd.keyDecoder, encoding: cmd.encodeKey}
+	}
+	if cmd.streamsValue != "" {
+		cmd.valueDecoder = streamsDecoder{layout: cmd.streamsValue, inner: cmd.valueDecoder, encoding: cmd.encodeValue}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/fgeller/kt/pkg/codec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamsDecoder(t *testing.T) {
+	start := time.Date(2023, 1, 2, 3, 4, 0, 0, time.UTC)
+	end := start.Add(5 * time.Minute)
+	millis := func(ts time.Time) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(ts.UnixNano()/int64(time.Millisecond)))
+		return b
+	}
+	concat := func(bs ...[]byte) []byte {
+		var result []byte
+		for _, b := range bs {
+			result = append(result, b...)
+		}
+		return result
+	}
+
+	seq := int32(3)
+	v, err := streamsDecoder{layout: "window", encoding: "string"}.decode(concat([]byte("c-1"), millis(start), []byte{0, 0, 0, 3}))
+	require.NoError(t, err)
+	require.Equal(t, streamsKey{Key: encodeBytes([]byte("c-1"), "string"), WindowStart: start, Seq: &seq}, v)
+
+	v, err = streamsDecoder{layout: "session", encoding: "hex"}.decode(concat([]byte{0xab}, millis(end), millis(start)))
+	require.NoError(t, err)
+	require.Equal(t, streamsKey{Key: encodeBytes([]byte{0xab}, "hex"), WindowStart: start, WindowEnd: &end}, v)
+
+	json, err := codec.ParseChain("json")
+	require.NoError(t, err)
+	v, err = streamsDecoder{layout: "windowed", inner: chainDecoder{json}}.decode(concat([]byte(`{"id":1}`), millis(start)))
+	require.NoError(t, err)
+	require.Equal(t, start, v.(streamsKey).WindowStart)
+	require.NotNil(t, v.(streamsKey).Key)
+
+	v, err = streamsDecoder{layout: "timestamped", encoding: "string"}.decode(concat(millis(end), []byte("42")))
+	require.NoError(t, err)
+	require.Equal(t, streamsValue{Timestamp: end, Value: encodeBytes([]byte("42"), "string")}, v)
+
+	_, err = streamsDecoder{layout: "session", encoding: "string"}.decode(millis(start))
+	require.Error(t, err)
+	_, err = streamsDecoder{layout: "timestamped", encoding: "string"}.decode([]byte{1})
+	require.Error(t, err)
+	_, err = streamsDecoder{layout: "windowed", inner: chainDecoder{json}}.decode(concat([]byte(`{`), millis(start)))
+	require.Error(t, err)
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"reflect"
+	"regexp"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseOffsets(t *testing.T) {
@@ -277,6 +281,30 @@ func TestParseOffsets(t *testing.T) {
 			},
 			expectedErr: nil,
 		},
+		{
+			input: "all=group:billing",
+			expected: map[int32]interval{
+				-1: interval{
+					start: offset{relative: true, start: offsetGroup, group: "billing"},
+					end:   offset{relative: false, start: 1<<63 - 1, diff: 0},
+				},
+			},
+			expectedErr: nil,
+		},
+		{
+			input: "0=group:billing:20,1=5:group:billing",
+			expected: map[int32]interval{
+				0: interval{
+					start: offset{relative: true, start: offsetGroup, group: "billing"},
+					end:   offset{relative: false, start: 20, diff: 0},
+				},
+				1: interval{
+					start: offset{relative: false, start: 5, diff: 0},
+					end:   offset{relative: true, start: offsetGroup, group: "billing"},
+				},
+			},
+			expectedErr: nil,
+		},
 		{
 			input: "+10:",
 			expected: map[int32]interval{
@@ -287,6 +315,58 @@ func TestParseOffsets(t *testing.T) {
 			},
 			expectedErr: nil,
 		},
+		{
+			input: "all=@2023-06-01T00:00:00Z:@2023-06-02T00:00:00+02:00",
+			expected: map[int32]interval{
+				-1: interval{
+					start: offset{relative: true, start: offsetTime, timestamp: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+					end:   offset{relative: true, start: offsetTime, diff: -1, timestamp: time.Date(2023, 6, 1, 22, 0, 0, 0, time.UTC)},
+				},
+			},
+			expectedErr: nil,
+		},
+		{
+			input: "0=@1685577600000:,1=group:billing:@1685577600000",
+			expected: map[int32]interval{
+				0: interval{
+					start: offset{relative: true, start: offsetTime, timestamp: time.Unix(1685577600, 0).UTC()},
+					end:   offset{relative: false, start: 1<<63 - 1},
+				},
+				1: interval{
+					start: offset{relative: true, start: offsetGroup, group: "billing"},
+					end:   offset{relative: true, start: offsetTime, diff: -1, timestamp: time.Unix(1685577600, 0).UTC()},
+				},
+			},
+			expectedErr: nil,
+		},
+		{
+			input: "all=oldest:current,1=current-5:current",
+			expected: map[int32]interval{
+				-1: interval{
+					start: offset{relative: true, start: sarama.OffsetOldest},
+					end:   offset{relative: true, start: offsetCurrent},
+				},
+				1: interval{
+					start: offset{relative: true, start: offsetCurrent, diff: -5},
+					end:   offset{relative: true, start: offsetCurrent},
+				},
+			},
+			expectedErr: nil,
+		},
+		{
+			input: "all=50%:60%,1=12.5%:",
+			expected: map[int32]interval{
+				-1: interval{
+					start: offset{relative: true, start: offsetPercent, percent: 50},
+					end:   offset{relative: true, start: offsetPercent, diff: -1, percent: 60},
+				},
+				1: interval{
+					start: offset{relative: true, start: offsetPercent, percent: 12.5},
+					end:   offset{relative: false, start: 1<<63 - 1},
+				},
+			},
+			expectedErr: nil,
+		},
 	}
 
 	for _, d := range data {
@@ -309,6 +389,263 @@ Input:    %v
 
 }
 
+func TestResolveCurrentOffset(t *testing.T) {
+	cmd := &consumeCmd{current: map[topicPartition]int64{{"hans", 0}: 10, {"hans", 1}: 0}}
+
+	actual, err := cmd.resolveOffset(offset{relative: true, start: offsetCurrent}, "hans", 0)
+	require.Nil(t, err)
+	require.Equal(t, int64(9), actual)
+
+	actual, err = cmd.resolveOffset(offset{relative: true, start: offsetCurrent, diff: -5}, "hans", 0)
+	require.Nil(t, err)
+	require.Equal(t, int64(4), actual)
+
+	actual, err = cmd.resolveOffset(offset{relative: true, start: offsetCurrent}, "hans", 1)
+	require.Nil(t, err)
+	require.Equal(t, int64(-1), actual)
+
+	_, err = cmd.resolveOffset(offset{relative: true, start: offsetCurrent}, "hans", 2)
+	require.NotNil(t, err)
+}
+
+func TestConsumeParseArgsUntilEnd(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-until-end"})
+	require.True(t, target.untilEnd)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans"})
+	require.False(t, target.untilEnd)
+}
+
+func TestConsumeParseArgsClientRack(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-client-rack", "eu-west-1a"})
+	require.Equal(t, "eu-west-1a", target.clientRack)
+	require.Equal(t, sarama.V2_4_0_0, target.version)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-client-rack", "eu-west-1a", "-version", "3.0.0"})
+	require.Equal(t, sarama.V3_0_0_0, target.version)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans"})
+	require.Equal(t, "", target.clientRack)
+	require.Equal(t, sarama.V2_0_0_0, target.version)
+}
+
+func TestConsumeUntilEndSkipsConsumedPartitions(t *testing.T) {
+	calls := make(chan tConsumePartition, 1)
+	target := consumeCmd{consumer: tConsumer{calls: calls}, untilEnd: true}
+	target.topic = "hans"
+	target.current = map[topicPartition]int64{{"hans", 2}: 4}
+	target.offsets = map[int32]interval{
+		-1: interval{start: offset{start: 4}, end: offset{start: 1<<63 - 1}},
+	}
+
+	require.False(t, target.consumePartition(make(chan printContext), newPartitionTurn("hans", 2), 0))
+	require.Len(t, calls, 0)
+}
+
+func TestConsumeParseArgsTopicRegex(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "orders-.*", "-topic-regex"})
+	require.NotNil(t, target.topicRegex)
+	require.Equal(t, []string{"orders-a", "orders-b"}, matchTopics(target.topicRegex, []string{"orders-b", "returns", "orders-a", "old-orders-a"}))
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "orders-.*"})
+	require.Nil(t, target.topicRegex)
+}
+
+func TestConsumePrintsTopicForRegex(t *testing.T) {
+	out := make(chan printContext, 1)
+	target := &consumeCmd{encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json"}
+	msg := &sarama.ConsumerMessage{Topic: "orders-a", Value: []byte("hans")}
+
+	go target.printMessage(out, msg)
+	ctx := <-out
+	require.Equal(t, "", ctx.output.(consumedMessage).Topic)
+	close(ctx.done)
+
+	target.topicRegex = regexp.MustCompile("orders-.*")
+	go target.printMessage(out, msg)
+	ctx = <-out
+	require.Equal(t, "orders-a", ctx.output.(consumedMessage).Topic)
+	close(ctx.done)
+}
+
+func TestConsumeMaxMessages(t *testing.T) {
+	out := make(chan printContext)
+	go func() {
+		for ctx := range out {
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	target := &consumeCmd{encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json", maxMessages: 2, limitReached: make(chan struct{})}
+	msg := &sarama.ConsumerMessage{Value: []byte("hans")}
+
+	require.True(t, target.printMessage(out, msg))
+	select {
+	case <-target.limitReached:
+		t.Fatal("limit reached after the first message")
+	default:
+	}
+
+	require.True(t, target.printMessage(out, msg))
+	<-target.limitReached
+
+	require.False(t, target.printMessage(out, msg))
+	require.Equal(t, 2, target.printed)
+}
+
+func TestConsumePrintLatest(t *testing.T) {
+	latest := map[string]*sarama.ConsumerMessage{
+		"a": {Key: []byte("a"), Value: []byte("3"), Offset: 3},
+		"b": {Key: []byte("b"), Value: []byte("1"), Offset: 1},
+		"c": {Key: []byte("c"), Value: nil, Offset: 2},
+	}
+
+	for _, tombstones := range []string{"skip", "include"} {
+		out := make(chan printContext)
+		printed := make(chan int64)
+		go func() {
+			for ctx := range out {
+				printed <- ctx.output.(consumedMessage).Offset
+				close(ctx.done)
+			}
+			close(printed)
+		}()
+
+		target := &consumeCmd{encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json", tombstones: tombstones}
+		go func() {
+			target.printLatest(out, latest)
+			close(out)
+		}()
+
+		offsets := []int64{}
+		for o := range printed {
+			offsets = append(offsets, o)
+		}
+
+		expected := []int64{1, 3}
+		if tombstones == "include" {
+			expected = []int64{1, 2, 3}
+		}
+		require.Equal(t, expected, offsets)
+	}
+}
+
+func TestConsumeTombstones(t *testing.T) {
+	tombstone, empty := &sarama.ConsumerMessage{}, &sarama.ConsumerMessage{Value: []byte{}}
+	for mode, expected := range map[string][]bool{
+		"include": {true, true},
+		"only":    {true, false},
+		"skip":    {false, true},
+	} {
+		cmd := &consumeCmd{tombstones: mode}
+		require.Equal(t, expected, []bool{cmd.showTombstone(tombstone), cmd.showTombstone(empty)}, mode)
+	}
+
+	for as, expected := range map[string]tombstonesFlag{
+		"":                 "",
+		"-tombstones":      "include",
+		"-tombstones=only": "only",
+		"-tombstones=skip": "skip",
+	} {
+		args := (&consumeCmd{}).parseFlags(strings.Fields(as))
+		require.Equal(t, expected, args.tombstones, as)
+	}
+}
+
+func TestParseOffsetsLike(t *testing.T) {
+	actual, err := parseOffsets("0=10:20,1=like:0,all=like:0")
+	require.Nil(t, err)
+	require.Equal(t, interval{offset{start: 10}, offset{start: 20}}, actual[0])
+	like := offset{relative: true, start: offsetLike, like: 0}
+	require.Equal(t, interval{like, like}, actual[1])
+	require.Equal(t, interval{like, like}, actual[-1])
+
+	_, err = parseOffsets("1=like:1")
+	require.NotNil(t, err)
+}
+
+func TestParseOffsetsInvalid(t *testing.T) {
+	for _, offsets := range []string{"all=@2023-13-45:", "0=:@yesterday"} {
+		_, err := parseOffsets(offsets)
+		require.Error(t, err, offsets)
+	}
+}
+
+func TestResolveLikeIntervalRequiresInterval(t *testing.T) {
+	like := offset{relative: true, start: offsetLike, like: 0}
+	target := &consumeCmd{offsets: map[int32]interval{-1: {like, like}}}
+	_, err := target.resolveLikeInterval("hans", 0)
+	require.NotNil(t, err)
+
+	target.offsets = map[int32]interval{1: {like, like}}
+	_, err = target.resolveLikeInterval("hans", 0)
+	require.NotNil(t, err)
+}
+
+func TestPercentOffset(t *testing.T) {
+	require.Equal(t, int64(10), percentOffset(10, 110, 0))
+	require.Equal(t, int64(60), percentOffset(10, 110, 50))
+	require.Equal(t, int64(110), percentOffset(10, 110, 100))
+	require.Equal(t, int64(5), percentOffset(5, 5, 50))
+
+	_, err := parseOffset("150%")
+	require.Error(t, err)
+
+	for _, offsets := range []string{"all=150%:", "all=-5%:", "0=1e3%:", "all=50%:100.5%"} {
+		_, err := parseOffsets(offsets)
+		require.Error(t, err, offsets)
+	}
+	actual, err := parseOffsets("all=0%:100%")
+	require.NoError(t, err)
+	require.Equal(t, 100.0, actual[-1].end.percent)
+}
+
+func TestParseOffsetsDurations(t *testing.T) {
+	before := time.Now()
+	actual, err := parseOffsets("all=-1h30m:-5m")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	iv := actual[-1]
+	if iv.start.start != offsetTime || iv.end.start != offsetTime || iv.end.diff != -1 {
+		t.Fatalf("expected time based interval, got %+v", iv)
+	}
+
+	if d := before.Sub(iv.start.timestamp); d > 90*time.Minute || d < 89*time.Minute {
+		t.Errorf("expected start 1h30m ago, got %v", d)
+	}
+
+	if d := before.Sub(iv.end.timestamp); d > 5*time.Minute || d < 4*time.Minute {
+		t.Errorf("expected end 5m ago, got %v", d)
+	}
+
+	actual, err = parseOffsets("all=-10:")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if actual[-1].start.start != sarama.OffsetNewest || actual[-1].start.diff != -10 {
+		t.Errorf("expected newest-10 for plain numbers, got %+v", actual[-1].start)
+	}
+}
+
 func TestFindPartitionsToConsume(t *testing.T) {
 	data := []struct {
 		topic    string
@@ -319,7 +656,7 @@ func TestFindPartitionsToConsume(t *testing.T) {
 		{
 			topic: "a",
 			offsets: map[int32]interval{
-				10: {offset{false, 2, 0}, offset{false, 4, 0}},
+				10: {offset{start: 2}, offset{start: 4}},
 			},
 			consumer: tConsumer{
 				topics:              []string{"a"},
@@ -335,7 +672,7 @@ func TestFindPartitionsToConsume(t *testing.T) {
 		{
 			topic: "a",
 			offsets: map[int32]interval{
-				-1: {offset{false, 3, 0}, offset{false, 41, 0}},
+				-1: {offset{start: 3}, offset{start: 41}},
 			},
 			consumer: tConsumer{
 				topics:              []string{"a"},
@@ -356,7 +693,7 @@ func TestFindPartitionsToConsume(t *testing.T) {
 			topic:    d.topic,
 			offsets:  d.offsets,
 		}
-		actual := target.findPartitions()
+		actual := target.findPartitions(d.topic)
 
 		if !reflect.DeepEqual(actual, d.expected) {
 			t.Errorf(
@@ -386,12 +723,12 @@ func TestConsume(t *testing.T) {
 		},
 		calls: calls,
 	}
-	partitions := []int32{1, 2}
+	partitions := []topicPartition{{"hans", 1}, {"hans", 2}}
 	target := consumeCmd{consumer: consumer}
 	target.topic = "hans"
 	target.brokers = []string{"localhost:9092"}
 	target.offsets = map[int32]interval{
-		-1: interval{start: offset{false, 1, 0}, end: offset{false, 5, 0}},
+		-1: interval{start: offset{start: 1}, end: offset{start: 5}},
 	}
 
 	go target.consume(partitions)
@@ -439,6 +776,145 @@ Actual:   %#v
 	}
 }
 
+func TestConsumeInterrupted(t *testing.T) {
+	out := make(chan printContext)
+	go func() {
+		for ctx := range out {
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	messages := make(chan *sarama.ConsumerMessage, 1)
+	messages <- &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 1, Value: []byte("hans")}
+	target := &consumeCmd{encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json", interrupted: make(chan struct{})}
+
+	done := make(chan struct{})
+	go func() {
+		pt := newPartitionTurn("hans", 1)
+		pt.End = -1
+		target.partitionLoop(out, tPartitionConsumer{messages: messages}, pt, 0)
+		close(done)
+	}()
+
+	messages <- &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 2, Value: []byte("hans")}
+	close(target.interrupted)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("partition loop didn't stop after the interrupt")
+	}
+}
+
+type tCommitter struct{ commits int }
+
+func (c *tCommitter) Commit() { c.commits++ }
+
+func TestOffsetMarked(t *testing.T) {
+	c := &tCommitter{}
+	target := &consumeCmd{commitEvery: 3}
+	for i := 0; i < 7; i++ {
+		target.offsetMarked(c)
+	}
+	require.Equal(t, 2, c.commits)
+
+	target = &consumeCmd{}
+	target.offsetMarked(c)
+	require.Equal(t, 2, c.commits)
+}
+
+func TestTuneFetches(t *testing.T) {
+	cfg := sarama.NewConfig()
+	target := &consumeCmd{noValue: true, fetchMin: 1024, fetchMax: 4096, channelBuffer: 1000}
+	target.limitFetchSize(cfg)
+	target.tuneFetches(cfg)
+	require.Equal(t, int32(1024), cfg.Consumer.Fetch.Min)
+	require.Equal(t, int32(1), cfg.Consumer.Fetch.Default)
+	require.Equal(t, int32(4096), cfg.Consumer.Fetch.Max)
+	require.Equal(t, 1000, cfg.ChannelBufferSize)
+
+	cfg = sarama.NewConfig()
+	target = &consumeCmd{fetchMax: 4096}
+	target.tuneFetches(cfg)
+	require.Equal(t, int32(4096), cfg.Consumer.Fetch.Default)
+	require.NoError(t, cfg.Validate())
+}
+
+func TestConsumeConcurrency(t *testing.T) {
+	first := make(chan *sarama.ConsumerMessage, 1)
+	calls := make(chan tConsumePartition)
+	consumer := tConsumer{
+		consumePartition: map[tConsumePartition]tPartitionConsumer{
+			{"hans", 1, 1}: {messages: first},
+			{"hans", 2, 1}: {messages: make(chan *sarama.ConsumerMessage)},
+		},
+		calls: calls,
+	}
+	target := &consumeCmd{consumer: consumer, concurrency: 1, encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json"}
+	target.offsets = map[int32]interval{
+		-1: {start: offset{start: 1}, end: offset{start: 1}},
+	}
+
+	go target.consume([]topicPartition{{"hans", 1}, {"hans", 2}})
+
+	require.Equal(t, tConsumePartition{"hans", 1, 1}, <-calls)
+	select {
+	case call := <-calls:
+		t.Fatalf("consumed %#v before the first partition ended", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first <- &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 1, Value: []byte("hans")}
+	select {
+	case call := <-calls:
+		require.Equal(t, tConsumePartition{"hans", 2, 1}, call)
+	case <-time.After(time.Second):
+		t.Fatal("didn't consume the second partition after the first ended")
+	}
+}
+
+func TestConsumeConcurrencyTakesTurns(t *testing.T) {
+	first := make(chan *sarama.ConsumerMessage, 1)
+	first <- &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 1, Value: []byte("hans")}
+	calls := make(chan tConsumePartition)
+	consumer := tConsumer{
+		consumePartition: map[tConsumePartition]tPartitionConsumer{
+			{"hans", 1, 1}: {messages: first},
+			{"hans", 2, 1}: {messages: make(chan *sarama.ConsumerMessage)},
+			{"hans", 1, 2}: {messages: make(chan *sarama.ConsumerMessage)},
+		},
+		calls: calls,
+	}
+	target := &consumeCmd{consumer: consumer, concurrency: 1, turn: 20 * time.Millisecond, interrupted: make(chan struct{}), encodeKey: "string", encodeValue: "string", encodeHeaders: headerEncodings{fallback: "string"}, output: "json"}
+	target.offsets = map[int32]interval{
+		-1: {start: offset{start: 1}, end: offset{start: 1<<63 - 1}},
+	}
+
+	done := make(chan struct{})
+	go func() { target.consume([]topicPartition{{"hans", 1}, {"hans", 2}}); close(done) }()
+
+	// partition 1 continues after its message once partition 2 had a turn.
+	for _, expected := range []tConsumePartition{{"hans", 1, 1}, {"hans", 2, 1}, {"hans", 1, 2}} {
+		select {
+		case call := <-calls:
+			require.Equal(t, expected, call)
+		case <-time.After(time.Second):
+			t.Fatalf("didn't consume %#v", expected)
+		}
+	}
+
+	go func() {
+		for range calls {
+		}
+	}()
+	close(target.interrupted)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consume didn't stop after the interrupt")
+	}
+}
+
 type tConsumePartition struct {
 	topic     string
 	partition int32
@@ -477,6 +953,9 @@ func (pc tPartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
 func (pc tPartitionConsumer) Errors() <-chan *sarama.ConsumerError {
 	return pc.errors
 }
+func (pc tPartitionConsumer) Pause()         {}
+func (pc tPartitionConsumer) Resume()        {}
+func (pc tPartitionConsumer) IsPaused() bool { return false }
 
 type tConsumer struct {
 	topics              []string
@@ -511,6 +990,11 @@ func (c tConsumer) HighWaterMarks() map[string]map[int32]int64 {
 	return nil
 }
 
+func (c tConsumer) Pause(topicPartitions map[string][]int32)  {}
+func (c tConsumer) Resume(topicPartitions map[string][]int32) {}
+func (c tConsumer) PauseAll()                                 {}
+func (c tConsumer) ResumeAll()                                {}
+
 func TestConsumeParseArgs(t *testing.T) {
 	topic := "test-topic"
 	givenBroker := "hans:9092"
@@ -551,3 +1035,228 @@ func TestConsumeParseArgs(t *testing.T) {
 		return
 	}
 }
+
+func TestParseUntilTime(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	for _, input := range []string{"1614816000000", "@1614816000000", "2021-03-04T00:00:00Z"} {
+		actual, err := parseUntilTime(input)
+		require.Nil(t, err, input)
+		require.True(t, ts.Equal(actual), input)
+	}
+
+	actual, err := parseUntilTime("-5m")
+	require.Nil(t, err)
+	require.WithinDuration(t, time.Now().Add(-5*time.Minute), actual, time.Second)
+
+	_, err = parseUntilTime("tomorrow")
+	require.NotNil(t, err)
+}
+
+func TestPastUntilTime(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 14, 5, 0, 0, time.UTC)
+	cmd := &consumeCmd{}
+	require.False(t, cmd.pastUntilTime(&sarama.ConsumerMessage{Timestamp: ts.Add(time.Hour)}))
+
+	cmd.untilTime = ts
+	require.False(t, cmd.pastUntilTime(&sarama.ConsumerMessage{Timestamp: ts}))
+	require.False(t, cmd.pastUntilTime(&sarama.ConsumerMessage{}))
+	require.True(t, cmd.pastUntilTime(&sarama.ConsumerMessage{Timestamp: ts.Add(time.Millisecond)}))
+}
+
+func TestParseFallbackOffset(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	data := []struct {
+		input       string
+		expected    offset
+		expectedErr bool
+	}{
+		{input: "oldest", expected: offset{relative: true, start: sarama.OffsetOldest}},
+		{input: "newest", expected: offset{relative: true, start: sarama.OffsetNewest}},
+		{input: "@1614816000000", expected: offset{relative: true, start: offsetTime, timestamp: ts}},
+		{input: "resume", expectedErr: true},
+		{input: "10", expectedErr: true},
+		{input: "@yesterday", expectedErr: true},
+	}
+
+	for _, d := range data {
+		actual, err := parseFallbackOffset(d.input)
+		if d.expectedErr {
+			require.NotNil(t, err, d.input)
+			continue
+		}
+		require.Nil(t, err, d.input)
+		require.Equal(t, d.expected, actual, d.input)
+	}
+}
+
+func TestConsumeParseArgsFallbackOffset(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-group", "g"})
+	require.Equal(t, sarama.OffsetNewest, target.fallback.start)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-group", "g", "-group-balanced"})
+	require.Equal(t, sarama.OffsetOldest, target.fallback.start)
+
+	target = &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-group", "g", "-group-balanced", "-fallback-offset", "@2021-03-04"})
+	require.Equal(t, offsetTime, target.fallback.start)
+}
+
+func TestConsumeLimitValue(t *testing.T) {
+	str := func(s string) *string { return &s }
+	size := func(i int) *int { return &i }
+
+	data := []struct {
+		cmd      *consumeCmd
+		value    []byte
+		expected consumedMessage
+	}{
+		{
+			cmd:      &consumeCmd{},
+			value:    []byte("hello"),
+			expected: consumedMessage{Value: str("hello")},
+		},
+		{
+			cmd:      &consumeCmd{noValue: true},
+			value:    []byte("hello"),
+			expected: consumedMessage{ValueSize: size(5)},
+		},
+		{
+			cmd:      &consumeCmd{noValue: true},
+			value:    nil,
+			expected: consumedMessage{},
+		},
+		{
+			cmd:      &consumeCmd{valueBytes: 2, encodeValue: "hex"},
+			value:    []byte("hello"),
+			expected: consumedMessage{Value: str("6865"), ValueSize: size(5)},
+		},
+		{
+			cmd:      &consumeCmd{valueBytes: 10, encodeValue: "string"},
+			value:    []byte("hello"),
+			expected: consumedMessage{Value: str("hello"), ValueSize: size(5)},
+		},
+		{
+			cmd:      &consumeCmd{maxValueBytes: 10, encodeValue: "string"},
+			value:    []byte("hello"),
+			expected: consumedMessage{Value: str("hello")},
+		},
+		{
+			cmd:      &consumeCmd{maxValueBytes: 4, encodeValue: "string"},
+			value:    []byte("hello"),
+			expected: consumedMessage{Value: str("…[5 bytes]"), ValueSize: size(5)},
+		},
+		{
+			cmd:      &consumeCmd{maxValueBytes: 4, truncateLarge: true, encodeValue: "string"},
+			value:    []byte("hello"),
+			expected: consumedMessage{Value: str("hell"), ValueSize: size(5)},
+		},
+	}
+
+	for _, d := range data {
+		m := newConsumedMessage(&sarama.ConsumerMessage{Value: d.value}, "string", d.cmd.encodeValue, "string")
+		d.cmd.limitValue(&m, d.value)
+		if !reflect.DeepEqual(d.expected, m) {
+			t.Errorf("expected %#v, got %#v", d.expected, m)
+		}
+	}
+}
+
+type upperDecoder struct{}
+
+func (upperDecoder) decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty")
+	}
+	return strings.ToUpper(string(data)), nil
+}
+
+func TestDecodeMessageHeaderEncodings(t *testing.T) {
+	str := func(s string) *string { return &s }
+	target := &consumeCmd{
+		encodeHeaders: headerEncodings{fallback: "hex", keys: map[string]string{"trace": "string", "meta": "avro", "bad": "avro"}},
+		headerDecoder: upperDecoder{},
+	}
+
+	msg := &sarama.ConsumerMessage{
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("trace"), Value: []byte("A")},
+			{Key: []byte("span"), Value: []byte("B")},
+			{Key: []byte("meta"), Value: []byte("m")},
+			{Key: []byte("bad"), Value: []byte{}},
+			{Key: []byte("empty")},
+		},
+	}
+	m := newConsumedMessage(msg, "string", "string", target.encodeHeaders.fallback)
+	target.decodeMessage(&m, msg)
+	require.Equal(t, map[string]interface{}{
+		"trace": str("A"),
+		"span":  str("42"),
+		"meta":  "M",
+		"bad":   str(""),
+		"empty": (*string)(nil),
+	}, m.Headers)
+}
+
+func TestNewConsumedMessageHeaders(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	m := &sarama.ConsumerMessage{
+		Key:   []byte("key"),
+		Value: []byte("value"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("trace"), Value: []byte("A")},
+			{Key: []byte("empty")},
+		},
+	}
+
+	actual := newConsumedMessage(m, "string", "string", "hex")
+	expected := map[string]interface{}{"trace": str("41"), "empty": (*string)(nil)}
+	if !reflect.DeepEqual(expected, actual.Headers) {
+		t.Errorf("expected %#v, got %#v", expected, actual.Headers)
+	}
+
+	actual = newConsumedMessage(&sarama.ConsumerMessage{}, "string", "string", "string")
+	if actual.Headers != nil {
+		t.Errorf("expected no headers, got %#v", actual.Headers)
+	}
+}
+
+func TestConsumeTruncateMessage(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	data := []struct {
+		truncate int
+		msg      *sarama.ConsumerMessage
+		expected consumedMessage
+	}{
+		{
+			truncate: 0,
+			msg:      &sarama.ConsumerMessage{Key: []byte("key"), Value: []byte("hello world")},
+			expected: consumedMessage{Key: str("key"), Value: str("hello world")},
+		},
+		{
+			truncate: 5,
+			msg:      &sarama.ConsumerMessage{Key: []byte("key"), Value: []byte("hello world")},
+			expected: consumedMessage{Key: str("key"), Value: str("hello…[11 bytes]")},
+		},
+		{
+			truncate: 2,
+			msg:      &sarama.ConsumerMessage{Value: []byte("äöü")},
+			expected: consumedMessage{Value: str("äö…[6 bytes]")},
+		},
+	}
+
+	for _, d := range data {
+		cmd := &consumeCmd{truncate: d.truncate}
+		m := newConsumedMessage(d.msg, "string", "string", "string")
+		cmd.truncateMessage(&m, d.msg)
+		if !reflect.DeepEqual(d.expected, m) {
+			t.Errorf("expected %#v, got %#v", d.expected, m)
+		}
+	}
+}
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type copyCmd struct {
+	clientConfig
+
+	srcBrokers    []string
+	dstBrokers    []string
+	srcTopic      string
+	dstTopic      string
+	offsets       map[int32]interval
+	untilEnd      bool
+	untilTime     time.Time
+	untilLag      int64
+	timeout       time.Duration
+	keepPartition bool
+	lineage       *lineage
+	skipHeaders   []headerMatch
+	headers       *headerPolicy
+	progress      *progressReporter
+	metricsAddr   string
+	metrics       *trafficMetrics
+	daemon        daemonArgs
+	verbose       bool
+	pretty        bool
+
+	transactionalID     string
+	transactionTimeout  time.Duration
+	transactionSize     int
+	transactionInterval time.Duration
+	group               string
+
+	connArgs  clientArgs
+	src       *consumeCmd // resolves -offsets against the source topic
+	consumer  sarama.Consumer
+	producer  sarama.SyncProducer
+	cutover   *cutover
+	txn       *copyTransaction
+	committed map[int32]int64 // next offsets of -group per source partition
+
+	dstPartitions int32  // number of partitions of -dst-topic
+	nextPartition uint32 // round robin counter for messages without key
+}
+
+type copyArgs struct {
+	clientArgs
+
+	srcBrokers    string
+	dstBrokers    string
+	srcTopic      string
+	dstTopic      string
+	offsets       string
+	untilEnd      bool
+	untilTime     string
+	untilLag      int64
+	timeout       time.Duration
+	keepPartition bool
+	lineage       string
+	skipHeaders   string
+	keepHeaders   string
+	dropHeaders   string
+	renameHeaders repeatedFlag
+	progressFD    int
+	progressEvery time.Duration
+	metricsAddr   string
+	daemon        daemonArgs
+	verbose       bool
+	pretty        bool
+
+	transactionalID     string
+	transactionTimeout  time.Duration
+	transactionSize     int
+	transactionInterval time.Duration
+	group               string
+}
+
+// copyResult summarizes the copy of a source partition. LastOffset is the
+// offset of the last message read from the source, to continue from.
+type copyResult struct {
+	Partition  int32  `json:"partition"`
+	Copied     int    `json:"copied"`
+	Skipped    int    `json:"skipped,omitempty"`
+	LastOffset *int64 `json:"lastOffset,omitempty"`
+}
+
+func (cmd *copyCmd) run(args []string) {
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	d, err := startDaemon(cmd.daemon, cmd.verbose, cmd.reload)
+	if err != nil {
+		failf("%v", err)
+	}
+	defer d.stop()
+
+	cmd.metrics = newTrafficMetrics(cmd.metricsAddr, true)
+	cmd.copyTopic(d.ready)
+}
+
+// reload reads the cluster profile from the config file again on SIGHUP.
+// copy keeps its connections, as open transactions can't move to a new
+// producer, so it only reports that a restart is needed to apply changes.
+func (cmd *copyCmd) reload() {
+	c, err := cmd.connArgs.reload()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reload config err=%v\n", err)
+		return
+	}
+	if !reflect.DeepEqual(c, cmd.clientConfig) {
+		fmt.Fprintf(os.Stderr, "connection settings changed, restart kt copy to apply them.\n")
+	}
+}
+
+// copyTopic copies the messages of the parsed arguments, ready is called
+// once both clusters are connected.
+func (cmd *copyCmd) copyTopic(ready func()) {
+	srcCfg := cmd.saramaConfig("src")
+	if cmd.transactionalID != "" {
+		// messages of aborted source transactions must not be copied.
+		srcCfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	srcClient, err := sarama.NewClient(cmd.srcBrokers, srcCfg)
+	if err != nil {
+		failf("failed to create source client err=%v", err)
+	}
+	defer logClose("source client", srcClient)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(srcClient); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	cmd.src = &consumeCmd{
+		topic:    cmd.srcTopic,
+		offsets:  cmd.offsets,
+		untilEnd: cmd.untilEnd,
+		verbose:  cmd.verbose,
+		client:   srcClient,
+		consumer: cmd.consumer,
+	}
+
+	partitions := cmd.src.findPartitions(cmd.srcTopic)
+	if len(partitions) == 0 {
+		failf("Found no partitions to copy")
+	}
+	tps := []topicPartition{}
+	for _, p := range partitions {
+		tps = append(tps, topicPartition{cmd.srcTopic, p})
+	}
+	if err = cmd.src.captureCurrentOffsets(tps); err != nil {
+		failf("failed to read high water marks err=%v", err)
+	}
+
+	cfg := cmd.saramaConfig("dst")
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	if cmd.transactionalID != "" {
+		cfg.Producer.Idempotent = true
+		cfg.Producer.Transaction.ID = cmd.transactionalID
+		cfg.Producer.Transaction.Timeout = cmd.transactionTimeout
+		cfg.Net.MaxOpenRequests = 1
+	}
+
+	dstClient, err := sarama.NewClient(cmd.dstBrokers, cfg)
+	if err != nil {
+		failf("failed to create destination client err=%v", err)
+	}
+	defer logClose("destination client", dstClient)
+
+	dstPartitions, err := dstClient.Partitions(cmd.dstTopic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.dstTopic, err)
+	}
+	if len(dstPartitions) == 0 {
+		failf("found no partitions for topic %v", cmd.dstTopic)
+	}
+	cmd.dstPartitions = int32(len(dstPartitions))
+	if cmd.keepPartition {
+		for _, p := range partitions {
+			if int(p) >= len(dstPartitions) {
+				failf("-keep-partition requires topic %v to have at least %v partitions, found %v", cmd.dstTopic, p+1, len(dstPartitions))
+			}
+		}
+	}
+
+	if cmd.transactionalID != "" {
+		cmd.setupTransaction(dstClient, partitions)
+	}
+
+	if cmd.producer, err = sarama.NewSyncProducerFromClient(dstClient); err != nil {
+		failf("failed to create producer err=%v", err)
+	}
+	defer logClose("producer", cmd.producer)
+	if cmd.transactionalID != "" {
+		cmd.txn = newCopyTransaction(cmd.producer, cmd.group, cmd.srcTopic, cmd.transactionSize)
+	}
+	ready()
+
+	var (
+		wg  sync.WaitGroup
+		out = make(chan printContext)
+		q   = make(chan struct{})
+	)
+
+	go print(out, cmd.pretty)
+	go listenForInterrupt(q)
+
+	cmd.progress.start()
+	defer cmd.progress.done()
+
+	cmd.cutover = newCutover(cmd.untilTime, cmd.untilLag, len(partitions))
+	done := make(chan struct{})
+	go cmd.cutover.watch(srcClient, cmd.srcTopic, time.Second, done)
+	go cmd.txn.commitEvery(cmd.transactionInterval, done)
+
+	wg.Add(len(partitions))
+	for _, p := range partitions {
+		go func(p int32) { defer wg.Done(); cmd.copyPartition(out, q, p) }(p)
+	}
+	wg.Wait()
+	close(done)
+	if err = cmd.txn.commit(); err != nil {
+		cmd.txn.fail(err)
+	}
+	if cmd.txn != nil && cmd.verbose {
+		fmt.Fprintf(os.Stderr, "committed %v transactions of %v\n", cmd.txn.commits, cmd.transactionalID)
+	}
+
+	if cmd.cutover != nil {
+		cmd.printCutover(out, q, srcClient, dstClient)
+	}
+}
+
+// printCutover prints the cutover report once copying stopped, the reason is
+// interrupt or end unless -until-time or -until-lag stopped it.
+func (cmd *copyCmd) printCutover(out chan printContext, q chan struct{}, src, dst sarama.Client) {
+	reason := "end"
+	select {
+	case <-q:
+		reason = "interrupt"
+	default:
+	}
+
+	timeout := cmd.timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	r, err := cmd.cutover.report(src, dst, cmd.srcTopic, cmd.dstTopic, reason, timeout)
+	if err != nil {
+		failf("failed to create cutover report err=%v", err)
+	}
+	ctx := printContext{output: r, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+// copyPartition copies the messages of the source partition within -offsets
+// one at a time, so they keep their order in the destination.
+func (cmd *copyCmd) copyPartition(out chan printContext, q chan struct{}, p int32) {
+	var (
+		result  = copyResult{Partition: p}
+		timer   *time.Timer
+		timeout = make(<-chan time.Time)
+	)
+
+	defer func() {
+		last := int64(-1)
+		if result.LastOffset != nil {
+			last = *result.LastOffset
+		}
+		cmd.progress.partitionDone(cmd.srcTopic, p, last, result.Copied+result.Skipped)
+
+		ctx := printContext{output: result, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}()
+
+	start, end, ok := cmd.src.partitionRange(cmd.srcTopic, p)
+	if ok && cmd.txn != nil {
+		start, ok = cmd.resumeStart(p, start, end)
+	}
+	if !ok {
+		cmd.cutover.partitionStarted(p, cmd.src.current[topicPartition{cmd.srcTopic, p}], true)
+		return
+	}
+	cmd.cutover.partitionStarted(p, start, false)
+
+	pc, err := cmd.consumer.ConsumePartition(cmd.srcTopic, p, start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to consume partition %v err=%v\n", p, err)
+		return
+	}
+	defer logClose(fmt.Sprintf("partition consumer %v", p), pc)
+	cmd.progress.partitionStarted(cmd.srcTopic, p, start, end)
+
+	for {
+		if cmd.timeout > 0 {
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(cmd.timeout)
+			timeout = timer.C
+		}
+
+		select {
+		case <-q:
+			return
+		case <-cmd.cutover.stopped():
+			return
+		case <-timeout:
+			fmt.Fprintf(os.Stderr, "copying from partition %v timed out after %s\n", p, cmd.timeout)
+			return
+		case err := <-pc.Errors():
+			fmt.Fprintf(os.Stderr, "partition %v consumer encountered err %s\n", p, err)
+			return
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				fmt.Fprintf(os.Stderr, "unexpected closed messages chan")
+				return
+			}
+
+			if cmd.cutover.reached(msg) {
+				return
+			}
+			cmd.metrics.consumed(msg, pc.HighWaterMarkOffset())
+			if len(cmd.skipHeaders) > 0 && matchHeaders(cmd.skipHeaders, recordHeaders(msg.Headers)) {
+				cmd.metrics.skipped(msg.Topic, p)
+				cmd.cutover.skipped(msg)
+				result.Skipped++
+				if err := cmd.txn.send(p, msg.Offset, nil); err != nil {
+					cmd.txn.fail(err)
+				}
+			} else {
+				err := cmd.txn.send(p, msg.Offset, func() error { return cmd.copyMessage(msg) })
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to copy message at offset %v of partition %v err=%v\n", msg.Offset, p, err)
+					cmd.txn.fail(err)
+					return
+				}
+				result.Copied++
+			}
+			offset := msg.Offset
+			result.LastOffset = &offset
+			cmd.progress.progress(cmd.srcTopic, p, offset, result.Copied+result.Skipped)
+
+			if end >= 0 && msg.Offset >= end {
+				cmd.cutover.partitionDone(p)
+				return
+			}
+		}
+	}
+}
+
+// copyMessage produces msg to the destination topic.
+func (cmd *copyCmd) copyMessage(msg *sarama.ConsumerMessage) error {
+	dp, dstOffset, err := cmd.producer.SendMessage(cmd.newProducerMessage(msg))
+	if err != nil {
+		cmd.metrics.produceFailed(cmd.dstTopic)
+		return err
+	}
+	cmd.metrics.produced(cmd.dstTopic, dp, len(msg.Key)+len(msg.Value))
+	cmd.cutover.copied(msg, dp, dstOffset)
+	return nil
+}
+
+// setupTransaction reads where -group continues the source partitions.
+// Transactions commit the group's offsets on the destination cluster, which
+// only accepts offsets of partitions it has, so it needs a topic named like
+// the source topic, as when mirroring a topic under its name.
+func (cmd *copyCmd) setupTransaction(dst sarama.Client, partitions []int32) {
+	dstPartitions, err := dst.Partitions(cmd.srcTopic)
+	if err != nil {
+		failf("-transactional-id commits the offsets of group %v on the destination cluster, which requires topic %v there err=%v", cmd.group, cmd.srcTopic, err)
+	}
+	for _, p := range partitions {
+		if int(p) >= len(dstPartitions) {
+			failf("-transactional-id commits the offsets of group %v on the destination cluster, which requires topic %v there to have at least %v partitions, found %v", cmd.group, cmd.srcTopic, p+1, len(dstPartitions))
+		}
+	}
+	if cmd.committed, err = fetchCommittedOffsets(dst, cmd.group, cmd.srcTopic, partitions); err != nil {
+		failf("failed to read committed offsets of group %v err=%v", cmd.group, err)
+	}
+}
+
+// newProducerMessage copies the key, value, headers and timestamp of msg,
+// and its partition with -keep-partition. Null keys and values stay null.
+func (cmd *copyCmd) newProducerMessage(msg *sarama.ConsumerMessage) *sarama.ProducerMessage {
+	pm := &sarama.ProducerMessage{Topic: cmd.dstTopic, Timestamp: msg.Timestamp}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		pm.Value = sarama.ByteEncoder(msg.Value)
+	}
+	if cmd.keepPartition {
+		pm.Partition = msg.Partition
+	} else {
+		pm.Partition = cmd.partition(msg.Key)
+	}
+
+	pm.Headers = cmd.headers.applyRecord(msg.Headers)
+	if cmd.lineage != nil {
+		for _, h := range cmd.lineage.headers(&msg.Topic, &msg.Partition, &msg.Offset) {
+			pm.Headers = append(pm.Headers, *h)
+		}
+	}
+
+	return pm
+}
+
+// partition returns the destination partition of key like the Java client's
+// default partitioner (murmur2), spreading messages without key round robin.
+func (cmd *copyCmd) partition(key []byte) int32 {
+	if key == nil {
+		return int32((atomic.AddUint32(&cmd.nextPartition, 1) - 1) % uint32(cmd.dstPartitions))
+	}
+	return keyPartitioners["murmur2"](key, cmd.dstPartitions)
+}
+
+func recordHeaders(hs []*sarama.RecordHeader) map[string][]byte {
+	headers := map[string][]byte{}
+	for _, h := range hs {
+		headers[string(h.Key)] = h.Value
+	}
+	return headers
+}
+
+func (cmd *copyCmd) saramaConfig(side string) *sarama.Config {
+	return cmd.clientConfig.saramaConfig("copy-" + side)
+}
+
+func (cmd *copyCmd) failStartup(msg string) {
+	failUsage(msg, "kt copy")
+}
+
+func (cmd *copyCmd) parseArgs(as []string) {
+	var (
+		err  error
+		args = cmd.parseFlags(as)
+	)
+
+	if args.srcTopic == "" {
+		cmd.failStartup("-src-topic is required.")
+	}
+	if args.dstTopic == "" {
+		args.dstTopic = args.srcTopic
+	}
+
+	envBrokers := os.Getenv("KT_BROKERS")
+	if args.srcBrokers == "" {
+		if envBrokers != "" {
+			args.srcBrokers = envBrokers
+		} else {
+			args.srcBrokers = "localhost:9092"
+		}
+	}
+	if args.dstBrokers == "" {
+		args.dstBrokers = args.srcBrokers
+	}
+
+	if args.srcTopic == args.dstTopic && args.srcBrokers == args.dstBrokers {
+		cmd.failStartup("copying a topic onto itself requires a different -dst-topic or -dst-brokers.")
+	}
+
+	if cmd.offsets, err = parseOffsets(args.offsets); err != nil {
+		cmd.failStartup(fmt.Sprintf("%s", err))
+	}
+	for _, i := range cmd.offsets {
+		if i.start.start == offsetResume || i.end.start == offsetResume {
+			cmd.failStartup("resume offsets aren't supported, with -group kt copy continues at the committed offsets anyway.")
+		}
+	}
+
+	if args.skipHeaders != "" {
+		if cmd.skipHeaders, err = parseHeaderMatches(args.skipHeaders); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid -skip-headers err=%v", err))
+		}
+	}
+	if cmd.headers, err = newHeaderPolicy(args.keepHeaders, args.dropHeaders, args.renameHeaders); err != nil {
+		cmd.failStartup(err.Error())
+	}
+	if args.lineage != "" {
+		cmd.lineage = newLineage(args.lineage, args.srcTopic)
+	}
+
+	cmd.srcTopic = args.srcTopic
+	cmd.dstTopic = args.dstTopic
+	cmd.srcBrokers = parseBrokers(args.srcBrokers)
+	cmd.dstBrokers = parseBrokers(args.dstBrokers)
+	cmd.untilEnd = args.untilEnd
+	if args.untilTime != "" {
+		if cmd.untilTime, err = parseTimestamp(args.untilTime); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid -until-time %#v err=%v", args.untilTime, err))
+		}
+	}
+	if args.untilLag < 0 {
+		cmd.failStartup("-until-lag cannot be negative.")
+	}
+	cmd.untilLag = args.untilLag
+	cmd.timeout = args.timeout
+	cmd.keepPartition = args.keepPartition
+	cmd.daemon = args.daemon
+	cmd.metricsAddr = args.metricsAddr
+	cmd.connArgs = args.clientArgs
+	args.brokers = args.srcBrokers
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	if cmd.progress, err = newProgressReporter("copy", args.progressFD, args.progressEvery); err != nil {
+		cmd.failStartup(err.Error())
+	}
+	if !cmd.version.IsAtLeast(sarama.V0_11_0_0) {
+		cmd.failStartup("kt copy requires -version 0.11.0.0 or later to preserve headers.")
+	}
+
+	if (args.transactionalID == "") != (args.group == "") {
+		cmd.failStartup("-transactional-id and -group are only supported together.")
+	}
+	if args.transactionSize <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid -transaction-size %v, expected a positive number of messages.", args.transactionSize))
+	}
+	if args.transactionInterval <= 0 || args.transactionTimeout <= 0 {
+		cmd.failStartup("-transaction-interval and -transaction-timeout must be positive durations.")
+	}
+	if args.transactionInterval >= args.transactionTimeout {
+		cmd.failStartup("-transaction-interval must be shorter than -transaction-timeout, after which brokers abort transactions.")
+	}
+	cmd.transactionalID = args.transactionalID
+	cmd.transactionTimeout = args.transactionTimeout
+	cmd.transactionSize = args.transactionSize
+	cmd.transactionInterval = args.transactionInterval
+	cmd.group = args.group
+}
+
+// parseBrokers splits a comma separated list of brokers, defaulting their
+// port to 9092.
+func parseBrokers(s string) []string {
+	brokers := strings.Split(s, ",")
+	for i, b := range brokers {
+		if !strings.Contains(b, ":") {
+			brokers[i] = b + ":9092"
+		}
+	}
+	return brokers
+}
+
+func (cmd *copyCmd) parseFlags(as []string) copyArgs {
+	var args copyArgs
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	flags.StringVar(&args.srcTopic, "src-topic", "", "Topic to copy messages from (required).")
+	flags.StringVar(&args.dstTopic, "dst-topic", "", "Topic to copy messages to (defaults to -src-topic).")
+	flags.StringVar(&args.srcBrokers, "src-brokers", "", "Comma separated list of brokers of the source cluster. Port defaults to 9092 when omitted (defaults to localhost:9092).")
+	flags.StringVar(&args.dstBrokers, "dst-brokers", "", "Comma separated list of brokers of the destination cluster (defaults to -src-brokers).")
+	args.clientArgs.addConnFlags(flags)
+	flags.StringVar(&args.offsets, "offsets", "", "Specifies what messages to copy by partition and offset range like for kt consume (defaults to all).")
+	flags.BoolVar(&args.untilEnd, "until-end", false, "Exit once every source partition reached its newest offset at start.")
+	flags.StringVar(&args.untilTime, "until-time", "", "Stop once past the given timestamp and caught up, not copying messages from then on, and print a cutover report (defaults to none).")
+	flags.Int64Var(&args.untilLag, "until-lag", 0, "Stop once every source partition lags fewer than the given number of messages behind and print a cutover report (defaults to 0 to disable).")
+	flags.DurationVar(&args.timeout, "timeout", time.Duration(0), "Timeout after not reading messages (default 0 to disable).")
+	flags.BoolVar(&args.keepPartition, "keep-partition", false, "Copy messages to the partition they were read from instead of partitioning by key.")
+	flags.StringVar(&args.lineage, "lineage", "", "Name of the source cluster, adds headers with the source of each message (defaults to none).")
+	flags.StringVar(&args.skipHeaders, "skip-headers", "", "Comma separated list of key=value or key headers, source messages with any of them are skipped (defaults to none).")
+	flags.StringVar(&args.keepHeaders, "keep-headers", "", "Comma separated list of glob patterns, only source headers with matching keys are copied (defaults to all).")
+	flags.StringVar(&args.dropHeaders, "drop-headers", "", "Comma separated list of glob patterns, source headers with matching keys aren't copied, e.g. traceparent,x-b3-* (defaults to none).")
+	flags.Var(&args.renameHeaders, "rename-header", "Copy source headers under another key as old=new, or old-*=new-* for a prefix. Can be repeated.")
+	flags.IntVar(&args.progressFD, "progress-fd", -1, "File descriptor to write progress events to as JSON lines like for kt consume (defaults to -1 to disable).")
+	flags.DurationVar(&args.progressEvery, "progress-interval", time.Second, "Minimum time between progress events of a partition for -progress-fd.")
+	flags.StringVar(&args.metricsAddr, "metricsaddr", "", "Address to expose Prometheus metrics on while copying, e.g. localhost:9100 (defaults to disabled).")
+	flags.StringVar(&args.transactionalID, "transactional-id", "", "Copy in transactions with the given transactional ID that also commit the source offsets for -group, for exactly-once copies (defaults to none).")
+	flags.StringVar(&args.group, "group", "", "Consumer group to commit the source offsets for within the transactions of -transactional-id, and to continue from.")
+	flags.IntVar(&args.transactionSize, "transaction-size", 1000, "Maximum number of messages per transaction for -transactional-id.")
+	flags.DurationVar(&args.transactionInterval, "transaction-interval", time.Second, "Maximum time between transaction commits for -transactional-id.")
+	flags.DurationVar(&args.transactionTimeout, "transaction-timeout", time.Minute, "Time after which the brokers abort an unfinished transaction.")
+	flags.StringVar(&args.daemon.pidFile, "pid-file", "", "Path to write the process ID to while running (defaults to none).")
+	flags.StringVar(&args.daemon.logFile, "log-file", "", "Path to append log output to instead of stderr, reopened on SIGHUP (defaults to stderr).")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of copy:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, copyDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var copyDocString = `
+The value for -src-brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+copy reads the messages of -src-topic within -offsets and produces them to
+-dst-topic, which may be on another cluster with -dst-brokers. Keys, values,
+headers and timestamps are copied as they are, so unlike piping kt consume
+into kt produce binary data and timestamps are preserved. The TLS and SASL
+settings apply to both clusters.
+
+Messages are partitioned by key like the Java producer does (murmur2),
+messages without key round robin, or copied to the partition they were read
+from with -keep-partition. The messages of a
+partition keep their order. kt copy doesn't stop on its own unless the
+offsets end, -until-end or -timeout is given. It prints the number of
+messages copied per source partition and the last offset read when done.
+
+-lineage, -skip-headers, -keep-headers, -drop-headers and -rename-header
+work like for kt produce, see "kt produce -help",
+so two clusters can mirror a topic into each other:
+
+  $ kt copy -src-topic orders -src-brokers a:9092 -dst-brokers b:9092 -lineage a -skip-headers kt-source-cluster=b
+  $ kt copy -src-topic orders -src-brokers b:9092 -dst-brokers a:9092 -lineage b -skip-headers kt-source-cluster=a
+
+To migrate producers to another cluster, kt copy can mirror a topic until
+the cutover and stop by itself. With -until-time it stops once the time has
+passed and every source partition is caught up, or reached a message from
+that time on, which it doesn't copy. With -until-lag it stops once every
+source partition lags fewer than the given number of messages behind. Either
+way it then prints a cutover report with the next offset, high water mark,
+lag and counts per source partition, the produced offset range and high
+water mark per destination partition, and digests of the keys and values on
+both sides. The destination's are computed from the records read back
+between the first and last produced offsets, so "match" is only true if the
+destination holds exactly what was copied:
+
+  $ kt copy -src-topic orders -src-brokers old:9092 -dst-brokers new:9092 -until-time 2024-05-01T14:00:00Z
+  $ kt copy -src-topic orders -src-brokers old:9092 -dst-brokers new:9092 -until-lag 100
+
+The report is printed as well when the copy ends or is interrupted before.
+
+-progress-fd reports progress like for kt consume, see "kt consume -help".
+The messages of the progress events count both copied and skipped messages.
+
+For long running copies, e.g. to bridge two clusters, -pid-file and
+-log-file work like for kt lag, see "kt lag -help". Started by systemd as a
+Type=notify service, kt reports readiness once it's connected to both
+clusters. Unlike lag, copy keeps its connections on SIGHUP and only reports
+when the -cluster profile in the config file changed, as open transactions
+can't move to new ones; restart it to apply the changes. -metricsaddr exposes Prometheus metrics under /metrics:
+
+ - kt_consume_messages_total and kt_consume_bytes_total per source partition,
+ - kt_consume_lag, the messages of a source partition after the last copied
+   one as of its fetch,
+ - kt_produce_messages_total and kt_produce_bytes_total per destination
+   partition,
+ - kt_produce_errors_total per destination topic, and
+ - kt_copy_skipped_total per source partition for -skip-headers.
+
+  $ kt copy -src-topic orders -src-brokers a:9092 -dst-brokers b:9092 -metricsaddr :9100
+
+For correctness-sensitive backfills, -transactional-id copies exactly once:
+kt copy produces in transactions that also commit the next source offsets
+for -group, like a Java application's sendOffsetsToTransaction, and reads
+only committed messages of the source. A transaction commits after
+-transaction-size messages or -transaction-interval. If copying fails, the
+transaction is aborted and kt exits. Running the same command again
+continues each source partition at the offset of the last committed
+transaction, -offsets only applies to partitions that the group has no
+offsets for yet and where copying ends. Consumers of the destination topic
+see every message exactly once with isolation.level=read_committed.
+
+The transactions commit the group's offsets on the destination cluster, so
+it needs a topic named like the source topic with at least as many
+partitions, as when mirroring a topic under its name. It requires Kafka
+0.11.0.0 or later on both clusters:
+
+  $ kt copy -src-topic orders -src-brokers old:9092 -dst-brokers new:9092 -transactional-id orders-backfill -group orders-backfill -until-end
+
+-offsets uses the syntax of kt consume, see "kt consume -help", except for
+resume offsets.
+
+Copy the messages of the last day to a topic on another cluster:
+
+  $ kt copy -src-topic orders -src-brokers prod:9092 -dst-brokers staging:9092 -offsets all=-24h: -until-end`
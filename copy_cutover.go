@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// recordDigest sums a hash of the key and value of each record, so the
+// digest of the records copied from the source equals that of the records
+// read back from the destination regardless of how they interleave there.
+type recordDigest uint64
+
+func (d *recordDigest) add(key, value []byte) {
+	h := sha256.New()
+	for _, b := range [][]byte{key, value} {
+		n := int32(len(b))
+		if b == nil {
+			n = -1
+		}
+		binary.Write(h, binary.BigEndian, n)
+		h.Write(b)
+	}
+	*d += recordDigest(binary.BigEndian.Uint64(h.Sum(nil)))
+}
+
+func (d recordDigest) String() string { return fmt.Sprintf("%016x", uint64(d)) }
+
+// cutoverReport is printed by kt copy with -until-time or -until-lag once it
+// stopped, as evidence for switching producers over to the destination.
+// Match is true if the records read back from the destination between the
+// first and last produced offsets are the ones copied from the source.
+type cutoverReport struct {
+	SrcTopic          string               `json:"srcTopic"`
+	DstTopic          string               `json:"dstTopic"`
+	Reason            string               `json:"reason"` // time, lag, end or interrupt
+	Started           time.Time            `json:"started"`
+	Stopped           time.Time            `json:"stopped"`
+	Copied            int                  `json:"copied"`
+	Skipped           int                  `json:"skipped"`
+	SourceDigest      string               `json:"sourceDigest"`
+	DestinationDigest string               `json:"destinationDigest"`
+	Match             bool                 `json:"match"`
+	Source            []cutoverSource      `json:"source"`
+	Destination       []cutoverDestination `json:"destination"`
+}
+
+// cutoverSource tracks a source partition, NextOffset is where a consumer
+// of the source continues after the copied messages.
+type cutoverSource struct {
+	Partition     int32  `json:"partition"`
+	NextOffset    int64  `json:"nextOffset"`
+	HighWaterMark int64  `json:"highWaterMark"`
+	Lag           int64  `json:"lag"`
+	Copied        int    `json:"copied"`
+	Skipped       int    `json:"skipped"`
+	Digest        string `json:"digest"`
+
+	done   bool // reached -until-time or the end of its offsets
+	digest recordDigest
+}
+
+// cutoverDestination tracks a destination partition, Read and Digest cover
+// the records read back between FirstOffset and LastOffset.
+type cutoverDestination struct {
+	Partition     int32  `json:"partition"`
+	FirstOffset   int64  `json:"firstOffset"`
+	LastOffset    int64  `json:"lastOffset"`
+	HighWaterMark int64  `json:"highWaterMark"`
+	Produced      int    `json:"produced"`
+	Read          int    `json:"read"`
+	Digest        string `json:"digest"`
+
+	digest recordDigest
+}
+
+// cutover stops kt copy once the wall clock passed -until-time and the
+// source partitions are caught up or reached messages from -until-time on,
+// or once all of them lag fewer than -until-lag messages behind. Like
+// consumeStats, a nil *cutover is valid and never stops copying.
+type cutover struct {
+	sync.Mutex
+	untilTime  time.Time
+	untilLag   int64
+	partitions int
+	started    time.Time
+	reason     string
+	stop       chan struct{}
+	sources    map[int32]*cutoverSource
+	dests      map[int32]*cutoverDestination
+}
+
+func newCutover(untilTime time.Time, untilLag int64, partitions int) *cutover {
+	if untilTime.IsZero() && untilLag <= 0 {
+		return nil
+	}
+	return &cutover{
+		untilTime:  untilTime,
+		untilLag:   untilLag,
+		partitions: partitions,
+		started:    time.Now().UTC(),
+		stop:       make(chan struct{}),
+		sources:    map[int32]*cutoverSource{},
+		dests:      map[int32]*cutoverDestination{},
+	}
+}
+
+// stopped is closed once the cutover condition is met.
+func (c *cutover) stopped() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.stop
+}
+
+// partitionStarted registers source partition p that's copied from offset
+// start, and done if there's nothing to copy.
+func (c *cutover) partitionStarted(p int32, start int64, done bool) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.sources[p] = &cutoverSource{Partition: p, NextOffset: start, done: done}
+}
+
+func (c *cutover) partitionDone(p int32) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if s, ok := c.sources[p]; ok {
+		s.done = true
+	}
+}
+
+// reached returns whether msg is at or after -until-time, then its partition
+// is done without copying it.
+func (c *cutover) reached(msg *sarama.ConsumerMessage) bool {
+	if c == nil || c.untilTime.IsZero() || msg.Timestamp.Before(c.untilTime) {
+		return false
+	}
+	c.partitionDone(msg.Partition)
+	return true
+}
+
+// copied records msg copied to offset of the destination partition dp.
+func (c *cutover) copied(msg *sarama.ConsumerMessage, dp int32, offset int64) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	s := c.sources[msg.Partition]
+	s.NextOffset, s.Copied = msg.Offset+1, s.Copied+1
+	s.digest.add(msg.Key, msg.Value)
+
+	d, ok := c.dests[dp]
+	if !ok {
+		d = &cutoverDestination{Partition: dp, FirstOffset: offset, LastOffset: offset}
+		c.dests[dp] = d
+	}
+	if offset < d.FirstOffset {
+		d.FirstOffset = offset
+	}
+	if offset > d.LastOffset {
+		d.LastOffset = offset
+	}
+	d.Produced++
+}
+
+func (c *cutover) skipped(msg *sarama.ConsumerMessage) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	s := c.sources[msg.Partition]
+	s.NextOffset, s.Skipped = msg.Offset+1, s.Skipped+1
+}
+
+// watch checks the lag of the source partitions every interval until the
+// cutover condition is met or done is closed.
+func (c *cutover) watch(client sarama.Client, topic string, interval time.Duration, done chan struct{}) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		newest := func(p int32) (int64, error) { return client.GetOffset(topic, p, sarama.OffsetNewest) }
+		if reason := c.check(newest, time.Now()); reason != "" {
+			c.Lock()
+			c.reason = reason
+			c.Unlock()
+			close(c.stop)
+			return
+		}
+	}
+}
+
+// check updates the lag of the source partitions and returns why copying
+// should stop, or "" to continue. newest returns the high water mark of a
+// partition, the lock isn't held while asking the brokers so copying
+// continues meanwhile.
+func (c *cutover) check(newest func(int32) (int64, error), now time.Time) string {
+	c.Lock()
+	if len(c.sources) < c.partitions {
+		c.Unlock()
+		return ""
+	}
+	hwms := map[int32]int64{}
+	for p := range c.sources {
+		hwms[p] = 0
+	}
+	c.Unlock()
+
+	for p := range hwms {
+		hwm, err := newest(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read high water mark of partition %v err=%v\n", p, err)
+			return ""
+		}
+		hwms[p] = hwm
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	allDone, caughtUp, belowLag := true, true, true
+	for p, s := range c.sources {
+		s.HighWaterMark, s.Lag = hwms[p], hwms[p]-s.NextOffset
+		allDone = allDone && s.done
+		caughtUp = caughtUp && (s.done || s.Lag <= 0)
+		belowLag = belowLag && (s.done || s.Lag < c.untilLag)
+	}
+
+	switch {
+	case !c.untilTime.IsZero() && (allDone || (caughtUp && !now.Before(c.untilTime))):
+		return "time"
+	case c.untilLag > 0 && belowLag:
+		return "lag"
+	}
+	return ""
+}
+
+// report reads back the records produced to the destination partitions to
+// compare their digest with that of the copied ones. reason applies unless
+// the cutover condition stopped copying.
+func (c *cutover) report(src, dst sarama.Client, srcTopic, dstTopic, reason string, timeout time.Duration) (cutoverReport, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.reason != "" {
+		reason = c.reason
+	}
+	r := cutoverReport{
+		SrcTopic:    srcTopic,
+		DstTopic:    dstTopic,
+		Reason:      reason,
+		Started:     c.started,
+		Stopped:     time.Now().UTC(),
+		Source:      []cutoverSource{},
+		Destination: []cutoverDestination{},
+	}
+
+	var srcDigest, dstDigest recordDigest
+	for p, s := range c.sources {
+		hwm, err := src.GetOffset(srcTopic, p, sarama.OffsetNewest)
+		if err != nil {
+			return r, fmt.Errorf("failed to read high water mark of source partition %v err=%v", p, err)
+		}
+		s.HighWaterMark, s.Lag, s.Digest = hwm, hwm-s.NextOffset, s.digest.String()
+		r.Copied, r.Skipped = r.Copied+s.Copied, r.Skipped+s.Skipped
+		srcDigest += s.digest
+		r.Source = append(r.Source, *s)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(dst)
+	if err != nil {
+		return r, fmt.Errorf("failed to create destination consumer err=%v", err)
+	}
+	defer logClose("destination consumer", consumer)
+
+	read := 0
+	for p, d := range c.dests {
+		if d.HighWaterMark, err = dst.GetOffset(dstTopic, p, sarama.OffsetNewest); err != nil {
+			return r, fmt.Errorf("failed to read high water mark of destination partition %v err=%v", p, err)
+		}
+		if err = readDigest(consumer, dstTopic, d, timeout); err != nil {
+			return r, err
+		}
+		d.Digest = d.digest.String()
+		dstDigest += d.digest
+		read += d.Read
+		r.Destination = append(r.Destination, *d)
+	}
+
+	sort.Slice(r.Source, func(i, j int) bool { return r.Source[i].Partition < r.Source[j].Partition })
+	sort.Slice(r.Destination, func(i, j int) bool { return r.Destination[i].Partition < r.Destination[j].Partition })
+	r.SourceDigest, r.DestinationDigest = srcDigest.String(), dstDigest.String()
+	r.Match = srcDigest == dstDigest && read == r.Copied
+	return r, nil
+}
+
+// readDigest reads the records of d between its first and last offset.
+func readDigest(consumer sarama.Consumer, topic string, d *cutoverDestination, timeout time.Duration) error {
+	pc, err := consumer.ConsumePartition(topic, d.Partition, d.FirstOffset)
+	if err != nil {
+		return fmt.Errorf("failed to consume destination partition %v err=%v", d.Partition, err)
+	}
+	defer logClose(fmt.Sprintf("destination partition consumer %v", d.Partition), pc)
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset > d.LastOffset {
+				return nil
+			}
+			d.Read++
+			d.digest.add(msg.Key, msg.Value)
+			if msg.Offset == d.LastOffset {
+				return nil
+			}
+		case err := <-pc.Errors():
+			return fmt.Errorf("failed to read destination partition %v err=%v", d.Partition, err)
+		case <-time.After(timeout):
+			return fmt.Errorf("timed out reading destination partition %v at offset %v", d.Partition, d.FirstOffset+int64(d.Read))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordDigest(t *testing.T) {
+	var a, b recordDigest
+	a.add([]byte("k1"), []byte("v1"))
+	a.add(nil, []byte("v2"))
+	b.add(nil, []byte("v2"))
+	b.add([]byte("k1"), []byte("v1"))
+	require.Equal(t, a, b)
+	require.Len(t, a.String(), 16)
+
+	var empty, null recordDigest
+	empty.add([]byte{}, []byte("v"))
+	null.add(nil, []byte("v"))
+	require.NotEqual(t, empty, null)
+}
+
+func TestCutoverCheck(t *testing.T) {
+	require.Nil(t, newCutover(time.Time{}, 0, 2))
+
+	hwms := map[int32]int64{0: 10, 1: 20}
+	newest := func(p int32) (int64, error) { return hwms[p], nil }
+	msg := func(p int32, offset int64, ts time.Time) *sarama.ConsumerMessage {
+		return &sarama.ConsumerMessage{Partition: p, Offset: offset, Timestamp: ts, Value: []byte("v")}
+	}
+
+	c := newCutover(time.Time{}, 5, 2)
+	c.partitionStarted(0, 0, false)
+	require.Equal(t, "", c.check(newest, time.Now()), "waits for all partitions")
+	c.partitionStarted(1, 18, false)
+	require.Equal(t, "", c.check(newest, time.Now()))
+	for o := int64(0); o < 6; o++ {
+		c.copied(msg(0, o, time.Now()), 0, 100+o)
+	}
+	require.Equal(t, "lag", c.check(newest, time.Now()))
+	require.Equal(t, int64(4), c.sources[0].Lag)
+	require.Equal(t, 6, c.dests[0].Produced)
+	require.Equal(t, int64(100), c.dests[0].FirstOffset)
+	require.Equal(t, int64(105), c.dests[0].LastOffset)
+
+	cutoff := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	c = newCutover(cutoff, 0, 2)
+	c.partitionStarted(0, 10, false)
+	c.partitionStarted(1, 15, false)
+	require.Equal(t, "", c.check(newest, cutoff.Add(time.Minute)), "partition 1 is behind")
+	require.False(t, c.reached(msg(1, 15, cutoff.Add(-time.Second))))
+	c.copied(msg(1, 15, cutoff.Add(-time.Second)), 1, 0)
+	require.True(t, c.reached(msg(1, 16, cutoff)))
+	require.Equal(t, "", c.check(newest, cutoff.Add(-time.Minute)), "before the cutoff")
+	require.Equal(t, "time", c.check(newest, cutoff.Add(time.Minute)))
+	require.Equal(t, int64(16), c.sources[1].NextOffset)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyParseArgs(t *testing.T) {
+	os.Setenv("KT_BROKERS", "")
+
+	target := &copyCmd{}
+	target.parseArgs([]string{"-src-topic", "orders", "-dst-brokers", "staging", "-offsets", "all=oldest:current"})
+	require.Equal(t, "orders", target.srcTopic)
+	require.Equal(t, "orders", target.dstTopic)
+	require.Equal(t, []string{"localhost:9092"}, target.srcBrokers)
+	require.Equal(t, []string{"staging:9092"}, target.dstBrokers)
+	require.Equal(t, offsetCurrent, target.offsets[-1].end.start)
+	require.Nil(t, target.lineage)
+
+	target = &copyCmd{}
+	target.parseArgs([]string{"-src-topic", "orders", "-dst-topic", "orders-copy", "-lineage", "prod", "-skip-headers", "mirrored"})
+	require.Equal(t, []string{"localhost:9092"}, target.dstBrokers)
+	require.Equal(t, "orders-copy", target.dstTopic)
+	require.Equal(t, "prod", target.lineage.cluster)
+	require.Equal(t, []headerMatch{{key: "mirrored"}}, target.skipHeaders)
+}
+
+func TestCopyNewProducerMessage(t *testing.T) {
+	ts := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	msg := &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    7,
+		Key:       []byte{0, 1},
+		Timestamp: ts,
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}},
+	}
+
+	target := &copyCmd{dstTopic: "orders-copy", dstPartitions: 6}
+	actual := target.newProducerMessage(msg)
+	require.Equal(t, "orders-copy", actual.Topic)
+	require.Equal(t, sarama.ByteEncoder([]byte{0, 1}), actual.Key)
+	require.Nil(t, actual.Value)
+	require.Equal(t, ts, actual.Timestamp)
+	require.Equal(t, keyPartitioners["murmur2"]([]byte{0, 1}, 6), actual.Partition)
+	require.Equal(t, []sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}}, actual.Headers)
+
+	target.keepPartition = true
+	target.lineage = &lineage{cluster: "prod", runID: "run"}
+	actual = target.newProducerMessage(msg)
+	require.Equal(t, int32(2), actual.Partition)
+	require.Equal(t, []sarama.RecordHeader{
+		{Key: []byte("trace"), Value: []byte("abc")},
+		{Key: []byte("kt-source-cluster"), Value: []byte("prod")},
+		{Key: []byte("kt-source-topic"), Value: []byte("orders")},
+		{Key: []byte("kt-source-partition"), Value: []byte("2")},
+		{Key: []byte("kt-source-offset"), Value: []byte("7")},
+		{Key: []byte("kt-run-id"), Value: []byte("run")},
+	}, actual.Headers)
+}
+
+func TestCopyPartition(t *testing.T) {
+	target := &copyCmd{dstPartitions: 6}
+
+	// murmur2("abc") is 479470107 per the Java client's UtilsTest.
+	require.Equal(t, int32(3), target.partition([]byte("abc")))
+	require.Equal(t, int32(3), target.partition([]byte("abc")))
+
+	actual := []int32{}
+	for i := 0; i < 7; i++ {
+		actual = append(actual, target.partition(nil))
+	}
+	require.Equal(t, []int32{0, 1, 2, 3, 4, 5, 0}, actual)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// txnProducer is the part of a transactional sarama.SyncProducer that
+// copyTransaction uses.
+type txnProducer interface {
+	BeginTxn() error
+	CommitTxn() error
+	AbortTxn() error
+	AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error
+}
+
+// copyTransaction copies messages in transactions of -transactional-id that
+// also commit the next source offsets for -group, so a copy that fails or is
+// interrupted continues after the last committed transaction without
+// duplicates or gaps for read_committed consumers. Partitions copy
+// concurrently within the same transaction, committing waits for the
+// messages in flight. Like consumeStats, a nil *copyTransaction is valid and
+// copies without transactions.
+type copyTransaction struct {
+	sync.RWMutex // held for writing to begin or commit
+	producer     txnProducer
+	group        string
+	topic        string // source topic
+	size         int    // messages per transaction
+
+	open    bool
+	mu      sync.Mutex // guards pending and count
+	pending map[int32]int64
+	count   int
+	commits int
+}
+
+func newCopyTransaction(producer txnProducer, group, topic string, size int) *copyTransaction {
+	return &copyTransaction{producer: producer, group: group, topic: topic, size: size, pending: map[int32]int64{}}
+}
+
+// send runs produce, if any, within the current transaction and records
+// offset as processed. It commits the transaction once it holds -transaction-size
+// messages.
+func (t *copyTransaction) send(partition int32, offset int64, produce func() error) error {
+	if t == nil {
+		if produce == nil {
+			return nil
+		}
+		return produce()
+	}
+	// a commit may end the transaction between beginning and sending.
+	for {
+		t.RLock()
+		if t.open {
+			break
+		}
+		t.RUnlock()
+		if err := t.begin(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if produce != nil {
+		err = produce()
+	}
+	full := false
+	if err == nil {
+		t.mu.Lock()
+		t.pending[partition] = offset + 1
+		t.count++
+		full = t.count >= t.size
+		t.mu.Unlock()
+	}
+	t.RUnlock()
+
+	if err != nil {
+		return err
+	}
+	if full {
+		return t.commit()
+	}
+	return nil
+}
+
+func (t *copyTransaction) begin() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.open {
+		return nil
+	}
+	if err := t.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction err=%v", err)
+	}
+	t.open = true
+	return nil
+}
+
+// commit adds the pending source offsets to the transaction and commits
+// it. The next message begins a new one.
+func (t *copyTransaction) commit() error {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	if !t.open {
+		return nil
+	}
+
+	t.mu.Lock()
+	offsets := []*sarama.PartitionOffsetMetadata{}
+	for p, o := range t.pending {
+		offsets = append(offsets, &sarama.PartitionOffsetMetadata{Partition: p, Offset: o})
+	}
+	t.mu.Unlock()
+
+	if len(offsets) > 0 {
+		if err := t.producer.AddOffsetsToTxn(map[string][]*sarama.PartitionOffsetMetadata{t.topic: offsets}, t.group); err != nil {
+			return fmt.Errorf("failed to add offsets to transaction err=%v", err)
+		}
+	}
+	if err := t.producer.CommitTxn(); err != nil {
+		return fmt.Errorf("failed to commit transaction err=%v", err)
+	}
+
+	t.open = false
+	t.mu.Lock()
+	t.pending = map[int32]int64{}
+	t.count = 0
+	t.commits++
+	t.mu.Unlock()
+	return nil
+}
+
+// commitEvery commits the transaction every interval until done is closed,
+// so slow topics don't keep messages invisible to read_committed consumers.
+func (t *copyTransaction) commitEvery(interval time.Duration, done chan struct{}) {
+	if t == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := t.commit(); err != nil {
+				t.fail(err)
+			}
+		}
+	}
+}
+
+// fail aborts the transaction and exits, as the partition consumers already
+// read past the messages of the aborted transaction. Running kt copy again
+// continues from the offsets of the last committed transaction.
+func (t *copyTransaction) fail(err error) {
+	if t == nil {
+		return
+	}
+	if aerr := t.producer.AbortTxn(); aerr != nil {
+		fmt.Fprintf(os.Stderr, "failed to abort transaction err=%v\n", aerr)
+	}
+	failf("%v, aborted the transaction, copy again to continue from the last committed offsets of group %v", err, t.group)
+}
+
+// resumeStart returns the committed offset of -group for partition p to
+// start at instead of start, and false if the partition is done already
+// as the offset is after end.
+func (cmd *copyCmd) resumeStart(p int32, start, end int64) (int64, bool) {
+	committed, ok := cmd.committed[p]
+	if !ok || committed < 0 {
+		return start, true
+	}
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "continuing partition %v at offset %v committed by group %v\n", p, committed, cmd.group)
+	}
+	if end >= 0 && committed > end {
+		return committed, false
+	}
+	return committed, true
+}
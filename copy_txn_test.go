@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTxnProducer struct {
+	calls   []string
+	offsets []map[string][]*sarama.PartitionOffsetMetadata
+}
+
+func (p *fakeTxnProducer) BeginTxn() error  { p.calls = append(p.calls, "begin"); return nil }
+func (p *fakeTxnProducer) CommitTxn() error { p.calls = append(p.calls, "commit"); return nil }
+func (p *fakeTxnProducer) AbortTxn() error  { p.calls = append(p.calls, "abort"); return nil }
+func (p *fakeTxnProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, group string) error {
+	p.calls = append(p.calls, "offsets:"+group)
+	p.offsets = append(p.offsets, offsets)
+	return nil
+}
+
+func TestCopyTransaction(t *testing.T) {
+	var disabled *copyTransaction
+	produced := 0
+	require.NoError(t, disabled.send(0, 1, func() error { produced++; return nil }))
+	require.NoError(t, disabled.send(0, 2, nil))
+	require.NoError(t, disabled.commit())
+	require.Equal(t, 1, produced)
+
+	producer := &fakeTxnProducer{}
+	txn := newCopyTransaction(producer, "mirror", "orders", 3)
+	require.NoError(t, txn.commit())
+	require.Empty(t, producer.calls)
+
+	require.NoError(t, txn.send(0, 10, func() error { produced++; return nil }))
+	require.NoError(t, txn.send(1, 4, nil))
+	require.Equal(t, []string{"begin"}, producer.calls)
+	require.NoError(t, txn.send(0, 11, func() error { produced++; return nil }))
+	require.Equal(t, 3, produced)
+	require.Equal(t, []string{"begin", "offsets:mirror", "commit"}, producer.calls)
+	require.Len(t, producer.offsets, 1)
+	require.ElementsMatch(t, []*sarama.PartitionOffsetMetadata{{Partition: 0, Offset: 12}, {Partition: 1, Offset: 5}}, producer.offsets[0]["orders"])
+	require.Equal(t, 1, txn.commits)
+
+	require.NoError(t, txn.send(1, 5, nil))
+	require.NoError(t, txn.commit())
+	require.Equal(t, []string{"begin", "offsets:mirror", "commit", "begin", "offsets:mirror", "commit"}, producer.calls)
+	require.Equal(t, []*sarama.PartitionOffsetMetadata{{Partition: 1, Offset: 6}}, producer.offsets[1]["orders"])
+	require.Equal(t, 2, txn.commits)
+}
+
+func TestCopyResumeStart(t *testing.T) {
+	target := &copyCmd{committed: map[int32]int64{0: 120, 1: -1, 2: 500}}
+	start, ok := target.resumeStart(0, 100, 200)
+	require.True(t, ok)
+	require.Equal(t, int64(120), start)
+
+	start, ok = target.resumeStart(1, 100, 200)
+	require.True(t, ok)
+	require.Equal(t, int64(100), start)
+
+	_, ok = target.resumeStart(2, 100, 200)
+	require.False(t, ok)
+
+	start, ok = target.resumeStart(2, 100, -1)
+	require.True(t, ok)
+	require.Equal(t, int64(500), start)
+
+	start, ok = target.resumeStart(3, 100, 200)
+	require.True(t, ok)
+	require.Equal(t, int64(100), start)
+}
+
+func TestCopyParseArgsTransactional(t *testing.T) {
+	os.Setenv("KT_BROKERS", "")
+
+	target := &copyCmd{}
+	target.parseArgs([]string{"-src-topic", "orders", "-dst-brokers", "dr", "-transactional-id", "orders-mirror", "-group", "orders-mirror", "-transaction-size", "500"})
+	require.Equal(t, "orders-mirror", target.transactionalID)
+	require.Equal(t, "orders-mirror", target.group)
+	require.Equal(t, 500, target.transactionSize)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Shopify/sarama"
+	"golang.org/x/sys/unix"
+)
+
+// daemonArgs bundles the flags for running long-lived commands like lag and
+// copy under a supervisor such as systemd.
+type daemonArgs struct {
+	pidFile string
+	logFile string
+}
+
+// daemon writes the -pid-file, redirects stderr to -log-file and reopens it
+// on SIGHUP so that logrotate can move it, and notifies systemd about
+// readiness when started as a Type=notify service. SIGHUP also calls the
+// command's reload func, so it can pick up changed cluster profiles.
+type daemon struct {
+	args    daemonArgs
+	log     *logWriter
+	verbose bool
+}
+
+func startDaemon(args daemonArgs, verbose bool, reload func()) (*daemon, error) {
+	d := &daemon{args: args, verbose: verbose}
+
+	if args.pidFile != "" {
+		if err := ioutil.WriteFile(args.pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write pid file err=%v", err)
+		}
+	}
+
+	if args.logFile != "" {
+		d.log = &logWriter{path: args.logFile, stderr: int(os.Stderr.Fd())}
+		if err := d.log.reopen(); err != nil {
+			return nil, err
+		}
+		log.SetOutput(d.log)
+		if verbose {
+			sarama.Logger = log.New(d.log, "", log.LstdFlags)
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if d.log != nil {
+				if err := d.log.reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to reopen log file err=%v\n", err)
+				}
+			}
+			if reload != nil {
+				reload()
+			}
+		}
+	}()
+
+	return d, nil
+}
+
+// logWriter writes kt's log to -log-file. reopen swaps the file under the
+// lock and points the stderr descriptor at it, so that writes to os.Stderr
+// follow the rotation without replacing os.Stderr under running goroutines.
+type logWriter struct {
+	sync.Mutex
+	path   string
+	stderr int
+	f      *os.File
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.f.Write(p)
+}
+
+// reopen (re)opens the log file for appending, e.g. after logrotate moved it.
+func (w *logWriter) reopen() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file err=%v", err)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if err := unix.Dup2(int(f.Fd()), w.stderr); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to redirect stderr to log file err=%v", err)
+	}
+	if w.f != nil {
+		w.f.Close()
+	}
+	w.f = f
+	return nil
+}
+
+// ready tells systemd that kt is connected and working.
+func (d *daemon) ready() {
+	if err := sdNotify("READY=1"); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to notify systemd err=%v\n", err)
+	}
+}
+
+// stop tells systemd that kt is shutting down and removes the -pid-file.
+func (d *daemon) stop() {
+	if err := sdNotify("STOPPING=1"); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to notify systemd err=%v\n", err)
+	}
+	if d.args.pidFile != "" {
+		if err := os.Remove(d.args.pidFile); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove pid file err=%v\n", err)
+		}
+	}
+}
+
+// sdNotify sends state to the socket in NOTIFY_SOCKET like systemd's
+// sd_notify, it does nothing if the variable isn't set.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
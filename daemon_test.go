@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-daemon")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+	os.Setenv("NOTIFY_SOCKET", socket)
+	require.Nil(t, sdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.Nil(t, err)
+	require.Equal(t, "READY=1", string(buf[:n]))
+
+	os.Setenv("NOTIFY_SOCKET", "")
+	require.Nil(t, sdNotify("READY=1"))
+}
+
+func TestDaemonPidAndLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-daemon")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	stderr, err := unix.Dup(int(os.Stderr.Fd()))
+	require.Nil(t, err)
+	defer unix.Dup2(stderr, int(os.Stderr.Fd()))
+	defer log.SetOutput(os.Stderr)
+
+	args := daemonArgs{pidFile: filepath.Join(dir, "kt.pid"), logFile: filepath.Join(dir, "kt.log")}
+	reloaded := make(chan struct{}, 1)
+	d, err := startDaemon(args, false, func() { reloaded <- struct{}{} })
+	require.Nil(t, err)
+
+	pid, err := ioutil.ReadFile(args.pidFile)
+	require.Nil(t, err)
+	require.Equal(t, fmt.Sprintf("%d\n", os.Getpid()), string(pid))
+
+	fmt.Fprint(os.Stderr, "before rotation\n")
+	require.Nil(t, os.Rename(args.logFile, args.logFile+".1"))
+	require.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGHUP didn't reload")
+	}
+	fmt.Fprint(os.Stderr, "after rotation\n")
+	log.Print("logged")
+
+	rotated, err := ioutil.ReadFile(args.logFile + ".1")
+	require.Nil(t, err)
+	require.Equal(t, "before rotation\n", string(rotated))
+	current, err := ioutil.ReadFile(args.logFile)
+	require.Nil(t, err)
+	require.Contains(t, string(current), "after rotation\n")
+	require.Contains(t, string(current), "logged\n")
+
+	os.Setenv("NOTIFY_SOCKET", "")
+	d.stop()
+	_, err = os.Stat(args.pidFile)
+	require.True(t, os.IsNotExist(err))
+}
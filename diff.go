@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type diffCmd struct {
+	clientConfig
+
+	a             recordCoordinates
+	b             recordCoordinates
+	encodeKey     string
+	encodeValue   string
+	encodeHeaders string
+	timeout       time.Duration
+	verbose       bool
+	pretty        bool
+
+	client  sarama.Client
+	decoder *consumeCmd // decodes keys and values like kt consume
+}
+
+type diffArgs struct {
+	clientArgs
+
+	a             string
+	b             string
+	encodeKey     string
+	encodeValue   string
+	encodeHeaders string
+	registry      registryArgs
+	timeout       time.Duration
+	verbose       bool
+	pretty        bool
+}
+
+// recordCoordinates locate a record as topic/partition@offset.
+type recordCoordinates struct {
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (c recordCoordinates) String() string {
+	return fmt.Sprintf("%v/%v@%v", c.Topic, c.Partition, c.Offset)
+}
+
+// parseRecordCoordinates parses topic/partition@offset, topic names can't
+// contain / or @.
+func parseRecordCoordinates(str string) (recordCoordinates, error) {
+	invalid := fmt.Errorf("invalid record %#v, expected topic/partition@offset like orders/0@100", str)
+	at := strings.LastIndex(str, "@")
+	slash := strings.LastIndex(str[:at+1], "/")
+	if at < 0 || slash <= 0 {
+		return recordCoordinates{}, invalid
+	}
+	partition, err := strconv.ParseInt(str[slash+1:at], 10, 32)
+	if err != nil || partition < 0 {
+		return recordCoordinates{}, invalid
+	}
+	offset, err := strconv.ParseInt(str[at+1:], 10, 64)
+	if err != nil || offset < 0 {
+		return recordCoordinates{}, invalid
+	}
+	return recordCoordinates{Topic: str[:slash], Partition: int32(partition), Offset: offset}, nil
+}
+
+// recordChange is a difference between the records at the JSON pointer
+// Path, e.g. /value/items/0/price. Change is added if only b has a value at
+// the path, removed if only a has one, and changed otherwise.
+type recordChange struct {
+	Path   string      `json:"path"`
+	Change string      `json:"change"`
+	A      interface{} `json:"a"`
+	B      interface{} `json:"b"`
+}
+
+type recordDiff struct {
+	A       recordCoordinates `json:"a"`
+	B       recordCoordinates `json:"b"`
+	Equal   bool              `json:"equal"`
+	Changes []recordChange    `json:"changes"`
+}
+
+// diffJSON appends the changes between the JSON values a and b below path.
+// Objects are compared by key and arrays by index, other values as a whole.
+func diffJSON(path string, a, b interface{}, changes []recordChange) []recordChange {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+			ae, aok := av[k]
+			be, bok := bv[k]
+			switch {
+			case !aok:
+				changes = append(changes, recordChange{Path: p, Change: "added", B: be})
+			case !bok:
+				changes = append(changes, recordChange{Path: p, Change: "removed", A: ae})
+			default:
+				changes = diffJSON(p, ae, be, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			p := fmt.Sprintf("%v/%v", path, i)
+			switch {
+			case i >= len(av):
+				changes = append(changes, recordChange{Path: p, Change: "added", B: bv[i]})
+			case i >= len(bv):
+				changes = append(changes, recordChange{Path: p, Change: "removed", A: av[i]})
+			default:
+				changes = diffJSON(p, av[i], bv[i], changes)
+			}
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, recordChange{Path: path, Change: "changed", A: a, B: b})
+	}
+	return changes
+}
+
+// diffableRecord is the JSON of the key, value and headers of m as kt
+// consume would print them.
+func diffableRecord(m consumedMessage) (interface{}, error) {
+	buf, err := json.Marshal(map[string]interface{}{"key": m.Key, "value": m.Value, "headers": m.Headers})
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(buf, &v)
+	return v, err
+}
+
+func newRecordDiff(a, b recordCoordinates, am, bm consumedMessage) (recordDiff, error) {
+	av, err := diffableRecord(am)
+	if err != nil {
+		return recordDiff{}, fmt.Errorf("failed to convert record %v to JSON err=%v", a, err)
+	}
+	bv, err := diffableRecord(bm)
+	if err != nil {
+		return recordDiff{}, fmt.Errorf("failed to convert record %v to JSON err=%v", b, err)
+	}
+	changes := diffJSON("", av, bv, []recordChange{})
+	return recordDiff{A: a, B: b, Equal: len(changes) == 0, Changes: changes}, nil
+}
+
+func (cmd *diffCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	cmd.decoder.setupAvro()
+	cmd.decoder.setupCodecs()
+
+	am := cmd.fetch(&cmd.a)
+	bm := cmd.fetch(&cmd.b)
+	d, err := newRecordDiff(cmd.a, cmd.b, am, bm)
+	if err != nil {
+		failf("%v", err)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: d, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+// fetch reads and decodes the record at c and fills in its timestamp.
+func (cmd *diffCmd) fetch(c *recordCoordinates) consumedMessage {
+	msgs, err := readRecordsAt(cmd.client, c.Topic, c.Partition, c.Offset, 1, cmd.timeout)
+	if err != nil {
+		failf("failed to read record %v err=%v", c, err)
+	}
+	if len(msgs) == 0 {
+		failf("found no record at %v", c)
+	}
+	if msgs[0].Offset != c.Offset {
+		// e.g. compacted away or a transaction marker.
+		failf("found no record at %v, the next record is at offset %v", c, msgs[0].Offset)
+	}
+	c.Timestamp = msgs[0].Timestamp
+	cmd.decoder.topic = c.Topic
+	return cmd.decoder.newMessage(msgs[0])
+}
+
+func (cmd *diffCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("diff")
+}
+
+func (cmd *diffCmd) failStartup(msg string) {
+	failUsage(msg, "kt diff")
+}
+
+func (cmd *diffCmd) parseArgs(as []string) {
+	var err error
+
+	args := cmd.parseFlags(as)
+
+	if args.a == "" || args.b == "" {
+		cmd.failStartup("-a and -b are required.")
+	}
+	if cmd.a, err = parseRecordCoordinates(args.a); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid -a: %v", err))
+	}
+	if cmd.b, err = parseRecordCoordinates(args.b); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid -b: %v", err))
+	}
+	for _, enc := range []string{args.encodeKey, args.encodeValue} {
+		if err = checkEncoding(enc, "avro"); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid encoding %#v err=%v, avro is supported too.", enc, err))
+		}
+	}
+	if args.encodeHeaders != "string" && args.encodeHeaders != "hex" && args.encodeHeaders != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodeheaders argument %#v, only string, hex and base64 are supported.`, args.encodeHeaders))
+	}
+	args.registry = readRegistryEnv(args.registry)
+	if (args.encodeKey == "avro" || args.encodeValue == "avro") && args.registry.url == "" {
+		cmd.failStartup("avro encoding requires -schema-registry.")
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.encodeKey = args.encodeKey
+	cmd.encodeValue = args.encodeValue
+	cmd.encodeHeaders = args.encodeHeaders
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	cmd.decoder = &consumeCmd{
+		encodeKey:     cmd.encodeKey,
+		encodeValue:   cmd.encodeValue,
+		encodeHeaders: headerEncodings{fallback: cmd.encodeHeaders},
+		registry:      args.registry,
+	}
+}
+
+func (cmd *diffCmd) parseFlags(as []string) diffArgs {
+	var args diffArgs
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.StringVar(&args.a, "a", "", "First record as topic/partition@offset, e.g. orders/0@100 (required).")
+	flags.StringVar(&args.b, "b", "", "Second record as topic/partition@offset, e.g. orders/0@250 (required).")
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.encodeValue, "encodevalue", "string", "Decode values as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.encodeKey, "encodekey", "string", "Decode keys as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.encodeHeaders, "encodeheaders", "string", "Present header values as (string|hex|base64), defaults to string.")
+	flags.StringVar(&args.registry.url, "schema-registry", "", "URL of the schema registry for avro encoding.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Time to wait for each record.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of diff:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, diffDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var diffDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+The value for -schema-registry can be set via KT_SCHEMA_REGISTRY.
+
+"kt diff" fetches the records -a and -b, decodes them like "kt consume", and
+prints the differences between their keys, values and headers, e.g. to see
+what changed between two versions of an entity:
+
+  $ kt diff -a orders/0@100 -b orders/0@250 -encodevalue json
+
+Each change names the JSON pointer of the difference, e.g.
+/value/items/0/price, and is "added" if only -b has a value there, "removed"
+if only -a has one, and "changed" with both values otherwise. Objects are
+compared by key and arrays by index. Values that aren't decoded to JSON, e.g.
+with the default -encodevalue string, differ as a whole. The records may be
+on different partitions or topics.`
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRecordCoordinates(t *testing.T) {
+	c, err := parseRecordCoordinates("orders.v2/3@250")
+	require.NoError(t, err)
+	require.Equal(t, recordCoordinates{Topic: "orders.v2", Partition: 3, Offset: 250}, c)
+	require.Equal(t, "orders.v2/3@250", c.String())
+
+	for _, invalid := range []string{"", "orders", "orders@1", "/0@1", "orders/x@1", "orders/0@", "orders/0@-1", "orders/-1@1"} {
+		_, err = parseRecordCoordinates(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	a := map[string]interface{}{
+		"status": "NEW",
+		"items":  []interface{}{map[string]interface{}{"price": 1.0}, "b"},
+		"a/b":    true,
+		"note":   "x",
+	}
+	b := map[string]interface{}{
+		"status": "PAID",
+		"items":  []interface{}{map[string]interface{}{"price": 2.0}},
+		"a/b":    true,
+		"paidAt": "2023-01-02",
+	}
+	require.Equal(t, []recordChange{
+		{Path: "/value/items/0/price", Change: "changed", A: 1.0, B: 2.0},
+		{Path: "/value/items/1", Change: "removed", A: "b"},
+		{Path: "/value/note", Change: "removed", A: "x"},
+		{Path: "/value/paidAt", Change: "added", B: "2023-01-02"},
+		{Path: "/value/status", Change: "changed", A: "NEW", B: "PAID"},
+	}, diffJSON("/value", a, b, []recordChange{}))
+
+	require.Equal(t, []recordChange{{Path: "/x~1y~0", Change: "changed", A: []interface{}{}, B: "s"}},
+		diffJSON("", map[string]interface{}{"x/y~": []interface{}{}}, map[string]interface{}{"x/y~": "s"}, []recordChange{}))
+}
+
+func TestNewRecordDiff(t *testing.T) {
+	decoder := &consumeCmd{encodeKey: "string", encodeValue: "json", encodeHeaders: headerEncodings{fallback: "string"}}
+	decoder.setupCodecs()
+	am := decoder.newMessage(&sarama.ConsumerMessage{Key: []byte("o-1"), Value: []byte(`{"status":"NEW","total":10}`)})
+	bm := decoder.newMessage(&sarama.ConsumerMessage{Key: []byte("o-1"), Value: []byte(`{"status":"PAID","total":10}`), Headers: []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}}})
+
+	a, b := recordCoordinates{Topic: "orders", Offset: 100}, recordCoordinates{Topic: "orders", Offset: 250}
+	d, err := newRecordDiff(a, b, am, bm)
+	require.NoError(t, err)
+	require.False(t, d.Equal)
+	require.Equal(t, []recordChange{
+		{Path: "/headers", Change: "changed", B: map[string]interface{}{"trace": "abc"}},
+		{Path: "/value/status", Change: "changed", A: "NEW", B: "PAID"},
+	}, d.Changes)
+
+	d, err = newRecordDiff(a, a, am, am)
+	require.NoError(t, err)
+	require.True(t, d.Equal)
+	require.Empty(t, d.Changes)
+}
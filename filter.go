@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Shopify/sarama"
+)
+
+// filterExpr is a parsed -filter expression that selects which messages are
+// printed, e.g. value.status == "ERROR" && partition < 3.
+type filterExpr interface {
+	eval(env map[string]interface{}) interface{}
+}
+
+// filterEnv exposes a message with the given key and value to filter
+// expressions. Keys and values that contain valid JSON can be navigated via
+// paths like value.user.id, other keys and values are plain strings.
+func filterEnv(m *sarama.ConsumerMessage, key, value []byte) map[string]interface{} {
+	headers := map[string]interface{}{}
+	for _, h := range m.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+
+	return map[string]interface{}{
+		"partition": float64(m.Partition),
+		"offset":    float64(m.Offset),
+		"key":       decodeFilterJSON(key),
+		"value":     decodeFilterJSON(value),
+		"headers":   headers,
+	}
+}
+
+func decodeFilterJSON(data []byte) interface{} {
+	if data == nil {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return string(data)
+	}
+	return v
+}
+
+func matchesFilter(f filterExpr, m *sarama.ConsumerMessage) bool {
+	return truthy(f.eval(filterEnv(m, m.Key, m.Value)))
+}
+
+type pathExpr []string
+
+func (p pathExpr) eval(env map[string]interface{}) interface{} {
+	var cur interface{} = env
+	for _, name := range p {
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			cur = c[name]
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil
+			}
+			cur = c[i]
+		default:
+			return nil
+		}
+	}
+	return cur
+}
+
+type literalExpr struct{ value interface{} }
+
+func (l literalExpr) eval(env map[string]interface{}) interface{} { return l.value }
+
+type notExpr struct{ expr filterExpr }
+
+func (n notExpr) eval(env map[string]interface{}) interface{} { return !truthy(n.expr.eval(env)) }
+
+type logicalExpr struct {
+	op          string
+	left, right filterExpr
+}
+
+func (l logicalExpr) eval(env map[string]interface{}) interface{} {
+	if l.op == "&&" {
+		return truthy(l.left.eval(env)) && truthy(l.right.eval(env))
+	}
+	return truthy(l.left.eval(env)) || truthy(l.right.eval(env))
+}
+
+type matchExpr struct {
+	left filterExpr
+	re   *regexp.Regexp
+}
+
+func (m matchExpr) eval(env map[string]interface{}) interface{} {
+	str, ok := m.left.eval(env).(string)
+	return ok && m.re.MatchString(str)
+}
+
+type compareExpr struct {
+	op          string
+	left, right filterExpr
+}
+
+func (c compareExpr) eval(env map[string]interface{}) interface{} {
+	l, r := c.left.eval(env), c.right.eval(env)
+
+	switch c.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+
+	var cmp int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case lv < rv:
+			cmp = -1
+		case lv > rv:
+			cmp = 1
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(lv, rv)
+	default:
+		return false
+	}
+
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default: // >=
+		return cmp >= 0
+	}
+}
+
+func equal(l, r interface{}) bool {
+	switch lv := l.(type) {
+	case nil:
+		return r == nil
+	case string, float64, bool:
+		return l == r
+	default:
+		// objects and arrays compare by their JSON representation.
+		lb, _ := json.Marshal(lv)
+		rb, _ := json.Marshal(r)
+		return string(lb) == string(rb)
+	}
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0
+	default:
+		return true
+	}
+}
+
+// parseFilter parses expressions of the following grammar:
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = operand [ ("==" | "!=" | "<" | "<=" | ">" | ">=" | "=~") operand ]
+//	operand    = path | string | number | "true" | "false" | "null" | "(" expr ")"
+//	path       = name { "." name }
+func parseFilter(str string) (filterExpr, error) {
+	return parseExpr(str, messageField)
+}
+
+// parseExpr parses str like parseFilter, field turns the names in it into
+// expressions or rejects them.
+func parseExpr(str string, field func(name string, pos int) (filterExpr, error)) (filterExpr, error) {
+	tokens, err := tokenizeFilter(str)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens, field: field}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %#v at position %v", p.peek().text, p.peek().pos)
+	}
+
+	return expr, nil
+}
+
+type filterTokenKind int
+
+const (
+	filterName filterTokenKind = iota
+	filterString
+	filterNumber
+	filterOperator
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+var filterOperators = []string{"==", "!=", "<=", ">=", "=~", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenizeFilter(str string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	for i := 0; i < len(str); {
+		c := rune(str[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"':
+			j := i + 1
+			for ; j < len(str) && str[j] != '"'; j++ {
+				if str[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(str) {
+				return nil, fmt.Errorf("unterminated string at position %v", i)
+			}
+			unquoted, err := strconv.Unquote(str[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %v err=%v", i, err)
+			}
+			tokens = append(tokens, filterToken{filterString, unquoted, i})
+			i = j + 1
+
+		case c == '-' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(str) && (str[j] == '.' || unicode.IsDigit(rune(str[j]))) {
+				j++
+			}
+			tokens = append(tokens, filterToken{filterNumber, str[i:j], i})
+			i = j
+
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(str) && (str[j] == '_' || str[j] == '-' || str[j] == '.' || unicode.IsLetter(rune(str[j])) || unicode.IsDigit(rune(str[j]))) {
+				j++
+			}
+			tokens = append(tokens, filterToken{filterName, str[i:j], i})
+			i = j
+
+		default:
+			op := ""
+			for _, o := range filterOperators {
+				if strings.HasPrefix(str[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %#v at position %v", string(c), i)
+			}
+			tokens = append(tokens, filterToken{filterOperator, op, i})
+			i += len(op)
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	field  func(name string, pos int) (filterExpr, error)
+}
+
+func (p *filterParser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *filterParser) peek() filterToken { return p.tokens[p.pos] }
+
+func (p *filterParser) accept(op string) bool {
+	if !p.done() && p.peek().kind == filterOperator && p.peek().text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{"||", left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{"&&", left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.accept("!") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.accept("=~") {
+		if p.done() || p.peek().kind != filterString {
+			return nil, fmt.Errorf("expected regular expression string after =~")
+		}
+		re, err := regexp.Compile(p.peek().text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %#v err=%v", p.peek().text, err)
+		}
+		p.pos++
+		return matchExpr{left, re}, nil
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return compareExpr{op, left, right}, nil
+		}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseOperand() (filterExpr, error) {
+	if p.done() {
+		return nil, fmt.Errorf("unexpected end of filter expression")
+	}
+
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return expr, nil
+	}
+
+	t := p.peek()
+	p.pos++
+
+	switch t.kind {
+	case filterString:
+		return literalExpr{t.text}, nil
+	case filterNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %#v at position %v", t.text, t.pos)
+		}
+		return literalExpr{n}, nil
+	case filterName:
+		switch t.text {
+		case "true":
+			return literalExpr{true}, nil
+		case "false":
+			return literalExpr{false}, nil
+		case "null":
+			return literalExpr{nil}, nil
+		}
+		return p.field(t.text, t.pos)
+	}
+
+	return nil, fmt.Errorf("unexpected %#v at position %v", t.text, t.pos)
+}
+
+// messageField is a path into the message fields of filterEnv.
+func messageField(name string, pos int) (filterExpr, error) {
+	path := strings.Split(name, ".")
+	switch path[0] {
+	case "key", "value", "partition", "offset", "headers":
+	default:
+		return nil, fmt.Errorf("unknown field %#v at position %v, expected key, value, partition, offset or headers", path[0], pos)
+	}
+	return pathExpr(path), nil
+}
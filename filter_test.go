@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Partition: 2,
+		Offset:    23,
+		Key:       []byte("id-23"),
+		Value:     []byte(`{"status": "ERROR", "code": 503, "items": [{"price": 120}], "ok": false}`),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("source"), Value: []byte("billing-eu")}},
+	}
+
+	data := []struct {
+		expr     string
+		expected bool
+	}{
+		{`value.status == "ERROR"`, true},
+		{`value.status != "ERROR"`, false},
+		{`value.code >= 500 && value.code < 600`, true},
+		{`value.items.0.price > 100`, true},
+		{`value.items.1.price > 100`, false},
+		{`value.missing == null`, true},
+		{`value.ok`, false},
+		{`!value.ok`, true},
+		{`key == "id-23"`, true},
+		{`key =~ "^id-[0-9]+$"`, true},
+		{`headers.source =~ "^billing"`, true},
+		{`partition == 1 || (offset == 23 && partition == 2)`, true},
+		{`value.status > 3`, false},
+	}
+
+	for _, d := range data {
+		f, err := parseFilter(d.expr)
+		require.Nil(t, err, d.expr)
+		require.Equal(t, d.expected, matchesFilter(f, msg), d.expr)
+	}
+
+	plain := &sarama.ConsumerMessage{Value: []byte("not json")}
+	f, err := parseFilter(`value == "not json"`)
+	require.Nil(t, err)
+	require.True(t, matchesFilter(f, plain))
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`value.status ==`,
+		`value.status == "ERROR`,
+		`(value.code == 1`,
+		`status == "ERROR"`,
+		`value =~ "("`,
+		`value == 1 2`,
+		`value # 1`,
+	} {
+		_, err := parseFilter(expr)
+		require.NotNil(t, err, expr)
+	}
+}
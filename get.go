@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+)
+
+type getCmd struct {
+	clientConfig
+
+	coordinates   string
+	topic         string
+	concurrency   int
+	maxFetchSize  int32
+	encodeKey     string
+	encodeValue   string
+	encodeHeaders string
+	verbose       bool
+	pretty        bool
+
+	client  sarama.Client
+	decoder *consumeCmd // decodes keys and values like kt consume
+	fetches int64
+}
+
+type getArgs struct {
+	clientArgs
+
+	coordinates   string
+	topic         string
+	concurrency   int
+	maxFetchSize  int
+	encodeKey     string
+	encodeValue   string
+	encodeHeaders string
+	registry      registryArgs
+	verbose       bool
+	pretty        bool
+}
+
+// getMaxRounds limits how often partitions are planned again after their
+// leader moved.
+const getMaxRounds = 5
+
+// readCoordinates reads one record per line, either as topic/partition@offset
+// or as a JSON object with topic, partition and offset like kt consume
+// prints them. JSON objects without topic are on the topic defaultTopic.
+func readCoordinates(r io.Reader, defaultTopic string) ([]recordCoordinates, error) {
+	var (
+		result  = []recordCoordinates{}
+		scanner = bufio.NewScanner(r)
+		line    = 0
+	)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line++
+		str := strings.TrimSpace(scanner.Text())
+		if str == "" {
+			continue
+		}
+		if !strings.HasPrefix(str, "{") {
+			c, err := parseRecordCoordinates(str)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", line, err)
+			}
+			result = append(result, c)
+			continue
+		}
+		var c struct {
+			Topic     string `json:"topic"`
+			Partition *int32 `json:"partition"`
+			Offset    *int64 `json:"offset"`
+		}
+		if err := json.Unmarshal([]byte(str), &c); err != nil {
+			return nil, fmt.Errorf("line %v: invalid JSON err=%v", line, err)
+		}
+		if c.Topic == "" {
+			c.Topic = defaultTopic
+		}
+		switch {
+		case c.Topic == "":
+			return nil, fmt.Errorf("line %v: missing topic, pass -topic for records without one", line)
+		case c.Partition == nil || *c.Partition < 0:
+			return nil, fmt.Errorf("line %v: missing or invalid partition", line)
+		case c.Offset == nil || *c.Offset < 0:
+			return nil, fmt.Errorf("line %v: missing or invalid offset", line)
+		}
+		result = append(result, recordCoordinates{Topic: c.Topic, Partition: *c.Partition, Offset: *c.Offset})
+	}
+	return result, scanner.Err()
+}
+
+// getPartition holds the offsets still to fetch of a partition, sorted and
+// without duplicates.
+type getPartition struct {
+	topic     string
+	partition int32
+	offsets   []int64
+	fetchSize int32
+}
+
+// groupCoordinates groups coordinates by partition.
+func groupCoordinates(coordinates []recordCoordinates, fetchSize int32) []*getPartition {
+	offsets := map[topicPartition]map[int64]bool{}
+	for _, c := range coordinates {
+		k := topicPartition{c.Topic, c.Partition}
+		if offsets[k] == nil {
+			offsets[k] = map[int64]bool{}
+		}
+		offsets[k][c.Offset] = true
+	}
+
+	result := []*getPartition{}
+	for k, set := range offsets {
+		p := &getPartition{topic: k.topic, partition: k.partition, fetchSize: fetchSize}
+		for o := range set {
+			p.offsets = append(p.offsets, o)
+		}
+		sort.Slice(p.offsets, func(i, j int) bool { return p.offsets[i] < p.offsets[j] })
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].topic != result[j].topic {
+			return result[i].topic < result[j].topic
+		}
+		return result[i].partition < result[j].partition
+	})
+	return result
+}
+
+// done is true once every offset was found or is missing.
+func (p *getPartition) done() bool {
+	return len(p.offsets) == 0
+}
+
+// skipBefore drops the offsets before offset, e.g. the log start offset, and
+// returns them as missing.
+func (p *getPartition) skipBefore(offset int64) []int64 {
+	i := sort.Search(len(p.offsets), func(i int) bool { return p.offsets[i] >= offset })
+	missing := p.offsets[:i]
+	p.offsets = p.offsets[i:]
+	return missing
+}
+
+// take picks the records at the wanted offsets out of block, the response
+// to a fetch at the first wanted offset. Offsets that block shows to have no
+// record, e.g. as it was compacted away or is a transaction marker, are
+// returned as missing. If the next batch is larger than the fetch size, the
+// fetch size grows up to maxFetchSize and nothing is taken.
+func (p *getPartition) take(block *sarama.FetchResponseBlock, maxFetchSize int32) (found []*sarama.ConsumerMessage, missing []int64, err error) {
+	if p.done() {
+		return nil, nil, nil
+	}
+	wanted := p.offsets[0]
+	fetched := false
+	for _, records := range block.RecordsSet {
+		if records.MsgSet != nil {
+			return nil, nil, fmt.Errorf("kt get requires the message format of Kafka 0.11.0.0 or later")
+		}
+		batch := records.RecordBatch
+		if batch == nil || len(batch.Records) == 0 {
+			continue
+		}
+		next := batch.LastOffset() + 1
+		if next <= wanted {
+			continue
+		}
+		fetched = true
+		if !batch.Control {
+			for _, rec := range batch.Records {
+				offset := batch.FirstOffset + rec.OffsetDelta
+				missing = append(missing, p.skipBefore(offset)...)
+				if p.done() || p.offsets[0] != offset {
+					continue
+				}
+				ts := batch.FirstTimestamp.Add(rec.TimestampDelta)
+				if batch.LogAppendTime {
+					ts = batch.MaxTimestamp
+				}
+				found = append(found, &sarama.ConsumerMessage{
+					Topic:     p.topic,
+					Partition: p.partition,
+					Offset:    offset,
+					Key:       rec.Key,
+					Value:     rec.Value,
+					Headers:   rec.Headers,
+					Timestamp: ts,
+				})
+				p.offsets = p.offsets[1:]
+			}
+		}
+		missing = append(missing, p.skipBefore(next)...)
+	}
+
+	switch {
+	case fetched:
+	case block.Partial || len(block.RecordsSet) > 0:
+		// the next batch is larger than the fetch size.
+		if p.fetchSize >= maxFetchSize {
+			return nil, nil, sarama.ErrMessageTooLarge
+		}
+		if p.fetchSize *= 2; p.fetchSize > maxFetchSize {
+			p.fetchSize = maxFetchSize
+		}
+	case block.LastRecordsBatchOffset != nil && *block.LastRecordsBatchOffset >= wanted:
+		// batches without records, e.g. after compaction.
+		missing = append(missing, p.skipBefore(*block.LastRecordsBatchOffset+1)...)
+	default:
+		if wanted < block.HighWaterMarkOffset {
+			missing = append(missing, wanted)
+			p.offsets = p.offsets[1:]
+			break
+		}
+		// the offsets at and after the high water mark don't exist yet.
+		missing = append(missing, p.offsets...)
+		p.offsets = nil
+	}
+	return found, missing, nil
+}
+
+// getResult is passed from the brokers' fetches to the printer.
+type getResult struct {
+	found   []*sarama.ConsumerMessage
+	missing []recordCoordinates
+}
+
+func (cmd *getCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	in := os.Stdin
+	if cmd.coordinates != "" && cmd.coordinates != "-" {
+		if in, err = os.Open(cmd.coordinates); err != nil {
+			failf("failed to open coordinates file err=%v", err)
+		}
+		defer logClose("coordinates file", in)
+	}
+	coordinates, err := readCoordinates(in, cmd.topic)
+	if err != nil {
+		failf("failed to read coordinates err=%v", err)
+	}
+	fetchSize := int32(1 << 20)
+	if fetchSize > cmd.maxFetchSize {
+		fetchSize = cmd.maxFetchSize
+	}
+	partitions := groupCoordinates(coordinates, fetchSize)
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	cmd.decoder.setupAvro()
+	cmd.decoder.setupCodecs()
+
+	results := make(chan getResult)
+	go func() {
+		defer close(results)
+		cmd.fetchAll(partitions, results)
+	}()
+
+	var (
+		out     = make(chan printContext)
+		found   = 0
+		missing = 0
+	)
+	go print(out, cmd.pretty)
+	for r := range results {
+		for _, c := range r.missing {
+			fmt.Fprintf(os.Stderr, "found no record at %v\n", c)
+			missing++
+		}
+		for _, msg := range r.found {
+			cmd.decoder.topic = msg.Topic
+			m := cmd.decoder.newMessage(msg)
+			m.Topic = msg.Topic
+			ctx := printContext{output: m, done: make(chan struct{})}
+			out <- ctx
+			<-ctx.done
+			found++
+		}
+	}
+
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "found %v records in %v fetch requests\n", found, atomic.LoadInt64(&cmd.fetches))
+	}
+	if missing > 0 {
+		failf("found no records at %v of %v offsets", missing, found+missing)
+	}
+}
+
+// fetchAll fetches partitions from their leaders, one broker at a time per
+// partition but up to -concurrency fetch requests at once overall. Partitions
+// whose leader moved are planned again with refreshed metadata.
+func (cmd *getCmd) fetchAll(partitions []*getPartition, results chan<- getResult) {
+	limit := make(chan struct{}, cmd.concurrency)
+	for round := 0; len(partitions) > 0; round++ {
+		if round > 0 {
+			if err := cmd.client.RefreshMetadata(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to refresh metadata err=%v\n", err)
+			}
+		}
+		if round == getMaxRounds {
+			for _, p := range partitions {
+				cmd.partitionFailed(p, fmt.Errorf("leader kept moving"), results)
+			}
+			return
+		}
+
+		byLeader := map[*sarama.Broker][]*getPartition{}
+		for _, p := range partitions {
+			leader, err := cmd.client.Leader(p.topic, p.partition)
+			if err != nil {
+				cmd.partitionFailed(p, err, results)
+				continue
+			}
+			byLeader[leader] = append(byLeader[leader], p)
+		}
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			retry = []*getPartition{}
+		)
+		for leader, ps := range byLeader {
+			wg.Add(1)
+			go func(leader *sarama.Broker, ps []*getPartition) {
+				defer wg.Done()
+				moved := cmd.fetchBroker(leader, ps, limit, results)
+				mu.Lock()
+				retry = append(retry, moved...)
+				mu.Unlock()
+			}(leader, ps)
+		}
+		wg.Wait()
+		partitions = retry
+	}
+}
+
+// fetchBroker fetches the wanted offsets of partitions led by broker. Each
+// request asks for the next wanted offset of every partition that isn't done
+// yet, so offsets close to each other come in the same record batches and
+// offsets far apart don't fetch the records between them. It returns the
+// partitions that broker no longer leads.
+func (cmd *getCmd) fetchBroker(broker *sarama.Broker, partitions []*getPartition, limit chan struct{}, results chan<- getResult) []*getPartition {
+	moved := []*getPartition{}
+	for {
+		pending := []*getPartition{}
+		for _, p := range partitions {
+			if !p.done() {
+				pending = append(pending, p)
+			}
+		}
+		if len(pending) == 0 {
+			return moved
+		}
+
+		req := &sarama.FetchRequest{Version: 4, MaxWaitTime: 100, MinBytes: 1, MaxBytes: sarama.MaxResponseSize}
+		for _, p := range pending {
+			req.AddBlock(p.topic, p.partition, p.offsets[0], p.fetchSize, -1)
+		}
+		limit <- struct{}{}
+		resp, err := broker.Fetch(req)
+		<-limit
+		atomic.AddInt64(&cmd.fetches, 1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch from broker %v err=%v\n", broker.Addr(), err)
+			return append(moved, pending...)
+		}
+
+		partitions = partitions[:0]
+		for _, p := range pending {
+			block := resp.GetBlock(p.topic, p.partition)
+			if block == nil {
+				cmd.partitionFailed(p, fmt.Errorf("missing partition in fetch response"), results)
+				continue
+			}
+			switch block.Err {
+			case sarama.ErrNoError:
+			case sarama.ErrNotLeaderForPartition, sarama.ErrLeaderNotAvailable, sarama.ErrUnknownTopicOrPartition:
+				moved = append(moved, p)
+				continue
+			case sarama.ErrOffsetOutOfRange:
+				oldest, err := cmd.client.GetOffset(p.topic, p.partition, sarama.OffsetOldest)
+				if err != nil {
+					cmd.partitionFailed(p, err, results)
+					continue
+				}
+				missing := p.skipBefore(oldest)
+				if len(missing) == 0 {
+					// the offsets are after the end of the partition.
+					missing = p.offsets
+					p.offsets = nil
+				}
+				results <- getResult{missing: p.coordinates(missing)}
+				partitions = append(partitions, p)
+				continue
+			default:
+				cmd.partitionFailed(p, block.Err, results)
+				continue
+			}
+
+			found, missing, err := p.take(block, cmd.maxFetchSize)
+			if err != nil {
+				cmd.partitionFailed(p, err, results)
+				continue
+			}
+			if len(found) > 0 || len(missing) > 0 {
+				results <- getResult{found: found, missing: p.coordinates(missing)}
+			}
+			partitions = append(partitions, p)
+		}
+	}
+}
+
+// partitionFailed reports the error and the offsets of p that are left as
+// missing.
+func (cmd *getCmd) partitionFailed(p *getPartition, err error, results chan<- getResult) {
+	fmt.Fprintf(os.Stderr, "failed to fetch partition %v of topic %v err=%v\n", p.partition, p.topic, err)
+	results <- getResult{missing: p.coordinates(p.offsets)}
+	p.offsets = nil
+}
+
+func (p *getPartition) coordinates(offsets []int64) []recordCoordinates {
+	result := make([]recordCoordinates, len(offsets))
+	for i, o := range offsets {
+		result[i] = recordCoordinates{Topic: p.topic, Partition: p.partition, Offset: o}
+	}
+	return result
+}
+
+func (cmd *getCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("get")
+}
+
+func (cmd *getCmd) failStartup(msg string) {
+	failUsage(msg, "kt get")
+}
+
+func (cmd *getCmd) parseArgs(as []string) {
+	var err error
+
+	args := cmd.parseFlags(as)
+
+	for _, enc := range []string{args.encodeKey, args.encodeValue} {
+		if err = checkEncoding(enc, "avro"); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid encoding %#v err=%v, avro is supported too.", enc, err))
+		}
+	}
+	if args.encodeHeaders != "string" && args.encodeHeaders != "hex" && args.encodeHeaders != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodeheaders argument %#v, only string, hex and base64 are supported.`, args.encodeHeaders))
+	}
+	args.registry = readRegistryEnv(args.registry)
+	if (args.encodeKey == "avro" || args.encodeValue == "avro") && args.registry.url == "" {
+		cmd.failStartup("avro encoding requires -schema-registry.")
+	}
+	if args.concurrency <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid concurrency argument %v, expected a positive number.", args.concurrency))
+	}
+	if args.maxFetchSize <= 0 || args.maxFetchSize > int(sarama.MaxResponseSize) {
+		cmd.failStartup(fmt.Sprintf("invalid max-fetch-size argument %v, expected a positive number up to %v.", args.maxFetchSize, sarama.MaxResponseSize))
+	}
+
+	cmd.coordinates = args.coordinates
+	cmd.topic = args.topic
+	cmd.concurrency = args.concurrency
+	cmd.maxFetchSize = int32(args.maxFetchSize)
+	cmd.encodeKey = args.encodeKey
+	cmd.encodeValue = args.encodeValue
+	cmd.encodeHeaders = args.encodeHeaders
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	cmd.decoder = &consumeCmd{
+		encodeKey:     cmd.encodeKey,
+		encodeValue:   cmd.encodeValue,
+		encodeHeaders: headerEncodings{fallback: cmd.encodeHeaders},
+		registry:      args.registry,
+	}
+}
+
+func (cmd *getCmd) parseFlags(as []string) getArgs {
+	var args getArgs
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	flags.StringVar(&args.coordinates, "coordinates", "", "File with a record per line as topic/partition@offset or JSON with topic, partition and offset (defaults to stdin).")
+	flags.StringVar(&args.topic, "topic", "", "Topic of the JSON records without a topic.")
+	flags.IntVar(&args.concurrency, "concurrency", 4, "Maximum number of fetch requests in flight across all brokers.")
+	flags.IntVar(&args.maxFetchSize, "max-fetch-size", 8<<20, "Maximum bytes to fetch per partition and request, grows up to this size for large record batches.")
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.encodeValue, "encodevalue", "string", "Decode values as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.encodeKey, "encodekey", "string", "Decode keys as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.encodeHeaders, "encodeheaders", "string", "Present header values as (string|hex|base64), defaults to string.")
+	flags.StringVar(&args.registry.url, "schema-registry", "", "URL of the schema registry for avro encoding.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of get:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, getDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var getDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+The value for -schema-registry can be set via KT_SCHEMA_REGISTRY.
+
+"kt get" fetches many records by their coordinates at once, e.g. thousands of
+offsets found with "kt consume" and jq, and prints them decoded like
+"kt consume" with their topic:
+
+  $ kt get -encodevalue json <<EOF
+  orders/0@100
+  orders/0@101
+  orders/3@52000
+  EOF
+
+  $ kt consume -topic orders -offsets all=oldest: -encodevalue json \
+      | jq -c 'select(.value.status == "FAILED") | {partition, offset}' \
+      | kt get -topic orders -encodevalue json
+
+Each line is a record as topic/partition@offset, or a JSON object with
+topic, partition and offset; -topic is the topic of objects without one.
+
+Instead of reading the records one at a time, the offsets are grouped by
+partition and the partitions by their leader. Each request to a broker asks
+for the next offset of all its partitions, so nearby offsets come with the
+same record batches while the records between offsets far apart aren't
+fetched. Brokers are fetched from concurrently, with at most -concurrency
+requests in flight. Records are printed as they arrive, in order per
+partition but not across partitions.
+
+Offsets without a record, e.g. compacted away, deleted by retention, after
+the end of the partition or a transaction marker, are reported to stderr and
+make kt get exit with status 1 after printing the records it found.`
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCoordinates(t *testing.T) {
+	in := `orders/0@100
+
+{"partition":3,"offset":7,"value":"x"}
+{"topic":"payments","partition":1,"offset":0}
+`
+	actual, err := readCoordinates(strings.NewReader(in), "orders")
+	require.NoError(t, err)
+	require.Equal(t, []recordCoordinates{
+		{Topic: "orders", Partition: 0, Offset: 100},
+		{Topic: "orders", Partition: 3, Offset: 7},
+		{Topic: "payments", Partition: 1, Offset: 0},
+	}, actual)
+
+	for _, invalid := range []string{"orders@1", `{"partition":0,"offset":1}`, `{"topic":"orders","offset":1}`, `{"topic":"orders","partition":0}`, `{"topic":`} {
+		_, err = readCoordinates(strings.NewReader(invalid), "")
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestGroupCoordinates(t *testing.T) {
+	actual := groupCoordinates([]recordCoordinates{
+		{Topic: "orders", Partition: 1, Offset: 9},
+		{Topic: "orders", Partition: 0, Offset: 5},
+		{Topic: "orders", Partition: 1, Offset: 2},
+		{Topic: "orders", Partition: 1, Offset: 9},
+		{Topic: "audit", Partition: 1, Offset: 4},
+	}, 1024)
+	require.Equal(t, []*getPartition{
+		{topic: "audit", partition: 1, offsets: []int64{4}, fetchSize: 1024},
+		{topic: "orders", partition: 0, offsets: []int64{5}, fetchSize: 1024},
+		{topic: "orders", partition: 1, offsets: []int64{2, 9}, fetchSize: 1024},
+	}, actual)
+}
+
+func TestGetPartitionTake(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	batch := func(first int64, deltas ...int64) *sarama.Records {
+		b := &sarama.RecordBatch{FirstOffset: first, FirstTimestamp: ts, Version: 2}
+		for _, d := range deltas {
+			b.Records = append(b.Records, &sarama.Record{OffsetDelta: d, Value: []byte{byte(first + d)}})
+			b.LastOffsetDelta = int32(d)
+		}
+		return &sarama.Records{RecordBatch: b}
+	}
+
+	p := &getPartition{topic: "orders", partition: 2, offsets: []int64{11, 12, 14, 16, 40, 90}, fetchSize: 1024}
+	block := &sarama.FetchResponseBlock{
+		HighWaterMarkOffset: 50,
+		// 14 was compacted away.
+		RecordsSet: []*sarama.Records{batch(10, 0, 1, 2, 3), batch(15, 0, 1, 2)},
+	}
+	found, missing, err := p.take(block, 4096)
+	require.NoError(t, err)
+	require.Equal(t, []int64{14}, missing)
+	require.Len(t, found, 3)
+	for i, o := range []int64{11, 12, 16} {
+		require.Equal(t, o, found[i].Offset)
+		require.Equal(t, "orders", found[i].Topic)
+		require.Equal(t, int32(2), found[i].Partition)
+		require.Equal(t, []byte{byte(o)}, found[i].Value)
+		require.Equal(t, ts, found[i].Timestamp)
+	}
+	require.Equal(t, []int64{40, 90}, p.offsets)
+
+	// the batch at 40 doesn't fit.
+	found, missing, err = p.take(&sarama.FetchResponseBlock{HighWaterMarkOffset: 50, Partial: true}, 4096)
+	require.NoError(t, err)
+	require.Empty(t, found)
+	require.Empty(t, missing)
+	require.Equal(t, int32(2048), p.fetchSize)
+
+	found, missing, err = p.take(&sarama.FetchResponseBlock{HighWaterMarkOffset: 50, RecordsSet: []*sarama.Records{batch(38, 0, 1, 2)}}, 4096)
+	require.NoError(t, err)
+	require.Equal(t, int64(40), found[0].Offset)
+	require.Empty(t, missing)
+
+	// 90 is after the end of the partition.
+	found, missing, err = p.take(&sarama.FetchResponseBlock{HighWaterMarkOffset: 50}, 4096)
+	require.NoError(t, err)
+	require.Empty(t, found)
+	require.Equal(t, []int64{90}, missing)
+	require.True(t, p.done())
+
+	p = &getPartition{offsets: []int64{3}, fetchSize: 4096}
+	_, _, err = p.take(&sarama.FetchResponseBlock{HighWaterMarkOffset: 50, Partial: true}, 4096)
+	require.Equal(t, sarama.ErrMessageTooLarge, err)
+
+	p = &getPartition{offsets: []int64{3, 8}, fetchSize: 4096}
+	found, missing, err = p.take(&sarama.FetchResponseBlock{HighWaterMarkOffset: 5, RecordsSet: []*sarama.Records{batch(0, 0, 1, 2, 3, 4)}}, 4096)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Empty(t, missing)
+	require.Equal(t, []int64{8}, p.offsets)
+}
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 )
@@ -20,12 +21,14 @@ type groupCmd struct {
 	tlsCA        string
 	tlsCert      string
 	tlsCertKey   string
+	sasl         saslArgs
 	group        string
 	filterGroups *regexp.Regexp
 	filterTopics *regexp.Regexp
 	topic        string
 	partitions   []int32
 	reset        int64
+	resetTime    time.Time // target of resets to a timestamp, cf. offsetTime
 	verbose      bool
 	pretty       bool
 	version      sarama.KafkaVersion
@@ -54,6 +57,23 @@ const (
 func (cmd *groupCmd) run(args []string) {
 	var err error
 
+	if len(args) > 0 {
+		switch args[0] {
+		case "set-offset":
+			(&groupSetOffsetCmd{}).run(args[1:])
+			return
+		case "skip":
+			(&groupSkipCmd{}).run(args[1:])
+			return
+		case "topics":
+			(&groupTopicsCmd{name: "group topics"}).run(args[1:])
+			return
+		case "export", "import":
+			(&groupOffsetsCmd{name: args[0]}).run(args[1:])
+			return
+		}
+	}
+
 	cmd.parseArgs(args)
 
 	if cmd.verbose {
@@ -173,6 +193,16 @@ func (cmd *groupCmd) resolveOffset(top string, part int32, off int64) int64 {
 	return resolvedOff
 }
 
+// resolveTimeOffset resolves the offset of the first message at or after
+// the reset timestamp, or the newest offset if there is none.
+func (cmd *groupCmd) resolveTimeOffset(top string, part int32) int64 {
+	off := cmd.resolveOffset(top, part, cmd.resetTime.UnixNano()/int64(time.Millisecond))
+	if off < 0 {
+		off = cmd.resolveOffset(top, part, sarama.OffsetNewest)
+	}
+	return off
+}
+
 func (cmd *groupCmd) fetchGroupOffset(wg *sync.WaitGroup, grp, top string, part int32, results chan<- groupOffset) {
 	defer wg.Done()
 
@@ -195,10 +225,13 @@ func (cmd *groupCmd) fetchGroupOffset(wg *sync.WaitGroup, grp, top string, part
 	specialOffset := cmd.reset == sarama.OffsetNewest || cmd.reset == sarama.OffsetOldest
 
 	groupOff, _ := pom.NextOffset()
-	if cmd.reset >= 0 || specialOffset {
+	if cmd.reset >= 0 || specialOffset || cmd.reset == offsetTime {
 		resolvedOff := cmd.reset
-		if specialOffset {
+		switch {
+		case specialOffset:
 			resolvedOff = cmd.resolveOffset(top, part, cmd.reset)
+		case cmd.reset == offsetTime:
+			resolvedOff = cmd.resolveTimeOffset(top, part)
 		}
 		if resolvedOff > groupOff {
 			pom.MarkOffset(resolvedOff, "")
@@ -329,7 +362,7 @@ func (cmd *groupCmd) saramaConfig() *sarama.Config {
 	}
 	cfg.ClientID = "kt-group-" + sanitizeUsername(usr.Username)
 
-	tlsConfig, err := setupCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
+	tlsConfig, err := setupBrokerCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
 	if err != nil {
 		failf("failed to setup certificates err=%v", err)
 	}
@@ -337,13 +370,15 @@ func (cmd *groupCmd) saramaConfig() *sarama.Config {
 		cfg.Net.TLS.Enable = true
 		cfg.Net.TLS.Config = tlsConfig
 	}
+	if err = setupSASL(cfg, cmd.sasl); err != nil {
+		failf("failed to setup SASL err=%v", err)
+	}
 
 	return cfg
 }
 
 func (cmd *groupCmd) failStartup(msg string) {
-	fmt.Fprintln(os.Stderr, msg)
-	failf("use \"kt group -help\" for more information")
+	failUsage(msg, "kt group")
 }
 
 func (cmd *groupCmd) parseArgs(as []string) {
@@ -361,6 +396,7 @@ func (cmd *groupCmd) parseArgs(as []string) {
 	cmd.tlsCA = args.tlsCA
 	cmd.tlsCert = args.tlsCert
 	cmd.tlsCertKey = args.tlsCertKey
+	cmd.sasl = readSASLEnv(args.sasl)
 	cmd.group = args.group
 	cmd.verbose = args.verbose
 	cmd.pretty = args.pretty
@@ -406,12 +442,20 @@ func (cmd *groupCmd) parseArgs(as []string) {
 		// optional flag
 		cmd.reset = resetNotSpecified
 	default:
+		if strings.HasPrefix(args.reset, "@") {
+			if cmd.resetTime, err = parseTimestamp(strings.TrimPrefix(args.reset, "@")); err != nil {
+				cmd.failStartup(fmt.Sprintf(`reset value %#v not valid err=%v`, args.reset, err))
+			}
+			cmd.reset = offsetTime
+			break
+		}
+
 		cmd.reset, err = strconv.ParseInt(args.reset, 10, 64)
 		if err != nil {
 			if cmd.verbose {
 				fmt.Fprintf(os.Stderr, "failed to parse set %#v err=%v", args.reset, err)
 			}
-			cmd.failStartup(fmt.Sprintf(`set value %#v not valid. either newest, oldest or specific offset expected.`, args.reset))
+			cmd.failStartup(fmt.Sprintf(`set value %#v not valid. either newest, oldest, specific offset or @timestamp expected.`, args.reset))
 		}
 	}
 
@@ -437,6 +481,7 @@ type groupArgs struct {
 	tlsCA        string
 	tlsCert      string
 	tlsCertKey   string
+	sasl         saslArgs
 	partitions   string
 	group        string
 	filterGroups string
@@ -456,10 +501,17 @@ func (cmd *groupCmd) parseFlags(as []string) groupArgs {
 	flags.StringVar(&args.tlsCA, "tlsca", "", "Path to the TLS certificate authority file")
 	flags.StringVar(&args.tlsCert, "tlscert", "", "Path to the TLS client certificate file")
 	flags.StringVar(&args.tlsCertKey, "tlscertkey", "", "Path to the TLS client certificate key file")
+	flags.StringVar(&args.sasl.mechanism, "sasl-mechanism", "", "SASL mechanism to authenticate with (PLAIN|SCRAM-SHA-256|SCRAM-SHA-512|GSSAPI), defaults to none.")
+	flags.StringVar(&args.sasl.user, "sasl-user", "", "SASL user name")
+	flags.StringVar(&args.sasl.password, "sasl-password", "", "SASL password")
+	flags.StringVar(&args.sasl.krb5Config, "krb5-config", "", "Path to the Kerberos config file for GSSAPI (defaults to $KRB5_CONFIG or /etc/krb5.conf).")
+	flags.StringVar(&args.sasl.keytab, "keytab", "", "Path to the Kerberos keytab file for GSSAPI, otherwise -sasl-password is used.")
+	flags.StringVar(&args.sasl.principal, "principal", "", "Kerberos principal to authenticate as for GSSAPI, e.g. kt@EXAMPLE.COM (defaults to -sasl-user).")
+	flags.StringVar(&args.sasl.realm, "realm", "", "Kerberos realm for GSSAPI (defaults to the realm of -principal).")
 	flags.StringVar(&args.group, "group", "", "Consumer group name.")
 	flags.StringVar(&args.filterGroups, "filter-groups", "", "Regex to filter groups.")
 	flags.StringVar(&args.filterTopics, "filter-topics", "", "Regex to filter topics.")
-	flags.StringVar(&args.reset, "reset", "", "Target offset to reset for consumer group (newest, oldest, or specific offset)")
+	flags.StringVar(&args.reset, "reset", "", "Target offset to reset for consumer group (newest, oldest, specific offset or @timestamp)")
 	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
 	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
 	flags.StringVar(&args.version, "version", "", "Kafka protocol version")
@@ -474,17 +526,20 @@ func (cmd *groupCmd) parseFlags(as []string) groupArgs {
 
 	err := flags.Parse(as)
 	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
-		os.Exit(0)
+		exit(0)
 	} else if err != nil {
-		os.Exit(2)
+		exit(exitUsage)
 	}
 
+	jsonErrors = !args.pretty
 	return args
 }
 
 var groupDocString = `
 The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
 The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
 
 The group command can be used to list groups, their offsets and lag and to reset a group's offset.
 
@@ -513,4 +568,30 @@ kt group -reset 23 -topic fav-topic -group specials -partitions 2
 To reset a consumer group's offset for all partitions:
 
 kt group -reset newest -topic fav-topic -group specials -partitions all
+
+To reset a consumer group to the first messages at or after a point in time,
+pass the time like for consume's @ offsets, as RFC3339, a date or
+milliseconds since the epoch. Partitions without messages since then are
+reset to newest:
+
+kt group -reset @2023-06-01T12:00:00Z -topic fav-topic -group specials
+
+To inspect the record at an offset before committing it for a single partition:
+
+kt group set-offset -group specials -topic fav-topic -partition 3 -offset 12345
+
+To find partitions where a group is stuck and skip the blocking records:
+
+kt group skip -group specials -topic fav-topic
+
+To list the topics a group consumes:
+
+kt group topics -group specials
+
+To save a group's offsets and restore them later:
+
+kt group export -group specials > offsets.json
+kt group import -group specials -f offsets.json
+
+See "kt group set-offset -help", "kt group skip -help" and "kt group import -help" for details.
 `
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetAudit is printed by set-offset -audit before the confirmation prompt.
+// It shows the first records at the committed and at the proposed offset and
+// how many messages the group would skip or process again.
+type offsetAudit struct {
+	Group      string            `json:"group"`
+	Topic      string            `json:"topic"`
+	Partition  int32             `json:"partition"`
+	Current    *int64            `json:"current"`
+	Proposed   int64             `json:"proposed"`
+	Skipped    int64             `json:"skipped"`
+	Replayed   int64             `json:"replayed"`
+	AtCurrent  []consumedMessage `json:"atCurrent"`
+	AtProposed []consumedMessage `json:"atProposed"`
+}
+
+// newOffsetAudit compares the committed offset previous, -1 if unset, with the
+// proposed offset. Without a committed offset the group starts per its
+// consumers' config, so nothing counts as skipped or replayed.
+func newOffsetAudit(group, topic string, partition int32, previous, proposed int64) offsetAudit {
+	a := offsetAudit{
+		Group:      group,
+		Topic:      topic,
+		Partition:  partition,
+		Proposed:   proposed,
+		AtCurrent:  []consumedMessage{},
+		AtProposed: []consumedMessage{},
+	}
+	if previous < 0 {
+		return a
+	}
+	a.Current = &previous
+	switch {
+	case proposed > previous:
+		a.Skipped = proposed - previous
+	case proposed < previous:
+		a.Replayed = previous - proposed
+	}
+	return a
+}
+
+// audit prints the first cmd.auditRecords records at the committed and the
+// target offset, so the operator sees what the group would skip or process
+// again. The committed offset may have been removed by retention already, then
+// there are no records to show for it.
+func (cmd *groupSetOffsetCmd) audit(out chan printContext, previous int64) {
+	a := newOffsetAudit(cmd.group, cmd.topic, cmd.partition, previous, cmd.offset)
+	for _, at := range []struct {
+		offset  int64
+		current bool
+		msgs    *[]consumedMessage
+	}{
+		{previous, true, &a.AtCurrent},
+		{cmd.offset, false, &a.AtProposed},
+	} {
+		if at.offset < 0 {
+			continue
+		}
+		msgs, err := readRecordsAt(cmd.client, cmd.topic, cmd.partition, at.offset, cmd.auditRecords, cmd.timeout)
+		if err != nil && at.current {
+			fmt.Fprintf(os.Stderr, "failed to read records at committed offset %v err=%v\n", at.offset, err)
+			continue
+		}
+		if err != nil {
+			failf("failed to read records at offset %v err=%v", at.offset, err)
+		}
+		for _, m := range msgs {
+			*at.msgs = append(*at.msgs, newConsumedMessage(m, cmd.encodeKey, cmd.encodeValue, "string"))
+		}
+	}
+	ctx := printContext{output: a, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+// readRecordsAt returns up to n records starting at or after offset. It stops
+// early at the end of the partition or after timeout.
+func readRecordsAt(client sarama.Client, topic string, partition int32, offset int64, n int, timeout time.Duration) ([]*sarama.ConsumerMessage, error) {
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", consumer)
+
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume partition %v err=%v", partition, err)
+	}
+	defer logClose("partition consumer", pc)
+
+	msgs := []*sarama.ConsumerMessage{}
+	deadline := time.After(timeout)
+	for len(msgs) < n {
+		select {
+		case msg := <-pc.Messages():
+			msgs = append(msgs, msg)
+			if msg.Offset+1 >= pc.HighWaterMarkOffset() {
+				return msgs, nil
+			}
+		case err := <-pc.Errors():
+			return nil, err
+		case <-deadline:
+			return msgs, nil
+		}
+	}
+	return msgs, nil
+}
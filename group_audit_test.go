@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOffsetAudit(t *testing.T) {
+	data := []struct {
+		testName string
+		previous int64
+		proposed int64
+		skipped  int64
+		replayed int64
+	}{
+		{testName: "forward", previous: 10, proposed: 15, skipped: 5},
+		{testName: "back", previous: 10, proposed: 4, replayed: 6},
+		{testName: "unchanged", previous: 10, proposed: 10},
+		{testName: "unset", previous: -1, proposed: 4},
+	}
+
+	for _, d := range data {
+		t.Run(d.testName, func(t *testing.T) {
+			a := newOffsetAudit("g", "t", 2, d.previous, d.proposed)
+			require.Equal(t, d.skipped, a.Skipped)
+			require.Equal(t, d.replayed, a.Replayed)
+			require.Equal(t, d.proposed, a.Proposed)
+			if d.previous < 0 {
+				require.Nil(t, a.Current)
+			} else {
+				require.Equal(t, d.previous, *a.Current)
+			}
+		})
+	}
+
+	buf, err := json.Marshal(newOffsetAudit("g", "t", 2, -1, 4))
+	require.Nil(t, err)
+	require.JSONEq(t, `{"group":"g","topic":"t","partition":2,"current":null,"proposed":4,"skipped":0,"replayed":0,"atCurrent":[],"atProposed":[]}`, string(buf))
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// groupOffsetsCmd implements "kt group export", which prints the offsets a
+// group committed as a groupOffsetsFile, and "kt group import", which
+// commits the offsets of such a file.
+type groupOffsetsCmd struct {
+	clientConfig
+
+	name    string
+	group   string
+	topic   string
+	file    string
+	yes     bool
+	verbose bool
+	pretty  bool
+
+	client sarama.Client
+}
+
+type groupOffsetsArgs struct {
+	clientArgs
+
+	group   string
+	topic   string
+	file    string
+	yes     bool
+	verbose bool
+	pretty  bool
+}
+
+type groupOffsetsFile struct {
+	Group    string                  `json:"group"`
+	Exported time.Time               `json:"exported"`
+	Offsets  []groupOffsetsFileEntry `json:"offsets"`
+}
+
+type groupOffsetsFileEntry struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+func (cmd *groupOffsetsCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	if cmd.name == "export" {
+		cmd.runExport(out)
+		return
+	}
+	cmd.runImport(out)
+}
+
+// fetchGroupOffsets reads all offsets group committed, for all topics
+// unless topic is given.
+func fetchGroupOffsets(client sarama.Client, group, topic string) ([]groupOffsetsFileEntry, error) {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find coordinator for group %#v err=%v", group, err)
+	}
+
+	// without partitions, version 2 requests the offsets of all topics.
+	resp, err := coordinator.FetchOffset(&sarama.OffsetFetchRequest{ConsumerGroup: group, Version: 2})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets for group %#v err=%v", group, err)
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, fmt.Errorf("failed to fetch offsets for group %#v err=%v", group, resp.Err)
+	}
+
+	entries := []groupOffsetsFileEntry{}
+	for t, blocks := range resp.Blocks {
+		if topic != "" && t != topic {
+			continue
+		}
+		for p, block := range blocks {
+			if block.Err != sarama.ErrNoError {
+				return nil, fmt.Errorf("failed to fetch offset of topic %v partition %v for group %#v err=%v", t, p, group, block.Err)
+			}
+			if block.Offset >= 0 {
+				entries = append(entries, groupOffsetsFileEntry{Topic: t, Partition: p, Offset: block.Offset})
+			}
+		}
+	}
+	sortGroupOffsetsFileEntries(entries)
+	return entries, nil
+}
+
+func sortGroupOffsetsFileEntries(entries []groupOffsetsFileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Topic != entries[j].Topic {
+			return entries[i].Topic < entries[j].Topic
+		}
+		return entries[i].Partition < entries[j].Partition
+	})
+}
+
+func (cmd *groupOffsetsCmd) runExport(out chan printContext) {
+	entries, err := fetchGroupOffsets(cmd.client, cmd.group, cmd.topic)
+	if err != nil {
+		failf("failed to export offsets err=%v", err)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(os.Stderr, "group %v has no committed offsets.\n", cmd.group)
+	}
+
+	ctx := printContext{output: groupOffsetsFile{Group: cmd.group, Exported: time.Now().UTC(), Offsets: entries}, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+// readGroupOffsetsFile reads an exported file, - reads stdin. Only the
+// entries of topic are returned if it's given.
+func readGroupOffsetsFile(path, topic string) (groupOffsetsFile, error) {
+	var (
+		f   groupOffsetsFile
+		buf []byte
+		err error
+	)
+
+	if path == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return f, err
+	}
+
+	if err = json.Unmarshal(buf, &f); err != nil {
+		return f, fmt.Errorf("invalid offsets file err=%v", err)
+	}
+
+	entries := []groupOffsetsFileEntry{}
+	seen := map[topicPartition]bool{}
+	for _, e := range f.Offsets {
+		if e.Topic == "" || e.Partition < 0 || e.Offset < 0 {
+			return f, fmt.Errorf("invalid entry %+v, expected topic, partition and offset", e)
+		}
+		tp := topicPartition{e.Topic, e.Partition}
+		if seen[tp] {
+			return f, fmt.Errorf("duplicate entry for topic %v partition %v", e.Topic, e.Partition)
+		}
+		seen[tp] = true
+		if topic == "" || e.Topic == topic {
+			entries = append(entries, e)
+		}
+	}
+	sortGroupOffsetsFileEntries(entries)
+	f.Offsets = entries
+	return f, nil
+}
+
+func (cmd *groupOffsetsCmd) runImport(out chan printContext) {
+	f, err := readGroupOffsetsFile(cmd.file, cmd.topic)
+	if err != nil {
+		failf("failed to read offsets file %v err=%v", cmd.file, err)
+	}
+	if len(f.Offsets) == 0 {
+		failf("found no offsets to import in %v", cmd.file)
+	}
+	if f.Group != "" && f.Group != cmd.group {
+		fmt.Fprintf(os.Stderr, "importing offsets exported for group %v into group %v.\n", f.Group, cmd.group)
+	}
+
+	previous := map[topicPartition]int64{}
+	current, err := fetchGroupOffsets(cmd.client, cmd.group, "")
+	if err != nil {
+		failf("failed to read committed offsets err=%v", err)
+	}
+	for _, e := range current {
+		previous[topicPartition{e.Topic, e.Partition}] = e.Offset
+	}
+
+	for _, e := range f.Offsets {
+		cmd.checkRange(e)
+		from := "unset"
+		if p, ok := previous[topicPartition{e.Topic, e.Partition}]; ok {
+			from = fmt.Sprint(p)
+		}
+		fmt.Fprintf(os.Stderr, "topic %v partition %v: %v -> %v\n", e.Topic, e.Partition, from, e.Offset)
+	}
+
+	if !cmd.yes && !confirmf("set %v offsets of group %v?", len(f.Offsets), cmd.group) {
+		failf("aborted, offsets unchanged.")
+	}
+
+	for _, e := range f.Offsets {
+		if err := commitGroupOffset(cmd.client, cmd.group, e.Topic, e.Partition, e.Offset); err != nil {
+			failf("%v", err)
+		}
+
+		prev, ok := previous[topicPartition{e.Topic, e.Partition}]
+
+		result := groupSetOffsetResult{Group: cmd.group, Topic: e.Topic, Partition: e.Partition, Offset: e.Offset}
+		if ok {
+			result.Previous = &prev
+		}
+		ctx := printContext{output: result, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+// checkRange warns about offsets outside of the partition's available range,
+// e.g. when retention deleted messages since the export. Consumers start
+// according to their offset reset policy for those.
+func (cmd *groupOffsetsCmd) checkRange(e groupOffsetsFileEntry) {
+	oldest, err := cmd.client.GetOffset(e.Topic, e.Partition, sarama.OffsetOldest)
+	if err != nil {
+		failf("failed to read oldest offset for topic %v partition %v err=%v", e.Topic, e.Partition, err)
+	}
+
+	newest, err := cmd.client.GetOffset(e.Topic, e.Partition, sarama.OffsetNewest)
+	if err != nil {
+		failf("failed to read newest offset for topic %v partition %v err=%v", e.Topic, e.Partition, err)
+	}
+
+	if e.Offset < oldest || e.Offset > newest {
+		fmt.Fprintf(os.Stderr, "offset %v is outside of the available range %v to %v for topic %v partition %v.\n", e.Offset, oldest, newest, e.Topic, e.Partition)
+	}
+}
+
+func (cmd *groupOffsetsCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("group")
+}
+
+func (cmd *groupOffsetsCmd) failStartup(msg string) {
+	failUsage(msg, fmt.Sprintf("kt group %v", cmd.name))
+}
+
+func (cmd *groupOffsetsCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.group == "" {
+		cmd.failStartup("group is required.")
+	}
+
+	if cmd.name == "import" && args.file == "" {
+		cmd.failStartup("-f is required.")
+	}
+
+	if args.file == "-" && !args.yes {
+		cmd.failStartup("-f - requires -yes as stdin can't be used for the confirmation prompt.")
+	}
+
+	cmd.group = args.group
+	cmd.topic = args.topic
+	cmd.file = args.file
+	cmd.yes = args.yes
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *groupOffsetsCmd) parseFlags(as []string) groupOffsetsArgs {
+	var args groupOffsetsArgs
+	flags := flag.NewFlagSet("group "+cmd.name, flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.group, "group", "", "Consumer group name (required).")
+	flags.StringVar(&args.topic, "topic", "", "Only export or import the offsets of the given topic (defaults to all topics).")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+	if cmd.name == "import" {
+		flags.StringVar(&args.file, "f", "", "Path to the exported offsets to import, - reads stdin (required).")
+		flags.BoolVar(&args.yes, "yes", false, "Skip the confirmation prompt.")
+	}
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of group %v:\n", cmd.name)
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, groupOffsetsDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var groupOffsetsDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+export prints the offsets a group committed for all topics, or only -topic,
+as JSON that import reads to commit them again, e.g. to restore the group's
+positions after a risky reset or to move them to another group:
+
+kt group export -group specials > offsets.json
+kt group import -group specials -f offsets.json
+
+import lists the changes and asks for confirmation unless -yes is given.
+Brokers reject offset commits for groups with active members, so stop the
+group's consumers before importing.`
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadGroupOffsetsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-group-offsets")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(content string) string {
+		path := filepath.Join(dir, "offsets.json")
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	path := write(`{"group": "g", "exported": "2023-06-01T12:00:00Z", "offsets": [
+  {"topic": "b", "partition": 0, "offset": 7},
+  {"topic": "a", "partition": 1, "offset": 3},
+  {"topic": "a", "partition": 0, "offset": 5}
+]}`)
+
+	f, err := readGroupOffsetsFile(path, "")
+	require.Nil(t, err)
+	require.Equal(t, "g", f.Group)
+	require.Equal(t, []groupOffsetsFileEntry{
+		{Topic: "a", Partition: 0, Offset: 5},
+		{Topic: "a", Partition: 1, Offset: 3},
+		{Topic: "b", Partition: 0, Offset: 7},
+	}, f.Offsets)
+
+	f, err = readGroupOffsetsFile(path, "b")
+	require.Nil(t, err)
+	require.Equal(t, []groupOffsetsFileEntry{{Topic: "b", Partition: 0, Offset: 7}}, f.Offsets)
+
+	invalid := []string{
+		`{"offsets": [{"topic": "a", "partition": 0, "offset": 1}, {"topic": "a", "partition": 0, "offset": 2}]}`,
+		`{"offsets": [{"partition": 0, "offset": 1}]}`,
+		`{"offsets": [{"topic": "a", "partition": 0, "offset": -1}]}`,
+		`[{"topic": "a"}]`,
+	}
+	for _, content := range invalid {
+		_, err = readGroupOffsetsFile(write(content), "")
+		require.NotNil(t, err, content)
+	}
+
+	_, err = readGroupOffsetsFile(filepath.Join(dir, "missing.json"), "")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type groupSetOffsetCmd struct {
+	clientConfig
+
+	group        string
+	topic        string
+	partition    int32
+	offset       int64
+	yes          bool
+	auditRecords int
+	timeout      time.Duration
+	encodeValue  string
+	encodeKey    string
+	verbose      bool
+	pretty       bool
+
+	client sarama.Client
+}
+
+type groupSetOffsetArgs struct {
+	clientArgs
+
+	group       string
+	topic       string
+	partition   int
+	offset      int64
+	yes         bool
+	audit       int
+	timeout     time.Duration
+	encodeValue string
+	encodeKey   string
+	verbose     bool
+	pretty      bool
+}
+
+type groupSetOffsetResult struct {
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Previous  *int64 `json:"previous"`
+	Offset    int64  `json:"offset"`
+}
+
+func (cmd *groupSetOffsetCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	previous, err := fetchCommittedOffset(cmd.client, cmd.group, cmd.topic, cmd.partition)
+	if err != nil {
+		failf("failed to read committed offset err=%v", err)
+	}
+
+	if err = cmd.checkRange(); err != nil {
+		failf("%v", err)
+	}
+	if cmd.auditRecords > 0 {
+		cmd.audit(out, previous)
+	} else if err = cmd.preflight(out); err != nil {
+		failf("%v", err)
+	}
+
+	if !cmd.yes && !cmd.confirm(previous) {
+		failf("aborted, offset unchanged.")
+	}
+
+	if err = commitGroupOffset(cmd.client, cmd.group, cmd.topic, cmd.partition, cmd.offset); err != nil {
+		failf("%v", err)
+	}
+
+	result := groupSetOffsetResult{Group: cmd.group, Topic: cmd.topic, Partition: cmd.partition, Offset: cmd.offset}
+	if previous >= 0 {
+		result.Previous = &previous
+	}
+	ctx := printContext{output: result, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+func (cmd *groupSetOffsetCmd) checkRange() error {
+	oldest, err := cmd.client.GetOffset(cmd.topic, cmd.partition, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("failed to read oldest offset for partition %v err=%v", cmd.partition, err)
+	}
+
+	newest, err := cmd.client.GetOffset(cmd.topic, cmd.partition, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("failed to read newest offset for partition %v err=%v", cmd.partition, err)
+	}
+
+	if cmd.offset < oldest || cmd.offset > newest {
+		return fmt.Errorf("offset %v is outside of the available range %v to %v for partition %v", cmd.offset, oldest, newest, cmd.partition)
+	}
+	return nil
+}
+
+// preflight prints the record at the target offset, so the operator can
+// confirm it's the one the group should process next.
+func (cmd *groupSetOffsetCmd) preflight(out chan printContext) error {
+	msg, err := readRecordAt(cmd.client, cmd.topic, cmd.partition, cmd.offset, cmd.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to read record at offset %v err=%v", cmd.offset, err)
+	}
+
+	if msg == nil {
+		fmt.Fprintf(os.Stderr, "no record at offset %v within %v, the group will wait for new messages.\n", cmd.offset, cmd.timeout)
+		return nil
+	}
+
+	if msg.Offset != cmd.offset {
+		fmt.Fprintf(os.Stderr, "no record at offset %v, the next available record is at offset %v:\n", cmd.offset, msg.Offset)
+	}
+	ctx := printContext{output: newConsumedMessage(msg, cmd.encodeKey, cmd.encodeValue, "string"), done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+	return nil
+}
+
+func (cmd *groupSetOffsetCmd) confirm(previous int64) bool {
+	from := "unset"
+	if previous >= 0 {
+		from = fmt.Sprint(previous)
+	}
+	return confirmf("set offset of group %v for topic %v partition %v from %v to %v?", cmd.group, cmd.topic, cmd.partition, from, cmd.offset)
+}
+
+// readRecordAt returns the first record at or after offset, or nil if there's
+// none within timeout.
+func readRecordAt(client sarama.Client, topic string, partition int32, offset int64, timeout time.Duration) (*sarama.ConsumerMessage, error) {
+	msgs, err := readRecordsAt(client, topic, partition, offset, 1, timeout)
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+	return msgs[0], nil
+}
+
+// confirmAnswers buffers the answers to confirmf's prompts. All prompts share
+// it so that piped answers after the first aren't lost in the buffer of an
+// earlier prompt. It's created lazily as os.Stdin is replaced after -config -.
+var confirmAnswers struct {
+	sync.Mutex
+	in     *os.File
+	reader *bufio.Reader
+}
+
+// confirmf prompts the operator on stderr and reads the answer from stdin.
+func confirmf(msg string, args ...interface{}) bool {
+	fmt.Fprintf(os.Stderr, msg+" [y/N] ", args...)
+
+	confirmAnswers.Lock()
+	defer confirmAnswers.Unlock()
+	if confirmAnswers.in != os.Stdin {
+		confirmAnswers.in = os.Stdin
+		confirmAnswers.reader = bufio.NewReader(os.Stdin)
+	}
+
+	answer, err := confirmAnswers.reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// commitGroupOffset commits offset for group and returns an error if the
+// broker rejects the commit. kt commits outside of the group's generation,
+// which brokers reject while the group has members, e.g. with
+// UNKNOWN_MEMBER_ID, as the members would overwrite the offset anyway.
+func commitGroupOffset(client sarama.Client, group, topic string, partition int32, offset int64) error {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return fmt.Errorf("failed to find coordinator for group %#v err=%v", group, err)
+	}
+
+	req := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	req.AddBlock(topic, partition, offset, -1, 0, "")
+	resp, err := coordinator.CommitOffset(req)
+	if err != nil {
+		return fmt.Errorf("failed to commit offset of partition %v for group %#v err=%v", partition, group, err)
+	}
+
+	kerr, ok := resp.Errors[topic][partition]
+	if !ok {
+		return fmt.Errorf("missing partition %v of topic %v in commit response for group %#v", partition, topic, group)
+	}
+	if kerr != sarama.ErrNoError {
+		return fmt.Errorf("broker rejected offset %v of partition %v for group %#v, it may have active members, err=%v", offset, partition, group, kerr)
+	}
+	return nil
+}
+
+func (cmd *groupSetOffsetCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("group")
+}
+
+func (cmd *groupSetOffsetCmd) failStartup(msg string) {
+	failUsage(msg, "kt group set-offset")
+}
+
+func (cmd *groupSetOffsetCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+
+	if args.group == "" || args.topic == "" || args.partition < 0 || args.offset < 0 {
+		cmd.failStartup("group, topic, partition and offset are required.")
+	}
+
+	cmd.group = args.group
+	cmd.topic = args.topic
+	cmd.partition = int32(args.partition)
+	cmd.offset = args.offset
+	cmd.yes = args.yes
+	if args.audit < 0 {
+		cmd.failStartup(fmt.Sprintf("invalid audit argument %v, expected a number of records.", args.audit))
+	}
+	cmd.auditRecords = args.audit
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+
+	if args.encodeValue != "string" && args.encodeValue != "hex" && args.encodeValue != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodevalue argument %#v, only string, hex and base64 are supported.`, args.encodeValue))
+	}
+	cmd.encodeValue = args.encodeValue
+
+	if args.encodeKey != "string" && args.encodeKey != "hex" && args.encodeKey != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodekey argument %#v, only string, hex and base64 are supported.`, args.encodeKey))
+	}
+	cmd.encodeKey = args.encodeKey
+}
+
+func (cmd *groupSetOffsetCmd) parseFlags(as []string) groupSetOffsetArgs {
+	var args groupSetOffsetArgs
+	flags := flag.NewFlagSet("group set-offset", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.group, "group", "", "Consumer group name (required).")
+	flags.StringVar(&args.topic, "topic", "", "Topic of the offset (required).")
+	flags.IntVar(&args.partition, "partition", -1, "Partition of the offset (required).")
+	flags.Int64Var(&args.offset, "offset", -1, "Offset the group should process next (required).")
+	flags.BoolVar(&args.yes, "yes", false, "Skip the confirmation prompt.")
+	flags.IntVar(&args.audit, "audit", 0, "Print the first N records at both the committed and the target offset before confirming, instead of only the record at the target offset.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Timeout for reading the record at the target offset.")
+	flags.StringVar(&args.encodeValue, "encodevalue", "string", "Present message value as (string|hex|base64), defaults to string.")
+	flags.StringVar(&args.encodeKey, "encodekey", "string", "Present message key as (string|hex|base64), defaults to string.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of group set-offset:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, groupSetOffsetDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var groupSetOffsetDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+set-offset sets the committed offset of a group for a single partition, e.g.
+to move a group past a poison-pill message. Before committing, kt prints the
+record at the target offset and asks for confirmation. Use -yes to skip the
+prompt. Brokers reject the commit while the group has active members, stop
+its consumers first.
+
+With -audit N, kt instead prints the first N records at both the currently
+committed and the target offset, together with how many messages the group
+would skip (moving forward) or process again (moving back), so it's clear what
+the reset does before confirming:
+
+kt group set-offset -group specials -topic fav-topic -partition 3 -offset 12000 -audit 5
+
+The offset is the offset of the next message the group should process:
+
+kt group set-offset -group specials -topic fav-topic -partition 3 -offset 12345`
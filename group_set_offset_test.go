@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// newGroupTestBroker serves partition 0 of topic orders from offset 10 to 20
+// with a record at offset 12 and coordinates group audit.
+func newGroupTestBroker(t *testing.T) (*sarama.MockBroker, *sarama.MockOffsetCommitResponse, sarama.Client) {
+	broker := sarama.NewMockBroker(t, 1)
+	commits := sarama.NewMockOffsetCommitResponse(t)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, sarama.OffsetOldest, 10).
+			SetOffset("orders", 0, sarama.OffsetNewest, 20),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage("orders", 0, 12, sarama.StringEncoder("poison")).
+			SetHighWaterMark("orders", 0, 20),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "audit", broker),
+		"OffsetCommitRequest": commits,
+	})
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_10_2_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	require.Nil(t, err)
+	return broker, commits, client
+}
+
+func TestGroupSetOffsetCheckRange(t *testing.T) {
+	broker, _, client := newGroupTestBroker(t)
+	defer broker.Close()
+	defer client.Close()
+
+	cmd := &groupSetOffsetCmd{client: client, topic: "orders", partition: 0}
+	for offset, valid := range map[int64]bool{9: false, 10: true, 20: true, 21: false} {
+		cmd.offset = offset
+		if valid {
+			require.Nil(t, cmd.checkRange(), "offset %v", offset)
+		} else {
+			require.NotNil(t, cmd.checkRange(), "offset %v", offset)
+		}
+	}
+}
+
+func TestGroupSetOffsetPreflight(t *testing.T) {
+	broker, _, client := newGroupTestBroker(t)
+	defer broker.Close()
+	defer client.Close()
+
+	out := make(chan printContext)
+	printed := make(chan interface{}, 1)
+	go func() {
+		ctx := <-out
+		printed <- ctx.output
+		close(ctx.done)
+	}()
+
+	cmd := &groupSetOffsetCmd{client: client, topic: "orders", partition: 0, offset: 12, timeout: time.Second, encodeKey: "string", encodeValue: "string"}
+	require.Nil(t, cmd.preflight(out))
+	msg := (<-printed).(consumedMessage)
+	require.Equal(t, int64(12), msg.Offset)
+	require.Equal(t, "poison", *msg.Value.(*string))
+}
+
+func TestGroupSetOffsetConfirm(t *testing.T) {
+	data := []struct {
+		input    string
+		expected bool
+	}{
+		{input: "y\n", expected: true},
+		{input: "YES\n", expected: true},
+		{input: "n\n", expected: false},
+		{input: "\n", expected: false},
+		{input: "", expected: false},
+	}
+
+	cmd := &groupSetOffsetCmd{group: "audit", topic: "orders", offset: 12}
+	for _, d := range data {
+		t.Run(d.input, func(t *testing.T) {
+			withStdin(t, d.input, func() {
+				require.Equal(t, d.expected, cmd.confirm(-1))
+			})
+		})
+	}
+}
+
+func TestCommitGroupOffset(t *testing.T) {
+	broker, commits, client := newGroupTestBroker(t)
+	defer broker.Close()
+	defer client.Close()
+
+	require.Nil(t, commitGroupOffset(client, "audit", "orders", 0, 12))
+
+	commits.SetError("audit", "orders", 0, sarama.ErrUnknownMemberId)
+	err := commitGroupOffset(client, "audit", "orders", 0, 12)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "rejected")
+}
+
+// withStdin runs fn with os.Stdin reading input.
+func withStdin(t *testing.T, input string, fn func()) {
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+	_, err = w.WriteString(input)
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		r.Close()
+	}()
+	fn()
+}
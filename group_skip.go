@@ -0,0 +1,306 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type groupSkipCmd struct {
+	clientConfig
+
+	group       string
+	topic       string
+	polls       int
+	interval    time.Duration
+	yes         bool
+	timeout     time.Duration
+	encodeValue string
+	encodeKey   string
+	verbose     bool
+	pretty      bool
+
+	client sarama.Client
+}
+
+type groupSkipArgs struct {
+	clientArgs
+
+	group       string
+	topic       string
+	polls       int
+	interval    time.Duration
+	yes         bool
+	timeout     time.Duration
+	encodeValue string
+	encodeKey   string
+	verbose     bool
+	pretty      bool
+}
+
+func (cmd *groupSkipCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic=%s err=%v", cmd.topic, err)
+	}
+
+	stuck := cmd.findStuckPartitions(partitions)
+	if len(stuck) == 0 {
+		fmt.Fprintf(os.Stderr, "found no partitions where group %v is stuck.\n", cmd.group)
+		return
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	stuckPartitions := []int32{}
+	for p := range stuck {
+		stuckPartitions = append(stuckPartitions, p)
+	}
+	sort.Slice(stuckPartitions, func(i, j int) bool { return stuckPartitions[i] < stuckPartitions[j] })
+
+	for _, p := range stuckPartitions {
+		cmd.skip(out, p, stuck[p])
+	}
+}
+
+// findStuckPartitions polls the committed offsets of the group and returns
+// the partitions where the offset didn't change between polls even though
+// there are messages left to consume, mapped to the committed offset. If the
+// group has active members, only partitions assigned to them are considered
+// as the others aren't consumed at all.
+func (cmd *groupSkipCmd) findStuckPartitions(partitions []int32) map[int32]int64 {
+	assigned, err := cmd.assignedPartitions()
+	if err != nil {
+		failf("failed to describe group %v err=%v", cmd.group, err)
+	}
+	if assigned == nil {
+		fmt.Fprintf(os.Stderr, "group %v has no active members, considering all partitions with lag.\n", cmd.group)
+	}
+
+	stuck := map[int32]int64{}
+	for _, p := range partitions {
+		if assigned != nil && !assigned[p] {
+			continue
+		}
+		off, err := fetchCommittedOffset(cmd.client, cmd.group, cmd.topic, p)
+		if err != nil {
+			failf("failed to read committed offset for partition %v err=%v", p, err)
+		}
+		if off >= 0 {
+			stuck[p] = off
+		}
+	}
+
+	for i := 1; i < cmd.polls && len(stuck) > 0; i++ {
+		fmt.Fprintf(os.Stderr, "poll %v/%v: %v partitions without progress, checking again in %v.\n", i, cmd.polls, len(stuck), cmd.interval)
+		time.Sleep(cmd.interval)
+
+		for p, previous := range stuck {
+			off, err := fetchCommittedOffset(cmd.client, cmd.group, cmd.topic, p)
+			if err != nil {
+				failf("failed to read committed offset for partition %v err=%v", p, err)
+			}
+			if off != previous {
+				delete(stuck, p)
+			}
+		}
+	}
+
+	for p, off := range stuck {
+		newest, err := cmd.client.GetOffset(cmd.topic, p, sarama.OffsetNewest)
+		if err != nil {
+			failf("failed to read newest offset for partition %v err=%v", p, err)
+		}
+		if off >= newest {
+			delete(stuck, p)
+		}
+	}
+
+	return stuck
+}
+
+// assignedPartitions returns the partitions of the topic that are assigned to
+// the group's members, nil if the group has no members or doesn't use the
+// consumer protocol.
+func (cmd *groupSkipCmd) assignedPartitions() (map[int32]bool, error) {
+	coordinator, err := cmd.client.Coordinator(cmd.group)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := coordinator.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: []string{cmd.group}})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Groups) != 1 {
+		return nil, fmt.Errorf("expected one group description, got %v", len(resp.Groups))
+	}
+	desc := resp.Groups[0]
+	if desc.Err != sarama.ErrNoError {
+		return nil, desc.Err
+	}
+	if len(desc.Members) == 0 || desc.ProtocolType != "consumer" {
+		return nil, nil
+	}
+
+	assigned := map[int32]bool{}
+	for _, gt := range newGroupTopics(desc, nil) {
+		if gt.Topic != cmd.topic {
+			continue
+		}
+		for _, p := range gt.AssignedPartitions {
+			assigned[p] = true
+		}
+	}
+	return assigned, nil
+}
+
+func (cmd *groupSkipCmd) skip(out chan printContext, partition int32, offset int64) {
+	msg, err := readRecordAt(cmd.client, cmd.topic, partition, offset, cmd.timeout)
+	if err != nil {
+		failf("failed to read record at offset %v on partition %v err=%v", offset, partition, err)
+	}
+
+	if msg == nil {
+		fmt.Fprintf(os.Stderr, "failed to read record at offset %v on partition %v within %v, leaving it alone.\n", offset, partition, cmd.timeout)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "group %v is stuck on partition %v at offset %v:\n", cmd.group, partition, offset)
+	ctx := printContext{output: newConsumedMessage(msg, cmd.encodeKey, cmd.encodeValue, "string"), done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	// the record at the committed offset might be gone due to retention or
+	// compaction, so skip past the record that was actually read.
+	next := msg.Offset + 1
+	if !cmd.yes && !confirmf("skip to offset %v on partition %v for group %v?", next, partition, cmd.group) {
+		fmt.Fprintf(os.Stderr, "leaving offset of partition %v unchanged.\n", partition)
+		return
+	}
+
+	if err = commitGroupOffset(cmd.client, cmd.group, cmd.topic, partition, next); err != nil {
+		failf("%v", err)
+	}
+
+	result := groupSetOffsetResult{Group: cmd.group, Topic: cmd.topic, Partition: partition, Previous: &offset, Offset: next}
+	ctx = printContext{output: result, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+func (cmd *groupSkipCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("group")
+}
+
+func (cmd *groupSkipCmd) failStartup(msg string) {
+	failUsage(msg, "kt group skip")
+}
+
+func (cmd *groupSkipCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+
+	if args.group == "" || args.topic == "" {
+		cmd.failStartup("group and topic are required.")
+	}
+
+	if args.polls < 2 {
+		cmd.failStartup("at least 2 polls are required to detect stuck partitions.")
+	}
+
+	cmd.group = args.group
+	cmd.topic = args.topic
+	cmd.polls = args.polls
+	cmd.interval = args.interval
+	cmd.yes = args.yes
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+
+	if args.encodeValue != "string" && args.encodeValue != "hex" && args.encodeValue != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodevalue argument %#v, only string, hex and base64 are supported.`, args.encodeValue))
+	}
+	cmd.encodeValue = args.encodeValue
+
+	if args.encodeKey != "string" && args.encodeKey != "hex" && args.encodeKey != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodekey argument %#v, only string, hex and base64 are supported.`, args.encodeKey))
+	}
+	cmd.encodeKey = args.encodeKey
+}
+
+func (cmd *groupSkipCmd) parseFlags(as []string) groupSkipArgs {
+	var args groupSkipArgs
+	flags := flag.NewFlagSet("group skip", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.group, "group", "", "Consumer group name (required).")
+	flags.StringVar(&args.topic, "topic", "", "Topic the group consumes (required).")
+	flags.IntVar(&args.polls, "polls", 3, "Number of times to poll the committed offsets to detect stuck partitions.")
+	flags.DurationVar(&args.interval, "interval", 10*time.Second, "Time to wait between polls.")
+	flags.BoolVar(&args.yes, "yes", false, "Skip the confirmation prompt.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Timeout for reading the blocking record.")
+	flags.StringVar(&args.encodeValue, "encodevalue", "string", "Present message value as (string|hex|base64), defaults to string.")
+	flags.StringVar(&args.encodeKey, "encodekey", "string", "Present message key as (string|hex|base64), defaults to string.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of group skip:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, groupSkipDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var groupSkipDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+skip helps to get a group past a poison-pill message. It polls the group's
+committed offsets -polls times, -interval apart. Partitions where the
+committed offset didn't change although there are messages left are
+considered stuck. For each stuck partition kt prints the blocking record and
+asks for confirmation before committing the offset after it.
+
+Run it while the group's consumers are running: only partitions assigned to
+a member are considered then, so a partition nobody consumes doesn't count as
+stuck. Without active members, kt can't tell a stuck partition from one that
+isn't consumed and considers every partition with lag. Brokers reject offset
+commits for groups with active members, so stop the group's consumers before
+confirming.
+
+kt group skip -group specials -topic fav-topic`
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// newGroupSkipTestClient serves partitions 0 to 3 of topic orders up to
+// offset 20 with records at offset 12 of partitions 0 and 1. Group audit
+// committed 12, 5, 20 and nothing for the partitions, and progresses on
+// partition 1 after the first poll. Its members are the given ones.
+func newGroupSkipTestClient(t *testing.T, members map[string]*sarama.GroupMemberDescription) (*sarama.MockBroker, sarama.Client) {
+	broker := sarama.NewMockBroker(t, 1)
+
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	offsets := sarama.NewMockOffsetResponse(t)
+	for p := int32(0); p < 4; p++ {
+		metadata.SetLeader("orders", p, broker.BrokerID())
+		offsets.SetOffset("orders", p, sarama.OffsetOldest, 0).SetOffset("orders", p, sarama.OffsetNewest, 20)
+	}
+	committed := func(p1 int64) *sarama.MockOffsetFetchResponse {
+		return sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("audit", "orders", 0, 12, "", sarama.ErrNoError).
+			SetOffset("audit", "orders", 1, p1, "", sarama.ErrNoError).
+			SetOffset("audit", "orders", 2, 20, "", sarama.ErrNoError).
+			SetOffset("audit", "orders", 3, -1, "", sarama.ErrNoError)
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"OffsetRequest":   offsets,
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage("orders", 0, 12, sarama.StringEncoder("poison")).
+			SetMessage("orders", 1, 12, sarama.StringEncoder("pill")).
+			SetHighWaterMark("orders", 0, 20).
+			SetHighWaterMark("orders", 1, 20),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "audit", broker),
+		// the first poll reads each partition's offset in turn.
+		"OffsetFetchRequest": sarama.NewMockSequence(committed(5), committed(5), committed(5), committed(5), committed(6)),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("audit", &sarama.GroupDescription{GroupId: "audit", State: "Stable", ProtocolType: "consumer", Members: members}),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_10_2_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	require.Nil(t, err)
+	return broker, client
+}
+
+func TestGroupSkipFindStuckPartitions(t *testing.T) {
+	broker, client := newGroupSkipTestClient(t, nil)
+	defer broker.Close()
+	defer client.Close()
+
+	cmd := &groupSkipCmd{client: client, group: "audit", topic: "orders", polls: 2, interval: time.Millisecond}
+	require.Equal(t, map[int32]int64{0: 12}, cmd.findStuckPartitions([]int32{0, 1, 2, 3}))
+}
+
+func TestGroupSkipFindStuckPartitionsAssigned(t *testing.T) {
+	// partition 0 isn't assigned to the group's only member, so it's not
+	// consumed rather than stuck. A single poll takes partition 1 as stuck.
+	broker, client := newGroupSkipTestClient(t, map[string]*sarama.GroupMemberDescription{
+		"m1": {MemberAssignment: encodeTestMemberAssignment("orders", 1, 2, 3)},
+	})
+	defer broker.Close()
+	defer client.Close()
+
+	cmd := &groupSkipCmd{client: client, group: "audit", topic: "orders", polls: 1, interval: time.Millisecond}
+	require.Equal(t, map[int32]int64{1: 5}, cmd.findStuckPartitions([]int32{0, 1, 2, 3}))
+}
+
+func TestGroupSkip(t *testing.T) {
+	broker, client := newGroupSkipTestClient(t, nil)
+	defer broker.Close()
+	defer client.Close()
+
+	out := make(chan printContext)
+	printed := make(chan []interface{})
+	go func() {
+		outputs := []interface{}{}
+		for ctx := range out {
+			outputs = append(outputs, ctx.output)
+			close(ctx.done)
+		}
+		printed <- outputs
+	}()
+
+	// both answers arrive at once, the second prompt must still see its own.
+	cmd := &groupSkipCmd{client: client, group: "audit", topic: "orders", timeout: time.Second, encodeKey: "string", encodeValue: "string"}
+	withStdin(t, "n\ny\n", func() {
+		cmd.skip(out, 0, 12)
+		cmd.skip(out, 1, 12)
+	})
+	close(out)
+
+	outputs := <-printed
+	require.Len(t, outputs, 3)
+	require.Equal(t, "poison", *outputs[0].(consumedMessage).Value.(*string))
+	require.Equal(t, "pill", *outputs[1].(consumedMessage).Value.(*string))
+	result := outputs[2].(groupSetOffsetResult)
+	require.Equal(t, int32(1), result.Partition)
+	require.Equal(t, int64(12), *result.Previous)
+	require.Equal(t, int64(13), result.Offset)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupParseArgsReset(t *testing.T) {
+	data := []struct {
+		reset        string
+		expected     int64
+		expectedTime time.Time
+	}{
+		{reset: "", expected: resetNotSpecified},
+		{reset: "newest", expected: sarama.OffsetNewest},
+		{reset: "oldest", expected: sarama.OffsetOldest},
+		{reset: "23", expected: 23},
+		{
+			reset:        "@2023-06-01T12:00:00Z",
+			expected:     offsetTime,
+			expectedTime: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			reset:        "@1685620800000",
+			expected:     offsetTime,
+			expectedTime: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, d := range data {
+		target := &groupCmd{}
+		target.parseArgs([]string{"-topic", "news", "-group", "specials", "-reset", d.reset})
+		require.Equal(t, d.expected, target.reset, d.reset)
+		require.Equal(t, d.expectedTime, target.resetTime, d.reset)
+	}
+}
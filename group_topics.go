@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// groupTopicsCmd implements "kt group topics", which lists the topics a
+// group consumes, and "kt topic groups", which lists the groups that
+// consume a topic. Both are derived from the group's committed offsets and
+// the partitions assigned to its members.
+type groupTopicsCmd struct {
+	clientConfig
+
+	name    string
+	group   string
+	topic   string
+	verbose bool
+	pretty  bool
+
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+type groupTopicsArgs struct {
+	clientArgs
+
+	group   string
+	topic   string
+	verbose bool
+	pretty  bool
+}
+
+// groupTopic describes how a group consumes a topic: the partitions it
+// committed offsets for and the partitions currently assigned to its
+// members. Only one of them is set for groups that are inactive or new to
+// the topic.
+type groupTopic struct {
+	Group               string  `json:"group"`
+	Topic               string  `json:"topic"`
+	State               string  `json:"state,omitempty"`
+	Members             int     `json:"members"`
+	AssignedPartitions  []int32 `json:"assignedPartitions"`
+	CommittedPartitions []int32 `json:"committedPartitions"`
+}
+
+func (cmd *groupTopicsCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.admin, err = sarama.NewClusterAdminFromClient(cmd.client); err != nil {
+		failf("failed to create cluster admin err=%v", err)
+	}
+
+	groups := []string{cmd.group}
+	if cmd.group == "" {
+		all, err := cmd.admin.ListConsumerGroups()
+		if err != nil {
+			failf("failed to list groups err=%v", err)
+		}
+		groups = []string{}
+		for g := range all {
+			groups = append(groups, g)
+		}
+		sort.Strings(groups)
+		fmt.Fprintf(os.Stderr, "found %v groups\n", len(groups))
+	}
+
+	descriptions, err := cmd.admin.DescribeConsumerGroups(groups)
+	if err != nil {
+		failf("failed to describe groups err=%v", err)
+	}
+	sort.Slice(descriptions, func(i, j int) bool { return descriptions[i].GroupId < descriptions[j].GroupId })
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	for _, desc := range descriptions {
+		if desc.Err != sarama.ErrNoError {
+			fmt.Fprintf(os.Stderr, "failed to describe group %v err=%v\n", desc.GroupId, desc.Err)
+			continue
+		}
+
+		committed, err := fetchGroupOffsets(cmd.client, desc.GroupId, cmd.topic)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read committed offsets of group %v err=%v\n", desc.GroupId, err)
+			continue
+		}
+
+		for _, gt := range newGroupTopics(desc, committed) {
+			if cmd.topic != "" && gt.Topic != cmd.topic {
+				continue
+			}
+			ctx := printContext{output: gt, done: make(chan struct{})}
+			out <- ctx
+			<-ctx.done
+		}
+	}
+}
+
+// newGroupTopics merges the partitions assigned to the group's members with
+// the partitions it committed offsets for into a groupTopic per topic.
+// Assignments of groups that don't use the consumer protocol, e.g. Connect
+// workers, can't be decoded and are ignored.
+func newGroupTopics(desc *sarama.GroupDescription, committed []groupOffsetsFileEntry) []groupTopic {
+	var (
+		topics  = map[string]*groupTopic{}
+		members = map[string]map[string]bool{}
+	)
+
+	get := func(name string) *groupTopic {
+		gt, ok := topics[name]
+		if !ok {
+			gt = &groupTopic{Group: desc.GroupId, Topic: name, State: desc.State, AssignedPartitions: []int32{}, CommittedPartitions: []int32{}}
+			topics[name] = gt
+			members[name] = map[string]bool{}
+		}
+		return gt
+	}
+
+	if desc.ProtocolType == "consumer" {
+		for id, m := range desc.Members {
+			assignment, err := m.GetMemberAssignment()
+			if err != nil || assignment == nil {
+				continue
+			}
+			for name, parts := range assignment.Topics {
+				gt := get(name)
+				gt.AssignedPartitions = append(gt.AssignedPartitions, parts...)
+				members[name][id] = true
+			}
+		}
+	}
+
+	for _, e := range committed {
+		gt := get(e.Topic)
+		gt.CommittedPartitions = append(gt.CommittedPartitions, e.Partition)
+	}
+
+	result := []groupTopic{}
+	for name, gt := range topics {
+		gt.Members = len(members[name])
+		sort.Slice(gt.AssignedPartitions, func(i, j int) bool { return gt.AssignedPartitions[i] < gt.AssignedPartitions[j] })
+		sort.Slice(gt.CommittedPartitions, func(i, j int) bool { return gt.CommittedPartitions[i] < gt.CommittedPartitions[j] })
+		result = append(result, *gt)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Topic < result[j].Topic })
+	return result
+}
+
+func (cmd *groupTopicsCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig(strings.Fields(cmd.name)[0])
+}
+
+func (cmd *groupTopicsCmd) failStartup(msg string) {
+	failUsage(msg, fmt.Sprintf("kt %v", cmd.name))
+}
+
+func (cmd *groupTopicsCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if cmd.name == "group topics" && args.group == "" {
+		cmd.failStartup("group is required.")
+	}
+
+	if cmd.name == "topic groups" {
+		if args.topic == "" {
+			args.topic = os.Getenv("KT_TOPIC")
+		}
+		if args.topic == "" {
+			cmd.failStartup("topic is required.")
+		}
+	}
+
+	cmd.group = args.group
+	cmd.topic = args.topic
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *groupTopicsCmd) parseFlags(as []string) groupTopicsArgs {
+	var args groupTopicsArgs
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	if cmd.name == "group topics" {
+		flags.StringVar(&args.group, "group", "", "Consumer group name (required).")
+		flags.StringVar(&args.topic, "topic", "", "Only list the given topic (defaults to all topics).")
+	} else {
+		flags.StringVar(&args.topic, "topic", "", "Topic to list the consumer groups of (required).")
+	}
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %v:\n", cmd.name)
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, groupTopicsDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var groupTopicsDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt group topics" lists the topics a group consumes, "kt topic groups" the
+groups that consume a topic. A group consumes a topic if it committed offsets
+for the topic's partitions or if partitions of the topic are assigned to its
+members, so groups that stopped consuming show up until their committed
+offsets expire. The latter requires the consumer protocol, which Kafka Connect
+workers for example don't use.
+
+"kt topic groups" reads the committed offsets of all groups in the cluster,
+which takes a while for clusters with many groups.
+
+kt group topics -group specials
+kt topic groups -topic fav-topic`
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestMemberAssignment(topic string, partitions ...int32) []byte {
+	var w kafkaWriter
+	w.int16(0) // version
+	w.int32(1)
+	w.string(topic)
+	w.int32(int32(len(partitions)))
+	for _, p := range partitions {
+		w.int32(p)
+	}
+	w.int32(-1) // user data
+	return w.Bytes()
+}
+
+func TestNewGroupTopics(t *testing.T) {
+	desc := &sarama.GroupDescription{
+		GroupId:      "specials",
+		State:        "Stable",
+		ProtocolType: "consumer",
+		Members: map[string]*sarama.GroupMemberDescription{
+			"m1": {MemberAssignment: encodeTestMemberAssignment("a", 1, 0)},
+			"m2": {MemberAssignment: encodeTestMemberAssignment("a", 2)},
+			"m3": {},
+		},
+	}
+	committed := []groupOffsetsFileEntry{
+		{Topic: "a", Partition: 0, Offset: 10},
+		{Topic: "b", Partition: 3, Offset: 4},
+	}
+
+	expected := []groupTopic{
+		{Group: "specials", Topic: "a", State: "Stable", Members: 2, AssignedPartitions: []int32{0, 1, 2}, CommittedPartitions: []int32{0}},
+		{Group: "specials", Topic: "b", State: "Stable", Members: 0, AssignedPartitions: []int32{}, CommittedPartitions: []int32{3}},
+	}
+	require.Equal(t, expected, newGroupTopics(desc, committed))
+
+	// assignments of other protocols aren't decoded
+	desc.ProtocolType = "connect"
+	actual := newGroupTopics(desc, nil)
+	require.Equal(t, []groupTopic{}, actual)
+}
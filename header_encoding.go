@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// headerEncodings configures the encoding of header values per header key,
+// e.g. "base64,trace-id=string,meta=avro" encodes meta as avro,
+// trace-id as string and any other header as base64.
+type headerEncodings struct {
+	fallback string
+	keys     map[string]string
+}
+
+// parseHeaderEncodings parses a comma separated list of encoding or
+// key=encoding elements. The encoding without key applies to headers that
+// aren't listed and defaults to string.
+func parseHeaderEncodings(s string, supported ...string) (headerEncodings, error) {
+	e := headerEncodings{fallback: "string", keys: map[string]string{}}
+	known := map[string]bool{}
+	for _, enc := range supported {
+		known[enc] = true
+	}
+
+	fallbacks := 0
+	for _, el := range strings.Split(s, ",") {
+		key, enc := "", el
+		if i := strings.LastIndex(el, "="); i >= 0 {
+			key, enc = el[:i], el[i+1:]
+			if key == "" {
+				return e, fmt.Errorf("invalid header encoding %#v, expected key=encoding or encoding", el)
+			}
+		}
+		if !known[enc] {
+			return e, fmt.Errorf("unsupported encoding %#v, only %v are supported", enc, strings.Join(supported, ", "))
+		}
+		if key == "" {
+			fallbacks++
+			e.fallback = enc
+			continue
+		}
+		e.keys[key] = enc
+	}
+	if fallbacks > 1 {
+		return e, fmt.Errorf("only one encoding without header key can be given")
+	}
+	return e, nil
+}
+
+func (e headerEncodings) of(key string) string {
+	if enc, ok := e.keys[key]; ok {
+		return enc
+	}
+	return e.fallback
+}
+
+// uses returns whether any header is encoded as enc.
+func (e headerEncodings) uses(enc string) bool {
+	if e.fallback == enc {
+		return true
+	}
+	for _, v := range e.keys {
+		if v == enc {
+			return true
+		}
+	}
+	return false
+}
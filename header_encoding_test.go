@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeaderEncodings(t *testing.T) {
+	actual, err := parseHeaderEncodings("base64,trace-id=string,meta=avro", "string", "hex", "base64", "avro")
+	require.Nil(t, err)
+	require.Equal(t, headerEncodings{fallback: "base64", keys: map[string]string{"trace-id": "string", "meta": "avro"}}, actual)
+	require.Equal(t, "string", actual.of("trace-id"))
+	require.Equal(t, "base64", actual.of("other"))
+	require.True(t, actual.uses("avro"))
+	require.False(t, actual.uses("hex"))
+
+	actual, err = parseHeaderEncodings("a=b=hex", "string", "hex")
+	require.Nil(t, err)
+	require.Equal(t, "string", actual.fallback)
+	require.Equal(t, "hex", actual.of("a=b"))
+
+	for _, invalid := range []string{"avro", "=hex", "hex,base64", "trace=", ""} {
+		_, err = parseHeaderEncodings(invalid, "string", "hex", "base64")
+		require.NotNil(t, err, invalid)
+	}
+}
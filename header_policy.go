@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// headerPolicy decides which headers of consumed messages are produced again
+// per -keep-headers, -drop-headers and -rename-header, e.g. to strip tracing
+// headers from replayed messages. Keys are matched with glob patterns like
+// path.Match. Like consumeStats, a nil *headerPolicy is valid and keeps all
+// headers as they are.
+type headerPolicy struct {
+	keep    []string
+	drop    []string
+	renames []headerRename
+}
+
+// headerRename renames the header from to to, or with a trailing * in both
+// the headers starting with from's prefix to the same key after to's prefix.
+type headerRename struct {
+	from string
+	to   string
+}
+
+// newHeaderPolicy parses the comma separated patterns of keep and drop and
+// the old=new renames. It returns nil if there's nothing to change.
+func newHeaderPolicy(keep, drop string, renames []string) (*headerPolicy, error) {
+	if keep == "" && drop == "" && len(renames) == 0 {
+		return nil, nil
+	}
+
+	var (
+		p   = &headerPolicy{}
+		err error
+	)
+	if p.keep, err = parseHeaderPatterns(keep); err != nil {
+		return nil, fmt.Errorf("invalid -keep-headers err=%v", err)
+	}
+	if p.drop, err = parseHeaderPatterns(drop); err != nil {
+		return nil, fmt.Errorf("invalid -drop-headers err=%v", err)
+	}
+	for _, s := range renames {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid -rename-header %#v, expected old=new", s)
+		}
+		r := headerRename{from: kv[0], to: kv[1]}
+		if strings.Contains(strings.TrimSuffix(r.from, "*"), "*") || strings.Contains(strings.TrimSuffix(r.to, "*"), "*") ||
+			strings.HasSuffix(r.from, "*") != strings.HasSuffix(r.to, "*") {
+			return nil, fmt.Errorf("invalid -rename-header %#v, only a trailing * on both sides is supported", s)
+		}
+		p.renames = append(p.renames, r)
+	}
+	return p, nil
+}
+
+func parseHeaderPatterns(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ps := strings.Split(s, ",")
+	for _, p := range ps {
+		if _, err := path.Match(p, ""); p == "" || err != nil {
+			return nil, fmt.Errorf("invalid pattern %#v", p)
+		}
+	}
+	return ps, nil
+}
+
+func matchAnyPattern(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the key the header key is produced with, and false if it's
+// dropped. Headers are dropped unless they match -keep-headers, if given, or
+// if they match -drop-headers, before the first matching rename applies.
+func (p *headerPolicy) key(key string) (string, bool) {
+	if p == nil {
+		return key, true
+	}
+	if len(p.keep) > 0 && !matchAnyPattern(p.keep, key) {
+		return "", false
+	}
+	if matchAnyPattern(p.drop, key) {
+		return "", false
+	}
+	for _, r := range p.renames {
+		switch {
+		case r.from == key:
+			return r.to, true
+		case strings.HasSuffix(r.from, "*") && strings.HasPrefix(key, strings.TrimSuffix(r.from, "*")):
+			return strings.TrimSuffix(r.to, "*") + strings.TrimPrefix(key, strings.TrimSuffix(r.from, "*")), true
+		}
+	}
+	return key, true
+}
+
+// apply returns the headers of an input message that are produced.
+func (p *headerPolicy) apply(headers map[string]*string) map[string]*string {
+	if p == nil || headers == nil {
+		return headers
+	}
+	result := map[string]*string{}
+	for k, v := range headers {
+		if nk, ok := p.key(k); ok {
+			result[nk] = v
+		}
+	}
+	return result
+}
+
+// applyRecord returns the headers of a consumed record that are produced,
+// keeping their order.
+func (p *headerPolicy) applyRecord(headers []*sarama.RecordHeader) []sarama.RecordHeader {
+	result := []sarama.RecordHeader{}
+	for _, h := range headers {
+		if k, ok := p.key(string(h.Key)); ok {
+			result = append(result, sarama.RecordHeader{Key: []byte(k), Value: h.Value})
+		}
+	}
+	return result
+}
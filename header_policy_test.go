@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderPolicyKey(t *testing.T) {
+	p, err := newHeaderPolicy("", "traceparent,x-b3-*", []string{"x-internal-*=x-replayed-*", "tenant=customer"})
+	require.Nil(t, err)
+
+	data := []struct {
+		given    string
+		expected string
+		kept     bool
+	}{
+		{given: "traceparent", kept: false},
+		{given: "x-b3-traceid", kept: false},
+		{given: "x-internal-source", expected: "x-replayed-source", kept: true},
+		{given: "tenant", expected: "customer", kept: true},
+		{given: "tenant-id", expected: "tenant-id", kept: true},
+	}
+	for _, d := range data {
+		t.Run(d.given, func(t *testing.T) {
+			actual, kept := p.key(d.given)
+			require.Equal(t, d.kept, kept)
+			require.Equal(t, d.expected, actual)
+		})
+	}
+
+	p, err = newHeaderPolicy("order-*,tenant", "order-debug", nil)
+	require.Nil(t, err)
+	v := "v"
+	require.Equal(t, map[string]*string{"order-id": &v, "tenant": &v}, p.apply(map[string]*string{
+		"order-id":    &v,
+		"order-debug": &v,
+		"tenant":      &v,
+		"traceparent": &v,
+	}))
+
+	var none *headerPolicy
+	k, kept := none.key("traceparent")
+	require.True(t, kept)
+	require.Equal(t, "traceparent", k)
+}
+
+func TestHeaderPolicyApplyRecord(t *testing.T) {
+	p, err := newHeaderPolicy("", "trace*", []string{"a=b"})
+	require.Nil(t, err)
+	actual := p.applyRecord([]*sarama.RecordHeader{
+		{Key: []byte("z"), Value: []byte("1")},
+		{Key: []byte("tracestate"), Value: []byte("2")},
+		{Key: []byte("a"), Value: []byte("3")},
+	})
+	require.Equal(t, []sarama.RecordHeader{
+		{Key: []byte("z"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("3")},
+	}, actual)
+}
+
+func TestNewHeaderPolicy(t *testing.T) {
+	p, err := newHeaderPolicy("", "", nil)
+	require.Nil(t, err)
+	require.Nil(t, p)
+
+	for _, d := range []struct {
+		keep, drop string
+		renames    []string
+	}{
+		{drop: "a,,b"},
+		{keep: "[a"},
+		{renames: []string{"a"}},
+		{renames: []string{"a-*=b"}},
+		{renames: []string{"a*b=c"}},
+	} {
+		_, err := newHeaderPolicy(d.keep, d.drop, d.renames)
+		require.NotNil(t, err, "%+v", d)
+	}
+}
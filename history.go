@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type historyCmd struct {
+	clientConfig
+
+	topic         string
+	key           []byte
+	keyText       string
+	offsets       map[int32]interval
+	partitioner   string
+	encodeValue   string
+	encodeHeaders string
+	timeout       time.Duration
+	verbose       bool
+	pretty        bool
+
+	client   sarama.Client
+	consumer sarama.Consumer
+	src      *consumeCmd // resolves -offsets
+}
+
+type historyArgs struct {
+	clientArgs
+
+	topic         string
+	key           string
+	decodeKey     string
+	offsets       string
+	partitioner   string
+	encodeValue   string
+	encodeHeaders string
+	timeout       time.Duration
+	verbose       bool
+	pretty        bool
+}
+
+// historyEvent is a record with the key in the timeline. SincePreviousMs is
+// the time since the previous event of the timeline, OffsetsSincePrevious
+// the offsets since the previous event of the same partition, as offsets of
+// different partitions don't compare.
+type historyEvent struct {
+	Partition            int32                  `json:"partition"`
+	Offset               int64                  `json:"offset"`
+	Timestamp            time.Time              `json:"timestamp"`
+	SincePreviousMs      *int64                 `json:"sincePreviousMs,omitempty"`
+	OffsetsSincePrevious *int64                 `json:"offsetsSincePrevious,omitempty"`
+	Tombstone            bool                   `json:"tombstone,omitempty"`
+	Value                interface{}            `json:"value"`
+	Headers              map[string]interface{} `json:"headers,omitempty"`
+}
+
+// historySummary follows the timeline, Failed lists partitions that
+// couldn't be read completely.
+type historySummary struct {
+	Topic      string     `json:"topic"`
+	Key        string     `json:"key"`
+	Events     int        `json:"events"`
+	Partitions []int32    `json:"partitions"`
+	First      *time.Time `json:"first,omitempty"`
+	Last       *time.Time `json:"last,omitempty"`
+	SpanMs     int64      `json:"spanMs"`
+	Failed     []int32    `json:"failed,omitempty"`
+}
+
+// historyTimeline orders events by timestamp, then by partition and offset,
+// and fills in the deltas between them.
+func historyTimeline(events []historyEvent) []historyEvent {
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		return a.Offset < b.Offset
+	})
+
+	previousOffsets := map[int32]int64{}
+	for i := range events {
+		e := &events[i]
+		if i > 0 {
+			ms := e.Timestamp.Sub(events[i-1].Timestamp).Milliseconds()
+			e.SincePreviousMs = &ms
+		}
+		if o, ok := previousOffsets[e.Partition]; ok {
+			d := e.Offset - o
+			e.OffsetsSincePrevious = &d
+		}
+		previousOffsets[e.Partition] = e.Offset
+	}
+	return events
+}
+
+func newHistorySummary(topic, key string, events []historyEvent) historySummary {
+	s := historySummary{Topic: topic, Key: key, Events: len(events), Partitions: []int32{}}
+	seen := map[int32]bool{}
+	for _, e := range events {
+		if !seen[e.Partition] {
+			seen[e.Partition] = true
+			s.Partitions = append(s.Partitions, e.Partition)
+		}
+	}
+	sort.Slice(s.Partitions, func(i, j int) bool { return s.Partitions[i] < s.Partitions[j] })
+	if len(events) > 0 {
+		first, last := events[0].Timestamp, events[len(events)-1].Timestamp
+		s.First, s.Last, s.SpanMs = &first, &last, last.Sub(first).Milliseconds()
+	}
+	return s
+}
+
+func (cmd *historyCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	if cmd.offsets == nil {
+		cmd.offsets = cmd.keyPartitionOffsets()
+	}
+	cmd.src = &consumeCmd{
+		topic:    cmd.topic,
+		offsets:  cmd.offsets,
+		untilEnd: true,
+		verbose:  cmd.verbose,
+		client:   cmd.client,
+		consumer: cmd.consumer,
+	}
+
+	partitions := cmd.src.findPartitions(cmd.topic)
+	if len(partitions) == 0 {
+		failf("found no partitions to scan")
+	}
+	tps := []topicPartition{}
+	for _, p := range partitions {
+		tps = append(tps, topicPartition{cmd.topic, p})
+	}
+	if err = cmd.src.captureCurrentOffsets(tps); err != nil {
+		failf("failed to read high water marks err=%v", err)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		events = []historyEvent{}
+		failed = []int32{}
+	)
+	wg.Add(len(partitions))
+	for _, p := range partitions {
+		go func(p int32) {
+			defer wg.Done()
+			es, err := cmd.scanPartition(p)
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, es...)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to scan partition %v of topic %v err=%v\n", p, cmd.topic, err)
+				failed = append(failed, p)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	events = historyTimeline(events)
+	summary := newHistorySummary(cmd.topic, cmd.keyText, events)
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool { return failed[i] < failed[j] })
+		summary.Failed = failed
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	for _, e := range events {
+		ctx := printContext{output: e, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+	ctx := printContext{output: summary, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	if len(failed) > 0 {
+		exitf(exitPartial, "failed to scan partitions %v of topic %v", failed, cmd.topic)
+	}
+}
+
+// keyPartitionOffsets returns the offsets of the whole partition that
+// -partitioner expects the key in.
+func (cmd *historyCmd) keyPartitionOffsets() map[int32]interval {
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.topic, err)
+	}
+	if len(partitions) == 0 {
+		failf("found no partitions for topic %v", cmd.topic)
+	}
+	p := keyPartitioners[cmd.partitioner](cmd.key, int32(len(partitions)))
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "scanning partition %v of topic %v that %v expects the key in\n", p, cmd.topic, cmd.partitioner)
+	}
+	all, _ := parseOffsets("")
+	return map[int32]interval{p: all[-1]}
+}
+
+// scanPartition reads the partition within -offsets and returns the records
+// with the key. It stops early after -timeout without messages, as the
+// newest offsets may be transaction markers.
+func (cmd *historyCmd) scanPartition(partition int32) ([]historyEvent, error) {
+	start, end, ok := cmd.src.partitionRange(cmd.topic, partition)
+	if !ok {
+		return nil, nil
+	}
+
+	pc, err := cmd.consumer.ConsumePartition(cmd.topic, partition, start)
+	if err != nil {
+		return nil, err
+	}
+	defer logClose("partition consumer", pc)
+
+	return cmd.scanMessages(pc, partition, end)
+}
+
+func (cmd *historyCmd) scanMessages(pc sarama.PartitionConsumer, partition int32, end int64) ([]historyEvent, error) {
+	var (
+		events  = []historyEvent{}
+		records = 0
+		timer   = time.NewTimer(cmd.timeout)
+	)
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(cmd.timeout)
+			if msg.Offset > end {
+				return events, nil
+			}
+			records++
+			if msg.Key != nil && bytes.Equal(msg.Key, cmd.key) {
+				events = append(events, cmd.newEvent(msg))
+			}
+			if msg.Offset >= end {
+				return events, nil
+			}
+		case err := <-pc.Errors():
+			return events, err
+		case <-timer.C:
+			if cmd.verbose {
+				fmt.Fprintf(os.Stderr, "timed out scanning partition %v of topic %v after %v records\n", partition, cmd.topic, records)
+			}
+			return events, nil
+		}
+	}
+}
+
+func (cmd *historyCmd) newEvent(msg *sarama.ConsumerMessage) historyEvent {
+	m := newConsumedMessage(msg, "string", cmd.encodeValue, cmd.encodeHeaders)
+	return historyEvent{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Tombstone: msg.Value == nil,
+		Value:     m.Value,
+		Headers:   m.Headers,
+	}
+}
+
+func (cmd *historyCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("history")
+}
+
+func (cmd *historyCmd) failStartup(msg string) {
+	failUsage(msg, "kt history")
+}
+
+func (cmd *historyCmd) parseArgs(as []string) {
+	var err error
+
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.key == "" {
+		cmd.failStartup("Key is required.")
+	}
+	if cmd.key, err = decodeBytes(args.key, args.decodeKey); err != nil {
+		cmd.failStartup(fmt.Sprintf("failed to decode key %#v as %v err=%v", args.key, args.decodeKey, err))
+	}
+	if keyPartitioners[args.partitioner] == nil {
+		cmd.failStartup(fmt.Sprintf("unknown partitioner %#v, available: %v.", args.partitioner, strings.Join(keyPartitionerNames, ", ")))
+	}
+	if args.offsets != "" {
+		if cmd.offsets, err = parseOffsets(args.offsets); err != nil {
+			cmd.failStartup(fmt.Sprintf("%s", err))
+		}
+		for _, i := range cmd.offsets {
+			if i.start.start == offsetResume || i.end.start == offsetResume {
+				cmd.failStartup("resume offsets require a group, which kt history doesn't use.")
+			}
+		}
+	}
+	if err = checkEncoding(args.encodeValue); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid encodevalue argument %#v err=%v", args.encodeValue, err))
+	}
+	if args.encodeHeaders != "string" && args.encodeHeaders != "hex" && args.encodeHeaders != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodeheaders argument %#v, only string, hex and base64 are supported.`, args.encodeHeaders))
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.keyText = args.key
+	cmd.partitioner = args.partitioner
+	cmd.encodeValue = args.encodeValue
+	cmd.encodeHeaders = args.encodeHeaders
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *historyCmd) parseFlags(as []string) historyArgs {
+	var args historyArgs
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Topic to scan (required).")
+	flags.StringVar(&args.key, "key", "", "Key of the records to collect (required).")
+	flags.StringVar(&args.decodeKey, "decodekey", "string", "Decode -key as (string|hex|base64|json|gzip|zstd) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.offsets, "offsets", "", "Specifies what records to scan by partition and offset range like for kt consume (defaults to all of the partition -partitioner expects the key in).")
+	flags.StringVar(&args.partitioner, "partitioner", "murmur2", "Partitioner to find the partition of the key with unless -offsets is given, one of: "+strings.Join(keyPartitionerNames, ", "))
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.encodeValue, "encodevalue", "string", "Present values as (string|hex|base64|json|gzip|zstd) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.encodeHeaders, "encodeheaders", "string", "Present header values as string, hex or base64.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Time to wait for further records of a partition before considering it scanned.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of history:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, historyDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var historyDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+history collects the records with -key and prints them as a timeline, e.g.
+to follow the lifecycle of an entity. Without -offsets, kt reads the whole
+partition that -partitioner expects the key in, murmur2 of the Java client
+by default, up to its newest offset at start. With -offsets, which uses the
+syntax of kt consume, it reads the given ranges of all listed partitions
+instead, e.g. for keys produced with another partitioner or to limit the
+scan to a time range.
+
+Events are ordered by timestamp and carry the milliseconds since the
+previous event and, within a partition, the offsets since the previous event
+of the partition. Tombstones are marked as such. A summary with the number
+of events, their partitions and the time between the first and last one
+follows the timeline.
+
+  $ kt history -topic orders -key order-1234
+  $ kt history -topic orders -key order-1234 -offsets all=-7d: -encodevalue json`
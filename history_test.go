@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryScanMessages(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	messages := make(chan *sarama.ConsumerMessage, 5)
+	messages <- &sarama.ConsumerMessage{Partition: 1, Offset: 3, Key: []byte("Hans"), Value: []byte("created"), Timestamp: ts}
+	messages <- &sarama.ConsumerMessage{Partition: 1, Offset: 4, Key: []byte("Arni"), Value: []byte("created"), Timestamp: ts}
+	messages <- &sarama.ConsumerMessage{Partition: 1, Offset: 5, Value: []byte("no key"), Timestamp: ts}
+	messages <- &sarama.ConsumerMessage{Partition: 1, Offset: 6, Key: []byte("Hans"), Timestamp: ts.Add(time.Second)}
+	messages <- &sarama.ConsumerMessage{Partition: 1, Offset: 7, Key: []byte("Hans"), Value: []byte("after end"), Timestamp: ts}
+
+	target := &historyCmd{key: []byte("Hans"), encodeValue: "string", encodeHeaders: "string", timeout: time.Second}
+	events, err := target.scanMessages(tPartitionConsumer{messages: messages}, 1, 6)
+	require.NoError(t, err)
+
+	value := "created"
+	require.Equal(t, []historyEvent{
+		{Partition: 1, Offset: 3, Timestamp: ts, Value: &value},
+		{Partition: 1, Offset: 6, Timestamp: ts.Add(time.Second), Tombstone: true},
+	}, events)
+}
+
+func TestHistoryTimeline(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	events := historyTimeline([]historyEvent{
+		{Partition: 0, Offset: 10, Timestamp: ts.Add(2 * time.Second)},
+		{Partition: 1, Offset: 5, Timestamp: ts.Add(time.Second)},
+		{Partition: 0, Offset: 4, Timestamp: ts},
+		{Partition: 0, Offset: 12, Timestamp: ts.Add(2 * time.Second)},
+	})
+
+	ms := func(v int64) *int64 { return &v }
+	require.Equal(t, []historyEvent{
+		{Partition: 0, Offset: 4, Timestamp: ts},
+		{Partition: 1, Offset: 5, Timestamp: ts.Add(time.Second), SincePreviousMs: ms(1000)},
+		{Partition: 0, Offset: 10, Timestamp: ts.Add(2 * time.Second), SincePreviousMs: ms(1000), OffsetsSincePrevious: ms(6)},
+		{Partition: 0, Offset: 12, Timestamp: ts.Add(2 * time.Second), SincePreviousMs: ms(0), OffsetsSincePrevious: ms(2)},
+	}, events)
+
+	summary := newHistorySummary("orders", "Hans", events)
+	require.Equal(t, 4, summary.Events)
+	require.Equal(t, []int32{0, 1}, summary.Partitions)
+	require.Equal(t, int64(2000), summary.SpanMs)
+	require.Equal(t, ts, *summary.First)
+
+	empty := newHistorySummary("orders", "Hans", historyTimeline([]historyEvent{}))
+	require.Equal(t, historySummary{Topic: "orders", Key: "Hans", Partitions: []int32{}}, empty)
+}
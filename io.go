@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type ioCmd struct {
+	clientConfig
+
+	timeout time.Duration
+	verbose bool
+
+	client   sarama.Client
+	consumer sarama.Consumer
+	producer sarama.SyncProducer
+	next     map[string]int32 // round robin partition per topic for records without key
+}
+
+type ioArgs struct {
+	clientArgs
+
+	timeout time.Duration
+	verbose bool
+}
+
+// ioRequest is a line of kt io's stdin. Id is passed back as it is with
+// every response to the request.
+type ioRequest struct {
+	ID            json.RawMessage    `json:"id"`
+	Op            string             `json:"op"`
+	Topic         string             `json:"topic"`
+	Offsets       string             `json:"offsets"`
+	Limit         int                `json:"limit"`
+	Partition     *int32             `json:"partition"`
+	Key           *string            `json:"key"`
+	Value         *string            `json:"value"`
+	Headers       map[string]*string `json:"headers"`
+	KeyEncoding   string             `json:"keyEncoding"`
+	ValueEncoding string             `json:"valueEncoding"`
+}
+
+// ioResponse is a line of kt io's stdout. Type is record for each record
+// of a consume request followed by done, produced, offsets or error.
+type ioResponse struct {
+	ID         json.RawMessage    `json:"id,omitempty"`
+	Type       string             `json:"type"`
+	Record     *consumedMessage   `json:"record,omitempty"`
+	Records    *int               `json:"records,omitempty"`
+	Partition  *int32             `json:"partition,omitempty"`
+	Offset     *int64             `json:"offset,omitempty"`
+	Partitions []ioPartitionRange `json:"partitions,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// ioPartitionRange is the offsets of a partition's first and last record
+// that an offsets request resolves to.
+type ioPartitionRange struct {
+	Partition int32 `json:"partition"`
+	Start     int64 `json:"start"`
+	End       int64 `json:"end"`
+}
+
+var errIOLimit = errors.New("limit reached")
+
+// parseIORequest reads and checks a request line, encodings default to
+// string.
+func parseIORequest(line string) (ioRequest, error) {
+	var req ioRequest
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		return req, fmt.Errorf("invalid request err=%v", err)
+	}
+	if req.KeyEncoding == "" {
+		req.KeyEncoding = "string"
+	}
+	if req.ValueEncoding == "" {
+		req.ValueEncoding = "string"
+	}
+
+	switch req.Op {
+	case "consume", "offsets", "produce":
+	case "":
+		return req, fmt.Errorf("request without op")
+	default:
+		return req, fmt.Errorf("unsupported op %#v, only consume, produce and offsets are supported", req.Op)
+	}
+	if req.Topic == "" {
+		return req, fmt.Errorf("request without topic")
+	}
+	if req.Limit < 0 {
+		return req, fmt.Errorf("invalid limit %v", req.Limit)
+	}
+	if req.Op == "consume" {
+		for _, enc := range []string{req.KeyEncoding, req.ValueEncoding} {
+			switch enc {
+			case "string", "hex", "base64":
+			default:
+				return req, fmt.Errorf(`unsupported encoding %#v, only "string", "hex" and "base64" are supported`, enc)
+			}
+		}
+	}
+	return req, nil
+}
+
+// message returns the record to produce for a produce request.
+func (req ioRequest) message() (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{Topic: req.Topic}
+	if req.Key != nil {
+		key, err := decodeBytes(*req.Key, req.KeyEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key err=%v", err)
+		}
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	if req.Value != nil {
+		value, err := decodeBytes(*req.Value, req.ValueEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode value err=%v", err)
+		}
+		msg.Value = sarama.ByteEncoder(value)
+	}
+	for k, v := range req.Headers {
+		h := sarama.RecordHeader{Key: []byte(k)}
+		if v != nil {
+			h.Value = []byte(*v)
+		}
+		msg.Headers = append(msg.Headers, h)
+	}
+	return msg, nil
+}
+
+func (cmd *ioCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	if cmd.producer, err = sarama.NewSyncProducerFromClient(cmd.client); err != nil {
+		failf("failed to create producer err=%v", err)
+	}
+	defer logClose("producer", cmd.producer)
+	cmd.next = map[string]int32{}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		req, err := parseIORequest(line)
+		if err == nil {
+			err = cmd.handle(req)
+		}
+		if err != nil {
+			cmd.respond(ioResponse{ID: req.ID, Type: "error", Error: err.Error()}, true)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		failf("failed to read requests err=%v", err)
+	}
+}
+
+func (cmd *ioCmd) handle(req ioRequest) error {
+	switch req.Op {
+	case "offsets":
+		ranges, err := cmd.resolveRanges(req.Topic, req.Offsets)
+		if err != nil {
+			return err
+		}
+		cmd.respond(ioResponse{ID: req.ID, Type: "offsets", Partitions: ranges}, true)
+		return nil
+	case "consume":
+		return cmd.consume(req)
+	default:
+		return cmd.produce(req)
+	}
+}
+
+// resolveRanges resolves offsets like kt consume -offsets up to the current
+// end of the partitions. Partitions without records in the range are left
+// out.
+func (cmd *ioCmd) resolveRanges(topic, offsets string) ([]ioPartitionRange, error) {
+	intervals, err := parseOffsets(offsets)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range intervals {
+		if i.start.start == offsetResume || i.end.start == offsetResume || i.start.start == offsetFile || i.end.start == offsetFile {
+			return nil, fmt.Errorf("resume offsets require a group or checkpoint file, which kt io doesn't use")
+		}
+	}
+
+	all, err := cmd.client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions err=%v", err)
+	}
+	partitions := []int32{}
+	for _, p := range all {
+		_, ok := intervals[p]
+		if _, hasDefault := intervals[-1]; ok || hasDefault {
+			partitions = append(partitions, p)
+		}
+	}
+
+	src := &consumeCmd{
+		topic:    topic,
+		offsets:  intervals,
+		untilEnd: true,
+		verbose:  cmd.verbose,
+		client:   cmd.client,
+		consumer: cmd.consumer,
+	}
+	tps := []topicPartition{}
+	for _, p := range partitions {
+		tps = append(tps, topicPartition{topic, p})
+	}
+	if err = src.captureCurrentOffsets(tps); err != nil {
+		return nil, fmt.Errorf("failed to read high water marks err=%v", err)
+	}
+
+	ranges := []ioPartitionRange{}
+	for _, p := range partitions {
+		start, end, ok := src.partitionRange(topic, p)
+		if len(src.failed) > 0 {
+			return nil, fmt.Errorf("failed to resolve offsets of partition %v", p)
+		}
+		if ok {
+			ranges = append(ranges, ioPartitionRange{Partition: p, Start: start, End: end})
+		}
+	}
+	return ranges, nil
+}
+
+// consume responds with the records within the request's offsets, up to
+// its limit, and done with their number.
+func (cmd *ioCmd) consume(req ioRequest) error {
+	ranges, err := cmd.resolveRanges(req.Topic, req.Offsets)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	add := func(msg *sarama.ConsumerMessage) error {
+		m := newConsumedMessage(msg, req.KeyEncoding, req.ValueEncoding, "string")
+		m.Topic = msg.Topic
+		cmd.respond(ioResponse{ID: req.ID, Type: "record", Record: &m}, false)
+		if count++; req.Limit > 0 && count >= req.Limit {
+			return errIOLimit
+		}
+		return nil
+	}
+	for _, r := range ranges {
+		err = consumePassRange(cmd.consumer, req.Topic, passRange{Partition: r.Partition, Start: r.Start, End: r.End + 1}, cmd.timeout, add)
+		if err == errIOLimit {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %v after %v records err=%v", r.Partition, count, err)
+		}
+	}
+	cmd.respond(ioResponse{ID: req.ID, Type: "done", Records: &count}, true)
+	return nil
+}
+
+// produce sends the request's record to its partition, the partition of
+// its key like the Java client's default partitioner or round robin for
+// records without key.
+func (cmd *ioCmd) produce(req ioRequest) error {
+	msg, err := req.message()
+	if err != nil {
+		return err
+	}
+
+	partitions, err := cmd.client.Partitions(req.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to read partitions err=%v", err)
+	}
+	count := int32(len(partitions))
+	switch {
+	case count == 0:
+		return fmt.Errorf("topic %v has no partitions", req.Topic)
+	case req.Partition != nil:
+		if *req.Partition < 0 || *req.Partition >= count {
+			return fmt.Errorf("invalid partition %v, topic %v has %v partitions", *req.Partition, req.Topic, count)
+		}
+		msg.Partition = *req.Partition
+	case msg.Key != nil:
+		key, _ := msg.Key.Encode()
+		msg.Partition = keyPartitioners["murmur2"](key, count)
+	default:
+		msg.Partition = cmd.next[req.Topic] % count
+		cmd.next[req.Topic] = msg.Partition + 1
+	}
+
+	partition, offset, err := cmd.producer.SendMessage(msg)
+	if err != nil {
+		return fmt.Errorf("failed to produce err=%v", err)
+	}
+	cmd.respond(ioResponse{ID: req.ID, Type: "produced", Partition: &partition, Offset: &offset}, true)
+	return nil
+}
+
+// respond writes a response line, flushing it unless more lines of the
+// same request follow.
+func (cmd *ioCmd) respond(r ioResponse, flush bool) {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		failf("failed to marshal response %#v, err=%v", r, err)
+	}
+	if _, err = stdout.Write(append(buf, '\n')); err != nil {
+		failf("failed to write output err=%v", err)
+	}
+	if flush {
+		if err = stdout.Flush(); err != nil {
+			failf("failed to write output err=%v", err)
+		}
+	}
+}
+
+func (cmd *ioCmd) saramaConfig() *sarama.Config {
+	cfg := cmd.clientConfig.saramaConfig("io")
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+
+	return cfg
+}
+
+func (cmd *ioCmd) failStartup(msg string) {
+	failUsage(msg, "kt io")
+}
+
+func (cmd *ioCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid -timeout %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+}
+
+func (cmd *ioCmd) parseFlags(as []string) ioArgs {
+	var args ioArgs
+	flags := flag.NewFlagSet("io", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Stop consuming a partition of a consume request after this long without records.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of io:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, ioDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = true
+	return args
+}
+
+var ioDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt io" reads requests as JSON objects, one per line, from stdin and writes
+responses as JSON objects, one per line, to stdout until stdin is closed.
+Programs in other languages can start it once and send it many requests,
+without starting kt and fetching metadata for every call. Requests are
+handled in order, each response has the id of its request:
+
+  {"id": 1, "op": "offsets", "topic": "orders", "offsets": "all=newest-9:"}
+  {"id": 1, "type": "offsets", "partitions": [{"partition": 0, "start": 90, "end": 99}]}
+
+  {"id": 2, "op": "consume", "topic": "orders", "offsets": "0=90:", "limit": 2}
+  {"id": 2, "type": "record", "record": {"topic": "orders", "partition": 0, "offset": 90, ...}}
+  {"id": 2, "type": "record", "record": {"topic": "orders", "partition": 0, "offset": 91, ...}}
+  {"id": 2, "type": "done", "records": 2}
+
+  {"id": 3, "op": "produce", "topic": "orders", "key": "a", "value": "b", "headers": {"h": "v"}}
+  {"id": 3, "type": "produced", "partition": 0, "offset": 100}
+
+offsets takes the syntax of kt consume -offsets and defaults to all
+records, it's resolved up to the current end of the partitions. An offsets
+request returns the first and last offset per partition, leaving out
+partitions without records in the range. A consume request responds with a
+record per record in the range like kt consume prints them, stopping after
+limit records if it's set, and done with the number of records. keyEncoding
+and valueEncoding are the encodings of printed keys and values for consume
+(string, hex or base64) and of the passed key and value for produce, like kt
+produce's -decodekey and -decodevalue. Records without partition are
+produced to the partition of their key like the Java client does, or round
+robin without key.
+
+A failed request responds with type error and the error, kt io continues
+with the next request. Errors that stop kt io, e.g. failing to connect, are
+printed to stderr as JSON.`
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIORequest(t *testing.T) {
+	data := []struct {
+		testName string
+		line     string
+		expected ioRequest
+		err      string
+	}{
+		{
+			testName: "consume",
+			line:     `{"id": "a", "op": "consume", "topic": "orders", "offsets": "0=5:", "limit": 2, "valueEncoding": "hex"}`,
+			expected: ioRequest{ID: json.RawMessage(`"a"`), Op: "consume", Topic: "orders", Offsets: "0=5:", Limit: 2, KeyEncoding: "string", ValueEncoding: "hex"},
+		},
+		{
+			testName: "invalid-json",
+			line:     `{"op": `,
+			err:      "invalid request",
+		},
+		{
+			testName: "unknown-op",
+			line:     `{"id": 1, "op": "delete", "topic": "orders"}`,
+			err:      `unsupported op "delete"`,
+		},
+		{
+			testName: "missing-topic",
+			line:     `{"id": 1, "op": "offsets"}`,
+			err:      "request without topic",
+		},
+		{
+			testName: "consume-encoding",
+			line:     `{"id": 1, "op": "consume", "topic": "orders", "keyEncoding": "gzip"}`,
+			err:      `unsupported encoding "gzip"`,
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.testName, func(t *testing.T) {
+			actual, err := parseIORequest(d.line)
+			if d.err != "" {
+				require.NotNil(t, err)
+				require.Contains(t, err.Error(), d.err)
+				return
+			}
+			require.Nil(t, err)
+			require.Equal(t, d.expected, actual)
+		})
+	}
+}
+
+func TestIORequestMessage(t *testing.T) {
+	req, err := parseIORequest(`{"op": "produce", "topic": "orders", "key": "6869", "keyEncoding": "hex", "headers": {"h": "v"}}`)
+	require.Nil(t, err)
+	msg, err := req.message()
+	require.Nil(t, err)
+	require.Equal(t, &sarama.ProducerMessage{
+		Topic:   "orders",
+		Key:     sarama.ByteEncoder("hi"),
+		Headers: []sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}},
+	}, msg)
+
+	invalid := "not base64!"
+	req.Value = &invalid
+	req.ValueEncoding = "base64"
+	_, err = req.message()
+	require.NotNil(t, err)
+}
+
+func TestIOResponse(t *testing.T) {
+	records := 2
+	buf, err := json.Marshal(ioResponse{ID: json.RawMessage(`7`), Type: "done", Records: &records})
+	require.Nil(t, err)
+	require.Equal(t, `{"id":7,"type":"done","records":2}`, string(buf))
+}
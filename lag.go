@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+type lagCmd struct {
+	clientConfig
+
+	group    string
+	topic    string
+	interval time.Duration
+	count    int
+	table    bool
+	maxAge   time.Duration
+	daemon   daemonArgs
+	verbose  bool
+	pretty   bool
+
+	metricsAddr string
+	cluster     string
+	exporter    *lagExporter
+
+	connArgs clientArgs
+	reloads  chan struct{}
+	client   sarama.Client
+	metadata *metadataCache
+}
+
+type lagArgs struct {
+	clientArgs
+
+	group    string
+	topic    string
+	interval time.Duration
+	count    int
+	table    bool
+	maxAge   time.Duration
+	daemon   daemonArgs
+	verbose  bool
+	pretty   bool
+
+	metricsAddr string
+	cluster     string
+}
+
+// partitionLag is the lag of the group on a partition at the time of a
+// poll. Offset and Lag are nil while the group hasn't committed an offset
+// for the partition.
+type partitionLag struct {
+	Time          time.Time `json:"time"`
+	Group         string    `json:"group"`
+	Topic         string    `json:"topic"`
+	Partition     int32     `json:"partition"`
+	HighWaterMark int64     `json:"highWaterMark"`
+	Offset        *int64    `json:"offset"`
+	Lag           *int64    `json:"lag"`
+}
+
+func (cmd *lagCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	cmd.reloads = make(chan struct{}, 1)
+	d, err := startDaemon(cmd.daemon, cmd.verbose, cmd.reload)
+	if err != nil {
+		failf("%v", err)
+	}
+	defer d.stop()
+
+	if cmd.client, err = cmd.connect(cmd.clientConfig); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer func() { logClose("client", cmd.client) }()
+	cmd.metadata = newMetadataCache(cmd.client.RefreshMetadata, cmd.maxAge)
+	if cmd.metricsAddr != "" {
+		cmd.exporter = newLagExporter(cmd.cluster)
+		cmd.exporter.serve(cmd.metricsAddr, cmd.group)
+	}
+	d.ready()
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	q := make(chan struct{})
+	go listenForInterrupt(q)
+
+	for i := 0; cmd.count == 0 || i < cmd.count; i++ {
+		if i > 0 && !cmd.wait(q) {
+			return
+		}
+
+		lags, err := cmd.poll()
+		if err != nil {
+			// brokers may be unavailable for a moment, keep watching
+			// with fresh metadata as leaders may have moved.
+			fmt.Fprintf(os.Stderr, "failed to read lag err=%v\n", err)
+			cmd.metadata.invalidate()
+			continue
+		}
+		cmd.exporter.update(lags)
+
+		if cmd.table {
+			cmd.printTable(lags)
+			continue
+		}
+
+		for _, l := range lags {
+			ctx := printContext{output: l, done: make(chan struct{})}
+			out <- ctx
+			<-ctx.done
+		}
+	}
+}
+
+// wait waits for the next poll and reconnects if kt gets SIGHUP meanwhile.
+// It returns false when kt is interrupted.
+func (cmd *lagCmd) wait(q chan struct{}) bool {
+	next := time.After(cmd.interval)
+	for {
+		select {
+		case <-q:
+			return false
+		case <-cmd.reloads:
+			cmd.reconnect()
+		case <-next:
+			return true
+		}
+	}
+}
+
+// reload asks the poll loop to reconnect, it's called on SIGHUP.
+func (cmd *lagCmd) reload() {
+	select {
+	case cmd.reloads <- struct{}{}:
+	default:
+	}
+}
+
+// reconnect reads the cluster profile from the config file again and swaps
+// the client for one with the new settings, e.g. moved brokers or rotated
+// certificates. It keeps the current client if that fails.
+func (cmd *lagCmd) reconnect() {
+	c, err := cmd.connArgs.reload()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reload config err=%v\n", err)
+		return
+	}
+	client, err := cmd.connect(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reconnect, keeping the current connection err=%v\n", err)
+		return
+	}
+
+	logClose("client", cmd.client)
+	cmd.clientConfig = c
+	cmd.client = client
+	cmd.metadata = newMetadataCache(client.RefreshMetadata, cmd.maxAge)
+}
+
+// poll reads the high water marks and the group's committed offsets of all
+// partitions of the topic. The committed offsets are read in a single
+// request from the group's coordinator.
+func (cmd *lagCmd) poll() ([]partitionLag, error) {
+	if err := cmd.metadata.refresh(cmd.topic); err != nil {
+		return nil, err
+	}
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	committed, err := fetchCommittedOffsets(cmd.client, cmd.group, cmd.topic, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	lags := []partitionLag{}
+	for _, p := range partitions {
+		hwm, err := cmd.client.GetOffset(cmd.topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read high water mark of partition %v err=%v", p, err)
+		}
+		lags = append(lags, newPartitionLag(now, cmd.group, cmd.topic, p, hwm, committed[p]))
+	}
+	return lags, nil
+}
+
+func newPartitionLag(t time.Time, group, topic string, partition int32, hwm, committed int64) partitionLag {
+	l := partitionLag{Time: t, Group: group, Topic: topic, Partition: partition, HighWaterMark: hwm}
+	if committed < 0 {
+		return l
+	}
+
+	lag := hwm - committed
+	if lag < 0 {
+		// the high water mark was read after the committed offset.
+		lag = 0
+	}
+	l.Offset = &committed
+	l.Lag = &lag
+	return l
+}
+
+// fetchCommittedOffsets reads the offsets group committed for the given
+// partitions of topic, partitions without commit map to a negative offset.
+func fetchCommittedOffsets(client sarama.Client, group, topic string, partitions []int32) (map[int32]int64, error) {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find coordinator for group %#v err=%v", group, err)
+	}
+
+	req := &sarama.OffsetFetchRequest{ConsumerGroup: group, Version: 1}
+	for _, p := range partitions {
+		req.AddPartition(topic, p)
+	}
+
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets for group %#v err=%v", group, err)
+	}
+
+	offsets := map[int32]int64{}
+	for _, p := range partitions {
+		block := resp.GetBlock(topic, p)
+		if block == nil {
+			return nil, fmt.Errorf("missing offset of partition %v for group %#v in coordinator response", p, group)
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to fetch offset of partition %v for group %#v err=%v", p, group, block.Err)
+		}
+		offsets[p] = block.Offset
+	}
+	return offsets, nil
+}
+
+// formatLagTable renders the lags as a table with a total row.
+func formatLagTable(lags []partitionLag) string {
+	var (
+		buf   bytes.Buffer
+		total int64
+	)
+
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "PARTITION\tHIGH WATER MARK\tOFFSET\tLAG\t")
+	for _, l := range lags {
+		offset, lag := "-", "-"
+		if l.Offset != nil {
+			offset, lag = fmt.Sprint(*l.Offset), fmt.Sprint(*l.Lag)
+			total += *l.Lag
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t\n", l.Partition, l.HighWaterMark, offset, lag)
+	}
+	fmt.Fprintf(w, "total\t\t\t%v\t\n", total)
+	w.Flush()
+
+	return buf.String()
+}
+
+// printTable prints the lags as a table. On a terminal it replaces the
+// previous poll's table, otherwise tables are separated by an empty line.
+func (cmd *lagCmd) printTable(lags []partitionLag) {
+	header := fmt.Sprintf("group %v on topic %v at %v\n\n", cmd.group, cmd.topic, time.Now().Format(time.RFC3339))
+	if terminal.IsTerminal(int(syscall.Stdout)) {
+		fmt.Fprint(stdout, "\033[H\033[2J"+header+formatLagTable(lags))
+	} else {
+		fmt.Fprint(stdout, header+formatLagTable(lags)+"\n")
+	}
+	stdout.Flush()
+}
+
+// connect creates a client with the connection settings of c.
+func (cmd *lagCmd) connect(c clientConfig) (sarama.Client, error) {
+	cfg, err := c.newSaramaConfig("lag")
+	if err != nil {
+		return nil, err
+	}
+	if cmd.maxAge > 0 {
+		cfg.Metadata.RefreshFrequency = cmd.maxAge
+	}
+
+	return sarama.NewClient(c.brokers, cfg)
+}
+
+func (cmd *lagCmd) failStartup(msg string) {
+	failUsage(msg, "kt lag")
+}
+
+func (cmd *lagCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+
+	if args.group == "" || args.topic == "" {
+		cmd.failStartup("group and topic are required.")
+	}
+
+	if args.interval <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid interval %v, expected a positive duration.", args.interval))
+	}
+
+	if args.count < 0 {
+		cmd.failStartup(fmt.Sprintf("invalid count %v, expected 0 to watch until interrupted or a positive number of polls.", args.count))
+	}
+	if args.maxAge < 0 {
+		cmd.failStartup("-metadata-max-age cannot be negative.")
+	}
+	cmd.maxAge = args.maxAge
+
+	cmd.group = args.group
+	cmd.topic = args.topic
+	cmd.interval = args.interval
+	cmd.count = args.count
+	cmd.table = args.table
+	cmd.metricsAddr = args.metricsAddr
+	cmd.cluster = args.cluster
+	cmd.daemon = args.daemon
+	cmd.connArgs = args.clientArgs
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *lagCmd) parseFlags(as []string) lagArgs {
+	var args lagArgs
+	flags := flag.NewFlagSet("lag", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.group, "group", "", "Consumer group name (required).")
+	flags.StringVar(&args.topic, "topic", "", "Topic the group consumes (required).")
+	flags.DurationVar(&args.interval, "interval", 5*time.Second, "Time to wait between polls.")
+	flags.IntVar(&args.count, "count", 0, "Number of polls before exiting (defaults to 0 to watch until interrupted).")
+	flags.DurationVar(&args.maxAge, "metadata-max-age", 0, "Reuse the topic's metadata for up to the given time between polls (defaults to 0 to refresh it every poll).")
+	flags.BoolVar(&args.table, "table", false, "Print a table per poll instead of JSON lines, refreshed in place on a terminal.")
+	flags.StringVar(&args.metricsAddr, "metricsaddr", "", "Address to expose the lag on in the formats of kafka_exporter, kafka-lag-exporter and Burrow, e.g. localhost:9100 (defaults to disabled).")
+	flags.StringVar(&args.cluster, "cluster-name", "local", "Cluster name for the cluster_name label and Burrow's URLs with -metricsaddr.")
+	flags.StringVar(&args.daemon.pidFile, "pid-file", "", "Path to write the process ID to while running (defaults to none).")
+	flags.StringVar(&args.daemon.logFile, "log-file", "", "Path to append log output to instead of stderr, reopened on SIGHUP (defaults to stderr).")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of lag:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, lagDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var lagDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+lag polls the high water marks of the topic's partitions and the offsets the
+group committed for them every -interval and prints the lag per partition,
+i.e. the number of messages the group has yet to process. Partitions the
+group hasn't committed an offset for have a null offset and lag. Use -count
+to stop after a number of polls and -table to watch the lag in a table
+instead of reading JSON.
+
+Each poll refreshes the topic's metadata. To spare busy clusters when
+polling often, -metadata-max-age reuses it for that long and sets how often
+kt refreshes it in the background. A failed poll refreshes it on the next
+one regardless, e.g. after a leader moved.
+
+To stand in for an existing lag exporter, -metricsaddr serves the latest
+poll over HTTP in their formats:
+
+  /metrics                                   Prometheus series of kafka_exporter
+                                             (kafka_consumergroup_lag, ...) and
+                                             kafka-lag-exporter (kafka_consumergroup_group_lag, ...)
+  /v3/kafka/<cluster>/consumer/<group>/lag   Burrow's consumer lag
+  /v3/kafka/<cluster>/consumer/<group>/status
+
+<cluster> is -cluster-name, which is also the cluster_name label. Burrow's
+partition status compares the latest two polls rather than a window of
+commits: REWIND if the offset went back, STALL if it didn't move despite lag
+and WARN if the lag grew, the group is ERR for REWIND or STALL partitions.
+
+To run lag as a service, -pid-file writes kt's process ID to the given file
+and -log-file appends log output, which goes to stderr otherwise, to a file
+that's reopened on SIGHUP, so logrotate can rotate it. When started by systemd
+as a Type=notify service, kt reports readiness once it's connected to the
+cluster. SIGHUP also reads the -cluster profile from the config file again
+and lag reconnects with it before the next poll, e.g. to pick up moved
+brokers or renewed certificates. Settings passed as flags stay as they are.
+
+kt lag -group specials -topic fav-topic -interval 10s`
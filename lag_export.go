@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// lagExporter exposes the latest poll of kt lag in the formats of existing
+// lag exporters, so dashboards and alerts built for them keep working while
+// kt stands in: Prometheus series of kafka_exporter and kafka-lag-exporter
+// under /metrics and Burrow's HTTP API under /v3/kafka.
+type lagExporter struct {
+	sync.Mutex
+	cluster  string
+	metrics  *metricsRegistry
+	previous []partitionLag
+	latest   []partitionLag
+}
+
+func newLagExporter(cluster string) *lagExporter {
+	e := &lagExporter{cluster: cluster, metrics: newMetricsRegistry()}
+	// kafka_exporter
+	e.metrics.register("kafka_consumergroup_current_offset", gaugeMetric, "Current offset of a consumer group at a topic partition.")
+	e.metrics.register("kafka_consumergroup_lag", gaugeMetric, "Current approximate lag of a consumer group at a topic partition.")
+	e.metrics.register("kafka_consumergroup_lag_sum", gaugeMetric, "Current approximate lag of a consumer group at a topic for all partitions.")
+	e.metrics.register("kafka_topic_partition_current_offset", gaugeMetric, "Current offset of a broker at a topic partition.")
+	// kafka-lag-exporter
+	e.metrics.register("kafka_consumergroup_group_offset", gaugeMetric, "Last group consumed offset of a partition.")
+	e.metrics.register("kafka_consumergroup_group_lag", gaugeMetric, "Group offset lag of a partition.")
+	e.metrics.register("kafka_consumergroup_group_max_lag", gaugeMetric, "Max group offset lag.")
+	e.metrics.register("kafka_consumergroup_group_sum_lag", gaugeMetric, "Sum of group offset lag.")
+	e.metrics.register("kafka_partition_latest_offset", gaugeMetric, "Latest offset of a partition.")
+	return e
+}
+
+// update records a poll. Partitions without committed offset only have the
+// series of their high water mark, like with the exporters.
+func (e *lagExporter) update(lags []partitionLag) {
+	if e == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.previous, e.latest = e.latest, lags
+
+	sums := map[string]int64{}
+	var max, sum int64
+	for _, l := range lags {
+		p := strconv.Itoa(int(l.Partition))
+		e.metrics.set("kafka_topic_partition_current_offset", map[string]string{"topic": l.Topic, "partition": p}, float64(l.HighWaterMark))
+		e.metrics.set("kafka_partition_latest_offset", map[string]string{"cluster_name": e.cluster, "topic": l.Topic, "partition": p}, float64(l.HighWaterMark))
+		if l.Lag == nil {
+			continue
+		}
+
+		group := map[string]string{"consumergroup": l.Group, "topic": l.Topic, "partition": p}
+		e.metrics.set("kafka_consumergroup_current_offset", group, float64(*l.Offset))
+		e.metrics.set("kafka_consumergroup_lag", group, float64(*l.Lag))
+		group = map[string]string{"cluster_name": e.cluster, "group": l.Group, "topic": l.Topic, "partition": p, "member_host": "", "consumer_id": "", "client_id": ""}
+		e.metrics.set("kafka_consumergroup_group_offset", group, float64(*l.Offset))
+		e.metrics.set("kafka_consumergroup_group_lag", group, float64(*l.Lag))
+
+		sums[l.Topic] += *l.Lag
+		sum += *l.Lag
+		if *l.Lag > max {
+			max = *l.Lag
+		}
+	}
+	for topic, s := range sums {
+		e.metrics.set("kafka_consumergroup_lag_sum", map[string]string{"consumergroup": lags[0].Group, "topic": topic}, float64(s))
+	}
+	if len(lags) > 0 {
+		group := map[string]string{"cluster_name": e.cluster, "group": lags[0].Group}
+		e.metrics.set("kafka_consumergroup_group_max_lag", group, float64(max))
+		e.metrics.set("kafka_consumergroup_group_sum_lag", group, float64(sum))
+	}
+}
+
+type burrowOffset struct {
+	Offset     int64 `json:"offset"`
+	Timestamp  int64 `json:"timestamp"`
+	ObservedAt int64 `json:"observedAt"`
+	Lag        int64 `json:"lag"`
+}
+
+type burrowPartition struct {
+	Topic      string        `json:"topic"`
+	Partition  int32         `json:"partition"`
+	Owner      string        `json:"owner"`
+	ClientID   string        `json:"client_id"`
+	Status     string        `json:"status"`
+	Start      *burrowOffset `json:"start"`
+	End        *burrowOffset `json:"end"`
+	CurrentLag int64         `json:"current_lag"`
+	Complete   float64       `json:"complete"`
+}
+
+type burrowGroupStatus struct {
+	Cluster        string             `json:"cluster"`
+	Group          string             `json:"group"`
+	Status         string             `json:"status"`
+	Complete       float64            `json:"complete"`
+	Partitions     []*burrowPartition `json:"partitions"`
+	PartitionCount int                `json:"partition_count"`
+	MaxLag         *burrowPartition   `json:"maxlag"`
+	TotalLag       int64              `json:"totallag"`
+}
+
+type burrowRequest struct {
+	URL  string `json:"url"`
+	Host string `json:"host"`
+}
+
+type burrowResponse struct {
+	Error   bool               `json:"error"`
+	Message string             `json:"message"`
+	Status  *burrowGroupStatus `json:"status,omitempty"`
+	Request burrowRequest      `json:"request"`
+}
+
+func newBurrowOffset(l partitionLag) *burrowOffset {
+	ms := l.Time.UnixNano() / 1e6
+	return &burrowOffset{Offset: *l.Offset, Timestamp: ms, ObservedAt: ms, Lag: *l.Lag}
+}
+
+// burrowPartitionStatus evaluates a partition like Burrow, though only
+// between the previous and the latest poll rather than over a window:
+// REWIND when the offset went back, STALL when it didn't move despite lag
+// and WARN when the lag grew.
+func burrowPartitionStatus(start, end *burrowOffset) string {
+	switch {
+	case end.Offset < start.Offset:
+		return "REWIND"
+	case end.Offset == start.Offset && end.Lag > 0:
+		return "STALL"
+	case end.Lag > start.Lag:
+		return "WARN"
+	}
+	return "OK"
+}
+
+// burrowStatus builds the group's status from two consecutive polls.
+// Partitions without committed offset are omitted, Burrow doesn't know them.
+func burrowStatus(cluster, group string, previous, latest []partitionLag) *burrowGroupStatus {
+	starts := map[string]*burrowOffset{}
+	for _, l := range previous {
+		if l.Lag != nil {
+			starts[l.Topic+"/"+strconv.Itoa(int(l.Partition))] = newBurrowOffset(l)
+		}
+	}
+
+	s := &burrowGroupStatus{Cluster: cluster, Group: group, Status: "OK", Complete: 1, Partitions: []*burrowPartition{}}
+	for _, l := range latest {
+		if l.Lag == nil {
+			continue
+		}
+		end := newBurrowOffset(l)
+		start, ok := starts[l.Topic+"/"+strconv.Itoa(int(l.Partition))]
+		if !ok {
+			start = end
+		}
+		p := &burrowPartition{
+			Topic:      l.Topic,
+			Partition:  l.Partition,
+			Status:     burrowPartitionStatus(start, end),
+			Start:      start,
+			End:        end,
+			CurrentLag: *l.Lag,
+			Complete:   1,
+		}
+		switch {
+		case p.Status == "STALL" || p.Status == "REWIND":
+			s.Status = "ERR"
+		case p.Status == "WARN" && s.Status == "OK":
+			s.Status = "WARN"
+		}
+		if s.MaxLag == nil || p.CurrentLag > s.MaxLag.CurrentLag {
+			s.MaxLag = p
+		}
+		s.TotalLag += p.CurrentLag
+		s.Partitions = append(s.Partitions, p)
+	}
+	s.PartitionCount = len(s.Partitions)
+	return s
+}
+
+// serveBurrow answers Burrow's lag and status requests for the group. Like
+// Burrow, status only lists partitions that aren't OK.
+func (e *lagExporter) serveBurrow(group string) http.Handler {
+	lagPath := "/v3/kafka/" + e.cluster + "/consumer/" + group + "/lag"
+	statusPath := "/v3/kafka/" + e.cluster + "/consumer/" + group + "/status"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := burrowResponse{Request: burrowRequest{URL: r.URL.Path, Host: r.Host}}
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path != lagPath && r.URL.Path != statusPath {
+			resp.Error, resp.Message = true, "cluster or consumer not found"
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		e.Lock()
+		resp.Status = burrowStatus(e.cluster, group, e.previous, e.latest)
+		e.Unlock()
+		resp.Message = "consumer status returned"
+		if r.URL.Path == statusPath {
+			bad := []*burrowPartition{}
+			for _, p := range resp.Status.Partitions {
+				if p.Status != "OK" {
+					bad = append(bad, p)
+				}
+			}
+			resp.Status.Partitions = bad
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// serve exposes the exporter on addr in the background.
+func (e *lagExporter) serve(addr, group string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e.metrics)
+	mux.Handle("/v3/kafka/", e.serveBurrow(group))
+	serveMux(addr, mux)
+}
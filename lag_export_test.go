@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLagExporterMetrics(t *testing.T) {
+	now := time.Now()
+	e := newLagExporter("prod")
+	e.update([]partitionLag{
+		newPartitionLag(now, "g", "hans", 0, 12, 6),
+		newPartitionLag(now, "g", "hans", 1, 3, -1),
+		newPartitionLag(now, "g", "hans", 2, 100, 90),
+	})
+
+	var buf bytes.Buffer
+	e.metrics.write(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`kafka_consumergroup_lag{consumergroup="g",partition="0",topic="hans"} 6`,
+		`kafka_consumergroup_current_offset{consumergroup="g",partition="2",topic="hans"} 90`,
+		`kafka_consumergroup_lag_sum{consumergroup="g",topic="hans"} 16`,
+		`kafka_topic_partition_current_offset{partition="1",topic="hans"} 3`,
+		`kafka_consumergroup_group_lag{client_id="",cluster_name="prod",consumer_id="",group="g",member_host="",partition="2",topic="hans"} 10`,
+		`kafka_consumergroup_group_max_lag{cluster_name="prod",group="g"} 10`,
+		`kafka_consumergroup_group_sum_lag{cluster_name="prod",group="g"} 16`,
+		`kafka_partition_latest_offset{cluster_name="prod",partition="1",topic="hans"} 3`,
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+	require.NotContains(t, out, `kafka_consumergroup_lag{consumergroup="g",partition="1"`)
+}
+
+func TestBurrowPartitionStatus(t *testing.T) {
+	require.Equal(t, "OK", burrowPartitionStatus(&burrowOffset{Offset: 5, Lag: 3}, &burrowOffset{Offset: 8, Lag: 2}))
+	require.Equal(t, "OK", burrowPartitionStatus(&burrowOffset{Offset: 5}, &burrowOffset{Offset: 5}))
+	require.Equal(t, "WARN", burrowPartitionStatus(&burrowOffset{Offset: 5, Lag: 3}, &burrowOffset{Offset: 8, Lag: 4}))
+	require.Equal(t, "STALL", burrowPartitionStatus(&burrowOffset{Offset: 5, Lag: 3}, &burrowOffset{Offset: 5, Lag: 3}))
+	require.Equal(t, "REWIND", burrowPartitionStatus(&burrowOffset{Offset: 5}, &burrowOffset{Offset: 2, Lag: 3}))
+}
+
+func TestLagExporterBurrow(t *testing.T) {
+	now := time.Now()
+	e := newLagExporter("prod")
+	e.update([]partitionLag{
+		newPartitionLag(now, "g", "hans", 0, 12, 6),
+		newPartitionLag(now, "g", "hans", 1, 20, 10),
+	})
+	e.update([]partitionLag{
+		newPartitionLag(now.Add(time.Second), "g", "hans", 0, 14, 14),
+		newPartitionLag(now.Add(time.Second), "g", "hans", 1, 25, 10),
+	})
+	h := e.serveBurrow("g")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/v3/kafka/prod/consumer/g/lag", nil))
+	require.Equal(t, 200, rec.Code)
+	var resp burrowResponse
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.False(t, resp.Error)
+	require.Equal(t, "ERR", resp.Status.Status)
+	require.Equal(t, 2, resp.Status.PartitionCount)
+	require.Equal(t, int64(15), resp.Status.TotalLag)
+	require.Equal(t, int32(1), resp.Status.MaxLag.Partition)
+	require.Equal(t, "OK", resp.Status.Partitions[0].Status)
+	require.Equal(t, int64(6), resp.Status.Partitions[0].Start.Lag)
+	require.Equal(t, "STALL", resp.Status.Partitions[1].Status)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/v3/kafka/prod/consumer/g/status", nil))
+	resp = burrowResponse{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Status.Partitions, 1)
+	require.Equal(t, 2, resp.Status.PartitionCount)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/v3/kafka/prod/consumer/other/lag", nil))
+	require.Equal(t, 404, rec.Code)
+	require.True(t, strings.Contains(rec.Body.String(), `"error":true`))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPartitionLag(t *testing.T) {
+	now := time.Now()
+
+	l := newPartitionLag(now, "g", "hans", 1, 10, 4)
+	require.Equal(t, int64(4), *l.Offset)
+	require.Equal(t, int64(6), *l.Lag)
+
+	// committed after the high water mark was read
+	l = newPartitionLag(now, "g", "hans", 1, 10, 11)
+	require.Equal(t, int64(0), *l.Lag)
+
+	l = newPartitionLag(now, "g", "hans", 1, 10, -1)
+	require.Nil(t, l.Offset)
+	require.Nil(t, l.Lag)
+}
+
+func TestFormatLagTable(t *testing.T) {
+	now := time.Now()
+	lags := []partitionLag{
+		newPartitionLag(now, "g", "hans", 0, 12, 6),
+		newPartitionLag(now, "g", "hans", 1, 3, -1),
+		newPartitionLag(now, "g", "hans", 2, 100, 90),
+	}
+
+	expected := "" +
+		"  PARTITION  HIGH WATER MARK  OFFSET  LAG\n" +
+		"          0               12       6    6\n" +
+		"          1                3       -    -\n" +
+		"          2              100      90   10\n" +
+		"      total                            16\n"
+	require.Equal(t, expected, formatLagTable(lags))
+}
+
+func TestLagPrintTableFlushesStdout(t *testing.T) {
+	defer func(s *bufferedStdout) { stdout = s }(stdout)
+	buf := &bytes.Buffer{}
+	stdout = &bufferedStdout{w: bufio.NewWriter(buf)}
+
+	cmd := &lagCmd{group: "specials", topic: "fav-topic"}
+	cmd.printTable([]partitionLag{newPartitionLag(time.Now(), "specials", "fav-topic", 0, 10, 7)})
+	require.Contains(t, buf.String(), "group specials on topic fav-topic at ")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// Headers that record where a copied message originates from.
+const (
+	lineageClusterHeader   = "kt-source-cluster"
+	lineageTopicHeader     = "kt-source-topic"
+	lineagePartitionHeader = "kt-source-partition"
+	lineageOffsetHeader    = "kt-source-offset"
+	lineageRunIDHeader     = "kt-run-id"
+)
+
+// lineage annotates produced messages with provenance headers: the source
+// cluster, topic, partition and offset they were copied from and the ID of
+// the kt run that copied them.
+type lineage struct {
+	cluster string
+	topic   string
+	runID   string
+}
+
+func newLineage(cluster, topic string) *lineage {
+	return &lineage{cluster: cluster, topic: topic, runID: randomString(16)}
+}
+
+// headers returns the provenance headers of a message copied from the given
+// source position. The topic falls back to the lineage's topic and unknown
+// topics, partitions and offsets are omitted.
+func (l *lineage) headers(topic *string, partition *int32, offset *int64) []*sarama.RecordHeader {
+	hs := []*sarama.RecordHeader{{Key: []byte(lineageClusterHeader), Value: []byte(l.cluster)}}
+
+	if topic == nil && l.topic != "" {
+		topic = &l.topic
+	}
+	if topic != nil {
+		hs = append(hs, &sarama.RecordHeader{Key: []byte(lineageTopicHeader), Value: []byte(*topic)})
+	}
+	if partition != nil {
+		hs = append(hs, &sarama.RecordHeader{Key: []byte(lineagePartitionHeader), Value: []byte(fmt.Sprint(*partition))})
+	}
+	if offset != nil {
+		hs = append(hs, &sarama.RecordHeader{Key: []byte(lineageOffsetHeader), Value: []byte(fmt.Sprint(*offset))})
+	}
+
+	return append(hs, &sarama.RecordHeader{Key: []byte(lineageRunIDHeader), Value: []byte(l.runID)})
+}
+
+// headerMatch matches messages with the header key, and the given value
+// unless value is nil.
+type headerMatch struct {
+	key   string
+	value *string
+}
+
+// parseHeaderMatches parses a comma separated list of key=value or key
+// elements, e.g. kt-source-cluster=b,mirrored.
+func parseHeaderMatches(s string) ([]headerMatch, error) {
+	ms := []headerMatch{}
+	for _, el := range strings.Split(s, ",") {
+		kv := strings.SplitN(el, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid header %#v, expected key=value or key", el)
+		}
+		m := headerMatch{key: kv[0]}
+		if len(kv) == 2 {
+			m.value = &kv[1]
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// matchHeaders returns whether any of the headers matches any of ms.
+func matchHeaders(ms []headerMatch, headers map[string][]byte) bool {
+	for _, m := range ms {
+		v, ok := headers[m.key]
+		if ok && (m.value == nil || *m.value == string(v)) {
+			return true
+		}
+	}
+	return false
+}
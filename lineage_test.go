@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeaderMatches(t *testing.T) {
+	a := "a"
+	actual, err := parseHeaderMatches("kt-source-cluster=a,mirrored")
+	require.Nil(t, err)
+	require.Equal(t, []headerMatch{{key: "kt-source-cluster", value: &a}, {key: "mirrored"}}, actual)
+
+	_, err = parseHeaderMatches("=a")
+	require.NotNil(t, err)
+
+	require.True(t, matchHeaders(actual, map[string][]byte{"kt-source-cluster": []byte("a")}))
+	require.False(t, matchHeaders(actual, map[string][]byte{"kt-source-cluster": []byte("b")}))
+	require.True(t, matchHeaders(actual, map[string][]byte{"mirrored": nil}))
+	require.False(t, matchHeaders(actual, map[string][]byte{}))
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type lintCmd struct {
+	clientConfig
+
+	file     string
+	internal bool
+	verbose  bool
+	pretty   bool
+
+	rules  lintRules
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+type lintArgs struct {
+	clientArgs
+
+	file     string
+	policy   string
+	internal bool
+	verbose  bool
+	pretty   bool
+}
+
+// lintPolicy are the rules topics are checked against, rules that aren't
+// set aren't checked. Retentions are durations like 1d or 12h.
+type lintPolicy struct {
+	Naming               string   `json:"naming,omitempty" yaml:"naming,omitempty"`
+	Exclude              []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	MinPartitions        int32    `json:"min-partitions,omitempty" yaml:"min-partitions,omitempty"`
+	MaxPartitions        int32    `json:"max-partitions,omitempty" yaml:"max-partitions,omitempty"`
+	MinReplicationFactor int16    `json:"min-replication-factor,omitempty" yaml:"min-replication-factor,omitempty"`
+	MinInsyncReplicas    int      `json:"min-insync-replicas,omitempty" yaml:"min-insync-replicas,omitempty"`
+	MinRetention         string   `json:"min-retention,omitempty" yaml:"min-retention,omitempty"`
+	MaxRetention         string   `json:"max-retention,omitempty" yaml:"max-retention,omitempty"`
+}
+
+// lintFile is the file of -f, the topics to check and optionally the policy
+// to check them against.
+type lintFile struct {
+	Policy *lintPolicy     `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Topics []lintFileTopic `json:"topics" yaml:"topics"`
+}
+
+type lintFileTopic struct {
+	Name              string                 `json:"name" yaml:"name"`
+	Partitions        int32                  `json:"partitions,omitempty" yaml:"partitions,omitempty"`
+	ReplicationFactor int16                  `json:"replication-factor,omitempty" yaml:"replication-factor,omitempty"`
+	Configs           map[string]interface{} `json:"configs,omitempty" yaml:"configs,omitempty"`
+}
+
+// lintTopic is a topic as declared in a file or found on the cluster.
+// Partitions and ReplicationFactor are 0 and Configs miss entries that the
+// file doesn't declare.
+type lintTopic struct {
+	Name              string
+	Partitions        int32
+	ReplicationFactor int16
+	Configs           map[string]string
+}
+
+type lintViolation struct {
+	Topic   string `json:"topic"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type lintReport struct {
+	Source     string          `json:"source"`
+	Topics     int             `json:"topics"`
+	Violations []lintViolation `json:"violations"`
+}
+
+// lintRules is a parsed lintPolicy.
+type lintRules struct {
+	naming               *regexp.Regexp
+	exclude              []string
+	minPartitions        int32
+	maxPartitions        int32
+	minReplicationFactor int16
+	minInsyncReplicas    int
+	minRetention         time.Duration
+	maxRetention         time.Duration
+}
+
+func newLintRules(p lintPolicy) (lintRules, error) {
+	var (
+		err error
+		r   = lintRules{
+			exclude:              p.Exclude,
+			minPartitions:        p.MinPartitions,
+			maxPartitions:        p.MaxPartitions,
+			minReplicationFactor: p.MinReplicationFactor,
+			minInsyncReplicas:    p.MinInsyncReplicas,
+		}
+	)
+	if p.Naming != "" {
+		if r.naming, err = regexp.Compile(p.Naming); err != nil {
+			return r, fmt.Errorf("invalid naming %#v err=%v", p.Naming, err)
+		}
+	}
+	if p.MinRetention != "" {
+		if r.minRetention, err = parseIdleDuration(p.MinRetention); err != nil {
+			return r, fmt.Errorf("invalid min-retention err=%v", err)
+		}
+	}
+	if p.MaxRetention != "" {
+		if r.maxRetention, err = parseIdleDuration(p.MaxRetention); err != nil {
+			return r, fmt.Errorf("invalid max-retention err=%v", err)
+		}
+	}
+	if r.maxPartitions > 0 && r.minPartitions > r.maxPartitions {
+		return r, fmt.Errorf("min-partitions %v is more than max-partitions %v", r.minPartitions, r.maxPartitions)
+	}
+	if r.maxRetention > 0 && r.minRetention > r.maxRetention {
+		return r, fmt.Errorf("min-retention %v is longer than max-retention %v", p.MinRetention, p.MaxRetention)
+	}
+	return r, nil
+}
+
+func (r lintRules) excluded(topic string) bool {
+	return matchAnyPattern(r.exclude, topic)
+}
+
+// check returns the violations of the rules by t. A topic that doesn't
+// declare a setting that a rule checks violates it, as the broker default
+// that applies isn't known from a file.
+func (r lintRules) check(t lintTopic) []lintViolation {
+	result := []lintViolation{}
+	violation := func(rule, msg string, args ...interface{}) {
+		result = append(result, lintViolation{Topic: t.Name, Rule: rule, Message: fmt.Sprintf(msg, args...)})
+	}
+
+	if r.naming != nil && !r.naming.MatchString(t.Name) {
+		violation("naming", "name doesn't match %v", r.naming)
+	}
+
+	switch {
+	case (r.minPartitions > 0 || r.maxPartitions > 0) && t.Partitions == 0:
+		violation("partitions", "partitions aren't declared")
+	case r.minPartitions > 0 && t.Partitions < r.minPartitions:
+		violation("partitions", "%v partitions are fewer than %v", t.Partitions, r.minPartitions)
+	case r.maxPartitions > 0 && t.Partitions > r.maxPartitions:
+		violation("partitions", "%v partitions are more than %v", t.Partitions, r.maxPartitions)
+	}
+
+	switch {
+	case r.minReplicationFactor > 0 && t.ReplicationFactor == 0:
+		violation("replication-factor", "replication factor isn't declared")
+	case t.ReplicationFactor < r.minReplicationFactor:
+		violation("replication-factor", "replication factor %v is less than %v", t.ReplicationFactor, r.minReplicationFactor)
+	}
+
+	if r.minInsyncReplicas > 0 {
+		v, ok := t.Configs["min.insync.replicas"]
+		n, err := strconv.Atoi(v)
+		switch {
+		case !ok:
+			violation("min-insync-replicas", "min.insync.replicas isn't set")
+		case err != nil:
+			violation("min-insync-replicas", "min.insync.replicas %#v isn't a number", v)
+		case n < r.minInsyncReplicas:
+			violation("min-insync-replicas", "min.insync.replicas %v is less than %v", n, r.minInsyncReplicas)
+		case t.ReplicationFactor > 0 && n > int(t.ReplicationFactor):
+			violation("min-insync-replicas", "min.insync.replicas %v is more than the replication factor %v, producers with acks=all fail", n, t.ReplicationFactor)
+		}
+	}
+
+	if r.minRetention > 0 || r.maxRetention > 0 {
+		v, ok := t.Configs["retention.ms"]
+		ms, err := strconv.ParseInt(v, 10, 64)
+		retention := time.Duration(ms) * time.Millisecond
+		switch {
+		case !ok:
+			violation("retention", "retention.ms isn't set")
+		case err != nil:
+			violation("retention", "retention.ms %#v isn't a number", v)
+		case ms < 0 && r.maxRetention > 0:
+			violation("retention", "retention is unlimited, more than %v", r.maxRetention)
+		case ms < 0:
+		case retention < r.minRetention:
+			violation("retention", "retention %v is shorter than %v", retention, r.minRetention)
+		case r.maxRetention > 0 && retention > r.maxRetention:
+			violation("retention", "retention %v is longer than %v", retention, r.maxRetention)
+		}
+	}
+
+	return result
+}
+
+// lint checks topics, sorted by name, against the rules.
+func (r lintRules) lint(source string, topics []lintTopic) lintReport {
+	report := lintReport{Source: source, Violations: []lintViolation{}}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	for _, t := range topics {
+		if r.excluded(t.Name) {
+			continue
+		}
+		report.Topics++
+		report.Violations = append(report.Violations, r.check(t)...)
+	}
+	return report
+}
+
+// readLintFile reads the topics and the policy, if any, of a YAML or JSON
+// file.
+func readLintFile(path string) ([]lintTopic, *lintPolicy, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var f lintFile
+	if err = unmarshalConfigFile(path, buf, &f); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %v err=%v", path, err)
+	}
+
+	topics := []lintTopic{}
+	for i, ft := range f.Topics {
+		if ft.Name == "" {
+			return nil, nil, fmt.Errorf("topic %v in %v has no name", i+1, path)
+		}
+		t := lintTopic{Name: ft.Name, Partitions: ft.Partitions, ReplicationFactor: ft.ReplicationFactor, Configs: map[string]string{}}
+		for k, v := range ft.Configs {
+			t.Configs[k] = fmt.Sprint(v)
+		}
+		topics = append(topics, t)
+	}
+	return topics, f.Policy, nil
+}
+
+func readLintPolicy(path string) (lintPolicy, error) {
+	var p lintPolicy
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err = unmarshalConfigFile(path, buf, &p); err != nil {
+		return p, fmt.Errorf("failed to parse %v err=%v", path, err)
+	}
+	return p, nil
+}
+
+func (cmd *lintCmd) run(args []string) {
+	var (
+		err    error
+		topics []lintTopic
+		source string
+	)
+
+	policy := cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.file != "" {
+		var filePolicy *lintPolicy
+		if topics, filePolicy, err = readLintFile(cmd.file); err != nil {
+			failf("failed to read topics err=%v", err)
+		}
+		if policy == nil {
+			policy = filePolicy
+		}
+		source = cmd.file
+	}
+	if policy == nil {
+		cmd.failStartup("found no policy, pass -policy or add one under \"policy\" to the -f file.")
+	}
+	if cmd.rules, err = newLintRules(*policy); err != nil {
+		failf("invalid policy err=%v", err)
+	}
+
+	if cmd.file == "" {
+		cmd.connect()
+		defer logClose("client", cmd.client)
+		if topics, err = cmd.readTopics(); err != nil {
+			failf("failed to read topics err=%v", err)
+		}
+		source = strings.Join(cmd.brokers, ",")
+	}
+
+	report := cmd.rules.lint(source, topics)
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: report, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	if len(report.Violations) > 0 {
+		failf("found %v policy violations in %v topics", len(report.Violations), report.Topics)
+	}
+}
+
+func (cmd *lintCmd) connect() {
+	var err error
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	if cmd.admin, err = sarama.NewClusterAdminFromClient(cmd.client); err != nil {
+		failf("failed to create cluster admin err=%v", err)
+	}
+}
+
+// readTopics reads the topics of the cluster with their effective configs,
+// including broker defaults.
+func (cmd *lintCmd) readTopics() ([]lintTopic, error) {
+	names, err := cmd.client.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	topics := []lintTopic{}
+	for _, name := range names {
+		if (strings.HasPrefix(name, "__") && !cmd.internal) || cmd.rules.excluded(name) {
+			continue
+		}
+		t := lintTopic{Name: name, Configs: map[string]string{}}
+		partitions, err := cmd.client.Partitions(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read partitions of topic %v err=%v", name, err)
+		}
+		t.Partitions = int32(len(partitions))
+		if len(partitions) > 0 {
+			replicas, err := cmd.client.Replicas(name, partitions[0])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read replicas of topic %v err=%v", name, err)
+			}
+			t.ReplicationFactor = int16(len(replicas))
+		}
+
+		entries, err := cmd.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: name})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe configs of topic %v err=%v", name, err)
+		}
+		for _, e := range entries {
+			t.Configs[e.Name] = e.Value
+		}
+		topics = append(topics, t)
+	}
+	return topics, nil
+}
+
+func (cmd *lintCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("lint")
+}
+
+func (cmd *lintCmd) failStartup(msg string) {
+	failUsage(msg, "kt lint")
+}
+
+// parseArgs returns the policy of -policy, nil if it isn't passed.
+func (cmd *lintCmd) parseArgs(as []string) *lintPolicy {
+	args := cmd.parseFlags(as)
+
+	var policy *lintPolicy
+	if args.policy != "" {
+		p, err := readLintPolicy(args.policy)
+		if err != nil {
+			cmd.failStartup(fmt.Sprintf("failed to read policy err=%v", err))
+		}
+		policy = &p
+	}
+
+	cmd.file = args.file
+	cmd.internal = args.internal
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+
+	return policy
+}
+
+func (cmd *lintCmd) parseFlags(as []string) lintArgs {
+	var args lintArgs
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.StringVar(&args.file, "f", "", "YAML or JSON file of topics to check, instead of the topics of the cluster.")
+	flags.StringVar(&args.policy, "policy", "", "YAML or JSON file of the policy to check against (defaults to the policy of the -f file).")
+	flags.BoolVar(&args.internal, "internal", false, "Include internal topics like __consumer_offsets of the cluster.")
+	args.clientArgs.addFlags(flags)
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of lint:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, lintDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var lintDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt lint" checks topics against a policy of naming and config rules and
+prints the violations, e.g. to gate changes to a file of topics in CI:
+
+  $ kt lint -f topics.yaml
+
+or to audit the topics of a cluster:
+
+  $ kt lint -policy policy.yaml -brokers kafka-1.prod:9093
+
+The file of -f lists the topics and may have the policy, too:
+
+  policy:
+    naming: '^[a-z]+\.[a-z0-9-]+\.v[0-9]+$'
+    exclude: ['_schemas', 'connect-*']
+    min-partitions: 3
+    max-partitions: 120
+    min-replication-factor: 3
+    min-insync-replicas: 2
+    min-retention: 1d
+    max-retention: 30d
+  topics:
+    - name: sales.orders.v1
+      partitions: 12
+      replication-factor: 3
+      configs:
+        min.insync.replicas: 2
+        retention.ms: 604800000
+
+The file of -policy has the rules of "policy" at its top level and wins over
+the policy of the -f file. Rules that aren't set aren't checked, exclude skips topics matching glob patterns.
+Retentions are durations like 12h or 7d, unlimited retention (-1) is longer
+than any max-retention. min-insync-replicas also fails topics where
+min.insync.replicas is more than the replication factor.
+
+Topics of a file that don't declare a setting that a rule checks violate it,
+as the broker default isn't known offline. On a cluster, the effective
+configs including broker defaults are checked.
+
+kt lint exits with status 0 without violations, 1 with violations, and with
+the status of the failure otherwise, e.g. 3 if the brokers are unreachable.`
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintRulesCheck(t *testing.T) {
+	rules, err := newLintRules(lintPolicy{
+		Naming:               `^[a-z]+\.[a-z0-9-]+\.v[0-9]+$`,
+		Exclude:              []string{"_schemas"},
+		MinPartitions:        3,
+		MinReplicationFactor: 3,
+		MinInsyncReplicas:    2,
+		MinRetention:         "1d",
+		MaxRetention:         "30d",
+	})
+	require.NoError(t, err)
+
+	report := rules.lint("topics.yaml", []lintTopic{
+		{Name: "sales.orders.v1", Partitions: 12, ReplicationFactor: 3, Configs: map[string]string{"min.insync.replicas": "2", "retention.ms": "604800000"}},
+		{Name: "Orders", Partitions: 1, ReplicationFactor: 2, Configs: map[string]string{"min.insync.replicas": "3", "retention.ms": "-1"}},
+		{Name: "sales.audit.v1", Configs: map[string]string{"retention.ms": "60000"}},
+		{Name: "_schemas", Partitions: 1, ReplicationFactor: 1},
+	})
+	require.Equal(t, lintReport{
+		Source: "topics.yaml",
+		Topics: 3,
+		Violations: []lintViolation{
+			{Topic: "Orders", Rule: "naming", Message: `name doesn't match ^[a-z]+\.[a-z0-9-]+\.v[0-9]+$`},
+			{Topic: "Orders", Rule: "partitions", Message: "1 partitions are fewer than 3"},
+			{Topic: "Orders", Rule: "replication-factor", Message: "replication factor 2 is less than 3"},
+			{Topic: "Orders", Rule: "min-insync-replicas", Message: "min.insync.replicas 3 is more than the replication factor 2, producers with acks=all fail"},
+			{Topic: "Orders", Rule: "retention", Message: "retention is unlimited, more than 720h0m0s"},
+			{Topic: "sales.audit.v1", Rule: "partitions", Message: "partitions aren't declared"},
+			{Topic: "sales.audit.v1", Rule: "replication-factor", Message: "replication factor isn't declared"},
+			{Topic: "sales.audit.v1", Rule: "min-insync-replicas", Message: "min.insync.replicas isn't set"},
+			{Topic: "sales.audit.v1", Rule: "retention", Message: "retention 1m0s is shorter than 24h0m0s"},
+		},
+	}, report)
+
+	empty, err := newLintRules(lintPolicy{})
+	require.NoError(t, err)
+	require.Empty(t, empty.check(lintTopic{Name: "Anything"}))
+
+	for _, invalid := range []lintPolicy{{Naming: "("}, {MinRetention: "x"}, {MinPartitions: 4, MaxPartitions: 2}, {MinRetention: "2d", MaxRetention: "1d"}} {
+		_, err = newLintRules(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestReadLintFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "topics.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+policy:
+  min-replication-factor: 3
+  max-retention: 30d
+topics:
+  - name: sales.orders.v1
+    partitions: 12
+    replication-factor: 3
+    configs:
+      min.insync.replicas: 2
+      retention.ms: 604800000
+`), 0644))
+	topics, policy, err := readLintFile(path)
+	require.NoError(t, err)
+	require.Equal(t, &lintPolicy{MinReplicationFactor: 3, MaxRetention: "30d"}, policy)
+	require.Equal(t, []lintTopic{{Name: "sales.orders.v1", Partitions: 12, ReplicationFactor: 3, Configs: map[string]string{"min.insync.replicas": "2", "retention.ms": "604800000"}}}, topics)
+
+	path = filepath.Join(dir, "topics.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"topics": [{"name": "a", "configs": {"retention.ms": 604800000}}]}`), 0644))
+	topics, policy, err = readLintFile(path)
+	require.NoError(t, err)
+	require.Nil(t, policy)
+	require.Equal(t, "604800000", topics[0].Configs["retention.ms"])
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"topics": [{"partitions": 1}]}`), 0644))
+	_, _, err = readLintFile(path)
+	require.Error(t, err)
+}
@@ -23,23 +23,91 @@ var usageMessage = `kt is a tool for Kafka.
 
 Usage:
 
-	kt command [arguments]
+	kt [-cluster name] command [arguments]
 
 The commands are:
 
-	consume    consume messages.
-	produce    produce messages.
-	topic      topic information.
-	group      consumer group information and modification.
-	admin      basic cluster administration.
+	consume         consume messages.
+	produce         produce messages.
+	topic           topic information.
+	group           consumer group information and modification.
+	lag             watch the lag of a consumer group.
+	top             watch the produce rate, skew and lag of topics in a table.
+	tail            follow the newest messages of a topic.
+	copy            copy messages between topics or clusters.
+	canary          check that a topic can be produced to and consumed from.
+	ping            measure produce and end-to-end latency of a topic.
+	analyze         find unused topics and groups, check partitioning and compression.
+	lint            check topics against naming and config policy rules.
+	check           check that the partitions of a topic received records recently.
+	probe           report which operations on a topic the credentials are allowed.
+	verify          check that every key of a topic is in its partition.
+	history         print the records of a key as a timeline.
+	diff            print the differences between two records.
+	get             fetch many records by topic, partition and offset.
+	io              serve consume, produce and offsets requests as JSON lines on stdio.
+	streams         map Kafka Streams state stores to their changelog topics.
+	retrypattern    inspect and drain the retry and dead letter topics of a topic.
+	replay-session  print the records of a kt consume -record file offline.
+	acl             list, create and delete ACLs.
+	ui              browse topics, partitions and messages in the terminal.
+	admin           basic cluster administration.
+	config          add cluster profiles to the config file.
+	self-update     install the latest release of kt.
+
+-cluster selects a cluster profile of the config file, KT_CLUSTER if it isn't
+passed. A profile sets the brokers and the TLS, SASL and schema registry
+settings that aren't passed as flags, e.g. in ~/.config/kt/config.yaml:
+
+	clusters:
+	  prod:
+	    brokers: kafka-1.prod:9093,kafka-2.prod:9093
+	    tlsca: /etc/kafka/prod-ca.pem
+	    sasl: {mechanism: SCRAM-SHA-512, user: kt, password: secret}
+	    schema-registry: https://registry.prod
+
+"kt config add-cluster" adds such a profile after checking that kt can
+connect with it.
+
+-config - reads the flags of a command as a JSON object from stdin, e.g. for
+programs that invoke kt without building and escaping a command line. Keys
+are flag names without dash, arrays repeat a flag and null leaves it unset,
+flags after -config - win over the object. Stdin after the object is left to
+the command, e.g. the messages to produce. kt admin keeps -config for the
+config entries of alterconfig:
+
+	$ printf '{"topic": "orders", "compression": "gzip"}\n{"value": "a"}' | kt produce -config -
 
 Use "kt [command] -help" for for information about the command.
 
 More at https://github.com/fgeller/kt`
 
 func parseArgs() command {
+	cluster, args, err := clusterFlag(os.Args[1:])
+	if err != nil {
+		exitf(exitUsage, "%v", err)
+	}
+	if cluster != "" {
+		if err = useCluster(cluster); err != nil {
+			exitf(exitUsage, "%v", err)
+		}
+	}
+	if len(args) > 1 {
+		cmdArgs, stdin, err := stdinConfig(args[0], args[1:], os.Stdin)
+		if err != nil {
+			exitf(exitUsage, "%v", err)
+		}
+		if stdin != nil {
+			if err = replaceStdin(stdin); err != nil {
+				failf("failed to read stdin after -config - err=%v", err)
+			}
+			args = append(args[:1], cmdArgs...)
+		}
+	}
+	os.Args = append(os.Args[:1], args...)
+
 	if len(os.Args) < 2 {
-		failf(usageMessage)
+		exitf(exitUsage, usageMessage)
 	}
 
 	switch os.Args[1] {
@@ -53,10 +121,54 @@ func parseArgs() command {
 		return &groupCmd{}
 	case "admin":
 		return &adminCmd{}
+	case "lag":
+		return &lagCmd{}
+	case "top":
+		return &topCmd{}
+	case "tail":
+		return &consumeCmd{tail: true}
+	case "copy":
+		return &copyCmd{}
+	case "canary":
+		return &canaryCmd{}
+	case "ping":
+		return &pingCmd{}
+	case "analyze":
+		return &analyzeCmd{}
+	case "check":
+		return &checkCmd{}
+	case "lint":
+		return &lintCmd{}
+	case "probe":
+		return &probeCmd{}
+	case "verify":
+		return &verifyCmd{}
+	case "history":
+		return &historyCmd{}
+	case "get":
+		return &getCmd{}
+	case "diff":
+		return &diffCmd{}
+	case "io":
+		return &ioCmd{}
+	case "streams":
+		return &streamsCmd{}
+	case "retrypattern":
+		return &retryPatternCmd{}
+	case "replay-session":
+		return &replaySessionCmd{}
+	case "acl":
+		return &aclCmd{}
+	case "ui":
+		return &uiCmd{}
+	case "self-update":
+		return &selfUpdateCmd{}
+	case "config":
+		return &configCmd{}
 	case "-h", "-help", "--help":
 		quitf(usageMessage)
 	default:
-		failf(usageMessage)
+		exitf(exitUsage, usageMessage)
 	}
 	return nil
 }
@@ -64,4 +176,5 @@ func parseArgs() command {
 func main() {
 	cmd := parseArgs()
 	cmd.run(os.Args[2:])
+	stdout.Flush()
 }
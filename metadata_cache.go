@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataCache refreshes the cluster metadata of long running commands like
+// kt lag and kt ui at most every -metadata-max-age, rather than before every
+// poll or view, to spare busy clusters. A maxAge of 0 refreshes every time.
+type metadataCache struct {
+	sync.Mutex
+	refreshMetadata func(topics ...string) error
+	maxAge          time.Duration
+	refreshed       map[string]time.Time // by comma separated topics, "" for all
+	now             func() time.Time
+}
+
+func newMetadataCache(refresh func(topics ...string) error, maxAge time.Duration) *metadataCache {
+	return &metadataCache{refreshMetadata: refresh, maxAge: maxAge, refreshed: map[string]time.Time{}, now: time.Now}
+}
+
+// refresh refreshes the metadata of topics, or of all topics if none are
+// given, unless it's younger than maxAge. Refreshing all topics covers
+// refreshing some of them.
+func (c *metadataCache) refresh(topics ...string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	key, now := strings.Join(topics, ","), c.now()
+	if c.maxAge > 0 {
+		for _, k := range []string{key, ""} {
+			if t, ok := c.refreshed[k]; ok && now.Sub(t) < c.maxAge {
+				return nil
+			}
+		}
+	}
+
+	if err := c.refreshMetadata(topics...); err != nil {
+		return err
+	}
+	c.refreshed[key] = now
+	return nil
+}
+
+// invalidate makes the next refresh ask the brokers, e.g. when the metadata
+// turned out to be stale or on the operator's request.
+func (c *metadataCache) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.refreshed = map[string]time.Time{}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataCache(t *testing.T) {
+	var refreshed [][]string
+	fail := false
+	refresh := func(topics ...string) error {
+		if fail {
+			return fmt.Errorf("unavailable")
+		}
+		refreshed = append(refreshed, topics)
+		return nil
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	c := newMetadataCache(refresh, time.Minute)
+	c.now = func() time.Time { return now }
+
+	require.Nil(t, c.refresh("orders"))
+	require.Nil(t, c.refresh("orders"))
+	require.Equal(t, [][]string{{"orders"}}, refreshed)
+
+	require.Nil(t, c.refresh())
+	require.Nil(t, c.refresh("billing"), "covered by refreshing all topics")
+	require.Len(t, refreshed, 2)
+
+	now = now.Add(time.Minute)
+	require.Nil(t, c.refresh("orders"))
+	require.Len(t, refreshed, 3)
+
+	c.invalidate()
+	fail = true
+	require.NotNil(t, c.refresh("orders"))
+	fail = false
+	require.Nil(t, c.refresh("orders"))
+	require.Len(t, refreshed, 4, "a failed refresh isn't cached")
+
+	c = newMetadataCache(refresh, 0)
+	require.Nil(t, c.refresh("orders"))
+	require.Nil(t, c.refresh("orders"))
+	require.Len(t, refreshed, 6)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	counterMetric = "counter"
+	gaugeMetric   = "gauge"
+)
+
+type metric struct {
+	name   string
+	kind   string
+	help   string
+	values map[string]float64
+}
+
+// metricsRegistry holds the metrics a command exposes via -metricsaddr. The
+// registry renders itself in the Prometheus text exposition format so it can
+// be scraped without pulling in a client library.
+type metricsRegistry struct {
+	sync.Mutex
+	metrics map[string]*metric
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{metrics: map[string]*metric{}}
+}
+
+func (r *metricsRegistry) register(name, kind, help string) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.metrics[name]; !ok {
+		r.metrics[name] = &metric{name: name, kind: kind, help: help, values: map[string]float64{}}
+	}
+}
+
+// add increments the series of the given metric identified by labels. It's a
+// no-op for a nil registry so callers don't have to check whether metrics
+// are enabled.
+func (r *metricsRegistry) add(name string, labels map[string]string, v float64) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	m, ok := r.metrics[name]
+	if !ok {
+		return
+	}
+	m.values[formatLabels(labels)] += v
+}
+
+// set overwrites the series of the given metric identified by labels.
+func (r *metricsRegistry) set(name string, labels map[string]string, v float64) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	m, ok := r.metrics[name]
+	if !ok {
+		return
+	}
+	m.values[formatLabels(labels)] = v
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, v))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (r *metricsRegistry) write(w io.Writer) {
+	r.Lock()
+	defer r.Unlock()
+
+	names := make([]string, 0, len(r.metrics))
+	for n := range r.metrics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		m := r.metrics[n]
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+		series := make([]string, 0, len(m.values))
+		for s := range m.values {
+			series = append(series, s)
+		}
+		sort.Strings(series)
+		for _, s := range series {
+			fmt.Fprintf(w, "%s%s %v\n", m.name, s, m.values[s])
+		}
+	}
+}
+
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.write(w)
+}
+
+// serveMetrics exposes the registry on addr under /metrics in the background.
+func serveMetrics(addr string, r *metricsRegistry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	serveMux(addr, mux)
+}
+
+// serveMux serves mux on addr in the background.
+func serveMux(addr string, mux *http.ServeMux) {
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to serve metrics on %v err=%v\n", addr, err)
+		}
+	}()
+}
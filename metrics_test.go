@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsRegistryWrite(t *testing.T) {
+	r := newMetricsRegistry()
+	r.register("kt_b_total", counterMetric, "B.")
+	r.register("kt_a", gaugeMetric, "A.")
+
+	r.add("kt_b_total", map[string]string{"broker": "localhost:9092"}, 1)
+	r.add("kt_b_total", map[string]string{"broker": "localhost:9092"}, 2.5)
+	r.add("kt_unknown", nil, 1)
+	r.set("kt_a", nil, 3)
+	r.set("kt_a", nil, 4)
+
+	var buf bytes.Buffer
+	r.write(&buf)
+
+	expected := `# HELP kt_a A.
+# TYPE kt_a gauge
+kt_a 4
+# HELP kt_b_total B.
+# TYPE kt_b_total counter
+kt_b_total{broker="localhost:9092"} 3.5
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestMetricsRegistryNil(t *testing.T) {
+	var r *metricsRegistry
+	r.add("kt_b_total", nil, 1)
+	r.set("kt_a", nil, 1)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// repeatedFlag collects the values of a flag that can be passed several
+// times, in order.
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *repeatedFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// mutation sets a field of input messages before they're produced, like
+// jq's path = value. target is key, value, partition or headers. For key and
+// value, path names the fields within the JSON object, for headers the
+// header key.
+type mutation struct {
+	target string
+	path   []string
+	value  string
+}
+
+// parseMutation parses -set arguments like .value.status=RETRY,
+// .key=id-23, .partition=0 or .headers.retry-count=1.
+func parseMutation(s string) (mutation, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || !strings.HasPrefix(kv[0], ".") {
+		return mutation{}, fmt.Errorf("invalid mutation %#v, expected .path=value", s)
+	}
+
+	m := mutation{value: kv[1]}
+	p := strings.TrimPrefix(kv[0], ".")
+	if strings.HasPrefix(p, "headers.") {
+		m.target, m.path = "headers", []string{strings.TrimPrefix(p, "headers.")}
+		return m, nil
+	}
+
+	fields := strings.Split(p, ".")
+	m.target, m.path = fields[0], fields[1:]
+	switch {
+	case m.target != "key" && m.target != "value" && m.target != "partition":
+		return mutation{}, fmt.Errorf("invalid mutation %#v, only .key, .value, .partition and .headers can be set", s)
+	case m.target == "partition" && len(m.path) > 0:
+		return mutation{}, fmt.Errorf("invalid mutation %#v, .partition has no fields", s)
+	}
+	for _, f := range m.path {
+		if f == "" {
+			return mutation{}, fmt.Errorf("invalid mutation %#v, empty field name", s)
+		}
+	}
+	return m, nil
+}
+
+// parseHeaderMutation parses -set-header arguments like retry-count=1.
+func parseHeaderMutation(s string) (mutation, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return mutation{}, fmt.Errorf("invalid header %#v, expected key=value", s)
+	}
+	return mutation{target: "headers", path: []string{kv[0]}, value: kv[1]}, nil
+}
+
+// apply sets the field of msg. Fields within the key or value are set to
+// the JSON value of the mutation's value if it is valid JSON and to the
+// string otherwise, so .value.retries=1 sets a number and
+// .value.status=RETRY a string. Missing objects along the path are created.
+func (m mutation) apply(msg *message) error {
+	switch m.target {
+	case "partition":
+		p, err := strconv.ParseInt(m.value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid partition %#v", m.value)
+		}
+		partition := int32(p)
+		msg.Partition = &partition
+		return nil
+	case "headers":
+		if msg.Headers == nil {
+			msg.Headers = map[string]*string{}
+		}
+		v := m.value
+		msg.Headers[m.path[0]] = &v
+		return nil
+	}
+
+	key := m.target == "key"
+	if len(m.path) == 0 {
+		v := m.value
+		if key {
+			msg.Key = &v
+		} else {
+			msg.Value = &v
+		}
+		return nil
+	}
+
+	obj, err := transformTarget(msg, key)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		obj = map[string]interface{}{}
+	}
+
+	parent := obj
+	for i, f := range m.path[:len(m.path)-1] {
+		child, ok := parent[f].(map[string]interface{})
+		if !ok && parent[f] != nil {
+			return fmt.Errorf("%v.%v is not a JSON object", m.target, strings.Join(m.path[:i+1], "."))
+		}
+		if !ok {
+			child = map[string]interface{}{}
+			parent[f] = child
+		}
+		parent = child
+	}
+	parent[m.path[len(m.path)-1]] = mutationValue(m.value)
+
+	return setTransformTarget(msg, key, obj)
+}
+
+func mutationValue(s string) interface{} {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return s
+	}
+	return v
+}
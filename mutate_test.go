@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMutation(t *testing.T) {
+	m, err := parseMutation(".value.status=RETRY")
+	require.NoError(t, err)
+	require.Equal(t, mutation{target: "value", path: []string{"status"}, value: "RETRY"}, m)
+
+	m, err = parseMutation(".headers.trace.id=a=b")
+	require.NoError(t, err)
+	require.Equal(t, mutation{target: "headers", path: []string{"trace.id"}, value: "a=b"}, m)
+
+	m, err = parseMutation(".key=")
+	require.NoError(t, err)
+	require.Equal(t, mutation{target: "key", path: []string{}, value: ""}, m)
+
+	for _, s := range []string{"value.status=RETRY", ".value.status", ".offset=1", ".partition.x=1", ".value..status=1"} {
+		_, err = parseMutation(s)
+		require.Error(t, err, s)
+	}
+
+	m, err = parseHeaderMutation("retry-count=1")
+	require.NoError(t, err)
+	require.Equal(t, mutation{target: "headers", path: []string{"retry-count"}, value: "1"}, m)
+	_, err = parseHeaderMutation("retry-count")
+	require.Error(t, err)
+}
+
+func TestMutationApply(t *testing.T) {
+	value := `{"id":23,"status":"FAILED","meta":{"tries":1}}`
+	msg := message{Value: &value}
+
+	for _, s := range []string{".value.status=RETRY", ".value.meta.tries=2", ".value.meta.reason.code=\"42\"", ".key=id-23", ".partition=3", ".headers.retry-count=1"} {
+		m, err := parseMutation(s)
+		require.NoError(t, err)
+		require.NoError(t, m.apply(&msg))
+	}
+
+	require.Equal(t, `{"id":23,"meta":{"reason":{"code":"42"},"tries":2},"status":"RETRY"}`, *msg.Value)
+	require.Equal(t, "id-23", *msg.Key)
+	require.Equal(t, int32(3), *msg.Partition)
+	require.Equal(t, "1", *msg.Headers["retry-count"])
+
+	m, err := parseMutation(".value.id.x=1")
+	require.NoError(t, err)
+	require.Error(t, m.apply(&msg))
+
+	plain := "not json"
+	require.Error(t, m.apply(&message{Value: &plain}))
+
+	tombstone := message{}
+	m, err = parseMutation(".value.status=RETRY")
+	require.NoError(t, err)
+	require.NoError(t, m.apply(&tombstone))
+	require.Equal(t, `{"status":"RETRY"}`, *tombstone.Value)
+}
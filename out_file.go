@@ -0,0 +1,236 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/klauspost/compress/zstd"
+)
+
+// outFile writes the output of -out-file. With -out-rotate-size, it starts
+// a new file once the current one holds that many bytes of output, always
+// between messages, so each file consists of complete lines. Files are
+// compressed with -out-compress. The manifest next to the files lists the
+// offsets that each file covers, it's rewritten after every rotation.
+type outFile struct {
+	sync.Mutex
+	path       string
+	rotateSize int64
+	compress   string
+
+	file    *os.File
+	w       io.Writer
+	closer  io.Closer // compressor of w, if any
+	entry   *outFileEntry
+	ranges  map[topicPartition]*outFileRange
+	written int64
+	files   []outFileEntry
+}
+
+// outFileEntry describes one of the files in the manifest. Bytes counts the
+// uncompressed output.
+type outFileEntry struct {
+	File       string         `json:"file"`
+	Records    int            `json:"records"`
+	Bytes      int64          `json:"bytes"`
+	Partitions []outFileRange `json:"partitions"`
+}
+
+// outFileRange is the first and last offset of a partition in a file.
+type outFileRange struct {
+	Topic       string `json:"topic"`
+	Partition   int32  `json:"partition"`
+	FirstOffset int64  `json:"firstOffset"`
+	LastOffset  int64  `json:"lastOffset"`
+}
+
+type outFileManifest struct {
+	Files   []outFileEntry `json:"files"`
+	Updated time.Time      `json:"updated"`
+}
+
+func newOutFile(path string, rotateSize int64, compress string) (*outFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	switch compress {
+	case "", "gzip", "zstd":
+	default:
+		return nil, fmt.Errorf("unsupported out-compress argument %#v, only gzip and zstd are supported", compress)
+	}
+	if rotateSize < 0 {
+		return nil, fmt.Errorf("invalid out-rotate-size %v, expected a positive number of bytes", rotateSize)
+	}
+	return &outFile{path: path, rotateSize: rotateSize, compress: compress}, nil
+}
+
+// fileName is the name of the file with the given sequence number, e.g.
+// orders.000001.jsonl.gz for orders.jsonl. Without rotation it's the path
+// itself, with the compression's extension.
+func (f *outFile) fileName(seq int) string {
+	name := f.path
+	if f.rotateSize > 0 {
+		ext := filepath.Ext(name)
+		name = fmt.Sprintf("%v.%06d%v", strings.TrimSuffix(name, ext), seq, ext)
+	}
+	switch f.compress {
+	case "gzip":
+		name += ".gz"
+	case "zstd":
+		name += ".zst"
+	}
+	return name
+}
+
+func (f *outFile) manifestName() string {
+	return f.path + ".manifest.json"
+}
+
+func (f *outFile) open() error {
+	var err error
+	name := f.fileName(len(f.files))
+	if f.file, err = os.Create(name); err != nil {
+		return err
+	}
+
+	f.w, f.closer = f.file, nil
+	switch f.compress {
+	case "gzip":
+		gz := gzip.NewWriter(f.file)
+		f.w, f.closer = gz, gz
+	case "zstd":
+		zw, err := zstd.NewWriter(f.file)
+		if err != nil {
+			f.file.Close()
+			return err
+		}
+		f.w, f.closer = zw, zw
+	}
+
+	f.entry = &outFileEntry{File: filepath.Base(name)}
+	f.ranges = map[topicPartition]*outFileRange{}
+	f.written = 0
+	return nil
+}
+
+// finish closes the current file and adds it to the manifest.
+func (f *outFile) finish() error {
+	if f.file == nil {
+		return nil
+	}
+	if f.closer != nil {
+		if err := f.closer.Close(); err != nil {
+			f.file.Close()
+			return err
+		}
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	f.entry.Bytes = f.written
+	f.entry.Partitions = []outFileRange{}
+	for _, r := range f.ranges {
+		f.entry.Partitions = append(f.entry.Partitions, *r)
+	}
+	sort.Slice(f.entry.Partitions, func(i, j int) bool {
+		a, b := f.entry.Partitions[i], f.entry.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	f.files = append(f.files, *f.entry)
+	f.file = nil
+	return f.writeManifest()
+}
+
+// writeManifest replaces the manifest atomically, so readers never see a
+// partial one.
+func (f *outFile) writeManifest() error {
+	files := f.files
+	if files == nil {
+		files = []outFileEntry{}
+	}
+	buf, err := json.MarshalIndent(outFileManifest{Files: files, Updated: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.manifestName() + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.manifestName())
+}
+
+// write adds the output of msg as a line, rotating first if the current
+// file is full. Outputs that aren't raw are written as compact JSON.
+func (f *outFile) write(output interface{}, msg *sarama.ConsumerMessage) error {
+	line, err := outputLine(output)
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file != nil && f.rotateSize > 0 && f.written >= f.rotateSize {
+		if err := f.finish(); err != nil {
+			return err
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.w.Write(line); err != nil {
+		return err
+	}
+	f.written += int64(len(line))
+	f.entry.Records++
+
+	tp := topicPartition{msg.Topic, msg.Partition}
+	if r, ok := f.ranges[tp]; ok {
+		// -latest-per-key prints in key order rather than offset order.
+		if msg.Offset < r.FirstOffset {
+			r.FirstOffset = msg.Offset
+		}
+		if msg.Offset > r.LastOffset {
+			r.LastOffset = msg.Offset
+		}
+	} else {
+		f.ranges[tp] = &outFileRange{Topic: msg.Topic, Partition: msg.Partition, FirstOffset: msg.Offset, LastOffset: msg.Offset}
+	}
+	return nil
+}
+
+func (f *outFile) String() string { return f.path }
+
+// close finishes the last file and writes the final manifest.
+func (f *outFile) close() {
+	if f == nil {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	err := f.finish()
+	if err == nil && len(f.files) == 0 {
+		// nothing was consumed, the manifest still says so.
+		err = f.writeManifest()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to close %v err=%v\n", f.path, err)
+	}
+}
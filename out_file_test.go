@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-out-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := newOutFile(filepath.Join(dir, "orders.jsonl"), 150, "gzip")
+	require.NoError(t, err)
+
+	for i := int64(0); i < 5; i++ {
+		msg := &sarama.ConsumerMessage{Topic: "orders", Partition: int32(i % 2), Offset: 10 + i}
+		require.NoError(t, f.write(consumedMessage{Partition: msg.Partition, Offset: msg.Offset}, msg))
+	}
+	f.close()
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "orders.jsonl.manifest.json"))
+	require.NoError(t, err)
+	var manifest outFileManifest
+	require.NoError(t, json.Unmarshal(buf, &manifest))
+
+	require.Len(t, manifest.Files, 2)
+	require.Equal(t, "orders.000000.jsonl.gz", manifest.Files[0].File)
+	require.Equal(t, 3, manifest.Files[0].Records)
+	require.Equal(t, []outFileRange{
+		{Topic: "orders", Partition: 0, FirstOffset: 10, LastOffset: 12},
+		{Topic: "orders", Partition: 1, FirstOffset: 11, LastOffset: 11},
+	}, manifest.Files[0].Partitions)
+	require.Equal(t, "orders.000001.jsonl.gz", manifest.Files[1].File)
+	require.Equal(t, 2, manifest.Files[1].Records)
+	require.Equal(t, []outFileRange{
+		{Topic: "orders", Partition: 0, FirstOffset: 14, LastOffset: 14},
+		{Topic: "orders", Partition: 1, FirstOffset: 13, LastOffset: 13},
+	}, manifest.Files[1].Partitions)
+
+	file, err := os.Open(filepath.Join(dir, "orders.000001.jsonl.gz"))
+	require.NoError(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	lines := 0
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var m consumedMessage
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+		lines++
+	}
+	require.Equal(t, 2, lines)
+}
+
+func TestOutFileWithoutRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-out-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := newOutFile(filepath.Join(dir, "orders.tsv"), 0, "")
+	require.NoError(t, err)
+	msg := &sarama.ConsumerMessage{Topic: "orders", Offset: 3}
+	require.NoError(t, f.write(rawOutput("0\t3\tk\tv\n"), msg))
+	f.close()
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "orders.tsv"))
+	require.NoError(t, err)
+	require.Equal(t, "0\t3\tk\tv\n", string(buf))
+}
+
+func TestNewOutFile(t *testing.T) {
+	f, err := newOutFile("", 0, "")
+	require.NoError(t, err)
+	require.Nil(t, f)
+	f.close()
+
+	_, err = newOutFile("orders.jsonl", 0, "lz4")
+	require.Error(t, err)
+	_, err = newOutFile("orders.jsonl", -1, "")
+	require.Error(t, err)
+}
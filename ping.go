@@ -0,0 +1,380 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+type pingCmd struct {
+	clientConfig
+
+	topic    string
+	duration time.Duration
+	interval time.Duration
+	timeout  time.Duration
+	verbose  bool
+	pretty   bool
+
+	runID    string
+	client   sarama.Client
+	consumer sarama.Consumer
+	producer sarama.SyncProducer
+}
+
+type pingArgs struct {
+	clientArgs
+
+	topic    string
+	duration time.Duration
+	interval time.Duration
+	timeout  time.Duration
+	verbose  bool
+	pretty   bool
+}
+
+// pingResult reports the probes of a partition, or of all partitions if
+// Partition is nil. ProduceMs is the time until the brokers acknowledged a
+// probe and EndToEndMs until it was consumed back.
+type pingResult struct {
+	Partition  *int32        `json:"partition,omitempty"`
+	Sent       int           `json:"sent"`
+	Received   int           `json:"received"`
+	Lost       int           `json:"lost"`
+	Errors     int           `json:"errors"`
+	ProduceMs  *latencyStats `json:"produceMs,omitempty"`
+	EndToEndMs *latencyStats `json:"endToEndMs,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// pingStats collects the latencies of a partition's probes, received tracks
+// the sequence numbers consumed back so redeliveries count once.
+type pingStats struct {
+	sync.Mutex
+	sent     int
+	errors   int
+	received map[int64]bool
+	err      string
+	produce  metrics.Histogram
+	endToEnd metrics.Histogram
+}
+
+func newPingStats() *pingStats {
+	return &pingStats{
+		received: map[int64]bool{},
+		produce:  metrics.NewHistogram(metrics.NewUniformSample(100000)),
+		endToEnd: metrics.NewHistogram(metrics.NewUniformSample(100000)),
+	}
+}
+
+func (s *pingStats) result() pingResult {
+	s.Lock()
+	defer s.Unlock()
+	return pingResult{
+		Sent:       s.sent,
+		Received:   len(s.received),
+		Lost:       s.sent - s.errors - len(s.received),
+		Errors:     s.errors,
+		ProduceMs:  newLatencyStats(s.produce),
+		EndToEndMs: newLatencyStats(s.endToEnd),
+		Error:      s.err,
+	}
+}
+
+func (s *pingStats) pending() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.err == "" && len(s.received) < s.sent-s.errors
+}
+
+func (cmd *pingCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	if cmd.producer, err = sarama.NewSyncProducerFromClient(cmd.client); err != nil {
+		failf("failed to create producer err=%v", err)
+	}
+	defer logClose("producer", cmd.producer)
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.topic, err)
+	}
+	if len(partitions) == 0 {
+		failf("found no partitions for topic %v", cmd.topic)
+	}
+
+	stats := map[int32]*pingStats{}
+	for _, p := range partitions {
+		stats[p] = newPingStats()
+		offset, err := cmd.client.GetOffset(cmd.topic, p, sarama.OffsetNewest)
+		if err != nil {
+			failf("failed to read offset for partition %v err=%v", p, err)
+		}
+		pc, err := cmd.consumer.ConsumePartition(cmd.topic, p, offset)
+		if err != nil {
+			failf("failed to consume partition %v err=%v", p, err)
+		}
+		defer logClose(fmt.Sprintf("partition consumer %v", p), pc)
+		go cmd.receive(pc, stats[p])
+	}
+
+	cmd.send(partitions, stats)
+
+	deadline := time.Now().Add(cmd.timeout)
+	for time.Now().Before(deadline) {
+		pending := false
+		for _, s := range stats {
+			pending = pending || s.pending()
+		}
+		if !pending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	var (
+		total  = pingResult{}
+		totals = map[string]metrics.Histogram{
+			"produce":  metrics.NewHistogram(metrics.NewUniformSample(100000)),
+			"endToEnd": metrics.NewHistogram(metrics.NewUniformSample(100000)),
+		}
+		failed = []string{}
+	)
+	for _, p := range partitions {
+		p := p
+		r := stats[p].result()
+		r.Partition = &p
+		total.Sent += r.Sent
+		total.Received += r.Received
+		total.Lost += r.Lost
+		total.Errors += r.Errors
+		for _, v := range stats[p].produce.Snapshot().Sample().Values() {
+			totals["produce"].Update(v)
+		}
+		for _, v := range stats[p].endToEnd.Snapshot().Sample().Values() {
+			totals["endToEnd"].Update(v)
+		}
+		if r.Lost > 0 || r.Errors > 0 || r.Error != "" {
+			failed = append(failed, fmt.Sprint(p))
+		}
+
+		ctx := printContext{output: r, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+	total.ProduceMs = newLatencyStats(totals["produce"])
+	total.EndToEndMs = newLatencyStats(totals["endToEnd"])
+	ctx := printContext{output: total, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	if len(failed) > 0 {
+		failf("ping failed on partitions %v of topic %v", strings.Join(failed, ","), cmd.topic)
+	}
+}
+
+// pingValue tags a probe with the run's ID, its sequence number and when it
+// was sent, so that concurrent runs and other records aren't counted.
+func (cmd *pingCmd) pingValue(seq int64, sent time.Time) string {
+	return fmt.Sprintf("kt-ping %v %v %v", cmd.runID, seq, sent.UnixNano())
+}
+
+// parsePingValue returns the sequence number and send time of a probe of
+// this run, ok is false for other records.
+func (cmd *pingCmd) parsePingValue(value []byte) (seq int64, sent time.Time, ok bool) {
+	fields := strings.Fields(string(value))
+	if len(fields) != 4 || fields[0] != "kt-ping" || fields[1] != cmd.runID {
+		return 0, sent, false
+	}
+	seq, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, sent, false
+	}
+	nanos, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, sent, false
+	}
+	return seq, time.Unix(0, nanos), true
+}
+
+// send produces a probe every -interval for -duration, to the partitions in
+// turn.
+func (cmd *pingCmd) send(partitions []int32, stats map[int32]*pingStats) {
+	ticker := time.NewTicker(cmd.interval)
+	defer ticker.Stop()
+	end := time.After(cmd.duration)
+	for seq := int64(0); ; seq++ {
+		p := partitions[seq%int64(len(partitions))]
+		start := time.Now()
+		_, _, err := cmd.producer.SendMessage(&sarama.ProducerMessage{
+			Topic:     cmd.topic,
+			Partition: p,
+			Key:       sarama.StringEncoder("kt-ping"),
+			Value:     sarama.StringEncoder(cmd.pingValue(seq, start)),
+		})
+
+		s := stats[p]
+		s.Lock()
+		s.sent++
+		if err != nil {
+			s.errors++
+			if cmd.verbose {
+				fmt.Fprintf(os.Stderr, "failed to produce probe to partition %v err=%v\n", p, err)
+			}
+		} else {
+			s.produce.Update(int64(time.Since(start) / time.Millisecond))
+		}
+		s.Unlock()
+
+		select {
+		case <-end:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cmd *pingCmd) receive(pc sarama.PartitionConsumer, s *pingStats) {
+	for {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				return
+			}
+			seq, sent, ok := cmd.parsePingValue(msg.Value)
+			if !ok {
+				continue
+			}
+			s.Lock()
+			if !s.received[seq] {
+				s.received[seq] = true
+				s.endToEnd.Update(int64(time.Since(sent) / time.Millisecond))
+			}
+			s.Unlock()
+		case err, ok := <-pc.Errors():
+			if !ok {
+				return
+			}
+			s.Lock()
+			s.err = fmt.Sprintf("failed to consume: %v", err)
+			s.Unlock()
+		}
+	}
+}
+
+func (cmd *pingCmd) saramaConfig() *sarama.Config {
+	cfg := cmd.clientConfig.saramaConfig("ping")
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	cfg.Producer.Timeout = cmd.timeout
+
+	return cfg
+}
+
+func (cmd *pingCmd) failStartup(msg string) {
+	failUsage(msg, "kt ping")
+}
+
+func (cmd *pingCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.duration <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid duration argument %v, expected a positive duration.", args.duration))
+	}
+	if args.interval <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid interval argument %v, expected a positive duration.", args.interval))
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.duration = args.duration
+	cmd.interval = args.interval
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	cmd.runID = randomString(16)
+}
+
+func (cmd *pingCmd) parseFlags(as []string) pingArgs {
+	var args pingArgs
+	flags := flag.NewFlagSet("ping", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Topic to send the probes to (required).")
+	args.clientArgs.addFlags(flags)
+	flags.DurationVar(&args.duration, "duration", 10*time.Second, "How long to send probes for.")
+	flags.DurationVar(&args.interval, "interval", 100*time.Millisecond, "Time between probes, which go to the partitions in turn.")
+	flags.DurationVar(&args.timeout, "timeout", 10*time.Second, "Time to wait for the last probes to be consumed, and for a probe to be acknowledged.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of ping:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, pingDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var pingDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+ping produces a probe record with key "kt-ping" every -interval for
+-duration, to the partitions of -topic in turn, and consumes them back. It
+prints per partition how many probes were sent, received, lost and failed to
+produce, with the count, min, max, mean, p50 and p99 of the time until a
+probe was acknowledged (produceMs) and until it was consumed (endToEndMs),
+followed by the same for all partitions. It exits with status 1 if any probe
+was lost or failed, e.g. to verify a cluster after a maintenance window.
+
+Where canary checks each partition once, ping measures latencies over time.
+Consumers of the topic see the probes, so use a topic that tolerates them.
+
+  $ kt ping -topic canary -duration 1m -interval 50ms`
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPingParseArgs(t *testing.T) {
+	os.Setenv("KT_TOPIC", "")
+	os.Setenv("KT_BROKERS", "")
+
+	target := &pingCmd{}
+	target.parseArgs([]string{"-topic", "canary", "-duration", "1m", "-interval", "50ms"})
+	require.Equal(t, "canary", target.topic)
+	require.Equal(t, []string{"localhost:9092"}, target.brokers)
+	require.Equal(t, time.Minute, target.duration)
+	require.Equal(t, 50*time.Millisecond, target.interval)
+	require.Equal(t, 10*time.Second, target.timeout)
+	require.Equal(t, 16, len(target.runID))
+}
+
+func TestPingValue(t *testing.T) {
+	a := &pingCmd{runID: "a"}
+	b := &pingCmd{runID: "b"}
+	sent := time.Unix(1700000000, 123456789)
+
+	seq, actual, ok := a.parsePingValue([]byte(a.pingValue(7, sent)))
+	require.True(t, ok)
+	require.Equal(t, int64(7), seq)
+	require.True(t, sent.Equal(actual))
+
+	_, _, ok = b.parsePingValue([]byte(a.pingValue(7, sent)))
+	require.False(t, ok)
+	_, _, ok = a.parsePingValue([]byte("hans"))
+	require.False(t, ok)
+}
+
+func TestPingStats(t *testing.T) {
+	s := newPingStats()
+	s.sent = 3
+	s.errors = 1
+	s.received[0] = true
+	s.produce.Update(2)
+	s.produce.Update(4)
+	s.endToEnd.Update(5)
+	require.True(t, s.pending())
+
+	r := s.result()
+	require.Equal(t, 1, r.Lost)
+	require.Equal(t, int64(2), r.ProduceMs.Count)
+	require.Equal(t, 3.0, r.ProduceMs.Mean)
+	require.Equal(t, int64(5), r.EndToEndMs.Max)
+
+	s.received[2] = true
+	require.False(t, s.pending())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// -pipeline makes "kt consume ... | kt produce ..." behave as one streaming
+// pipeline. The commands don't share more than the pipe, so the handshake is
+// in how each end treats it: consume writes every message through to the
+// pipe before marking its offset and stops at a broken pipe without
+// committing what it couldn't write, produce reads until consume closes the
+// pipe, even after an interrupt, and drops a last line that was cut short.
+
+// ignoreSIGPIPE turns writes to a closed pipe into errors, rather than
+// killing kt, so consume -pipeline gets to report them.
+func ignoreSIGPIPE() {
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+}
+
+// flushPipeline writes the messages printed so far through to the pipe, so
+// that the offsets marked next only cover messages that left kt. It exits
+// without committing the offsets marked since the last commit if the
+// downstream command is gone.
+func (cmd *consumeCmd) flushPipeline() {
+	if !cmd.pipeline {
+		return
+	}
+	if err := stdout.Flush(); err != nil {
+		failf("downstream closed the pipe, stopping without committing the offsets since the last commit err=%v", err)
+	}
+}
+
+// listenForPipelineInterrupt lets produce -pipeline read the rest of its input
+// after the first SIGINT or SIGTERM. In a shell, the upstream consume gets the
+// same signal and closes the pipe after its last message. A second signal
+// closes q like listenForInterrupt.
+func listenForPipelineInterrupt(q chan struct{}) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	fmt.Fprintf(os.Stderr, "received signal %s, producing the remaining input until it ends - interrupt again to stop now\n", sig)
+	sig = <-signals
+	fmt.Fprintf(os.Stderr, "received signal %s\n", sig)
+	close(q)
+}
+
+// scanPipelineLines is like scanLines but drops a last line without a
+// newline, which is what's left when the upstream command was killed while
+// writing it.
+func scanPipelineLines(r io.Reader, name string, max int, out chan input) error {
+	var truncated []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, max), max)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) > 0 && bytes.IndexByte(data, '\n') < 0 {
+			truncated = append([]byte{}, data...)
+			return len(data), nil, nil
+		}
+		return bufio.ScanLines(data, atEOF)
+	})
+
+	line := 1
+	for ; scanner.Scan(); line++ {
+		out <- input{text: scanner.Text(), file: name, line: line}
+	}
+	if len(truncated) > 0 {
+		fmt.Fprintf(os.Stderr, "dropped incomplete last line %v of %v: %q\n", line, name, truncated)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanPipelineLines(t *testing.T) {
+	data := []struct {
+		testName string
+		given    string
+		expected []string
+	}{
+		{testName: "complete", given: "{\"value\":\"a\"}\n{\"value\":\"b\"}\n", expected: []string{`{"value":"a"}`, `{"value":"b"}`}},
+		{testName: "truncated", given: "{\"value\":\"a\"}\n{\"val", expected: []string{`{"value":"a"}`}},
+		{testName: "crlf", given: "a\r\nb\r\n", expected: []string{"a", "b"}},
+		{testName: "only-truncated", given: "{\"val", expected: []string{}},
+		{testName: "empty", given: "", expected: []string{}},
+	}
+
+	for _, d := range data {
+		t.Run(d.testName, func(t *testing.T) {
+			out := make(chan input, 10)
+			require.Nil(t, scanPipelineLines(strings.NewReader(d.given), "stdin", 1024, out))
+			close(out)
+			actual := []string{}
+			for i := range out {
+				require.Equal(t, len(actual)+1, i.line)
+				actual = append(actual, i.text)
+			}
+			require.Equal(t, d.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+// Package codec converts between the bytes of Kafka keys, values and headers
+// and their representation in kt's input and output, e.g. hex strings or
+// decoded JSON. Encodings are a codec name like json or a chain like
+// gzip+json.
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec converts between the bytes of keys and values and their
+// representation. Decode turns record bytes into what consume prints, Encode
+// turns what produce reads into record bytes. Codecs that wrap other
+// formats, like gzip, decode to []byte and can be followed by the codec of
+// their contents, e.g. gzip+json.
+type Codec interface {
+	Name() string
+	Decode(data []byte) (interface{}, error)
+	Encode(input []byte) ([]byte, error)
+}
+
+var (
+	codecs         = map[string]Codec{}
+	wrappingCodecs = map[string]bool{}
+)
+
+// Register adds c to the encodings that ParseChain accepts, wraps marks
+// codecs that can be followed by another one.
+func Register(c Codec, wraps bool) {
+	codecs[c.Name()] = c
+	wrappingCodecs[c.Name()] = wraps
+}
+
+func init() {
+	Register(String{}, false)
+	Register(Hex{}, false)
+	Register(Base64{}, false)
+	Register(JSON{}, false)
+	Register(Gzip{}, true)
+	Register(&Zstd{}, true)
+}
+
+// Names lists the registered codecs for error messages.
+func Names() string {
+	names := make([]string, 0, len(codecs))
+	for n := range codecs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+type String struct{}
+
+func (String) Name() string                            { return "string" }
+func (String) Decode(data []byte) (interface{}, error) { return string(data), nil }
+func (String) Encode(input []byte) ([]byte, error)     { return input, nil }
+
+type Hex struct{}
+
+func (Hex) Name() string                            { return "hex" }
+func (Hex) Decode(data []byte) (interface{}, error) { return hex.EncodeToString(data), nil }
+func (Hex) Encode(input []byte) ([]byte, error)     { return hex.DecodeString(string(input)) }
+
+type Base64 struct{}
+
+func (Base64) Name() string { return "base64" }
+func (Base64) Decode(data []byte) (interface{}, error) {
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+func (Base64) Encode(input []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(string(input))
+}
+
+// JSON decodes JSON values rather than printing them as a string, and
+// encodes the JSON of the input value compacted.
+type JSON struct{}
+
+func (JSON) Name() string { return "json" }
+
+func (JSON) Decode(data []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+func (JSON) Encode(input []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, input); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type Gzip struct{}
+
+func (Gzip) Name() string { return "gzip" }
+
+func (Gzip) Decode(data []byte) (interface{}, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (Gzip) Encode(input []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(input); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Zstd is for values that producers compressed with zstd themselves, without
+// dictionary.
+type Zstd struct {
+	once    sync.Once
+	decoder *zstd.Decoder
+	encoder *zstd.Encoder
+	err     error
+}
+
+func (*Zstd) Name() string { return "zstd" }
+
+func (c *Zstd) setup() error {
+	c.once.Do(func() {
+		if c.decoder, c.err = zstd.NewReader(nil); c.err != nil {
+			return
+		}
+		c.encoder, c.err = zstd.NewWriter(nil)
+	})
+	return c.err
+}
+
+func (c *Zstd) Decode(data []byte) (interface{}, error) {
+	if err := c.setup(); err != nil {
+		return nil, err
+	}
+	return c.decoder.DecodeAll(data, nil)
+}
+
+func (c *Zstd) Encode(input []byte) ([]byte, error) {
+	if err := c.setup(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(input, nil), nil
+}
+
+// Chain is an encoding like gzip+json, a wrapping codec followed by the
+// codec of its contents. Decoding applies the codecs left to right and
+// encoding right to left.
+type Chain []Codec
+
+// ParseChain parses an encoding of registered codecs joined by +.
+func ParseChain(s string) (Chain, error) {
+	names := strings.Split(s, "+")
+	chain := Chain{}
+	for i, n := range names {
+		c, ok := codecs[n]
+		if !ok {
+			return nil, fmt.Errorf("unsupported encoding %#v, only %v and chains like gzip+json are supported", n, Names())
+		}
+		if i < len(names)-1 && !wrappingCodecs[n] {
+			return nil, fmt.Errorf("%v doesn't wrap other formats, it can only be last in %#v", n, s)
+		}
+		chain = append(chain, c)
+	}
+	return chain, nil
+}
+
+// Text returns whether the chain is a single string, hex or base64 codec,
+// whose output is a string of the data rather than decoded data.
+func (c Chain) Text() bool {
+	if len(c) != 1 {
+		return false
+	}
+	n := c[0].Name()
+	return n == "string" || n == "hex" || n == "base64"
+}
+
+// JSON returns whether the chain's input is JSON, i.e. produce input values
+// are JSON values rather than strings.
+func (c Chain) JSON() bool {
+	return len(c) > 0 && c[len(c)-1].Name() == "json"
+}
+
+// Decode applies the codecs to data. Strings, including the data that a
+// wrapping codec ends with, are returned as *string.
+func (c Chain) Decode(data []byte) (interface{}, error) {
+	var v interface{} = data
+	for i, codec := range c {
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%v doesn't decode to bytes", c[i-1].Name())
+		}
+		var err error
+		if v, err = codec.Decode(b); err != nil && len(c) > 1 {
+			return nil, fmt.Errorf("failed to decode %v err=%v", codec.Name(), err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	switch v := v.(type) {
+	case []byte:
+		s := string(v)
+		return &s, nil
+	case string:
+		return &v, nil
+	}
+	return v, nil
+}
+
+func (c Chain) Encode(input []byte) ([]byte, error) {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		if input, err = c[i].Encode(input); err != nil && len(c) > 1 {
+			return nil, fmt.Errorf("failed to encode %v err=%v", c[i].Name(), err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+// Format renders data with the string, hex or base64 codec, other encodings
+// render it as a string until it's decoded. nil stays nil.
+func Format(data []byte, encoding string) *string {
+	if data == nil {
+		return nil
+	}
+
+	c, ok := codecs[encoding]
+	if !ok || !(Chain{c}).Text() {
+		c = String{}
+	}
+	v, _ := c.Decode(data)
+	str := v.(string)
+	return &str
+}
@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChain(t *testing.T) {
+	c, err := ParseChain("gzip+json")
+	require.Nil(t, err)
+	require.Equal(t, Chain{Gzip{}, JSON{}}, c)
+	require.True(t, c.JSON())
+	require.False(t, c.Text())
+
+	c, err = ParseChain("hex")
+	require.Nil(t, err)
+	require.True(t, c.Text())
+
+	for _, s := range []string{"", "avro", "json+gzip", "gzip+", "msgpack"} {
+		_, err = ParseChain(s)
+		require.NotNil(t, err, s)
+	}
+}
+
+func TestChainRoundTrip(t *testing.T) {
+	for _, enc := range []string{"gzip+json", "zstd+json", "gzip+zstd+json"} {
+		c, err := ParseChain(enc)
+		require.Nil(t, err, enc)
+
+		data, err := c.Encode([]byte(`{"id": 23, "tags": ["a"]}`))
+		require.Nil(t, err, enc)
+		v, err := c.Decode(data)
+		require.Nil(t, err, enc)
+		require.Equal(t, map[string]interface{}{"id": json.Number("23"), "tags": []interface{}{"a"}}, v, enc)
+	}
+
+	c, _ := ParseChain("gzip")
+	data, err := c.Encode([]byte("hello"))
+	require.Nil(t, err)
+	v, err := c.Decode(data)
+	require.Nil(t, err)
+	require.Equal(t, "hello", *v.(*string))
+
+	_, err = c.Decode([]byte("not gzip"))
+	require.NotNil(t, err)
+
+	c, _ = ParseChain("json")
+	_, err = c.Encode([]byte("{nope"))
+	require.NotNil(t, err)
+	_, err = c.Decode([]byte(`{} {}`))
+	require.NotNil(t, err)
+}
+
+func TestFormat(t *testing.T) {
+	require.Equal(t, "4142", *Format([]byte("AB"), "hex"))
+	require.Equal(t, "QUI=", *Format([]byte("AB"), "base64"))
+	require.Equal(t, `{"a":1}`, *Format([]byte(`{"a":1}`), "gzip+json"))
+	require.Nil(t, Format(nil, "string"))
+}
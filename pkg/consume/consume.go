@@ -0,0 +1,257 @@
+// Package consume reads the messages of a topic and decodes their keys and
+// values like kt consume, for tools that embed kt rather than run it:
+//
+//	out := make(chan consume.Message)
+//	go func() {
+//		for m := range out {
+//			fmt.Println(m.Offset, m.Value)
+//		}
+//	}()
+//	err := consume.Consume(ctx, consume.Options{
+//		Brokers:       []string{"localhost:9092"},
+//		Topic:         "orders",
+//		ValueEncoding: "gzip+json",
+//		UntilEnd:      true,
+//	}, out)
+//	close(out)
+package consume
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/fgeller/kt/pkg/codec"
+)
+
+// Options configures Consume.
+type Options struct {
+	Brokers []string
+	Topic   string
+
+	// Partitions to consume, defaults to all partitions of Topic.
+	Partitions []int32
+
+	// Start is the offset to start consuming each partition at, or
+	// sarama.OffsetNewest for only messages produced from now on. Partitions
+	// whose oldest offset is after Start start at their oldest offset, so
+	// the zero value consumes from the beginning.
+	Start int64
+
+	// UntilEnd stops consuming each partition at its newest message when
+	// consuming started, otherwise Consume runs until the context is done.
+	UntilEnd bool
+
+	// KeyEncoding and ValueEncoding are codec encodings like kt consume's
+	// -encodekey and -encodevalue, e.g. hex or gzip+json. Both default to
+	// string.
+	KeyEncoding   string
+	ValueEncoding string
+
+	// Config of the client, e.g. for TLS and SASL, defaults to
+	// sarama.NewConfig().
+	Config *sarama.Config
+}
+
+// Message is a consumed message with key and value decoded. It marshals to
+// JSON like kt consume prints messages.
+type Message struct {
+	Topic     string                 `json:"topic,omitempty"`
+	Partition int32                  `json:"partition"`
+	Offset    int64                  `json:"offset"`
+	Key       interface{}            `json:"key"`
+	Value     interface{}            `json:"value"`
+	Headers   map[string]interface{} `json:"headers,omitempty"`
+	Timestamp *time.Time             `json:"timestamp,omitempty"`
+
+	// DecodeErr is why the key or value failed to decode, they're base64
+	// strings then.
+	DecodeErr error `json:"-"`
+	// Raw is the message as it was consumed.
+	Raw *sarama.ConsumerMessage `json:"-"`
+}
+
+// Decoder decodes consumed messages with a key and a value encoding.
+type Decoder struct {
+	keyEncoding   string
+	valueEncoding string
+	key           codec.Chain
+	value         codec.Chain
+}
+
+// NewDecoder returns a Decoder for key and value encodings like hex or
+// gzip+json, empty encodings are string.
+func NewDecoder(keyEncoding, valueEncoding string) (*Decoder, error) {
+	d := &Decoder{keyEncoding: keyEncoding, valueEncoding: valueEncoding}
+	for _, e := range []*string{&d.keyEncoding, &d.valueEncoding} {
+		if *e == "" {
+			*e = "string"
+		}
+	}
+
+	var err error
+	if d.key, err = codec.ParseChain(d.keyEncoding); err != nil {
+		return nil, fmt.Errorf("invalid key encoding err=%v", err)
+	}
+	if d.value, err = codec.ParseChain(d.valueEncoding); err != nil {
+		return nil, fmt.Errorf("invalid value encoding err=%v", err)
+	}
+	return d, nil
+}
+
+// Decode decodes the key and value of msg. Headers are strings. Keys and
+// values that fail to decode are base64 strings and DecodeErr says why.
+func (d *Decoder) Decode(msg *sarama.ConsumerMessage) Message {
+	m := Message{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Raw:       msg,
+	}
+	m.Key, m.DecodeErr = d.decode(d.key, msg.Key, "key")
+	var err error
+	if m.Value, err = d.decode(d.value, msg.Value, "value"); err != nil && m.DecodeErr == nil {
+		m.DecodeErr = err
+	}
+
+	if len(msg.Headers) > 0 {
+		m.Headers = map[string]interface{}{}
+		for _, h := range msg.Headers {
+			m.Headers[string(h.Key)] = codec.Format(h.Value, "string")
+		}
+	}
+	if !msg.Timestamp.IsZero() {
+		m.Timestamp = &msg.Timestamp
+	}
+	return m
+}
+
+func (d *Decoder) decode(c codec.Chain, data []byte, what string) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	v, err := c.Decode(data)
+	if err != nil {
+		return codec.Format(data, "base64"), fmt.Errorf("failed to decode %v err=%v", what, err)
+	}
+	return v, nil
+}
+
+// Consume sends the messages of opts.Topic to out, decoded per
+// opts.KeyEncoding and opts.ValueEncoding, until ctx is done or with
+// opts.UntilEnd all partitions were consumed. It doesn't close out. Messages
+// of a partition are sent in order, those of different partitions are
+// interleaved. Consume returns the first error that stopped a partition,
+// or nil.
+func Consume(ctx context.Context, opts Options, out chan<- Message) error {
+	dec, err := NewDecoder(opts.KeyEncoding, opts.ValueEncoding)
+	if err != nil {
+		return err
+	}
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = sarama.NewConfig()
+	}
+
+	client, err := sarama.NewClient(opts.Brokers, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create client err=%v", err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer err=%v", err)
+	}
+	defer consumer.Close()
+
+	partitions := opts.Partitions
+	if len(partitions) == 0 {
+		if partitions, err = client.Partitions(opts.Topic); err != nil {
+			return fmt.Errorf("failed to read partitions of topic %v err=%v", opts.Topic, err)
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, p := range partitions {
+		wg.Add(1)
+		go func(p int32) {
+			defer wg.Done()
+			if err := consumePartition(ctx, client, consumer, dec, opts, p, out); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}(p)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func consumePartition(ctx context.Context, client sarama.Client, consumer sarama.Consumer, dec *Decoder, opts Options, p int32, out chan<- Message) error {
+	start, end, err := partitionRange(client, opts, p)
+	if err != nil {
+		return err
+	}
+	if opts.UntilEnd && start > end {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(opts.Topic, p, start)
+	if err != nil {
+		return fmt.Errorf("failed to consume partition %v of topic %v err=%v", p, opts.Topic, err)
+	}
+	defer pc.Close()
+
+	part := NewPartition(opts.Topic, p, start, -1)
+	if opts.UntilEnd {
+		part.End, part.Client = end, client
+	}
+	stop, err := part.Consume(ctx, pc, func(msg *sarama.ConsumerMessage) bool {
+		select {
+		case out <- dec.Decode(msg):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	if stop == Failed {
+		return fmt.Errorf("failed to consume partition %v of topic %v err=%v", p, opts.Topic, err)
+	}
+	return nil
+}
+
+// partitionRange resolves the offset to start partition p at and the offset
+// of its newest message.
+func partitionRange(client sarama.Client, opts Options, p int32) (start, end int64, err error) {
+	oldest, err := client.GetOffset(opts.Topic, p, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read oldest offset of partition %v of topic %v err=%v", p, opts.Topic, err)
+	}
+	newest, err := client.GetOffset(opts.Topic, p, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read newest offset of partition %v of topic %v err=%v", p, opts.Topic, err)
+	}
+
+	switch {
+	case opts.Start == sarama.OffsetNewest:
+		start = newest
+	case opts.Start < oldest:
+		start = oldest
+	default:
+		start = opts.Start
+	}
+	return start, newest - 1, nil
+}
@@ -0,0 +1,64 @@
+package consume
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecoder(t *testing.T) {
+	_, err := NewDecoder("", "msgpack")
+	require.NotNil(t, err)
+
+	dec, err := NewDecoder("hex", "json")
+	require.Nil(t, err)
+	msg := &sarama.ConsumerMessage{
+		Topic:   "orders",
+		Offset:  3,
+		Key:     []byte("AB"),
+		Value:   []byte(`{"id":23}`),
+		Headers: []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("t1")}},
+	}
+	m := dec.Decode(msg)
+	require.Nil(t, m.DecodeErr)
+	require.Equal(t, "4142", *m.Key.(*string))
+	require.Equal(t, map[string]interface{}{"id": json.Number("23")}, m.Value)
+	require.Equal(t, "t1", *m.Headers["trace"].(*string))
+	require.Nil(t, m.Timestamp)
+	require.Equal(t, msg, m.Raw)
+
+	m = dec.Decode(&sarama.ConsumerMessage{Value: []byte("{nope")})
+	require.NotNil(t, m.DecodeErr)
+	require.Nil(t, m.Key)
+	require.Equal(t, "e25vcGU=", *m.Value.(*string))
+}
+
+func TestConsumeUntilEnd(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, sarama.OffsetOldest, 0).
+			SetOffset("orders", 0, sarama.OffsetNewest, 2),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetMessage("orders", 0, 0, sarama.StringEncoder("a")).
+			SetMessage("orders", 0, 1, sarama.StringEncoder("b")),
+	})
+
+	out := make(chan Message, 10)
+	err := Consume(context.Background(), Options{Brokers: []string{broker.Addr()}, Topic: "orders", UntilEnd: true}, out)
+	require.Nil(t, err)
+	close(out)
+
+	values := []string{}
+	for m := range out {
+		values = append(values, *m.Value.(*string))
+	}
+	require.Equal(t, []string{"a", "b"}, values)
+}
@@ -0,0 +1,214 @@
+package consume
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Source is what Partition consumes from, e.g. a sarama.PartitionConsumer or
+// sarama.ConsumerGroupClaim. If it has an Errors channel like
+// sarama.PartitionConsumer, consuming stops at its first error.
+type Source interface {
+	Messages() <-chan *sarama.ConsumerMessage
+	HighWaterMarkOffset() int64
+}
+
+// Stop says why Partition.Consume returned.
+type Stop int
+
+const (
+	// Ended means the message at End was consumed or nothing's left up to
+	// End.
+	Ended Stop = iota
+	// Canceled means the context is done.
+	Canceled
+	// TimedOut means no message arrived for Timeout.
+	TimedOut
+	// Stopped means the handler returned false.
+	Stopped
+	// Closed means the source closed its messages channel.
+	Closed
+	// Failed means the source sent an error, Consume returns it.
+	Failed
+)
+
+// EndCheckInterval is how long a partition with Client set may be idle
+// before Consume checks whether anything's left to consume up to its end.
+var EndCheckInterval = time.Second
+
+// Partition is where consuming a partition is at. Consume continues from
+// there, so a partition can be consumed in turns from different sources.
+type Partition struct {
+	Topic     string
+	Partition int32
+
+	// Next is the offset of the next message, End the offset of the last
+	// message to consume or -1 to consume until stopped.
+	Next int64
+	End  int64
+
+	// Count is the number of messages consumed and Last the offset of the
+	// last one, or -1.
+	Count int
+	Last  int64
+
+	// Timeout stops consuming once no message arrived for that long, zero
+	// waits forever.
+	Timeout time.Duration
+
+	// Client, if set, is used to check whether anything's left up to End
+	// when no messages arrive, for ends that are known to exist like the
+	// newest offset when consuming started. The records up to End may all be
+	// transaction markers or of aborted transactions, which consumers skip.
+	Client sarama.Client
+}
+
+// NewPartition returns a Partition that starts consuming at next and stops
+// after end, or with end -1 consumes until stopped.
+func NewPartition(topic string, partition int32, next, end int64) *Partition {
+	return &Partition{Topic: topic, Partition: partition, Next: next, End: end, Last: -1}
+}
+
+// Consume passes the messages of src to handle until the partition ends,
+// ctx is done or handle returns false. A message counts as consumed only if
+// handle returns true. The error is the one src sent if it Failed.
+func (p *Partition) Consume(ctx context.Context, src Source, handle func(*sarama.ConsumerMessage) bool) (Stop, error) {
+	var errs <-chan *sarama.ConsumerError
+	if s, ok := src.(interface {
+		Errors() <-chan *sarama.ConsumerError
+	}); ok {
+		errs = s.Errors()
+	}
+
+	var (
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+	if p.Timeout > 0 {
+		timer = time.NewTimer(p.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var idle <-chan time.Time
+	if p.Client != nil && p.End >= 0 {
+		t := time.NewTicker(EndCheckInterval)
+		defer t.Stop()
+		idle = t.C
+	}
+	checked := p.Next
+
+	for {
+		select {
+		case <-ctx.Done():
+			return Canceled, nil
+		case <-idle:
+			// checks don't count as activity for Timeout.
+			if p.Next == checked && p.reachedEnd(src) {
+				return Ended, nil
+			}
+			checked = p.Next
+		case <-timeout:
+			return TimedOut, nil
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			return Failed, err
+		case msg, ok := <-src.Messages():
+			if !ok {
+				return Closed, nil
+			}
+			if !handle(msg) {
+				return Stopped, nil
+			}
+			p.Count, p.Last, p.Next = p.Count+1, msg.Offset, msg.Offset+1
+			if p.End >= 0 && msg.Offset >= p.End {
+				return Ended, nil
+			}
+			if timer != nil {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(p.Timeout)
+			}
+		}
+	}
+}
+
+// reachedEnd returns whether nothing's left to consume from Next up to End,
+// as the high water mark of src isn't past Next or the records up to End are
+// all skipped by consumers.
+func (p *Partition) reachedEnd(src Source) bool {
+	if hwm := src.HighWaterMarkOffset(); hwm > 0 && hwm <= p.Next {
+		return true
+	}
+	return OnlySkipped(p.Client, p.Topic, p.Partition, p.Next, p.End)
+}
+
+// OnlySkipped returns whether the records of the partition from offset up to
+// end are all transaction markers or, with read_committed, of aborted
+// transactions, which consumers skip, e.g. when a transaction's commit marker
+// is the partition's last record. It fetches once from offset, and returns
+// false if that fails or doesn't reach end.
+func OnlySkipped(client sarama.Client, topic string, partition int32, offset, end int64) bool {
+	cfg := client.Config()
+	// transactions need Kafka 0.11.0.0 or later.
+	if !cfg.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return false
+	}
+	broker, err := client.Leader(topic, partition)
+	if err != nil {
+		return false
+	}
+	req := &sarama.FetchRequest{
+		Version:     4,
+		MaxWaitTime: int32(cfg.Consumer.MaxWaitTime / time.Millisecond),
+		MinBytes:    1,
+		MaxBytes:    sarama.MaxResponseSize,
+		Isolation:   cfg.Consumer.IsolationLevel,
+	}
+	req.AddBlock(topic, partition, offset, cfg.Consumer.Fetch.Default, -1)
+	resp, err := broker.Fetch(req)
+	if err != nil {
+		return false
+	}
+	block := resp.GetBlock(topic, partition)
+	if block == nil || block.Err != sarama.ErrNoError {
+		return false
+	}
+
+	aborted := block.AbortedTransactions
+	sort.Slice(aborted, func(i, j int) bool { return aborted[i].FirstOffset < aborted[j].FirstOffset })
+	aborting := map[int64]bool{}
+	next := offset
+	for _, records := range block.RecordsSet {
+		batch := records.RecordBatch
+		if batch == nil {
+			return false
+		}
+		for cfg.Consumer.IsolationLevel == sarama.ReadCommitted && len(aborted) > 0 && aborted[0].FirstOffset <= batch.LastOffset() {
+			aborting[aborted[0].ProducerID] = true
+			aborted = aborted[1:]
+		}
+		skip := batch.Control || batch.IsTransactional && aborting[batch.ProducerID]
+		for _, rec := range batch.Records {
+			if o := batch.FirstOffset + rec.OffsetDelta; !skip && o >= offset && o <= end {
+				return false
+			}
+			// the abort marker ends the producer's aborted transaction.
+			if batch.Control && len(rec.Key) >= 4 && binary.BigEndian.Uint16(rec.Key[2:4]) == 0 {
+				delete(aborting, batch.ProducerID)
+			}
+		}
+		if n := batch.LastOffset() + 1; n > next {
+			next = n
+		}
+	}
+	return next > end
+}
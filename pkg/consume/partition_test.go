@@ -0,0 +1,102 @@
+package consume
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+type tSource struct {
+	messages chan *sarama.ConsumerMessage
+	errors   chan *sarama.ConsumerError
+	hwm      int64
+}
+
+func (s tSource) Messages() <-chan *sarama.ConsumerMessage { return s.messages }
+func (s tSource) Errors() <-chan *sarama.ConsumerError     { return s.errors }
+func (s tSource) HighWaterMarkOffset() int64               { return s.hwm }
+
+func TestPartitionConsume(t *testing.T) {
+	src := tSource{messages: make(chan *sarama.ConsumerMessage, 3), errors: make(chan *sarama.ConsumerError, 1)}
+	src.messages <- &sarama.ConsumerMessage{Offset: 3}
+	src.messages <- &sarama.ConsumerMessage{Offset: 4}
+
+	p := NewPartition("orders", 0, 3, 5)
+	stop, err := p.Consume(context.Background(), src, func(msg *sarama.ConsumerMessage) bool { return msg.Offset < 4 })
+	require.Nil(t, err)
+	require.Equal(t, Stopped, stop)
+	require.Equal(t, 1, p.Count)
+	require.Equal(t, int64(3), p.Last)
+	require.Equal(t, int64(4), p.Next)
+
+	// a later turn continues where the last one stopped.
+	src.messages <- &sarama.ConsumerMessage{Offset: 4}
+	src.messages <- &sarama.ConsumerMessage{Offset: 5}
+	stop, err = p.Consume(context.Background(), src, func(*sarama.ConsumerMessage) bool { return true })
+	require.Nil(t, err)
+	require.Equal(t, Ended, stop)
+	require.Equal(t, 3, p.Count)
+	require.Equal(t, int64(6), p.Next)
+
+	p.Timeout = 10 * time.Millisecond
+	stop, _ = p.Consume(context.Background(), src, func(*sarama.ConsumerMessage) bool { return true })
+	require.Equal(t, TimedOut, stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Timeout = 0
+	stop, _ = p.Consume(ctx, src, func(*sarama.ConsumerMessage) bool { return true })
+	require.Equal(t, Canceled, stop)
+
+	src.errors <- &sarama.ConsumerError{Topic: "orders", Err: sarama.ErrOffsetOutOfRange}
+	stop, err = p.Consume(context.Background(), src, func(*sarama.ConsumerMessage) bool { return true })
+	require.Equal(t, Failed, stop)
+	require.NotNil(t, err)
+
+	close(src.messages)
+	stop, _ = p.Consume(context.Background(), src, func(*sarama.ConsumerMessage) bool { return true })
+	require.Equal(t, Closed, stop)
+}
+
+func TestPartitionConsumeSkippedEnd(t *testing.T) {
+	defer func(d time.Duration) { EndCheckInterval = d }(EndCheckInterval)
+	EndCheckInterval = 10 * time.Millisecond
+
+	// offset 2 before the high water mark 3 is a commit marker, so the
+	// consumer never passes on the end offset.
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetch.AddRecordBatch("orders", 0, nil, sarama.StringEncoder("a"), 0, 7, true)
+	fetch.AddRecordBatch("orders", 0, nil, sarama.StringEncoder("b"), 1, 7, true)
+	fetch.AddControlRecord("orders", 0, 2, 7, sarama.ControlRecordCommit)
+	fetch.GetBlock("orders", 0).HighWaterMarkOffset = 3
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, cfg)
+	require.Nil(t, err)
+	defer client.Close()
+
+	require.True(t, OnlySkipped(client, "orders", 0, 2, 2))
+	require.False(t, OnlySkipped(client, "orders", 0, 1, 2))
+
+	src := tSource{messages: make(chan *sarama.ConsumerMessage, 2), hwm: 3}
+	src.messages <- &sarama.ConsumerMessage{Offset: 0}
+	src.messages <- &sarama.ConsumerMessage{Offset: 1}
+	p := NewPartition("orders", 0, 0, 2)
+	p.Client = client
+	stop, err := p.Consume(context.Background(), src, func(*sarama.ConsumerMessage) bool { return true })
+	require.Nil(t, err)
+	require.Equal(t, Ended, stop)
+	require.Equal(t, 2, p.Count)
+}
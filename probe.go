@@ -0,0 +1,327 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// probeCmd dispatches the probes of kt probe.
+type probeCmd struct{}
+
+func (cmd *probeCmd) run(args []string) {
+	if len(args) > 0 && args[0] == "acl" {
+		(&probeACLCmd{}).run(args[1:])
+		return
+	}
+	exitf(exitUsage, "unknown probe, use \"kt probe acl -help\" for more information")
+}
+
+type probeACLCmd struct {
+	clientConfig
+
+	topic           string
+	partition       int32
+	abort           bool
+	transactionalID string
+	verbose         bool
+	pretty          bool
+
+	client sarama.Client
+}
+
+type probeACLArgs struct {
+	clientArgs
+
+	topic           string
+	partition       int
+	abort           bool
+	transactionalID string
+	verbose         bool
+	pretty          bool
+}
+
+// probeResult is the outcome of one operation of kt probe acl: allowed,
+// denied if the brokers rejected the credentials or ACLs, failed for other
+// errors, or skipped as an earlier operation it depends on wasn't allowed.
+type probeResult struct {
+	Operation string `json:"operation"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type probeReport struct {
+	Topic      string        `json:"topic"`
+	Partition  int32         `json:"partition"`
+	Principal  string        `json:"principal,omitempty"`
+	Operations []probeResult `json:"operations"`
+}
+
+func newProbeResult(operation string, err error) probeResult {
+	switch {
+	case err == nil:
+		return probeResult{Operation: operation, Status: "allowed"}
+	case errorExitCode(err) == exitAuth:
+		return probeResult{Operation: operation, Status: "denied", Error: err.Error()}
+	}
+	return probeResult{Operation: operation, Status: "failed", Error: err.Error()}
+}
+
+func skippedProbe(operation, reason string) probeResult {
+	return probeResult{Operation: operation, Status: "skipped", Error: reason}
+}
+
+// exitCode is exitAuth if any operation was denied, exitFailure if any
+// failed otherwise, 0 if all were allowed.
+func (r probeReport) exitCode() int {
+	code := 0
+	for _, o := range r.Operations {
+		switch o.Status {
+		case "denied":
+			return exitAuth
+		case "failed":
+			code = exitFailure
+		}
+	}
+	return code
+}
+
+func (cmd *probeACLCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	report := probeReport{Topic: cmd.topic, Partition: cmd.partition, Principal: cmd.sasl.user}
+	describe := cmd.describe()
+	report.Operations = append(report.Operations, describe)
+	if describe.Status != "allowed" {
+		if cmd.abort {
+			report.Operations = append(report.Operations, skippedProbe("transaction", "requires describe"))
+		}
+		report.Operations = append(report.Operations, skippedProbe("write", "requires describe"), skippedProbe("read", "requires describe"))
+	} else {
+		report.Operations = append(report.Operations, cmd.write()...)
+		report.Operations = append(report.Operations, cmd.read())
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: report, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	if code := report.exitCode(); code != 0 {
+		exitf(code, "not all operations on partition %v of topic %v are allowed", cmd.partition, cmd.topic)
+	}
+}
+
+// describe reads the topic's metadata and checks that the partition exists.
+func (cmd *probeACLCmd) describe() probeResult {
+	if err := cmd.client.RefreshMetadata(cmd.topic); err != nil {
+		return newProbeResult("describe", err)
+	}
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		return newProbeResult("describe", err)
+	}
+	for _, p := range partitions {
+		if p == cmd.partition {
+			return newProbeResult("describe", nil)
+		}
+	}
+	return newProbeResult("describe", fmt.Errorf("topic %v has no partition %v", cmd.topic, cmd.partition))
+}
+
+// write produces a probe record to the partition. With -abort it does so
+// in a transaction that it aborts, reporting the transaction's operations
+// separately.
+func (cmd *probeACLCmd) write() []probeResult {
+	msg := &sarama.ProducerMessage{
+		Topic:     cmd.topic,
+		Partition: cmd.partition,
+		Value:     sarama.StringEncoder("kt probe acl"),
+		Headers:   []sarama.RecordHeader{{Key: []byte("kt-probe"), Value: []byte(time.Now().UTC().Format(time.RFC3339))}},
+	}
+
+	if !cmd.abort {
+		producer, err := sarama.NewSyncProducerFromClient(cmd.client)
+		if err != nil {
+			return []probeResult{newProbeResult("write", err)}
+		}
+		defer logClose("producer", producer)
+		_, _, err = producer.SendMessage(msg)
+		return []probeResult{newProbeResult("write", err)}
+	}
+
+	cfg := cmd.saramaConfig()
+	cfg.Producer.Idempotent = true
+	cfg.Producer.Transaction.ID = cmd.transactionalID
+	cfg.Net.MaxOpenRequests = 1
+	producer, err := sarama.NewSyncProducer(cmd.brokers, cfg)
+	if err != nil {
+		return []probeResult{newProbeResult("transaction", err), skippedProbe("write", "requires transaction")}
+	}
+	defer logClose("producer", producer)
+	if err = producer.BeginTxn(); err != nil {
+		return []probeResult{newProbeResult("transaction", err), skippedProbe("write", "requires transaction")}
+	}
+	_, _, sendErr := producer.SendMessage(msg)
+	abortErr := producer.AbortTxn()
+	if sendErr != nil {
+		// the transaction fails with the record, its abort error adds nothing.
+		abortErr = nil
+	}
+	return []probeResult{newProbeResult("transaction", abortErr), newProbeResult("write", sendErr)}
+}
+
+// read fetches from the partition's newest offset, which returns no records
+// but is authorized like any other fetch.
+func (cmd *probeACLCmd) read() probeResult {
+	offset, err := cmd.client.GetOffset(cmd.topic, cmd.partition, sarama.OffsetNewest)
+	if err != nil {
+		return newProbeResult("read", err)
+	}
+	broker, err := cmd.client.Leader(cmd.topic, cmd.partition)
+	if err != nil {
+		return newProbeResult("read", err)
+	}
+
+	req := &sarama.FetchRequest{Version: 4, MaxWaitTime: 100, MinBytes: 1, MaxBytes: 1024}
+	req.AddBlock(cmd.topic, cmd.partition, offset, 1024, -1)
+	resp, err := broker.Fetch(req)
+	if err != nil {
+		return newProbeResult("read", err)
+	}
+	block := resp.GetBlock(cmd.topic, cmd.partition)
+	if block == nil {
+		return newProbeResult("read", fmt.Errorf("fetch response has no block for partition %v", cmd.partition))
+	}
+	if block.Err != sarama.ErrNoError {
+		return newProbeResult("read", block.Err)
+	}
+	return newProbeResult("read", nil)
+}
+
+func (cmd *probeACLCmd) saramaConfig() *sarama.Config {
+	cfg := cmd.clientConfig.saramaConfig("probe")
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	cfg.Producer.Retry.Max = 0
+
+	return cfg
+}
+
+func (cmd *probeACLCmd) failStartup(msg string) {
+	failUsage(msg, "kt probe acl")
+}
+
+func (cmd *probeACLCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.partition < 0 {
+		cmd.failStartup(fmt.Sprintf("invalid partition argument %v.", args.partition))
+	}
+	if args.transactionalID != "" && !args.abort {
+		cmd.failStartup("-transactional-id requires -abort.")
+	}
+
+	cmd.topic = args.topic
+	cmd.partition = int32(args.partition)
+	cmd.abort = args.abort
+	cmd.transactionalID = args.transactionalID
+	if cmd.transactionalID == "" {
+		cmd.transactionalID = fmt.Sprintf("kt-probe-%v", time.Now().UnixNano())
+	}
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	if cmd.abort && !cmd.version.IsAtLeast(sarama.V0_11_0_0) {
+		cmd.failStartup("-abort requires -version 0.11.0.0 or later for transactions.")
+	}
+}
+
+func (cmd *probeACLCmd) parseFlags(as []string) probeACLArgs {
+	var args probeACLArgs
+	flags := flag.NewFlagSet("probe acl", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Topic to probe (required).")
+	flags.IntVar(&args.partition, "partition", 0, "Partition to produce the probe record to and fetch from.")
+	flags.BoolVar(&args.abort, "abort", false, "Produce the probe record in a transaction that is aborted, so read_committed consumers never see it.")
+	flags.StringVar(&args.transactionalID, "transactional-id", "", "transactional.id for -abort, e.g. one the ACLs grant access to (defaults to kt-probe-<timestamp>).")
+	args.clientArgs.addFlags(flags)
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of probe acl:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, probeACLDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var probeACLDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt probe acl" tries the operations a client needs on a topic with the
+current credentials and reports which of them are allowed:
+
+ - describe: read the topic's metadata.
+ - transaction: with -abort, initialize -transactional-id, begin a
+   transaction and abort it.
+ - write: produce a record with a kt-probe header to -partition.
+ - read: fetch from the newest offset of -partition.
+
+  $ kt probe acl -topic orders -sasl-user billing
+  {
+    "topic": "orders",
+    "partition": 0,
+    "principal": "billing",
+    "operations": [
+      {"operation": "describe", "status": "allowed"},
+      {"operation": "write", "status": "denied", "error": "kafka server: The client is not authorized to access this topic"},
+      {"operation": "read", "status": "allowed"}
+    ]
+  }
+
+Each operation is allowed, denied if the brokers rejected it for the
+credentials or ACLs, failed for other errors, or skipped if it requires an
+operation that wasn't allowed. Without -abort the probe record is written
+to the topic for good, with -abort it's part of an aborted transaction that
+read_committed consumers skip, which additionally requires Write access to
+the transactional ID.
+
+kt probe acl exits with status 0 if all operations are allowed, 4 if any is
+denied and 1 if any failed otherwise.`
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProbeResult(t *testing.T) {
+	require.Equal(t, probeResult{Operation: "read", Status: "allowed"}, newProbeResult("read", nil))
+
+	denied := newProbeResult("write", &sarama.ProducerError{Msg: &sarama.ProducerMessage{}, Err: sarama.ErrTopicAuthorizationFailed})
+	require.Equal(t, "denied", denied.Status)
+	require.Contains(t, denied.Error, "not authorized")
+
+	require.Equal(t, "denied", newProbeResult("transaction", fmt.Errorf("init err=%w", sarama.ErrTransactionalIDAuthorizationFailed)).Status)
+	require.Equal(t, "failed", newProbeResult("read", sarama.ErrNotLeaderForPartition).Status)
+	require.Equal(t, "failed", newProbeResult("describe", errors.New("topic orders has no partition 7")).Status)
+}
+
+func TestProbeReportExitCode(t *testing.T) {
+	report := func(statuses ...string) probeReport {
+		r := probeReport{}
+		for _, s := range statuses {
+			r.Operations = append(r.Operations, probeResult{Status: s})
+		}
+		return r
+	}
+	require.Equal(t, 0, report("allowed", "allowed", "allowed").exitCode())
+	require.Equal(t, exitFailure, report("allowed", "failed", "allowed").exitCode())
+	require.Equal(t, exitAuth, report("allowed", "failed", "denied").exitCode())
+	require.Equal(t, exitAuth, report("denied", "skipped", "skipped").exitCode())
+}
@@ -1,44 +1,98 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math/rand"
+	"net/http"
 	"os"
 	"os/user"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/fgeller/kt/pkg/codec"
 )
 
 type produceArgs struct {
-	topic       string
-	partition   int
-	brokers     string
-	tlsCA       string
-	tlsCert     string
-	tlsCertKey  string
-	batch       int
-	timeout     time.Duration
-	verbose     bool
-	pretty      bool
-	version     string
-	compression string
-	literal     bool
-	decodeKey   string
-	decodeValue string
-	partitioner string
-	bufferSize  int
+	topic         string
+	partition     int
+	brokers       string
+	tlsCA         string
+	tlsCert       string
+	tlsCertKey    string
+	sasl          saslArgs
+	batch         int
+	batchSize     int
+	timeout       time.Duration
+	linger        time.Duration
+	rate          float64
+	maxBytes      int
+	verbose       bool
+	pretty        bool
+	version       string
+	compression   string
+	literal       bool
+	nullValue     string
+	file          string
+	dir           string
+	source        string
+	watchDir      string
+	perFile       bool
+	decodeKey     string
+	decodeValue   string
+	decodeHeaders string
+	partitioner   string
+	bufferSize    int
+	pipeline      bool
+	metricsAddr   string
+	transforms    string
+	sessionStats  string
+	lineage       string
+	lineageTopic  string
+	skipHeaders   string
+	keepHeaders   string
+	dropHeaders   string
+	renameHeaders repeatedFlag
+	set           repeatedFlag
+	setHeaders    repeatedFlag
+	anonymize     repeatedFlag
+	anonymizeSalt string
+	dryRun        bool
+	onError       string
+	dlqFile       string
+	reportInputs  bool
+	showFetch     bool
+
+	idempotent         bool
+	transactionalID    string
+	transactionTimeout time.Duration
+
+	registry       registryArgs
+	schema         string
+	keySchema      string
+	registerSchema bool
 }
 
 type message struct {
-	Key       *string `json:"key"`
-	Value     *string `json:"value"`
-	Partition *int32  `json:"partition"`
+	Key       *string            `json:"key"`
+	Value     *string            `json:"value"`
+	Partition *int32             `json:"partition"`
+	Headers   map[string]*string `json:"headers"`
+	Subject   *string            `json:"subject"`
+	SchemaID  *int32             `json:"schemaId"`
+	Topic     *string            `json:"topic"`
+	Offset    *int64             `json:"offset"`
+
+	lineage  []*sarama.RecordHeader // provenance headers of -lineage
+	inputErr error                  // why the input line wasn't parsed as JSON
+	file     string                 // the input was read from, see input
+	line     int                    // number of the input line in file, from 1
 }
 
 func (cmd *produceCmd) read(as []string) produceArgs {
@@ -50,17 +104,66 @@ func (cmd *produceCmd) read(as []string) produceArgs {
 	flags.StringVar(&args.tlsCA, "tlsca", "", "Path to the TLS certificate authority file")
 	flags.StringVar(&args.tlsCert, "tlscert", "", "Path to the TLS client certificate file")
 	flags.StringVar(&args.tlsCertKey, "tlscertkey", "", "Path to the TLS client certificate key file")
-	flags.IntVar(&args.batch, "batch", 1, "Max size of a batch before sending it off")
+	flags.StringVar(&args.sasl.mechanism, "sasl-mechanism", "", "SASL mechanism to authenticate with (PLAIN|SCRAM-SHA-256|SCRAM-SHA-512|GSSAPI), defaults to none.")
+	flags.StringVar(&args.sasl.user, "sasl-user", "", "SASL user name")
+	flags.StringVar(&args.sasl.password, "sasl-password", "", "SASL password")
+	flags.StringVar(&args.sasl.krb5Config, "krb5-config", "", "Path to the Kerberos config file for GSSAPI (defaults to $KRB5_CONFIG or /etc/krb5.conf).")
+	flags.StringVar(&args.sasl.keytab, "keytab", "", "Path to the Kerberos keytab file for GSSAPI, otherwise -sasl-password is used.")
+	flags.StringVar(&args.sasl.principal, "principal", "", "Kerberos principal to authenticate as for GSSAPI, e.g. kt@EXAMPLE.COM (defaults to -sasl-user).")
+	flags.StringVar(&args.sasl.realm, "realm", "", "Kerberos realm for GSSAPI (defaults to the realm of -principal).")
+	flags.IntVar(&args.batch, "batch", 0, "Max number of messages in a batch before sending it off (defaults to 1, or no limit with -batch-size).")
+	flags.IntVar(&args.batchSize, "batch-size", 0, "Max bytes of keys and values in a batch before sending it off (defaults to no limit).")
 	flags.DurationVar(&args.timeout, "timeout", 50*time.Millisecond, "Duration to wait for batch to be filled before sending it off")
+	flags.DurationVar(&args.linger, "linger", 0, "Duration to wait for batch to be filled before sending it off, like the producer's linger.ms (defaults to -timeout).")
+	flags.Float64Var(&args.rate, "rate", 0, "Max number of messages to produce per second (defaults to 0 for no limit).")
+	flags.IntVar(&args.maxBytes, "max-bytes-per-sec", 0, "Max bytes of keys and values to produce per second (defaults to 0 for no limit).")
 	flags.BoolVar(&args.verbose, "verbose", false, "Verbose output")
 	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
 	flags.BoolVar(&args.literal, "literal", false, "Interpret stdin line literally and pass it as value, key as null.")
+	flags.StringVar(&args.nullValue, "null-value", "", "Input value to produce as null, i.e. as a tombstone, e.g. '\\N' with -literal (defaults to none).")
+	flags.StringVar(&args.file, "file", "", "Read input from the given file instead of stdin.")
+	flags.StringVar(&args.dir, "dir", "", "Read input from the files in the given directory in name order instead of stdin.")
+	flags.StringVar(&args.source, "source", "", "Where to read input from (stdin|file:<glob>|dir:<path>|watch:<path>|http(s)://<url>|listen:<address>), defaults to stdin.")
+	flags.StringVar(&args.watchDir, "watch-dir", "", "Produce the JSON, CSV and Avro files in the given directory and those that appear in it, skipping processed files.")
+	flags.BoolVar(&args.perFile, "per-file", false, "Produce the contents of each file, HTTP response or request as one message rather than one per line.")
 	flags.StringVar(&args.version, "version", "", "Kafka protocol version")
-	flags.StringVar(&args.compression, "compression", "", "Kafka message compression codec [gzip|snappy|lz4] (defaults to none)")
-	flags.StringVar(&args.partitioner, "partitioner", "", "Optional partitioner to use. Available: hashCode")
-	flags.StringVar(&args.decodeKey, "decodekey", "string", "Decode message value as (string|hex|base64), defaults to string.")
-	flags.StringVar(&args.decodeValue, "decodevalue", "string", "Decode message value as (string|hex|base64), defaults to string.")
+	flags.StringVar(&args.compression, "compression", "", "Kafka message compression codec [gzip|snappy|lz4|zstd] (defaults to none)")
+	flags.StringVar(&args.partitioner, "partitioner", "manual", "Partitioner to use (manual|hash|random|roundrobin|hashCode). manual uses each input's \"partition\" or -partition.")
+	flags.StringVar(&args.decodeKey, "decodekey", "string", "Decode message key as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.decodeValue, "decodevalue", "string", "Decode message value as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.decodeHeaders, "decodeheaders", "string", "Decode message header values as (string|hex|base64|avro), per header as key=encoding, e.g. base64,trace-id=string. Defaults to string.")
+	flags.StringVar(&args.registry.url, "schema-registry", "", "URL of the schema registry for avro encoding.")
+	flags.StringVar(&args.registry.user, "schema-registry-user", "", "User name for basic authentication with the schema registry.")
+	flags.StringVar(&args.registry.password, "schema-registry-password", "", "Password for basic authentication with the schema registry.")
+	flags.StringVar(&args.registry.tlsCA, "schema-registry-tlsca", "", "Path to the certificate authority file to verify the schema registry with.")
+	flags.StringVar(&args.registry.tlsCert, "schema-registry-tlscert", "", "Path to the client certificate file for the schema registry.")
+	flags.StringVar(&args.registry.tlsCertKey, "schema-registry-tlscertkey", "", "Path to the client certificate key file for the schema registry.")
+	flags.StringVar(&args.schema, "schema", "", "Avro schema file or subject to encode values with (defaults to the latest schema of subject <topic>-value).")
+	flags.StringVar(&args.keySchema, "key-schema", "", "Avro schema file or subject to encode keys with (defaults to the latest schema of subject <topic>-key).")
+	flags.BoolVar(&args.registerSchema, "register-schema", false, "Register the -schema and -key-schema files under subject <topic>-value and <topic>-key if they aren't yet.")
 	flags.IntVar(&args.bufferSize, "buffersize", 16777216, "Buffer size for scanning stdin, defaults to 16777216=16*1024*1024.")
+	flags.BoolVar(&args.pipeline, "pipeline", false, "Read stdin until it ends even after an interrupt and drop an incomplete last line, for input piped from kt consume -pipeline.")
+	flags.StringVar(&args.metricsAddr, "metricsaddr", "", "Address to expose Prometheus metrics on, e.g. localhost:9100 (defaults to disabled).")
+	flags.BoolVar(&args.idempotent, "idempotent", false, "Produce as an idempotent producer so brokers discard duplicate batches.")
+	flags.StringVar(&args.transactionalID, "transactional-id", "", "Produce all input in a single transaction with the given transactional ID, implies -idempotent.")
+	flags.DurationVar(&args.transactionTimeout, "transaction-timeout", time.Minute, "Time after which the brokers abort an unfinished transaction.")
+	flags.StringVar(&args.sessionStats, "session-stats", "", "Path to write a JSON report of the session's traffic and broker latencies to at exit (defaults to none).")
+	flags.StringVar(&args.transforms, "transforms", "", "Kafka Connect style config file of transforms to apply before producing (defaults to none).")
+	flags.StringVar(&args.lineage, "lineage", "", "Name of the cluster the input was consumed from, adds headers with the source of each message (defaults to none).")
+	flags.StringVar(&args.lineageTopic, "lineage-topic", "", "Topic the input was consumed from for -lineage (defaults to the \"topic\" of each input line).")
+	flags.StringVar(&args.skipHeaders, "skip-headers", "", "Comma separated list of key=value or key headers, input messages with any of them are skipped (defaults to none).")
+	flags.Var(&args.set, "set", "Set a field of input messages as .path=value, e.g. .value.status=RETRY, .key=id-23 or .partition=0. Can be repeated.")
+	flags.StringVar(&args.keepHeaders, "keep-headers", "", "Comma separated list of glob patterns, only input headers with matching keys are produced (defaults to all).")
+	flags.StringVar(&args.dropHeaders, "drop-headers", "", "Comma separated list of glob patterns, input headers with matching keys aren't produced, e.g. traceparent,x-b3-* (defaults to none).")
+	flags.Var(&args.renameHeaders, "rename-header", "Produce input headers under another key as old=new, or old-*=new-* for a prefix. Can be repeated.")
+	flags.Var(&args.setHeaders, "set-header", "Set a header of input messages as key=value, e.g. retry-count=1. Can be repeated.")
+	flags.Var(&args.anonymize, "anonymize", "Replace a field of input messages with a pseudonym as .path=method, e.g. .value.email=mask-email, .key=hash or .value.birthDate=jitter:720h. Can be repeated.")
+	flags.StringVar(&args.anonymizeSalt, "anonymize-salt", "", "Secret that -anonymize derives pseudonyms from, the same salt gives the same pseudonyms.")
+	flags.BoolVar(&args.dryRun, "dry-run", false, "Validate the input and print where each message would be produced to without producing it.")
+	flags.StringVar(&args.onError, "on-produce-error", "fail", "What to do with messages that fail to produce (fail|skip|dlq-file), dlq-file writes them to -dlq-file.")
+	flags.BoolVar(&args.reportInputs, "report-inputs", false, "Include the input files and line numbers of the produced messages with their offsets in the output.")
+	flags.BoolVar(&args.showFetch, "show-fetch", false, "Print a JSON line per produce request to stderr with the broker it was sent to, its latency and the partitions, records and bytes it carried.")
+	flags.StringVar(&args.dlqFile, "dlq-file", "", "Path of the file to append messages that failed to produce to with -on-produce-error dlq-file.")
 
 	flags.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage of produce:")
@@ -70,17 +173,20 @@ func (cmd *produceCmd) read(as []string) produceArgs {
 
 	err := flags.Parse(as)
 	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
-		os.Exit(0)
+		exit(0)
 	} else if err != nil {
-		os.Exit(2)
+		exit(exitUsage)
+	}
+	if err = applyConfig(flags, args.brokers, args.topic); err != nil {
+		cmd.failStartup(err.Error())
 	}
 
+	jsonErrors = !args.pretty
 	return args
 }
 
 func (cmd *produceCmd) failStartup(msg string) {
-	fmt.Fprintln(os.Stderr, msg)
-	failf("use \"kt produce -help\" for more information")
+	failUsage(msg, "kt produce")
 }
 
 func (cmd *produceCmd) parseArgs(as []string) {
@@ -97,6 +203,7 @@ func (cmd *produceCmd) parseArgs(as []string) {
 	cmd.tlsCA = args.tlsCA
 	cmd.tlsCert = args.tlsCert
 	cmd.tlsCertKey = args.tlsCertKey
+	cmd.sasl = readSASLEnv(args.sasl)
 
 	envBrokers := os.Getenv("KT_BROKERS")
 	if args.brokers == "" {
@@ -114,28 +221,187 @@ func (cmd *produceCmd) parseArgs(as []string) {
 		}
 	}
 
-	if args.decodeValue != "string" && args.decodeValue != "hex" && args.decodeValue != "base64" {
-		cmd.failStartup(fmt.Sprintf(`unsupported decodevalue argument %#v, only string, hex and base64 are supported.`, args.decodeValue))
+	if err := checkEncoding(args.decodeValue, "avro"); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid decodevalue argument %#v err=%v, avro is supported too.", args.decodeValue, err))
 		return
 	}
 	cmd.decodeValue = args.decodeValue
 
-	if args.decodeKey != "string" && args.decodeKey != "hex" && args.decodeKey != "base64" {
-		cmd.failStartup(fmt.Sprintf(`unsupported decodekey argument %#v, only string, hex and base64 are supported.`, args.decodeValue))
+	if err := checkEncoding(args.decodeKey, "avro"); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid decodekey argument %#v err=%v, avro is supported too.", args.decodeKey, err))
 		return
 	}
 	cmd.decodeKey = args.decodeKey
 
+	var err error
+	if cmd.decodeHeaders, err = parseHeaderEncodings(args.decodeHeaders, "string", "hex", "base64", "avro"); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid decodeheaders argument %#v err=%v", args.decodeHeaders, err))
+		return
+	}
+
+	args.registry = readRegistryEnv(args.registry)
+	if (cmd.decodeKey == "avro" || cmd.decodeValue == "avro" || cmd.decodeHeaders.uses("avro")) && args.registry.url == "" {
+		cmd.failStartup("avro encoding requires -schema-registry.")
+		return
+	}
+	if args.schema != "" && cmd.decodeValue != "avro" {
+		cmd.failStartup("-schema requires -decodevalue avro.")
+		return
+	}
+	if args.keySchema != "" && cmd.decodeKey != "avro" {
+		cmd.failStartup("-key-schema requires -decodekey avro.")
+		return
+	}
+	if args.registerSchema && cmd.decodeKey != "avro" && cmd.decodeValue != "avro" {
+		cmd.failStartup("-register-schema requires -decodekey avro or -decodevalue avro.")
+		return
+	}
+	cmd.registry = args.registry
+	cmd.schema = args.schema
+	cmd.keySchema = args.keySchema
+	cmd.registerSchema = args.registerSchema
+
+	if args.batch < 0 || args.batchSize < 0 {
+		cmd.failStartup("-batch and -batch-size cannot be negative.")
+	}
 	cmd.batch = args.batch
+	if cmd.batch == 0 && args.batchSize == 0 {
+		cmd.batch = 1
+	}
+	cmd.batchSize = args.batchSize
 	cmd.timeout = args.timeout
+	if args.linger > 0 {
+		cmd.timeout = args.linger
+	}
+	if cmd.limiter, err = newRateLimiter(args.rate, args.maxBytes); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid -rate or -max-bytes-per-sec err=%v", err))
+	}
+
+	if cmd.sourceSpec, err = parseSourceSpec(args.source); err != nil {
+		cmd.failStartup(err.Error())
+	}
+	if (args.file != "" || args.dir != "" || args.watchDir != "") && args.source != "" {
+		cmd.failStartup("-file, -dir and -watch-dir cannot be combined with -source, use -source file:<path> or dir:<path> instead.")
+	}
+	inputs := 0
+	for _, in := range []string{args.file, args.dir, args.watchDir} {
+		if in != "" {
+			inputs++
+		}
+	}
+	switch {
+	case inputs > 1:
+		cmd.failStartup("-file, -dir and -watch-dir cannot be combined.")
+	case args.watchDir != "" && args.perFile:
+		cmd.failStartup("-per-file cannot be combined with -watch-dir, which reads files according to their extension.")
+	case args.watchDir != "":
+		cmd.sourceSpec = sourceSpec{kind: "watch-dir", target: args.watchDir}
+	case args.file != "":
+		cmd.sourceSpec = sourceSpec{kind: "file", target: args.file}
+	case args.dir != "":
+		cmd.sourceSpec = sourceSpec{kind: "dir", target: args.dir}
+	}
+	if args.perFile && cmd.sourceSpec.kind == "stdin" {
+		cmd.failStartup("-per-file requires -file, -dir or a -source other than stdin.")
+	}
+	if args.pipeline && cmd.sourceSpec.kind != "stdin" {
+		cmd.failStartup("-pipeline reads stdin, it cannot be combined with -file, -dir, -watch-dir or -source.")
+	}
+	cmd.pipeline = args.pipeline
+	cmd.perFile = args.perFile
 	cmd.verbose = args.verbose
 	cmd.pretty = args.pretty
 	cmd.literal = args.literal
+	cmd.nullValue = args.nullValue
 	cmd.partition = int32(args.partition)
 	cmd.partitioner = args.partitioner
+	switch cmd.partitioner {
+	case "", "manual", "hash", "random", "roundrobin", "hashCode":
+	default:
+		cmd.failStartup(fmt.Sprintf("unsupported partitioner %#v, only manual, hash, random, roundrobin and hashCode are supported.", cmd.partitioner))
+	}
+	cmd.random = rand.New(rand.NewSource(time.Now().UnixNano()))
 	cmd.version = kafkaVersion(args.version)
 	cmd.compression = kafkaCompression(args.compression)
+	if cmd.compression == sarama.CompressionZSTD && !cmd.version.IsAtLeast(sarama.V2_1_0_0) {
+		cmd.failStartup("zstd compression requires -version 2.1.0 or later.")
+	}
 	cmd.bufferSize = args.bufferSize
+	cmd.metricsAddr = args.metricsAddr
+	cmd.stats = newSessionStats("produce", args.sessionStats)
+
+	cmd.idempotent = args.idempotent || args.transactionalID != ""
+	cmd.transactionalID = args.transactionalID
+	cmd.transactionTimeout = args.transactionTimeout
+	if cmd.idempotent && produceRequestVersion(cmd.version) < 3 {
+		cmd.failStartup("-idempotent and -transactional-id require -version 0.11.0.0 or later.")
+	}
+
+	if args.lineageTopic != "" && args.lineage == "" {
+		cmd.failStartup("-lineage-topic requires -lineage.")
+	}
+	if args.lineage != "" {
+		if produceRequestVersion(cmd.version) < 3 {
+			cmd.failStartup("-lineage requires -version 0.11.0.0 or later for headers.")
+		}
+		cmd.lineage = newLineage(args.lineage, args.lineageTopic)
+	}
+
+	if args.skipHeaders != "" {
+		var err error
+		if cmd.skipHeaders, err = parseHeaderMatches(args.skipHeaders); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid -skip-headers err=%v", err))
+		}
+	}
+
+	if cmd.headers, err = newHeaderPolicy(args.keepHeaders, args.dropHeaders, args.renameHeaders); err != nil {
+		cmd.failStartup(err.Error())
+	}
+
+	for _, s := range args.set {
+		m, err := parseMutation(s)
+		if err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid -set err=%v", err))
+		}
+		cmd.mutations = append(cmd.mutations, m)
+	}
+	for _, s := range args.setHeaders {
+		m, err := parseHeaderMutation(s)
+		if err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid -set-header err=%v", err))
+		}
+		cmd.mutations = append(cmd.mutations, m)
+	}
+
+	rules := []anonymization{}
+	for _, s := range args.anonymize {
+		a, err := parseAnonymization(s)
+		if err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid -anonymize err=%v", err))
+		}
+		rules = append(rules, a)
+	}
+	if args.anonymizeSalt == "" {
+		args.anonymizeSalt = os.Getenv("KT_ANONYMIZE_SALT")
+	}
+	if len(rules) > 0 && args.anonymizeSalt == "" {
+		cmd.failStartup("-anonymize requires -anonymize-salt, or KT_ANONYMIZE_SALT, so pseudonyms can't be guessed.")
+	}
+	cmd.anonymizer = newAnonymizer(args.anonymizeSalt, rules)
+
+	if args.transforms != "" {
+		var err error
+		if cmd.transforms, err = readTransforms(args.transforms); err != nil {
+			cmd.failStartup(fmt.Sprintf("failed to read transforms err=%v", err))
+		}
+	}
+	cmd.dryRun = args.dryRun
+	cmd.reportInputs = args.reportInputs
+	cmd.fetchLog = newFetchLog(args.showFetch, os.Stderr)
+
+	if cmd.errors, err = newProduceErrors(args.onError, args.dlqFile); err != nil {
+		cmd.failStartup(err.Error())
+	}
 }
 
 func kafkaCompression(codecName string) sarama.CompressionCodec {
@@ -146,20 +412,20 @@ func kafkaCompression(codecName string) sarama.CompressionCodec {
 		return sarama.CompressionSnappy
 	case "lz4":
 		return sarama.CompressionLZ4
+	case "zstd":
+		return sarama.CompressionZSTD
 	case "":
 		return sarama.CompressionNone
 	}
 
-	failf("unsupported compression codec %#v - supported: gzip, snappy, lz4", codecName)
+	exitf(exitUsage, "unsupported compression codec %#v - supported: gzip, snappy, lz4, zstd", codecName)
 	panic("unreachable")
 }
 
-func (cmd *produceCmd) findLeaders() {
+func (cmd *produceCmd) saramaConfig() (*sarama.Config, error) {
 	var (
 		usr *user.User
 		err error
-		res *sarama.MetadataResponse
-		req = sarama.MetadataRequest{Topics: []string{cmd.topic}}
 		cfg = sarama.NewConfig()
 	)
 
@@ -169,17 +435,35 @@ func (cmd *produceCmd) findLeaders() {
 		fmt.Fprintf(os.Stderr, "Failed to read current user err=%v", err)
 	}
 	cfg.ClientID = "kt-produce-" + sanitizeUsername(usr.Username)
+	cmd.stats.configure(cfg)
 	if cmd.verbose {
 		fmt.Fprintf(os.Stderr, "sarama client configuration %#v\n", cfg)
 	}
-	tlsConfig, err := setupCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
+	tlsConfig, err := setupBrokerCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
 	if err != nil {
-		failf("failed to setup certificates err=%v", err)
+		return nil, fmt.Errorf("failed to setup certificates err=%v", err)
 	}
 	if tlsConfig != nil {
 		cfg.Net.TLS.Enable = true
 		cfg.Net.TLS.Config = tlsConfig
 	}
+	if err = setupSASL(cfg, cmd.sasl); err != nil {
+		return nil, fmt.Errorf("failed to setup SASL err=%v", err)
+	}
+
+	return cfg, nil
+}
+
+func (cmd *produceCmd) findLeaders(topic string) (map[int32]*sarama.Broker, error) {
+	var (
+		res *sarama.MetadataResponse
+		req = sarama.MetadataRequest{Topics: []string{topic}}
+	)
+
+	cfg, err := cmd.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
 
 loop:
 	for _, addr := range cmd.brokers {
@@ -204,56 +488,116 @@ loop:
 		}
 
 		for _, tm := range res.Topics {
-			if tm.Name == cmd.topic {
+			if tm.Name == topic {
 				if tm.Err != sarama.ErrNoError {
 					fmt.Fprintf(os.Stderr, "Failed to get metadata from %#v. err=%v\n", addr, tm.Err)
 					continue loop
 				}
 
-				cmd.leaders = map[int32]*sarama.Broker{}
+				leaders := map[int32]*sarama.Broker{}
 				for _, pm := range tm.Partitions {
 					b, ok := brokers[pm.Leader]
 					if !ok {
-						failf("failed to find leader in broker response, giving up")
+						return nil, fmt.Errorf("failed to find leader in broker response, giving up")
 					}
 
 					if err = b.Open(cfg); err != nil && err != sarama.ErrAlreadyConnected {
-						failf("failed to open broker connection err=%s", err)
+						return nil, fmt.Errorf("failed to open broker connection err=%s", err)
 					}
 					if connected, err := broker.Connected(); !connected && err != nil {
-						failf("failed to wait for broker connection to open err=%s", err)
+						return nil, fmt.Errorf("failed to wait for broker connection to open err=%s", err)
 					}
 
-					cmd.leaders[pm.ID] = b
+					leaders[pm.ID] = b
 				}
-				return
+				return leaders, nil
 			}
 		}
 	}
 
-	failf("failed to find leader for given topic")
+	return nil, fmt.Errorf("failed to find leader for topic %v", topic)
+}
+
+// topicLeaders returns the partition leaders of topic, looking them up the
+// first time transforms route a message to it.
+func (cmd *produceCmd) topicLeaders(topic string) (map[int32]*sarama.Broker, error) {
+	if topic == cmd.topic {
+		return cmd.leaders, nil
+	}
+
+	if leaders, ok := cmd.routed[topic]; ok {
+		return leaders, nil
+	}
+
+	leaders, err := cmd.findLeaders(topic)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.routed == nil {
+		cmd.routed = map[string]map[int32]*sarama.Broker{}
+	}
+	cmd.routed[topic] = leaders
+	return leaders, nil
 }
 
 type produceCmd struct {
-	topic       string
-	brokers     []string
-	tlsCA       string
-	tlsCert     string
-	tlsCertKey  string
-	batch       int
-	timeout     time.Duration
-	verbose     bool
-	pretty      bool
-	literal     bool
-	partition   int32
-	version     sarama.KafkaVersion
-	compression sarama.CompressionCodec
-	partitioner string
-	decodeKey   string
-	decodeValue string
-	bufferSize  int
+	topic         string
+	brokers       []string
+	tlsCA         string
+	tlsCert       string
+	tlsCertKey    string
+	sasl          saslArgs
+	batch         int
+	batchSize     int
+	timeout       time.Duration
+	limiter       *rateLimiter
+	verbose       bool
+	pretty        bool
+	literal       bool
+	nullValue     string
+	sourceSpec    sourceSpec
+	pipeline      bool
+	perFile       bool
+	partition     int32
+	version       sarama.KafkaVersion
+	compression   sarama.CompressionCodec
+	partitioner   string
+	nextPartition int32 // of -partitioner roundrobin
+	random        *rand.Rand
+	decodeKey     string
+	decodeValue   string
+	decodeHeaders headerEncodings
+	bufferSize    int
+	metricsAddr   string
+	transforms    []transform
+	stats         *sessionStats
+	lineage       *lineage
+	skipHeaders   []headerMatch
+	headers       *headerPolicy
+	mutations     []mutation
+	anonymizer    *anonymizer
+	dryRun        bool
+	reportInputs  bool
+	errors        *produceErrors
+	fetchLog      *fetchLog
+	checked       int // messages validated by -dry-run
+	invalid       int // of checked
+
+	idempotent         bool
+	transactionalID    string
+	transactionTimeout time.Duration
+	session            *producerSession
+
+	registry       registryArgs
+	schema         string
+	keySchema      string
+	registerSchema bool
+	avro           *avroEncoder
+	avroKey        *avroEncoder
 
 	leaders map[int32]*sarama.Broker
+	routed  map[string]map[int32]*sarama.Broker // leaders of topics transforms routed messages to
+	metrics *metricsRegistry
 }
 
 func (cmd *produceCmd) run(as []string) {
@@ -263,26 +607,169 @@ func (cmd *produceCmd) run(as []string) {
 	}
 
 	defer cmd.close()
-	cmd.findLeaders()
-	stdin := make(chan string)
-	lines := make(chan string)
+	defer cmd.stats.write()
+	if err := cmd.errors.open(); err != nil {
+		failf("failed to open %v err=%v", cmd.errors.path, err)
+	}
+	defer cmd.errors.close()
+	cmd.setupMetrics()
+	cmd.setupAvro()
+
+	var err error
+	if cmd.leaders, err = cmd.findLeaders(cmd.topic); err != nil {
+		failf("%v", err)
+	}
+	if !cmd.dryRun {
+		if err = cmd.setupProducerSession(); err != nil {
+			failf("%v", err)
+		}
+	}
+	defer cmd.session.close()
+	stdin := make(chan input)
+	lines := make(chan input)
 	messages := make(chan message)
 	batchedMessages := make(chan []message)
 	out := make(chan printContext)
 	q := make(chan struct{})
 
-	go readStdinLines(cmd.bufferSize, stdin)
+	src := cmd.setupSource()
+	go func() {
+		if err := src.read(stdin); err != nil {
+			failf("failed to read input from %v err=%v", src, err)
+		}
+		close(stdin)
+	}()
 	go print(out, cmd.pretty)
 
-	go listenForInterrupt(q)
+	if cmd.pipeline {
+		go listenForPipelineInterrupt(q)
+	} else {
+		go listenForInterrupt(q)
+	}
 	go cmd.readInput(q, stdin, lines)
 	go cmd.deserializeLines(lines, messages, int32(len(cmd.leaders)))
 	go cmd.batchRecords(messages, batchedMessages)
-	cmd.produce(batchedMessages, out)
+	err = cmd.produce(batchedMessages, out)
+	if cmd.dryRun {
+		if cmd.invalid > 0 {
+			failf("%v of %v messages are invalid", cmd.invalid, cmd.checked)
+		}
+		return
+	}
+
+	interrupted := false
+	select {
+	case <-q:
+		interrupted = true
+	default:
+	}
+	cmd.endTransaction(err == nil && !interrupted)
+}
+
+// endTransaction commits the transaction of -transactional-id if all input
+// was produced, otherwise it aborts it so none of it becomes visible to
+// read_committed consumers.
+func (cmd *produceCmd) endTransaction(commit bool) {
+	if cmd.transactionalID == "" {
+		return
+	}
+
+	if err := cmd.session.endTransaction(commit); err != nil {
+		failf("failed to end transaction %v err=%v", cmd.transactionalID, err)
+	}
+	if !commit {
+		failf("aborted transaction %v", cmd.transactionalID)
+	}
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "committed transaction %v\n", cmd.transactionalID)
+	}
+}
+
+func (cmd *produceCmd) setupMetrics() {
+	if cmd.metricsAddr == "" {
+		return
+	}
+
+	cmd.metrics = newMetricsRegistry()
+	cmd.metrics.register("kt_produce_messages_total", counterMetric, "Number of messages acknowledged by the brokers.")
+	cmd.metrics.register("kt_broker_throttled_responses_total", counterMetric, "Number of produce responses for which the broker applied quota throttling.")
+	cmd.metrics.register("kt_broker_throttle_seconds_total", counterMetric, "Total time the broker reported throttling produce requests.")
+	serveMetrics(cmd.metricsAddr, cmd.metrics)
+}
+
+// setupAvro prepares encoding keys, values and headers as avro. Keys and
+// values have separate subjects, <topic>-key and <topic>-value. -key-schema
+// and -schema are either a schema file, which has to be registered under the
+// subject already unless -register-schema is set, or a subject to use the
+// latest schema of. Headers use the latest schema of the subject
+// <topic>-<key>.
+func (cmd *produceCmd) setupAvro() {
+	if cmd.decodeKey != "avro" && cmd.decodeValue != "avro" && !cmd.decodeHeaders.uses("avro") && cmd.registry.url == "" {
+		return
+	}
+
+	registry, err := newSchemaRegistry(cmd.registry)
+	if err != nil {
+		failf("failed to setup schema registry client err=%v", err)
+	}
+	if cmd.decodeKey == "avro" {
+		cmd.avroKey = newAvroEncoder(registry, cmd.topic+"-key")
+		cmd.useSchema(cmd.avroKey, cmd.keySchema, "keys")
+	}
+	cmd.avro = newAvroEncoder(registry, cmd.topic+"-value")
+	if cmd.decodeValue == "avro" {
+		cmd.useSchema(cmd.avro, cmd.schema, "values")
+	}
+}
+
+// useSchema makes e encode with schema, a file or subject, if it's set.
+func (cmd *produceCmd) useSchema(e *avroEncoder, schema, what string) {
+	buf, err := ioutil.ReadFile(schema)
+	switch {
+	case schema == "":
+	case err == nil:
+		if _, err = parseAvroSchema(string(buf)); err != nil {
+			failf("failed to parse schema %v err=%v", schema, err)
+		}
+		if e.id, err = e.registry.schemaID(e.subject, string(buf), cmd.registerSchema && !cmd.dryRun); err != nil {
+			failf("failed to find schema %v under subject %v err=%v", schema, e.subject, err)
+		}
+		if cmd.verbose {
+			fmt.Fprintf(os.Stderr, "encoding %v with schema id %v\n", what, e.id)
+		}
+	case os.IsNotExist(err) && !cmd.registerSchema:
+		e.subject = schema
+	default:
+		failf("failed to read schema %v err=%v", schema, err)
+	}
+}
+
+// produceRequestVersion picks the highest produce request version that
+// matches the message formats produced by makeSaramaMessage and
+// makeSaramaRecord. Versions 1 and up make brokers report the time they
+// throttled a request due to quotas, version 3 sends record batches.
+func produceRequestVersion(v sarama.KafkaVersion) int16 {
+	switch {
+	case v.IsAtLeast(sarama.V0_11_0_0):
+		return 3
+	case v.IsAtLeast(sarama.V0_10_0_0):
+		return 2
+	case v.IsAtLeast(sarama.V0_9_0_0):
+		return 1
+	default:
+		return 0
+	}
 }
 
 func (cmd *produceCmd) close() {
-	for _, b := range cmd.leaders {
+	cmd.closeLeaders(cmd.leaders)
+	for _, leaders := range cmd.routed {
+		cmd.closeLeaders(leaders)
+	}
+}
+
+func (cmd *produceCmd) closeLeaders(leaders map[int32]*sarama.Broker) {
+	for _, b := range leaders {
 		var (
 			connected bool
 			err       error
@@ -303,14 +790,15 @@ func (cmd *produceCmd) close() {
 	}
 }
 
-func (cmd *produceCmd) deserializeLines(in chan string, out chan message, partitionCount int32) {
+func (cmd *produceCmd) deserializeLines(in chan input, out chan message, partitionCount int32) {
 	defer func() { close(out) }()
 	for {
 		select {
-		case l, ok := <-in:
+		case i, ok := <-in:
 			if !ok {
 				return
 			}
+			l := i.text
 			var msg message
 
 			switch {
@@ -318,7 +806,7 @@ func (cmd *produceCmd) deserializeLines(in chan string, out chan message, partit
 				msg.Value = &l
 				msg.Partition = &cmd.partition
 			default:
-				if err := json.Unmarshal([]byte(l), &msg); err != nil {
+				if err := cmd.unmarshalMessage(l, &msg); err != nil {
 					if cmd.verbose {
 						fmt.Fprintf(os.Stderr, "Failed to unmarshal input [%v], falling back to defaults. err=%v\n", l, err)
 					}
@@ -327,29 +815,213 @@ func (cmd *produceCmd) deserializeLines(in chan string, out chan message, partit
 						v = nil
 					}
 					msg = message{Key: nil, Value: v}
+					if cmd.dryRun {
+						msg.inputErr = err
+					}
 				}
 			}
+			msg.file, msg.line = i.file, i.line
+			cmd.nullify(&msg)
 
-			var part int32 = 0
-			if msg.Key != nil && cmd.partitioner == "hashCode" {
-				part = hashCodePartition(*msg.Key, partitionCount)
+			if len(cmd.skipHeaders) > 0 && cmd.skipMessage(msg) {
+				if cmd.verbose {
+					fmt.Fprintf(os.Stderr, "Skipping input [%v] because of its headers.\n", l)
+				}
+				continue
 			}
-			if msg.Partition == nil {
-				msg.Partition = &part
+			msg.Headers = cmd.headers.apply(msg.Headers)
+
+			rejected := false
+			for _, m := range cmd.mutations {
+				if err := m.apply(&msg); err != nil {
+					err = fmt.Errorf("failed to set %v of input [%v] err=%v", m.target, l, err)
+					if err = cmd.errors.reject(cmd.topic, msg, err); err != nil {
+						failf("%v", err)
+					}
+					rejected = true
+					break
+				}
+			}
+			if rejected {
+				continue
+			}
+			if err := cmd.anonymizer.apply(&msg); err != nil {
+				err = fmt.Errorf("failed to anonymize input [%v] err=%v", l, err)
+				if err = cmd.errors.reject(cmd.topic, msg, err); err != nil {
+					failf("%v", err)
+				}
+				continue
 			}
 
+			if cmd.lineage != nil {
+				source := msg.Partition
+				if cmd.literal {
+					source = nil
+				}
+				msg.lineage = cmd.lineage.headers(msg.Topic, source, msg.Offset)
+			}
+
+			cmd.choosePartition(&msg, partitionCount)
+
 			out <- msg
 		}
 	}
 }
 
+// choosePartition sets the partition of msg per -partitioner. manual keeps
+// the input's partition and defaults to -partition. hash partitions by key
+// like the Java client's default partitioner (murmur2), spreading messages
+// without key round robin. random and roundrobin ignore keys. All three
+// ignore the input's partition, e.g. to redistribute consumed messages.
+// hashCode keeps the input's partition, too.
+func (cmd *produceCmd) choosePartition(msg *message, partitions int32) {
+	var part int32
+	switch cmd.partitioner {
+	case "hashCode":
+		if msg.Partition != nil {
+			return
+		}
+		if msg.Key != nil {
+			part = hashCodePartition(*msg.Key, partitions)
+		}
+	case "hash":
+		if msg.Key == nil {
+			part = cmd.roundRobin(partitions)
+		} else {
+			part = keyPartitioners["murmur2"](cmd.partitionKey(msg), partitions)
+		}
+	case "random":
+		part = cmd.random.Int31n(partitions)
+	case "roundrobin":
+		part = cmd.roundRobin(partitions)
+	default:
+		if msg.Partition != nil {
+			return
+		}
+		part = cmd.partition
+	}
+	msg.Partition = &part
+}
+
+// partitionKey returns the bytes the hash partitioner hashes, the key as it
+// is produced per -decodekey. Like the Java client hashes serialized keys,
+// records with the same key then land in the same partition regardless of
+// the client, e.g. avro keys are hashed in the wire format and hex keys as
+// the bytes they encode. Keys that fail to decode are hashed as they are,
+// producing them fails later on.
+func (cmd *produceCmd) partitionKey(msg *message) []byte {
+	var (
+		key []byte
+		err error
+	)
+	if cmd.decodeKey == "avro" {
+		key, err = cmd.avroKey.encode([]byte(*msg.Key), "", 0)
+	} else {
+		key, err = decodeBytes(*msg.Key, cmd.decodeKey)
+	}
+	if err != nil {
+		return []byte(*msg.Key)
+	}
+	return key
+}
+
+func (cmd *produceCmd) roundRobin(partitions int32) int32 {
+	p := cmd.nextPartition % partitions
+	cmd.nextPartition = p + 1
+	return p
+}
+
+// nullify makes msg a tombstone if its value is -null-value.
+func (cmd *produceCmd) nullify(msg *message) {
+	if cmd.nullValue != "" && msg.Value != nil && *msg.Value == cmd.nullValue {
+		msg.Value = nil
+	}
+}
+
+// skipMessage returns whether msg carries any of the -skip-headers. Header
+// values that fail to decode are compared as they are.
+func (cmd *produceCmd) skipMessage(msg message) bool {
+	headers := map[string][]byte{}
+	for k, v := range msg.Headers {
+		if v == nil {
+			headers[k] = nil
+			continue
+		}
+		decoded, err := cmd.decodeHeader(k, *v)
+		if err != nil {
+			decoded = []byte(*v)
+		}
+		headers[k] = decoded
+	}
+	return matchHeaders(cmd.skipHeaders, headers)
+}
+
+// unmarshalMessage parses a JSON input line. For avro the key, value or
+// header is the JSON value to encode rather than a string, so it's kept as
+// raw JSON.
+func (cmd *produceCmd) unmarshalMessage(l string, msg *message) error {
+	if cmd.decodeKey != "avro" && !jsonEncoding(cmd.decodeValue) && !cmd.decodeHeaders.uses("avro") {
+		return json.Unmarshal([]byte(l), msg)
+	}
+
+	var (
+		err error
+		in  struct {
+			message
+			Key     json.RawMessage            `json:"key"`
+			Value   json.RawMessage            `json:"value"`
+			Headers map[string]json.RawMessage `json:"headers"`
+		}
+	)
+	if err = json.Unmarshal([]byte(l), &in); err != nil {
+		return err
+	}
+
+	*msg = in.message
+	if msg.Key, err = rawString(in.Key, cmd.decodeKey == "avro"); err != nil {
+		return fmt.Errorf("invalid key err=%v", err)
+	}
+	if msg.Value, err = rawString(in.Value, jsonEncoding(cmd.decodeValue)); err != nil {
+		return fmt.Errorf("invalid value err=%v", err)
+	}
+	if in.Headers != nil {
+		msg.Headers = map[string]*string{}
+	}
+	for k, raw := range in.Headers {
+		if msg.Headers[k], err = rawString(raw, cmd.decodeHeaders.of(k) == "avro"); err != nil {
+			return fmt.Errorf("invalid header %#v err=%v", k, err)
+		}
+	}
+	return nil
+}
+
+// rawString returns the JSON string in raw, or raw itself if asJSON is set.
+// Missing values and null are nil.
+func rawString(raw json.RawMessage, asJSON bool) (*string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if asJSON {
+		v := string(raw)
+		return &v, nil
+	}
+	var v string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
 func (cmd *produceCmd) batchRecords(in chan message, out chan []message) {
 	defer func() { close(out) }()
 
-	messages := []message{}
+	var (
+		messages = []message{}
+		size     int
+	)
 	send := func() {
 		out <- messages
-		messages = []message{}
+		messages, size = []message{}, 0
 	}
 
 	for {
@@ -360,8 +1032,18 @@ func (cmd *produceCmd) batchRecords(in chan message, out chan []message) {
 				return
 			}
 
+			n := 0
+			if m.Key != nil {
+				n += len(*m.Key)
+			}
+			if m.Value != nil {
+				n += len(*m.Value)
+			}
+			cmd.limiter.wait(n)
+
 			messages = append(messages, m)
-			if len(messages) > 0 && len(messages) >= cmd.batch {
+			size += n
+			if (cmd.batch > 0 && len(messages) >= cmd.batch) || (cmd.batchSize > 0 && size >= cmd.batchSize) {
 				send()
 			}
 		case <-time.After(cmd.timeout):
@@ -377,40 +1059,115 @@ type partitionProduceResult struct {
 	count int64
 }
 
+// inputRange is a run of consecutive input lines of a file that were
+// produced to consecutive offsets of a partition, starting at Offset.
+type inputRange struct {
+	File      string `json:"file"`
+	FirstLine int    `json:"firstLine"`
+	LastLine  int    `json:"lastLine"`
+	Offset    int64  `json:"offset"`
+}
+
+// inputRanges maps the messages produced to a partition starting at offset
+// back to their input for -report-inputs.
+func inputRanges(msgs []message, offset int64) []inputRange {
+	ranges := []inputRange{}
+	for i, m := range msgs {
+		if n := len(ranges); n > 0 && ranges[n-1].File == m.file && ranges[n-1].LastLine+1 == m.line {
+			ranges[n-1].LastLine = m.line
+			continue
+		}
+		ranges = append(ranges, inputRange{File: m.file, FirstLine: m.line, LastLine: m.line, Offset: offset + int64(i)})
+	}
+	return ranges
+}
+
+// decodeBytes turns produce input into record bytes with the codecs of
+// encoding.
+func decodeBytes(data string, encoding string) ([]byte, error) {
+	chain, err := codec.ParseChain(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return chain.Encode([]byte(data))
+}
+
+// decodeHeader decodes the value of header key per -decodeheaders.
+func (cmd *produceCmd) decodeHeader(key, value string) ([]byte, error) {
+	enc := cmd.decodeHeaders.of(key)
+	if enc == "avro" {
+		return cmd.avro.encode([]byte(value), cmd.topic+"-"+key, 0)
+	}
+	return decodeBytes(value, enc)
+}
+
+func (cmd *produceCmd) decodeKeyValue(msg message) (key, value []byte, err error) {
+	if msg.Key != nil && cmd.decodeKey == "avro" {
+		if key, err = cmd.avroKey.encode([]byte(*msg.Key), "", 0); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode key as avro, err=%v", err)
+		}
+	} else if msg.Key != nil {
+		if key, err = decodeBytes(*msg.Key, cmd.decodeKey); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode key as %v string, err=%v", cmd.decodeKey, err)
+		}
+	}
+
+	if msg.Value != nil && cmd.decodeValue == "avro" {
+		var (
+			subject string
+			id      int32
+		)
+		if msg.Subject != nil {
+			subject = *msg.Subject
+		}
+		if msg.SchemaID != nil {
+			id = *msg.SchemaID
+		}
+		if value, err = cmd.avro.encode([]byte(*msg.Value), subject, id); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode value as avro, err=%v", err)
+		}
+	} else if msg.Value != nil {
+		if value, err = decodeBytes(*msg.Value, cmd.decodeValue); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode value as %v string, err=%v", cmd.decodeValue, err)
+		}
+		if value, err = cmd.frameValue(msg, value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return key, value, nil
+}
+
+// frameValue prefixes value with the schema registry wire format header, if
+// msg picks its schema via schemaId or subject, e.g. for topics with several
+// record types. The value itself has to be encoded already.
+func (cmd *produceCmd) frameValue(msg message, value []byte) ([]byte, error) {
+	var id int32
+	switch {
+	case msg.SchemaID != nil:
+		id = *msg.SchemaID
+	case msg.Subject != nil && cmd.avro == nil:
+		return nil, fmt.Errorf("subject %v requires -schema-registry", *msg.Subject)
+	case msg.Subject != nil:
+		var err error
+		if id, err = cmd.avro.latestID(*msg.Subject); err != nil {
+			return nil, fmt.Errorf("failed to look up schema of subject %v, err=%v", *msg.Subject, err)
+		}
+	default:
+		return value, nil
+	}
+
+	return joinWireFormat(id, value), nil
+}
+
 func (cmd *produceCmd) makeSaramaMessage(msg message) (*sarama.Message, error) {
 	var (
 		err error
 		sm  = &sarama.Message{Codec: cmd.compression}
 	)
 
-	if msg.Key != nil {
-		switch cmd.decodeKey {
-		case "hex":
-			if sm.Key, err = hex.DecodeString(*msg.Key); err != nil {
-				return sm, fmt.Errorf("failed to decode key as hex string, err=%v", err)
-			}
-		case "base64":
-			if sm.Key, err = base64.StdEncoding.DecodeString(*msg.Key); err != nil {
-				return sm, fmt.Errorf("failed to decode key as base64 string, err=%v", err)
-			}
-		default: // string
-			sm.Key = []byte(*msg.Key)
-		}
-	}
-
-	if msg.Value != nil {
-		switch cmd.decodeValue {
-		case "hex":
-			if sm.Value, err = hex.DecodeString(*msg.Value); err != nil {
-				return sm, fmt.Errorf("failed to decode value as hex string, err=%v", err)
-			}
-		case "base64":
-			if sm.Value, err = base64.StdEncoding.DecodeString(*msg.Value); err != nil {
-				return sm, fmt.Errorf("failed to decode value as base64 string, err=%v", err)
-			}
-		default: // string
-			sm.Value = []byte(*msg.Value)
-		}
+	if sm.Key, sm.Value, err = cmd.decodeKeyValue(msg); err != nil {
+		return sm, err
 	}
 
 	if cmd.version.IsAtLeast(sarama.V0_10_0_0) {
@@ -421,40 +1178,208 @@ func (cmd *produceCmd) makeSaramaMessage(msg message) (*sarama.Message, error) {
 	return sm, nil
 }
 
-func (cmd *produceCmd) produceBatch(leaders map[int32]*sarama.Broker, batch []message, out chan printContext) error {
-	requests := map[*sarama.Broker]*sarama.ProduceRequest{}
-	for _, msg := range batch {
-		broker, ok := leaders[*msg.Partition]
-		if !ok {
-			return fmt.Errorf("non-configured partition %v", *msg.Partition)
+// makeSaramaRecord converts msg into a record for a record batch, which
+// unlike sarama.Message supports headers.
+func (cmd *produceCmd) makeSaramaRecord(msg message) (*sarama.Record, error) {
+	var (
+		err error
+		rec = &sarama.Record{}
+	)
+
+	if rec.Key, rec.Value, err = cmd.decodeKeyValue(msg); err != nil {
+		return rec, err
+	}
+
+	keys := make([]string, 0, len(msg.Headers))
+	for k := range msg.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h := &sarama.RecordHeader{Key: []byte(k)}
+		if v := msg.Headers[k]; v != nil {
+			if h.Value, err = cmd.decodeHeader(k, *v); err != nil {
+				return rec, fmt.Errorf("failed to decode header %#v as %v, err=%v", k, cmd.decodeHeaders.of(k), err)
+			}
 		}
+		rec.Headers = append(rec.Headers, h)
+	}
+	rec.Headers = append(rec.Headers, msg.lineage...)
+
+	return rec, nil
+}
+
+func newRecordBatch(codec sarama.CompressionCodec) *sarama.RecordBatch {
+	now := time.Now()
+	return &sarama.RecordBatch{
+		Version:          2,
+		Codec:            codec,
+		CompressionLevel: sarama.CompressionLevelDefault,
+		FirstTimestamp:   now,
+		MaxTimestamp:     now,
+		ProducerID:       -1,
+		ProducerEpoch:    -1,
+	}
+}
+
+func (cmd *produceCmd) produceBatch(leaders map[int32]*sarama.Broker, batch []message, out chan printContext) error {
+	var (
+		requests = map[*sarama.Broker]*sarama.ProduceRequest{}
+		batches  = map[topicPartition]*sarama.RecordBatch{}
+		version  = produceRequestVersion(cmd.version)
+	)
+	if cmd.compression == sarama.CompressionZSTD {
+		// brokers only accept zstd batches in produce requests v7 and up,
+		// which are encoded like v3.
+		version = 7
+	}
+
+	// requests are only created for brokers that messages are sent to, as
+	// messages that fail to encode are skipped with -on-produce-error.
+	request := func(broker *sarama.Broker) *sarama.ProduceRequest {
 		req, ok := requests[broker]
 		if !ok {
-			req = &sarama.ProduceRequest{RequiredAcks: sarama.WaitForAll, Timeout: 10000}
+			req = &sarama.ProduceRequest{RequiredAcks: sarama.WaitForAll, Timeout: 10000, Version: version}
+			if cmd.session != nil {
+				req.TransactionalID = cmd.session.transactionalID
+			}
 			requests[broker] = req
 		}
+		return req
+	}
+	details := map[*sarama.Broker]*produceDetails{}
+	sized := func(broker *sarama.Broker, tp topicPartition, key, value []byte) {
+		d, ok := details[broker]
+		if !ok {
+			d = &produceDetails{Broker: broker.ID(), Addr: broker.Addr(), partitions: map[topicPartition]bool{}}
+			details[broker] = d
+		}
+		d.partitions[tp] = true
+		d.Records++
+		d.Bytes += len(key) + len(value)
+	}
+	sent := map[topicPartition][]message{}
+	for _, msg := range batch {
+		topic, partitionLeaders := cmd.topic, leaders
+		if len(cmd.transforms) > 0 {
+			var err error
+			if topic, err = applyTransforms(cmd.transforms, cmd.topic, time.Now(), &msg); err != nil {
+				if err = cmd.errors.reject(topic, msg, fmt.Errorf("failed to transform message err=%v", err)); err != nil {
+					return err
+				}
+				continue
+			}
+			if partitionLeaders, err = cmd.topicLeaders(topic); err != nil {
+				return err
+			}
+		}
+
+		broker, ok := partitionLeaders[*msg.Partition]
+		if !ok {
+			if err := cmd.errors.reject(topic, msg, fmt.Errorf("non-configured partition %v", *msg.Partition)); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(msg.Headers) > 0 && version < 3 {
+			if err := cmd.errors.reject(topic, msg, fmt.Errorf("headers require Kafka version 0.11.0.0 or later, got %v", cmd.version)); err != nil {
+				return err
+			}
+			continue
+		}
+		tp := topicPartition{topic, *msg.Partition}
+		if version >= 3 {
+			rec, err := cmd.makeSaramaRecord(msg)
+			if err != nil {
+				if err = cmd.errors.reject(topic, msg, err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			rb, ok := batches[tp]
+			if !ok {
+				rb = newRecordBatch(cmd.compression)
+				batches[tp] = rb
+				request(broker).AddBatch(topic, *msg.Partition, rb)
+			}
+			rec.OffsetDelta = int64(len(rb.Records))
+			rb.LastOffsetDelta = int32(rec.OffsetDelta)
+			rb.Records = append(rb.Records, rec)
+			sent[tp] = append(sent[tp], msg)
+			sized(broker, tp, rec.Key, rec.Value)
+			continue
+		}
 
 		sm, err := cmd.makeSaramaMessage(msg)
 		if err != nil {
+			if err = cmd.errors.reject(topic, msg, err); err != nil {
+				return err
+			}
+			continue
+		}
+		request(broker).AddMessage(topic, *msg.Partition, sm)
+		sent[tp] = append(sent[tp], msg)
+		sized(broker, tp, sm.Key, sm.Value)
+	}
+
+	if cmd.session != nil {
+		tps := make([]topicPartition, 0, len(batches))
+		for tp, rb := range batches {
+			cmd.session.prepareBatch(tp, rb)
+			tps = append(tps, tp)
+		}
+		if err := cmd.session.addPartitions(tps); err != nil {
 			return err
 		}
-		req.AddMessage(cmd.topic, *msg.Partition, sm)
 	}
 
 	for broker, req := range requests {
+		started := time.Now()
 		resp, err := broker.Produce(req)
 		if err != nil {
 			return fmt.Errorf("failed to send request to broker %#v. err=%s", broker, err)
 		}
+		if d := details[broker]; cmd.fetchLog != nil && d != nil {
+			d.Partitions = len(d.partitions)
+			d.LatencyMs = durationMs(time.Since(started))
+			d.ThrottleMs = int64(resp.ThrottleTime / time.Millisecond)
+			cmd.fetchLog.print(produceReport{Produce: *d})
+		}
 
-		offsets, err := readPartitionOffsetResults(resp)
-		if err != nil {
-
-			return fmt.Errorf("failed to read producer response err=%s", err)
+		offsets, failed := readPartitionOffsetResults(resp)
+		for tp, kerr := range failed {
+			// a rejected batch breaks the sequence numbers of idempotent
+			// producers, so they always stop.
+			if cmd.session != nil || !cmd.errors.tolerated() {
+				fmt.Fprintf(os.Stderr, "Failed to send message. err=%s\n", kerr.Error())
+				return fmt.Errorf("failed to read producer response err=%s", kerr)
+			}
+			for _, msg := range sent[tp] {
+				if err := cmd.errors.reject(tp.topic, msg, kerr); err != nil {
+					return err
+				}
+			}
 		}
 
-		for p, o := range offsets {
-			result := map[string]interface{}{"partition": p, "startOffset": o.start, "count": o.count}
+		cmd.recordThrottle(broker, resp.ThrottleTime)
+
+		for tp, o := range offsets {
+			if n := int64(len(sent[tp])); n > 0 {
+				o.count = n
+			}
+			cmd.metrics.add("kt_produce_messages_total", map[string]string{"topic": tp.topic, "partition": fmt.Sprint(tp.partition)}, float64(o.count))
+			result := map[string]interface{}{"partition": tp.partition, "startOffset": o.start, "count": o.count}
+			if cmd.reportInputs {
+				result["inputs"] = inputRanges(sent[tp], o.start)
+			}
+			if tp.topic != cmd.topic {
+				result["topic"] = tp.topic
+			}
+			if resp.ThrottleTime > 0 {
+				result["throttleMs"] = int64(resp.ThrottleTime / time.Millisecond)
+			}
 			ctx := printContext{output: result, done: make(chan struct{})}
 			out <- ctx
 			<-ctx.done
@@ -464,41 +1389,126 @@ func (cmd *produceCmd) produceBatch(leaders map[int32]*sarama.Broker, batch []me
 	return nil
 }
 
-func readPartitionOffsetResults(resp *sarama.ProduceResponse) (map[int32]partitionProduceResult, error) {
-	offsets := map[int32]partitionProduceResult{}
-	for _, blocks := range resp.Blocks {
+// produceReport is printed to stderr for each produce request of -show-fetch.
+type produceReport struct {
+	Produce produceDetails `json:"produce"`
+}
+
+// produceDetails describes a produce request: the broker it was sent to, the
+// partitions, records and bytes of keys and values it carried, and how long
+// the broker took to acknowledge it.
+type produceDetails struct {
+	Broker     int32   `json:"broker"`
+	Addr       string  `json:"addr"`
+	Partitions int     `json:"partitions"`
+	Records    int     `json:"records"`
+	Bytes      int     `json:"bytes"`
+	LatencyMs  float64 `json:"latencyMs"`
+	ThrottleMs int64   `json:"throttleMs"`
+
+	partitions map[topicPartition]bool
+}
+
+func (cmd *produceCmd) recordThrottle(broker *sarama.Broker, throttle time.Duration) {
+	if throttle <= 0 {
+		return
+	}
+
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "broker %v throttled produce request for %v due to quota violation\n", broker.Addr(), throttle)
+	}
+
+	labels := map[string]string{"broker": broker.Addr()}
+	cmd.metrics.add("kt_broker_throttled_responses_total", labels, 1)
+	cmd.metrics.add("kt_broker_throttle_seconds_total", labels, throttle.Seconds())
+}
+
+// readPartitionOffsetResults returns the results of the partitions that
+// were produced to and the errors of those that weren't.
+func readPartitionOffsetResults(resp *sarama.ProduceResponse) (map[topicPartition]partitionProduceResult, map[topicPartition]sarama.KError) {
+	offsets := map[topicPartition]partitionProduceResult{}
+	failed := map[topicPartition]sarama.KError{}
+	for topic, blocks := range resp.Blocks {
 		for partition, block := range blocks {
+			tp := topicPartition{topic, partition}
 			if block.Err != sarama.ErrNoError {
-				fmt.Fprintf(os.Stderr, "Failed to send message. err=%s\n", block.Err.Error())
-				return offsets, block.Err
+				failed[tp] = block.Err
+				continue
 			}
 
-			if r, ok := offsets[partition]; ok {
-				offsets[partition] = partitionProduceResult{start: block.Offset, count: r.count + 1}
+			if r, ok := offsets[tp]; ok {
+				offsets[tp] = partitionProduceResult{start: block.Offset, count: r.count + 1}
 			} else {
-				offsets[partition] = partitionProduceResult{start: block.Offset, count: 1}
+				offsets[tp] = partitionProduceResult{start: block.Offset, count: 1}
 			}
 		}
 	}
-	return offsets, nil
+	return offsets, failed
 }
 
-func (cmd *produceCmd) produce(in chan []message, out chan printContext) {
+func (cmd *produceCmd) produce(in chan []message, out chan printContext) error {
 	for {
 		select {
 		case b, ok := <-in:
 			if !ok {
-				return
+				return nil
+			}
+			if cmd.dryRun {
+				cmd.dryRunBatch(cmd.leaders, b, out)
+				continue
 			}
 			if err := cmd.produceBatch(cmd.leaders, b, out); err != nil {
 				fmt.Fprintln(os.Stderr, err.Error()) // TODO: failf
-				return
+				return err
+			}
+		}
+	}
+}
+
+// watchStateFile is where -watch-dir tracks the processed files of the
+// directory, it's hidden so it isn't processed itself.
+const watchStateFile = ".kt-processed.json"
+
+// setupSource creates the source of -source, -file, -dir or -watch-dir.
+func (cmd *produceCmd) setupSource() source {
+	var (
+		src source
+		err error
+	)
+	switch cmd.sourceSpec.kind {
+	case "stdin":
+		src = &stdinSource{bufferSize: cmd.bufferSize, pipeline: cmd.pipeline}
+	case "file":
+		src, err = newFileSource(cmd.sourceSpec.target, cmd.perFile, cmd.bufferSize)
+	case "dir":
+		src, err = newDirSource(cmd.sourceSpec.target, cmd.perFile, cmd.bufferSize)
+	case "watch":
+		if _, err = dirFiles(cmd.sourceSpec.target); err == nil {
+			src = &watchSource{dir: cmd.sourceSpec.target, interval: time.Second, perFile: cmd.perFile, bufferSize: cmd.bufferSize}
+		}
+	case "watch-dir":
+		if _, err = dirFiles(cmd.sourceSpec.target); err == nil {
+			src = &watchSource{
+				dir:        cmd.sourceSpec.target,
+				interval:   time.Second,
+				bufferSize: cmd.bufferSize,
+				convert:    true,
+				jsonValues: jsonEncoding(cmd.decodeValue),
+				statePath:  filepath.Join(cmd.sourceSpec.target, watchStateFile),
 			}
 		}
+	case "http":
+		src = &httpSource{url: cmd.sourceSpec.target, client: &http.Client{Timeout: time.Minute}, perFile: cmd.perFile, bufferSize: cmd.bufferSize}
+	case "listen":
+		src, err = newListenSource(cmd.sourceSpec.target, cmd.perFile, cmd.bufferSize)
+	}
+	if err != nil {
+		failf("failed to set up input source %#v err=%v", cmd.sourceSpec.target, err)
 	}
+	return src
 }
 
-func (cmd *produceCmd) readInput(q chan struct{}, stdin chan string, out chan string) {
+func (cmd *produceCmd) readInput(q chan struct{}, stdin chan input, out chan input) {
 	defer func() { close(out) }()
 	for {
 		select {
@@ -516,10 +1526,86 @@ func (cmd *produceCmd) readInput(q chan struct{}, stdin chan string, out chan st
 var produceDocString = `
 The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
 The values supplied on the command line win over environment variable values.
-
-Input is read from stdin and separated by newlines.
-
-If you want to use the -partitioner keep in mind that the hashCode
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -tlsca, -tlscert and -tlscertkey can be set via KT_TLS_CA, KT_TLS_CERT and KT_TLS_CERT_KEY.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+The values for -schema-registry, -schema-registry-user and -schema-registry-password can be set via KT_SCHEMA_REGISTRY, KT_SCHEMA_REGISTRY_USER and KT_SCHEMA_REGISTRY_PASSWORD.
+The value for -anonymize-salt can be set via KT_ANONYMIZE_SALT.
+Flags that aren't passed default to the config file's values for the topic and cluster, see "kt consume -help".
+
+Input is read from stdin and separated by newlines. Pass -file to read a
+file instead, or -dir to read the files of a directory in name order. With
+-per-file, each file's contents are one input, e.g. to load a directory of
+JSON fixtures with one message per file:
+
+  $ kt produce -topic fixtures -dir testdata/orders -per-file -literal
+
+-source selects where input comes from in general, -file and -dir are short
+for -source file:<path> and dir:<path>. file:<glob> reads the files matching
+the pattern in name order. watch:<path> produces the files that appear in a
+directory, once they stopped changing, until kt is interrupted. An http or
+https URL reads the response to a GET request. listen:[host]:<port> serves
+HTTP and produces the body of every POST request, which is answered once
+its input was read:
+
+  $ kt produce -topic orders -source 'file:exports/orders-*.jsonl'
+  $ kt produce -topic fixtures -source watch:incoming -per-file -literal
+  $ kt produce -topic events -source listen:localhost:8080
+  $ curl --data-binary @events.jsonl localhost:8080
+
+-watch-dir feeds a topic from the files dropped into a directory, e.g. to
+fill test clusters. It produces the files in the directory and those that
+appear in it, once they stopped changing, until kt is interrupted. Files are
+read according to their extension:
+
+  .json, .jsonl, .ndjson  JSON messages like on stdin, the elements of
+                          arrays as one message each
+  .csv                    one message per row, with a header row; columns
+                          named key, value and partition set those of the
+                          message and the other columns become headers.
+                          Without a value column the other columns form a
+                          JSON object that is the value
+  .avro                   one message per record of an Avro object
+                          container file, the record in the Avro JSON
+                          encoding is the value
+
+Other files are read line by line. Unless -decodevalue is json or avro,
+values of CSV rows and Avro records are produced as JSON strings. Processed
+files are tracked with their size and modification time in
+<dir>/.kt-processed.json, so a restart skips them, while files that are
+replaced are produced again:
+
+  $ kt produce -topic fixtures -watch-dir incoming -decodevalue json
+
+Messages are sent in batches of up to -batch messages or -batch-size bytes
+of keys and values, whichever is reached first, or once no input arrived for
+-linger. Batches are compressed with -compression; zstd requires -version
+2.1.0 or later. To bulk load quickly, batch generously:
+
+  $ kt produce -topic fixtures -file orders.jsonl -batch-size 1048576 -linger 100ms -compression lz4
+
+To replay into a production cluster gently, -rate and -max-bytes-per-sec
+limit the messages and bytes of keys and values produced per second:
+
+  $ kt consume -topic orders -until-end | kt produce -topic orders-replay -rate 500 -max-bytes-per-sec 1048576
+
+Input messages go to the partition given by their "partition", or to
+-partition, with the default -partitioner manual. -partitioner hash
+partitions by key like the Java client (murmur2), hashing keys as decoded
+per -decodekey, random and roundrobin spread messages evenly. These three ignore the partitions of the input, so
+the output of kt consume can be reproduced with a different partitioning:
+
+  $ kt consume -topic orders -until-end | kt produce -topic orders-rehashed -partitioner hash
+
+In a pipeline like this, produce stops reading at an interrupt by default
+and produces the input it has. With -pipeline it keeps reading until stdin
+ends, as the upstream kt consume -pipeline gets the same interrupt and
+closes the pipe after its last message, and it drops a last line without
+newline that the upstream left when it was killed. Memory stays bounded
+either way, as input is only read as fast as it's produced. A second
+interrupt stops right away.
+
+If you want to use the -partitioner hashCode keep in mind that the hashCode
 implementation is not the default for Kafka's producer anymore.
 
 To specify the key, value and partition individually pass it as a JSON object
@@ -527,9 +1613,203 @@ like the following:
 
     {"key": "id-23", "value": "message content", "partition": 0}
 
+Record headers can be passed as a JSON object of header keys to values,
+which are decoded according to -decodeheaders. Headers require Kafka 0.11.0.0
+or later:
+
+    {"key": "id-23", "value": "message content", "headers": {"trace-id": "abc"}}
+
+-decodeheaders can set the encoding per header key, e.g. base64,trace-id=string
+decodes trace-id as a string and all other headers as base64. Headers decoded
+as avro are JSON values that are encoded with the latest schema of the subject
+<topic>-<key> in the schema registry wire format and require -schema-registry.
+
+-decodekey and -decodevalue also support json, gzip and zstd and chains of
+them like gzip+json, see "kt consume -help". With json, the value of each
+input line is a JSON value rather than a string, which is produced
+compacted. gzip+json gzips it afterwards:
+
+    {"key": "id-23", "value": {"event": "signup"}}
+
+To produce avro in the schema registry wire format, pass -decodevalue avro
+and -schema-registry (or set KT_SCHEMA_REGISTRY). The value of each input
+line is then a JSON value in the Avro JSON encoding rather than a string.
+Union values may also be passed without the object naming the branch type.
+-schema either names a schema file, which must be registered under the
+subject <topic>-value already unless -register-schema is set, or a subject
+to use the latest schema of. It defaults to the latest schema of
+<topic>-value.
+
+For topics with several record types, e.g. with the RecordNameStrategy, each
+input line can name the schema of its value via "schemaId" or via "subject"
+for the latest schema of a subject, which requires -schema-registry (or
+KT_SCHEMA_REGISTRY). With -decodevalue avro, kt encodes the value with that
+schema:
+
+    {"key": "id-23", "value": {"id": 23}, "subject": "com.shop.OrderCreated"}
+
+Otherwise kt prefixes the value, which must be encoded already, e.g. Avro
+passed as hex or base64, with the schema registry wire format header:
+
+    {"key": "id-23", "value": "0a6f6c61", "schemaId": 7}
+
+Keys are encoded as avro with -decodekey avro, as JSON values in the Avro
+JSON encoding like values, e.g. for record keys of Kafka Connect or Kafka
+Streams. They use the separate subject <topic>-key: -key-schema names a
+schema file or subject for keys like -schema does for values, and
+-register-schema registers both files. -partitioner hash hashes avro keys in
+the wire format, like the Java client hashes serialized keys:
+
+    {"key": {"tenant": "acme", "id": 23}, "value": {"status": "paid"}}
+
+To record where replayed messages come from, pass -lineage with the name of
+the cluster they were consumed from. Each message then gets the headers
+kt-source-cluster, kt-source-topic, kt-source-partition and kt-source-offset
+as well as kt-run-id, a random ID of the kt run that produced it. The source
+partition and offset are taken from the "partition" and "offset" of the input
+lines, as printed by kt consume, and the topic from "topic" or -lineage-topic.
+Missing ones are omitted:
+
+  $ kt consume -topic orders -brokers old:9092 | kt produce -topic orders -brokers new:9092 -lineage old -lineage-topic orders
+
+To mirror topics between two clusters in both directions without copying
+messages back and forth forever, pass the name of the destination cluster
+to -skip-headers as kt-source-cluster. Messages that were copied from it
+are then skipped:
+
+  $ kt consume -topic orders -brokers b:9092 | kt produce -topic orders -brokers a:9092 -lineage b -lineage-topic orders -skip-headers kt-source-cluster=a
+
+-skip-headers takes a comma separated list of key=value or key elements,
+so other markers work as well, e.g. -skip-headers mirrored.
+
+To keep replays out of downstream tracing systems, -keep-headers and
+-drop-headers select the input headers that are produced by comma separated
+glob patterns, and -rename-header old=new produces a header under another
+key, or with old-*=new-* all headers with a prefix under another one.
+Headers are dropped unless they match -keep-headers, if given, or if they
+match -drop-headers, before the first matching rename applies. -skip-headers
+sees the headers before, -set-header and -lineage add theirs after:
+
+  $ kt consume -topic orders | kt produce -topic orders-replay -drop-headers 'traceparent,tracestate,x-b3-*' -rename-header 'x-internal-*=x-replayed-*'
+
+To edit messages and send them again, e.g. to retry records during an
+incident, -set sets fields of each input message like jq's .path = value
+and -set-header sets headers. Paths start with .key, .value, .partition or
+.headers. Fields within the key or value require it to be a JSON object and
+are set to the JSON value of the argument if it is valid JSON, otherwise to
+the string. Mutations apply in order after -skip-headers:
+
+  $ kt consume -topic orders -offsets 0=1234:1234 | kt produce -topic orders -set-header retry-count=1 -set .value.status=RETRY
+
+To copy production data to a development cluster without personal data,
+-anonymize replaces fields of each input message with pseudonyms after the
+mutations, as .path=method with the paths of -set. Arrays along the path
+apply to each element, e.g. .value.contacts[].email, and messages without
+the field are left alone. The methods are:
+
+  hash            a hex string for strings, an integer for numbers.
+  mask-email      letters and digits of the address except the top level
+                  domain, e.g. jane.doe@example.com to xkqa.mrt@hbqwzpt.com.
+  mask-phone      the digits, keeping length and format.
+  jitter:<max>    shifts a date like 2006-01-02, an RFC 3339 timestamp or
+                  milliseconds since the epoch by up to max either way.
+
+Pseudonyms derive from the value and the secret -anonymize-salt, so the
+same value always gets the same pseudonym and references between records
+and topics still match, e.g. when .key and .value.customerId are both
+hashed. Jitter derives from the message key instead, so the dates of an
+entity keep their distances. Keep the salt secret, with it values that are
+easy to enumerate like phone numbers can be matched to their pseudonyms:
+
+  $ kt consume -topic customers -pipeline | kt produce -brokers dev -topic customers -pipeline -anonymize .key=hash -anonymize .value.email=mask-email -anonymize .value.birthDate=jitter:720h -anonymize-salt "$SALT"
+
+To replay messages the way a Kafka Connect pipeline transforms them, pass
+-transforms with a config file of single message transforms. The file is
+either a properties file or a JSON connector config and lists the transforms
+to apply in order under "transforms":
+
+    transforms=mask,route
+    transforms.mask.type=org.apache.kafka.connect.transforms.MaskField$Value
+    transforms.mask.fields=ssn,card
+    transforms.route.type=org.apache.kafka.connect.transforms.TimestampRouter
+    transforms.route.topic.format=${topic}-${timestamp}
+    transforms.route.timestamp.format=yyyyMMdd
+
+Supported are MaskField, InsertField (except offset.field) and
+TimestampRouter. MaskField and InsertField expect the key or value to be a
+JSON object. The message timestamp is the time it's produced at.
+
 In case the input line cannot be interpeted as a JSON object the key and value
 both default to the input line and partition to 0.
 
+A null or missing "value" produces a tombstone, i.e. a message with a null
+value that deletes its key from compacted topics, while "" produces an empty
+value. Input that can't express null, like -literal lines, can mark
+tombstones with -null-value, its value is then produced as null:
+
+  $ printf 'ola\n\\N\n' | kt produce -topic greetings -literal -null-value '\N'
+
+When a broker throttles a produce request because the client exceeded its
+quota, the output includes the throttle time as "throttleMs" and -verbose logs
+it to stderr. Pass -metricsaddr to expose the throttle time per broker as
+Prometheus metrics under /metrics.
+
+To avoid duplicates when a batch is retried, pass -idempotent. With
+-transactional-id, all input is produced in a single transaction that is
+committed once stdin is closed. On errors or interrupt, the transaction is
+aborted and kt exits with status 1, so read_committed consumers see either
+all of the input or none of it. The transaction has to complete within
+-transaction-timeout. Both require -version 0.11.0.0 or later:
+
+  $ kt produce -topic orders -transactional-id orders-replay < orders.jsonl
+
+-dry-run checks input before it's produced, e.g. a replay file: kt looks up
+the partitions and applies -set, -transforms and the partitioner, encodes
+keys, values and headers, e.g. against their Avro schema in the registry, and
+prints each message's partition and encoded sizes instead of producing it.
+Lines that aren't valid JSON messages are errors unless -literal is set, and
+-register-schema doesn't register the schema. kt exits with status 1 if any
+message is invalid:
+
+  $ kt produce -topic orders -dry-run -decodevalue avro < orders.jsonl
+  {"key":"id-23","keySize":5,"partition":0,"value":"{\"id\":23}","valueSize":6}
+  {"error":"invalid input, use -literal for lines that aren't JSON messages err=...","key":null,"value":"{\"id\":"}
+
+By default kt stops at the first message that fails to produce, e.g. a
+value that doesn't match its Avro schema or a record the broker rejects as
+too large. For bulk loads, -on-produce-error skip skips such messages and
+dlq-file appends them to -dlq-file, with the number of their input line and
+the reason, so they can be fixed and produced again. Either way kt reports
+how many messages failed when it exits. Idempotent and transactional
+producers still stop when the broker rejects a batch:
+
+  $ kt produce -topic orders -decodevalue avro -on-produce-error dlq-file -dlq-file failed.jsonl < orders.jsonl
+  $ cat failed.jsonl
+  {"file":"stdin","line":7,"reason":"failed to encode value as avro, err=...","topic":"orders","partition":0,"key":"id-7","value":"{\"id\":\"7\"}"}
+
+To trace offsets back to the input, -report-inputs adds the input file and
+line numbers of the messages to the output, in runs of consecutive lines
+that were produced to consecutive offsets starting at "offset". Lines count
+from 1 per file, or per request of -source listen. For the JSON, CSV and Avro
+files of -watch-dir they're the number of the JSON value, the line of the CSV
+row or the number of the Avro record:
+
+  $ kt produce -topic orders -source file:'orders-*.jsonl' -batch 100 -report-inputs
+  {"count":100,"inputs":[{"file":"orders-1.jsonl","firstLine":1,"lastLine":100,"offset":2300}],"partition":0,"startOffset":2300}
+
+To find out why producing is slow, -show-fetch prints a JSON line to stderr
+for each produce request: the broker it was sent to, the number of
+partitions, records and bytes of keys and values it carried, how long the
+broker took to acknowledge it and whether it throttled the request. Few
+records per request point at a small -batch, most requests going to the
+same broker at leader imbalance:
+
+  {"produce":{"broker":1,"addr":"localhost:9092","partitions":3,"records":100,"bytes":12800,"latencyMs":8.4,"throttleMs":0}}
+
+Pass -session-stats to write a JSON report to the given file when kt exits.
+It covers the bytes sent and received, requests by type, and request
+latencies in milliseconds, both in total and per broker ID.
+
 Examples:
 
 Send a single message with a specific key:
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// dryRunBatch validates the messages of batch like produceBatch but prints
+// what would be sent rather than sending it: the topic and partition each
+// message goes to and the sizes of its encoded key and value. Messages that
+// can't be produced, e.g. input that isn't valid JSON or values that don't
+// match their Avro schema, are printed with their error and counted in
+// invalid.
+func (cmd *produceCmd) dryRunBatch(leaders map[int32]*sarama.Broker, batch []message, out chan printContext) {
+	for _, msg := range batch {
+		result, err := cmd.dryRunMessage(leaders, msg)
+		if err != nil {
+			cmd.invalid++
+			result["error"] = err.Error()
+		}
+		cmd.checked++
+
+		ctx := printContext{output: result, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+func (cmd *produceCmd) dryRunMessage(leaders map[int32]*sarama.Broker, msg message) (map[string]interface{}, error) {
+	result := map[string]interface{}{"key": msg.Key, "value": msg.Value}
+	if cmd.reportInputs {
+		result["file"], result["line"] = msg.file, msg.line
+	}
+	if len(msg.Headers) > 0 {
+		result["headers"] = msg.Headers
+	}
+	if msg.inputErr != nil {
+		return result, fmt.Errorf("invalid input, use -literal for lines that aren't JSON messages err=%v", msg.inputErr)
+	}
+
+	topic := cmd.topic
+	if len(cmd.transforms) > 0 {
+		var err error
+		if topic, err = applyTransforms(cmd.transforms, cmd.topic, time.Now(), &msg); err != nil {
+			return result, fmt.Errorf("failed to transform message err=%v", err)
+		}
+		if leaders, err = cmd.topicLeaders(topic); err != nil {
+			return result, err
+		}
+		result["key"], result["value"] = msg.Key, msg.Value
+	}
+	if topic != cmd.topic {
+		result["topic"] = topic
+	}
+	result["partition"] = *msg.Partition
+	if _, ok := leaders[*msg.Partition]; !ok {
+		return result, fmt.Errorf("non-configured partition %v", *msg.Partition)
+	}
+	if len(msg.Headers) > 0 && produceRequestVersion(cmd.version) < 3 {
+		return result, fmt.Errorf("headers require Kafka version 0.11.0.0 or later, got %v", cmd.version)
+	}
+
+	rec, err := cmd.makeSaramaRecord(msg)
+	if err != nil {
+		return result, err
+	}
+	result["keySize"], result["valueSize"] = len(rec.Key), len(rec.Value)
+	return result, nil
+}
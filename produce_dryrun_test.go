@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProduceDryRun(t *testing.T) {
+	target := &produceCmd{
+		topic:         "orders",
+		dryRun:        true,
+		version:       sarama.V2_0_0_0,
+		decodeKey:     "string",
+		decodeValue:   "json",
+		decodeHeaders: headerEncodings{fallback: "string"},
+	}
+	leaders := map[int32]*sarama.Broker{0: {}, 1: {}}
+
+	in := make(chan input, 3)
+	messages := make(chan message, 3)
+	in <- input{text: `{"key":"id-23","value":{"id": 23},"partition":1}`}
+	in <- input{text: `{"key":"id-24","value":{"id":`}
+	in <- input{text: `{"key":"id-25","value":{"id":25},"partition":2}`}
+	close(in)
+	target.deserializeLines(in, messages, 2)
+
+	var batch []message
+	for m := range messages {
+		batch = append(batch, m)
+	}
+	out := make(chan printContext)
+	results := make(chan []map[string]interface{})
+	go func() {
+		var rs []map[string]interface{}
+		for i := 0; i < 3; i++ {
+			ctx := <-out
+			rs = append(rs, ctx.output.(map[string]interface{}))
+			close(ctx.done)
+		}
+		results <- rs
+	}()
+	target.dryRunBatch(leaders, batch, out)
+	rs := <-results
+
+	require.Equal(t, int32(1), rs[0]["partition"])
+	require.Equal(t, 5, rs[0]["keySize"])
+	require.Equal(t, 9, rs[0]["valueSize"])
+	require.Nil(t, rs[0]["error"])
+	require.Contains(t, rs[1]["error"], "invalid input")
+	require.Equal(t, "non-configured partition 2", rs[2]["error"])
+	require.Equal(t, 3, target.checked)
+	require.Equal(t, 2, target.invalid)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// produceErrorRecord is written to the -dlq-file for each input message that
+// failed to produce. Besides the input file, line number and reason it
+// carries the message like produce reads it, so the file can be fixed up and
+// produced again.
+type produceErrorRecord struct {
+	File      string             `json:"file"`
+	Line      int                `json:"line"`
+	Reason    string             `json:"reason"`
+	Topic     string             `json:"topic,omitempty"`
+	Partition *int32             `json:"partition,omitempty"`
+	Key       *string            `json:"key"`
+	Value     *string            `json:"value"`
+	Headers   map[string]*string `json:"headers,omitempty"`
+}
+
+// produceErrors handles input messages that fail to produce per
+// -on-produce-error: fail stops produce at the first of them, skip drops them
+// and dlq-file drops them after writing a produceErrorRecord to a file.
+// Either way failed counts them.
+type produceErrors struct {
+	sync.Mutex
+	policy string
+	path   string
+	file   *os.File
+	failed int
+}
+
+func newProduceErrors(policy, path string) (*produceErrors, error) {
+	switch policy {
+	case "", "fail":
+		policy = "fail"
+	case "skip":
+	case "dlq-file":
+		if path == "" {
+			return nil, fmt.Errorf("-on-produce-error dlq-file requires -dlq-file")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported -on-produce-error %#v, only fail, skip and dlq-file are supported", policy)
+	}
+	if path != "" && policy != "dlq-file" {
+		return nil, fmt.Errorf("-dlq-file requires -on-produce-error dlq-file")
+	}
+	return &produceErrors{policy: policy, path: path}, nil
+}
+
+// open opens the -dlq-file for appending, so the failures of earlier runs
+// aren't lost.
+func (e *produceErrors) open() error {
+	if e == nil || e.policy != "dlq-file" {
+		return nil
+	}
+	var err error
+	e.file, err = os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// tolerated returns whether produce continues after messages fail. Like
+// consumeStats, a nil *produceErrors is valid and fails.
+func (e *produceErrors) tolerated() bool {
+	return e != nil && e.policy != "fail"
+}
+
+// reject handles msg that failed to produce due to err on topic. It returns
+// err if produce should stop.
+func (e *produceErrors) reject(topic string, msg message, err error) error {
+	if !e.tolerated() {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	e.failed++
+	if e.file == nil {
+		return nil
+	}
+	rec := produceErrorRecord{
+		File:      msg.file,
+		Line:      msg.line,
+		Reason:    err.Error(),
+		Topic:     topic,
+		Partition: msg.Partition,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   msg.Headers,
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = e.file.Write(append(buf, '\n')); err != nil {
+		return fmt.Errorf("failed to write to %v err=%v", e.path, err)
+	}
+	return nil
+}
+
+// close closes the -dlq-file and reports how many messages failed, so they
+// aren't dropped silently.
+func (e *produceErrors) close() {
+	if e == nil {
+		return
+	}
+	if e.file != nil {
+		if err := e.file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close %v err=%v\n", e.path, err)
+		}
+	}
+	switch {
+	case e.failed == 0:
+	case e.file != nil:
+		fmt.Fprintf(os.Stderr, "%v messages failed to produce, see %v\n", e.failed, e.path)
+	default:
+		fmt.Fprintf(os.Stderr, "skipped %v messages that failed to produce\n", e.failed)
+	}
+}
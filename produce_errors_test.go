@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProduceErrors(t *testing.T) {
+	e, err := newProduceErrors("", "")
+	require.Nil(t, err)
+	require.False(t, e.tolerated())
+
+	e, err = newProduceErrors("skip", "")
+	require.Nil(t, err)
+	require.True(t, e.tolerated())
+
+	_, err = newProduceErrors("dlq-file", "")
+	require.NotNil(t, err)
+	_, err = newProduceErrors("skip", "failed.jsonl")
+	require.NotNil(t, err)
+	_, err = newProduceErrors("retry", "")
+	require.NotNil(t, err)
+
+	var disabled *produceErrors
+	require.False(t, disabled.tolerated())
+	require.NotNil(t, disabled.reject("orders", message{}, sarama.ErrMessageSizeTooLarge))
+}
+
+func TestProduceBatchErrors(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetVersion(3).
+			SetError("orders", 1, sarama.ErrMessageSizeTooLarge),
+	})
+
+	leader := sarama.NewBroker(broker.Addr())
+	require.Nil(t, leader.Open(sarama.NewConfig()))
+	defer leader.Close()
+	leaders := map[int32]*sarama.Broker{0: leader, 1: leader}
+
+	out := make(chan printContext)
+	results := make(chan interface{}, 10)
+	go func() {
+		for ctx := range out {
+			results <- ctx.output
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	newBatch := func() []message {
+		batch := []message{newMessage("zz", "a", 0), newMessage("00", "b", 0), newMessage("01", "c", 1)}
+		for i := range batch {
+			batch[i].file, batch[i].line = "orders.jsonl", i+1
+		}
+		return batch
+	}
+
+	path := filepath.Join(t.TempDir(), "failed.jsonl")
+	errs, err := newProduceErrors("dlq-file", path)
+	require.Nil(t, err)
+	require.Nil(t, errs.open())
+	cmd := &produceCmd{topic: "orders", version: sarama.V2_0_0_0, decodeKey: "hex", decodeValue: "string", errors: errs, reportInputs: true}
+	require.Nil(t, cmd.produceBatch(leaders, newBatch(), out))
+	errs.close()
+	require.Equal(t, 2, errs.failed)
+	require.Equal(t, map[string]interface{}{
+		"partition":   int32(0),
+		"startOffset": int64(0),
+		"count":       int64(1),
+		"inputs":      []inputRange{{File: "orders.jsonl", FirstLine: 2, LastLine: 2, Offset: 0}},
+	}, <-results)
+
+	buf, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	require.Len(t, lines, 2)
+	var failed []produceErrorRecord
+	for _, l := range lines {
+		var rec produceErrorRecord
+		require.Nil(t, json.Unmarshal([]byte(l), &rec))
+		failed = append(failed, rec)
+	}
+	require.Equal(t, "orders.jsonl", failed[0].File)
+	require.Equal(t, 1, failed[0].Line)
+	require.Contains(t, failed[0].Reason, "failed to decode key as hex")
+	require.Equal(t, "zz", *failed[0].Key)
+	require.Equal(t, 3, failed[1].Line)
+	require.Equal(t, "orders", failed[1].Topic)
+	require.Equal(t, sarama.ErrMessageSizeTooLarge.Error(), failed[1].Reason)
+
+	cmd.errors, _ = newProduceErrors("fail", "")
+	require.NotNil(t, cmd.produceBatch(leaders, newBatch(), out))
+}
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/stretchr/testify/require"
 )
@@ -223,6 +232,148 @@ func TestMakeSaramaMessage(t *testing.T) {
 	require.Equal(t, []byte("peter"), actual.Value)
 }
 
+func TestMakeSaramaRecord(t *testing.T) {
+	target := &produceCmd{decodeKey: "string", decodeValue: "string", decodeHeaders: headerEncodings{fallback: "hex"}}
+	key, value, trace, span := "key", "value", "41", "42"
+	msg := message{Key: &key, Value: &value, Headers: map[string]*string{"trace": &trace, "span": &span, "empty": nil}}
+	actual, err := target.makeSaramaRecord(msg)
+	require.Nil(t, err)
+	require.Equal(t, []byte(key), actual.Key)
+	require.Equal(t, []byte(value), actual.Value)
+	require.Equal(t, []*sarama.RecordHeader{
+		{Key: []byte("empty")},
+		{Key: []byte("span"), Value: []byte("B")},
+		{Key: []byte("trace"), Value: []byte("A")},
+	}, actual.Headers)
+
+	bad := "zz"
+	msg.Headers = map[string]*string{"trace": &bad}
+	_, err = target.makeSaramaRecord(msg)
+	require.NotNil(t, err)
+}
+
+func TestFrameValue(t *testing.T) {
+	lookups := 0
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		require.Equal(t, "/subjects/com.shop.OrderCreated/versions/latest", r.URL.Path)
+		fmt.Fprint(w, `{"subject": "com.shop.OrderCreated", "version": 3, "id": 42, "schema": "\"bytes\""}`)
+	}))
+	defer registry.Close()
+
+	target := &produceCmd{decodeKey: "string", decodeValue: "hex", registry: registryArgs{url: registry.URL}}
+	target.setupAvro()
+	value, id, subject := "0a6f6c61", int32(7), "com.shop.OrderCreated"
+
+	_, actual, err := target.decodeKeyValue(message{Value: &value})
+	require.Nil(t, err)
+	require.Equal(t, []byte{0x0a, 0x6f, 0x6c, 0x61}, actual)
+
+	_, actual, err = target.decodeKeyValue(message{Value: &value, SchemaID: &id})
+	require.Nil(t, err)
+	require.Equal(t, []byte{0, 0, 0, 0, 7, 0x0a, 0x6f, 0x6c, 0x61}, actual)
+
+	for i := 0; i < 2; i++ {
+		_, actual, err = target.decodeKeyValue(message{Value: &value, Subject: &subject})
+		require.Nil(t, err)
+		require.Equal(t, []byte{0, 0, 0, 0, 42, 0x0a, 0x6f, 0x6c, 0x61}, actual)
+	}
+	require.Equal(t, 1, lookups)
+
+	target = &produceCmd{decodeKey: "string", decodeValue: "hex"}
+	_, _, err = target.decodeKeyValue(message{Value: &value, Subject: &subject})
+	require.NotNil(t, err)
+}
+
+func TestProduceLineage(t *testing.T) {
+	target := &produceCmd{decodeKey: "string", decodeValue: "string", decodeHeaders: headerEncodings{fallback: "string"}}
+	target.lineage = &lineage{cluster: "old", topic: "orders", runID: "run"}
+
+	in := make(chan input, 2)
+	out := make(chan message)
+	go target.deserializeLines(in, out, 1)
+	in <- input{text: `{"key":"id-23","value":"ola","partition":0,"offset":7,"headers":{"trace":"abc"}}`}
+	in <- input{text: `{"value":"ola","topic":"returns"}`}
+	close(in)
+
+	rec, err := target.makeSaramaRecord(<-out)
+	require.Nil(t, err)
+	require.Equal(t, []*sarama.RecordHeader{
+		{Key: []byte("trace"), Value: []byte("abc")},
+		{Key: []byte("kt-source-cluster"), Value: []byte("old")},
+		{Key: []byte("kt-source-topic"), Value: []byte("orders")},
+		{Key: []byte("kt-source-partition"), Value: []byte("0")},
+		{Key: []byte("kt-source-offset"), Value: []byte("7")},
+		{Key: []byte("kt-run-id"), Value: []byte("run")},
+	}, rec.Headers)
+
+	rec, err = target.makeSaramaRecord(<-out)
+	require.Nil(t, err)
+	require.Equal(t, []*sarama.RecordHeader{
+		{Key: []byte("kt-source-cluster"), Value: []byte("old")},
+		{Key: []byte("kt-source-topic"), Value: []byte("returns")},
+		{Key: []byte("kt-run-id"), Value: []byte("run")},
+	}, rec.Headers)
+}
+
+func TestProduceSkipHeaders(t *testing.T) {
+	target := &produceCmd{decodeHeaders: headerEncodings{fallback: "hex"}, skipHeaders: []headerMatch{{key: "mirrored"}}}
+
+	in := make(chan input, 2)
+	out := make(chan message)
+	go target.deserializeLines(in, out, 1)
+	in <- input{text: `{"value":"skipped","headers":{"mirrored":"61"}}`}
+	in <- input{text: `{"value":"kept","headers":{"trace":"61"}}`}
+	close(in)
+
+	require.Equal(t, "kept", *(<-out).Value)
+	_, ok := <-out
+	require.False(t, ok)
+}
+
+func TestUnmarshalAvroMessage(t *testing.T) {
+	target := &produceCmd{decodeValue: "avro"}
+
+	var msg message
+	require.Nil(t, target.unmarshalMessage(`{"key": "id-23", "value": {"id": 23}, "subject": "orders", "schemaId": 7}`, &msg))
+	require.Equal(t, "id-23", *msg.Key)
+	require.Equal(t, `{"id": 23}`, *msg.Value)
+	require.Equal(t, "orders", *msg.Subject)
+	require.Equal(t, int32(7), *msg.SchemaID)
+
+	msg = message{}
+	require.Nil(t, target.unmarshalMessage(`{"key": "id-23", "value": null}`, &msg))
+	require.Nil(t, msg.Value)
+
+	require.NotNil(t, target.unmarshalMessage(`not json`, &msg))
+}
+
+func TestUnmarshalAvroHeaders(t *testing.T) {
+	target := &produceCmd{decodeValue: "string", decodeHeaders: headerEncodings{fallback: "hex", keys: map[string]string{"meta": "avro"}}}
+
+	var msg message
+	require.Nil(t, target.unmarshalMessage(`{"value": "v", "headers": {"meta": {"id": 23}, "trace": "41", "empty": null}}`, &msg))
+	require.Equal(t, "v", *msg.Value)
+	require.Equal(t, `{"id": 23}`, *msg.Headers["meta"])
+	require.Equal(t, "41", *msg.Headers["trace"])
+	require.Contains(t, msg.Headers, "empty")
+	require.Nil(t, msg.Headers["empty"])
+
+	require.NotNil(t, target.unmarshalMessage(`{"headers": {"trace": 41}}`, &msg))
+}
+
+func TestDecodeHeaderPerKey(t *testing.T) {
+	target := &produceCmd{decodeHeaders: headerEncodings{fallback: "hex", keys: map[string]string{"trace-id": "string"}}}
+
+	actual, err := target.decodeHeader("trace-id", "41")
+	require.Nil(t, err)
+	require.Equal(t, []byte("41"), actual)
+
+	actual, err = target.decodeHeader("span", "41")
+	require.Nil(t, err)
+	require.Equal(t, []byte("A"), actual)
+}
+
 func TestDeserializeLines(t *testing.T) {
 	target := &produceCmd{}
 	target.partitioner = "hashCode"
@@ -267,20 +418,185 @@ func TestDeserializeLines(t *testing.T) {
 	}
 
 	for _, d := range data {
-		in := make(chan string, 1)
+		in := make(chan input, 1)
 		out := make(chan message)
 		target.literal = d.literal
 		target.partition = d.partition
 		go target.deserializeLines(in, out, d.partitionCount)
-		in <- d.in
+		in <- input{text: d.in}
 
 		select {
 		case <-time.After(50 * time.Millisecond):
 			t.Errorf("did not receive output in time")
 		case actual := <-out:
 			if !(reflect.DeepEqual(d.expected, actual)) {
-				t.Errorf(spew.Sprintf("\nexpected %#v\nactual   %#v", d.expected, actual))
+				t.Errorf("%s", spew.Sprintf("\nexpected %#v\nactual   %#v", d.expected, actual))
 			}
 		}
 	}
 }
+
+func TestChoosePartition(t *testing.T) {
+	partition := func(target *produceCmd, key *string, p *int32) int32 {
+		msg := message{Key: key, Partition: p}
+		target.choosePartition(&msg, 3)
+		return *msg.Partition
+	}
+	key, one := "Hans", int32(1)
+
+	target := &produceCmd{partitioner: "manual", partition: 2}
+	require.Equal(t, int32(1), partition(target, &key, &one))
+	require.Equal(t, int32(2), partition(target, &key, nil))
+
+	target = &produceCmd{partitioner: "hash"}
+	require.Equal(t, keyPartitioners["murmur2"]([]byte(key), 3), partition(target, &key, &one))
+	require.Equal(t, []int32{0, 1, 2, 0}, []int32{partition(target, nil, nil), partition(target, nil, nil), partition(target, nil, &one), partition(target, nil, nil)})
+
+	// keys are hashed as the bytes they decode to.
+	hexKey, base64Key, invalid := "6869", "aGk=", "not hex"
+	target = &produceCmd{partitioner: "hash", decodeKey: "hex"}
+	require.Equal(t, keyPartitioners["murmur2"]([]byte("hi"), 3), partition(target, &hexKey, nil))
+	require.Equal(t, keyPartitioners["murmur2"]([]byte(invalid), 3), partition(target, &invalid, nil))
+	target = &produceCmd{partitioner: "hash", decodeKey: "base64"}
+	require.Equal(t, keyPartitioners["murmur2"]([]byte("hi"), 3), partition(target, &base64Key, nil))
+
+	target = &produceCmd{partitioner: "roundrobin"}
+	require.Equal(t, []int32{0, 1, 2, 0}, []int32{partition(target, &key, nil), partition(target, &key, &one), partition(target, &key, nil), partition(target, &key, nil)})
+
+	target = &produceCmd{partitioner: "random", random: rand.New(rand.NewSource(1))}
+	for i := 0; i < 10; i++ {
+		p := partition(target, &key, nil)
+		require.True(t, p >= 0 && p < 3)
+	}
+}
+
+func TestProduceTombstones(t *testing.T) {
+	target := &produceCmd{decodeKey: "string", decodeValue: "string", literal: true, nullValue: `\N`}
+	in := make(chan input, 2)
+	out := make(chan message)
+	go target.deserializeLines(in, out, 1)
+	in <- input{text: `\N`}
+	require.Nil(t, (<-out).Value)
+	in <- input{text: "ola"}
+	require.Equal(t, "ola", *(<-out).Value)
+
+	target.literal = false
+	for l, expected := range map[string][]byte{
+		`{"key":"a","value":null}`:  nil,
+		`{"key":"a"}`:               nil,
+		`{"key":"a","value":""}`:    {},
+		`{"key":"a","value":"\\N"}`: nil,
+	} {
+		var msg message
+		require.Nil(t, target.unmarshalMessage(l, &msg), l)
+		target.nullify(&msg)
+		rec, err := target.makeSaramaRecord(msg)
+		require.Nil(t, err, l)
+		require.Equal(t, expected, rec.Value, l)
+	}
+}
+
+func TestProduceRequestVersion(t *testing.T) {
+	require.Equal(t, int16(0), produceRequestVersion(sarama.V0_8_2_0))
+	require.Equal(t, int16(1), produceRequestVersion(sarama.V0_9_0_1))
+	require.Equal(t, int16(2), produceRequestVersion(sarama.V0_10_0_0))
+	require.Equal(t, int16(3), produceRequestVersion(sarama.V0_11_0_0))
+	require.Equal(t, int16(3), produceRequestVersion(sarama.V2_0_0_0))
+}
+
+func TestBatchRecordsBySize(t *testing.T) {
+	target := &produceCmd{batchSize: 10, timeout: time.Minute}
+	in := make(chan message)
+	out := make(chan []message, 3)
+	go target.batchRecords(in, out)
+
+	in <- newMessage("k", "1234", 0)
+	in <- newMessage("k", "1234", 0) // 10 bytes
+	in <- newMessage("", "1", 0)
+	close(in)
+
+	require.Equal(t, 2, len(<-out))
+	require.Equal(t, 1, len(<-out))
+}
+
+func TestProduceReadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-produce")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.json"), []byte("{\n  \"value\": \"b\"\n}\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("a1\na2\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("x"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+
+	read := func(target *produceCmd) []string {
+		src := target.setupSource()
+		out := make(chan input)
+		go func() {
+			require.NoError(t, src.read(out))
+			close(out)
+		}()
+		lines := []string{}
+		for l := range out {
+			lines = append(lines, l.text)
+		}
+		return lines
+	}
+
+	require.Equal(t, []string{"a1", "a2", "{", `  "value": "b"`, "}"}, read(&produceCmd{sourceSpec: sourceSpec{kind: "dir", target: dir}, bufferSize: 1024}))
+	require.Equal(t, []string{"a1\na2", "{\n  \"value\": \"b\"\n}"}, read(&produceCmd{sourceSpec: sourceSpec{kind: "dir", target: dir}, perFile: true}))
+	require.Equal(t, []string{"a1", "a2"}, read(&produceCmd{sourceSpec: sourceSpec{kind: "file", target: filepath.Join(dir, "a.json")}, bufferSize: 1024}))
+	require.Equal(t, []string{"a1\na2", "{\n  \"value\": \"b\"\n}"}, read(&produceCmd{sourceSpec: sourceSpec{kind: "file", target: filepath.Join(dir, "*.json")}, perFile: true}))
+}
+
+func TestKafkaCompression(t *testing.T) {
+	require.Equal(t, sarama.CompressionNone, kafkaCompression(""))
+	require.Equal(t, sarama.CompressionZSTD, kafkaCompression("zstd"))
+}
+
+func TestInputRanges(t *testing.T) {
+	msgs := []message{
+		{file: "a.jsonl", line: 1},
+		{file: "a.jsonl", line: 2},
+		{file: "a.jsonl", line: 4},
+		{file: "b.jsonl", line: 5},
+		{file: "b.jsonl", line: 6},
+	}
+	require.Equal(t, []inputRange{
+		{File: "a.jsonl", FirstLine: 1, LastLine: 2, Offset: 10},
+		{File: "a.jsonl", FirstLine: 4, LastLine: 4, Offset: 12},
+		{File: "b.jsonl", FirstLine: 5, LastLine: 6, Offset: 13},
+	}, inputRanges(msgs, 10))
+}
+
+func TestProduceBatchShowFetch(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	leader := sarama.NewBroker(broker.Addr())
+	require.Nil(t, leader.Open(sarama.NewConfig()))
+	defer leader.Close()
+	leaders := map[int32]*sarama.Broker{0: leader, 1: leader}
+
+	out := make(chan printContext)
+	go func() {
+		for ctx := range out {
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	buf := &bytes.Buffer{}
+	cmd := &produceCmd{topic: "orders", version: sarama.V2_0_0_0, decodeKey: "string", decodeValue: "string", fetchLog: newFetchLog(true, buf)}
+	batch := []message{newMessage("a", "bc", 0), newMessage("d", "ef", 0), newMessage("g", "hi", 1)}
+	require.Nil(t, cmd.produceBatch(leaders, batch, out))
+
+	var report produceReport
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &report))
+	require.Equal(t, leader.Addr(), report.Produce.Addr)
+	require.Equal(t, 2, report.Produce.Partitions)
+	require.Equal(t, 3, report.Produce.Records)
+	require.Equal(t, 9, report.Produce.Bytes)
+}
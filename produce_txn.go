@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// producerSession is the state of an idempotent producer: the producer ID
+// and epoch assigned by the brokers and the next sequence number per
+// partition, which brokers use to discard duplicate batches. For
+// -transactional-id it also tracks the transaction coordinator and the
+// partitions added to the transaction.
+type producerSession struct {
+	transactionalID *string
+	coordinator     *sarama.Broker
+	producerID      int64
+	producerEpoch   int16
+	sequences       map[topicPartition]int32
+	added           map[topicPartition]bool
+}
+
+const (
+	initProducerRetries = 10
+	initProducerBackoff = 200 * time.Millisecond
+)
+
+// retriableInitProducerError reports whether InitProducerId may succeed
+// later, e.g. while an earlier transaction of the same transactional ID is
+// still being aborted after a failed run.
+func retriableInitProducerError(err sarama.KError) bool {
+	switch err {
+	case sarama.ErrConcurrentTransactions, sarama.ErrOffsetsLoadInProgress, sarama.ErrNotCoordinatorForConsumer, sarama.ErrConsumerCoordinatorNotAvailable:
+		return true
+	}
+	return false
+}
+
+func anyLeader(leaders map[int32]*sarama.Broker) *sarama.Broker {
+	for _, b := range leaders {
+		return b
+	}
+	return nil
+}
+
+func (cmd *produceCmd) findTransactionCoordinator(cfg *sarama.Config) (*sarama.Broker, error) {
+	req := &sarama.FindCoordinatorRequest{Version: 1, CoordinatorKey: cmd.transactionalID, CoordinatorType: sarama.CoordinatorTransaction}
+	resp, err := anyLeader(cmd.leaders).FindCoordinator(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, resp.Err
+	}
+
+	if err = resp.Coordinator.Open(cfg); err != nil && err != sarama.ErrAlreadyConnected {
+		return nil, err
+	}
+	return resp.Coordinator, nil
+}
+
+// setupProducerSession initializes the producer ID for -idempotent and
+// -transactional-id, the latter also begins the transaction all input is
+// produced in.
+func (cmd *produceCmd) setupProducerSession() error {
+	if !cmd.idempotent && cmd.transactionalID == "" {
+		return nil
+	}
+
+	session := &producerSession{sequences: map[topicPartition]int32{}, added: map[topicPartition]bool{}}
+	req := &sarama.InitProducerIDRequest{}
+	broker := anyLeader(cmd.leaders)
+
+	if cmd.transactionalID != "" {
+		cfg, err := cmd.saramaConfig()
+		if err != nil {
+			return err
+		}
+		if broker, err = cmd.findTransactionCoordinator(cfg); err != nil {
+			return fmt.Errorf("failed to find transaction coordinator err=%v", err)
+		}
+		session.transactionalID = &cmd.transactionalID
+		session.coordinator = broker
+		req.TransactionalID = &cmd.transactionalID
+		req.TransactionTimeout = cmd.transactionTimeout
+	}
+
+	for i := 0; ; i++ {
+		resp, err := broker.InitProducerID(req)
+		if err != nil {
+			return fmt.Errorf("failed to initialize producer id err=%v", err)
+		}
+		if resp.Err == sarama.ErrNoError {
+			session.producerID, session.producerEpoch = resp.ProducerID, resp.ProducerEpoch
+			break
+		}
+		if !retriableInitProducerError(resp.Err) || i >= initProducerRetries {
+			return fmt.Errorf("failed to initialize producer id err=%v", resp.Err)
+		}
+		time.Sleep(initProducerBackoff)
+	}
+
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "initialized producer id %v with epoch %v\n", session.producerID, session.producerEpoch)
+	}
+	cmd.session = session
+	return nil
+}
+
+// prepareBatch marks rb as part of the session and assigns its sequence
+// numbers, it has to be called once all records are added.
+func (s *producerSession) prepareBatch(tp topicPartition, rb *sarama.RecordBatch) {
+	rb.ProducerID = s.producerID
+	rb.ProducerEpoch = s.producerEpoch
+	rb.FirstSequence = s.sequences[tp]
+	rb.IsTransactional = s.transactionalID != nil
+	s.sequences[tp] += int32(len(rb.Records))
+}
+
+// addPartitions adds the partitions the transaction doesn't cover yet,
+// brokers reject transactional writes to other partitions.
+func (s *producerSession) addPartitions(tps []topicPartition) error {
+	if s.transactionalID == nil {
+		return nil
+	}
+
+	req := &sarama.AddPartitionsToTxnRequest{
+		TransactionalID: *s.transactionalID,
+		ProducerID:      s.producerID,
+		ProducerEpoch:   s.producerEpoch,
+		TopicPartitions: map[string][]int32{},
+	}
+	for _, tp := range tps {
+		if !s.added[tp] {
+			req.TopicPartitions[tp.topic] = append(req.TopicPartitions[tp.topic], tp.partition)
+		}
+	}
+	if len(req.TopicPartitions) == 0 {
+		return nil
+	}
+
+	resp, err := s.coordinator.AddPartitionsToTxn(req)
+	if err != nil {
+		return fmt.Errorf("failed to add partitions to transaction err=%v", err)
+	}
+	for topic, errs := range resp.Errors {
+		for _, pe := range errs {
+			if pe.Err != sarama.ErrNoError {
+				return fmt.Errorf("failed to add partition %v of topic %v to transaction err=%v", pe.Partition, topic, pe.Err)
+			}
+		}
+	}
+
+	for topic, parts := range req.TopicPartitions {
+		for _, p := range parts {
+			s.added[topicPartition{topic, p}] = true
+		}
+	}
+	return nil
+}
+
+// endTransaction commits or aborts the transaction, it's a no-op unless
+// -transactional-id is set.
+func (s *producerSession) endTransaction(commit bool) error {
+	if s == nil || s.transactionalID == nil {
+		return nil
+	}
+
+	resp, err := s.coordinator.EndTxn(&sarama.EndTxnRequest{
+		TransactionalID:   *s.transactionalID,
+		ProducerID:        s.producerID,
+		ProducerEpoch:     s.producerEpoch,
+		TransactionResult: commit,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Err != sarama.ErrNoError {
+		return resp.Err
+	}
+	return nil
+}
+
+func (s *producerSession) close() {
+	if s != nil && s.coordinator != nil {
+		logClose("transaction coordinator", s.coordinator)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProducerSessionTransaction(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorTransaction, "replay", broker),
+		"InitProducerIDRequest": sarama.NewMockSequence(
+			&sarama.InitProducerIDResponse{Err: sarama.ErrConcurrentTransactions},
+			&sarama.InitProducerIDResponse{ProducerID: 7, ProducerEpoch: 2},
+		),
+		"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{
+			Errors: map[string][]*sarama.PartitionError{"orders": {{Partition: 0, Err: sarama.ErrNoError}}},
+		}),
+		"EndTxnRequest": sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+	})
+
+	leader := sarama.NewBroker(broker.Addr())
+	cmd := &produceCmd{version: sarama.V2_0_0_0, transactionalID: "replay", idempotent: true, transactionTimeout: time.Minute}
+	cfg, err := cmd.saramaConfig()
+	require.Nil(t, err)
+	require.Nil(t, leader.Open(cfg))
+	defer leader.Close()
+	cmd.leaders = map[int32]*sarama.Broker{0: leader}
+
+	require.Nil(t, cmd.setupProducerSession())
+	defer cmd.session.close()
+	require.Equal(t, int64(7), cmd.session.producerID)
+	require.Equal(t, int16(2), cmd.session.producerEpoch)
+
+	tp := topicPartition{"orders", 0}
+	rb := newRecordBatch(sarama.CompressionNone)
+	rb.Records = []*sarama.Record{{}, {}}
+	cmd.session.prepareBatch(tp, rb)
+	require.Equal(t, int64(7), rb.ProducerID)
+	require.Equal(t, int32(0), rb.FirstSequence)
+	require.True(t, rb.IsTransactional)
+
+	rb = newRecordBatch(sarama.CompressionNone)
+	rb.Records = []*sarama.Record{{}}
+	cmd.session.prepareBatch(tp, rb)
+	require.Equal(t, int32(2), rb.FirstSequence)
+
+	require.Nil(t, cmd.session.addPartitions([]topicPartition{tp}))
+	require.Nil(t, cmd.session.addPartitions([]topicPartition{tp}))
+	require.Nil(t, cmd.session.endTransaction(true))
+
+	var added []map[string][]int32
+	var ended []bool
+	for _, rr := range broker.History() {
+		switch req := rr.Request.(type) {
+		case *sarama.AddPartitionsToTxnRequest:
+			added = append(added, req.TopicPartitions)
+		case *sarama.EndTxnRequest:
+			ended = append(ended, req.TransactionResult)
+		case *sarama.InitProducerIDRequest:
+			require.Equal(t, "replay", *req.TransactionalID)
+		}
+	}
+	require.Equal(t, []map[string][]int32{{"orders": {0}}}, added)
+	require.Equal(t, []bool{true}, ended)
+}
+
+func TestProducerSessionIdempotent(t *testing.T) {
+	var session *producerSession
+	require.Nil(t, session.endTransaction(false))
+	session.close()
+
+	session = &producerSession{producerID: 3, sequences: map[topicPartition]int32{}, added: map[topicPartition]bool{}}
+	rb := newRecordBatch(sarama.CompressionNone)
+	rb.Records = []*sarama.Record{{}}
+	session.prepareBatch(topicPartition{"orders", 1}, rb)
+	require.Equal(t, int64(3), rb.ProducerID)
+	require.False(t, rb.IsTransactional)
+	require.Nil(t, session.addPartitions([]topicPartition{{"orders", 1}}))
+	require.Nil(t, session.endTransaction(true))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressVersion is the version of the -progress-fd event format. It's
+// increased when fields change meaning or are removed, adding fields or
+// events doesn't change it.
+const progressVersion = 1
+
+// progressReporter writes progress events for -progress-fd as JSON lines,
+// separate from the data on stdout. Progress of a partition is reported at
+// most once per interval. Like sessionStats, a nil *progressReporter is
+// valid and does nothing.
+type progressReporter struct {
+	sync.Mutex
+	w        io.Writer
+	command  string
+	interval time.Duration
+	reported map[topicPartition]time.Time
+}
+
+// progressEvent is a single line of the progress protocol. Event is one of
+// start, partition-start, progress, partition-done and done. Offset is the
+// offset of the last message read and Messages the number of messages
+// processed of the partition so far.
+type progressEvent struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Version   int       `json:"version,omitempty"`
+	Command   string    `json:"command,omitempty"`
+	Topic     string    `json:"topic,omitempty"`
+	Partition *int32    `json:"partition,omitempty"`
+	Start     *int64    `json:"start,omitempty"`
+	End       *int64    `json:"end,omitempty"`
+	Offset    *int64    `json:"offset,omitempty"`
+	Messages  *int      `json:"messages,omitempty"`
+}
+
+// newProgressReporter writes progress events to the file descriptor fd,
+// which the process embedding kt passes in, e.g. via 3>progress.jsonl.
+// Negative file descriptors disable reporting.
+func newProgressReporter(command string, fd int, interval time.Duration) (*progressReporter, error) {
+	if fd < 0 {
+		return nil, nil
+	}
+	if fd == 0 || fd == 1 {
+		return nil, fmt.Errorf("invalid progress file descriptor %v, stdin and stdout are reserved for data", fd)
+	}
+	return &progressReporter{w: os.NewFile(uintptr(fd), "progress"), command: command, interval: interval, reported: map[topicPartition]time.Time{}}, nil
+}
+
+func (r *progressReporter) emit(ev progressEvent) {
+	ev.Time = time.Now().UTC()
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to marshal progress event err=%v\n", err)
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if _, err := r.w.Write(append(buf, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write progress event err=%v\n", err)
+	}
+}
+
+func (r *progressReporter) start() {
+	if r == nil {
+		return
+	}
+	r.emit(progressEvent{Event: "start", Version: progressVersion, Command: r.command})
+}
+
+// partitionStarted reports the offsets of the first and last message to read
+// from the partition, end is omitted if the partition is read until kt is
+// stopped and start if it's yet unknown, e.g. for the oldest offset.
+func (r *progressReporter) partitionStarted(topic string, partition int32, start, end int64) {
+	if r == nil {
+		return
+	}
+	ev := progressEvent{Event: "partition-start", Topic: topic, Partition: &partition}
+	if start >= 0 {
+		ev.Start = &start
+	}
+	if end >= 0 && end < 1<<63-1 {
+		ev.End = &end
+	}
+	r.emit(ev)
+}
+
+func (r *progressReporter) progress(topic string, partition int32, offset int64, messages int) {
+	if r == nil {
+		return
+	}
+
+	tp := topicPartition{topic, partition}
+	now := time.Now()
+	r.Lock()
+	due := now.Sub(r.reported[tp]) >= r.interval
+	if due {
+		r.reported[tp] = now
+	}
+	r.Unlock()
+
+	if due {
+		r.emit(progressEvent{Event: "progress", Topic: topic, Partition: &partition, Offset: &offset, Messages: &messages})
+	}
+}
+
+// partitionDone reports that kt stopped reading the partition, offset is
+// negative if no message was read.
+func (r *progressReporter) partitionDone(topic string, partition int32, offset int64, messages int) {
+	if r == nil {
+		return
+	}
+	ev := progressEvent{Event: "partition-done", Topic: topic, Partition: &partition, Messages: &messages}
+	if offset >= 0 {
+		ev.Offset = &offset
+	}
+	r.emit(ev)
+}
+
+func (r *progressReporter) done() {
+	if r == nil {
+		return
+	}
+	r.emit(progressEvent{Event: "done"})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := &progressReporter{w: &buf, command: "consume", interval: time.Hour, reported: map[topicPartition]time.Time{}}
+
+	r.start()
+	r.partitionStarted("orders", 1, 3, 1<<63-1)
+	r.progress("orders", 1, 3, 1)
+	r.progress("orders", 1, 4, 2) // within the interval
+	r.partitionDone("orders", 1, 4, 2)
+	r.done()
+
+	events := []map[string]interface{}{}
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		ev := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal([]byte(l), &ev))
+		require.NotEmpty(t, ev["time"])
+		delete(ev, "time")
+		events = append(events, ev)
+	}
+
+	require.Equal(t, []map[string]interface{}{
+		{"event": "start", "version": float64(1), "command": "consume"},
+		{"event": "partition-start", "topic": "orders", "partition": float64(1), "start": float64(3)},
+		{"event": "progress", "topic": "orders", "partition": float64(1), "offset": float64(3), "messages": float64(1)},
+		{"event": "partition-done", "topic": "orders", "partition": float64(1), "offset": float64(4), "messages": float64(2)},
+		{"event": "done"},
+	}, events)
+}
+
+func TestNewProgressReporter(t *testing.T) {
+	r, err := newProgressReporter("consume", -1, time.Second)
+	require.Nil(t, err)
+	require.Nil(t, r)
+	r.start() // nil reporters do nothing
+
+	_, err = newProgressReporter("consume", 1, time.Second)
+	require.NotNil(t, err)
+}
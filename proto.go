@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// decoder decodes keys or values into a value that marshals to JSON.
+type decoder interface {
+	decode(data []byte) (interface{}, error)
+}
+
+// parseProtoFile parses the .proto file at path including its imports, which
+// are resolved relative to its directory.
+func parseProtoFile(path string) (*protoregistry.Files, error) {
+	p := protoparse.Parser{ImportPaths: []string{filepath.Dir(path)}}
+	fds, err := p.ParseFiles(filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	return newProtoFiles(fds)
+}
+
+// parseProtoSchema parses the .proto source of a schema registry schema.
+// Its imports are resolved from refs, the sources of the referenced schemas
+// by import path.
+func parseProtoSchema(name, schema string, refs map[string]string) (protoreflect.FileDescriptor, error) {
+	sources := map[string]string{name: schema}
+	for k, v := range refs {
+		sources[k] = v
+	}
+
+	p := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(sources)}
+	fds, err := p.ParseFiles(name)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := newProtoFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	return files.FindFileByPath(name)
+}
+
+func newProtoFiles(fds []*desc.FileDescriptor) (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var add func(fd *desc.FileDescriptor)
+	add = func(fd *desc.FileDescriptor) {
+		if seen[fd.GetName()] {
+			return
+		}
+		seen[fd.GetName()] = true
+		for _, dep := range fd.GetDependencies() {
+			add(dep)
+		}
+		set.File = append(set.File, fd.AsFileDescriptorProto())
+	}
+	for _, fd := range fds {
+		add(fd)
+	}
+
+	return protodesc.NewFiles(set)
+}
+
+type protoDecoder struct {
+	descriptor protoreflect.MessageDescriptor
+}
+
+func newProtoDecoder(files *protoregistry.Files, name string) (*protoDecoder, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message type %v err=%v", name, err)
+	}
+
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a message type", name)
+	}
+
+	return &protoDecoder{descriptor: md}, nil
+}
+
+func (d *protoDecoder) decode(data []byte) (interface{}, error) {
+	return decodeProto(d.descriptor, data)
+}
+
+func decodeProto(md protoreflect.MessageDescriptor, data []byte) (interface{}, error) {
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	js, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(js), nil
+}
+
+// splitMessageIndexes splits the payload of a protobuf schema registry
+// message into the message indexes and the encoded message. The indexes
+// locate the message type in the schema, the first is the index of a top
+// level message and the following ones of nested messages. They're zig-zag
+// varints prefixed by their count, a count of 0 is short for the first
+// message.
+func splitMessageIndexes(payload []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(payload)
+	if n <= 0 || count < 0 || count > int64(len(payload)) {
+		return nil, nil, fmt.Errorf("invalid protobuf message indexes")
+	}
+	payload = payload[n:]
+	if count == 0 {
+		return []int{0}, payload, nil
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		idx, n := binary.Varint(payload)
+		if n <= 0 || idx < 0 {
+			return nil, nil, fmt.Errorf("invalid protobuf message indexes")
+		}
+		indexes[i] = int(idx)
+		payload = payload[n:]
+	}
+	return indexes, payload, nil
+}
+
+// protoMessageByIndexes finds the message type of a schema registry message
+// by its message indexes.
+func protoMessageByIndexes(fd protoreflect.FileDescriptor, indexes []int) (protoreflect.MessageDescriptor, error) {
+	var (
+		md       protoreflect.MessageDescriptor
+		messages = fd.Messages()
+	)
+	for _, i := range indexes {
+		if i >= messages.Len() {
+			return nil, fmt.Errorf("message index %v out of range for %v", indexes, fd.Path())
+		}
+		md = messages.Get(i)
+		messages = md.Messages()
+	}
+	return md, nil
+}
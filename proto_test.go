@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testProto = `syntax = "proto3";
+package shop;
+
+import "common.proto";
+
+message Order {
+  int64 id = 1;
+  string customer = 2;
+  repeated shop.common.Tag tags = 3;
+}
+`
+
+const testCommonProto = `syntax = "proto3";
+package shop.common;
+
+message Tag {
+  string name = 1;
+}
+`
+
+func TestProtoDecoder(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "order.proto"), []byte(testProto), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "common.proto"), []byte(testCommonProto), 0644))
+
+	files, err := parseProtoFile(filepath.Join(dir, "order.proto"))
+	require.Nil(t, err)
+
+	d, err := newProtoDecoder(files, "shop.Order")
+	require.Nil(t, err)
+
+	_, err = newProtoDecoder(files, "shop.Missing")
+	require.NotNil(t, err)
+
+	msg := dynamicpb.NewMessage(d.descriptor)
+	fields := d.descriptor.Fields()
+	msg.Set(fields.ByName("id"), protoreflect.ValueOfInt64(23))
+	msg.Set(fields.ByName("customer"), protoreflect.ValueOfString("Arni"))
+	tags := msg.Mutable(fields.ByName("tags")).List()
+	tag := tags.NewElement()
+	tag.Message().Set(tag.Message().Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("vip"))
+	tags.Append(tag)
+
+	data, err := proto.Marshal(msg)
+	require.Nil(t, err)
+
+	actual, err := d.decode(data)
+	require.Nil(t, err)
+
+	var decoded map[string]interface{}
+	require.Nil(t, json.Unmarshal(actual.(json.RawMessage), &decoded))
+	require.Equal(t, map[string]interface{}{
+		"id":       "23",
+		"customer": "Arni",
+		"tags":     []interface{}{map[string]interface{}{"name": "vip"}},
+	}, decoded)
+
+	_, err = d.decode([]byte{0xff, 0xff})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter throttles the messages and bytes of -rate and
+// -max-bytes-per-sec with a token bucket each. Both allow bursts of one
+// second's worth. Like sessionStats, a nil *rateLimiter is valid and doesn't
+// limit anything.
+type rateLimiter struct {
+	sync.Mutex
+	messages *tokenBucket
+	bytes    *tokenBucket
+	sleep    func(time.Duration)
+}
+
+type tokenBucket struct {
+	rate     float64 // tokens per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// newRateLimiter returns nil if neither limit is set.
+func newRateLimiter(rate float64, bytesPerSec int) (*rateLimiter, error) {
+	if rate < 0 || bytesPerSec < 0 {
+		return nil, fmt.Errorf("rate limits cannot be negative")
+	}
+	if rate == 0 && bytesPerSec == 0 {
+		return nil, nil
+	}
+
+	l := &rateLimiter{sleep: time.Sleep}
+	if rate > 0 {
+		l.messages = newTokenBucket(rate)
+	}
+	if bytesPerSec > 0 {
+		l.bytes = newTokenBucket(float64(bytesPerSec))
+	}
+	return l, nil
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	capacity := rate
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &tokenBucket{rate: rate, capacity: capacity, tokens: capacity}
+}
+
+// take removes n tokens and returns how long to wait until they're
+// available. Tokens may go into debt so that messages larger than the
+// bucket still pass, just delayed accordingly.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait blocks until a message of size bytes may pass. Waiting holds the
+// lock, so concurrent callers are admitted one after another.
+func (l *rateLimiter) wait(size int) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	delay := l.messages.take(1, now)
+	if d := l.bytes.take(float64(size), now); d > delay {
+		delay = d
+	}
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10)
+
+	// a second's worth passes as a burst
+	for i := 0; i < 10; i++ {
+		require.Equal(t, time.Duration(0), b.take(1, now))
+	}
+	require.Equal(t, 100*time.Millisecond, b.take(1, now))
+
+	// refills at the rate, up to the capacity
+	require.Equal(t, time.Duration(0), b.take(1, now.Add(200*time.Millisecond)))
+	b.take(0, now.Add(time.Hour))
+	require.Equal(t, 10.0, b.tokens)
+
+	// takes larger than the capacity go into debt
+	require.Equal(t, 2*time.Second, b.take(30, now.Add(time.Hour)))
+}
+
+func TestRateLimiter(t *testing.T) {
+	l, err := newRateLimiter(0, 0)
+	require.NoError(t, err)
+	require.Nil(t, l)
+	l.wait(100)
+
+	_, err = newRateLimiter(-1, 0)
+	require.Error(t, err)
+
+	l, err = newRateLimiter(1000, 10)
+	require.NoError(t, err)
+	slept := []time.Duration{}
+	l.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	l.wait(10)
+	require.Equal(t, 0, len(slept))
+	l.wait(5)
+	require.Equal(t, 1, len(slept))
+	require.InDelta(t, float64(500*time.Millisecond), float64(slept[0]), float64(10*time.Millisecond))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// replaySessionCmd renders the records of a kt consume -record file like
+// kt consume would, with kt consume's flags for decoding, filtering and
+// output, without connecting to Kafka.
+type replaySessionCmd struct {
+	path    string
+	consume consumeCmd
+}
+
+func (cmd *replaySessionCmd) run(as []string) {
+	cmd.parseArgs(as)
+
+	session, err := openSession(cmd.path)
+	if err != nil {
+		failf("failed to open session file err=%v", err)
+	}
+	defer session.close()
+
+	cmd.consume.parseArgs(replayConsumeArgs(as[1:], session.info.Topics))
+	cmd.consume.replay(session)
+}
+
+func (cmd *replaySessionCmd) parseArgs(as []string) {
+	for _, a := range as {
+		if a == "-h" || a == "-help" || a == "--help" {
+			quitf(replaySessionDocString)
+		}
+	}
+	if len(as) == 0 || strings.HasPrefix(as[0], "-") {
+		failUsage("kt replay-session requires the path of a session file as its first argument.", "kt replay-session")
+	}
+	cmd.path = as[0]
+}
+
+// replayConsumeArgs adds the recorded topics to args unless they select
+// topics with -topic themselves.
+func replayConsumeArgs(args []string, topics []string) []string {
+	for _, a := range args {
+		if a == "-topic" || a == "--topic" || strings.HasPrefix(a, "-topic=") || strings.HasPrefix(a, "--topic=") {
+			return args
+		}
+	}
+	if len(topics) == 1 {
+		return append([]string{"-topic", topics[0]}, args...)
+	}
+	quoted := make([]string, len(topics))
+	for i, t := range topics {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+	return append([]string{"-topic", strings.Join(quoted, "|"), "-topic-regex"}, args...)
+}
+
+// replay prints the records of session from the selected topics as consume
+// prints fetched records.
+func (cmd *consumeCmd) replay(session *sessionReader) {
+	cmd.topics = []string{cmd.topic}
+	if cmd.topicRegex != nil {
+		cmd.topics = matchTopics(cmd.topicRegex, session.info.Topics)
+	}
+	selected := map[string]bool{}
+	for _, t := range cmd.topics {
+		selected[t] = true
+	}
+
+	cmd.setupAvro()
+	cmd.setupProto()
+	cmd.setupCodecs()
+	cmd.setupSink()
+	if cmd.sink != nil {
+		defer cmd.sink.close()
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	partitions := []topicPartition{}
+	seen := map[topicPartition]bool{}
+	for {
+		msg, err := session.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			failf("failed to read session file err=%v", err)
+		}
+		if !selected[msg.Topic] {
+			continue
+		}
+		if tp := (topicPartition{msg.Topic, msg.Partition}); !seen[tp] {
+			seen[tp] = true
+			partitions = append(partitions, tp)
+		}
+		if !cmd.printMessage(out, msg) {
+			break
+		}
+	}
+
+	for _, tp := range partitions {
+		cmd.flushRepeats(out, tp.topic, tp.partition)
+	}
+	if len(partitions) == 0 {
+		fmt.Fprintf(os.Stderr, "found no records of topics %v in the session file\n", cmd.topics)
+	}
+	cmd.printStats()
+	cmd.reportIncompleteChunks()
+	cmd.clipboard.copy()
+}
+
+var replaySessionDocString = `Usage of replay-session:
+
+  kt replay-session <session file> [kt consume flags]
+
+"kt replay-session" prints the records that "kt consume -record" recorded,
+e.g. to iterate on the decoding, -filter or -template of an expensive read
+from production without fetching the records again:
+
+  $ kt consume -topic orders -offsets all=newest-100000: -until-end -record orders.ktrec > /dev/null
+  $ kt replay-session orders.ktrec -encodevalue json -filter 'value.status == "FAILED"'
+  $ kt replay-session orders.ktrec -encodevalue json | jq .value.total
+
+The session file holds all records that kt consume fetched, before -filter,
+sampling or -max-messages dropped any of them, in the order they were
+fetched. Its first line records when and from which brokers and topics.
+
+kt consume's flags for decoding (-encodekey, -encodevalue, -encodeheaders,
+-protofile, -zstd-dict, -chunk-headers, ...), selecting (-filter, -sample,
+-max-messages, -tombstones, ...) and output (-output, -template, -stats,
+-out-file, ...) apply to the recorded records, see "kt consume -help".
+-topic, or -topic with -topic-regex, selects among the recorded topics, all of
+them are printed by default. Flags that choose what to fetch, like -offsets
+or -group, don't apply. Avro still requires the schema registry to read the
+schemas of the recorded records.`
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type retryPatternCmd struct {
+	clientConfig
+
+	subcommand    string // status or drain
+	topic         string
+	retryPatterns []string
+	dlqPatterns   []string
+	group         string
+	level         string
+	maxMessages   int
+	yes           bool
+	timeout       time.Duration
+	verbose       bool
+	pretty        bool
+
+	client sarama.Client
+}
+
+type retryPatternArgs struct {
+	clientArgs
+
+	topic       string
+	retry       string
+	dlq         string
+	group       string
+	level       string
+	maxMessages int
+	yes         bool
+	timeout     time.Duration
+	verbose     bool
+	pretty      bool
+}
+
+// Default names of retry and dead letter topics, e.g. of Spring Kafka's
+// retryable topics and common hand-rolled conventions. {topic} is replaced
+// by the main topic.
+const (
+	defaultRetryPatterns = "{topic}-retry*,{topic}.retry*,{topic}__retry*"
+	defaultDLQPatterns   = "{topic}-dlt,{topic}-dlq,{topic}.DLT,{topic}.DLQ,{topic}__dlq"
+)
+
+// retryLevel is a retry or dead letter topic of the family. Depth counts the
+// records after the committed offsets of -group, or all retained records
+// without -group, Oldest is the timestamp of the oldest of them.
+type retryLevel struct {
+	Topic       string     `json:"topic"`
+	Kind        string     `json:"kind"` // retry or dlq
+	Partitions  int        `json:"partitions"`
+	Depth       int64      `json:"depth"`
+	Oldest      *time.Time `json:"oldest,omitempty"`
+	OldestAgeMs *int64     `json:"oldestAgeMs,omitempty"`
+
+	delay time.Duration // parsed from the name's suffix for ordering
+}
+
+// drainResult is printed per drained partition of a level.
+type drainResult struct {
+	Topic       string `json:"topic"`
+	Partition   int32  `json:"partition"`
+	Drained     int    `json:"drained"`
+	FirstOffset *int64 `json:"firstOffset,omitempty"`
+	LastOffset  *int64 `json:"lastOffset,omitempty"`
+}
+
+// retryTopicPatterns replaces {topic} in the comma separated glob patterns.
+func retryTopicPatterns(patterns, topic string) ([]string, error) {
+	result := []string{}
+	for _, p := range strings.Split(patterns, ",") {
+		p = strings.ReplaceAll(strings.TrimSpace(p), "{topic}", topic)
+		if _, err := path.Match(p, ""); p == "" || err != nil {
+			return nil, fmt.Errorf("invalid pattern %#v", p)
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+var retryDelayRE = regexp.MustCompile(`(\d+)(ms|s|m|h|d)?$`)
+
+// retryDelay parses the delay of a retry topic from its suffix like 5m or
+// 5000, which Spring Kafka uses for milliseconds, or returns -1.
+func retryDelay(topic string) time.Duration {
+	m := retryDelayRE.FindStringSubmatch(topic)
+	if m == nil {
+		return -1
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return -1
+	}
+	unit := map[string]time.Duration{"": time.Millisecond, "ms": time.Millisecond, "s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[m[2]]
+	return time.Duration(n) * unit
+}
+
+// findRetryLevels returns the retry and dead letter topics among topics,
+// retry levels ordered by their delay, then the dead letter topics.
+func findRetryLevels(topics []string, main string, retry, dlq []string) []retryLevel {
+	levels := []retryLevel{}
+	for _, t := range topics {
+		switch {
+		case t == main:
+		case matchAnyPattern(dlq, t):
+			levels = append(levels, retryLevel{Topic: t, Kind: "dlq"})
+		case matchAnyPattern(retry, t):
+			levels = append(levels, retryLevel{Topic: t, Kind: "retry", delay: retryDelay(t)})
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		a, b := levels[i], levels[j]
+		if a.Kind != b.Kind {
+			return a.Kind == "retry"
+		}
+		if a.delay != b.delay {
+			return a.delay < b.delay
+		}
+		return a.Topic < b.Topic
+	})
+	return levels
+}
+
+func (cmd *retryPatternCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	topics, err := cmd.client.Topics()
+	if err != nil {
+		failf("failed to read topics err=%v", err)
+	}
+	levels := findRetryLevels(topics, cmd.topic, cmd.retryPatterns, cmd.dlqPatterns)
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	if cmd.subcommand == "drain" {
+		cmd.drain(out, levels)
+		return
+	}
+
+	if len(levels) == 0 {
+		fmt.Fprintf(os.Stderr, "found no retry or dead letter topics of topic %v\n", cmd.topic)
+	}
+	for _, l := range levels {
+		if err = cmd.inspect(&l, time.Now()); err != nil {
+			failf("failed to inspect topic %v err=%v", l.Topic, err)
+		}
+		ctx := printContext{output: l, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+// levelRange returns the offsets of a partition of a level that are still
+// to be processed: from the committed offset of -group, or the oldest one,
+// up to the high water mark.
+func (cmd *retryPatternCmd) levelRange(topic string, partition int32, committed map[int32]int64) (start, end int64, err error) {
+	if start, err = cmd.client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+		return 0, 0, err
+	}
+	if end, err = cmd.client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+		return 0, 0, err
+	}
+	if c, ok := committed[partition]; ok && c > start {
+		start = c
+	}
+	if start > end {
+		start = end
+	}
+	return start, end, nil
+}
+
+func (cmd *retryPatternCmd) committedOffsets(topic string, partitions []int32) (map[int32]int64, error) {
+	if cmd.group == "" {
+		return nil, nil
+	}
+	return fetchCommittedOffsets(cmd.client, cmd.group, topic, partitions)
+}
+
+// inspect fills in the depth of level and the age of its oldest record.
+func (cmd *retryPatternCmd) inspect(level *retryLevel, now time.Time) error {
+	partitions, err := cmd.client.Partitions(level.Topic)
+	if err != nil {
+		return err
+	}
+	committed, err := cmd.committedOffsets(level.Topic, partitions)
+	if err != nil {
+		return err
+	}
+
+	level.Partitions = len(partitions)
+	for _, p := range partitions {
+		start, end, err := cmd.levelRange(level.Topic, p, committed)
+		if err != nil {
+			return err
+		}
+		if end <= start {
+			continue
+		}
+		level.Depth += end - start
+
+		msgs, err := readRecordsAt(cmd.client, level.Topic, p, start, 1, cmd.timeout)
+		if err != nil {
+			return err
+		}
+		if len(msgs) > 0 && (level.Oldest == nil || msgs[0].Timestamp.Before(*level.Oldest)) {
+			ts := msgs[0].Timestamp
+			level.Oldest = &ts
+		}
+	}
+	if level.Oldest != nil {
+		age := now.Sub(*level.Oldest).Milliseconds()
+		level.OldestAgeMs = &age
+	}
+	return nil
+}
+
+// drain produces the records of -level that are still to be processed back
+// to the main topic, and commits their offsets for -group so the retry
+// consumer skips them.
+func (cmd *retryPatternCmd) drain(out chan printContext, levels []retryLevel) {
+	found := false
+	for _, l := range levels {
+		found = found || l.Topic == cmd.level
+	}
+	if !found {
+		failf("topic %v isn't a retry or dead letter topic of topic %v", cmd.level, cmd.topic)
+	}
+
+	partitions, err := cmd.client.Partitions(cmd.level)
+	if err != nil {
+		failf("failed to read partitions of topic %v err=%v", cmd.level, err)
+	}
+	committed, err := cmd.committedOffsets(cmd.level, partitions)
+	if err != nil {
+		failf("%v", err)
+	}
+	mainPartitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions of topic %v err=%v", cmd.topic, err)
+	}
+
+	ranges := map[int32][2]int64{}
+	depth := int64(0)
+	for _, p := range partitions {
+		start, end, err := cmd.levelRange(cmd.level, p, committed)
+		if err != nil {
+			failf("failed to read offsets of partition %v of topic %v err=%v", p, cmd.level, err)
+		}
+		ranges[p] = [2]int64{start, end}
+		depth += end - start
+	}
+	if depth == 0 {
+		fmt.Fprintf(os.Stderr, "found no records to drain in topic %v\n", cmd.level)
+		return
+	}
+	if cmd.maxMessages > 0 && depth > int64(cmd.maxMessages) {
+		depth = int64(cmd.maxMessages)
+	}
+	if !cmd.yes && !confirmf("drain %v records of topic %v to topic %v?", depth, cmd.level, cmd.topic) {
+		failf("aborted")
+	}
+
+	producer, err := sarama.NewSyncProducerFromClient(cmd.client)
+	if err != nil {
+		failf("failed to create producer err=%v", err)
+	}
+	defer logClose("producer", producer)
+
+	consumer, err := sarama.NewConsumerFromClient(cmd.client)
+	if err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", consumer)
+
+	remaining := int(depth)
+	for _, p := range partitions {
+		r := ranges[p]
+		if r[1] <= r[0] || remaining == 0 {
+			continue
+		}
+		result, err := cmd.drainPartition(consumer, producer, p, r[0], r[1], int32(len(mainPartitions)), remaining)
+		remaining -= result.Drained
+		if result.LastOffset != nil && cmd.group != "" {
+			if cerr := commitGroupOffset(cmd.client, cmd.group, cmd.level, p, *result.LastOffset+1); cerr != nil {
+				failf("drained partition %v of topic %v but %v", p, cmd.level, cerr)
+			}
+		}
+		ctx := printContext{output: result, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+		if err != nil {
+			failf("failed to drain partition %v of topic %v err=%v", p, cmd.level, err)
+		}
+	}
+}
+
+// drainPartition produces up to max records of the partition from start to
+// end to the main topic, partitioned by key like the Java client. Records
+// without key keep their partition if the main topic has it.
+func (cmd *retryPatternCmd) drainPartition(consumer sarama.Consumer, producer sarama.SyncProducer, partition int32, start, end int64, partitions int32, max int) (drainResult, error) {
+	result := drainResult{Topic: cmd.level, Partition: partition}
+	pc, err := consumer.ConsumePartition(cmd.level, partition, start)
+	if err != nil {
+		return result, err
+	}
+	defer logClose("partition consumer", pc)
+
+	for result.Drained < max {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset >= end {
+				return result, nil
+			}
+			p := partition % partitions
+			if msg.Key != nil {
+				p = keyPartitioners["murmur2"](msg.Key, partitions)
+			}
+			headers := []sarama.RecordHeader{}
+			for _, h := range msg.Headers {
+				headers = append(headers, *h)
+			}
+			pm := &sarama.ProducerMessage{Topic: cmd.topic, Partition: p, Headers: headers, Timestamp: msg.Timestamp}
+			if msg.Key != nil {
+				pm.Key = sarama.ByteEncoder(msg.Key)
+			}
+			if msg.Value != nil {
+				pm.Value = sarama.ByteEncoder(msg.Value)
+			}
+			if _, _, err = producer.SendMessage(pm); err != nil {
+				return result, err
+			}
+			offset := msg.Offset
+			if result.FirstOffset == nil {
+				result.FirstOffset = &offset
+			}
+			result.LastOffset = &offset
+			result.Drained++
+			if offset >= end-1 {
+				return result, nil
+			}
+		case err := <-pc.Errors():
+			return result, err
+		case <-time.After(cmd.timeout):
+			// the newest offsets may be transaction markers.
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+func (cmd *retryPatternCmd) saramaConfig() *sarama.Config {
+	cfg := cmd.clientConfig.saramaConfig("retrypattern")
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+
+	return cfg
+}
+
+func (cmd *retryPatternCmd) failStartup(msg string) {
+	failUsage(msg, "kt retrypattern "+cmd.subcommand)
+}
+
+func (cmd *retryPatternCmd) parseArgs(as []string) {
+	var err error
+
+	if len(as) == 0 || (as[0] != "status" && as[0] != "drain") {
+		failUsage("kt retrypattern requires a subcommand, status or drain.", "kt retrypattern status")
+	}
+	cmd.subcommand = as[0]
+	args := cmd.parseFlags(as[1:])
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if cmd.retryPatterns, err = retryTopicPatterns(args.retry, args.topic); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid -retry-topics err=%v", err))
+	}
+	if cmd.dlqPatterns, err = retryTopicPatterns(args.dlq, args.topic); err != nil {
+		cmd.failStartup(fmt.Sprintf("invalid -dlq-topics err=%v", err))
+	}
+	if cmd.subcommand == "drain" && args.level == "" {
+		cmd.failStartup("-level is required to drain a retry or dead letter topic.")
+	}
+	if cmd.subcommand == "status" && (args.level != "" || args.maxMessages != 0 || args.yes) {
+		cmd.failStartup("-level, -max-messages and -yes only apply to drain.")
+	}
+	if args.maxMessages < 0 {
+		cmd.failStartup(fmt.Sprintf("invalid -max-messages %v, expected a positive number or 0 for all.", args.maxMessages))
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.group = args.group
+	cmd.level = args.level
+	cmd.maxMessages = args.maxMessages
+	cmd.yes = args.yes
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *retryPatternCmd) parseFlags(as []string) retryPatternArgs {
+	var args retryPatternArgs
+	flags := flag.NewFlagSet("retrypattern "+cmd.subcommand, flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Main topic of the retry topic family (required).")
+	flags.StringVar(&args.retry, "retry-topics", defaultRetryPatterns, "Comma separated glob patterns of the retry topics, {topic} stands for -topic.")
+	flags.StringVar(&args.dlq, "dlq-topics", defaultDLQPatterns, "Comma separated glob patterns of the dead letter topics, {topic} stands for -topic.")
+	flags.StringVar(&args.group, "group", "", "Consumer group of the retry topics, to count only records after its committed offsets and commit drained ones.")
+	flags.StringVar(&args.level, "level", "", "Retry or dead letter topic to drain back to -topic (required for drain).")
+	flags.IntVar(&args.maxMessages, "max-messages", 0, "Maximum number of records to drain, 0 drains all.")
+	flags.BoolVar(&args.yes, "yes", false, "Skip the confirmation prompt of drain.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Time to wait for records of a partition.")
+	args.clientArgs.addFlags(flags)
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of retrypattern %v:\n", cmd.subcommand)
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, retryPatternDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var retryPatternDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt retrypattern" works with the retry and dead letter topics of a main
+topic, e.g. orders-retry-5m, orders-retry-1h and orders-dlt for orders. The
+topics are found by the glob patterns of -retry-topics and -dlq-topics, in
+which {topic} stands for -topic. The defaults cover Spring Kafka's retryable
+topics and common conventions:
+
+  -retry-topics  ` + defaultRetryPatterns + `
+  -dlq-topics    ` + defaultDLQPatterns + `
+
+"kt retrypattern status" prints each level, retry topics ordered by the
+delay in their name, e.g. 30s, 5m or 5000 for milliseconds, followed by the
+dead letter topics. Per level, depth counts the records still to be
+processed, after the committed offsets of -group or all retained records
+without -group, and oldest is the timestamp of the oldest of them.
+
+"kt retrypattern drain" produces the records of -level still to be processed
+back to -topic, e.g. once the bug that sent them to the dead letter topic is
+fixed. Keys, values, headers and timestamps are kept, records are
+partitioned by key like the Java client does. With -group, kt commits the
+offsets of the drained records so the retry consumer doesn't process them
+again, which requires the group to be stopped. kt asks for confirmation
+unless -yes is given.
+
+  $ kt retrypattern status -topic orders -group orders-retry
+  $ kt retrypattern drain -topic orders -level orders-dlt -max-messages 100`
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for topic, expected := range map[string]time.Duration{
+		"orders-retry-5m":    5 * time.Minute,
+		"orders-retry-30s":   30 * time.Second,
+		"orders-retry-5000":  5 * time.Second,
+		"orders-retry-250ms": 250 * time.Millisecond,
+		"orders.retry.1d":    24 * time.Hour,
+		"orders-retry":       -1,
+	} {
+		require.Equal(t, expected, retryDelay(topic), topic)
+	}
+}
+
+func TestFindRetryLevels(t *testing.T) {
+	retry, err := retryTopicPatterns(defaultRetryPatterns, "orders")
+	require.NoError(t, err)
+	dlq, err := retryTopicPatterns(defaultDLQPatterns, "orders")
+	require.NoError(t, err)
+	require.Equal(t, []string{"orders-retry*", "orders.retry*", "orders__retry*"}, retry)
+
+	levels := findRetryLevels([]string{
+		"orders-dlt",
+		"orders-retry-1h",
+		"orders",
+		"orders-retry-30s",
+		"payments-retry-5m",
+		"orders-retry-5m",
+		"orders-retry",
+		"orders-events",
+	}, "orders", retry, dlq)
+
+	topics := []string{}
+	kinds := []string{}
+	for _, l := range levels {
+		topics = append(topics, l.Topic)
+		kinds = append(kinds, l.Kind)
+	}
+	require.Equal(t, []string{"orders-retry", "orders-retry-30s", "orders-retry-5m", "orders-retry-1h", "orders-dlt"}, topics)
+	require.Equal(t, []string{"retry", "retry", "retry", "retry", "dlq"}, kinds)
+
+	_, err = retryTopicPatterns("{topic}-retry-[", "orders")
+	require.Error(t, err)
+}
+
+func TestRetryPatternParseArgs(t *testing.T) {
+	target := &retryPatternCmd{}
+	target.parseArgs([]string{"drain", "-topic", "orders", "-level", "orders-dlt", "-retry-topics", "{topic}-r-*", "-max-messages", "10", "-yes"})
+	require.Equal(t, "drain", target.subcommand)
+	require.Equal(t, []string{"orders-r-*"}, target.retryPatterns)
+	require.Equal(t, "orders-dlt", target.level)
+	require.Equal(t, 10, target.maxMessages)
+	require.True(t, target.yes)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// saslArgs bundles the SASL flags that are shared by all commands.
+type saslArgs struct {
+	mechanism string
+	user      string
+	password  string
+
+	// Kerberos settings of GSSAPI
+	krb5Config string
+	keytab     string
+	principal  string
+	realm      string
+}
+
+// readSASLEnv fills in values that weren't supplied via flags from the
+// environment variables KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD,
+// and KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM for GSSAPI.
+func readSASLEnv(args saslArgs) saslArgs {
+	if args.mechanism == "" {
+		args.mechanism = os.Getenv("KT_SASL_MECHANISM")
+	}
+	if args.user == "" {
+		args.user = os.Getenv("KT_SASL_USER")
+	}
+	if args.password == "" {
+		args.password = os.Getenv("KT_SASL_PASSWORD")
+	}
+	if args.krb5Config == "" {
+		args.krb5Config = os.Getenv("KT_KRB5_CONFIG")
+	}
+	if args.keytab == "" {
+		args.keytab = os.Getenv("KT_KEYTAB")
+	}
+	if args.principal == "" {
+		args.principal = os.Getenv("KT_PRINCIPAL")
+	}
+	if args.realm == "" {
+		args.realm = os.Getenv("KT_REALM")
+	}
+	return args
+}
+
+// setupSASL enables SASL authentication in cfg for the given mechanism. It
+// expects cfg.Version to be set already to pick the handshake version.
+func setupSASL(cfg *sarama.Config, args saslArgs) error {
+	kerberos := args.krb5Config != "" || args.keytab != "" || args.principal != "" || args.realm != ""
+	if strings.ToUpper(args.mechanism) == sarama.SASLTypeGSSAPI {
+		return setupGSSAPI(cfg, args)
+	}
+	if kerberos {
+		return fmt.Errorf("Kerberos config, keytab, principal and realm require SASL mechanism GSSAPI")
+	}
+
+	if args.mechanism == "" {
+		if args.user != "" || args.password != "" {
+			return fmt.Errorf("SASL user and password require a SASL mechanism")
+		}
+		return nil
+	}
+
+	if args.user == "" || args.password == "" {
+		return fmt.Errorf("SASL mechanism %v requires user and password", args.mechanism)
+	}
+
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.User = args.user
+	cfg.Net.SASL.Password = args.password
+	if cfg.Version.IsAtLeast(sarama.V1_0_0_0) {
+		cfg.Net.SASL.Version = sarama.SASLHandshakeV1
+	}
+
+	switch strings.ToUpper(args.mechanism) {
+	case sarama.SASLTypePlaintext:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: scram.HashGeneratorFcn(sha256.New)}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: scram.HashGeneratorFcn(sha512.New)}
+		}
+	default:
+		return fmt.Errorf("unsupported SASL mechanism %#v, only PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 and GSSAPI are supported", args.mechanism)
+	}
+
+	return nil
+}
+
+// setupGSSAPI configures Kerberos authentication with the keytab if one is
+// given, otherwise with the password. The principal defaults to the SASL
+// user and its realm is used unless one is given explicitly. Brokers are
+// expected to run as the Kerberos service "kafka".
+func setupGSSAPI(cfg *sarama.Config, args saslArgs) error {
+	principal := args.principal
+	if principal == "" {
+		principal = args.user
+	}
+	name, realm := principal, ""
+	if i := strings.LastIndex(principal, "@"); i >= 0 {
+		name, realm = principal[:i], principal[i+1:]
+	}
+	if args.realm != "" {
+		realm = args.realm
+	}
+	if name == "" {
+		return fmt.Errorf("SASL mechanism GSSAPI requires a principal")
+	}
+	if realm == "" {
+		return fmt.Errorf("SASL mechanism GSSAPI requires a realm, either via the principal or explicitly")
+	}
+
+	krb5Config := args.krb5Config
+	if krb5Config == "" {
+		krb5Config = os.Getenv("KRB5_CONFIG")
+	}
+	if krb5Config == "" {
+		krb5Config = "/etc/krb5.conf"
+	}
+
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.Mechanism = sarama.SASLTypeGSSAPI
+	if cfg.Version.IsAtLeast(sarama.V1_0_0_0) {
+		cfg.Net.SASL.Version = sarama.SASLHandshakeV1
+	}
+	cfg.Net.SASL.GSSAPI = sarama.GSSAPIConfig{
+		KerberosConfigPath: krb5Config,
+		ServiceName:        "kafka",
+		Username:           name,
+		Realm:              realm,
+	}
+
+	switch {
+	case args.keytab != "":
+		cfg.Net.SASL.GSSAPI.AuthType = sarama.KRB5_KEYTAB_AUTH
+		cfg.Net.SASL.GSSAPI.KeyTabPath = args.keytab
+	case args.password != "":
+		cfg.Net.SASL.GSSAPI.AuthType = sarama.KRB5_USER_AUTH
+		cfg.Net.SASL.GSSAPI.Password = args.password
+	default:
+		return fmt.Errorf("SASL mechanism GSSAPI requires a keytab or password")
+	}
+
+	return nil
+}
+
+// scramClient implements sarama.SCRAMClient on top of xdg-go/scram.
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(user, password, authzID string) (err error) {
+	if c.Client, err = c.HashGeneratorFcn.NewClient(user, password, authzID); err != nil {
+		return err
+	}
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
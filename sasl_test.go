@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupSASL(t *testing.T) {
+	data := []struct {
+		args              saslArgs
+		expectedErr       bool
+		expectedEnable    bool
+		expectedMechanism sarama.SASLMechanism
+		expectedSCRAM     bool
+	}{
+		{args: saslArgs{}},
+		{args: saslArgs{user: "hans"}, expectedErr: true},
+		{args: saslArgs{mechanism: "PLAIN", user: "hans"}, expectedErr: true},
+		{args: saslArgs{mechanism: "GSSAPI", user: "hans", password: "pw"}, expectedErr: true},
+		{
+			args:              saslArgs{mechanism: "plain", user: "hans", password: "pw"},
+			expectedEnable:    true,
+			expectedMechanism: sarama.SASLTypePlaintext,
+		},
+		{
+			args:              saslArgs{mechanism: "SCRAM-SHA-256", user: "hans", password: "pw"},
+			expectedEnable:    true,
+			expectedMechanism: sarama.SASLTypeSCRAMSHA256,
+			expectedSCRAM:     true,
+		},
+		{
+			args:              saslArgs{mechanism: "SCRAM-SHA-512", user: "hans", password: "pw"},
+			expectedEnable:    true,
+			expectedMechanism: sarama.SASLTypeSCRAMSHA512,
+			expectedSCRAM:     true,
+		},
+	}
+
+	for _, d := range data {
+		cfg := sarama.NewConfig()
+		cfg.Version = sarama.V2_0_0_0
+		err := setupSASL(cfg, d.args)
+		if d.expectedErr {
+			require.Error(t, err, "args %#v", d.args)
+			continue
+		}
+		require.NoError(t, err, "args %#v", d.args)
+		require.Equal(t, d.expectedEnable, cfg.Net.SASL.Enable)
+		if !d.expectedEnable {
+			continue
+		}
+		require.Equal(t, d.expectedMechanism, cfg.Net.SASL.Mechanism)
+		require.Equal(t, sarama.SASLHandshakeV1, cfg.Net.SASL.Version)
+		require.Equal(t, d.expectedSCRAM, cfg.Net.SASL.SCRAMClientGeneratorFunc != nil)
+		require.NoError(t, cfg.Validate())
+	}
+}
+
+func TestReadSASLEnv(t *testing.T) {
+	os.Setenv("KT_SASL_MECHANISM", "PLAIN")
+	os.Setenv("KT_SASL_USER", "hans")
+	os.Setenv("KT_SASL_PASSWORD", "pw")
+	defer os.Unsetenv("KT_SASL_MECHANISM")
+	defer os.Unsetenv("KT_SASL_USER")
+	defer os.Unsetenv("KT_SASL_PASSWORD")
+
+	require.Equal(t, saslArgs{mechanism: "PLAIN", user: "hans", password: "pw"}, readSASLEnv(saslArgs{}))
+	require.Equal(t, saslArgs{mechanism: "SCRAM-SHA-256", user: "hans", password: "secret"}, readSASLEnv(saslArgs{mechanism: "SCRAM-SHA-256", password: "secret"}))
+
+	os.Setenv("KT_KEYTAB", "/etc/kt.keytab")
+	os.Setenv("KT_REALM", "EXAMPLE.COM")
+	defer os.Unsetenv("KT_KEYTAB")
+	defer os.Unsetenv("KT_REALM")
+	actual := readSASLEnv(saslArgs{mechanism: "GSSAPI", principal: "kt"})
+	require.Equal(t, "/etc/kt.keytab", actual.keytab)
+	require.Equal(t, "EXAMPLE.COM", actual.realm)
+	require.Equal(t, "kt", actual.principal)
+}
+
+func TestSetupGSSAPI(t *testing.T) {
+	data := []struct {
+		args        saslArgs
+		expectedErr bool
+		expected    sarama.GSSAPIConfig
+	}{
+		{args: saslArgs{mechanism: "GSSAPI", principal: "kt"}, expectedErr: true},
+		{args: saslArgs{mechanism: "GSSAPI", principal: "kt@EXAMPLE.COM"}, expectedErr: true},
+		{args: saslArgs{mechanism: "PLAIN", user: "hans", password: "pw", keytab: "kt.keytab"}, expectedErr: true},
+		{args: saslArgs{keytab: "kt.keytab"}, expectedErr: true},
+		{
+			args: saslArgs{mechanism: "gssapi", principal: "kt@EXAMPLE.COM", keytab: "kt.keytab", krb5Config: "krb5.conf"},
+			expected: sarama.GSSAPIConfig{
+				AuthType:           sarama.KRB5_KEYTAB_AUTH,
+				KeyTabPath:         "kt.keytab",
+				KerberosConfigPath: "krb5.conf",
+				ServiceName:        "kafka",
+				Username:           "kt",
+				Realm:              "EXAMPLE.COM",
+			},
+		},
+		{
+			args: saslArgs{mechanism: "GSSAPI", user: "hans@EXAMPLE.COM", password: "pw", realm: "OTHER.COM", krb5Config: "krb5.conf"},
+			expected: sarama.GSSAPIConfig{
+				AuthType:           sarama.KRB5_USER_AUTH,
+				Password:           "pw",
+				KerberosConfigPath: "krb5.conf",
+				ServiceName:        "kafka",
+				Username:           "hans",
+				Realm:              "OTHER.COM",
+			},
+		},
+	}
+
+	for _, d := range data {
+		cfg := sarama.NewConfig()
+		cfg.Version = sarama.V2_0_0_0
+		err := setupSASL(cfg, d.args)
+		if d.expectedErr {
+			require.Error(t, err, "args %#v", d.args)
+			continue
+		}
+		require.NoError(t, err, "args %#v", d.args)
+		require.Equal(t, sarama.SASLMechanism(sarama.SASLTypeGSSAPI), cfg.Net.SASL.Mechanism)
+		require.Equal(t, d.expected, cfg.Net.SASL.GSSAPI)
+		require.NoError(t, cfg.Validate())
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// registryArgs bundles the schema registry flags that are shared by consume
+// and produce.
+type registryArgs struct {
+	url        string
+	user       string
+	password   string
+	tlsCA      string
+	tlsCert    string
+	tlsCertKey string
+}
+
+// readRegistryEnv fills in values that weren't supplied via flags from the
+// environment variables KT_SCHEMA_REGISTRY, KT_SCHEMA_REGISTRY_USER and
+// KT_SCHEMA_REGISTRY_PASSWORD.
+func readRegistryEnv(args registryArgs) registryArgs {
+	if args.url == "" {
+		args.url = os.Getenv("KT_SCHEMA_REGISTRY")
+	}
+	if args.user == "" {
+		args.user = os.Getenv("KT_SCHEMA_REGISTRY_USER")
+	}
+	if args.password == "" {
+		args.password = os.Getenv("KT_SCHEMA_REGISTRY_PASSWORD")
+	}
+	return args
+}
+
+// schemaRegistry is a minimal Confluent schema registry client that caches
+// the schemas it fetched by ID or version and the IDs it looked up, so each
+// is requested at most once per run. It doesn't connect before the first
+// lookup. Besides Avro, it decodes JSON Schema and Protobuf data.
+type schemaRegistry struct {
+	url      string
+	user     string
+	password string
+	client   *http.Client
+
+	sync.Mutex
+	fetched  map[int32]registrySchema
+	schemas  map[int32]*avroSchema
+	protos   map[int32]protoreflect.FileDescriptor
+	versions map[string]int32 // subject/version to ID, except for latest
+	ids      map[string]int32 // subject and schema to ID
+}
+
+// newSchemaRegistry creates a client for the registry at args.url. Basic
+// auth credentials are taken from the URL unless given via args.
+func newSchemaRegistry(args registryArgs) (*schemaRegistry, error) {
+	u, err := url.Parse(args.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema registry URL err=%v", err)
+	}
+
+	user, password := args.user, args.password
+	if u.User != nil {
+		if user == "" {
+			user = u.User.Username()
+			password, _ = u.User.Password()
+		}
+		u.User = nil
+	}
+	if password != "" && user == "" {
+		return nil, fmt.Errorf("schema registry password requires a user")
+	}
+
+	tlsConfig, err := setupCerts(args.tlsCert, args.tlsCA, args.tlsCertKey)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &schemaRegistry{
+		url:      strings.TrimSuffix(u.String(), "/"),
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		fetched:  map[int32]registrySchema{},
+		schemas:  map[int32]*avroSchema{},
+		protos:   map[int32]protoreflect.FileDescriptor{},
+		versions: map[string]int32{},
+		ids:      map[string]int32{},
+	}, nil
+}
+
+type registrySchema struct {
+	ID         int32             `json:"id"`
+	Version    int               `json:"version"`
+	Schema     string            `json:"schema"`
+	SchemaType string            `json:"schemaType"`
+	References []schemaReference `json:"references"`
+}
+
+// schemaReference refers to a schema that a schema imports by Name, e.g.
+// the import path of a Protobuf schema.
+type schemaReference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+func (r *schemaRegistry) get(path string, result interface{}) error {
+	return r.do("GET", path, nil, result)
+}
+
+func (r *schemaRegistry) post(path string, body interface{}, result interface{}) error {
+	return r.do("POST", path, body, result)
+}
+
+func (r *schemaRegistry) do(method, path string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, r.url+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("schema registry request %v failed with status %v: %v", path, resp.StatusCode, e.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (r *schemaRegistry) parse(rs registrySchema) (*avroSchema, error) {
+	if rs.SchemaType != "" && rs.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("unsupported schema type %v for schema id %v", rs.SchemaType, rs.ID)
+	}
+	return parseAvroSchema(rs.Schema)
+}
+
+// fetch returns the schema of the given ID as registered, the caller must
+// hold the lock.
+func (r *schemaRegistry) fetch(id int32) (registrySchema, error) {
+	if rs, ok := r.fetched[id]; ok {
+		return rs, nil
+	}
+
+	var rs registrySchema
+	if err := r.get(fmt.Sprintf("/schemas/ids/%d", id), &rs); err != nil {
+		return rs, err
+	}
+	rs.ID = id
+	r.fetched[id] = rs
+	return rs, nil
+}
+
+// schemaType returns the type of the schema of the given ID: AVRO, JSON or
+// PROTOBUF.
+func (r *schemaRegistry) schemaType(id int32) (string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.schemas[id]; ok {
+		return "AVRO", nil
+	}
+
+	rs, err := r.fetch(id)
+	if err != nil {
+		return "", err
+	}
+	if rs.SchemaType == "" {
+		return "AVRO", nil
+	}
+	return rs.SchemaType, nil
+}
+
+func (r *schemaRegistry) schemaByID(id int32) (*avroSchema, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if s, ok := r.schemas[id]; ok {
+		return s, nil
+	}
+
+	rs, err := r.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := r.parse(rs)
+	if err != nil {
+		return nil, err
+	}
+	r.schemas[id] = s
+	return s, nil
+}
+
+// protoByID returns the Protobuf schema of the given ID. The schemas it
+// references are fetched by subject and version, including their own
+// references.
+func (r *schemaRegistry) protoByID(id int32) (protoreflect.FileDescriptor, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if fd, ok := r.protos[id]; ok {
+		return fd, nil
+	}
+
+	rs, err := r.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+	if rs.SchemaType != "PROTOBUF" {
+		return nil, fmt.Errorf("schema id %v is not a Protobuf schema", id)
+	}
+
+	refs := map[string]string{}
+	var resolve func(rs registrySchema) error
+	resolve = func(rs registrySchema) error {
+		for _, ref := range rs.References {
+			if _, ok := refs[ref.Name]; ok {
+				continue
+			}
+			var dep registrySchema
+			if err := r.get(fmt.Sprintf("/subjects/%s/versions/%d", url.PathEscape(ref.Subject), ref.Version), &dep); err != nil {
+				return fmt.Errorf("failed to read reference %v err=%v", ref.Name, err)
+			}
+			refs[ref.Name] = dep.Schema
+			if err := resolve(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := resolve(rs); err != nil {
+		return nil, err
+	}
+
+	fd, err := parseProtoSchema(fmt.Sprintf("schema-%d.proto", id), rs.Schema, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Protobuf schema id %v err=%v", id, err)
+	}
+	r.protos[id] = fd
+	return fd, nil
+}
+
+// schemaByVersion looks up the given version of subject, version may also
+// be "latest".
+func (r *schemaRegistry) schemaByVersion(subject string, version string) (int32, *avroSchema, error) {
+	key := subject + "/" + version
+	r.Lock()
+	if id, ok := r.versions[key]; ok {
+		s := r.schemas[id]
+		r.Unlock()
+		return id, s, nil
+	}
+	r.Unlock()
+
+	var rs registrySchema
+	if err := r.get(fmt.Sprintf("/subjects/%s/versions/%s", url.PathEscape(subject), url.PathEscape(version)), &rs); err != nil {
+		return 0, nil, err
+	}
+
+	s, err := r.parse(rs)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	r.Lock()
+	r.schemas[rs.ID] = s
+	if version != "latest" {
+		r.versions[key] = rs.ID
+	}
+	r.Unlock()
+	return rs.ID, s, nil
+}
+
+// schemaID returns the ID of schema under subject. If register is set, the
+// schema is registered under subject if it isn't yet.
+func (r *schemaRegistry) schemaID(subject string, schema string, register bool) (int32, error) {
+	var (
+		rs   registrySchema
+		path = fmt.Sprintf("/subjects/%s", url.PathEscape(subject))
+		key  = subject + "\x00" + schema
+	)
+
+	r.Lock()
+	id, ok := r.ids[key]
+	r.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	if register {
+		path += "/versions"
+	}
+
+	if err := r.post(path, map[string]string{"schema": schema}, &rs); err != nil {
+		return 0, err
+	}
+
+	r.Lock()
+	r.ids[key] = rs.ID
+	r.Unlock()
+	return rs.ID, nil
+}
+
+// splitWireFormat splits data in the schema registry wire format into the
+// schema ID and the encoded payload.
+func splitWireFormat(data []byte) (int32, []byte, error) {
+	if len(data) < 5 || data[0] != 0 {
+		return 0, nil, fmt.Errorf("data is not in schema registry wire format")
+	}
+	return int32(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+}
+
+// registryDecoder decodes keys or values in the schema registry wire format.
+// The data's schema ID determines whether it's Avro, JSON Schema or
+// Protobuf. If an Avro schema is pinned, it's used instead of the ID
+// embedded in the data. If a reader schema is set, Avro data is resolved
+// against it.
+type registryDecoder struct {
+	registry *schemaRegistry
+	pinned   *avroSchema
+	reader   *avroSchema
+	naming   avroNaming
+}
+
+func (d *registryDecoder) decode(data []byte) (interface{}, error) {
+	id, payload, err := splitWireFormat(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := d.pinned
+	if s == nil {
+		typ, err := d.registry.schemaType(id)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "JSON":
+			if !json.Valid(payload) {
+				return nil, fmt.Errorf("invalid JSON for schema id %v", id)
+			}
+			return json.RawMessage(payload), nil
+		case "PROTOBUF":
+			return d.decodeProto(id, payload)
+		}
+
+		if s, err = d.registry.schemaByID(id); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.reader != nil {
+		v, err := s.decodeAs(d.reader, payload)
+		return d.naming.rename(d.reader, v), err
+	}
+	v, err := s.decode(payload)
+	return d.naming.rename(s, v), err
+}
+
+func (d *registryDecoder) decodeProto(id int32, payload []byte) (interface{}, error) {
+	fd, err := d.registry.protoByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, payload, err := splitMessageIndexes(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := protoMessageByIndexes(fd, indexes)
+	if err != nil {
+		return nil, err
+	}
+	return decodeProto(md, payload)
+}
+
+// avroEncoder encodes JSON values with a schema from the registry into the
+// schema registry wire format.
+type avroEncoder struct {
+	registry *schemaRegistry
+	id       int32  // schema to encode with, unless a record asks otherwise
+	subject  string // subject whose latest schema is used if id isn't set
+	subjects map[string]int32
+}
+
+func newAvroEncoder(registry *schemaRegistry, subject string) *avroEncoder {
+	return &avroEncoder{registry: registry, subject: subject, subjects: map[string]int32{}}
+}
+
+func joinWireFormat(id int32, payload []byte) []byte {
+	data := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(data[1:5], uint32(id))
+	return append(data, payload...)
+}
+
+// latestID returns the ID of the latest schema of subject.
+func (e *avroEncoder) latestID(subject string) (int32, error) {
+	if id, ok := e.subjects[subject]; ok {
+		return id, nil
+	}
+
+	id, _, err := e.registry.schemaByVersion(subject, "latest")
+	if err != nil {
+		return 0, err
+	}
+	e.subjects[subject] = id
+	return id, nil
+}
+
+// encode encodes value with the schema of the given ID, or with the latest
+// schema of subject, falling back to the encoder's defaults if neither is
+// set.
+func (e *avroEncoder) encode(value []byte, subject string, id int32) ([]byte, error) {
+	if id == 0 && subject == "" {
+		id, subject = e.id, e.subject
+	}
+
+	if id == 0 {
+		var err error
+		if id, err = e.latestID(subject); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := e.registry.schemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := s.encodeJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	return joinWireFormat(id, payload), nil
+}
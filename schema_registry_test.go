@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaRegistry(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			fmt.Fprint(w, `{"schema": "\"string\""}`)
+		case "/schemas/ids/2":
+			fmt.Fprint(w, `{"schema": "\"long\""}`)
+		case "/subjects/orders-value/versions", "/subjects/orders-value":
+			if r.Method != "POST" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if r.URL.Path == "/subjects/orders-value" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+				return
+			}
+			fmt.Fprint(w, `{"id": 2}`)
+		case "/subjects/orders-value/versions/latest":
+			fmt.Fprint(w, `{"subject": "orders-value", "version": 3, "id": 2, "schema": "\"long\""}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	registry, err := newSchemaRegistry(registryArgs{url: srv.URL + "/"})
+	require.Nil(t, err)
+	d := &registryDecoder{registry: registry}
+
+	actual, err := d.decode(concat([]byte{0, 0, 0, 0, 1}, avroStr("hi")))
+	require.Nil(t, err)
+	require.Equal(t, "hi", actual)
+
+	// cached
+	_, err = d.decode(concat([]byte{0, 0, 0, 0, 1}, avroStr("ho")))
+	require.Nil(t, err)
+	require.Equal(t, 1, requests)
+
+	_, err = d.decode(concat([]byte{0, 0, 0, 0, 9}, avroStr("hi")))
+	require.NotNil(t, err)
+
+	_, err = d.decode([]byte("plain"))
+	require.NotNil(t, err)
+
+	id, pinned, err := registry.schemaByVersion("orders-value", "latest")
+	require.Nil(t, err)
+	require.Equal(t, int32(2), id)
+
+	_, err = registry.schemaID("orders-value", `"long"`, false)
+	require.NotNil(t, err)
+
+	id, err = registry.schemaID("orders-value", `"long"`, true)
+	require.Nil(t, err)
+	require.Equal(t, int32(2), id)
+
+	// the pinned schema wins over the embedded id 1.
+	d.pinned = pinned
+	actual, err = d.decode(concat([]byte{0, 0, 0, 0, 1}, avroLong(42)))
+	require.Nil(t, err)
+	require.Equal(t, int64(42), actual)
+}
+
+func TestSchemaRegistryAuthAndCache(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if user, password, ok := r.BasicAuth(); !ok || user != "hans" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error_code": 401, "message": "Unauthorized"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/subjects/orders-value/versions/3", "/subjects/orders-value/versions/latest":
+			fmt.Fprint(w, `{"id": 2, "schema": "\"long\""}`)
+		case "/subjects/orders-value":
+			fmt.Fprint(w, `{"id": 2}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	registry, err := newSchemaRegistry(registryArgs{url: srv.URL})
+	require.Nil(t, err)
+	_, _, err = registry.schemaByVersion("orders-value", "3")
+	require.NotNil(t, err)
+
+	// credentials in the URL
+	registry, err = newSchemaRegistry(registryArgs{url: strings.Replace(srv.URL, "http://", "http://hans:secret@", 1)})
+	require.Nil(t, err)
+	require.NotContains(t, registry.url, "secret")
+	_, _, err = registry.schemaByVersion("orders-value", "3")
+	require.Nil(t, err)
+
+	registry, err = newSchemaRegistry(registryArgs{url: srv.URL, user: "hans", password: "secret"})
+	require.Nil(t, err)
+
+	requests = 0
+	for i := 0; i < 2; i++ {
+		id, _, err := registry.schemaByVersion("orders-value", "3")
+		require.Nil(t, err)
+		require.Equal(t, int32(2), id)
+
+		id, err = registry.schemaID("orders-value", `"long"`, false)
+		require.Nil(t, err)
+		require.Equal(t, int32(2), id)
+	}
+	require.Equal(t, 2, requests)
+
+	// latest may change, so it's not cached.
+	for i := 0; i < 2; i++ {
+		_, _, err = registry.schemaByVersion("orders-value", "latest")
+		require.Nil(t, err)
+	}
+	require.Equal(t, 4, requests)
+
+	_, err = newSchemaRegistry(registryArgs{url: srv.URL, password: "secret"})
+	require.NotNil(t, err)
+
+	_, err = newSchemaRegistry(registryArgs{url: srv.URL, tlsCert: "cert.pem"})
+	require.NotNil(t, err)
+}
+
+func TestAvroEncoder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			fmt.Fprint(w, `{"schema": "\"string\""}`)
+		case "/schemas/ids/2":
+			fmt.Fprint(w, `{"schema": "\"long\""}`)
+		case "/subjects/orders-value/versions/latest":
+			fmt.Fprint(w, `{"id": 1, "schema": "\"string\""}`)
+		case "/subjects/counts/versions/latest":
+			fmt.Fprint(w, `{"id": 2, "schema": "\"long\""}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	registry, err := newSchemaRegistry(registryArgs{url: srv.URL})
+	require.Nil(t, err)
+	e := newAvroEncoder(registry, "orders-value")
+
+	actual, err := e.encode([]byte(`"hi"`), "", 0)
+	require.Nil(t, err)
+	require.Equal(t, concat([]byte{0, 0, 0, 0, 1}, avroStr("hi")), actual)
+
+	actual, err = e.encode([]byte(`42`), "counts", 0)
+	require.Nil(t, err)
+	require.Equal(t, concat([]byte{0, 0, 0, 0, 2}, avroLong(42)), actual)
+
+	actual, err = e.encode([]byte(`42`), "", 2)
+	require.Nil(t, err)
+	require.Equal(t, concat([]byte{0, 0, 0, 0, 2}, avroLong(42)), actual)
+
+	_, err = e.encode([]byte(`42`), "", 0)
+	require.NotNil(t, err)
+}
+
+func TestRegistryDecoderJSONAndProtobuf(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/5":
+			fmt.Fprint(w, `{"schemaType": "PROTOBUF", "schema": "syntax = \"proto3\"; package shop; import \"money.proto\"; message Order { string id = 1; Money total = 2; message Line { string sku = 1; } }", "references": [{"name": "money.proto", "subject": "money", "version": 1}]}`)
+		case "/subjects/money/versions/1":
+			fmt.Fprint(w, `{"schemaType": "PROTOBUF", "schema": "syntax = \"proto3\"; package shop; message Money { int64 cents = 1; }"}`)
+		case "/schemas/ids/6":
+			fmt.Fprint(w, `{"schemaType": "JSON", "schema": "{\"type\": \"object\"}"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	registry, err := newSchemaRegistry(registryArgs{url: srv.URL})
+	require.Nil(t, err)
+	d := &registryDecoder{registry: registry}
+
+	actual, err := d.decode(concat([]byte{0, 0, 0, 0, 6}, []byte(`{"a": 1}`)))
+	require.Nil(t, err)
+	require.JSONEq(t, `{"a": 1}`, string(actual.(json.RawMessage)))
+
+	_, err = d.decode(concat([]byte{0, 0, 0, 0, 6}, []byte(`{"a"`)))
+	require.NotNil(t, err)
+
+	// message indexes [0] written as the short form 0.
+	actual, err = d.decode(concat([]byte{0, 0, 0, 0, 5, 0}, []byte{0x0a, 3, 'o', '-', '1', 0x12, 2, 0x08, 42}))
+	require.Nil(t, err)
+	require.JSONEq(t, `{"id": "o-1", "total": {"cents": "42"}}`, string(actual.(json.RawMessage)))
+
+	// message indexes [0, 0] for the nested Order.Line.
+	actual, err = d.decode(concat([]byte{0, 0, 0, 0, 5, 4, 0, 0}, []byte{0x0a, 2, 'a', 'b'}))
+	require.Nil(t, err)
+	require.JSONEq(t, `{"sku": "ab"}`, string(actual.(json.RawMessage)))
+
+	_, err = d.decode(concat([]byte{0, 0, 0, 0, 5, 2, 8}, []byte{}))
+	require.NotNil(t, err)
+}
+
+func TestAvroKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/3", "/subjects/orders-key/versions/latest", "/subjects/orders-key/versions/1":
+			fmt.Fprint(w, `{"id": 3, "schema": "{\"type\": \"record\", \"name\": \"Key\", \"fields\": [{\"name\": \"id\", \"type\": \"long\"}]}"}`)
+		case "/schemas/ids/4":
+			fmt.Fprint(w, `{"schema": "{\"type\": \"record\", \"name\": \"Key\", \"fields\": [{\"name\": \"id\", \"type\": \"long\"}, {\"name\": \"tenant\", \"type\": \"string\"}]}"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code": 40403, "message": "Schema not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	producer := &produceCmd{topic: "orders", decodeKey: "avro", decodeValue: "string", partitioner: "hash", registry: registryArgs{url: srv.URL}}
+	producer.setupAvro()
+
+	var msg message
+	require.Nil(t, producer.unmarshalMessage(`{"key": {"id": 23}, "value": "paid"}`, &msg))
+	require.Equal(t, `{"id": 23}`, *msg.Key)
+	require.Equal(t, "paid", *msg.Value)
+
+	key, _, err := producer.decodeKeyValue(msg)
+	require.Nil(t, err)
+	require.Equal(t, concat([]byte{0, 0, 0, 0, 3}, avroLong(23)), key)
+
+	producer.choosePartition(&msg, 7)
+	require.Equal(t, keyPartitioners["murmur2"](key, 7), *msg.Partition)
+
+	// a newer writer schema read with the pinned key schema.
+	consumer := &consumeCmd{topic: "orders", encodeKey: "avro", encodeValue: "string", registry: registryArgs{url: srv.URL}, keySchema: avroSchemaPin{version: "1"}}
+	consumer.setupAvro()
+	require.Nil(t, consumer.valueDecoder)
+	actual, err := consumer.keyDecoder.decode(concat([]byte{0, 0, 0, 0, 4}, avroLong(23)))
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{"id": int64(23)}, actual)
+
+	consumer = &consumeCmd{topic: "orders", encodeKey: "avro", encodeValue: "string", registry: registryArgs{url: srv.URL}}
+	consumer.setupAvro()
+	actual, err = consumer.keyDecoder.decode(concat([]byte{0, 0, 0, 0, 4}, avroLong(23), avroStr("acme")))
+	require.Nil(t, err)
+	require.Equal(t, map[string]interface{}{"id": int64(23), "tenant": "acme"}, actual)
+}
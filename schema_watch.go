@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// subjectVersions returns the IDs of the versions of subject, asking the
+// registry only for the versions missing in known.
+func (r *schemaRegistry) subjectVersions(subject string, known map[int]int32) (map[int]int32, error) {
+	var versions []int
+	path := fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject))
+	if err := r.get(path, &versions); err != nil {
+		return nil, err
+	}
+
+	result := map[int]int32{}
+	for _, v := range versions {
+		if id, ok := known[v]; ok {
+			result[v] = id
+			continue
+		}
+		var rs registrySchema
+		if err := r.get(fmt.Sprintf("%s/%d", path, v), &rs); err != nil {
+			return nil, err
+		}
+		result[v] = rs.ID
+	}
+	return result, nil
+}
+
+// schemaAnnotation is printed in the output of kt consume -watch-schemas
+// when a version of a subject is registered, or when records of the topic
+// start using a schema ID they didn't use since kt started. Version is 0 if
+// the ID isn't a version of the subject, e.g. with another subject naming
+// strategy.
+type schemaAnnotation struct {
+	Annotation string `json:"annotation"` // registered or used
+	Subject    string `json:"subject"`
+	Version    int    `json:"version,omitempty"`
+	ID         int32  `json:"id"`
+	Partition  *int32 `json:"partition,omitempty"`
+	Offset     *int64 `json:"offset,omitempty"`
+}
+
+func (a schemaAnnotation) String() string {
+	version := "an unversioned schema"
+	if a.Version > 0 {
+		version = fmt.Sprintf("version %v", a.Version)
+	}
+	if a.Annotation == "registered" {
+		return fmt.Sprintf("%v: %v registered with id %v", a.Subject, version, a.ID)
+	}
+	return fmt.Sprintf("%v: records use %v with id %v from %v/%v on", a.Subject, version, a.ID, *a.Partition, *a.Offset)
+}
+
+// schemaWatch polls the schema registry every -watch-schemas for new
+// versions of the key and value subjects of the consumed topics, and notices
+// when records start using a schema, e.g. during a rollout. The first ID per
+// subject is what producers used at start and isn't annotated. Like
+// consumeStats, a nil *schemaWatch is valid and doesn't watch anything.
+type schemaWatch struct {
+	sync.Mutex
+	versions func(subject string, known map[int]int32) (map[int]int32, error)
+	interval time.Duration
+	key      bool
+	value    bool
+	subjects []string
+	known    map[string]map[int]int32 // by subject, version to ID
+	used     map[string]map[int32]bool
+	pending  []schemaAnnotation // registered versions, printed before the next record
+}
+
+func newSchemaWatch(versions func(string, map[int]int32) (map[int]int32, error), interval time.Duration, topics []string, key, value bool) *schemaWatch {
+	if interval <= 0 {
+		return nil
+	}
+	w := &schemaWatch{
+		versions: versions,
+		interval: interval,
+		key:      key,
+		value:    value,
+		known:    map[string]map[int]int32{},
+		used:     map[string]map[int32]bool{},
+	}
+	for _, t := range topics {
+		if key {
+			w.subjects = append(w.subjects, t+"-key")
+		}
+		if value {
+			w.subjects = append(w.subjects, t+"-value")
+		}
+	}
+	return w
+}
+
+// run records the current versions, then polls for new ones.
+func (w *schemaWatch) run() {
+	if w == nil {
+		return
+	}
+	w.poll(false)
+	// follow mode ends with kt, so there's no need to stop polling.
+	for range time.Tick(w.interval) {
+		w.poll(true)
+	}
+}
+
+// poll reads the versions of the subjects and queues annotations for new
+// ones if annotate is set. Subjects may not exist yet, failures are only
+// logged.
+func (w *schemaWatch) poll(annotate bool) {
+	for _, s := range w.subjects {
+		w.pollSubject(s, annotate)
+	}
+}
+
+func (w *schemaWatch) pollSubject(subject string, annotate bool) {
+	w.Lock()
+	known := w.known[subject]
+	w.Unlock()
+
+	versions, err := w.versions(subject, known)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read versions of subject %v err=%v\n", subject, err)
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	added := []int{}
+	for v := range versions {
+		if _, ok := w.known[subject][v]; !ok {
+			added = append(added, v)
+		}
+	}
+	sort.Ints(added)
+	w.known[subject] = versions
+	if !annotate {
+		return
+	}
+	for _, v := range added {
+		w.pending = append(w.pending, schemaAnnotation{Annotation: "registered", Subject: subject, Version: v, ID: versions[v]})
+	}
+}
+
+// version returns the version of subject with id, or 0, the caller must
+// hold the lock.
+func (w *schemaWatch) version(subject string, id int32) int {
+	for v, i := range w.known[subject] {
+		if i == id {
+			return v
+		}
+	}
+	return 0
+}
+
+// observe returns the annotations to print before msg.
+func (w *schemaWatch) observe(msg *sarama.ConsumerMessage) []schemaAnnotation {
+	if w == nil {
+		return nil
+	}
+	result := []schemaAnnotation{}
+	for _, f := range []struct {
+		watched bool
+		suffix  string
+		data    []byte
+	}{{w.key, "-key", msg.Key}, {w.value, "-value", msg.Value}} {
+		if !f.watched {
+			continue
+		}
+		if a, ok := w.observeID(msg, msg.Topic+f.suffix, f.data); ok {
+			result = append(result, a)
+		}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	result = append(w.pending, result...)
+	w.pending = nil
+	return result
+}
+
+func (w *schemaWatch) observeID(msg *sarama.ConsumerMessage, subject string, data []byte) (schemaAnnotation, bool) {
+	id, _, err := splitWireFormat(data)
+	if err != nil {
+		return schemaAnnotation{}, false
+	}
+
+	w.Lock()
+	used, ok := w.used[subject]
+	if !ok {
+		used = map[int32]bool{}
+		w.used[subject] = used
+	}
+	first, seen := len(used) == 0, used[id]
+	used[id] = true
+	version := w.version(subject, id)
+	w.Unlock()
+
+	if first || seen {
+		return schemaAnnotation{}, false
+	}
+	if version == 0 {
+		// the version may have been registered since the last poll.
+		w.pollSubject(subject, false)
+		w.Lock()
+		version = w.version(subject, id)
+		w.Unlock()
+	}
+	partition, offset := msg.Partition, msg.Offset
+	return schemaAnnotation{Annotation: "used", Subject: subject, Version: version, ID: id, Partition: &partition, Offset: &offset}, true
+}
+
+// setupSchemaWatch starts -watch-schemas for the subjects of the topics whose
+// keys or values are decoded as avro.
+func (cmd *consumeCmd) setupSchemaWatch() {
+	if cmd.watchSchemas <= 0 {
+		return
+	}
+	registry, err := newSchemaRegistry(cmd.registry)
+	if err != nil {
+		failf("failed to setup schema registry client err=%v", err)
+	}
+	cmd.schemaWatch = newSchemaWatch(registry.subjectVersions, cmd.watchSchemas, cmd.topics, cmd.encodeKey == "avro", cmd.encodeValue == "avro")
+	go cmd.schemaWatch.run()
+}
+
+// printSchemaChanges prints the -watch-schemas annotations before msg, in the
+// output for -output json and tail, otherwise to stderr so they don't mix
+// with the data.
+func (cmd *consumeCmd) printSchemaChanges(out chan printContext, msg *sarama.ConsumerMessage) {
+	for _, a := range cmd.schemaWatch.observe(msg) {
+		var output interface{} = a
+		switch {
+		case cmd.sink != nil || cmd.summary != nil || (cmd.output != "json" && cmd.output != "tail"):
+			fmt.Fprintln(os.Stderr, a)
+			continue
+		case cmd.output == "tail" && cmd.color:
+			output = rawOutput(ansiYellow + "--- " + a.String() + ansiReset + "\n")
+		case cmd.output == "tail":
+			output = rawOutput("--- " + a.String() + "\n")
+		}
+		ctx := printContext{output: output, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
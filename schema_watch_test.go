@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaRegistrySubjectVersions(t *testing.T) {
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/subjects/orders-value/versions":
+			fmt.Fprint(w, `[1, 2]`)
+		case "/subjects/orders-value/versions/2":
+			fmt.Fprint(w, `{"subject": "orders-value", "version": 2, "id": 7, "schema": "\"long\""}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code": 40401, "message": "Subject not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	registry, err := newSchemaRegistry(registryArgs{url: srv.URL})
+	require.Nil(t, err)
+
+	versions, err := registry.subjectVersions("orders-value", map[int]int32{1: 5})
+	require.Nil(t, err)
+	require.Equal(t, map[int]int32{1: 5, 2: 7}, versions)
+	require.Equal(t, []string{"/subjects/orders-value/versions", "/subjects/orders-value/versions/2"}, requests)
+
+	_, err = registry.subjectVersions("users-value", nil)
+	require.NotNil(t, err)
+}
+
+func TestSchemaWatch(t *testing.T) {
+	var disabled *schemaWatch
+	require.Nil(t, disabled.observe(&sarama.ConsumerMessage{}))
+	require.Nil(t, newSchemaWatch(nil, 0, []string{"orders"}, false, true))
+
+	versions := map[int]int32{1: 10, 2: 11}
+	w := newSchemaWatch(func(subject string, known map[int]int32) (map[int]int32, error) {
+		require.Equal(t, "orders-value", subject)
+		result := map[int]int32{}
+		for v, id := range versions {
+			result[v] = id
+		}
+		return result, nil
+	}, time.Minute, []string{"orders"}, false, true)
+	require.Equal(t, []string{"orders-value"}, w.subjects)
+	w.poll(false)
+
+	value := func(id byte) []byte { return []byte{0, 0, 0, 0, id, 42} }
+	require.Empty(t, w.observe(&sarama.ConsumerMessage{Topic: "orders", Offset: 1, Value: value(10)}))
+	require.Empty(t, w.observe(&sarama.ConsumerMessage{Topic: "orders", Offset: 2, Value: []byte("plain")}))
+	require.Empty(t, w.observe(&sarama.ConsumerMessage{Topic: "orders", Offset: 3, Value: value(10)}))
+
+	versions[3] = 12
+	w.poll(true)
+	require.Equal(t, []schemaAnnotation{{Annotation: "registered", Subject: "orders-value", Version: 3, ID: 12}}, w.observe(&sarama.ConsumerMessage{Topic: "orders", Offset: 4, Value: value(10)}))
+
+	partition, offset := int32(2), int64(5)
+	used := w.observe(&sarama.ConsumerMessage{Topic: "orders", Partition: 2, Offset: 5, Value: value(12)})
+	require.Equal(t, []schemaAnnotation{{Annotation: "used", Subject: "orders-value", Version: 3, ID: 12, Partition: &partition, Offset: &offset}}, used)
+	require.Equal(t, "orders-value: records use version 3 with id 12 from 2/5 on", used[0].String())
+	require.Empty(t, w.observe(&sarama.ConsumerMessage{Topic: "orders", Offset: 6, Value: value(12)}))
+
+	// registered between polls.
+	versions[4] = 13
+	used = w.observe(&sarama.ConsumerMessage{Topic: "orders", Offset: 7, Value: value(13)})
+	require.Len(t, used, 1)
+	require.Equal(t, 4, used[0].Version)
+	require.Empty(t, w.observe(&sarama.ConsumerMessage{Topic: "orders", Offset: 8, Value: value(13)}))
+}
+
+func TestConsumePrintSchemaChanges(t *testing.T) {
+	out := make(chan printContext)
+	outputs := make(chan interface{}, 10)
+	go func() {
+		for ctx := range out {
+			outputs <- ctx.output
+			close(ctx.done)
+		}
+	}()
+	defer close(out)
+
+	w := newSchemaWatch(nil, time.Minute, []string{"orders"}, false, true)
+	w.pending = []schemaAnnotation{{Annotation: "registered", Subject: "orders-value", Version: 3, ID: 12}}
+	target := &consumeCmd{output: "tail", schemaWatch: w}
+	target.printSchemaChanges(out, &sarama.ConsumerMessage{Topic: "orders"})
+	require.Equal(t, rawOutput("--- orders-value: version 3 registered with id 12\n"), <-outputs)
+}
+
+func TestConsumeParseArgsWatchSchemas(t *testing.T) {
+	target := &consumeCmd{}
+	target.parseArgs([]string{"-topic", "hans", "-encodevalue", "avro", "-schema-registry", "http://localhost:8081", "-watch-schemas", "1m"})
+	require.Equal(t, time.Minute, target.watchSchemas)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type selfUpdateCmd struct {
+	repo       string
+	version    string
+	check      bool
+	verbose    bool
+	pretty     bool
+	executable string
+	goos       string
+	goarch     string
+
+	apiURL string
+	client *http.Client
+}
+
+type selfUpdateArgs struct {
+	repo    string
+	version string
+	check   bool
+	verbose bool
+	pretty  bool
+}
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func (r githubRelease) asset(name string) *githubAsset {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return &a
+		}
+	}
+	return nil
+}
+
+// selfUpdateResult reports the release that was installed, or with -check
+// the release that would be installed.
+type selfUpdateResult struct {
+	Version string `json:"version"`
+	Asset   string `json:"asset"`
+	SHA256  string `json:"sha256"`
+	Path    string `json:"path"`
+	Updated bool   `json:"updated"`
+}
+
+func (cmd *selfUpdateCmd) run(args []string) {
+	cmd.parseArgs(args)
+
+	result, err := cmd.update()
+	if err != nil {
+		failf("failed to update kt err=%v", err)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: result, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+// update downloads the release's archive for the platform, verifies it
+// against the release's checksums and replaces the executable with the kt
+// binary in it. Releases without checksums aren't installed.
+func (cmd *selfUpdateCmd) update() (selfUpdateResult, error) {
+	release, err := cmd.release()
+	if err != nil {
+		return selfUpdateResult{}, err
+	}
+
+	name := releaseArchive(release.TagName, cmd.goos, cmd.goarch)
+	archive := release.asset(name)
+	if archive == nil {
+		return selfUpdateResult{}, fmt.Errorf("release %v has no binary for %v/%v, expected asset %v", release.TagName, cmd.goos, cmd.goarch, name)
+	}
+	sums := release.asset(releaseChecksums(release.TagName))
+	if sums == nil {
+		return selfUpdateResult{}, fmt.Errorf("release %v has no checksums file %v, refusing to install an unverified binary", release.TagName, releaseChecksums(release.TagName))
+	}
+
+	sumsData, err := cmd.download(sums.URL)
+	if err != nil {
+		return selfUpdateResult{}, err
+	}
+	expected, err := findChecksum(sumsData, name)
+	if err != nil {
+		return selfUpdateResult{}, err
+	}
+
+	data, err := cmd.download(archive.URL)
+	if err != nil {
+		return selfUpdateResult{}, err
+	}
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return selfUpdateResult{}, fmt.Errorf("checksum mismatch for %v, expected %v but got %v", name, expected, actual)
+	}
+
+	result := selfUpdateResult{Version: release.TagName, Asset: name, SHA256: expected, Path: cmd.executable}
+	if cmd.check {
+		return result, nil
+	}
+
+	bin, err := extractBinary(data)
+	if err != nil {
+		return result, fmt.Errorf("failed to extract kt from %v err=%v", name, err)
+	}
+	if err = replaceExecutable(cmd.executable, bin); err != nil {
+		return result, err
+	}
+	result.Updated = true
+	return result, nil
+}
+
+// release looks up -version, or the latest release, of -repo.
+func (cmd *selfUpdateCmd) release() (githubRelease, error) {
+	var release githubRelease
+
+	u := fmt.Sprintf("%v/repos/%v/releases/latest", cmd.apiURL, cmd.repo)
+	if cmd.version != "" {
+		u = fmt.Sprintf("%v/repos/%v/releases/tags/%v", cmd.apiURL, cmd.repo, cmd.version)
+	}
+	data, err := cmd.download(u)
+	if err != nil {
+		return release, err
+	}
+	if err = json.Unmarshal(data, &release); err != nil {
+		return release, fmt.Errorf("failed to read release of %v err=%v", cmd.repo, err)
+	}
+	return release, nil
+}
+
+func (cmd *selfUpdateCmd) download(u string) ([]byte, error) {
+	if cmd.verbose {
+		fmt.Fprintf(os.Stderr, "downloading %v\n", u)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(u, cmd.apiURL) {
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	resp, err := cmd.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %v err=%v", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %v status=%v", u, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// releaseArchive is the name of the release asset of the given platform as
+// created by make release.
+func releaseArchive(tag, goos, goarch string) string {
+	return fmt.Sprintf("kt-%v-%v-%v.tar.gz", tag, goos, goarch)
+}
+
+// releaseChecksums is the name of the release's SHA-256 checksums file in
+// the format of shasum -a 256.
+func releaseChecksums(tag string) string {
+	return fmt.Sprintf("kt-%v-checksums.txt", tag)
+}
+
+func findChecksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("found no checksum for %v", name)
+}
+
+// extractBinary returns the kt executable in the gzipped tar archive.
+func extractBinary(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	r := tar.NewReader(gz)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("archive contains no kt executable")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag == tar.TypeReg && path.Base(h.Name) == "kt" {
+			return ioutil.ReadAll(r)
+		}
+	}
+}
+
+// replaceExecutable writes bin next to the executable at p and renames it
+// over p, so that p is either the old or the new binary even if kt is
+// interrupted. The new binary keeps the old one's permissions.
+func replaceExecutable(p string, bin []byte) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".kt-update-")
+	if err != nil {
+		return fmt.Errorf("failed to create file next to %v err=%v", p, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(bin); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %v err=%v", tmp.Name(), err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %v err=%v", tmp.Name(), err)
+	}
+	if err = os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to replace %v err=%v", p, err)
+	}
+	return nil
+}
+
+func (cmd *selfUpdateCmd) parseArgs(as []string) {
+	var err error
+	args := cmd.parseFlags(as)
+
+	cmd.repo = args.repo
+	cmd.version = args.version
+	cmd.check = args.check
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	cmd.goos = runtime.GOOS
+	cmd.goarch = runtime.GOARCH
+	cmd.apiURL = "https://api.github.com"
+	cmd.client = &http.Client{Timeout: 5 * time.Minute}
+
+	if cmd.executable, err = os.Executable(); err != nil {
+		failf("failed to find the kt executable err=%v", err)
+	}
+	if cmd.executable, err = filepath.EvalSymlinks(cmd.executable); err != nil {
+		failf("failed to resolve the kt executable err=%v", err)
+	}
+}
+
+func (cmd *selfUpdateCmd) parseFlags(as []string) selfUpdateArgs {
+	var args selfUpdateArgs
+	flags := flag.NewFlagSet("self-update", flag.ContinueOnError)
+	flags.StringVar(&args.repo, "repo", "fgeller/kt", "GitHub repository to download releases from.")
+	flags.StringVar(&args.version, "version", "", "Release tag to install (defaults to the latest release).")
+	flags.BoolVar(&args.check, "check", false, "Only verify the release's archive, don't install it.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of self-update:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, selfUpdateDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var selfUpdateDocString = `
+self-update replaces the running kt binary with the latest release, or the
+release tagged -version, for the platform, e.g. on remote boxes without a
+package manager. It downloads the release's kt-<tag>-<os>-<arch>.tar.gz and
+verifies its SHA-256 checksum against the release's kt-<tag>-checksums.txt
+before installing. Releases without checksums aren't installed.
+
+The new binary is written next to the current one and renamed over it, so
+the update is atomic and needs write access to the binary's directory. Set
+GITHUB_TOKEN to avoid the GitHub API's rate limit for anonymous requests.
+
+  $ kt self-update
+  $ kt self-update -version v14.0.0 -check`
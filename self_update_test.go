@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testReleaseArchive(t *testing.T, name string, content []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	require.NoError(t, w.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestFindChecksum(t *testing.T) {
+	sums := []byte("aa11  kt-v1-darwin-amd64.tar.gz\nBB22 *kt-v1-linux-amd64.tar.gz\n")
+
+	sum, err := findChecksum(sums, "kt-v1-linux-amd64.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, "bb22", sum)
+
+	_, err = findChecksum(sums, "kt-v1-linux-arm64.tar.gz")
+	require.Error(t, err)
+}
+
+func TestExtractBinary(t *testing.T) {
+	bin, err := extractBinary(testReleaseArchive(t, "kt", []byte("new kt")))
+	require.NoError(t, err)
+	require.Equal(t, []byte("new kt"), bin)
+
+	_, err = extractBinary(testReleaseArchive(t, "README.md", []byte("docs")))
+	require.Error(t, err)
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-self-update")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "kt")
+	require.NoError(t, ioutil.WriteFile(p, []byte("old kt"), 0750))
+	require.NoError(t, replaceExecutable(p, []byte("new kt")))
+
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte("new kt"), data)
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0750), info.Mode().Perm())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestSelfUpdate(t *testing.T) {
+	archive := testReleaseArchive(t, "kt", []byte("new kt"))
+	sum := sha256.Sum256(archive)
+	checksums := fmt.Sprintf("%v  kt-v2-linux-amd64.tar.gz\n", hex.EncodeToString(sum[:]))
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/fgeller/kt/releases/latest":
+			fmt.Fprintf(w, `{"tag_name": "v2", "assets": [
+  {"name": "kt-v2-linux-amd64.tar.gz", "browser_download_url": "%[1]v/archive"},
+  {"name": "kt-v2-checksums.txt", "browser_download_url": "%[1]v/checksums"}
+]}`, server.URL)
+		case "/archive":
+			w.Write(archive)
+		case "/checksums":
+			w.Write([]byte(checksums))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kt-self-update")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "kt")
+	require.NoError(t, ioutil.WriteFile(p, []byte("old kt"), 0755))
+
+	target := &selfUpdateCmd{repo: "fgeller/kt", goos: "linux", goarch: "amd64", executable: p, apiURL: server.URL, client: server.Client(), check: true}
+	result, err := target.update()
+	require.NoError(t, err)
+	require.Equal(t, selfUpdateResult{Version: "v2", Asset: "kt-v2-linux-amd64.tar.gz", SHA256: hex.EncodeToString(sum[:]), Path: p}, result)
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte("old kt"), data)
+
+	target.check = false
+	result, err = target.update()
+	require.NoError(t, err)
+	require.True(t, result.Updated)
+	data, err = ioutil.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte("new kt"), data)
+
+	checksums = "0000  kt-v2-linux-amd64.tar.gz\n"
+	_, err = target.update()
+	require.Error(t, err)
+
+	target.goarch = "arm64"
+	_, err = target.update()
+	require.Error(t, err)
+}
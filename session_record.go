@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// sessionFormat is the version of the -record file format.
+const sessionFormat = 1
+
+// sessionInfo is the first line of a -record file.
+type sessionInfo struct {
+	Format   int       `json:"ktSession"`
+	Recorded time.Time `json:"recorded"`
+	Brokers  []string  `json:"brokers"`
+	Topics   []string  `json:"topics"`
+}
+
+// sessionRecord is a fetched record in a -record file, with key, value and
+// header values as raw bytes, which encoding/json writes as base64.
+type sessionRecord struct {
+	Topic     string          `json:"topic"`
+	Partition int32           `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Timestamp time.Time       `json:"timestamp"`
+	Key       []byte          `json:"key"`
+	Value     []byte          `json:"value"`
+	Headers   []sessionHeader `json:"headers,omitempty"`
+}
+
+func newSessionRecord(msg *sarama.ConsumerMessage) sessionRecord {
+	r := sessionRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}
+	for _, h := range msg.Headers {
+		r.Headers = append(r.Headers, sessionHeader{Key: string(h.Key), Value: h.Value})
+	}
+	return r
+}
+
+func (r sessionRecord) message() *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{
+		Topic:     r.Topic,
+		Partition: r.Partition,
+		Offset:    r.Offset,
+		Timestamp: r.Timestamp,
+		Key:       r.Key,
+		Value:     r.Value,
+	}
+	for _, h := range r.Headers {
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+	}
+	return msg
+}
+
+// sessionHeader is a record header in a -record file, kept in a list rather
+// than an object as keys can repeat.
+type sessionHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// sessionRecorder writes the records that consume fetches to the -record
+// file as gzip compressed JSON lines, before -filter, sampling or
+// -max-messages drop any of them. Like sessionStats, a nil *sessionRecorder
+// is valid and doesn't record anything.
+type sessionRecorder struct {
+	sync.Mutex
+	path string
+	file *os.File
+	gz   *gzip.Writer
+	w    *bufio.Writer
+	enc  *json.Encoder
+	err  error
+}
+
+func newSessionRecorder(path string, info sessionInfo) (*sessionRecorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session file %v err=%v", path, err)
+	}
+	r := &sessionRecorder{path: path, file: f, gz: gzip.NewWriter(f)}
+	r.w = bufio.NewWriter(r.gz)
+	r.enc = json.NewEncoder(r.w)
+	info.Format = sessionFormat
+	if err = r.enc.Encode(info); err != nil {
+		return nil, fmt.Errorf("failed to write session file %v err=%v", path, err)
+	}
+	return r, nil
+}
+
+// add records msg, a failure is reported once when the file is closed.
+func (r *sessionRecorder) add(msg *sarama.ConsumerMessage) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(newSessionRecord(msg))
+	}
+}
+
+func (r *sessionRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, f := range []func() error{r.w.Flush, r.gz.Close, r.file.Close} {
+		if err := f(); err != nil && r.err == nil {
+			r.err = err
+		}
+	}
+	if r.err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write session file %v err=%v\n", r.path, r.err)
+	}
+}
+
+// sessionReader reads a -record file.
+type sessionReader struct {
+	closers []io.Closer
+	dec     *json.Decoder
+	info    sessionInfo
+}
+
+func openSession(path string) (*sessionReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	r := &sessionReader{closers: []io.Closer{f}}
+	var in io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read session file %v err=%v", path, err)
+		}
+		r.closers = append(r.closers, gz)
+		in = gz
+	}
+	r.dec = json.NewDecoder(in)
+	if err = r.dec.Decode(&r.info); err != nil || r.info.Format == 0 {
+		r.close()
+		return nil, fmt.Errorf("%v isn't a kt session file, expected a file written by kt consume -record", path)
+	}
+	if r.info.Format > sessionFormat {
+		r.close()
+		return nil, fmt.Errorf("session file %v has format %v, this kt reads up to format %v", path, r.info.Format, sessionFormat)
+	}
+	return r, nil
+}
+
+// next returns the next record, or io.EOF after the last one.
+func (r *sessionReader) next() (*sarama.ConsumerMessage, error) {
+	var rec sessionRecord
+	if err := r.dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return rec.message(), nil
+}
+
+func (r *sessionReader) close() {
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		r.closers[i].Close()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRecordRoundTrip(t *testing.T) {
+	var disabled *sessionRecorder
+	disabled.add(&sarama.ConsumerMessage{})
+	disabled.close()
+
+	path := filepath.Join(t.TempDir(), "orders.ktrec")
+	recorded := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	r, err := newSessionRecorder(path, sessionInfo{Recorded: recorded, Brokers: []string{"localhost:9092"}, Topics: []string{"orders"}})
+	require.NoError(t, err)
+
+	messages := []*sarama.ConsumerMessage{
+		{
+			Topic:     "orders",
+			Partition: 1,
+			Offset:    7,
+			Timestamp: recorded,
+			Key:       []byte("id-1"),
+			Value:     []byte{0, 1, 2, 0xff},
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte("trace"), Value: []byte("a")},
+				{Key: []byte("trace"), Value: []byte("b")},
+			},
+		},
+		{Topic: "orders", Partition: 0, Offset: 3, Timestamp: recorded, Key: []byte("id-2")},
+	}
+	for _, m := range messages {
+		r.add(m)
+	}
+	r.close()
+
+	session, err := openSession(path)
+	require.NoError(t, err)
+	defer session.close()
+	require.Equal(t, sessionInfo{Format: sessionFormat, Recorded: recorded, Brokers: []string{"localhost:9092"}, Topics: []string{"orders"}}, session.info)
+
+	for _, expected := range messages {
+		msg, err := session.next()
+		require.NoError(t, err)
+		require.Equal(t, expected, msg)
+	}
+	_, err = session.next()
+	require.Equal(t, io.EOF, err)
+
+	_, err = openSession(filepath.Join("test-dependencies", "does-not-exist.ktrec"))
+	require.Error(t, err)
+}
+
+func TestReplayConsumeArgs(t *testing.T) {
+	require.Equal(t, []string{"-topic", "orders", "-encodevalue", "json"}, replayConsumeArgs([]string{"-encodevalue", "json"}, []string{"orders"}))
+	require.Equal(t, []string{"-topic", `orders|orders\.v2`, "-topic-regex"}, replayConsumeArgs(nil, []string{"orders", "orders.v2"}))
+	require.Equal(t, []string{"-topic=orders"}, replayConsumeArgs([]string{"-topic=orders"}, []string{"orders", "orders.v2"}))
+
+	target := &consumeCmd{}
+	target.parseArgs(replayConsumeArgs([]string{"-encodevalue", "hex"}, []string{"orders", "orders.v2"}))
+	require.NotNil(t, target.topicRegex)
+	require.Equal(t, []string{"orders.v2"}, matchTopics(target.topicRegex, []string{"orders.v2", "ordersXv2"}))
+}
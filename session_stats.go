@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// sessionStats collects sarama's client metrics for -session-stats and
+// writes them as a JSON report when the command exits. Like the metrics
+// registry, a nil *sessionStats is valid and does nothing.
+type sessionStats struct {
+	path     string
+	command  string
+	start    time.Time
+	registry metrics.Registry
+}
+
+type latencyStats struct {
+	Count int64   `json:"count"`
+	Min   int64   `json:"min"`
+	Max   int64   `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P99   float64 `json:"p99"`
+}
+
+type trafficStats struct {
+	BytesIn   int64         `json:"bytesIn"`
+	BytesOut  int64         `json:"bytesOut"`
+	Requests  int64         `json:"requests"`
+	Responses int64         `json:"responses"`
+	LatencyMs *latencyStats `json:"latencyMs,omitempty"`
+}
+
+type sessionReport struct {
+	Command    string    `json:"command"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"durationMs"`
+	trafficStats
+	RequestsByType map[string]int64         `json:"requestsByType"`
+	Brokers        map[string]*trafficStats `json:"brokers"`
+}
+
+func newSessionStats(command, path string) *sessionStats {
+	if path == "" {
+		return nil
+	}
+	return &sessionStats{path: path, command: command, start: time.Now(), registry: metrics.NewRegistry()}
+}
+
+// configure makes clients created with cfg record their metrics for the
+// report.
+func (s *sessionStats) configure(cfg *sarama.Config) {
+	if s == nil {
+		return
+	}
+	cfg.MetricRegistry = s.registry
+}
+
+func newLatencyStats(h metrics.Histogram) *latencyStats {
+	snap := h.Snapshot()
+	if snap.Count() == 0 {
+		return nil
+	}
+	ps := snap.Percentiles([]float64{0.5, 0.99})
+	return &latencyStats{Count: snap.Count(), Min: snap.Min(), Max: snap.Max(), Mean: snap.Mean(), P50: ps[0], P99: ps[1]}
+}
+
+// record adds a sarama metric to the traffic stats, it returns false for
+// metrics that aren't about traffic.
+func (t *trafficStats) record(name string, m interface{}) bool {
+	var count int64
+	switch v := m.(type) {
+	case metrics.Meter:
+		count = v.Count()
+	case metrics.Histogram:
+		if name != "request-latency-in-ms" {
+			return false
+		}
+		t.LatencyMs = newLatencyStats(v)
+		return true
+	default:
+		return false
+	}
+
+	switch name {
+	case "incoming-byte-rate":
+		t.BytesIn = count
+	case "outgoing-byte-rate":
+		t.BytesOut = count
+	case "request-rate":
+		t.Requests = count
+	case "response-rate":
+		t.Responses = count
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *sessionStats) report(end time.Time) sessionReport {
+	r := sessionReport{
+		Command:        s.command,
+		Start:          s.start,
+		End:            end,
+		DurationMs:     int64(end.Sub(s.start) / time.Millisecond),
+		RequestsByType: map[string]int64{},
+		Brokers:        map[string]*trafficStats{},
+	}
+
+	s.registry.Each(func(name string, m interface{}) {
+		if i := strings.LastIndex(name, "-for-broker-"); i >= 0 {
+			if strings.HasPrefix(name, "protocol-requests-rate-") {
+				return
+			}
+			id := name[i+len("-for-broker-"):]
+			b, ok := r.Brokers[id]
+			if !ok {
+				b = &trafficStats{}
+			}
+			if b.record(name[:i], m) {
+				r.Brokers[id] = b
+			}
+			return
+		}
+
+		if strings.HasPrefix(name, "protocol-requests-rate-") {
+			key, err := strconv.ParseInt(strings.TrimPrefix(name, "protocol-requests-rate-"), 10, 16)
+			if meter, ok := m.(metrics.Meter); ok && err == nil && meter.Count() > 0 {
+				r.RequestsByType[apiKeyName(int16(key))] = meter.Count()
+			}
+			return
+		}
+
+		r.record(name, m)
+	})
+
+	return r
+}
+
+// write writes the report to the -session-stats file. Failing to do so is
+// reported but doesn't fail the command, it's done its work at this point.
+func (s *sessionStats) write() {
+	if s == nil {
+		return
+	}
+
+	buf, err := json.MarshalIndent(s.report(time.Now()), "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(s.path, append(buf, '\n'), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write session stats to %v err=%v\n", s.path, err)
+	}
+}
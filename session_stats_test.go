@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionStatsReport(t *testing.T) {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newSessionStats("consume", "stats.json")
+	s.start = start
+
+	cfg := sarama.NewConfig()
+	s.configure(cfg)
+	require.Equal(t, s.registry, cfg.MetricRegistry)
+
+	metrics.GetOrRegisterMeter("incoming-byte-rate", s.registry).Mark(300)
+	metrics.GetOrRegisterMeter("outgoing-byte-rate", s.registry).Mark(40)
+	metrics.GetOrRegisterMeter("request-rate", s.registry).Mark(3)
+	metrics.GetOrRegisterMeter("response-rate", s.registry).Mark(3)
+	metrics.GetOrRegisterMeter("incoming-byte-rate-for-broker-1", s.registry).Mark(200)
+	metrics.GetOrRegisterMeter("request-rate-for-broker-1", s.registry).Mark(2)
+	metrics.GetOrRegisterMeter("protocol-requests-rate-1", s.registry).Mark(2)
+	metrics.GetOrRegisterMeter("protocol-requests-rate-3", s.registry).Mark(1)
+	metrics.GetOrRegisterMeter("protocol-requests-rate-1-for-broker-1", s.registry).Mark(2)
+	metrics.GetOrRegisterMeter("protocol-requests-rate-18", s.registry)
+	metrics.GetOrRegisterMeter("consumer-fetch-rate", s.registry).Mark(2)
+	latency := metrics.GetOrRegisterHistogram("request-latency-in-ms-for-broker-1", s.registry, metrics.NewUniformSample(10))
+	latency.Update(10)
+	latency.Update(30)
+	metrics.GetOrRegisterHistogram("request-latency-in-ms", s.registry, metrics.NewUniformSample(10))
+
+	actual := s.report(start.Add(2 * time.Second))
+	require.Equal(t, sessionReport{
+		Command:        "consume",
+		Start:          start,
+		End:            start.Add(2 * time.Second),
+		DurationMs:     2000,
+		trafficStats:   trafficStats{BytesIn: 300, BytesOut: 40, Requests: 3, Responses: 3},
+		RequestsByType: map[string]int64{"Fetch": 2, "Metadata": 1},
+		Brokers: map[string]*trafficStats{
+			"1": {BytesIn: 200, Requests: 2, LatencyMs: &latencyStats{Count: 2, Min: 10, Max: 30, Mean: 20, P50: 20, P99: 30}},
+		},
+	}, actual)
+}
+
+func TestSessionStatsWrite(t *testing.T) {
+	var disabled *sessionStats
+	require.Nil(t, newSessionStats("produce", ""))
+	disabled.configure(sarama.NewConfig())
+	disabled.write()
+
+	dir, err := ioutil.TempDir("", "kt-session-stats")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stats.json")
+	s := newSessionStats("produce", path)
+	metrics.GetOrRegisterMeter("outgoing-byte-rate", s.registry).Mark(12)
+	s.write()
+
+	buf, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+
+	var actual map[string]interface{}
+	require.Nil(t, json.Unmarshal(buf, &actual))
+	require.Equal(t, "produce", actual["command"])
+	require.Equal(t, float64(12), actual["bytesOut"])
+	require.Equal(t, map[string]interface{}{}, actual["brokers"])
+}
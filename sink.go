@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// sink is where consume writes the output of the messages it consumed,
+// selected with -sink. write is called concurrently for the partitions and
+// returns once the output is written, close flushes the output and reports
+// its own errors on stderr.
+type sink interface {
+	write(output interface{}, msg *sarama.ConsumerMessage) error
+	close()
+	String() string
+}
+
+// sinkSpec is a parsed -sink argument: the kind of sink and where it
+// writes to.
+type sinkSpec struct {
+	kind   string
+	target string
+}
+
+// parseSinkSpec parses -sink arguments like stdout, file:orders.jsonl,
+// kafka:staging:9092/orders, https://example.com/orders,
+// nats://localhost/kafka.${topic}, mqtt://localhost/devices/${key},
+// elasticsearch:http://localhost:9200/kt-${topic},
+// postgres://kt@localhost/db?table=orders, redis://localhost/0 or
+// exec:'jq .value'.
+func parseSinkSpec(s string) (sinkSpec, error) {
+	switch {
+	case s == "" || s == "stdout":
+		return sinkSpec{kind: "stdout"}, nil
+	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+		return sinkSpec{kind: "http", target: s}, nil
+	case strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://"):
+		return sinkSpec{kind: "postgres", target: s}, nil
+	case strings.HasPrefix(s, "redis://") || strings.HasPrefix(s, "rediss://"):
+		return sinkSpec{kind: "redis", target: s}, nil
+	case strings.HasPrefix(s, "nats://"), strings.HasPrefix(s, "mqtt://"), strings.HasPrefix(s, "mqtts://"):
+		sp := sinkSpec{kind: s[:4], target: s}
+		if _, _, _, err := bridgeAddress(s, ""); err != nil {
+			return sinkSpec{}, fmt.Errorf("invalid sink %#v, %v", s, err)
+		}
+		return sp, nil
+	}
+
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return sinkSpec{}, fmt.Errorf("invalid sink %#v, expected stdout, file:<path>, kafka:<brokers>/<topic>, elasticsearch:<url>/<index>, an http(s), postgres, redis, nats or mqtt URL or exec:<command>", s)
+	}
+	sp := sinkSpec{kind: kv[0], target: kv[1]}
+	switch sp.kind {
+	case "file", "exec":
+	case "elasticsearch", "opensearch":
+		sp.kind = "elasticsearch"
+		if !strings.HasPrefix(sp.target, "http://") && !strings.HasPrefix(sp.target, "https://") || strings.Count(sp.target, "/") < 3 {
+			return sinkSpec{}, fmt.Errorf("invalid sink %#v, expected %v:http(s)://<host>/<index>", s, kv[0])
+		}
+	case "kafka":
+		i := strings.LastIndex(sp.target, "/")
+		if i <= 0 || i == len(sp.target)-1 {
+			return sinkSpec{}, fmt.Errorf("invalid sink %#v, expected kafka:<brokers>/<topic>", s)
+		}
+	default:
+		return sinkSpec{}, fmt.Errorf("unsupported sink %#v, only stdout, file, kafka, elasticsearch, opensearch, http(s), postgres, redis, nats, mqtt and exec are supported", sp.kind)
+	}
+	return sp, nil
+}
+
+// sinkTLSConfig is the TLS config for sinks that connect to host via TLS,
+// with the certificate authority and client certificate given by the tlsca,
+// tlscert and tlscertkey parameters of their URL. The server's certificate
+// is always verified, so messages and credentials don't go to anyone else.
+func sinkTLSConfig(params url.Values, host string) (*tls.Config, error) {
+	cfg, err := setupCerts(params.Get("tlscert"), params.Get("tlsca"), params.Get("tlscertkey"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup certificates err=%v", err)
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg.ServerName = host
+	return cfg, nil
+}
+
+// outputLine is the line written for output: raw output as it is, other
+// output as compact JSON followed by a newline.
+func outputLine(output interface{}) ([]byte, error) {
+	if line, ok := output.(rawOutput); ok {
+		return line, nil
+	}
+	buf, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
+
+// stdoutSink hands the output to the print goroutine, it's the default.
+type stdoutSink chan printContext
+
+func (s stdoutSink) write(output interface{}, msg *sarama.ConsumerMessage) error {
+	ctx := printContext{output: output, done: make(chan struct{})}
+	s <- ctx
+	<-ctx.done
+	return nil
+}
+
+func (s stdoutSink) close() {}
+
+func (s stdoutSink) String() string { return "stdout" }
+
+// httpSink POSTs the output of each message to a URL, as JSON unless the
+// output is raw.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSink(url string, timeout time.Duration) *httpSink {
+	return &httpSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *httpSink) write(output interface{}, msg *sarama.ConsumerMessage) error {
+	line, err := outputLine(output)
+	if err != nil {
+		return err
+	}
+	contentType := "application/json"
+	if _, ok := output.(rawOutput); ok {
+		contentType = "application/octet-stream"
+	}
+
+	resp, err := s.client.Post(s.url, contentType, bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) close() {}
+
+func (s *httpSink) String() string { return s.url }
+
+// execSink writes the output as lines to the stdin of a command, which
+// shares kt's stdout and stderr, e.g. to filter records with jq or to load
+// them with a database's client.
+type execSink struct {
+	sync.Mutex
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+}
+
+func newExecSink(command string) (*execSink, error) {
+	s := &execSink{command: command, cmd: exec.Command("sh", "-c", command)}
+	s.cmd.Stdout, s.cmd.Stderr = stdout, os.Stderr
+
+	var err error
+	if s.stdin, err = s.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err = s.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *execSink) write(output interface{}, msg *sarama.ConsumerMessage) error {
+	line, err := outputLine(output)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	_, err = s.stdin.Write(line)
+	return err
+}
+
+// close ends the command's input and waits for it to exit.
+func (s *execSink) close() {
+	s.stdin.Close()
+	if err := s.cmd.Wait(); err != nil {
+		fmt.Fprintf(os.Stderr, "command %#v failed err=%v\n", s.command, err)
+	}
+}
+
+func (s *execSink) String() string { return s.command }
+
+// kafkaSink produces the consumed messages to a topic, usually of another
+// cluster, with their original key, value, headers and timestamp. The output
+// format doesn't apply, messages are mirrored as they were consumed and
+// partitioned by their key like the Java client does.
+type kafkaSink struct {
+	brokers  []string
+	topic    string
+	client   sarama.Client
+	producer sarama.SyncProducer
+	next     int32 // round robin partition for messages without key
+}
+
+func newKafkaSink(target string, cfg *sarama.Config) (*kafkaSink, error) {
+	i := strings.LastIndex(target, "/")
+	s := &kafkaSink{brokers: parseBrokers(target[:i]), topic: target[i+1:]}
+
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+
+	var err error
+	if s.client, err = sarama.NewClient(s.brokers, cfg); err != nil {
+		return nil, err
+	}
+	if s.producer, err = sarama.NewSyncProducerFromClient(s.client); err != nil {
+		logClose("sink client", s.client)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *kafkaSink) write(output interface{}, msg *sarama.ConsumerMessage) error {
+	partitions, err := s.client.Partitions(s.topic)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("found no partitions for topic %v", s.topic)
+	}
+	_, _, err = s.producer.SendMessage(s.newProducerMessage(msg, int32(len(partitions))))
+	return err
+}
+
+// newProducerMessage mirrors msg to the partition of its key like the Java
+// client's default partitioner (murmur2), spreading messages without key
+// round robin.
+func (s *kafkaSink) newProducerMessage(msg *sarama.ConsumerMessage, partitions int32) *sarama.ProducerMessage {
+	pm := &sarama.ProducerMessage{Topic: s.topic, Timestamp: msg.Timestamp}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		pm.Value = sarama.ByteEncoder(msg.Value)
+	}
+	for _, h := range msg.Headers {
+		pm.Headers = append(pm.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	if msg.Key != nil {
+		pm.Partition = keyPartitioners["murmur2"](msg.Key, partitions)
+	} else {
+		pm.Partition = s.next % partitions
+		s.next = pm.Partition + 1
+	}
+	return pm
+}
+
+func (s *kafkaSink) close() {
+	logClose("sink producer", s.producer)
+	logClose("sink client", s.client)
+}
+
+func (s *kafkaSink) String() string {
+	return fmt.Sprintf("topic %v at %v", s.topic, strings.Join(s.brokers, ","))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// elasticsearchSink indexes the output of each message as a document via the
+// bulk API of Elasticsearch or OpenSearch, e.g. with
+// elasticsearch:http://localhost:9200/kt-${topic}-${date}. Documents are
+// identified by their topic, partition and offset, so indexing a range again
+// overwrites rather than duplicates them. Writes are buffered and sent every
+// flushSize documents, after flushInterval and on close. https URLs verify
+// the server's certificate, against ?tlsca= if given.
+type elasticsearchSink struct {
+	sync.Mutex
+	url      string // of the cluster, without the index
+	user     *url.Userinfo
+	index    bridgeTemplate
+	client   *http.Client
+	buf      bytes.Buffer
+	buffered int
+	err      error // of a flush in the background
+
+	flushSize int
+	ticker    *time.Ticker
+	done      chan struct{}
+}
+
+// elasticsearchUnsafe are the characters index names cannot contain.
+const elasticsearchUnsafe = `\/*?"<>|,#:`
+
+func newElasticsearchSink(target string, timeout, flushInterval time.Duration) (*elasticsearchSink, error) {
+	query := ""
+	if k := strings.Index(target, "?"); k >= 0 {
+		target, query = target[:k], target[k+1:]
+	}
+	i := strings.Index(target, "://")
+	j := strings.LastIndex(target, "/")
+	if i < 0 || j <= i+3 || j == len(target)-1 {
+		return nil, fmt.Errorf("expected http(s)://[user:password@]host[:port]/<index>")
+	}
+	u, err := url.Parse(target[:j])
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	index := target[j+1:]
+	static := bridgeTemplatePlaceholder.ReplaceAllString(index, "")
+	if strings.IndexFunc(static, func(r rune) bool { return r <= ' ' || strings.ContainsRune(elasticsearchUnsafe, r) }) >= 0 {
+		return nil, fmt.Errorf("invalid index %#v, it cannot contain whitespace, control characters or any of %v", index, elasticsearchUnsafe)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if u.Scheme == "https" {
+		if transport.TLSClientConfig, err = sinkTLSConfig(params, u.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+	s := &elasticsearchSink{
+		user:      u.User,
+		index:     bridgeTemplate(index),
+		client:    &http.Client{Timeout: timeout, Transport: transport},
+		flushSize: 500,
+		ticker:    time.NewTicker(flushInterval),
+		done:      make(chan struct{}),
+	}
+	u.User = nil
+	s.url = u.String()
+
+	go func() {
+		for {
+			select {
+			case <-s.ticker.C:
+				s.Lock()
+				if err := s.flush(); err != nil && s.err == nil {
+					s.err = err
+				}
+				s.Unlock()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// indexName expands the index template, ${date} is the message's day like
+// Logstash's 2006.01.02. Index names must be lower case.
+func (s *elasticsearchSink) indexName(msg *sarama.ConsumerMessage) string {
+	t := bridgeTemplate(strings.Replace(string(s.index), "${date}", msg.Timestamp.UTC().Format("2006.01.02"), -1))
+	return strings.ToLower(t.expand(msg, elasticsearchUnsafe))
+}
+
+func (s *elasticsearchSink) write(output interface{}, msg *sarama.ConsumerMessage) error {
+	if _, ok := output.(rawOutput); ok {
+		return fmt.Errorf("raw output cannot be indexed, use -output json")
+	}
+	doc, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{
+		"_index": s.indexName(msg),
+		"_id":    fmt.Sprintf("%v-%v-%v", msg.Topic, msg.Partition, msg.Offset),
+	}})
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.buf.Write(action)
+	s.buf.WriteByte('\n')
+	s.buf.Write(doc)
+	s.buf.WriteByte('\n')
+	if s.buffered++; s.buffered >= s.flushSize {
+		return s.flush()
+	}
+	return nil
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// flush sends the buffered documents in a bulk request, it expects the lock
+// to be held.
+func (s *elasticsearchSink) flush() error {
+	if s.buffered == 0 {
+		return nil
+	}
+	req, err := http.NewRequest("POST", s.url+"/_bulk", bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.user != nil {
+		p, _ := s.user.Password()
+		req.SetBasicAuth(s.user.Username(), p)
+	}
+	s.buf.Reset()
+	s.buffered = 0
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v: %s", resp.Status, body)
+	}
+
+	var br bulkResponse
+	if err = json.Unmarshal(body, &br); err != nil {
+		return fmt.Errorf("failed to parse bulk response err=%v", err)
+	}
+	if !br.Errors {
+		return nil
+	}
+	for _, item := range br.Items {
+		for _, r := range item {
+			if r.Status < 200 || r.Status > 299 {
+				return fmt.Errorf("failed to index document with status %v: %s", r.Status, r.Error)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *elasticsearchSink) close() {
+	s.ticker.Stop()
+	close(s.done)
+	s.Lock()
+	defer s.Unlock()
+	if err := s.flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to index the last documents in %v err=%v\n", s.url, err)
+	}
+}
+
+func (s *elasticsearchSink) String() string { return fmt.Sprintf("%v index %v", s.url, s.index) }
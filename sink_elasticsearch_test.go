@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElasticsearchSink(t *testing.T) {
+	var (
+		bodies []string
+		user   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/_bulk", r.URL.Path)
+		user, _, _ = r.BasicAuth()
+		buf, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(buf))
+		if strings.Contains(string(buf), "fail") {
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	defer server.Close()
+
+	target := strings.Replace(server.URL, "http://", "http://kt:secret@", 1) + "/kt-${topic}-${date}"
+	s, err := newElasticsearchSink(target, time.Second, time.Hour)
+	require.NoError(t, err)
+	s.flushSize = 2
+
+	ts := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	msg := &sarama.ConsumerMessage{Topic: "Orders", Partition: 1, Offset: 2, Timestamp: ts}
+	require.NoError(t, s.write(map[string]int{"offset": 2}, msg))
+	require.Len(t, bodies, 0)
+	require.NoError(t, s.write(map[string]int{"offset": 3}, msg))
+	require.Equal(t, []string{
+		`{"index":{"_id":"Orders-1-2","_index":"kt-orders-2024.03.01"}}` + "\n" + `{"offset":2}` + "\n" +
+			`{"index":{"_id":"Orders-1-2","_index":"kt-orders-2024.03.01"}}` + "\n" + `{"offset":3}` + "\n",
+	}, bodies)
+	require.Equal(t, "kt", user)
+
+	require.NoError(t, s.write(map[string]string{"status": "ok"}, msg))
+	require.Error(t, s.write(map[string]string{"status": "fail"}, msg))
+	require.Error(t, s.write(rawOutput("raw\n"), msg))
+	s.close()
+
+	for _, target := range []string{"http://localhost:9200", "http://localhost:9200/kt orders", "http://localhost:9200/kt#${topic}", "http://localhost:9200/kt?tlsca=%zz"} {
+		_, err = newElasticsearchSink(target, time.Second, time.Second)
+		require.Error(t, err, target)
+	}
+}
+
+func TestElasticsearchSinkVerifiesTLS(t *testing.T) {
+	cfg, ca := testTLSServer(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	server.TLS = cfg
+	server.StartTLS()
+	defer server.Close()
+
+	msg := &sarama.ConsumerMessage{Topic: "orders"}
+	s, err := newElasticsearchSink(server.URL+"/kt-${topic}", time.Second, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, s.write(map[string]int{"offset": 2}, msg))
+	s.Lock()
+	require.Error(t, s.flush())
+	s.Unlock()
+
+	s, err = newElasticsearchSink(server.URL+"/kt-${topic}?tlsca="+url.QueryEscape(ca), time.Second, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, s.write(map[string]int{"offset": 2}, msg))
+	s.Lock()
+	require.NoError(t, s.flush())
+	s.Unlock()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyText(t *testing.T) {
+	actual, err := copyText("a\tb\nc\\d")
+	require.NoError(t, err)
+	require.Equal(t, `a\tb\nc\\d`, actual)
+	_, err = copyText("a\x00b")
+	require.Error(t, err)
+	require.Equal(t, `"public"."my""table"`, quotePostgresTable(`public.my"table`))
+
+	require.True(t, jsonHasNUL([]byte(`{"a":"x\u0000"}`)))
+	require.False(t, jsonHasNUL([]byte(`{"a":"x\\u0000"}`)))
+	require.True(t, jsonHasNUL([]byte(`"\\\u0000"`)))
+	require.False(t, jsonHasNUL([]byte(`{"a":"\u0001"}`)))
+}
+
+func TestPostgresSinkVerifiesTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "kt-postgres")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.ReadFull(conn, make([]byte, 8)) // SSLRequest
+				conn.Write([]byte{'S'})
+				tc := tls.Server(conn, srv.TLS)
+				if tc.Handshake() != nil {
+					return
+				}
+				pg := &postgresSink{conn: tc, r: bufio.NewReader(tc)}
+				buf := make([]byte, 4)
+				io.ReadFull(pg.r, buf)
+				io.ReadFull(pg.r, make([]byte, binary.BigEndian.Uint32(buf)-4))
+				pg.send('R', []byte{0, 0, 0, 0})
+				pg.send('Z', []byte{'I'})
+				for {
+					typ, _, err := pg.receive()
+					if err != nil || typ == 'X' {
+						return
+					}
+					pg.send('C', cString("CREATE TABLE"))
+					pg.send('Z', []byte{'I'})
+				}
+			}()
+		}
+	}()
+
+	target := "postgres://kt@" + ln.Addr().String() + "/debug?sslmode=require"
+	_, err = newPostgresSink(target, time.Second, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "certificate")
+
+	s, err := newPostgresSink(target+"&sslrootcert="+url.QueryEscape(ca), time.Second, time.Hour)
+	require.NoError(t, err)
+	msg := &sarama.ConsumerMessage{Topic: "orders"}
+	nul := "a\x00"
+	require.Error(t, s.write(consumedMessage{Key: &nul}, msg))
+	require.Error(t, s.write(consumedMessage{Value: map[string]string{"a": nul}}, msg))
+	s.close()
+}
+
+func TestPostgresSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	type received struct {
+		startup, password string
+		queries, copied   []string
+	}
+	done := make(chan received, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var (
+			rcv = received{}
+			srv = &postgresSink{conn: conn, r: bufio.NewReader(conn)}
+			buf = make([]byte, 4)
+		)
+		io.ReadFull(srv.r, buf)
+		startup := make([]byte, binary.BigEndian.Uint32(buf)-4)
+		io.ReadFull(srv.r, startup)
+		rcv.startup = string(startup[4:])
+
+		srv.send('R', []byte{0, 0, 0, 3})
+		for {
+			typ, body, err := srv.receive()
+			if err != nil {
+				done <- rcv
+				return
+			}
+			switch typ {
+			case 'p':
+				rcv.password = strings.TrimSuffix(string(body), "\x00")
+				srv.send('R', []byte{0, 0, 0, 0})
+				srv.send('Z', []byte{'I'})
+			case 'Q':
+				q := strings.TrimSuffix(string(body), "\x00")
+				rcv.queries = append(rcv.queries, q)
+				if strings.HasPrefix(q, "COPY") {
+					srv.send('G', []byte{0, 0, 0})
+					continue
+				}
+				srv.send('C', cString("CREATE TABLE"))
+				srv.send('Z', []byte{'I'})
+			case 'd':
+				rcv.copied = append(rcv.copied, string(body))
+			case 'c':
+				srv.send('C', cString("COPY 2"))
+				srv.send('Z', []byte{'I'})
+			case 'X':
+				done <- rcv
+				return
+			}
+		}
+	}()
+
+	s, err := newPostgresSink("postgres://kt:secret@"+ln.Addr().String()+"/debug?table=orders", time.Second, time.Hour)
+	require.NoError(t, err)
+	s.flushSize = 2
+
+	ts := time.Date(2024, 3, 1, 14, 2, 15, 0, time.UTC)
+	msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 2, Timestamp: ts}
+	id := "id\t23"
+	require.NoError(t, s.write(consumedMessage{Key: &id, Value: map[string]int{"id": 23}}, msg))
+	require.NoError(t, s.write(consumedMessage{}, msg))
+	require.Error(t, s.write(rawOutput("raw\n"), msg))
+	s.close()
+
+	rcv := <-done
+	require.Equal(t, "user\x00kt\x00database\x00debug\x00application_name\x00kt\x00\x00", rcv.startup)
+	require.Equal(t, "secret", rcv.password)
+	require.Equal(t, []string{
+		`CREATE TABLE IF NOT EXISTS "orders" (topic text, partition integer, "offset" bigint, ts timestamptz, key text, value jsonb)`,
+		`COPY "orders" (topic, partition, "offset", ts, key, value) FROM STDIN`,
+	}, rcv.queries)
+	require.Equal(t, []string{
+		"orders\t1\t2\t2024-03-01T14:02:15Z\tid\\t23\t{\"id\":23}\n" +
+			"orders\t1\t2\t2024-03-01T14:02:15Z\t\\N\t\\N\n",
+	}, rcv.copied)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// redisSink sets the decoded value of each message under its decoded key, so
+// the latest value per key of a compacted topic can be looked up in Redis,
+// e.g. with redis://localhost/0?prefix=orders:&ttl=1h. Tombstones delete the
+// key, messages without a key are skipped. It speaks just enough RESP for
+// AUTH, SELECT, SET and DEL, rediss:// connects via TLS and verifies the
+// server's certificate, against ?tlsca= if given. Keys and values are sent
+// as bulk strings, which are binary safe, so they may contain any bytes.
+type redisSink struct {
+	sync.Mutex
+	addr    string
+	prefix  string
+	ttl     time.Duration
+	conn    net.Conn
+	r       *bufio.Reader
+	skipped int
+}
+
+func newRedisSink(target string, timeout time.Duration) (*redisSink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	s := &redisSink{addr: u.Host, prefix: q.Get("prefix")}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if ttl := q.Get("ttl"); ttl != "" {
+		if s.ttl, err = time.ParseDuration(ttl); err != nil || s.ttl < time.Millisecond {
+			return nil, fmt.Errorf("invalid ttl %#v, expected a duration like 1h", ttl)
+		}
+	}
+	db := strings.Trim(u.Path, "/")
+	if db != "" {
+		if _, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %#v, expected a number", db)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if u.Scheme == "rediss" {
+		var cfg *tls.Config
+		if cfg, err = sinkTLSConfig(q, u.Hostname()); err != nil {
+			return nil, err
+		}
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, cfg)
+	} else {
+		s.conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.r = bufio.NewReader(s.conn)
+
+	s.conn.SetDeadline(time.Now().Add(timeout))
+	if u.User != nil {
+		password, ok := u.User.Password()
+		args := []string{"AUTH", u.User.Username(), password}
+		if !ok {
+			args = []string{"AUTH", u.User.Username()} // redis://password@
+		} else if u.User.Username() == "" {
+			args = []string{"AUTH", password}
+		}
+		if err = s.do(args...); err != nil {
+			s.conn.Close()
+			return nil, fmt.Errorf("failed to authenticate err=%v", err)
+		}
+	}
+	if db != "" {
+		if err = s.do("SELECT", db); err != nil {
+			s.conn.Close()
+			return nil, err
+		}
+	}
+	s.conn.SetDeadline(time.Time{})
+	return s, nil
+}
+
+// do sends a command and reads its reply, returning error replies as errors.
+func (s *redisSink) do(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return err
+	}
+
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return fmt.Errorf("invalid reply")
+	}
+	switch line[0] {
+	case '-':
+		return fmt.Errorf("%v", line[1:])
+	case '$': // bulk string, e.g. to SET with GET
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("invalid reply %#v", line)
+		}
+		if n >= 0 {
+			_, err = io.CopyN(ioutil.Discard, s.r, int64(n)+2)
+		}
+		return err
+	}
+	return nil
+}
+
+// redisString is the string stored for a decoded key or value: strings as
+// they are, other JSON as its text.
+func redisString(v json.RawMessage) (string, bool) {
+	if len(v) == 0 || string(v) == "null" {
+		return "", false
+	}
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s, true
+	}
+	return string(v), true
+}
+
+func (s *redisSink) write(output interface{}, msg *sarama.ConsumerMessage) error {
+	if _, ok := output.(rawOutput); ok {
+		return fmt.Errorf("raw output cannot be stored, use -output json")
+	}
+	buf, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	var fields struct {
+		Key   json.RawMessage `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err = json.Unmarshal(buf, &fields); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	key, ok := redisString(fields.Key)
+	if !ok {
+		s.skipped++
+		return nil
+	}
+	key = s.prefix + key
+	value, ok := redisString(fields.Value)
+	if !ok {
+		return s.do("DEL", key)
+	}
+	if s.ttl > 0 {
+		return s.do("SET", key, value, "PX", strconv.FormatInt(int64(s.ttl/time.Millisecond), 10))
+	}
+	return s.do("SET", key, value)
+}
+
+func (s *redisSink) close() {
+	s.Lock()
+	defer s.Unlock()
+	if s.skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %v messages without a key\n", s.skipped)
+	}
+	logClose("Redis connection", s.conn)
+}
+
+func (s *redisSink) String() string { return fmt.Sprintf("Redis %v", s.addr) }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis accepts a connection and sends the commands it receives, which
+// it answers with OK.
+func fakeRedis(t *testing.T) (string, chan []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return fakeRedisOn(ln)
+}
+
+func fakeRedisOn(ln net.Listener) (string, chan []string) {
+	commands := make(chan []string, 10)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(commands)
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			var cmd []string
+			for i := 0; i < n; i++ {
+				r.ReadString('\n')
+				arg, _ := r.ReadString('\n')
+				cmd = append(cmd, strings.TrimSuffix(arg, "\r\n"))
+			}
+			commands <- cmd
+			io.WriteString(conn, "+OK\r\n")
+		}
+	}()
+	return ln.Addr().String(), commands
+}
+
+func TestRedisSink(t *testing.T) {
+	addr, commands := fakeRedis(t)
+	s, err := newRedisSink("redis://:secret@"+addr+"/2?prefix=customer:&ttl=1h", time.Second)
+	require.NoError(t, err)
+
+	msg := &sarama.ConsumerMessage{Topic: "customers"}
+	key, value := "c-1", `{"name":"Hans"}`
+	require.NoError(t, s.write(consumedMessage{Key: &key, Value: &value}, msg))
+	require.NoError(t, s.write(map[string]interface{}{"key": "c-2", "value": map[string]int{"age": 3}}, msg))
+	require.NoError(t, s.write(consumedMessage{Key: &key}, msg))
+	require.NoError(t, s.write(consumedMessage{Value: &value}, msg))
+	require.Error(t, s.write(rawOutput("raw\n"), msg))
+	s.close()
+
+	var actual [][]string
+	for c := range commands {
+		actual = append(actual, c)
+	}
+	require.Equal(t, [][]string{
+		{"AUTH", "secret"},
+		{"SELECT", "2"},
+		{"SET", "customer:c-1", `{"name":"Hans"}`, "PX", "3600000"},
+		{"SET", "customer:c-2", `{"age":3}`, "PX", "3600000"},
+		{"DEL", "customer:c-1"},
+	}, actual)
+}
+
+func TestRedisSinkVerifiesTLS(t *testing.T) {
+	cfg, ca := testTLSServer(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	require.NoError(t, err)
+	addr, _ := fakeRedisOn(ln)
+	_, err = newRedisSink("rediss://"+addr, time.Second)
+	require.Error(t, err)
+
+	ln, err = tls.Listen("tcp", "127.0.0.1:0", cfg)
+	require.NoError(t, err)
+	addr, commands := fakeRedisOn(ln)
+	s, err := newRedisSink("rediss://:secret@"+addr+"?tlsca="+url.QueryEscape(ca), time.Second)
+	require.NoError(t, err)
+	s.close()
+	require.Equal(t, []string{"AUTH", "secret"}, <-commands)
+}
+
+func TestRedisSinkInvalid(t *testing.T) {
+	_, err := newRedisSink("redis://localhost/cache", time.Second)
+	require.Error(t, err)
+	_, err = newRedisSink("redis://localhost?ttl=soon", time.Second)
+	require.Error(t, err)
+}
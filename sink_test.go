@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSinkSpec(t *testing.T) {
+	data := []struct {
+		in       string
+		expected sinkSpec
+		err      bool
+	}{
+		{in: "", expected: sinkSpec{kind: "stdout"}},
+		{in: "stdout", expected: sinkSpec{kind: "stdout"}},
+		{in: "file:orders.jsonl", expected: sinkSpec{kind: "file", target: "orders.jsonl"}},
+		{in: "kafka:a:9092,b:9092/orders", expected: sinkSpec{kind: "kafka", target: "a:9092,b:9092/orders"}},
+		{in: "https://example.com/orders", expected: sinkSpec{kind: "http", target: "https://example.com/orders"}},
+		{in: "exec:jq .value", expected: sinkSpec{kind: "exec", target: "jq .value"}},
+		{in: "opensearch:http://localhost:9200/kt-${topic}", expected: sinkSpec{kind: "elasticsearch", target: "http://localhost:9200/kt-${topic}"}},
+		{in: "elasticsearch:localhost:9200/kt", err: true},
+		{in: "postgres://kt@localhost/debug?table=orders", expected: sinkSpec{kind: "postgres", target: "postgres://kt@localhost/debug?table=orders"}},
+		{in: "rediss://localhost/0?ttl=1h", expected: sinkSpec{kind: "redis", target: "rediss://localhost/0?ttl=1h"}},
+		{in: "kafka:orders", err: true},
+		{in: "kafka:a:9092/", err: true},
+		{in: "file:", err: true},
+		{in: "nats:orders", err: true},
+	}
+
+	for _, d := range data {
+		actual, err := parseSinkSpec(d.in)
+		if d.err {
+			require.Error(t, err, d.in)
+			continue
+		}
+		require.NoError(t, err, d.in)
+		require.Equal(t, d.expected, actual, d.in)
+	}
+}
+
+// testTLSServer returns the TLS config of a server with a self-signed
+// certificate for 127.0.0.1 and the path of a PEM file to trust it.
+func testTLSServer(t *testing.T) (*tls.Config, string) {
+	srv := httptest.NewTLSServer(nil)
+	t.Cleanup(srv.Close)
+	dir, err := ioutil.TempDir("", "kt-sink")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ca := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644))
+	return srv.TLS, ca
+}
+
+func TestHTTPSink(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(buf))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	msg := &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 2}
+	s := newHTTPSink(server.URL+"/orders", 0)
+	require.NoError(t, s.write(map[string]int{"offset": 2}, msg))
+	require.NoError(t, s.write(rawOutput("raw\n"), msg))
+	require.Equal(t, []string{"{\"offset\":2}\n", "raw\n"}, bodies)
+
+	s = newHTTPSink(server.URL+"/fail", 0)
+	require.Error(t, s.write(rawOutput("raw\n"), msg))
+}
+
+func TestExecSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "out")
+
+	s, err := newExecSink("cat > " + p)
+	require.NoError(t, err)
+	msg := &sarama.ConsumerMessage{Topic: "hans", Partition: 1, Offset: 2}
+	require.NoError(t, s.write(map[string]int{"offset": 2}, msg))
+	require.NoError(t, s.write(rawOutput("raw\n"), msg))
+	s.close()
+
+	buf, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, "{\"offset\":2}\nraw\n", string(buf))
+}
+
+func TestKafkaSinkNewProducerMessage(t *testing.T) {
+	s := &kafkaSink{topic: "orders-mirror"}
+	msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Key: []byte("abc"), Value: []byte("paid")}
+
+	// murmur2("abc") is 479470107 per the Java client's UtilsTest.
+	pm := s.newProducerMessage(msg, 6)
+	require.Equal(t, "orders-mirror", pm.Topic)
+	require.Equal(t, int32(3), pm.Partition)
+	require.Equal(t, sarama.ByteEncoder("paid"), pm.Value)
+
+	msg.Key = nil
+	actual := []int32{}
+	for i := 0; i < 4; i++ {
+		actual = append(actual, s.newProducerMessage(msg, 3).Partition)
+	}
+	require.Equal(t, []int32{0, 1, 2, 0}, actual)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// source is where produce reads its input from, selected with -source. read
+// sends the inputs to out until the source is exhausted and returns the error
+// that stopped it, if any. Sources that never end, like watch and listen, run
+// until produce is interrupted.
+type source interface {
+	read(out chan input) error
+	String() string
+}
+
+// input is a line of input, or all of a file with -per-file, and where it
+// was read from: the file, URL or stdin, and the number of the line in it
+// from 1. For the JSON, CSV and Avro files of -watch-dir, it's the number of
+// the JSON value, the line of the CSV row or the number of the Avro record.
+type input struct {
+	text string
+	file string
+	line int
+}
+
+// sourceSpec is a parsed -source argument: the kind of source and where it
+// reads from.
+type sourceSpec struct {
+	kind   string
+	target string
+}
+
+// parseSourceSpec parses -source arguments like stdin, file:'orders-*.jsonl',
+// dir:fixtures, watch:incoming, https://example.com/orders.jsonl or
+// listen:localhost:8080.
+func parseSourceSpec(s string) (sourceSpec, error) {
+	switch {
+	case s == "" || s == "stdin":
+		return sourceSpec{kind: "stdin"}, nil
+	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+		return sourceSpec{kind: "http", target: s}, nil
+	}
+
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return sourceSpec{}, fmt.Errorf("invalid source %#v, expected stdin, file:<glob>, dir:<path>, watch:<path>, an http(s) URL or listen:<address>", s)
+	}
+	sp := sourceSpec{kind: kv[0], target: kv[1]}
+	switch sp.kind {
+	case "file":
+		if _, err := filepath.Match(sp.target, ""); err != nil {
+			return sourceSpec{}, fmt.Errorf("invalid source %#v, %v", s, err)
+		}
+	case "dir", "watch":
+	case "listen":
+		if _, _, err := net.SplitHostPort(sp.target); err != nil {
+			return sourceSpec{}, fmt.Errorf("invalid source %#v, expected listen:[host]:<port>", s)
+		}
+	default:
+		return sourceSpec{}, fmt.Errorf("unsupported source %#v, only stdin, file, dir, watch, http(s) and listen are supported", sp.kind)
+	}
+	return sp, nil
+}
+
+// readInputs sends the lines of r of up to max bytes to out, or with perFile
+// all of r without trailing newline. name is where r is read from.
+func readInputs(r io.Reader, name string, perFile bool, max int, out chan input) error {
+	if !perFile {
+		return scanLines(r, name, max, out)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	out <- input{text: strings.TrimSuffix(strings.TrimSuffix(string(buf), "\n"), "\r"), file: name, line: 1}
+	return nil
+}
+
+// scanLines sends the lines of r of up to max bytes to out.
+func scanLines(r io.Reader, name string, max int, out chan input) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, max), max)
+
+	for line := 1; scanner.Scan(); line++ {
+		out <- input{text: scanner.Text(), file: name, line: line}
+	}
+	return scanner.Err()
+}
+
+type stdinSource struct {
+	bufferSize int
+	pipeline   bool
+}
+
+func (s *stdinSource) read(out chan input) error {
+	if s.pipeline {
+		return scanPipelineLines(os.Stdin, "stdin", s.bufferSize, out)
+	}
+	return scanLines(os.Stdin, "stdin", s.bufferSize, out)
+}
+
+func (s *stdinSource) String() string { return "stdin" }
+
+// fileSource reads files in the given order.
+type fileSource struct {
+	name       string
+	paths      []string
+	perFile    bool
+	bufferSize int
+}
+
+// newFileSource reads the files matching the glob pattern sorted by name, or
+// the file of that name.
+func newFileSource(pattern string, perFile bool, bufferSize int) (*fileSource, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil || len(paths) == 0 {
+		if _, err := os.Stat(pattern); err != nil {
+			return nil, err
+		}
+		paths = []string{pattern}
+	}
+	sort.Strings(paths)
+	return &fileSource{name: pattern, paths: paths, perFile: perFile, bufferSize: bufferSize}, nil
+}
+
+// newDirSource reads the regular files in dir sorted by name, skipping
+// hidden files.
+func newDirSource(dir string, perFile bool, bufferSize int) (*fileSource, error) {
+	paths, err := dirFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSource{name: dir, paths: paths, perFile: perFile, bufferSize: bufferSize}, nil
+}
+
+func dirFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, info.Name()))
+	}
+	return paths, nil
+}
+
+func readFile(path string, perFile bool, bufferSize int, out chan input) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = readInputs(f, path, perFile, bufferSize, out); err != nil {
+		return fmt.Errorf("failed to read %v err=%v", path, err)
+	}
+	return nil
+}
+
+func (s *fileSource) read(out chan input) error {
+	for _, p := range s.paths {
+		if err := readFile(p, s.perFile, s.bufferSize, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSource) String() string { return s.name }
+
+// watchSource polls a directory and reads the files that appear in it after
+// it started, in name order. A file is read once its size and modification
+// time didn't change between two polls, so files that are still being
+// written aren't read partially. With a state file, as for -watch-dir, it
+// also reads the files that were there before and files that changed after
+// they were read, unless the state file lists them as processed with the
+// same size and modification time.
+type watchSource struct {
+	dir        string
+	interval   time.Duration
+	perFile    bool
+	bufferSize int
+	convert    bool   // read files according to their extension, see readWatchedFile
+	jsonValues bool   // of converted files, otherwise values are JSON strings
+	statePath  string // tracks the processed files, if set
+}
+
+func (s *watchSource) read(out chan input) error {
+	type state struct {
+		size    int64
+		modTime time.Time
+		done    bool
+	}
+	var (
+		files     = map[string]*state{}
+		processed = map[string]watchedFile{}
+	)
+	existing, err := dirFiles(s.dir)
+	if err != nil {
+		return err
+	}
+	if s.statePath != "" {
+		if processed, err = readWatchState(s.statePath); err != nil {
+			return err
+		}
+	}
+	for _, p := range existing {
+		info, err := os.Stat(p)
+		if err == nil && (s.statePath == "" || processed[filepath.Base(p)].matches(info)) {
+			files[p] = &state{size: info.Size(), modTime: info.ModTime(), done: true}
+		}
+	}
+
+	for {
+		paths, err := dirFiles(s.dir)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				continue // e.g. moved away again
+			}
+			st, ok := files[p]
+			if !ok {
+				files[p] = &state{size: info.Size(), modTime: info.ModTime()}
+				continue
+			}
+			if st.done && (s.statePath == "" || st.size == info.Size() && st.modTime.Equal(info.ModTime())) {
+				continue
+			}
+			if st.size != info.Size() || !st.modTime.Equal(info.ModTime()) {
+				// with a state file, processed files that change are read again.
+				st.size, st.modTime, st.done = info.Size(), info.ModTime(), false
+				continue
+			}
+			if s.convert {
+				err = readWatchedFile(p, s.jsonValues, s.bufferSize, out)
+			} else {
+				err = readFile(p, s.perFile, s.bufferSize, out)
+			}
+			if err != nil {
+				return err
+			}
+			st.done = true
+			if s.statePath != "" {
+				processed[filepath.Base(p)] = watchedFile{Size: info.Size(), ModTime: info.ModTime(), Processed: time.Now()}
+				if err = writeWatchState(s.statePath, processed); err != nil {
+					return err
+				}
+			}
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+func (s *watchSource) String() string { return "watched " + s.dir }
+
+// httpSource reads the body of a GET request.
+type httpSource struct {
+	url        string
+	client     *http.Client
+	perFile    bool
+	bufferSize int
+}
+
+func (s *httpSource) read(out chan input) error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return readInputs(resp.Body, s.url, s.perFile, s.bufferSize, out)
+}
+
+func (s *httpSource) String() string { return s.url }
+
+// listenSource serves HTTP and reads the bodies of POST requests to any path,
+// so other tools can send input to produce. The lines of a request are
+// produced together and it's answered once they're read. Their lines are
+// numbered per request, with the URL and remote address as file.
+type listenSource struct {
+	sync.Mutex
+	addr       string
+	perFile    bool
+	bufferSize int
+	ln         net.Listener
+}
+
+func newListenSource(addr string, perFile bool, bufferSize int) (*listenSource, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &listenSource{addr: ln.Addr().String(), perFile: perFile, bufferSize: bufferSize, ln: ln}, nil
+}
+
+func (s *listenSource) read(out chan input) error {
+	return http.Serve(s.ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		s.Lock()
+		err := readInputs(r.Body, fmt.Sprintf("%v%v from %v", s, r.URL.Path, r.RemoteAddr), s.perFile, s.bufferSize, out)
+		s.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func (s *listenSource) String() string { return "http://" + s.addr }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSourceSpec(t *testing.T) {
+	data := []struct {
+		in       string
+		expected sourceSpec
+		err      bool
+	}{
+		{in: "", expected: sourceSpec{kind: "stdin"}},
+		{in: "stdin", expected: sourceSpec{kind: "stdin"}},
+		{in: "file:exports/*.jsonl", expected: sourceSpec{kind: "file", target: "exports/*.jsonl"}},
+		{in: "dir:fixtures", expected: sourceSpec{kind: "dir", target: "fixtures"}},
+		{in: "watch:incoming", expected: sourceSpec{kind: "watch", target: "incoming"}},
+		{in: "https://example.com/orders.jsonl", expected: sourceSpec{kind: "http", target: "https://example.com/orders.jsonl"}},
+		{in: "listen::8080", expected: sourceSpec{kind: "listen", target: ":8080"}},
+		{in: "listen:8080", err: true},
+		{in: "file:[", err: true},
+		{in: "kafka:localhost/orders", err: true},
+		{in: "file:", err: true},
+	}
+	for _, d := range data {
+		actual, err := parseSourceSpec(d.in)
+		if d.err {
+			require.Error(t, err, d.in)
+			continue
+		}
+		require.NoError(t, err, d.in)
+		require.Equal(t, d.expected, actual, d.in)
+	}
+}
+
+func TestWatchSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "old.json"), []byte("old\n"), 0644))
+
+	out := make(chan input)
+	s := &watchSource{dir: dir, interval: 10 * time.Millisecond, bufferSize: 1024}
+	go s.read(out)
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "new.json"), []byte("n1\nn2\n"), 0644))
+
+	for _, expected := range []string{"n1", "n2"} {
+		select {
+		case l := <-out:
+			require.Equal(t, expected, l.text)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %v", expected)
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a\nb\n"))
+	}))
+	defer srv.Close()
+
+	out := make(chan input, 2)
+	s := &httpSource{url: srv.URL, client: srv.Client(), bufferSize: 1024}
+	require.NoError(t, s.read(out))
+	require.Equal(t, input{text: "a", file: srv.URL, line: 1}, <-out)
+	require.Equal(t, input{text: "b", file: srv.URL, line: 2}, <-out)
+}
+
+func TestListenSource(t *testing.T) {
+	s, err := newListenSource("127.0.0.1:0", true, 1024)
+	require.NoError(t, err)
+	out := make(chan input, 1)
+	go s.read(out)
+
+	resp, err := http.Post(s.String(), "application/json", strings.NewReader("{\"value\": 1}\n"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, `{"value": 1}`, (<-out).text)
+
+	resp, err = http.Get(s.String())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
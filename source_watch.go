@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watchedFile is an entry of -watch-dir's state file. Files are identified
+// by name, size and modification time, so a file that is replaced under the
+// same name is processed again.
+type watchedFile struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	Processed time.Time `json:"processed"`
+}
+
+func (f watchedFile) matches(info os.FileInfo) bool {
+	return f.Size == info.Size() && f.ModTime.Equal(info.ModTime())
+}
+
+// readWatchState reads the processed files of a state file, which doesn't
+// exist before the first file was processed.
+func readWatchState(path string) (map[string]watchedFile, error) {
+	files := map[string]watchedFile{}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &files); err != nil {
+		return nil, fmt.Errorf("invalid state file %v err=%v", path, err)
+	}
+	return files, nil
+}
+
+// writeWatchState replaces the state file, via a temporary file so it's
+// never left half written.
+func writeWatchState(path string, files map[string]watchedFile) error {
+	buf, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readWatchedFile sends the input of a file of -watch-dir to out as JSON
+// messages, depending on its extension:
+//
+//	.json, .jsonl, .ndjson: JSON values, the elements of arrays, as messages
+//	.csv: a message per row, see csvMessage
+//	.avro: a message per record of an Avro object container file
+//
+// Other files are read line by line like stdin.
+func readWatchedFile(path string, jsonValues bool, bufferSize int, out chan input) error {
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonl", ".ndjson":
+		err = readJSONFile(path, out)
+	case ".csv":
+		err = readCSVFile(path, jsonValues, out)
+	case ".avro":
+		var data []byte
+		if data, err = ioutil.ReadFile(path); err == nil {
+			record := 0
+			err = readAvroFile(data, func(v interface{}) error {
+				record++
+				return sendMessage(map[string]interface{}{"value": watchedValue(v, jsonValues)}, input{file: path, line: record}, out)
+			})
+		}
+	default:
+		return readFile(path, false, bufferSize, out)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %v err=%v", path, err)
+	}
+	return nil
+}
+
+// sendMessage sends msg as the JSON text of in.
+func sendMessage(msg interface{}, in input, out chan input) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	in.text = string(buf)
+	out <- in
+	return nil
+}
+
+// watchedValue is a value of a CSV row or Avro record as produce expects it:
+// JSON with -decodevalue json or avro, otherwise a JSON string.
+func watchedValue(v interface{}, jsonValues bool) interface{} {
+	if jsonValues {
+		return v
+	}
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
+
+func readJSONFile(path string, out chan input) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	value := 0
+	for {
+		var v json.RawMessage
+		if err = dec.Decode(&v); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var items []json.RawMessage
+		if json.Unmarshal(v, &items) != nil {
+			items = []json.RawMessage{v}
+		}
+		for _, item := range items {
+			var buf bytes.Buffer
+			if err = json.Compact(&buf, item); err != nil {
+				return err
+			}
+			value++
+			out <- input{text: buf.String(), file: path, line: value}
+		}
+	}
+}
+
+// readCSVFile sends a message per row of a CSV file with a header row. The
+// columns key and partition set those of the message. With a value column,
+// it's the value and the remaining columns are headers. Otherwise the value
+// is an object of the remaining columns.
+func readCSVFile(path string, jsonValues bool, out chan input) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg, err := csvMessage(header, row, jsonValues)
+		if err != nil {
+			return fmt.Errorf("invalid row %v err=%v", row, err)
+		}
+		line, _ := r.FieldPos(0)
+		if err = sendMessage(msg, input{file: path, line: line}, out); err != nil {
+			return err
+		}
+	}
+}
+
+func csvMessage(header, row []string, jsonValues bool) (map[string]interface{}, error) {
+	var (
+		msg     = map[string]interface{}{}
+		fields  = map[string]interface{}{}
+		headers = map[string]string{}
+		value   *string
+	)
+	for i, col := range header {
+		v := row[i]
+		switch col {
+		case "key":
+			msg["key"] = v
+		case "partition":
+			p, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid partition %#v", v)
+			}
+			msg["partition"] = p
+		case "value":
+			value = &v
+		default:
+			fields[col] = v
+			headers[col] = v
+		}
+	}
+
+	switch {
+	case value == nil:
+		msg["value"] = watchedValue(fields, jsonValues)
+	case jsonValues && json.Valid([]byte(*value)):
+		msg["value"] = json.RawMessage(*value)
+	default:
+		msg["value"] = *value
+	}
+	if value != nil && len(headers) > 0 {
+		msg["headers"] = headers
+	}
+	return msg, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVMessage(t *testing.T) {
+	msg, err := csvMessage([]string{"key", "value", "trace"}, []string{"id-1", `{"a":1}`, "t-1"}, false)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"key": "id-1", "value": `{"a":1}`, "headers": map[string]string{"trace": "t-1"}}, msg)
+
+	msg, err = csvMessage([]string{"key", "partition", "name", "city"}, []string{"id-2", "3", "Hans", "Berlin"}, false)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"key": "id-2", "partition": int64(3), "value": `{"city":"Berlin","name":"Hans"}`}, msg)
+
+	msg, err = csvMessage([]string{"name"}, []string{"Hans"}, true)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"value": map[string]interface{}{"name": "Hans"}}, msg)
+
+	_, err = csvMessage([]string{"partition"}, []string{"one"}, false)
+	require.Error(t, err)
+}
+
+func readWatched(t *testing.T, path string, jsonValues bool) []string {
+	out := make(chan input, 10)
+	require.NoError(t, readWatchedFile(path, jsonValues, 1024, out))
+	close(out)
+	lines := []string{}
+	for l := range out {
+		lines = append(lines, l.text)
+	}
+	return lines
+}
+
+func TestReadWatchedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-watch-dir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+		return p
+	}
+
+	p := write("orders.json", "[\n  {\"key\": \"a\", \"value\": \"1\"},\n  {\"key\": \"b\", \"value\": \"2\"}\n]\n")
+	require.Equal(t, []string{`{"key":"a","value":"1"}`, `{"key":"b","value":"2"}`}, readWatched(t, p, false))
+
+	p = write("orders.jsonl", "{\"value\": \"1\"}\n{\"value\": \"2\"}\n")
+	require.Equal(t, []string{`{"value":"1"}`, `{"value":"2"}`}, readWatched(t, p, false))
+
+	p = write("customers.CSV", "key,name\nc-1,Hans\n")
+	require.Equal(t, []string{`{"key":"c-1","value":{"name":"Hans"}}`}, readWatched(t, p, true))
+
+	p = write("notes.txt", "a b\nc\n")
+	require.Equal(t, []string{"a b", "c"}, readWatched(t, p, false))
+}
+
+func TestWatchSourceState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-watch-dir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, watchStateFile)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.jsonl"), []byte(`{"value":"a"}`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.jsonl"), []byte(`{"value":"b"}`), 0644))
+
+	info, err := os.Stat(filepath.Join(dir, "a.jsonl"))
+	require.NoError(t, err)
+	require.NoError(t, writeWatchState(state, map[string]watchedFile{"a.jsonl": {Size: info.Size(), ModTime: info.ModTime()}}))
+
+	out := make(chan input)
+	s := &watchSource{dir: dir, interval: 10 * time.Millisecond, bufferSize: 1024, convert: true, statePath: state}
+	go s.read(out)
+
+	select {
+	case l := <-out:
+		require.Equal(t, `{"value":"b"}`, l.text, "skips the processed a.jsonl")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for b.jsonl")
+	}
+
+	require.Eventually(t, func() bool {
+		files, err := readWatchState(state)
+		require.NoError(t, err)
+		return len(files) == 2
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// streamsCmd dispatches the subcommands of kt streams.
+type streamsCmd struct{}
+
+func (cmd *streamsCmd) run(args []string) {
+	if len(args) > 0 && args[0] == "stores" {
+		(&streamsStoresCmd{}).run(args[1:])
+		return
+	}
+	exitf(exitUsage, "unknown streams command, use \"kt streams stores -help\" for more information")
+}
+
+type streamsStoresCmd struct {
+	clientConfig
+
+	applicationID string
+	store         string
+	verbose       bool
+	pretty        bool
+}
+
+type streamsStoresArgs struct {
+	clientArgs
+
+	applicationID string
+	store         string
+	verbose       bool
+	pretty        bool
+}
+
+// streamsTopic is an internal topic of a Kafka Streams application, Name is
+// the store name of a changelog or the processor name of a repartition
+// topic.
+type streamsTopic struct {
+	Name       string `json:"name"`
+	Topic      string `json:"topic"`
+	Partitions int    `json:"partitions"`
+}
+
+type streamsTopology struct {
+	ApplicationID string         `json:"applicationId"`
+	Stores        []streamsTopic `json:"stores"`
+	Repartitions  []streamsTopic `json:"repartitions"`
+}
+
+// newStreamsTopology finds the internal topics of application app among
+// topics, which maps topic names to their partition counts. Streams names
+// them <app>-<name>-changelog and <app>-<name>-repartition.
+func newStreamsTopology(app string, topics map[string]int) streamsTopology {
+	result := streamsTopology{ApplicationID: app, Stores: []streamsTopic{}, Repartitions: []streamsTopic{}}
+	prefix := app + "-"
+	for topic, partitions := range topics {
+		if !strings.HasPrefix(topic, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(topic, prefix)
+		switch {
+		case strings.HasSuffix(name, "-changelog") && len(name) > len("-changelog"):
+			t := streamsTopic{Name: strings.TrimSuffix(name, "-changelog"), Topic: topic, Partitions: partitions}
+			result.Stores = append(result.Stores, t)
+		case strings.HasSuffix(name, "-repartition") && len(name) > len("-repartition"):
+			t := streamsTopic{Name: strings.TrimSuffix(name, "-repartition"), Topic: topic, Partitions: partitions}
+			result.Repartitions = append(result.Repartitions, t)
+		}
+	}
+	sort.Slice(result.Stores, func(i, j int) bool { return result.Stores[i].Name < result.Stores[j].Name })
+	sort.Slice(result.Repartitions, func(i, j int) bool { return result.Repartitions[i].Name < result.Repartitions[j].Name })
+	return result
+}
+
+// only keeps the store named store and no repartition topics, it reports
+// false if there's no such store.
+func (t *streamsTopology) only(store string) bool {
+	for _, s := range t.Stores {
+		if s.Name == store {
+			t.Stores, t.Repartitions = []streamsTopic{s}, []streamsTopic{}
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd *streamsStoresCmd) run(args []string) {
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	client, err := sarama.NewClient(cmd.brokers, cmd.saramaConfig())
+	if err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", client)
+
+	all, err := client.Topics()
+	if err != nil {
+		failf("failed to read topics err=%v", err)
+	}
+	topics := map[string]int{}
+	for _, t := range all {
+		if !strings.HasPrefix(t, cmd.applicationID+"-") {
+			continue
+		}
+		ps, err := client.Partitions(t)
+		if err != nil {
+			failf("failed to read partitions for topic %v err=%v", t, err)
+		}
+		topics[t] = len(ps)
+	}
+
+	topology := newStreamsTopology(cmd.applicationID, topics)
+	if cmd.store != "" && !topology.only(cmd.store) {
+		failf("found no changelog topic for store %v of application %v", cmd.store, cmd.applicationID)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: topology, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+func (cmd *streamsStoresCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("streams")
+}
+
+func (cmd *streamsStoresCmd) failStartup(msg string) {
+	failUsage(msg, "kt streams stores")
+}
+
+func (cmd *streamsStoresCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.applicationID == "" {
+		cmd.failStartup("-application-id is required.")
+	}
+
+	cmd.applicationID = args.applicationID
+	cmd.store = args.store
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *streamsStoresCmd) parseFlags(as []string) streamsStoresArgs {
+	var args streamsStoresArgs
+	flags := flag.NewFlagSet("streams stores", flag.ContinueOnError)
+	flags.StringVar(&args.applicationID, "application-id", "", "application.id of the Kafka Streams application (required).")
+	flags.StringVar(&args.store, "store", "", "Only print the changelog topic of this store (defaults to all stores and repartition topics).")
+	args.clientArgs.addFlags(flags)
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of streams stores:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, streamsStoresDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var streamsStoresDocString = `
+The value for -brokers can also be set via environment variable KT_BROKERS.
+The value supplied on the command line wins over the environment variable value.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt streams stores" maps the state stores of a Kafka Streams application to
+their changelog topics and lists its repartition topics, which Streams names
+<application.id>-<name>-changelog and <application.id>-<name>-repartition:
+
+  $ kt streams stores -application-id orders-app
+  $ kt streams stores -application-id orders-app -store counts
+
+Stores without logging enabled have no changelog topic and aren't listed.
+The topics can be read with kt consume, which decodes the window timestamps
+Streams appends to keys with -streams-key and the timestamps of timestamped
+stores with -streams-value, see kt consume -help.`
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStreamsTopology(t *testing.T) {
+	topology := newStreamsTopology("orders-app", map[string]int{
+		"orders":                      12,
+		"orders-app-counts-changelog": 6,
+		"orders-app-KSTREAM-AGGREGATE-STATE-STORE-0000000003-changelog": 6,
+		"orders-app-by-customer-repartition":                            6,
+		"orders-app-changelog":                                          1,
+		"orders-app-v2-counts-changelog":                                3,
+		"other-app-counts-changelog":                                    6,
+	})
+	require.Equal(t, streamsTopology{
+		ApplicationID: "orders-app",
+		Stores: []streamsTopic{
+			{Name: "KSTREAM-AGGREGATE-STATE-STORE-0000000003", Topic: "orders-app-KSTREAM-AGGREGATE-STATE-STORE-0000000003-changelog", Partitions: 6},
+			{Name: "counts", Topic: "orders-app-counts-changelog", Partitions: 6},
+			{Name: "v2-counts", Topic: "orders-app-v2-counts-changelog", Partitions: 3},
+		},
+		Repartitions: []streamsTopic{{Name: "by-customer", Topic: "orders-app-by-customer-repartition", Partitions: 6}},
+	}, topology)
+
+	require.True(t, topology.only("counts"))
+	require.Equal(t, []streamsTopic{{Name: "counts", Topic: "orders-app-counts-changelog", Partitions: 6}}, topology.Stores)
+	require.Empty(t, topology.Repartitions)
+	require.False(t, topology.only("missing"))
+}
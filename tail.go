@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ANSI escape sequences of the tail output's colors.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+var tailEscaper = strings.NewReplacer("\n", `\n`, "\r", `\r`)
+
+// useColor resolves -color. auto colors output when stdout is a terminal
+// and NO_COLOR isn't set.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && terminal.IsTerminal(int(syscall.Stdout)), nil
+	}
+	return false, fmt.Errorf("unsupported color argument %#v, only auto, always and never are supported", mode)
+}
+
+// tailField renders a key or value on a single line, decoded values as
+// compact JSON. It returns false for nil, i.e. missing keys and tombstones.
+func tailField(v interface{}) (string, bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", false, nil
+	case *string:
+		if v == nil {
+			return "", false, nil
+		}
+		return tailEscaper.Replace(*v), true, nil
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", false, err
+	}
+	return string(buf), true, nil
+}
+
+// tailLine formats m for -output tail: the timestamp in local time, the
+// partition/offset, key and value. Values longer than -max-value-len
+// characters are cut off with a marker of their length in bytes.
+func (cmd *consumeCmd) tailLine(m consumedMessage, msg *sarama.ConsumerMessage) (string, error) {
+	paint := func(color, s string) string {
+		if !cmd.color {
+			return s
+		}
+		return color + s + ansiReset
+	}
+
+	var b strings.Builder
+	if m.Timestamp != nil {
+		b.WriteString(paint(ansiDim, m.Timestamp.Local().Format("2006-01-02 15:04:05.000")))
+		b.WriteByte(' ')
+	}
+	if m.Topic != "" {
+		b.WriteString(paint(ansiCyan, m.Topic+"/"))
+	}
+	b.WriteString(paint(ansiCyan, fmt.Sprintf("%v/%v", m.Partition, m.Offset)))
+
+	key, ok, err := tailField(m.Key)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		b.WriteByte(' ')
+		b.WriteString(paint(ansiYellow, key))
+	}
+
+	value, ok, err := tailField(m.Value)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !ok && m.ValueSize != nil:
+		b.WriteString(paint(ansiDim, fmt.Sprintf(" [%d bytes]", *m.ValueSize)))
+	case !ok:
+		b.WriteString(paint(ansiRed, " <tombstone>"))
+	default:
+		if cmd.maxValueLen > 0 && utf8.RuneCountInString(value) > cmd.maxValueLen {
+			value = fmt.Sprintf("%s%s", string([]rune(value)[:cmd.maxValueLen]), paint(ansiDim, fmt.Sprintf("…[%d bytes]", len(msg.Value))))
+		}
+		b.WriteByte(' ')
+		b.WriteString(value)
+	}
+	if m.Collapsed != nil {
+		b.WriteString(paint(ansiDim, fmt.Sprintf(" [%d repeats collapsed]", *m.Collapsed)))
+	}
+
+	b.WriteByte('\n')
+	return b.String(), nil
+}
+
+var tailDocString = `
+"kt tail" follows a topic interactively. It's "kt consume" with defaults for
+reading along rather than piping: it starts at the newest offset of every
+partition unless -offsets or -group is given, and prints -output tail.
+
+-output tail prints one line per message with the timestamp in local time,
+partition/offset, key and value. Line breaks in keys and values are escaped,
+values longer than -max-value-len characters are cut off with a marker of
+their length in bytes. With -color auto, the output is colored when stdout
+is a terminal and the environment variable NO_COLOR isn't set.
+
+All flags of "kt consume" apply, see "kt consume -help" for -offsets, -filter
+and the encodings.
+
+  $ kt tail -topic orders
+  $ kt tail -topic orders -offsets newest-10: -filter 'value.status == "ERROR"'`
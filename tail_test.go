@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailLine(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 14, 2, 11, 317000000, time.Local)
+	key, value := "Arni", "Terminator\nreturns"
+	target := &consumeCmd{output: "tail"}
+
+	msg := &sarama.ConsumerMessage{Key: []byte(key), Value: []byte(value)}
+	m := consumedMessage{Partition: 0, Offset: 6, Key: &key, Value: &value, Timestamp: &ts}
+	actual, err := target.tailLine(m, msg)
+	require.Nil(t, err)
+	require.Equal(t, "2024-03-01 14:02:11.317 0/6 Arni Terminator\\nreturns\n", actual)
+
+	target.maxValueLen = 10
+	actual, err = target.tailLine(m, msg)
+	require.Nil(t, err)
+	require.Equal(t, "2024-03-01 14:02:11.317 0/6 Arni Terminator…[18 bytes]\n", actual)
+
+	target.color = true
+	actual, err = target.tailLine(consumedMessage{Topic: "news", Partition: 1, Offset: 2}, &sarama.ConsumerMessage{})
+	require.Nil(t, err)
+	require.Equal(t, ansiCyan+"news/"+ansiReset+ansiCyan+"1/2"+ansiReset+ansiRed+" <tombstone>"+ansiReset+"\n", actual)
+
+	size := 42
+	target.color = false
+	actual, err = target.tailLine(consumedMessage{Partition: 1, Offset: 3, Value: map[string]interface{}{"id": 1}}, &sarama.ConsumerMessage{})
+	require.Nil(t, err)
+	require.Equal(t, "1/3 {\"id\":1}\n", actual)
+
+	actual, err = target.tailLine(consumedMessage{Partition: 1, Offset: 4, ValueSize: &size}, &sarama.ConsumerMessage{})
+	require.Nil(t, err)
+	require.Equal(t, "1/4 [42 bytes]\n", actual)
+}
+
+func TestUseColor(t *testing.T) {
+	actual, err := useColor("always")
+	require.Nil(t, err)
+	require.True(t, actual)
+
+	actual, err = useColor("never")
+	require.Nil(t, err)
+	require.False(t, actual)
+
+	_, err = useColor("sometimes")
+	require.NotNil(t, err)
+}
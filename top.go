@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+type topCmd struct {
+	clientConfig
+
+	topics     []string
+	topicRegex *regexp.Regexp
+	groups     []string
+	interval   time.Duration
+	count      int
+	largest    bool
+	maxAge     time.Duration
+	verbose    bool
+
+	client   sarama.Client
+	metadata *metadataCache
+}
+
+type topArgs struct {
+	clientArgs
+
+	topic      string
+	topicRegex bool
+	groups     string
+	interval   time.Duration
+	count      int
+	largest    bool
+	maxAge     time.Duration
+	verbose    bool
+}
+
+// topPoll is the high water marks of a topic's partitions at a poll.
+type topPoll struct {
+	time time.Time
+	hwms map[int32]int64
+}
+
+// topRow is a topic's row of the kt top table. Rate and Skew are nil
+// without a previous poll, Skew also if nothing was produced since. Lags
+// maps groups to their total lag on the topic, nil if a group hasn't
+// committed offsets for it.
+type topRow struct {
+	Topic      string
+	Partitions int
+	Rate       *float64 // messages per second since the previous poll
+	Skew       *float64 // the busiest partition's messages since the previous poll relative to an even split
+	Lags       map[string]*int64
+	Largest    int // bytes of key and value of the largest message seen
+}
+
+// newTopRow compares the polls of topic to tell its produce rate and skew.
+// Partitions whose high water mark went back, e.g. as the topic was
+// recreated, count as no messages.
+func newTopRow(topic string, prev, cur topPoll) topRow {
+	row := topRow{Topic: topic, Partitions: len(cur.hwms), Lags: map[string]*int64{}}
+	if prev.hwms == nil {
+		return row
+	}
+
+	var total, busiest int64
+	for p, hwm := range cur.hwms {
+		before, ok := prev.hwms[p]
+		if !ok || hwm < before {
+			continue
+		}
+		n := hwm - before
+		total += n
+		if n > busiest {
+			busiest = n
+		}
+	}
+
+	if elapsed := cur.time.Sub(prev.time).Seconds(); elapsed > 0 {
+		rate := float64(total) / elapsed
+		row.Rate = &rate
+	}
+	if total > 0 {
+		skew := float64(busiest) * float64(len(cur.hwms)) / float64(total)
+		row.Skew = &skew
+	}
+	return row
+}
+
+// topLargest tracks the size of the largest message seen per topic.
+type topLargest struct {
+	sync.Mutex
+	sizes map[string]int
+}
+
+func (l *topLargest) observe(msg *sarama.ConsumerMessage) {
+	l.Lock()
+	defer l.Unlock()
+	if size := len(msg.Key) + len(msg.Value); size > l.sizes[msg.Topic] {
+		l.sizes[msg.Topic] = size
+	}
+}
+
+func (l *topLargest) of(topic string) int {
+	l.Lock()
+	defer l.Unlock()
+	return l.sizes[topic]
+}
+
+// formatTopTable renders the rows with a lag column per group.
+func formatTopTable(rows []topRow, groups []string, largest bool) string {
+	var buf bytes.Buffer
+
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprint(w, "TOPIC\tPARTITIONS\tMSG/S\tSKEW")
+	for _, g := range groups {
+		fmt.Fprintf(w, "\tLAG %v", g)
+	}
+	if largest {
+		fmt.Fprint(w, "\tLARGEST")
+	}
+	fmt.Fprintln(w, "\t")
+
+	for _, r := range rows {
+		rate, skew := "-", "-"
+		if r.Rate != nil {
+			rate = fmt.Sprintf("%.1f", *r.Rate)
+		}
+		if r.Skew != nil {
+			skew = fmt.Sprintf("%.2f", *r.Skew)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v", r.Topic, r.Partitions, rate, skew)
+		for _, g := range groups {
+			lag := "-"
+			if l := r.Lags[g]; l != nil {
+				lag = fmt.Sprint(*l)
+			}
+			fmt.Fprintf(w, "\t%v", lag)
+		}
+		if largest {
+			fmt.Fprintf(w, "\t%v", r.Largest)
+		}
+		fmt.Fprintln(w, "\t")
+	}
+	w.Flush()
+
+	return buf.String()
+}
+
+func (cmd *topCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+	cmd.metadata = newMetadataCache(cmd.client.RefreshMetadata, cmd.maxAge)
+
+	if cmd.topicRegex != nil {
+		all, err := cmd.client.Topics()
+		if err != nil {
+			failf("failed to read topics err=%v", err)
+		}
+		if cmd.topics = matchTopics(cmd.topicRegex, all); len(cmd.topics) == 0 {
+			failf("found no topics matching %v", cmd.topicRegex)
+		}
+	}
+
+	largest := &topLargest{sizes: map[string]int{}}
+	if cmd.largest {
+		consumer, err := sarama.NewConsumerFromClient(cmd.client)
+		if err != nil {
+			failf("failed to create consumer err=%v", err)
+		}
+		defer logClose("consumer", consumer)
+		cmd.watchLargest(consumer, largest)
+	}
+
+	q := make(chan struct{})
+	go listenForInterrupt(q)
+
+	polls := map[string]topPoll{}
+	for i := 0; cmd.count == 0 || i < cmd.count; i++ {
+		if i > 0 {
+			select {
+			case <-q:
+				return
+			case <-time.After(cmd.interval):
+			}
+		}
+
+		rows := []topRow{}
+		for _, t := range cmd.topics {
+			cur, err := cmd.poll(t)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read high water marks of topic %v err=%v\n", t, err)
+				cmd.metadata.invalidate()
+				continue
+			}
+			row := newTopRow(t, polls[t], cur)
+			polls[t] = cur
+			for _, g := range cmd.groups {
+				if row.Lags[g], err = topicLag(cmd.client, g, t, cur.hwms); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to read lag of group %v on topic %v err=%v\n", g, t, err)
+				}
+			}
+			row.Largest = largest.of(t)
+			rows = append(rows, row)
+		}
+		cmd.printTable(rows)
+	}
+}
+
+// poll reads the high water marks of the partitions of topic.
+func (cmd *topCmd) poll(topic string) (topPoll, error) {
+	if err := cmd.metadata.refresh(cmd.topics...); err != nil {
+		return topPoll{}, err
+	}
+	partitions, err := cmd.client.Partitions(topic)
+	if err != nil {
+		return topPoll{}, err
+	}
+
+	result := topPoll{time: time.Now(), hwms: map[int32]int64{}}
+	for _, p := range partitions {
+		if result.hwms[p], err = cmd.client.GetOffset(topic, p, sarama.OffsetNewest); err != nil {
+			return topPoll{}, fmt.Errorf("failed to read high water mark of partition %v err=%v", p, err)
+		}
+	}
+	return result, nil
+}
+
+// topicLag sums the lag of group on the partitions of topic, it's nil if
+// the group hasn't committed offsets for any of them.
+func topicLag(client sarama.Client, group, topic string, hwms map[int32]int64) (*int64, error) {
+	partitions := []int32{}
+	for p := range hwms {
+		partitions = append(partitions, p)
+	}
+	committed, err := fetchCommittedOffsets(client, group, topic, partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	var total *int64
+	for _, p := range partitions {
+		l := newPartitionLag(time.Time{}, group, topic, p, hwms[p], committed[p])
+		if l.Lag == nil {
+			continue
+		}
+		if total == nil {
+			total = new(int64)
+		}
+		*total += *l.Lag
+	}
+	return total, nil
+}
+
+// watchLargest consumes the partitions of the topics from their newest
+// offsets to track the largest message. Partitions that fail to consume
+// are reported and skipped.
+func (cmd *topCmd) watchLargest(consumer sarama.Consumer, largest *topLargest) {
+	for _, t := range cmd.topics {
+		partitions, err := consumer.Partitions(t)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read partitions of topic %v err=%v\n", t, err)
+			continue
+		}
+		for _, p := range partitions {
+			pc, err := consumer.ConsumePartition(t, p, sarama.OffsetNewest)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to consume partition %v of topic %v err=%v\n", p, t, err)
+				continue
+			}
+			go func() {
+				for msg := range pc.Messages() {
+					largest.observe(msg)
+				}
+			}()
+		}
+	}
+}
+
+// printTable prints the rows as a table. On a terminal it replaces the
+// previous poll's table, otherwise tables are separated by an empty line.
+func (cmd *topCmd) printTable(rows []topRow) {
+	header := fmt.Sprintf("kt top at %v every %v\n\n", time.Now().Format(time.RFC3339), cmd.interval)
+	if terminal.IsTerminal(int(syscall.Stdout)) {
+		fmt.Fprint(stdout, "\033[H\033[2J"+header+formatTopTable(rows, cmd.groups, cmd.largest))
+	} else {
+		fmt.Fprint(stdout, header+formatTopTable(rows, cmd.groups, cmd.largest)+"\n")
+	}
+	stdout.Flush()
+}
+
+func (cmd *topCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("top")
+}
+
+func (cmd *topCmd) failStartup(msg string) {
+	failUsage(msg, "kt top")
+}
+
+func (cmd *topCmd) parseArgs(as []string) {
+	var err error
+
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if args.topicRegex {
+		if cmd.topicRegex, err = regexp.Compile("^(?:" + args.topic + ")$"); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid topic regex %#v err=%v", args.topic, err))
+		}
+	} else {
+		cmd.topics = splitList(args.topic)
+		sort.Strings(cmd.topics)
+	}
+	cmd.groups = splitList(args.groups)
+
+	if args.interval <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid interval %v, expected a positive duration.", args.interval))
+	}
+	if args.count < 0 {
+		cmd.failStartup(fmt.Sprintf("invalid count %v, expected 0 to watch until interrupted or a positive number of polls.", args.count))
+	}
+	if args.maxAge < 0 {
+		cmd.failStartup("-metadata-max-age cannot be negative.")
+	}
+
+	cmd.interval = args.interval
+	cmd.count = args.count
+	cmd.largest = args.largest
+	cmd.maxAge = args.maxAge
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+}
+
+func (cmd *topCmd) parseFlags(as []string) topArgs {
+	var args topArgs
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Comma separated topics to show (required).")
+	flags.BoolVar(&args.topicRegex, "topic-regex", false, "Interpret -topic as a regular expression and show all matching topics.")
+	flags.StringVar(&args.groups, "group", "", "Comma separated consumer groups to show the lag of per topic (defaults to none).")
+	flags.DurationVar(&args.interval, "interval", 5*time.Second, "Time to wait between polls.")
+	flags.IntVar(&args.count, "count", 0, "Number of polls before exiting (defaults to 0 to watch until interrupted).")
+	flags.BoolVar(&args.largest, "largest", true, "Consume the topics from their newest offsets to show the largest message seen, disable to only read offsets.")
+	flags.DurationVar(&args.maxAge, "metadata-max-age", 0, "Reuse the topics' metadata for up to the given time between polls (defaults to 0 to refresh it every poll).")
+	args.clientArgs.addFlags(flags)
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of top:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, topDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	return args
+}
+
+var topDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+top shows a table of the topics that it refreshes every -interval, in place
+on a terminal:
+
+  $ kt top -topic orders,payments -group billing,audit
+  kt top at 2026-10-15T10:00:05Z every 5s
+
+  TOPIC     PARTITIONS  MSG/S  SKEW  LAG billing  LAG audit  LARGEST
+  orders    12          412.6  1.08  1204         0          18422
+  payments  6           37.2   3.91  -            12         912
+
+ - MSG/S is the number of messages produced per second since the previous
+   poll, from the high water marks of the topic's partitions.
+ - SKEW is the number of messages the busiest partition received since the
+   previous poll relative to an even split, 1.00 for evenly spread messages
+   and the partition count if all went to one partition.
+ - LAG <group> is the lag of each -group summed over the partitions, like kt
+   lag, - if the group hasn't committed offsets for the topic.
+ - LARGEST is the size in bytes of key and value of the largest message
+   produced since kt top started. To track it, kt top consumes the topics
+   from their newest offsets, -largest=false only reads offsets instead.
+
+The first poll has no rate and skew yet. As with kt lag, -metadata-max-age
+spares busy clusters from refreshing the metadata every poll.`
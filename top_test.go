@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTopRow(t *testing.T) {
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	first := topPoll{time: start, hwms: map[int32]int64{0: 100, 1: 100, 2: 50}}
+
+	row := newTopRow("orders", topPoll{}, first)
+	require.Equal(t, 3, row.Partitions)
+	require.Nil(t, row.Rate)
+	require.Nil(t, row.Skew)
+
+	second := topPoll{time: start.Add(5 * time.Second), hwms: map[int32]int64{0: 140, 1: 110, 2: 60}}
+	row = newTopRow("orders", first, second)
+	require.Equal(t, 12.0, *row.Rate)
+	require.Equal(t, 2.0, *row.Skew)
+
+	// recreated partitions and idle topics.
+	third := topPoll{time: start.Add(10 * time.Second), hwms: map[int32]int64{0: 0, 1: 110, 2: 60}}
+	row = newTopRow("orders", second, third)
+	require.Equal(t, 0.0, *row.Rate)
+	require.Nil(t, row.Skew)
+}
+
+func TestFormatTopTable(t *testing.T) {
+	rate, skew, lag := 412.55, 1.08, int64(1204)
+	rows := []topRow{
+		{Topic: "orders", Partitions: 12, Rate: &rate, Skew: &skew, Lags: map[string]*int64{"billing": &lag}, Largest: 18422},
+		{Topic: "payments", Partitions: 6, Lags: map[string]*int64{}},
+	}
+	require.Equal(t, ""+
+		"TOPIC     PARTITIONS  MSG/S  SKEW  LAG billing  LARGEST  \n"+
+		"orders    12          412.6  1.08  1204         18422    \n"+
+		"payments  6           -      -     -            0        \n",
+		formatTopTable(rows, []string{"billing"}, true))
+	require.Equal(t, ""+
+		"TOPIC     PARTITIONS  MSG/S  SKEW  \n"+
+		"orders    12          412.6  1.08  \n"+
+		"payments  6           -      -     \n",
+		formatTopTable(rows, nil, false))
+}
+
+func TestTopLargest(t *testing.T) {
+	l := &topLargest{sizes: map[string]int{}}
+	l.observe(&sarama.ConsumerMessage{Topic: "orders", Key: []byte("k"), Value: []byte("value")})
+	l.observe(&sarama.ConsumerMessage{Topic: "orders", Value: []byte("v")})
+	require.Equal(t, 6, l.of("orders"))
+	require.Equal(t, 0, l.of("payments"))
+}
@@ -18,7 +18,9 @@ type topicArgs struct {
 	tlsCA      string
 	tlsCert    string
 	tlsCertKey string
+	sasl       saslArgs
 	filter     string
+	where      string
 	partitions bool
 	leaders    bool
 	replicas   bool
@@ -32,7 +34,9 @@ type topicCmd struct {
 	tlsCA      string
 	tlsCert    string
 	tlsCertKey string
+	sasl       saslArgs
 	filter     *regexp.Regexp
+	where      *topicWhere
 	partitions bool
 	leaders    bool
 	replicas   bool
@@ -41,11 +45,16 @@ type topicCmd struct {
 	version    sarama.KafkaVersion
 
 	client sarama.Client
+	admin  sarama.ClusterAdmin
+	sizes  map[string]int64 // for the size field of -where
 }
 
+// topic is printed per topic. Where has the values of the fields that
+// -where refers to.
 type topic struct {
-	Name       string      `json:"name"`
-	Partitions []partition `json:"partitions,omitempty"`
+	Name       string                 `json:"name"`
+	Partitions []partition            `json:"partitions,omitempty"`
+	Where      map[string]interface{} `json:"where,omitempty"`
 }
 
 type partition struct {
@@ -67,10 +76,18 @@ func (cmd *topicCmd) parseFlags(as []string) topicArgs {
 	flags.StringVar(&args.tlsCA, "tlsca", "", "Path to the TLS certificate authority file")
 	flags.StringVar(&args.tlsCert, "tlscert", "", "Path to the TLS client certificate file")
 	flags.StringVar(&args.tlsCertKey, "tlscertkey", "", "Path to the TLS client certificate key file")
+	flags.StringVar(&args.sasl.mechanism, "sasl-mechanism", "", "SASL mechanism to authenticate with (PLAIN|SCRAM-SHA-256|SCRAM-SHA-512|GSSAPI), defaults to none.")
+	flags.StringVar(&args.sasl.user, "sasl-user", "", "SASL user name")
+	flags.StringVar(&args.sasl.password, "sasl-password", "", "SASL password")
+	flags.StringVar(&args.sasl.krb5Config, "krb5-config", "", "Path to the Kerberos config file for GSSAPI (defaults to $KRB5_CONFIG or /etc/krb5.conf).")
+	flags.StringVar(&args.sasl.keytab, "keytab", "", "Path to the Kerberos keytab file for GSSAPI, otherwise -sasl-password is used.")
+	flags.StringVar(&args.sasl.principal, "principal", "", "Kerberos principal to authenticate as for GSSAPI, e.g. kt@EXAMPLE.COM (defaults to -sasl-user).")
+	flags.StringVar(&args.sasl.realm, "realm", "", "Kerberos realm for GSSAPI (defaults to the realm of -principal).")
 	flags.BoolVar(&args.partitions, "partitions", false, "Include information per partition.")
 	flags.BoolVar(&args.leaders, "leaders", false, "Include leader information per partition.")
 	flags.BoolVar(&args.replicas, "replicas", false, "Include replica ids per partition.")
 	flags.StringVar(&args.filter, "filter", "", "Regex to filter topics by name.")
+	flags.StringVar(&args.where, "where", "", "Only list topics matching the given expression over their configs and partitions, replicas, messages and size, e.g. 'retention.ms > 604800000 && partitions > 32'.")
 	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
 	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
 	flags.StringVar(&args.version, "version", "", "Kafka protocol version")
@@ -82,11 +99,12 @@ func (cmd *topicCmd) parseFlags(as []string) topicArgs {
 
 	err := flags.Parse(as)
 	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
-		os.Exit(0)
+		exit(0)
 	} else if err != nil {
-		os.Exit(2)
+		exit(exitUsage)
 	}
 
+	jsonErrors = !args.pretty
 	return args
 }
 
@@ -115,10 +133,16 @@ func (cmd *topicCmd) parseArgs(as []string) {
 	if re, err = regexp.Compile(args.filter); err != nil {
 		failf("invalid regex for filter err=%s", err)
 	}
+	if args.where != "" {
+		if cmd.where, err = parseTopicWhere(args.where); err != nil {
+			failUsage(fmt.Sprintf("invalid where argument %#v err=%v", args.where, err), "kt topic")
+		}
+	}
 
 	cmd.tlsCA = args.tlsCA
 	cmd.tlsCert = args.tlsCert
 	cmd.tlsCertKey = args.tlsCertKey
+	cmd.sasl = readSASLEnv(args.sasl)
 	cmd.filter = re
 	cmd.partitions = args.partitions
 	cmd.leaders = args.leaders
@@ -145,7 +169,7 @@ func (cmd *topicCmd) connect() {
 		fmt.Fprintf(os.Stderr, "sarama client configuration %#v\n", cfg)
 	}
 
-	tlsConfig, err := setupCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
+	tlsConfig, err := setupBrokerCerts(cmd.tlsCert, cmd.tlsCA, cmd.tlsCertKey)
 	if err != nil {
 		failf("failed to setup certificates err=%v", err)
 	}
@@ -153,6 +177,9 @@ func (cmd *topicCmd) connect() {
 		cfg.Net.TLS.Enable = true
 		cfg.Net.TLS.Config = tlsConfig
 	}
+	if err = setupSASL(cfg, cmd.sasl); err != nil {
+		failf("failed to setup SASL err=%v", err)
+	}
 
 	if cmd.client, err = sarama.NewClient(cmd.brokers, cfg); err != nil {
 		failf("failed to create client err=%v", err)
@@ -166,6 +193,22 @@ func (cmd *topicCmd) run(as []string) {
 		out = make(chan printContext)
 	)
 
+	if len(as) > 0 && as[0] == "groups" {
+		(&groupTopicsCmd{name: "topic groups"}).run(as[1:])
+		return
+	}
+	if len(as) > 0 && as[0] == "clone" {
+		(&topicCloneCmd{}).run(as[1:])
+		return
+	}
+	if len(as) > 0 && as[0] == "peek" {
+		(&topicPeekCmd{}).run(as[1:])
+		return
+	}
+	if len(as) > 0 && as[0] == "list" {
+		as = as[1:]
+	}
+
 	cmd.parseArgs(as)
 	if cmd.verbose {
 		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
@@ -173,6 +216,16 @@ func (cmd *topicCmd) run(as []string) {
 
 	cmd.connect()
 	defer cmd.client.Close()
+	if cmd.where != nil {
+		if cmd.admin, err = sarama.NewClusterAdminFromClient(cmd.client); err != nil {
+			failf("failed to create cluster admin err=%v", err)
+		}
+		if cmd.where.uses("size") {
+			if err = cmd.readSizes(); err != nil {
+				failf("%v", err)
+			}
+		}
+	}
 
 	if all, err = cmd.client.Topics(); err != nil {
 		failf("failed to read topics err=%v", err)
@@ -204,10 +257,25 @@ func (cmd *topicCmd) print(name string, out chan printContext) {
 		err error
 	)
 
+	var where map[string]interface{}
+	if cmd.where != nil {
+		info, err := cmd.readWhereInfo(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read info for topic %s. err=%v\n", name, err)
+			return
+		}
+		env := info.env()
+		if !cmd.where.matches(env) {
+			return
+		}
+		where = cmd.where.values(env)
+	}
+
 	if top, err = cmd.readTopic(name); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read info for topic %s. err=%v\n", name, err)
 		return
 	}
+	top.Where = where
 
 	ctx := printContext{output: top, done: make(chan struct{})}
 	out <- ctx
@@ -266,4 +334,41 @@ func (cmd *topicCmd) readTopic(name string) (topic, error) {
 
 var topicDocString = `
 The values for -brokers can also be set via the environment variable KT_BROKERS respectively.
-The values supplied on the command line win over environment variable values.`
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+To list the consumer groups of a topic:
+
+kt topic groups -topic fav-topic
+
+See "kt topic groups -help" for details.
+
+To create a copy of a topic with the same partitions and configs, optionally
+including its messages:
+
+kt topic clone -from fav-topic -to fav-topic-copy -with-data
+
+See "kt topic clone -help" for details.
+
+To print the first and last record of every partition:
+
+kt topic peek -topic fav-topic
+
+See "kt topic peek -help" for details.
+
+To find topics by their configs, partitions or size, pass an expression to
+-where that is evaluated for every topic, with the operators of kt consume
+-filter:
+
+kt topic list -where 'retention.ms > 604800000 && partitions > 32'
+kt topic list -where 'cleanup.policy != "compact" && name =~ "-changelog$"'
+
+Config names like retention.ms refer to the topic's effective config,
+including broker defaults, and numeric values compare as numbers, e.g.
+retention.ms is -1 for unlimited retention. partitions is the partition
+count, replicas the replication factor, messages the number of messages
+between the oldest and newest offsets and size the bytes of one replica of
+each partition summed up, which requires brokers of version 1.0 or later.
+Matching topics are printed with the values of the fields that -where refers
+to. "kt topic list" is the same as "kt topic".`
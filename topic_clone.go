@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type topicCloneCmd struct {
+	clientConfig
+
+	from              string
+	to                string
+	withData          bool
+	replicationFactor int
+	timeout           time.Duration
+	verbose           bool
+	pretty            bool
+
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+type topicCloneArgs struct {
+	clientArgs
+
+	from              string
+	to                string
+	withData          bool
+	replicationFactor int
+	timeout           time.Duration
+	verbose           bool
+	pretty            bool
+}
+
+// clonedTopic describes the topic created by kt topic clone, Configs are the
+// configs that were set on the source topic.
+type clonedTopic struct {
+	Topic             string            `json:"topic"`
+	From              string            `json:"from"`
+	Partitions        int32             `json:"partitions"`
+	ReplicationFactor int16             `json:"replicationFactor"`
+	Configs           map[string]string `json:"configs"`
+}
+
+func (cmd *topicCloneCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.admin, err = sarama.NewClusterAdminFromClient(cmd.client); err != nil {
+		failf("failed to create cluster admin err=%v", err)
+	}
+
+	detail, err := cmd.describe()
+	if err != nil {
+		failf("failed to describe topic %v err=%v", cmd.from, err)
+	}
+	if err = cmd.admin.CreateTopic(cmd.to, detail, false); err != nil {
+		failf("failed to create topic %v err=%v", cmd.to, err)
+	}
+	if err = cmd.awaitPartitions(detail.NumPartitions); err != nil {
+		failf("failed to wait for topic %v err=%v", cmd.to, err)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	ctx := printContext{output: newClonedTopic(cmd.to, cmd.from, detail), done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	if !cmd.withData {
+		return
+	}
+
+	c := &copyCmd{
+		clientConfig:  cmd.clientConfig,
+		srcBrokers:    cmd.brokers,
+		dstBrokers:    cmd.brokers,
+		srcTopic:      cmd.from,
+		dstTopic:      cmd.to,
+		untilEnd:      true,
+		timeout:       cmd.timeout,
+		keepPartition: true,
+		verbose:       cmd.verbose,
+		pretty:        cmd.pretty,
+	}
+	if c.offsets, err = parseOffsets(""); err != nil {
+		failf("%v", err)
+	}
+	c.copyTopic(func() {})
+}
+
+// describe reads the partition count, replication factor and the configs
+// set on the source topic. Defaults of the broker aren't copied, so the
+// clone follows changes to them like the source does.
+func (cmd *topicCloneCmd) describe() (*sarama.TopicDetail, error) {
+	partitions, err := cmd.client.Partitions(cmd.from)
+	if err != nil {
+		return nil, err
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("found no partitions")
+	}
+
+	rf := int16(cmd.replicationFactor)
+	if rf <= 0 {
+		replicas, err := cmd.client.Replicas(cmd.from, partitions[0])
+		if err != nil {
+			return nil, err
+		}
+		rf = int16(len(replicas))
+	}
+
+	entries, err := cmd.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: cmd.from})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sarama.TopicDetail{
+		NumPartitions:     int32(len(partitions)),
+		ReplicationFactor: rf,
+		ConfigEntries:     topicConfigOverrides(entries),
+	}, nil
+}
+
+// topicConfigOverrides returns the configs set on a topic. Brokers before
+// 1.1 don't report the source of configs, only whether they're defaults.
+func topicConfigOverrides(entries []sarama.ConfigEntry) map[string]*string {
+	configs := map[string]*string{}
+	for _, e := range entries {
+		if e.ReadOnly || e.Sensitive {
+			continue
+		}
+		if e.Source == sarama.SourceTopic || (e.Source == sarama.SourceUnknown && !e.Default) {
+			v := e.Value
+			configs[e.Name] = &v
+		}
+	}
+	return configs
+}
+
+// awaitPartitions waits until the client's metadata has the partitions of
+// the new topic, so that copying doesn't fail on unknown partitions.
+func (cmd *topicCloneCmd) awaitPartitions(n int32) error {
+	var err error
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(250 * time.Millisecond) {
+		var ps []int32
+		if err = cmd.client.RefreshMetadata(cmd.to); err != nil {
+			continue
+		}
+		if ps, err = cmd.client.Partitions(cmd.to); err == nil && int32(len(ps)) == n {
+			return nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("timed out waiting for %v partitions", n)
+	}
+	return err
+}
+
+func newClonedTopic(to, from string, detail *sarama.TopicDetail) clonedTopic {
+	configs := map[string]string{}
+	for k, v := range detail.ConfigEntries {
+		configs[k] = *v
+	}
+	return clonedTopic{Topic: to, From: from, Partitions: detail.NumPartitions, ReplicationFactor: detail.ReplicationFactor, Configs: configs}
+}
+
+func (cmd *topicCloneCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("topic")
+}
+
+func (cmd *topicCloneCmd) failStartup(msg string) {
+	failUsage(msg, "kt topic clone")
+}
+
+func (cmd *topicCloneCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.from == "" || args.to == "" {
+		cmd.failStartup("-from and -to are required.")
+	}
+	if args.from == args.to {
+		cmd.failStartup("-from and -to have to be different topics.")
+	}
+
+	cmd.from = args.from
+	cmd.to = args.to
+	cmd.withData = args.withData
+	cmd.replicationFactor = args.replicationFactor
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	if cmd.withData && !cmd.version.IsAtLeast(sarama.V0_11_0_0) {
+		cmd.failStartup("-with-data requires -version 0.11.0.0 or later to preserve headers.")
+	}
+}
+
+func (cmd *topicCloneCmd) parseFlags(as []string) topicCloneArgs {
+	var args topicCloneArgs
+	flags := flag.NewFlagSet("topic clone", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.from, "from", "", "Topic to clone (required).")
+	flags.StringVar(&args.to, "to", "", "Name of the topic to create (required).")
+	flags.BoolVar(&args.withData, "with-data", false, "Copy the messages of -from into the new topic.")
+	flags.IntVar(&args.replicationFactor, "replication-factor", 0, "Replication factor of the new topic (defaults to the one of -from).")
+	flags.DurationVar(&args.timeout, "timeout", time.Duration(0), "Timeout after not reading messages for -with-data (default 0 to disable).")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of topic clone:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, topicCloneDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var topicCloneDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt topic clone" creates -to with the partition count, replication factor and
+configs set on -from, e.g. retention and cleanup policy, and prints them.
+Settings inherited from the brokers aren't copied. With -with-data, it then
+copies the messages of -from as of now into the partition of the same
+number like "kt copy -keep-partition -until-end", preserving keys, values,
+headers and timestamps. Offsets differ where -from was compacted or has
+transaction markers.
+
+  $ kt topic clone -from orders -to orders-experiment -with-data`
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicConfigOverrides(t *testing.T) {
+	entries := []sarama.ConfigEntry{
+		{Name: "retention.ms", Value: "1000", Source: sarama.SourceTopic},
+		{Name: "cleanup.policy", Value: "delete", Source: sarama.SourceDefault, Default: true},
+		{Name: "min.insync.replicas", Value: "2", Source: sarama.SourceStaticBroker},
+		{Name: "segment.bytes", Value: "1024", Source: sarama.SourceUnknown},
+		{Name: "flush.ms", Value: "10", Source: sarama.SourceUnknown, Default: true},
+		{Name: "secret", Value: "", Source: sarama.SourceTopic, Sensitive: true},
+	}
+
+	actual := newClonedTopic("b", "a", &sarama.TopicDetail{NumPartitions: 3, ReplicationFactor: 2, ConfigEntries: topicConfigOverrides(entries)})
+	require.Equal(t, clonedTopic{
+		Topic:             "b",
+		From:              "a",
+		Partitions:        3,
+		ReplicationFactor: 2,
+		Configs:           map[string]string{"retention.ms": "1000", "segment.bytes": "1024"},
+	}, actual)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// peekLookback is how many offsets before the newest offset the last record
+// is searched in, as the newest offsets can be transaction markers rather
+// than records.
+const peekLookback = 10
+
+type topicPeekCmd struct {
+	clientConfig
+
+	topic       string
+	encodeKey   string
+	encodeValue string
+	timeout     time.Duration
+	verbose     bool
+	pretty      bool
+
+	client   sarama.Client
+	consumer sarama.Consumer
+	decoder  *consumeCmd // decodes keys and values like kt consume
+}
+
+type topicPeekArgs struct {
+	clientArgs
+
+	topic       string
+	encodeKey   string
+	encodeValue string
+	registry    registryArgs
+	timeout     time.Duration
+	verbose     bool
+	pretty      bool
+}
+
+// partitionPeek shows the first and last record of a partition, Span is the
+// time between their timestamps.
+type partitionPeek struct {
+	Partition int32            `json:"partition"`
+	Oldest    int64            `json:"oldest"`
+	Newest    int64            `json:"newest"`
+	First     *consumedMessage `json:"first,omitempty"`
+	Last      *consumedMessage `json:"last,omitempty"`
+	Span      string           `json:"span,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+func (cmd *topicPeekCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	cmd.decoder.setupAvro()
+	cmd.decoder.setupCodecs()
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.topic, err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		peeks = []partitionPeek{}
+	)
+	wg.Add(len(partitions))
+	for _, p := range partitions {
+		go func(p int32) {
+			defer wg.Done()
+			pp := cmd.peek(p)
+			mu.Lock()
+			peeks = append(peeks, pp)
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+	sort.Slice(peeks, func(i, j int) bool { return peeks[i].Partition < peeks[j].Partition })
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+	for _, pp := range peeks {
+		ctx := printContext{output: pp, done: make(chan struct{})}
+		out <- ctx
+		<-ctx.done
+	}
+}
+
+func (cmd *topicPeekCmd) peek(partition int32) partitionPeek {
+	var (
+		result = partitionPeek{Partition: partition}
+		err    error
+	)
+
+	if result.Oldest, err = cmd.client.GetOffset(cmd.topic, partition, sarama.OffsetOldest); err != nil {
+		result.Error = fmt.Sprintf("failed to read oldest offset: %v", err)
+		return result
+	}
+	if result.Newest, err = cmd.client.GetOffset(cmd.topic, partition, sarama.OffsetNewest); err != nil {
+		result.Error = fmt.Sprintf("failed to read newest offset: %v", err)
+		return result
+	}
+	if result.Newest <= result.Oldest {
+		return result
+	}
+
+	first, err := cmd.fetch(partition, result.Oldest, result.Oldest)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read first record: %v", err)
+		return result
+	}
+	last, err := cmd.fetch(partition, lastRecordStart(result.Oldest, result.Newest), result.Newest-1)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read last record: %v", err)
+		return result
+	}
+
+	if first != nil {
+		m := cmd.decoder.newMessage(first)
+		result.First = &m
+	}
+	if last != nil {
+		m := cmd.decoder.newMessage(last)
+		result.Last = &m
+	}
+	if first != nil && last != nil && !first.Timestamp.IsZero() && !last.Timestamp.IsZero() {
+		result.Span = last.Timestamp.Sub(first.Timestamp).String()
+	}
+	return result
+}
+
+// lastRecordStart is the offset to search the last record of a partition
+// from, at most peekLookback offsets before newest.
+func lastRecordStart(oldest, newest int64) int64 {
+	if newest-peekLookback < oldest {
+		return oldest
+	}
+	return newest - peekLookback
+}
+
+// fetch consumes from start and returns the latest record up to end. It
+// stops early at -timeout, e.g. as the end offset is a transaction marker.
+func (cmd *topicPeekCmd) fetch(partition int32, start, end int64) (*sarama.ConsumerMessage, error) {
+	pc, err := cmd.consumer.ConsumePartition(cmd.topic, partition, start)
+	if err != nil {
+		return nil, err
+	}
+	defer logClose(fmt.Sprintf("partition consumer %v", partition), pc)
+
+	var (
+		latest  *sarama.ConsumerMessage
+		timeout = time.After(cmd.timeout)
+	)
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset > end {
+				return latest, nil
+			}
+			latest = msg
+			if msg.Offset == end {
+				return latest, nil
+			}
+		case err := <-pc.Errors():
+			return latest, err
+		case <-timeout:
+			return latest, nil
+		}
+	}
+}
+
+func (cmd *topicPeekCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("topic")
+}
+
+func (cmd *topicPeekCmd) failStartup(msg string) {
+	failUsage(msg, "kt topic peek")
+}
+
+func (cmd *topicPeekCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	for _, enc := range []string{args.encodeKey, args.encodeValue} {
+		if err := checkEncoding(enc, "avro"); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid encoding %#v err=%v, avro is supported too.", enc, err))
+		}
+	}
+	args.registry = readRegistryEnv(args.registry)
+	if (args.encodeKey == "avro" || args.encodeValue == "avro") && args.registry.url == "" {
+		cmd.failStartup("avro encoding requires -schema-registry.")
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.encodeKey = args.encodeKey
+	cmd.encodeValue = args.encodeValue
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+	cmd.decoder = &consumeCmd{
+		topic:         cmd.topic,
+		encodeKey:     cmd.encodeKey,
+		encodeValue:   cmd.encodeValue,
+		encodeHeaders: headerEncodings{fallback: "string"},
+		registry:      args.registry,
+	}
+}
+
+func (cmd *topicPeekCmd) parseFlags(as []string) topicPeekArgs {
+	var args topicPeekArgs
+	flags := flag.NewFlagSet("topic peek", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Topic to peek into (required).")
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.encodeValue, "encodevalue", "string", "Present message value as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.encodeKey, "encodekey", "string", "Present message key as (string|hex|base64|json|gzip|zstd|avro) or a chain like gzip+json, defaults to string.")
+	flags.StringVar(&args.registry.url, "schema-registry", "", "URL of the schema registry for avro encoding.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Time to wait for the records of a partition.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of topic peek:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, topicPeekDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var topicPeekDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+The value for -schema-registry can be set via KT_SCHEMA_REGISTRY.
+
+"kt topic peek" prints the first and last record of every partition, encoded
+like "kt consume" prints them, together with the partition's oldest and
+newest offset and the time between the two records' timestamps as "span".
+It gives a quick sense of a topic's data and age without offset expressions.
+Empty partitions have neither record. If the newest offsets are transaction
+markers, the last record is searched for among the ten offsets before the
+newest, waiting at most -timeout.
+
+  $ kt topic peek -topic orders -encodevalue avro`
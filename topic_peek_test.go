@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastRecordStart(t *testing.T) {
+	require.Equal(t, int64(0), lastRecordStart(0, 1))
+	require.Equal(t, int64(3), lastRecordStart(3, 8))
+	require.Equal(t, int64(90), lastRecordStart(0, 100))
+	require.Equal(t, int64(95), lastRecordStart(95, 100))
+}
+
+func TestTopicPeekParseFlags(t *testing.T) {
+	args := (&topicPeekCmd{}).parseFlags([]string{"-topic", "orders", "-encodevalue", "hex"})
+	require.Equal(t, "orders", args.topic)
+	require.Equal(t, "string", args.encodeKey)
+	require.Equal(t, "hex", args.encodeValue)
+	require.Equal(t, "5s", args.timeout.String())
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+)
+
+// topicWhereFields are the fields of -where that aren't configs: the
+// partition count, the replication factor, the number of messages between
+// the oldest and newest offsets and the bytes of a replica of each
+// partition, summed up.
+var topicWhereFields = map[string]bool{
+	"name":       true,
+	"partitions": true,
+	"replicas":   true,
+	"messages":   true,
+	"size":       true,
+}
+
+// topicWhere is a parsed -where expression over the fields of a topic,
+// fields lists those it refers to so that only the necessary information
+// is read.
+type topicWhere struct {
+	expr   filterExpr
+	fields []string
+}
+
+// topicField is a field of the flat topicWhereEnv, names that aren't
+// topicWhereFields are config names like retention.ms.
+type topicField string
+
+func (f topicField) eval(env map[string]interface{}) interface{} { return env[string(f)] }
+
+func parseTopicWhere(str string) (*topicWhere, error) {
+	seen := map[string]bool{}
+	w := &topicWhere{}
+	expr, err := parseExpr(str, func(name string, pos int) (filterExpr, error) {
+		if !seen[name] {
+			seen[name] = true
+			w.fields = append(w.fields, name)
+		}
+		return topicField(name), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(w.fields)
+	w.expr = expr
+	return w, nil
+}
+
+// uses returns whether the expression refers to field, or to configs for
+// "configs".
+func (w *topicWhere) uses(field string) bool {
+	for _, f := range w.fields {
+		if f == field || (field == "configs" && !topicWhereFields[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *topicWhere) matches(env map[string]interface{}) bool {
+	return truthy(w.expr.eval(env))
+}
+
+// values returns the fields the expression refers to, to print them with
+// matching topics.
+func (w *topicWhere) values(env map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for _, f := range w.fields {
+		result[f] = env[f]
+	}
+	return result
+}
+
+// topicWhereInfo is what's known about a topic for -where, messages and
+// size are nil if they weren't read.
+type topicWhereInfo struct {
+	name       string
+	partitions int
+	replicas   int
+	messages   *int64
+	size       *int64
+	configs    map[string]string
+}
+
+// env exposes the topic to -where expressions. Config values that are
+// numbers compare as numbers, e.g. retention.ms > 604800000.
+func (t topicWhereInfo) env() map[string]interface{} {
+	env := map[string]interface{}{}
+	for k, v := range t.configs {
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			env[k] = n
+		} else {
+			env[k] = v
+		}
+	}
+	env["name"] = t.name
+	env["partitions"] = float64(t.partitions)
+	env["replicas"] = float64(t.replicas)
+	if t.messages != nil {
+		env["messages"] = float64(*t.messages)
+	}
+	if t.size != nil {
+		env["size"] = float64(*t.size)
+	}
+	return env
+}
+
+// replicaSizes sums the bytes of the largest replica of each partition per
+// topic across the log dirs of all brokers.
+func replicaSizes(dirs map[int32][]sarama.DescribeLogDirsResponseDirMetadata) map[string]int64 {
+	largest := map[topicPartition]int64{}
+	for _, ds := range dirs {
+		for _, d := range ds {
+			for _, t := range d.Topics {
+				for _, p := range t.Partitions {
+					tp := topicPartition{t.Topic, p.PartitionID}
+					if p.Size > largest[tp] {
+						largest[tp] = p.Size
+					}
+				}
+			}
+		}
+	}
+
+	result := map[string]int64{}
+	for tp, size := range largest {
+		result[tp.topic] += size
+	}
+	return result
+}
+
+// readWhereInfo reads what -where refers to of topic name.
+func (cmd *topicCmd) readWhereInfo(name string) (topicWhereInfo, error) {
+	info := topicWhereInfo{name: name}
+	partitions, err := cmd.client.Partitions(name)
+	if err != nil {
+		return info, err
+	}
+	info.partitions = len(partitions)
+	if len(partitions) > 0 {
+		replicas, err := cmd.client.Replicas(name, partitions[0])
+		if err != nil {
+			return info, err
+		}
+		info.replicas = len(replicas)
+	}
+
+	if cmd.where.uses("messages") {
+		var total int64
+		for _, p := range partitions {
+			oldest, err := cmd.client.GetOffset(name, p, sarama.OffsetOldest)
+			if err != nil {
+				return info, err
+			}
+			newest, err := cmd.client.GetOffset(name, p, sarama.OffsetNewest)
+			if err != nil {
+				return info, err
+			}
+			total += newest - oldest
+		}
+		info.messages = &total
+	}
+
+	if cmd.sizes != nil {
+		size := cmd.sizes[name]
+		info.size = &size
+	}
+
+	if cmd.where.uses("configs") {
+		entries, err := cmd.admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: name})
+		if err != nil {
+			return info, fmt.Errorf("failed to describe configs err=%v", err)
+		}
+		info.configs = map[string]string{}
+		for _, e := range entries {
+			info.configs[e.Name] = e.Value
+		}
+	}
+	return info, nil
+}
+
+// readSizes reads the log dirs of all brokers for the size field of -where.
+func (cmd *topicCmd) readSizes() error {
+	ids := []int32{}
+	for _, b := range cmd.client.Brokers() {
+		ids = append(ids, b.ID())
+	}
+	dirs, err := cmd.admin.DescribeLogDirs(ids)
+	if err != nil {
+		return fmt.Errorf("failed to describe log dirs, brokers before 1.0 don't support this, err=%v", err)
+	}
+	cmd.sizes = replicaSizes(dirs)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicWhere(t *testing.T) {
+	w, err := parseTopicWhere(`retention.ms > 604800000 && partitions > 32 || cleanup.policy == "compact" && name =~ "-changelog$"`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"cleanup.policy", "name", "partitions", "retention.ms"}, w.fields)
+	require.True(t, w.uses("configs"))
+	require.True(t, w.uses("partitions"))
+	require.False(t, w.uses("size"))
+
+	large := topicWhereInfo{name: "orders", partitions: 64, replicas: 3, configs: map[string]string{"retention.ms": "1209600000", "cleanup.policy": "delete"}}
+	require.True(t, w.matches(large.env()))
+	require.Equal(t, map[string]interface{}{"cleanup.policy": "delete", "name": "orders", "partitions": float64(64), "retention.ms": float64(1209600000)}, w.values(large.env()))
+
+	unlimited := large
+	unlimited.configs = map[string]string{"retention.ms": "-1", "cleanup.policy": "delete"}
+	require.False(t, w.matches(unlimited.env()))
+
+	changelog := topicWhereInfo{name: "app-counts-changelog", partitions: 6, configs: map[string]string{"retention.ms": "-1", "cleanup.policy": "compact"}}
+	require.True(t, w.matches(changelog.env()))
+
+	w, err = parseTopicWhere("size > 1000 && messages == 0")
+	require.NoError(t, err)
+	require.False(t, w.uses("configs"))
+	size, messages := int64(2048), int64(0)
+	require.True(t, w.matches(topicWhereInfo{size: &size, messages: &messages}.env()))
+
+	_, err = parseTopicWhere("partitions >")
+	require.Error(t, err)
+}
+
+func TestReplicaSizes(t *testing.T) {
+	dir := func(topic string, sizes ...int64) sarama.DescribeLogDirsResponseDirMetadata {
+		t := sarama.DescribeLogDirsResponseTopic{Topic: topic}
+		for i, s := range sizes {
+			t.Partitions = append(t.Partitions, sarama.DescribeLogDirsResponsePartition{PartitionID: int32(i), Size: s})
+		}
+		return sarama.DescribeLogDirsResponseDirMetadata{Topics: []sarama.DescribeLogDirsResponseTopic{t}}
+	}
+	sizes := replicaSizes(map[int32][]sarama.DescribeLogDirsResponseDirMetadata{
+		1: {dir("orders", 100, 200), dir("payments", 5)},
+		2: {dir("orders", 110, 190)},
+	})
+	require.Equal(t, map[string]int64{"orders": 310, "payments": 5}, sizes)
+}
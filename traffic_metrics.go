@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// trafficMetrics counts what consume and copy read and write for
+// -metricsaddr, e.g. to watch a kt copy that runs as a mirror. Like
+// consumeStats, a nil *trafficMetrics is valid and doesn't count anything.
+type trafficMetrics struct {
+	registry *metricsRegistry
+}
+
+// newTrafficMetrics serves the metrics on addr, with those of the produced
+// messages if copying. It returns nil if addr is empty.
+func newTrafficMetrics(addr string, copying bool) *trafficMetrics {
+	if addr == "" {
+		return nil
+	}
+	r := newMetricsRegistry()
+	r.register("kt_consume_messages_total", counterMetric, "Number of messages consumed.")
+	r.register("kt_consume_bytes_total", counterMetric, "Number of bytes of keys and values consumed.")
+	r.register("kt_consume_lag", gaugeMetric, "Number of messages after the last consumed one as of its fetch.")
+	r.register("kt_decode_errors_total", counterMetric, "Number of keys and values that failed to decode and were printed as base64.")
+	r.register("kt_commit_failures_total", counterMetric, "Number of offsets that failed to commit for -group.")
+	r.register("kt_broker_throttled_responses_total", counterMetric, "Number of fetch responses for which the broker applied quota throttling.")
+	r.register("kt_broker_throttle_seconds_total", counterMetric, "Total time the broker reported throttling fetch requests.")
+	if copying {
+		r.register("kt_produce_messages_total", counterMetric, "Number of messages acknowledged by the brokers.")
+		r.register("kt_produce_bytes_total", counterMetric, "Number of bytes of keys and values acknowledged by the brokers.")
+		r.register("kt_produce_errors_total", counterMetric, "Number of messages that failed to produce.")
+		r.register("kt_copy_skipped_total", counterMetric, "Number of messages skipped due to -skip-headers.")
+	}
+	serveMetrics(addr, r)
+	return &trafficMetrics{registry: r}
+}
+
+func partitionLabels(topic string, partition int32) map[string]string {
+	return map[string]string{"topic": topic, "partition": fmt.Sprint(partition)}
+}
+
+// consumed counts msg, hwm is the high water mark of its partition.
+func (m *trafficMetrics) consumed(msg *sarama.ConsumerMessage, hwm int64) {
+	if m == nil {
+		return
+	}
+	labels := partitionLabels(msg.Topic, msg.Partition)
+	m.registry.add("kt_consume_messages_total", labels, 1)
+	m.registry.add("kt_consume_bytes_total", labels, float64(len(msg.Key)+len(msg.Value)))
+	if lag := hwm - msg.Offset - 1; hwm > 0 && lag >= 0 {
+		m.registry.set("kt_consume_lag", labels, float64(lag))
+	}
+}
+
+// decodeFailed counts a key or value of topic that failed to decode, field
+// is key or value.
+func (m *trafficMetrics) decodeFailed(topic, field string) {
+	if m == nil {
+		return
+	}
+	m.registry.add("kt_decode_errors_total", map[string]string{"topic": topic, "field": field}, 1)
+}
+
+func (m *trafficMetrics) commitFailed(group, topic string, partition int32) {
+	if m == nil {
+		return
+	}
+	labels := partitionLabels(topic, partition)
+	labels["group"] = group
+	m.registry.add("kt_commit_failures_total", labels, 1)
+}
+
+// throttled counts a fetch that broker throttled for throttle.
+func (m *trafficMetrics) throttled(broker string, throttle time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := map[string]string{"broker": broker}
+	m.registry.add("kt_broker_throttled_responses_total", labels, 1)
+	m.registry.add("kt_broker_throttle_seconds_total", labels, throttle.Seconds())
+}
+
+// produced counts a message copied to partition of topic with size bytes of
+// key and value.
+func (m *trafficMetrics) produced(topic string, partition int32, size int) {
+	if m == nil {
+		return
+	}
+	labels := partitionLabels(topic, partition)
+	m.registry.add("kt_produce_messages_total", labels, 1)
+	m.registry.add("kt_produce_bytes_total", labels, float64(size))
+}
+
+func (m *trafficMetrics) produceFailed(topic string) {
+	if m == nil {
+		return
+	}
+	m.registry.add("kt_produce_errors_total", map[string]string{"topic": topic}, 1)
+}
+
+func (m *trafficMetrics) skipped(topic string, partition int32) {
+	if m == nil {
+		return
+	}
+	m.registry.add("kt_copy_skipped_total", partitionLabels(topic, partition), 1)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrafficMetrics(t *testing.T) {
+	var disabled *trafficMetrics
+	disabled.consumed(&sarama.ConsumerMessage{}, 1)
+	disabled.produced("orders", 0, 1)
+	require.Nil(t, newTrafficMetrics("", true))
+
+	m := newTrafficMetrics("127.0.0.1:0", true)
+	m.consumed(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 5, Key: []byte("k"), Value: []byte("abc")}, 10)
+	m.consumed(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 6, Value: []byte("de")}, 10)
+	m.decodeFailed("orders", "value")
+	m.commitFailed("audit", "orders", 1)
+	m.produced("orders-copy", 0, 6)
+	m.produceFailed("orders-copy")
+	m.skipped("orders", 1)
+	m.throttled("localhost:9092", 1500*time.Millisecond)
+
+	var buf bytes.Buffer
+	m.registry.write(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`kt_consume_messages_total{partition="1",topic="orders"} 2`,
+		`kt_consume_bytes_total{partition="1",topic="orders"} 6`,
+		`kt_consume_lag{partition="1",topic="orders"} 3`,
+		`kt_decode_errors_total{field="value",topic="orders"} 1`,
+		`kt_commit_failures_total{group="audit",partition="1",topic="orders"} 1`,
+		`kt_produce_messages_total{partition="0",topic="orders-copy"} 1`,
+		`kt_produce_bytes_total{partition="0",topic="orders-copy"} 6`,
+		`kt_produce_errors_total{topic="orders-copy"} 1`,
+		`kt_copy_skipped_total{partition="1",topic="orders"} 1`,
+		`kt_broker_throttled_responses_total{broker="localhost:9092"} 1`,
+		`kt_broker_throttle_seconds_total{broker="localhost:9092"} 1.5`,
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+
+	// consume doesn't produce.
+	m = newTrafficMetrics("127.0.0.1:0", false)
+	buf.Reset()
+	m.registry.write(&buf)
+	require.NotContains(t, buf.String(), "kt_produce_messages_total")
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transformRecord is what a transform sees of a message about to be
+// produced: the topic it's routed to, its timestamp and the message itself.
+type transformRecord struct {
+	topic     string
+	timestamp time.Time
+	msg       *message
+}
+
+// transform mirrors a Kafka Connect single message transform (SMT).
+type transform interface {
+	apply(r *transformRecord) error
+}
+
+const connectTransformsPackage = "org.apache.kafka.connect.transforms."
+
+// readTransforms reads the transforms configured in a Kafka Connect style
+// config file. The file is either a properties file as used by Connect
+// workers in standalone mode or a JSON object as sent to the Connect REST
+// API, optionally nested under "config".
+func readTransforms(path string) ([]transform, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var props map[string]string
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '{' {
+		props, err = parseTransformsJSON(trimmed)
+	} else {
+		props, err = parseProperties(buf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v err=%v", path, err)
+	}
+
+	return parseTransforms(props)
+}
+
+func parseTransformsJSON(buf []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, err
+	}
+	if cfg, ok := raw["config"].(map[string]interface{}); ok {
+		raw = cfg
+	}
+
+	props := map[string]string{}
+	for k, v := range raw {
+		switch t := v.(type) {
+		case string:
+			props[k] = t
+		case float64, bool:
+			props[k] = fmt.Sprint(t)
+		}
+	}
+	return props, nil
+}
+
+// parseProperties parses the subset of the Java properties format that is
+// used in practice: key=value or key: value lines and # or ! comments.
+func parseProperties(buf []byte) (map[string]string, error) {
+	props := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid property on line %v: %#v", n, line)
+		}
+		props[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return props, scanner.Err()
+}
+
+// parseTransforms creates the transforms listed in the "transforms"
+// property, in order.
+func parseTransforms(props map[string]string) ([]transform, error) {
+	var transforms []transform
+	for _, name := range splitList(props["transforms"]) {
+		prefix := "transforms." + name + "."
+		cfg := map[string]string{}
+		for k, v := range props {
+			if strings.HasPrefix(k, prefix) {
+				cfg[strings.TrimPrefix(k, prefix)] = v
+			}
+		}
+
+		t, err := newTransform(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform %#v: %v", name, err)
+		}
+		transforms = append(transforms, t)
+	}
+
+	if len(transforms) == 0 {
+		return nil, fmt.Errorf("no transforms configured, expected a list of names in \"transforms\"")
+	}
+	return transforms, nil
+}
+
+func newTransform(cfg map[string]string) (transform, error) {
+	typ := strings.TrimPrefix(cfg["type"], connectTransformsPackage)
+	switch typ {
+	case "MaskField$Key", "MaskField$Value":
+		fields := splitList(cfg["fields"])
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%v requires fields", typ)
+		}
+		replacement, hasReplacement := cfg["replacement"]
+		return &maskField{key: typ == "MaskField$Key", fields: fields, replacement: replacement, hasReplacement: hasReplacement}, nil
+
+	case "InsertField$Key", "InsertField$Value":
+		t := &insertField{
+			key:            typ == "InsertField$Key",
+			topicField:     transformFieldName(cfg["topic.field"]),
+			partitionField: transformFieldName(cfg["partition.field"]),
+			timestampField: transformFieldName(cfg["timestamp.field"]),
+			staticField:    transformFieldName(cfg["static.field"]),
+			staticValue:    cfg["static.value"],
+		}
+		if cfg["offset.field"] != "" {
+			return nil, fmt.Errorf("%v does not support offset.field as offsets aren't known before producing", typ)
+		}
+		if t.topicField == "" && t.partitionField == "" && t.timestampField == "" && t.staticField == "" {
+			return nil, fmt.Errorf("%v requires at least one of topic.field, partition.field, timestamp.field and static.field", typ)
+		}
+		return t, nil
+
+	case "TimestampRouter":
+		t := &timestampRouter{topicFormat: "${topic}-${timestamp}", timestampFormat: "20060102"}
+		if f, ok := cfg["topic.format"]; ok {
+			t.topicFormat = f
+		}
+		if f, ok := cfg["timestamp.format"]; ok {
+			layout, err := javaDateLayout(f)
+			if err != nil {
+				return nil, err
+			}
+			t.timestampFormat = layout
+		}
+		return t, nil
+
+	case "":
+		return nil, fmt.Errorf("missing type")
+	}
+
+	return nil, fmt.Errorf("unsupported type %#v, supported: MaskField$Key, MaskField$Value, InsertField$Key, InsertField$Value and TimestampRouter", cfg["type"])
+}
+
+func splitList(str string) []string {
+	var list []string
+	for _, s := range strings.Split(str, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// transformFieldName strips the optional (?) and required (!) suffixes that
+// InsertField allows on field names, they only matter for Connect schemas.
+func transformFieldName(name string) string {
+	return strings.TrimRight(name, "?!")
+}
+
+// transformTarget returns the key or value of msg decoded as a JSON object.
+func transformTarget(msg *message, key bool) (map[string]interface{}, error) {
+	str, what := msg.Value, "value"
+	if key {
+		str, what = msg.Key, "key"
+	}
+	if str == nil {
+		return nil, nil
+	}
+
+	var obj map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(*str))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil || obj == nil {
+		return nil, fmt.Errorf("%v is not a JSON object", what)
+	}
+	return obj, nil
+}
+
+func setTransformTarget(msg *message, key bool, obj map[string]interface{}) error {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	str := string(buf)
+	if key {
+		msg.Key = &str
+	} else {
+		msg.Value = &str
+	}
+	return nil
+}
+
+// maskField replaces the given fields with the zero value of their type, or
+// with the replacement if one is configured, like MaskField.
+type maskField struct {
+	key            bool
+	fields         []string
+	replacement    string
+	hasReplacement bool
+}
+
+func (t *maskField) apply(r *transformRecord) error {
+	obj, err := transformTarget(r.msg, t.key)
+	if obj == nil || err != nil {
+		return err
+	}
+
+	for _, f := range t.fields {
+		v, ok := obj[f]
+		if !ok {
+			continue
+		}
+		if obj[f], err = t.mask(v); err != nil {
+			return fmt.Errorf("failed to mask field %#v err=%v", f, err)
+		}
+	}
+
+	return setTransformTarget(r.msg, t.key, obj)
+}
+
+func (t *maskField) mask(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case string:
+		if t.hasReplacement {
+			return t.replacement, nil
+		}
+		return "", nil
+	case json.Number:
+		if t.hasReplacement {
+			if _, err := strconv.ParseFloat(t.replacement, 64); err != nil {
+				return nil, fmt.Errorf("replacement %#v is not a number", t.replacement)
+			}
+			return json.Number(t.replacement), nil
+		}
+		return json.Number("0"), nil
+	case bool:
+		return false, nil
+	case []interface{}:
+		return []interface{}{}, nil
+	case map[string]interface{}:
+		return map[string]interface{}{}, nil
+	default: // null
+		return v, nil
+	}
+}
+
+// insertField adds the topic, partition, timestamp or a static value as
+// fields, like InsertField. Timestamps are inserted as milliseconds since
+// the epoch, which is how Connect's JSON converter writes them.
+type insertField struct {
+	key            bool
+	topicField     string
+	partitionField string
+	timestampField string
+	staticField    string
+	staticValue    string
+}
+
+func (t *insertField) apply(r *transformRecord) error {
+	obj, err := transformTarget(r.msg, t.key)
+	if obj == nil || err != nil {
+		return err
+	}
+
+	if t.topicField != "" {
+		obj[t.topicField] = r.topic
+	}
+	if t.partitionField != "" && r.msg.Partition != nil {
+		obj[t.partitionField] = *r.msg.Partition
+	}
+	if t.timestampField != "" {
+		obj[t.timestampField] = r.timestamp.UnixNano() / int64(time.Millisecond)
+	}
+	if t.staticField != "" {
+		obj[t.staticField] = t.staticValue
+	}
+
+	return setTransformTarget(r.msg, t.key, obj)
+}
+
+// timestampRouter routes messages to a topic derived from the original
+// topic and the message's timestamp, like TimestampRouter.
+type timestampRouter struct {
+	topicFormat     string
+	timestampFormat string // Go time layout
+}
+
+func (t *timestampRouter) apply(r *transformRecord) error {
+	r.topic = strings.NewReplacer(
+		"${topic}", r.topic,
+		"${timestamp}", r.timestamp.UTC().Format(t.timestampFormat),
+	).Replace(t.topicFormat)
+	return nil
+}
+
+// javaDateLayouts maps the java.text.SimpleDateFormat patterns that are
+// useful in topic names to Go time layouts, longest patterns first.
+var javaDateLayouts = []struct{ java, golang string }{
+	{"yyyy", "2006"},
+	{"yy", "06"},
+	{"MM", "01"},
+	{"dd", "02"},
+	{"HH", "15"},
+	{"mm", "04"},
+	{"ss", "05"},
+	{"SSS", "000"},
+}
+
+// javaDateLayout converts a SimpleDateFormat pattern like yyyyMMdd-HH into
+// the equivalent Go time layout. Letters are reserved for patterns in
+// SimpleDateFormat, so unknown ones are rejected rather than copied.
+func javaDateLayout(pattern string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+
+		if c == '\'' {
+			j := strings.IndexByte(pattern[i+1:], '\'')
+			if j < 0 {
+				return "", fmt.Errorf("unterminated quote in timestamp format %#v", pattern)
+			}
+			layout.WriteString(pattern[i+1 : i+1+j])
+			i += j + 2
+			continue
+		}
+
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			if c == '.' && strings.HasPrefix(pattern[i+1:], "SSS") {
+				// Go only treats fractional seconds as such after a separator.
+				layout.WriteString(".000")
+				i += 4
+				continue
+			}
+			layout.WriteByte(c)
+			i++
+			continue
+		}
+
+		found := false
+		for _, l := range javaDateLayouts {
+			if strings.HasPrefix(pattern[i:], l.java) {
+				layout.WriteString(l.golang)
+				i += len(l.java)
+				found = true
+				break
+			}
+		}
+		if !found {
+			j := i
+			for j < len(pattern) && pattern[j] == c {
+				j++
+			}
+			return "", fmt.Errorf("unsupported pattern %#v in timestamp format %#v", pattern[i:j], pattern)
+		}
+	}
+	return layout.String(), nil
+}
+
+// applyTransforms runs the transforms on msg in order and returns the topic
+// it ends up being routed to.
+func applyTransforms(transforms []transform, topic string, timestamp time.Time, msg *message) (string, error) {
+	r := &transformRecord{topic: topic, timestamp: timestamp, msg: msg}
+	for _, t := range transforms {
+		if err := t.apply(r); err != nil {
+			return "", err
+		}
+	}
+	return r.topic, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTransforms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kt-transforms")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	data := map[string]string{
+		"props": `# replay pipeline
+transforms=mask, route
+transforms.mask.type=org.apache.kafka.connect.transforms.MaskField$Value
+transforms.mask.fields=ssn
+transforms.route.type: TimestampRouter
+`,
+		"json": `{"name": "sink", "config": {
+  "transforms": "mask,route",
+  "transforms.mask.type": "org.apache.kafka.connect.transforms.MaskField$Value",
+  "transforms.mask.fields": "ssn",
+  "transforms.route.type": "org.apache.kafka.connect.transforms.TimestampRouter"
+}}`,
+	}
+
+	for name, content := range data {
+		path := filepath.Join(dir, name)
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+		actual, err := readTransforms(path)
+		require.Nil(t, err, name)
+		require.Equal(t, []transform{
+			&maskField{fields: []string{"ssn"}},
+			&timestampRouter{topicFormat: "${topic}-${timestamp}", timestampFormat: "20060102"},
+		}, actual, name)
+	}
+
+	for name, props := range map[string]map[string]string{
+		"none":         {},
+		"missing type": {"transforms": "a"},
+		"unknown type": {"transforms": "a", "transforms.a.type": "org.apache.kafka.connect.transforms.Flatten$Value"},
+		"no fields":    {"transforms": "a", "transforms.a.type": "MaskField$Value"},
+		"offset field": {"transforms": "a", "transforms.a.type": "InsertField$Value", "transforms.a.offset.field": "offset"},
+		"bad format":   {"transforms": "a", "transforms.a.type": "TimestampRouter", "transforms.a.timestamp.format": "yyyy-ww"},
+	} {
+		_, err := parseTransforms(props)
+		require.NotNil(t, err, name)
+	}
+}
+
+func TestApplyTransforms(t *testing.T) {
+	props := map[string]string{
+		"transforms":                        "mask,insert,insertKey,route",
+		"transforms.mask.type":              "MaskField$Value",
+		"transforms.mask.fields":            "ssn,age,tags,missing",
+		"transforms.insert.type":            "InsertField$Value",
+		"transforms.insert.topic.field":     "topic",
+		"transforms.insert.partition.field": "partition!",
+		"transforms.insert.timestamp.field": "ts?",
+		"transforms.insert.static.field":    "origin",
+		"transforms.insert.static.value":    "replay",
+		"transforms.insertKey.type":         "InsertField$Key",
+		"transforms.insertKey.static.field": "origin",
+		"transforms.insertKey.static.value": "replay",
+		"transforms.route.type":             "TimestampRouter",
+		"transforms.route.topic.format":     "${topic}_${timestamp}",
+		"transforms.route.timestamp.format": "yyyy-MM-dd'T'HH",
+	}
+	transforms, err := parseTransforms(props)
+	require.Nil(t, err)
+
+	key, value, partition := `{"id": 1}`, `{"name": "Arni", "ssn": "123", "age": 71, "tags": ["a"], "big": 9007199254740993}`, int32(2)
+	msg := message{Key: &key, Value: &value, Partition: &partition}
+	ts := time.Date(2023, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	topic, err := applyTransforms(transforms, "people", ts, &msg)
+	require.Nil(t, err)
+	require.Equal(t, "people_2023-06-01T12", topic)
+	require.Equal(t, `{"id":1,"origin":"replay"}`, *msg.Key)
+	require.Equal(t, `{"age":0,"big":9007199254740993,"name":"Arni","origin":"replay","partition":2,"ssn":"","tags":[],"topic":"people","ts":1685622600000}`, *msg.Value)
+
+	msg = message{Partition: &partition}
+	_, err = applyTransforms(transforms, "people", ts, &msg)
+	require.Nil(t, err)
+	require.Nil(t, msg.Key)
+	require.Nil(t, msg.Value)
+
+	value = "not json"
+	msg = message{Value: &value, Partition: &partition}
+	_, err = applyTransforms(transforms, "people", ts, &msg)
+	require.NotNil(t, err)
+}
+
+func TestMaskFieldReplacement(t *testing.T) {
+	value := `{"ssn": "123", "age": 71, "ok": true}`
+	msg := message{Value: &value}
+	mask := &maskField{fields: []string{"ssn", "ok"}, replacement: "***", hasReplacement: true}
+	require.Nil(t, mask.apply(&transformRecord{msg: &msg}))
+	require.Equal(t, `{"age":71,"ok":false,"ssn":"***"}`, *msg.Value)
+
+	mask = &maskField{fields: []string{"age"}, replacement: "***", hasReplacement: true}
+	require.NotNil(t, mask.apply(&transformRecord{msg: &msg}))
+}
+
+func TestJavaDateLayout(t *testing.T) {
+	data := map[string]string{
+		"yyyyMMdd":              "20060102",
+		"yy-MM-dd HH:mm:ss.SSS": "06-01-02 15:04:05.000",
+		"yyyy'W'MM":             "2006W01",
+		"'week'-dd":             "week-02",
+	}
+
+	for pattern, expected := range data {
+		actual, err := javaDateLayout(pattern)
+		require.Nil(t, err, pattern)
+		require.Equal(t, expected, actual, pattern)
+	}
+
+	for _, invalid := range []string{"yyyy-ww", "'open", "EEE"} {
+		_, err := javaDateLayout(invalid)
+		require.NotNil(t, err, invalid)
+	}
+}
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+	"github.com/fgeller/kt/pkg/codec"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+type uiCmd struct {
+	clientConfig
+
+	group       string
+	encodeKey   string
+	encodeValue string
+	pageSize    int
+	timeout     time.Duration
+	maxAge      time.Duration
+
+	client   sarama.Client
+	consumer sarama.Consumer
+	metadata *metadataCache
+}
+
+type uiArgs struct {
+	clientArgs
+
+	group       string
+	encodeKey   string
+	encodeValue string
+	pageSize    int
+	timeout     time.Duration
+	maxAge      time.Duration
+}
+
+// uiBackend is what the UI reads from the cluster, so the UI can be tested
+// without one.
+type uiBackend interface {
+	topics() ([]uiTopic, error)
+	partitions(topic string) ([]uiPartition, error)
+	// messages returns up to n messages of the partition from offset on,
+	// fewer if the partition ends before.
+	messages(topic string, partition int32, offset int64, n int) ([]*sarama.ConsumerMessage, error)
+	// invalidate makes the next read refresh the cluster metadata.
+	invalidate()
+}
+
+type uiTopic struct {
+	name       string
+	partitions int
+}
+
+// uiPartition is a row of the partitions view. committed and lag are -1
+// without -group or if the group hasn't committed an offset.
+type uiPartition struct {
+	id        int32
+	oldest    int64
+	newest    int64
+	committed int64
+	lag       int64
+}
+
+type uiView int
+
+const (
+	uiTopics uiView = iota
+	uiPartitions
+	uiMessages
+	uiDetail
+)
+
+// uiModel is the state of the UI. handleKey changes it and render draws it,
+// neither touches the terminal.
+type uiModel struct {
+	backend  uiBackend
+	pageSize int
+	keyEncs  []string // the encodings that e and E cycle through, the
+	valEncs  []string // current ones first
+	view     uiView
+	status   string
+
+	topics    []uiTopic
+	filter    string
+	filtering bool
+	topic     string
+
+	partitions []uiPartition
+	partition  uiPartition
+
+	offset   int64 // of the first message on the page
+	messages []*sarama.ConsumerMessage
+
+	cursor []int // per view
+	scroll int   // of the detail view
+}
+
+func newUIModel(backend uiBackend, pageSize int, encodeKey, encodeValue string) *uiModel {
+	m := &uiModel{
+		backend:  backend,
+		pageSize: pageSize,
+		keyEncs:  uiEncodings(encodeKey),
+		valEncs:  uiEncodings(encodeValue),
+		cursor:   make([]int, uiDetail+1),
+	}
+	m.loadTopics()
+	return m
+}
+
+// uiEncodings returns the encodings to cycle through starting with enc.
+func uiEncodings(enc string) []string {
+	encs := []string{enc}
+	for _, e := range []string{"string", "hex", "base64", "json"} {
+		if e != enc {
+			encs = append(encs, e)
+		}
+	}
+	return encs
+}
+
+func (m *uiModel) loadTopics() {
+	topics, err := m.backend.topics()
+	if err != nil {
+		m.status = fmt.Sprintf("failed to read topics: %v", err)
+		return
+	}
+	m.topics = topics
+}
+
+func (m *uiModel) loadPartitions() bool {
+	ps, err := m.backend.partitions(m.topic)
+	if err != nil {
+		m.status = fmt.Sprintf("failed to read partitions of %v: %v", m.topic, err)
+		return false
+	}
+	m.partitions = ps
+	return true
+}
+
+func (m *uiModel) loadMessages(offset int64) {
+	if offset > m.partition.newest-int64(m.pageSize) {
+		offset = m.partition.newest - int64(m.pageSize)
+	}
+	if offset < m.partition.oldest {
+		offset = m.partition.oldest
+	}
+	msgs, err := m.backend.messages(m.topic, m.partition.id, offset, m.pageSize)
+	if err != nil {
+		m.status = fmt.Sprintf("failed to read messages at offset %v: %v", offset, err)
+		return
+	}
+	m.offset, m.messages = offset, msgs
+	m.cursor[uiMessages] = 0
+}
+
+func (m *uiModel) visibleTopics() []uiTopic {
+	re, err := regexp.Compile(m.filter)
+	if err != nil {
+		return m.topics
+	}
+	result := []uiTopic{}
+	for _, t := range m.topics {
+		if re.MatchString(t.name) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func (m *uiModel) rows() int {
+	switch m.view {
+	case uiTopics:
+		return len(m.visibleTopics())
+	case uiPartitions:
+		return len(m.partitions)
+	case uiMessages:
+		return len(m.messages)
+	}
+	return 0
+}
+
+// handleKey applies a key as returned by readUIKey and returns false once
+// the UI should quit.
+func (m *uiModel) handleKey(key string) bool {
+	m.status = ""
+	if m.filtering {
+		switch key {
+		case "enter":
+			m.filtering = false
+		case "esc":
+			m.filtering, m.filter = false, ""
+		case "backspace":
+			if m.filter != "" {
+				_, n := utf8.DecodeLastRuneInString(m.filter)
+				m.filter = m.filter[:len(m.filter)-n]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				m.filter += key
+			}
+		}
+		m.cursor[uiTopics] = 0
+		return true
+	}
+
+	cur := &m.cursor[m.view]
+	switch key {
+	case "q", "ctrl-c":
+		return false
+	case "up", "k":
+		if m.view == uiDetail {
+			if m.scroll > 0 {
+				m.scroll--
+			}
+		} else if *cur > 0 {
+			*cur--
+		}
+	case "down", "j":
+		if m.view == uiDetail {
+			m.scroll++
+		} else if *cur < m.rows()-1 {
+			*cur++
+		}
+	case "enter", "right", "l":
+		m.open()
+	case "esc", "left", "h", "backspace":
+		if m.view > uiTopics {
+			m.view--
+		}
+	case "/":
+		if m.view == uiTopics {
+			m.filtering = true
+		}
+	case "r":
+		m.refresh()
+	case "R":
+		m.backend.invalidate()
+		m.refresh()
+		if m.status == "" {
+			m.status = "refreshed metadata"
+		}
+	case "n", "pgdown":
+		if m.view == uiMessages && len(m.messages) > 0 {
+			m.loadMessages(m.messages[len(m.messages)-1].Offset + 1)
+		}
+	case "p", "pgup":
+		if m.view == uiMessages {
+			m.loadMessages(m.offset - int64(m.pageSize))
+		}
+	case "g":
+		if m.view == uiMessages {
+			m.loadMessages(m.partition.oldest)
+		}
+	case "G":
+		if m.view == uiMessages {
+			m.loadMessages(m.partition.newest)
+		}
+	case "e":
+		m.valEncs = append(m.valEncs[1:], m.valEncs[0])
+		m.status = "values as " + m.valEncs[0]
+	case "E":
+		m.keyEncs = append(m.keyEncs[1:], m.keyEncs[0])
+		m.status = "keys as " + m.keyEncs[0]
+	}
+	return true
+}
+
+func (m *uiModel) open() {
+	switch m.view {
+	case uiTopics:
+		topics := m.visibleTopics()
+		if len(topics) == 0 {
+			return
+		}
+		m.topic = topics[m.cursor[uiTopics]].name
+		if m.loadPartitions() {
+			m.view, m.cursor[uiPartitions] = uiPartitions, 0
+		}
+	case uiPartitions:
+		if len(m.partitions) == 0 {
+			return
+		}
+		m.partition = m.partitions[m.cursor[uiPartitions]]
+		m.view = uiMessages
+		m.loadMessages(m.partition.newest)
+	case uiMessages:
+		if len(m.messages) > 0 {
+			m.view, m.scroll = uiDetail, 0
+		}
+	}
+}
+
+// refresh reloads the current view, e.g. to see new messages.
+func (m *uiModel) refresh() {
+	switch m.view {
+	case uiTopics:
+		m.loadTopics()
+	case uiPartitions:
+		m.loadPartitions()
+	case uiMessages:
+		if !m.loadPartitions() {
+			return
+		}
+		for _, p := range m.partitions {
+			if p.id == m.partition.id {
+				m.partition = p
+			}
+		}
+		m.loadMessages(m.offset)
+	}
+}
+
+// uiField decodes a key or value with enc, falling back to base64.
+func uiField(data []byte, enc string) string {
+	if data == nil {
+		return "null"
+	}
+	c, err := codec.ParseChain(enc)
+	if err == nil {
+		var v interface{}
+		if v, err = c.Decode(data); err == nil {
+			if s, ok := v.(*string); ok {
+				return *s
+			}
+			buf, _ := json.Marshal(v)
+			return string(buf)
+		}
+	}
+	return "base64:" + *encodeBytes(data, "base64")
+}
+
+// fit cuts s to width runes, or pads it.
+func fit(s string, width int) string {
+	s = tailEscaper.Replace(s)
+	if n := utf8.RuneCountInString(s); n <= width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	if width <= 1 {
+		return string([]rune(s)[:width])
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+// render returns the screen's lines for a terminal of the given size.
+func (m *uiModel) render(width, height int) []string {
+	var (
+		title  string
+		header string
+		rows   []string
+		help   string
+	)
+	switch m.view {
+	case uiTopics:
+		title = "topics"
+		if m.filter != "" || m.filtering {
+			title += " matching /" + m.filter
+		}
+		header = fmt.Sprintf("%-10v %v", "PARTITIONS", "TOPIC")
+		for _, t := range m.visibleTopics() {
+			rows = append(rows, fmt.Sprintf("%-10v %v", t.partitions, t.name))
+		}
+		help = "enter open  / filter  r/R refresh  q quit"
+	case uiPartitions:
+		title = "topic " + m.topic
+		header = fmt.Sprintf("%-10v %-14v %-14v %-12v %-14v %v", "PARTITION", "OLDEST", "NEWEST", "MESSAGES", "COMMITTED", "LAG")
+		for _, p := range m.partitions {
+			committed, lag := "-", "-"
+			if p.committed >= 0 {
+				committed, lag = fmt.Sprint(p.committed), fmt.Sprint(p.lag)
+			}
+			rows = append(rows, fmt.Sprintf("%-10v %-14v %-14v %-12v %-14v %v", p.id, p.oldest, p.newest, p.newest-p.oldest, committed, lag))
+		}
+		help = "enter messages  esc back  r refresh  q quit"
+	case uiMessages:
+		title = fmt.Sprintf("topic %v partition %v offsets %v-%v, keys as %v, values as %v", m.topic, m.partition.id, m.partition.oldest, m.partition.newest, m.keyEncs[0], m.valEncs[0])
+		header = fmt.Sprintf("%-12v %-24v %-20v %v", "OFFSET", "TIMESTAMP", "KEY", "VALUE")
+		for _, msg := range m.messages {
+			ts := ""
+			if !msg.Timestamp.IsZero() {
+				ts = msg.Timestamp.Format("2006-01-02T15:04:05.000")
+			}
+			rows = append(rows, fmt.Sprintf("%-12v %-24v %v %v", msg.Offset, ts, fit(uiField(msg.Key, m.keyEncs[0]), 20), uiField(msg.Value, m.valEncs[0])))
+		}
+		help = "enter show  n/p next/previous page  g/G oldest/newest  e/E value/key encoding  esc back  q quit"
+	case uiDetail:
+		msg := m.messages[m.cursor[uiMessages]]
+		title = fmt.Sprintf("topic %v partition %v offset %v", m.topic, msg.Partition, msg.Offset)
+		rows = m.detail(msg)
+		if m.scroll > len(rows)-1 {
+			m.scroll = len(rows) - 1
+		}
+		rows = rows[m.scroll:]
+		help = "up/down scroll  e/E value/key encoding  esc back  q quit"
+	}
+
+	lines := []string{ansiCyan + fit(title, width) + ansiReset}
+	if header != "" {
+		lines = append(lines, ansiDim+fit(header, width)+ansiReset)
+	}
+	space := height - len(lines) - 2
+	first := 0
+	if cur := m.cursor[m.view]; m.view != uiDetail && cur >= space {
+		first = cur - space + 1
+	}
+	for i := first; i < len(rows) && i-first < space; i++ {
+		line := fit(rows[i], width)
+		if m.view != uiDetail && i == m.cursor[m.view] {
+			line = "\x1b[7m" + line + ansiReset
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+
+	status := m.status
+	if m.filtering {
+		status = "/" + m.filter
+	}
+	return append(lines, ansiYellow+fit(status, width)+ansiReset, ansiDim+fit(help, width)+ansiReset)
+}
+
+// detail renders a message as indented JSON, with its key and value decoded
+// per the current encodings.
+func (m *uiModel) detail(msg *sarama.ConsumerMessage) []string {
+	field := func(data []byte, enc string) interface{} {
+		s := uiField(data, enc)
+		if data == nil {
+			return nil
+		}
+		if enc != "json" {
+			return s
+		}
+		return json.RawMessage(s)
+	}
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	buf, err := json.MarshalIndent(map[string]interface{}{
+		"partition": msg.Partition,
+		"offset":    msg.Offset,
+		"timestamp": msg.Timestamp,
+		"headers":   headers,
+		"key":       field(msg.Key, m.keyEncs[0]),
+		"value":     field(msg.Value, m.valEncs[0]),
+	}, "", "  ")
+	if err != nil {
+		// e.g. a value that failed to decode as json.
+		buf, _ = json.MarshalIndent(map[string]interface{}{
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"key":       uiField(msg.Key, m.keyEncs[0]),
+			"value":     uiField(msg.Value, m.valEncs[0]),
+		}, "", "  ")
+	}
+	return strings.Split(string(buf), "\n")
+}
+
+// readUIKey reads a key press from a terminal in raw mode and names
+// special keys like up, enter or esc.
+func readUIKey(r io.Reader) (string, error) {
+	buf := make([]byte, 16)
+	n, err := r.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	switch s := string(buf[:n]); s {
+	case "\x1b[A", "\x1bOA":
+		return "up", nil
+	case "\x1b[B", "\x1bOB":
+		return "down", nil
+	case "\x1b[C", "\x1bOC":
+		return "right", nil
+	case "\x1b[D", "\x1bOD":
+		return "left", nil
+	case "\x1b[5~":
+		return "pgup", nil
+	case "\x1b[6~":
+		return "pgdown", nil
+	case "\r", "\n":
+		return "enter", nil
+	case "\x1b":
+		return "esc", nil
+	case "\x7f", "\x08":
+		return "backspace", nil
+	case "\x03":
+		return "ctrl-c", nil
+	default:
+		return s, nil
+	}
+}
+
+func (cmd *uiCmd) run(args []string) {
+	var err error
+	cmd.parseArgs(args)
+
+	fd := int(syscall.Stdin)
+	if !terminal.IsTerminal(fd) || !terminal.IsTerminal(int(syscall.Stdout)) {
+		failf("kt ui requires a terminal")
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+	cmd.metadata = newMetadataCache(cmd.client.RefreshMetadata, cmd.maxAge)
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		failf("failed to set up terminal err=%v", err)
+	}
+	fmt.Fprint(stdout, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer func() {
+		fmt.Fprint(stdout, "\x1b[?25h\x1b[?1049l")
+		stdout.Flush()
+		terminal.Restore(fd, state)
+	}()
+
+	m := newUIModel(cmd, cmd.pageSize, cmd.encodeKey, cmd.encodeValue)
+	for {
+		width, height, err := terminal.GetSize(int(syscall.Stdout))
+		if err != nil {
+			width, height = 80, 24
+		}
+		fmt.Fprint(stdout, "\x1b[H\x1b[2J"+strings.Join(m.render(width, height), "\r\n"))
+		stdout.Flush()
+
+		key, err := readUIKey(os.Stdin)
+		if err != nil || !m.handleKey(key) {
+			return
+		}
+	}
+}
+
+func (cmd *uiCmd) invalidate() { cmd.metadata.invalidate() }
+
+func (cmd *uiCmd) topics() ([]uiTopic, error) {
+	if err := cmd.metadata.refresh(); err != nil {
+		return nil, err
+	}
+	names, err := cmd.client.Topics()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	topics := []uiTopic{}
+	for _, n := range names {
+		ps, err := cmd.client.Partitions(n)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, uiTopic{name: n, partitions: len(ps)})
+	}
+	return topics, nil
+}
+
+func (cmd *uiCmd) partitions(topic string) ([]uiPartition, error) {
+	ids, err := cmd.client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	result := []uiPartition{}
+	for _, id := range ids {
+		p := uiPartition{id: id, committed: -1, lag: -1}
+		if p.oldest, err = cmd.client.GetOffset(topic, id, sarama.OffsetOldest); err != nil {
+			return nil, err
+		}
+		if p.newest, err = cmd.client.GetOffset(topic, id, sarama.OffsetNewest); err != nil {
+			return nil, err
+		}
+		if cmd.group != "" {
+			if p.committed, err = fetchCommittedOffset(cmd.client, cmd.group, topic, id); err != nil {
+				return nil, err
+			}
+			if p.committed >= 0 {
+				p.lag = p.newest - p.committed
+			}
+		}
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].id < result[j].id })
+	return result, nil
+}
+
+func (cmd *uiCmd) messages(topic string, partition int32, offset int64, n int) ([]*sarama.ConsumerMessage, error) {
+	newest, err := cmd.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil || offset >= newest {
+		return nil, err
+	}
+	pc, err := cmd.consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer logClose(fmt.Sprintf("partition consumer %v", partition), pc)
+
+	var (
+		msgs    []*sarama.ConsumerMessage
+		timeout = time.After(cmd.timeout)
+	)
+	for len(msgs) < n {
+		select {
+		case msg := <-pc.Messages():
+			msgs = append(msgs, msg)
+			if msg.Offset >= newest-1 {
+				return msgs, nil
+			}
+		case err := <-pc.Errors():
+			return msgs, err
+		case <-timeout:
+			// e.g. the last offsets are transaction markers.
+			return msgs, nil
+		}
+	}
+	return msgs, nil
+}
+
+func (cmd *uiCmd) saramaConfig() *sarama.Config {
+	cfg := cmd.clientConfig.saramaConfig("ui")
+	if cmd.maxAge > 0 {
+		cfg.Metadata.RefreshFrequency = cmd.maxAge
+	}
+
+	return cfg
+}
+
+func (cmd *uiCmd) failStartup(msg string) {
+	failUsage(msg, "kt ui")
+}
+
+func (cmd *uiCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	for _, enc := range []string{args.encodeKey, args.encodeValue} {
+		if _, err := codec.ParseChain(enc); err != nil {
+			cmd.failStartup(fmt.Sprintf("invalid encoding %#v err=%v", enc, err))
+		}
+	}
+	if args.pageSize <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid page argument %v, expected a positive number of messages.", args.pageSize))
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+	if args.maxAge < 0 {
+		cmd.failStartup("-metadata-max-age cannot be negative.")
+	}
+
+	cmd.group = args.group
+	cmd.maxAge = args.maxAge
+	cmd.encodeKey = args.encodeKey
+	cmd.encodeValue = args.encodeValue
+	cmd.pageSize = args.pageSize
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+}
+
+func (cmd *uiCmd) parseFlags(as []string) uiArgs {
+	var args uiArgs
+	flags := flag.NewFlagSet("ui", flag.ContinueOnError)
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.group, "group", "", "Consumer group to show the committed offsets and lag of (defaults to none).")
+	flags.StringVar(&args.encodeValue, "encodevalue", "string", "Show message values as (string|hex|base64|json|gzip|zstd) or a chain like gzip+json at first, defaults to string.")
+	flags.StringVar(&args.encodeKey, "encodekey", "string", "Show message keys as (string|hex|base64|json|gzip|zstd) or a chain like gzip+json at first, defaults to string.")
+	flags.IntVar(&args.pageSize, "page", 50, "Number of messages per page.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Time to wait for the messages of a page.")
+	flags.DurationVar(&args.maxAge, "metadata-max-age", 0, "Reuse the cluster metadata for up to the given time when listing topics, R refreshes it explicitly (defaults to 0 to refresh every time).")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of ui:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, uiDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+	return args
+}
+
+var uiDocString = `
+The value for -brokers can also be set via the environment variable KT_BROKERS.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+"kt ui" browses a cluster in the terminal. It lists the topics with their
+number of partitions, a topic's partitions with their oldest and newest
+offsets, and with -group the group's committed offsets and lag, and pages
+through the messages of a partition starting at the newest -page messages.
+
+Keys:
+
+  up/down, j/k   move the selection, scroll a message
+  enter          open the topic, partition or message
+  esc, left      go back
+  /              filter topics by a regular expression
+  n/p            next/previous page of messages
+  g/G            oldest/newest page of messages
+  e/E            cycle the encoding of values/keys (string, hex, base64, json)
+  r              reload, e.g. to see new messages
+  R              reload with fresh cluster metadata
+  q              quit
+
+  $ kt ui -group billing -encodevalue json
+
+On busy clusters, -metadata-max-age reuses the cluster metadata for that
+long when reloading the list of topics instead of asking the brokers every
+time, and sets how often kt refreshes it in the background. R refreshes it
+right away, e.g. to see a topic that was just created.`
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUIBackend struct {
+	newest      int64
+	invalidated int
+}
+
+func (b *fakeUIBackend) invalidate() { b.invalidated++ }
+
+func (b *fakeUIBackend) topics() ([]uiTopic, error) {
+	return []uiTopic{{name: "billing", partitions: 1}, {name: "orders", partitions: 2}}, nil
+}
+
+func (b *fakeUIBackend) partitions(topic string) ([]uiPartition, error) {
+	return []uiPartition{
+		{id: 0, oldest: 0, newest: b.newest, committed: 5, lag: b.newest - 5},
+		{id: 1, oldest: 0, newest: 0, committed: -1, lag: -1},
+	}, nil
+}
+
+func (b *fakeUIBackend) messages(topic string, partition int32, offset int64, n int) ([]*sarama.ConsumerMessage, error) {
+	var msgs []*sarama.ConsumerMessage
+	for o := offset; o < b.newest && len(msgs) < n; o++ {
+		msgs = append(msgs, &sarama.ConsumerMessage{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    o,
+			Key:       []byte(fmt.Sprintf("k%v", o)),
+			Value:     []byte(fmt.Sprintf(`{"n":%v}`, o)),
+		})
+	}
+	return msgs, nil
+}
+
+func TestUIModelNavigation(t *testing.T) {
+	m := newUIModel(&fakeUIBackend{newest: 25}, 10, "string", "string")
+	require.Len(t, m.topics, 2)
+
+	for _, k := range []string{"/", "o", "r", "enter"} {
+		require.True(t, m.handleKey(k))
+	}
+	require.Equal(t, []uiTopic{{name: "orders", partitions: 2}}, m.visibleTopics())
+
+	b := m.backend.(*fakeUIBackend)
+	m.handleKey("R")
+	require.Equal(t, 1, b.invalidated)
+	require.Equal(t, "refreshed metadata", m.status)
+
+	m.handleKey("enter")
+	require.Equal(t, uiPartitions, m.view)
+	require.Equal(t, "orders", m.topic)
+	require.Len(t, m.partitions, 2)
+
+	m.handleKey("enter")
+	require.Equal(t, uiMessages, m.view)
+	require.Equal(t, int64(15), m.offset, "starts at the newest page")
+	require.Len(t, m.messages, 10)
+
+	m.handleKey("p")
+	require.Equal(t, int64(5), m.offset)
+	m.handleKey("p")
+	require.Equal(t, int64(0), m.offset)
+	m.handleKey("n")
+	require.Equal(t, int64(10), m.offset)
+	m.handleKey("G")
+	require.Equal(t, int64(15), m.offset)
+	m.handleKey("n")
+	require.Equal(t, int64(15), m.offset, "stays on the newest page")
+
+	m.handleKey("down")
+	m.handleKey("enter")
+	require.Equal(t, uiDetail, m.view)
+	require.Contains(t, strings.Join(m.render(80, 24), "\n"), `"value": "{\"n\":16}"`)
+
+	for i := 0; i < 3; i++ {
+		m.handleKey("e")
+	}
+	require.Equal(t, "json", m.valEncs[0])
+	require.Contains(t, strings.Join(m.render(80, 24), "\n"), `"n": 16`)
+
+	m.handleKey("esc")
+	m.handleKey("esc")
+	m.handleKey("esc")
+	require.Equal(t, uiTopics, m.view)
+	require.False(t, m.handleKey("q"))
+}
+
+func TestUIModelRender(t *testing.T) {
+	m := newUIModel(&fakeUIBackend{newest: 25}, 10, "string", "hex")
+	m.handleKey("down")
+	m.handleKey("enter")
+
+	lines := m.render(100, 8)
+	require.Len(t, lines, 8)
+	require.Contains(t, lines[2], "0          0              25             25           5              20")
+	require.Contains(t, lines[2], "\x1b[7m", "highlights the selection")
+	require.Contains(t, lines[3], "-              -")
+
+	m.handleKey("enter")
+	lines = m.render(200, 8)
+	require.Contains(t, lines[2], "15           ")
+	require.Contains(t, lines[2], "k15")
+	require.Contains(t, lines[2], "7b226e223a31357d")
+}
+
+func TestUIField(t *testing.T) {
+	require.Equal(t, "null", uiField(nil, "string"))
+	require.Equal(t, "hans", uiField([]byte("hans"), "string"))
+	require.Equal(t, "68616e73", uiField([]byte("hans"), "hex"))
+	require.Equal(t, `{"a":1}`, uiField([]byte(`{"a": 1}`), "json"))
+	require.Equal(t, "base64:aGFucw==", uiField([]byte("hans"), "json"))
+}
+
+func TestReadUIKey(t *testing.T) {
+	data := map[string]string{
+		"\x1b[A":  "up",
+		"\x1b[6~": "pgdown",
+		"\r":      "enter",
+		"\x1b":    "esc",
+		"\x7f":    "backspace",
+		"G":       "G",
+	}
+	for in, expected := range data {
+		actual, err := readUIKey(strings.NewReader(in))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, "%q", in)
+	}
+}
+
+func TestFit(t *testing.T) {
+	require.Equal(t, "ab  ", fit("ab", 4))
+	require.Equal(t, "abc…", fit("abcdef", 4))
+	require.Equal(t, `a\nb`, fit("a\nb", 4))
+}
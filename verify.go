@@ -0,0 +1,302 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type verifyCmd struct {
+	clientConfig
+
+	topic         string
+	partitioner   string
+	encodeKey     string
+	maxViolations int
+	timeout       time.Duration
+	verbose       bool
+	pretty        bool
+
+	client   sarama.Client
+	consumer sarama.Consumer
+
+	sync.Mutex
+	reported int
+}
+
+type verifyArgs struct {
+	clientArgs
+
+	topic         string
+	partitioner   string
+	encodeKey     string
+	maxViolations int
+	timeout       time.Duration
+	verbose       bool
+	pretty        bool
+}
+
+// keyViolation is a record whose key the partitioner maps to another
+// partition than the one it was found on.
+type keyViolation struct {
+	Partition int32   `json:"partition"`
+	Offset    int64   `json:"offset"`
+	Key       *string `json:"key"`
+	Expected  int32   `json:"expected"`
+}
+
+// verifyReport counts the records kt read, the ones with keys and the
+// violations among them, Failed lists partitions that couldn't be read
+// completely.
+type verifyReport struct {
+	Topic       string  `json:"topic"`
+	Partitioner string  `json:"partitioner"`
+	Records     int     `json:"records"`
+	Keyed       int     `json:"keyed"`
+	Violations  int     `json:"violations"`
+	Failed      []int32 `json:"failed,omitempty"`
+}
+
+func (r *verifyReport) add(o verifyReport) {
+	r.Records += o.Records
+	r.Keyed += o.Keyed
+	r.Violations += o.Violations
+}
+
+func (cmd *verifyCmd) run(args []string) {
+	var err error
+
+	cmd.parseArgs(args)
+
+	if cmd.verbose {
+		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	if cmd.client, err = sarama.NewClient(cmd.brokers, cmd.saramaConfig()); err != nil {
+		failf("failed to create client err=%v", err)
+	}
+	defer logClose("client", cmd.client)
+
+	if cmd.consumer, err = sarama.NewConsumerFromClient(cmd.client); err != nil {
+		failf("failed to create consumer err=%v", err)
+	}
+	defer logClose("consumer", cmd.consumer)
+
+	partitions, err := cmd.client.Partitions(cmd.topic)
+	if err != nil {
+		failf("failed to read partitions for topic %v err=%v", cmd.topic, err)
+	}
+	if len(partitions) == 0 {
+		failf("found no partitions for topic %v", cmd.topic)
+	}
+
+	out := make(chan printContext)
+	go print(out, cmd.pretty)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		report = verifyReport{Topic: cmd.topic, Partitioner: cmd.partitioner}
+	)
+	wg.Add(len(partitions))
+	for _, p := range partitions {
+		go func(p int32) {
+			defer wg.Done()
+			r, err := cmd.verifyPartition(out, p, int32(len(partitions)))
+			mu.Lock()
+			defer mu.Unlock()
+			report.add(r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to verify partition %v of topic %v err=%v\n", p, cmd.topic, err)
+				report.Failed = append(report.Failed, p)
+			}
+		}(p)
+	}
+	wg.Wait()
+	sort.Slice(report.Failed, func(i, j int) bool { return report.Failed[i] < report.Failed[j] })
+
+	ctx := printContext{output: report, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+
+	if report.Violations > 0 {
+		failf("found %v of %v records with keys in another partition than %v expects", report.Violations, report.Keyed, cmd.partitioner)
+	}
+	if len(report.Failed) > 0 {
+		exitf(exitPartial, "failed to verify partitions %v of topic %v", report.Failed, cmd.topic)
+	}
+}
+
+// verifyPartition reads the partition from its oldest to its newest offset
+// at start and checks the partition of every record with a key.
+func (cmd *verifyCmd) verifyPartition(out chan printContext, partition, partitions int32) (verifyReport, error) {
+	oldest, err := cmd.client.GetOffset(cmd.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	newest, err := cmd.client.GetOffset(cmd.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	if newest <= oldest {
+		return verifyReport{}, nil
+	}
+
+	pc, err := cmd.consumer.ConsumePartition(cmd.topic, partition, oldest)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	defer logClose("partition consumer", pc)
+
+	return cmd.verifyMessages(pc, partition, partitions, newest, func(v keyViolation) { cmd.report(out, v) })
+}
+
+// verifyMessages checks the messages of pc until the one before newest.
+// It stops early after -timeout without messages, as the newest offsets may
+// be transaction markers.
+func (cmd *verifyCmd) verifyMessages(pc sarama.PartitionConsumer, partition, partitions int32, newest int64, violation func(keyViolation)) (verifyReport, error) {
+	var (
+		report verifyReport
+		expect = keyPartitioners[cmd.partitioner]
+		timer  = time.NewTimer(cmd.timeout)
+	)
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(cmd.timeout)
+			report.Records++
+			if msg.Key != nil {
+				report.Keyed++
+				if expected := expect(msg.Key, partitions); expected != msg.Partition {
+					report.Violations++
+					violation(keyViolation{Partition: msg.Partition, Offset: msg.Offset, Key: encodeBytes(msg.Key, cmd.encodeKey), Expected: expected})
+				}
+			}
+			if msg.Offset >= newest-1 {
+				return report, nil
+			}
+		case err := <-pc.Errors():
+			return report, err
+		case <-timer.C:
+			if cmd.verbose {
+				fmt.Fprintf(os.Stderr, "timed out verifying partition %v of topic %v after %v records\n", partition, cmd.topic, report.Records)
+			}
+			return report, nil
+		}
+	}
+}
+
+// report prints up to -max-violations violations, they're all counted for
+// the summary either way.
+func (cmd *verifyCmd) report(out chan printContext, v keyViolation) {
+	cmd.Lock()
+	if cmd.maxViolations > 0 && cmd.reported >= cmd.maxViolations {
+		cmd.Unlock()
+		return
+	}
+	cmd.reported++
+	cmd.Unlock()
+
+	ctx := printContext{output: v, done: make(chan struct{})}
+	out <- ctx
+	<-ctx.done
+}
+
+func (cmd *verifyCmd) saramaConfig() *sarama.Config {
+	return cmd.clientConfig.saramaConfig("verify")
+}
+
+func (cmd *verifyCmd) failStartup(msg string) {
+	failUsage(msg, "kt verify")
+}
+
+func (cmd *verifyCmd) parseArgs(as []string) {
+	args := cmd.parseFlags(as)
+
+	if args.topic == "" {
+		args.topic = os.Getenv("KT_TOPIC")
+	}
+	if args.topic == "" {
+		cmd.failStartup("Topic name is required.")
+	}
+	if keyPartitioners[args.partitioner] == nil {
+		cmd.failStartup(fmt.Sprintf("unknown partitioner %#v, available: %v.", args.partitioner, strings.Join(keyPartitionerNames, ", ")))
+	}
+	if args.encodeKey != "string" && args.encodeKey != "hex" && args.encodeKey != "base64" {
+		cmd.failStartup(fmt.Sprintf(`unsupported encodekey argument %#v, only string, hex and base64 are supported.`, args.encodeKey))
+	}
+	if args.timeout <= 0 {
+		cmd.failStartup(fmt.Sprintf("invalid timeout argument %v, expected a positive duration.", args.timeout))
+	}
+
+	cmd.topic = args.topic
+	cmd.partitioner = args.partitioner
+	cmd.encodeKey = args.encodeKey
+	cmd.maxViolations = args.maxViolations
+	cmd.timeout = args.timeout
+	cmd.clientConfig = args.clientArgs.read()
+	cmd.verbose = args.verbose
+	cmd.pretty = args.pretty
+}
+
+func (cmd *verifyCmd) parseFlags(as []string) verifyArgs {
+	var args verifyArgs
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.StringVar(&args.topic, "topic", "", "Topic to verify (required).")
+	args.clientArgs.addFlags(flags)
+	flags.StringVar(&args.partitioner, "partitioner", "murmur2", "Partitioner the keys should match, one of: "+strings.Join(keyPartitionerNames, ", "))
+	flags.StringVar(&args.encodeKey, "encodekey", "string", "Encode the keys of violations as string, hex or base64.")
+	flags.IntVar(&args.maxViolations, "max-violations", 100, "Maximum number of violations to print, 0 prints all of them.")
+	flags.DurationVar(&args.timeout, "timeout", 5*time.Second, "Time to wait for further records of a partition before considering it verified.")
+	flags.BoolVar(&args.verbose, "verbose", false, "More verbose logging to stderr.")
+	flags.BoolVar(&args.pretty, "pretty", true, "Control output pretty printing.")
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage of verify:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, verifyDocString)
+	}
+
+	err := flags.Parse(as)
+	if err != nil && strings.Contains(err.Error(), "flag: help requested") {
+		exit(0)
+	} else if err != nil {
+		exit(exitUsage)
+	}
+
+	jsonErrors = !args.pretty
+	return args
+}
+
+var verifyDocString = `
+The values for -topic and -brokers can also be set via environment variables KT_TOPIC and KT_BROKERS respectively.
+The values supplied on the command line win over environment variable values.
+The values for -sasl-mechanism, -sasl-user and -sasl-password can be set via KT_SASL_MECHANISM, KT_SASL_USER and KT_SASL_PASSWORD.
+The values for -krb5-config, -keytab, -principal and -realm can be set via KT_KRB5_CONFIG, KT_KEYTAB, KT_PRINCIPAL and KT_REALM.
+
+verify reads all partitions of -topic in parallel, from the oldest to the
+newest offset at start, and checks that the key of every record maps to the
+partition it was found on under -partitioner, murmur2 of the Java client by
+default. It's the exhaustive counterpart of "kt analyze partitioning", e.g.
+after changing producer libraries or to find a custom partitioner's bugs.
+
+kt prints each violation with the partition and offset of the record, its
+key and the expected partition, up to -max-violations, followed by a summary
+of all records. It exits with status 1 if any record violates the
+partitioning. Records without key are counted but not checked, and records
+produced before partitions were added are violations, too.
+
+  $ kt verify -topic orders
+  $ kt verify -topic orders -partitioner crc32 -encodekey hex`
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyMessages(t *testing.T) {
+	messages := make(chan *sarama.ConsumerMessage, 4)
+	messages <- &sarama.ConsumerMessage{Partition: 0, Offset: 0, Key: []byte("Arni")}
+	messages <- &sarama.ConsumerMessage{Partition: 0, Offset: 1, Key: []byte("Hans")}
+	messages <- &sarama.ConsumerMessage{Partition: 0, Offset: 2}
+	messages <- &sarama.ConsumerMessage{Partition: 0, Offset: 3, Key: []byte("Bert")}
+
+	target := &verifyCmd{partitioner: "murmur2", encodeKey: "string", timeout: time.Second}
+	violations := []keyViolation{}
+	report, err := target.verifyMessages(tPartitionConsumer{messages: messages}, 0, 3, 4, func(v keyViolation) { violations = append(violations, v) })
+	require.NoError(t, err)
+	require.Equal(t, verifyReport{Records: 4, Keyed: 3, Violations: 1}, report)
+
+	key := "Hans"
+	require.Equal(t, []keyViolation{{Partition: 0, Offset: 1, Key: &key, Expected: 1}}, violations)
+}
+
+func TestVerifyMessagesTimeout(t *testing.T) {
+	messages := make(chan *sarama.ConsumerMessage, 1)
+	messages <- &sarama.ConsumerMessage{Partition: 1, Offset: 0, Key: []byte("Hans")}
+
+	target := &verifyCmd{partitioner: "murmur2", encodeKey: "string", timeout: 10 * time.Millisecond}
+	report, err := target.verifyMessages(tPartitionConsumer{messages: messages}, 1, 3, 10, func(keyViolation) { t.Fatal("unexpected violation") })
+	require.NoError(t, err)
+	require.Equal(t, verifyReport{Records: 1, Keyed: 1}, report)
+}
+
+func TestVerifyReportMaxViolations(t *testing.T) {
+	out := make(chan printContext)
+	go func() {
+		for ctx := range out {
+			close(ctx.done)
+		}
+	}()
+
+	target := &verifyCmd{maxViolations: 2}
+	for i := 0; i < 5; i++ {
+		target.report(out, keyViolation{Offset: int64(i)})
+	}
+	close(out)
+	require.Equal(t, 2, target.reported)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// zstdInflater decompresses values that producers compressed with zstd
+// themselves, usually with a shared dictionary, for -zstd-dict. Data that
+// isn't a zstd frame is passed through. Like sessionStats, a nil
+// *zstdInflater is valid and passes everything through.
+type zstdInflater struct {
+	decoder *zstd.Decoder
+}
+
+// newZstdInflater loads the comma separated dictionary files in paths,
+// e.g. as trained by zstd --train. Frames pick their dictionary by ID. It
+// returns nil if paths is empty.
+func newZstdInflater(paths string) (*zstdInflater, error) {
+	if paths == "" {
+		return nil, nil
+	}
+
+	dicts := [][]byte{}
+	for _, p := range strings.Split(paths, ",") {
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd dictionary err=%v", err)
+		}
+		dicts = append(dicts, buf)
+	}
+
+	d, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dicts...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load zstd dictionaries %v err=%v", paths, err)
+	}
+	return &zstdInflater{decoder: d}, nil
+}
+
+// inflate returns the decompressed data if data is a zstd frame.
+func (z *zstdInflater) inflate(data []byte) ([]byte, error) {
+	if z == nil || !bytes.HasPrefix(data, zstdMagic) {
+		return data, nil
+	}
+	return z.decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZstdInflater(t *testing.T) {
+	var z *zstdInflater
+	actual, err := z.inflate([]byte("plain"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("plain"), actual)
+
+	d, err := zstd.NewReader(nil)
+	require.Nil(t, err)
+	z = &zstdInflater{decoder: d}
+
+	e, err := zstd.NewWriter(nil)
+	require.Nil(t, err)
+	compressed := e.EncodeAll([]byte(`{"id": 23}`), nil)
+
+	actual, err = z.inflate(compressed)
+	require.Nil(t, err)
+	require.Equal(t, []byte(`{"id": 23}`), actual)
+
+	actual, err = z.inflate([]byte("plain"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("plain"), actual)
+
+	_, err = z.inflate(append(append([]byte{}, zstdMagic...), 1, 2, 3))
+	require.NotNil(t, err)
+
+	cmd := &consumeCmd{zstd: z}
+	msg := &sarama.ConsumerMessage{Offset: 7, Value: compressed}
+	inflated := cmd.inflateValue(msg)
+	require.Equal(t, []byte(`{"id": 23}`), inflated.Value)
+	require.Equal(t, int64(7), inflated.Offset)
+	require.Equal(t, compressed, msg.Value)
+}
+
+func TestNewZstdInflater(t *testing.T) {
+	actual, err := newZstdInflater("")
+	require.Nil(t, err)
+	require.Nil(t, actual)
+
+	_, err = newZstdInflater(filepath.Join(t.TempDir(), "missing"))
+	require.NotNil(t, err)
+
+	invalid := filepath.Join(t.TempDir(), "dict")
+	require.Nil(t, ioutil.WriteFile(invalid, []byte("not a dictionary"), 0644))
+	_, err = newZstdInflater(invalid)
+	require.NotNil(t, err)
+}